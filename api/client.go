@@ -17,24 +17,82 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"mime"
+	"net"
 	"net/http"
 	"net/url"
 	"runtime"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/format"
+	"github.com/ollama/ollama/npipe"
 	"github.com/ollama/ollama/version"
 )
 
 // Client encapsulates client state for interacting with the ollama
-// service. Use [ClientFromEnvironment] to create new Clients.
+// service. Use [ClientFromEnvironment] to create new Clients, or
+// [NewClientWithOptions] to tune their connections.
 type Client struct {
 	base *url.URL
 	http *http.Client
+
+	// transport is the transport of http before any middleware was added
+	transport  http.RoundTripper
+	middleware []Middleware
+
+	// timeout is [ClientOptions.Timeout]
+	timeout time.Duration
+}
+
+// Middleware wraps the transport of a [Client] to inspect or change its
+// requests and responses, such as to add authentication headers, log
+// requests or record metrics. It returns a transport that calls next to send
+// the request.
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc adapts a function to an [http.RoundTripper] for use in
+// [Middleware].
+type RoundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f RoundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// Use adds middleware to the requests of c. Middleware added first sees
+// requests first and responses last. Use must not be called concurrently
+// with requests. The [http.Client] that c was created with is not changed.
+func (c *Client) Use(mw ...Middleware) {
+	if c.middleware == nil {
+		if c.http == nil {
+			c.http = http.DefaultClient
+		}
+
+		c.transport = c.http.Transport
+		if c.transport == nil {
+			c.transport = http.DefaultTransport
+		}
+	}
+
+	c.middleware = append(c.middleware, mw...)
+
+	next := c.transport
+	for i := len(c.middleware) - 1; i >= 0; i-- {
+		next = c.middleware[i](next)
+	}
+
+	hc := *c.http
+	hc.Transport = next
+	c.http = &hc
 }
 
 func checkError(resp *http.Response, body []byte) error {
@@ -61,11 +119,52 @@ func checkError(resp *http.Response, body []byte) error {
 //	<scheme>://<host>:<port>
 //
 // If the variable is not specified, a default ollama host and port will be
-// used.
+// used. A unix socket may be specified with unix://<path>, and a Windows named
+// pipe with npipe:////./pipe/<name>. If the variable lists several comma
+// separated addresses, the first one is used.
 func ClientFromEnvironment() (*Client, error) {
+	base := envconfig.Host()
+
+	var transport *http.Transport
+	switch base.Scheme {
+	case "unix":
+		socket := base.Path
+		base = &url.URL{Scheme: "http", Host: "localhost"}
+		transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+	case "npipe":
+		pipe := base.Path
+		base = &url.URL{Scheme: "http", Host: "localhost"}
+		transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return npipe.Dial(ctx, pipe)
+			},
+		}
+	}
+
+	// https hosts negotiate HTTP/2 automatically; cleartext HTTP/2 needs
+	// prior knowledge that the server supports it
+	if envconfig.H2C() && base.Scheme == "http" {
+		if transport == nil {
+			transport = http.DefaultTransport.(*http.Transport).Clone()
+		}
+
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+
+	client := http.DefaultClient
+	if transport != nil {
+		client = &http.Client{Transport: transport}
+	}
+
 	return &Client{
-		base: envconfig.Host(),
-		http: http.DefaultClient,
+		base: base,
+		http: client,
 	}, nil
 }
 
@@ -76,6 +175,70 @@ func NewClient(base *url.URL, http *http.Client) *Client {
 	}
 }
 
+// ClientOptions tunes the connections of a [Client] created with
+// [NewClientWithOptions]. The zero value uses the settings of
+// [http.DefaultTransport].
+type ClientOptions struct {
+	// MaxIdleConns limits the idle connections kept open for reuse, and
+	// MaxIdleConnsPerHost those to the server. Clients sending many
+	// concurrent requests should raise MaxIdleConnsPerHost, which is 2 by
+	// default, to avoid opening a connection for most requests.
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+
+	// MaxConnsPerHost limits the connections to the server, including those
+	// in use. Requests wait for a connection once it is reached. Zero means
+	// no limit.
+	MaxConnsPerHost int
+
+	// IdleConnTimeout is how long an idle connection is kept open.
+	IdleConnTimeout time.Duration
+
+	// TLSConfig configures connections to https servers.
+	TLSConfig *tls.Config
+
+	// HTTP2 sends requests to http servers over HTTP/2 without TLS, which
+	// the server must support, as with OLLAMA_H2C. Requests to https servers
+	// use HTTP/2 if the server supports it regardless.
+	HTTP2 bool
+
+	// Timeout limits how long the client waits for the server: for the
+	// whole response of requests that aren't streamed, and for each chunk of
+	// streamed responses, so that long generations aren't cut off. Zero
+	// means no limit besides the context of the request.
+	Timeout time.Duration
+}
+
+// NewClientWithOptions creates a [Client] for the server at base with its own
+// transport tuned by opts, rather than sharing [http.DefaultClient].
+func NewClientWithOptions(base *url.URL, opts ClientOptions) *Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if opts.MaxIdleConns > 0 {
+		transport.MaxIdleConns = opts.MaxIdleConns
+	}
+	if opts.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = opts.MaxIdleConnsPerHost
+	}
+	if opts.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = opts.IdleConnTimeout
+	}
+	transport.MaxConnsPerHost = opts.MaxConnsPerHost
+	if opts.TLSConfig != nil {
+		transport.TLSClientConfig = opts.TLSConfig
+	}
+
+	if opts.HTTP2 && base.Scheme == "http" {
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+
+	return &Client{
+		base:    base,
+		http:    &http.Client{Transport: transport},
+		timeout: opts.Timeout,
+	}
+}
+
 func (c *Client) do(ctx context.Context, method, path string, reqData, respData any) error {
 	var reqBody io.Reader
 	var data []byte
@@ -96,7 +259,15 @@ func (c *Client) do(ctx context.Context, method, path string, reqData, respData
 		reqBody = bytes.NewReader(data)
 	}
 
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	path, query, _ := strings.Cut(path, "?")
 	requestURL := c.base.JoinPath(path)
+	requestURL.RawQuery = query
 	request, err := http.NewRequestWithContext(ctx, method, requestURL.String(), reqBody)
 	if err != nil {
 		return err
@@ -142,6 +313,21 @@ func (c *Client) stream(ctx context.Context, method, path string, data any, fn f
 		buf = bytes.NewBuffer(bts)
 	}
 
+	// the timeout restarts with each chunk of the response
+	var wait func()
+	if c.timeout > 0 {
+		var cancel context.CancelCauseFunc
+		ctx, cancel = context.WithCancelCause(ctx)
+		defer cancel(nil)
+
+		timer := time.AfterFunc(c.timeout, func() {
+			cancel(fmt.Errorf("no response from the server after %s: %w", c.timeout, context.DeadlineExceeded))
+		})
+		defer timer.Stop()
+
+		wait = func() { timer.Reset(c.timeout) }
+	}
+
 	requestURL := c.base.JoinPath(path)
 	request, err := http.NewRequestWithContext(ctx, method, requestURL.String(), buf)
 	if err != nil {
@@ -151,9 +337,18 @@ func (c *Client) stream(ctx context.Context, method, path string, data any, fn f
 	request.Header.Set("Content-Type", "application/json")
 	request.Header.Set("Accept", "application/x-ndjson")
 	request.Header.Set("User-Agent", fmt.Sprintf("ollama/%s (%s %s) Go/%s", version.Version, runtime.GOARCH, runtime.GOOS, runtime.Version()))
+	switch r := data.(type) {
+	case *GenerateRequest:
+		setRequestID(request, r.RequestID)
+	case *ChatRequest:
+		setRequestID(request, r.RequestID)
+	}
 
 	response, err := c.http.Do(request)
 	if err != nil {
+		if cause := context.Cause(ctx); wait != nil && errors.Is(cause, context.DeadlineExceeded) {
+			return cause
+		}
 		return err
 	}
 	defer response.Body.Close()
@@ -162,36 +357,147 @@ func (c *Client) stream(ctx context.Context, method, path string, data any, fn f
 	// increase the buffer size to avoid running out of space
 	scanBuf := make([]byte, 0, maxBufferSize)
 	scanner.Buffer(scanBuf, maxBufferSize)
-	for scanner.Scan() {
+
+	chunk := func(bts []byte) error {
+		if wait != nil {
+			wait()
+		}
+
 		var errorResponse struct {
-			Error string `json:"error,omitempty"`
+			ErrorResponse
+			DoneReason string         `json:"done_reason,omitempty"`
+			Summary    *StreamSummary `json:"summary,omitempty"`
 		}
 
-		bts := scanner.Bytes()
 		if err := json.Unmarshal(bts, &errorResponse); err != nil {
 			return fmt.Errorf("unmarshal: %w", err)
 		}
 
-		if errorResponse.Error != "" {
-			return errors.New(errorResponse.Error)
-		}
-
 		if response.StatusCode >= http.StatusBadRequest {
 			return StatusError{
 				StatusCode:   response.StatusCode,
 				Status:       response.Status,
 				ErrorMessage: errorResponse.Error,
+				Code:         errorResponse.Code,
+				Fields:       errorResponse.Fields,
 			}
 		}
 
-		if err := fn(bts); err != nil {
+		if errorResponse.Error != "" {
+			err := errors.New(errorResponse.Error)
+			if errorResponse.DoneReason == DoneReasonCanceled {
+				err = ErrCanceled
+			} else if errorResponse.Code != "" {
+				// errors in the middle of a stream keep their code to be
+				// recognized like those that fail the request
+				err = StatusError{ErrorMessage: errorResponse.Error, Code: errorResponse.Code}
+			}
+
+			if errorResponse.Summary != nil {
+				return StreamError{Err: err, Summary: errorResponse.Summary}
+			}
+
 			return err
 		}
+
+		return fn(bts)
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type")); mediaType == "text/event-stream" {
+		err = scanEvents(scanner, chunk)
+	} else {
+		for scanner.Scan() {
+			if err = chunk(scanner.Bytes()); err != nil {
+				break
+			}
+		}
+	}
+
+	// the scanner stops without an error when the timeout closes the body
+	if cause := context.Cause(ctx); wait != nil && err == nil && errors.Is(cause, context.DeadlineExceeded) {
+		return cause
+	}
+
+	return err
+}
+
+// scanEvents calls fn with the data of each server-sent event read by
+// scanner, for requests that asked for events with their Accept header.
+func scanEvents(scanner *bufio.Scanner, fn func([]byte) error) error {
+	var data []byte
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			// a blank line ends an event
+			if len(data) > 0 {
+				if err := fn(data); err != nil {
+					return err
+				}
+			}
+
+			data = data[:0]
+			continue
+		}
+
+		if field, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+			if len(data) > 0 {
+				data = append(data, '\n')
+			}
+			data = append(data, bytes.TrimPrefix(field, []byte(" "))...)
+		}
+	}
+
+	if len(data) > 0 {
+		return fn(data)
 	}
 
 	return nil
 }
 
+// StreamError is returned by [Client.Generate] and [Client.Chat] when a
+// stream ends with an error, with the summary of the response until then,
+// such as the tokens that were processed before it failed.
+type StreamError struct {
+	Err     error
+	Summary *StreamSummary
+}
+
+func (e StreamError) Error() string {
+	return e.Err.Error()
+}
+
+func (e StreamError) Unwrap() error {
+	return e.Err
+}
+
+// ErrCanceled is returned by [Client.Generate] and [Client.Chat] when the
+// server stopped the request before it completed, such as after a call to
+// [Client.Cancel].
+var ErrCanceled = errors.New("request canceled")
+
+// NewRequestID returns a random ID for the RequestID field of
+// [GenerateRequest] and [ChatRequest].
+func NewRequestID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func setRequestID(request *http.Request, id string) {
+	if id != "" {
+		request.Header.Set("X-Request-ID", id)
+	}
+}
+
+// Cancel stops the generate or chat request with the given ID, which was set
+// in its RequestID field, and the generation it started on the server. The
+// stream of the request ends with [ErrCanceled]. Closing the connection of a
+// request stops it too, but Cancel also works through proxies that keep
+// the connection to the server open, and from other processes.
+func (c *Client) Cancel(ctx context.Context, requestID string) error {
+	return c.do(ctx, http.MethodPost, "/api/cancel", &CancelRequest{RequestID: requestID}, nil)
+}
+
 // GenerateResponseFunc is a function that [Client.Generate] invokes every time
 // a response is received from the service. If this function returns an error,
 // [Client.Generate] will stop generating and return this error.
@@ -207,6 +513,11 @@ func (c *Client) Generate(ctx context.Context, req *GenerateRequest, fn Generate
 			return err
 		}
 
+		// servers that predate stream summaries only report these fields directly
+		if resp.Done && resp.StreamSummary == nil {
+			resp.StreamSummary = NewStreamSummary(resp.DoneReason, resp.Metrics)
+		}
+
 		return fn(resp)
 	})
 }
@@ -227,6 +538,11 @@ func (c *Client) Chat(ctx context.Context, req *ChatRequest, fn ChatResponseFunc
 			return err
 		}
 
+		// servers that predate stream summaries only report these fields directly
+		if resp.Done && resp.StreamSummary == nil {
+			resp.StreamSummary = NewStreamSummary(resp.DoneReason, resp.Metrics)
+		}
+
 		return fn(resp)
 	})
 }
@@ -250,6 +566,225 @@ func (c *Client) Pull(ctx context.Context, req *PullRequest, fn PullProgressFunc
 	})
 }
 
+// PullPreview describes the layers that pulling the model of req would
+// download and the disk space they need, without downloading them.
+func (c *Client) PullPreview(ctx context.Context, req *PullRequest) (*PullPreviewResponse, error) {
+	var resp PullPreviewResponse
+	if err := c.do(ctx, http.MethodPost, "/api/pull/preview", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// PullHandle controls a pull started by [Client.StartPull] from other
+// goroutines, such as those handling the buttons of a download manager.
+type PullHandle struct {
+	stop context.CancelFunc
+	done chan struct{}
+	err  error
+
+	mu sync.Mutex
+	// paused is set between Pause and Resume, which closes resumed
+	paused  bool
+	resumed chan struct{}
+	// cancelAttempt stops the request to the server that is in progress
+	cancelAttempt context.CancelFunc
+}
+
+// StartPull pulls a model like [Client.Pull] in the background and returns a
+// handle to pause, resume or cancel it. fn is called from the goroutine of
+// the pull. Use [PullHandle.Wait] for the result.
+func (c *Client) StartPull(ctx context.Context, req *PullRequest, fn PullProgressFunc) *PullHandle {
+	ctx, stop := context.WithCancel(ctx)
+	h := &PullHandle{stop: stop, done: make(chan struct{})}
+	go func() {
+		defer close(h.done)
+		defer stop()
+		h.err = h.run(ctx, c, req, fn)
+	}()
+
+	return h
+}
+
+func (h *PullHandle) run(ctx context.Context, c *Client, req *PullRequest, fn PullProgressFunc) error {
+	for {
+		if err := h.waitResumed(ctx); err != nil {
+			return err
+		}
+
+		attempt := h.attempt(ctx)
+		err := c.Pull(attempt, req, fn)
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case attempt.Err() != nil:
+			// the server keeps what it downloaded of each layer, so the
+			// pull continues from there once it is resumed
+			continue
+		default:
+			return err
+		}
+	}
+}
+
+// attempt returns the context of a request to the server, which Pause
+// cancels.
+func (h *PullHandle) attempt(ctx context.Context) context.Context {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ctx, h.cancelAttempt = context.WithCancel(ctx)
+	if h.paused {
+		h.cancelAttempt()
+	}
+
+	return ctx
+}
+
+func (h *PullHandle) waitResumed(ctx context.Context) error {
+	h.mu.Lock()
+	paused, resumed := h.paused, h.resumed
+	h.mu.Unlock()
+
+	if !paused {
+		return nil
+	}
+
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Pause stops transferring the model until [PullHandle.Resume] is called.
+// Layers that were partly downloaded are kept on the server.
+func (h *PullHandle) Pause() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.paused {
+		return
+	}
+
+	h.paused = true
+	h.resumed = make(chan struct{})
+	if h.cancelAttempt != nil {
+		h.cancelAttempt()
+	}
+}
+
+// Resume continues a paused pull where it stopped.
+func (h *PullHandle) Resume() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.paused {
+		return
+	}
+
+	h.paused = false
+	close(h.resumed)
+}
+
+// Paused reports whether the pull is paused.
+func (h *PullHandle) Paused() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.paused
+}
+
+// Cancel stops the pull, which makes [PullHandle.Wait] return
+// [context.Canceled].
+func (h *PullHandle) Cancel() {
+	h.stop()
+}
+
+// Done returns a channel that is closed once the pull has finished.
+func (h *PullHandle) Done() <-chan struct{} {
+	return h.done
+}
+
+// Wait waits for the pull to finish and returns its error.
+func (h *PullHandle) Wait() error {
+	<-h.done
+	return h.err
+}
+
+// WarmProgressFunc is a function that [Client.Warm] invokes when progress is
+// made.
+// It's similar to other progress function types like [PullProgressFunc].
+type WarmProgressFunc func(ProgressResponse) error
+
+// Warm reads a model's files into memory ahead of its first use so loading
+// it does not wait on disk. fn is called each time progress is made on the
+// request and can be used to display a progress bar, etc.
+func (c *Client) Warm(ctx context.Context, req *WarmRequest, fn WarmProgressFunc) error {
+	return c.stream(ctx, http.MethodPost, "/api/warm", req, func(bts []byte) error {
+		var resp ProgressResponse
+		if err := json.Unmarshal(bts, &resp); err != nil {
+			return err
+		}
+
+		return fn(resp)
+	})
+}
+
+// VerifyProgressFunc is a function that [Client.Verify] invokes when progress
+// is made.
+// It's similar to other progress function types like [PullProgressFunc].
+type VerifyProgressFunc func(ProgressResponse) error
+
+// Verify checks a local model's files against its manifest and reports the
+// first blob that is corrupt as an error. fn is called each time progress is
+// made on the request and can be used to display a progress bar, etc.
+func (c *Client) Verify(ctx context.Context, req *VerifyRequest, fn VerifyProgressFunc) error {
+	return c.stream(ctx, http.MethodPost, "/api/verify", req, func(bts []byte) error {
+		var resp ProgressResponse
+		if err := json.Unmarshal(bts, &resp); err != nil {
+			return err
+		}
+
+		return fn(resp)
+	})
+}
+
+// EvalProgressFunc is a function that [Client.Eval] invokes when progress is
+// made and with the final report.
+type EvalProgressFunc func(EvalResponse) error
+
+// Eval runs an evaluation task against a local model. fn is called each time
+// progress is made on the request and with the report once it completes.
+func (c *Client) Eval(ctx context.Context, req *EvalRequest, fn EvalProgressFunc) error {
+	return c.stream(ctx, http.MethodPost, "/api/eval", req, func(bts []byte) error {
+		var resp EvalResponse
+		if err := json.Unmarshal(bts, &resp); err != nil {
+			return err
+		}
+
+		return fn(resp)
+	})
+}
+
+// FinetuneProgressFunc is a function that [Client.Finetune] invokes when
+// progress is made.
+type FinetuneProgressFunc func(FinetuneResponse) error
+
+// Finetune trains a LoRA adapter for a local model and creates a new model
+// from the model and the adapter. This is experimental and requires the
+// Ollama engine. fn is called with the loss as training progresses.
+func (c *Client) Finetune(ctx context.Context, req *FinetuneRequest, fn FinetuneProgressFunc) error {
+	return c.stream(ctx, http.MethodPost, "/api/finetune", req, func(bts []byte) error {
+		var resp FinetuneResponse
+		if err := json.Unmarshal(bts, &resp); err != nil {
+			return err
+		}
+
+		return fn(resp)
+	})
+}
+
 // PushProgressFunc is a function that [Client.Push] invokes when progress is
 // made.
 // It's similar to other progress function types like [PullProgressFunc].
@@ -298,6 +833,164 @@ func (c *Client) List(ctx context.Context) (*ListResponse, error) {
 	return &lr, nil
 }
 
+// ListWithOptions lists the local models matching the filters of req, sorted
+// and paginated as it requests.
+func (c *Client) ListWithOptions(ctx context.Context, req *ListRequest) (*ListResponse, error) {
+	var lr ListResponse
+	if err := c.do(ctx, http.MethodGet, "/api/tags?"+req.Query().Encode(), nil, &lr); err != nil {
+		return nil, err
+	}
+	return &lr, nil
+}
+
+// SearchModels lists the local models matching the metadata of req.
+func (c *Client) SearchModels(ctx context.Context, req *SearchModelsRequest) (*SearchModelsResponse, error) {
+	var resp SearchModelsResponse
+	if err := c.do(ctx, http.MethodPost, "/api/models/search", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Search finds models in a registry matching req, with the sizes and
+// quantizations of their tags.
+func (c *Client) Search(ctx context.Context, req *SearchRequest) (*SearchResponse, error) {
+	var resp SearchResponse
+	if err := c.do(ctx, http.MethodPost, "/api/search", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Updates lists the update policies and statuses of local models.
+func (c *Client) Updates(ctx context.Context) (*UpdatesResponse, error) {
+	var resp UpdatesResponse
+	if err := c.do(ctx, http.MethodGet, "/api/updates", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// SetUpdatePolicy sets the update policy of a local model.
+func (c *Client) SetUpdatePolicy(ctx context.Context, req *UpdatePolicyRequest) (*ModelUpdate, error) {
+	var resp ModelUpdate
+	if err := c.do(ctx, http.MethodPost, "/api/updates", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Canaries lists the models whose requests are split with a canary, with
+// the metrics of each variant.
+func (c *Client) Canaries(ctx context.Context) (*CanariesResponse, error) {
+	var resp CanariesResponse
+	if err := c.do(ctx, http.MethodGet, "/api/canaries", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// StartCanary sends a share of the requests to a model to a canary model,
+// or changes the share of a canary that has started.
+func (c *Client) StartCanary(ctx context.Context, req *CanaryRequest) (*Canary, error) {
+	var resp Canary
+	if err := c.do(ctx, http.MethodPost, "/api/canaries", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// PromoteCanary replaces a model with its canary and ends the canary.
+func (c *Client) PromoteCanary(ctx context.Context, req *CanaryEndRequest) error {
+	return c.do(ctx, http.MethodPost, "/api/canaries/promote", req, nil)
+}
+
+// RollbackCanary ends the canary of a model, which keeps its stable
+// version.
+func (c *Client) RollbackCanary(ctx context.Context, req *CanaryEndRequest) error {
+	return c.do(ctx, http.MethodPost, "/api/canaries/rollback", req, nil)
+}
+
+// CreateSession creates a chat session whose messages are kept by the
+// server. Pass its ID in [ChatRequest] to chat in it.
+func (c *Client) CreateSession(ctx context.Context, req *SessionRequest) (*Session, error) {
+	var resp Session
+	if err := c.do(ctx, http.MethodPost, "/api/sessions", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Sessions lists the chat sessions, without their messages.
+func (c *Client) Sessions(ctx context.Context) (*SessionsResponse, error) {
+	var resp SessionsResponse
+	if err := c.do(ctx, http.MethodGet, "/api/sessions", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Session returns a chat session with its messages.
+func (c *Client) Session(ctx context.Context, id string) (*Session, error) {
+	var resp Session
+	if err := c.do(ctx, http.MethodGet, "/api/sessions/"+url.PathEscape(id), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeleteSession deletes a chat session.
+func (c *Client) DeleteSession(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/api/sessions/"+url.PathEscape(id), nil, nil)
+}
+
+// Cache lists the prompts with cache keys that the loaded models keep in
+// their caches.
+func (c *Client) Cache(ctx context.Context) (*CacheResponse, error) {
+	var resp CacheResponse
+	if err := c.do(ctx, http.MethodGet, "/api/cache", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// PinCache keeps the prompts with a cache key in the cache of a loaded model
+// until they are unpinned or evicted, or the model is unloaded.
+func (c *Client) PinCache(ctx context.Context, req *CacheRequest) error {
+	return c.do(ctx, http.MethodPost, "/api/cache/pin", req, nil)
+}
+
+// UnpinCache allows the prompts with a cache key to be evicted for other
+// prompts again.
+func (c *Client) UnpinCache(ctx context.Context, req *CacheRequest) error {
+	return c.do(ctx, http.MethodPost, "/api/cache/unpin", req, nil)
+}
+
+// EvictCache removes the prompts with a cache key from the cache of a loaded
+// model.
+func (c *Client) EvictCache(ctx context.Context, req *CacheRequest) error {
+	return c.do(ctx, http.MethodDelete, "/api/cache", req, nil)
+}
+
+// LogLevels returns the log levels of the server.
+func (c *Client) LogLevels(ctx context.Context) (*LogLevels, error) {
+	var resp LogLevels
+	if err := c.do(ctx, http.MethodGet, "/api/loglevel", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// SetLogLevels changes the log levels of the server and returns the levels
+// in effect afterwards. Runners that are already loaded keep their levels.
+func (c *Client) SetLogLevels(ctx context.Context, req *LogLevels) (*LogLevels, error) {
+	var resp LogLevels
+	if err := c.do(ctx, http.MethodPost, "/api/loglevel", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 // ListRunning lists running models.
 func (c *Client) ListRunning(ctx context.Context) (*ProcessResponse, error) {
 	var lr ProcessResponse
@@ -351,6 +1044,56 @@ func (c *Client) Embed(ctx context.Context, req *EmbedRequest) (*EmbedResponse,
 	return &resp, nil
 }
 
+// EmbedBatch generates embeddings for many inputs in as few passes of the
+// model as possible. Inputs that fail have an error in their result instead
+// of failing the request.
+func (c *Client) EmbedBatch(ctx context.Context, req *EmbedBatchRequest) (*EmbedBatchResponse, error) {
+	var resp EmbedBatchResponse
+	if err := c.do(ctx, http.MethodPost, "/api/embed/batch", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Classify classifies one or more inputs with a model that has a sequence
+// classification head and returns the score of each label.
+func (c *Client) Classify(ctx context.Context, req *ClassifyRequest) (*ClassifyResponse, error) {
+	var resp ClassifyResponse
+	if err := c.do(ctx, http.MethodPost, "/api/classify", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Similarity compares vectors, or texts embedded with a model, and returns
+// the similarity of each pair.
+func (c *Client) Similarity(ctx context.Context, req *SimilarityRequest) (*SimilarityResponse, error) {
+	var resp SimilarityResponse
+	if err := c.do(ctx, http.MethodPost, "/api/similarity", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Cluster groups vectors into clusters with k-means.
+func (c *Client) Cluster(ctx context.Context, req *ClusterRequest) (*ClusterResponse, error) {
+	var resp ClusterResponse
+	if err := c.do(ctx, http.MethodPost, "/api/cluster", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Perplexity scores a text under a model and returns the negative log
+// likelihood of each of its tokens and the model's perplexity on it.
+func (c *Client) Perplexity(ctx context.Context, req *PerplexityRequest) (*PerplexityResponse, error) {
+	var resp PerplexityResponse
+	if err := c.do(ctx, http.MethodPost, "/api/perplexity", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 // Embeddings generates an embedding from a model.
 func (c *Client) Embeddings(ctx context.Context, req *EmbeddingRequest) (*EmbeddingResponse, error) {
 	var resp EmbeddingResponse
@@ -3,12 +3,15 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestClientFromEnvironment(t *testing.T) {
@@ -33,6 +36,7 @@ func TestClientFromEnvironment(t *testing.T) {
 		"scheme, hostname, and port": {value: "https://example.com:1234", expect: "https://example.com:1234"},
 		"trailing slash":             {value: "example.com/", expect: "http://example.com:11434"},
 		"trailing slash port":        {value: "example.com:1234/", expect: "http://example.com:1234"},
+		"path prefix":                {value: "https://example.com/ollama", expect: "https://example.com:443/ollama"},
 	}
 
 	for k, v := range testCases {
@@ -51,6 +55,135 @@ func TestClientFromEnvironment(t *testing.T) {
 	}
 }
 
+func TestClientBasePath(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ollama/api/version" {
+			http.NotFound(w, r)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{"version": "1.2.3"})
+	}))
+	defer ts.Close()
+
+	t.Setenv("OLLAMA_HOST", ts.URL+"/ollama/")
+
+	client, err := ClientFromEnvironment()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := client.Version(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if version != "1.2.3" {
+		t.Fatalf("expected version 1.2.3, got %s", version)
+	}
+}
+
+func TestClientH2C(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"version": r.Proto})
+	}))
+	ts.Config.Protocols = new(http.Protocols)
+	ts.Config.Protocols.SetHTTP1(true)
+	ts.Config.Protocols.SetUnencryptedHTTP2(true)
+	ts.Start()
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		h2c    string
+		expect string
+	}{
+		{"", "HTTP/1.1"},
+		{"1", "HTTP/2.0"},
+	} {
+		t.Run(tt.expect, func(t *testing.T) {
+			t.Setenv("OLLAMA_HOST", ts.URL)
+			t.Setenv("OLLAMA_H2C", tt.h2c)
+
+			client, err := ClientFromEnvironment()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			proto, err := client.Version(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if proto != tt.expect {
+				t.Fatalf("expected %s, got %s", tt.expect, proto)
+			}
+		})
+	}
+}
+
+func TestClientStreamSummary(t *testing.T) {
+	cases := map[string]struct {
+		final  string
+		expect *StreamSummary
+	}{
+		"server summary": {
+			final: `{"done":true,"done_reason":"stop","prompt_eval_count":3,"eval_count":5,"summary":{"done_reason":"stop","usage":{"prompt_tokens":3,"completion_tokens":5,"total_tokens":8},"warnings":["truncated"]}}`,
+			expect: &StreamSummary{
+				DoneReason: "stop",
+				Usage:      Usage{PromptTokens: 3, CompletionTokens: 5, TotalTokens: 8},
+				Warnings:   []string{"truncated"},
+			},
+		},
+		"older server": {
+			final: `{"done":true,"done_reason":"length","prompt_eval_count":3,"eval_count":5}`,
+			expect: &StreamSummary{
+				DoneReason: "length",
+				Usage:      Usage{PromptTokens: 3, CompletionTokens: 5, TotalTokens: 8},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/x-ndjson")
+				fmt.Fprintln(w, `{"message":{"role":"assistant","content":"hi"},"done":false}`)
+				fmt.Fprintln(w, tc.final)
+			}))
+			defer ts.Close()
+
+			client := NewClient(&url.URL{Scheme: "http", Host: ts.Listener.Addr().String()}, http.DefaultClient)
+
+			var summary *StreamSummary
+			var chunks int
+			err := client.Chat(context.Background(), &ChatRequest{}, func(resp ChatResponse) error {
+				chunks++
+				if !resp.Done && resp.StreamSummary != nil {
+					t.Errorf("unexpected summary before the final response")
+				}
+
+				summary = resp.StreamSummary
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if chunks != 2 {
+				t.Fatalf("expected 2 chunks, got %d", chunks)
+			}
+
+			if summary == nil {
+				t.Fatal("expected summary")
+			}
+
+			if summary.DoneReason != tc.expect.DoneReason || summary.Usage != tc.expect.Usage || strings.Join(summary.Warnings, ",") != strings.Join(tc.expect.Warnings, ",") {
+				t.Errorf("expected %+v, got %+v", tc.expect, summary)
+			}
+		})
+	}
+}
+
 // testError represents an internal error type with status code and message
 // this is used since the error response from the server is not a standard error struct
 type testError struct {
@@ -78,6 +211,15 @@ func TestClientStream(t *testing.T) {
 			},
 			wantErr: "test error message",
 		},
+		{
+			name: "error response without a message",
+			responses: []any{
+				testError{
+					statusCode: http.StatusBadGateway,
+				},
+			},
+			wantErr: "502 Bad Gateway",
+		},
 		{
 			name: "error after successful chunks, ok response",
 			responses: []any{
@@ -253,3 +395,385 @@ func TestClientDo(t *testing.T) {
 		})
 	}
 }
+
+func TestClientCancel(t *testing.T) {
+	canceled := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/chat":
+			if id := r.Header.Get("X-Request-ID"); id != "chat-1" {
+				t.Errorf("expected request id chat-1, got %q", id)
+			}
+
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			fmt.Fprintln(w, `{"message":{"role":"assistant","content":"hi"},"done":false}`)
+			w.(http.Flusher).Flush()
+
+			fmt.Fprintf(w, `{"error":"context canceled","done_reason":"canceled","request_id":%q}`+"\n", <-canceled)
+		case "/api/cancel":
+			var req CancelRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Error(err)
+			}
+
+			canceled <- req.RequestID
+		}
+	}))
+	defer ts.Close()
+
+	client := NewClient(&url.URL{Scheme: "http", Host: ts.Listener.Addr().String()}, http.DefaultClient)
+
+	req := &ChatRequest{Model: "test", RequestID: "chat-1"}
+	err := client.Chat(context.Background(), req, func(resp ChatResponse) error {
+		return client.Cancel(context.Background(), req.RequestID)
+	})
+	if !errors.Is(err, ErrCanceled) {
+		t.Errorf("expected ErrCanceled, got %v", err)
+	}
+}
+
+func TestNewRequestID(t *testing.T) {
+	a, b := NewRequestID(), NewRequestID()
+	if len(a) != 16 || a == b {
+		t.Errorf("expected distinct random ids, got %q and %q", a, b)
+	}
+}
+
+func TestStartPull(t *testing.T) {
+	var calls atomic.Int32
+	disconnected := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		if calls.Add(1) == 1 {
+			fmt.Fprintln(w, `{"status":"pulling abc","digest":"abc","total":100,"completed":10}`)
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			close(disconnected)
+			return
+		}
+
+		fmt.Fprintln(w, `{"status":"success"}`)
+	}))
+	defer ts.Close()
+
+	client := NewClient(&url.URL{Scheme: "http", Host: ts.Listener.Addr().String()}, http.DefaultClient)
+
+	t.Run("pause and resume", func(t *testing.T) {
+		progress := make(chan ProgressResponse, 2)
+		h := client.StartPull(context.Background(), &PullRequest{Model: "test"}, func(resp ProgressResponse) error {
+			progress <- resp
+			return nil
+		})
+
+		if resp := <-progress; resp.Digest != "abc" {
+			t.Fatalf("expected progress of layer abc, got %+v", resp)
+		}
+
+		h.Pause()
+		<-disconnected
+		if !h.Paused() {
+			t.Fatal("expected pull to be paused")
+		}
+
+		h.Resume()
+		if err := h.Wait(); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp := <-progress; resp.Status != "success" {
+			t.Errorf("expected success, got %+v", resp)
+		}
+
+		if n := calls.Load(); n != 2 {
+			t.Errorf("expected 2 pull requests, got %d", n)
+		}
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		h := client.StartPull(context.Background(), &PullRequest{Model: "test"}, func(ProgressResponse) error { return nil })
+		h.Pause()
+		h.Cancel()
+
+		if err := h.Wait(); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	})
+}
+
+func TestClientTypedErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/show", "/api/chat":
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: `model "test" not found, try pulling it first`, Code: ErrorCodeModelNotFound}) //nolint:errcheck
+		case "/api/generate":
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "server busy", Code: ErrorCodeServerOverloaded}) //nolint:errcheck
+		}
+	}))
+	defer ts.Close()
+
+	client := NewClient(&url.URL{Scheme: "http", Host: ts.Listener.Addr().String()}, http.DefaultClient)
+
+	_, err := client.Show(context.Background(), &ShowRequest{Model: "test"})
+	if !errors.Is(err, ErrModelNotFound) {
+		t.Errorf("show: expected ErrModelNotFound, got %v", err)
+	}
+
+	err = client.Chat(context.Background(), &ChatRequest{Model: "test"}, func(ChatResponse) error { return nil })
+	var se StatusError
+	if !errors.Is(err, ErrModelNotFound) || !errors.As(err, &se) || se.StatusCode != http.StatusNotFound || se.Code != ErrorCodeModelNotFound {
+		t.Errorf("chat: expected ErrModelNotFound with status 404, got %#v", err)
+	}
+
+	if se.Error() != `404 Not Found: model "test" not found, try pulling it first` {
+		t.Errorf("chat: unexpected message %q", se.Error())
+	}
+
+	err = client.Generate(context.Background(), &GenerateRequest{Model: "test"}, func(GenerateResponse) error { return nil })
+	if !errors.Is(err, ErrServerOverloaded) || errors.Is(err, ErrModelNotFound) {
+		t.Errorf("generate: expected ErrServerOverloaded, got %v", err)
+	}
+}
+
+func TestClientStreamTypedError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(GenerateResponse{Response: "hello"})                                                               //nolint:errcheck
+		json.NewEncoder(w).Encode(map[string]string{"error": "out of memory", "code": ErrorCodeOutOfMemory, "done_reason": "error"}) //nolint:errcheck
+	}))
+	defer ts.Close()
+
+	client := NewClient(&url.URL{Scheme: "http", Host: ts.Listener.Addr().String()}, http.DefaultClient)
+
+	var responses int
+	err := client.Generate(context.Background(), &GenerateRequest{Model: "test"}, func(GenerateResponse) error {
+		responses++
+		return nil
+	})
+	if !errors.Is(err, ErrOutOfMemory) || err.Error() != "out of memory" {
+		t.Errorf("expected ErrOutOfMemory, got %v", err)
+	}
+
+	if responses != 1 {
+		t.Errorf("expected 1 response before the error, got %d", responses)
+	}
+}
+
+func TestClientStreamErrorSummary(t *testing.T) {
+	cases := map[string]struct {
+		final  string
+		expect error
+	}{
+		"error": {
+			final:  `{"error":"out of memory","code":"out_of_memory","done_reason":"error","summary":{"done_reason":"error","usage":{"prompt_tokens":3,"completion_tokens":1,"total_tokens":4}}}`,
+			expect: ErrOutOfMemory,
+		},
+		"canceled": {
+			final:  `{"error":"context canceled","done_reason":"canceled","summary":{"done_reason":"canceled","usage":{"prompt_tokens":3,"completion_tokens":1,"total_tokens":4}}}`,
+			expect: ErrCanceled,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/x-ndjson")
+				fmt.Fprintln(w, `{"response":"hello","done":false}`)
+				fmt.Fprintln(w, tc.final)
+			}))
+			defer ts.Close()
+
+			client := NewClient(&url.URL{Scheme: "http", Host: ts.Listener.Addr().String()}, http.DefaultClient)
+
+			err := client.Generate(context.Background(), &GenerateRequest{Model: "test"}, func(GenerateResponse) error {
+				return nil
+			})
+			if !errors.Is(err, tc.expect) {
+				t.Fatalf("expected %v, got %v", tc.expect, err)
+			}
+
+			var se StreamError
+			if !errors.As(err, &se) || se.Summary == nil {
+				t.Fatalf("expected a summary, got %v", err)
+			}
+
+			if want := (Usage{PromptTokens: 3, CompletionTokens: 1, TotalTokens: 4}); se.Summary.Usage != want {
+				t.Errorf("expected %+v, got %+v", want, se.Summary.Usage)
+			}
+		})
+	}
+}
+
+func TestClientUse(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); auth != "Bearer secret" {
+			t.Errorf("expected authorization header, got %q", auth)
+		}
+
+		json.NewEncoder(w).Encode(VersionResponse{Version: "1.2.3"}) //nolint:errcheck
+	}))
+	defer ts.Close()
+
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				calls = append(calls, name+" "+r.URL.Path)
+				resp, err := next.RoundTrip(r)
+				calls = append(calls, name+" done")
+				return resp, err
+			})
+		}
+	}
+
+	auth := func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			r = r.Clone(r.Context())
+			r.Header.Set("Authorization", "Bearer secret")
+			return next.RoundTrip(r)
+		})
+	}
+
+	hc := &http.Client{}
+	client := NewClient(&url.URL{Scheme: "http", Host: ts.Listener.Addr().String()}, hc)
+	client.Use(trace("outer"), auth)
+	client.Use(trace("inner"))
+
+	version, err := client.Version(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if version != "1.2.3" {
+		t.Errorf("expected version 1.2.3, got %q", version)
+	}
+
+	want := []string{"outer /api/version", "inner /api/version", "inner done", "outer done"}
+	if strings.Join(calls, ",") != strings.Join(want, ",") {
+		t.Errorf("expected calls %v, got %v", want, calls)
+	}
+
+	if hc.Transport != nil {
+		t.Error("expected the http client to be unchanged")
+	}
+}
+
+func TestClientEventStream(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, ": comment\n\n")
+		fmt.Fprint(w, "data: {\"response\":\"Hello\"}\n\n")
+		fmt.Fprint(w, "data:{\"response\":\" world\"}\n\n")
+		fmt.Fprint(w, "event: error\ndata: {\"error\":\"out of memory\"}\n\n")
+	}))
+	defer ts.Close()
+
+	client := NewClient(&url.URL{Scheme: "http", Host: ts.Listener.Addr().String()}, http.DefaultClient)
+
+	var sb strings.Builder
+	err := client.Generate(t.Context(), &GenerateRequest{Model: "test"}, func(resp GenerateResponse) error {
+		sb.WriteString(resp.Response)
+		return nil
+	})
+
+	if err == nil || err.Error() != "out of memory" {
+		t.Errorf("expected the error event, got %v", err)
+	}
+
+	if sb.String() != "Hello world" {
+		t.Errorf("expected %q, got %q", "Hello world", sb.String())
+	}
+}
+
+func TestNewClientWithOptions(t *testing.T) {
+	t.Run("transport", func(t *testing.T) {
+		client := NewClientWithOptions(&url.URL{Scheme: "http", Host: "localhost"}, ClientOptions{
+			MaxIdleConnsPerHost: 64,
+			MaxConnsPerHost:     128,
+		})
+
+		transport := client.http.Transport.(*http.Transport)
+		if transport.MaxIdleConnsPerHost != 64 || transport.MaxConnsPerHost != 128 {
+			t.Errorf("expected the connection limits to be set, got %d idle and %d total", transport.MaxIdleConnsPerHost, transport.MaxConnsPerHost)
+		}
+
+		if transport == http.DefaultTransport {
+			t.Error("expected a transport of its own")
+		}
+	})
+
+	t.Run("http2", func(t *testing.T) {
+		ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(map[string]string{"version": r.Proto})
+		}))
+		ts.Config.Protocols = new(http.Protocols)
+		ts.Config.Protocols.SetHTTP1(true)
+		ts.Config.Protocols.SetUnencryptedHTTP2(true)
+		ts.Start()
+		defer ts.Close()
+
+		client := NewClientWithOptions(&url.URL{Scheme: "http", Host: ts.Listener.Addr().String()}, ClientOptions{HTTP2: true})
+		proto, err := client.Version(t.Context())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if proto != "HTTP/2.0" {
+			t.Errorf("expected HTTP/2.0, got %s", proto)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		// chunks arrive every delay after the first one
+		var delay, first atomic.Int64
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			wait := time.Duration(first.Load())
+			for i := range 3 {
+				select {
+				case <-time.After(wait):
+				case <-r.Context().Done():
+					return
+				}
+				wait = time.Duration(delay.Load())
+
+				if r.URL.Path == "/api/version" {
+					continue
+				}
+
+				fmt.Fprintf(w, "{\"response\":\"%d\"}\n", i)
+				w.(http.Flusher).Flush()
+			}
+
+			if r.URL.Path == "/api/version" {
+				fmt.Fprint(w, `{"version":"0.0.0"}`)
+			}
+		}))
+		defer ts.Close()
+
+		client := NewClientWithOptions(&url.URL{Scheme: "http", Host: ts.Listener.Addr().String()}, ClientOptions{Timeout: 100 * time.Millisecond})
+		generate := func() error {
+			return client.Generate(t.Context(), &GenerateRequest{Model: "test"}, func(GenerateResponse) error { return nil })
+		}
+
+		// the stream takes longer than the timeout, but its chunks don't
+		first.Store(0)
+		delay.Store(int64(60 * time.Millisecond))
+		if err := generate(); err != nil {
+			t.Errorf("expected the stream to finish, got %v", err)
+		}
+
+		if _, err := client.Version(t.Context()); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected the request to time out, got %v", err)
+		}
+
+		delay.Store(int64(300 * time.Millisecond))
+		if err := generate(); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected the stream to time out, got %v", err)
+		}
+
+		first.Store(int64(300 * time.Millisecond))
+		if err := generate(); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected the stream to time out, got %v", err)
+		}
+	})
+}
@@ -1,10 +1,15 @@
 package api
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"math"
+	"net/http"
+	"net/url"
 	"os"
 	"reflect"
 	"strconv"
@@ -14,11 +19,83 @@ import (
 	"github.com/ollama/ollama/envconfig"
 )
 
-// StatusError is an error with an HTTP status code and message.
+// StatusError is an error with an HTTP status code and message. Use
+// [errors.Is] with [ErrModelNotFound], [ErrContextExceeded],
+// [ErrServerOverloaded] or [ErrOutOfMemory] to tell common failures apart.
 type StatusError struct {
 	StatusCode   int
 	Status       string
 	ErrorMessage string `json:"error"`
+
+	// Code identifies the kind of error, like [ErrorResponse.Code].
+	Code string `json:"code,omitempty"`
+
+	// Fields are the names of the fields that caused the error, like
+	// [ErrorResponse.Fields].
+	Fields []string `json:"fields,omitempty"`
+}
+
+// ErrorResponse is the body of responses for requests that failed.
+type ErrorResponse struct {
+	Error string `json:"error"`
+
+	// Code identifies the kind of error for clients to handle without
+	// matching the message. It is one of the ErrorCode constants, or empty
+	// for other errors.
+	Code string `json:"code,omitempty"`
+
+	// Fields are the names of the fields of the request that caused the
+	// error, such as the options rejected with [ErrorCodeInvalidOptions].
+	Fields []string `json:"fields,omitempty"`
+}
+
+// Codes of [ErrorResponse].
+const (
+	ErrorCodeModelNotFound    = "model_not_found"
+	ErrorCodeContextExceeded  = "context_exceeded"
+	ErrorCodeServerOverloaded = "server_overloaded"
+	ErrorCodeInvalidOptions   = "invalid_options"
+	ErrorCodeOutOfMemory      = "out_of_memory"
+)
+
+var (
+	// ErrModelNotFound matches errors for models that don't exist.
+	ErrModelNotFound = errors.New("model not found")
+
+	// ErrContextExceeded matches errors for inputs that don't fit in the
+	// context length of the model.
+	ErrContextExceeded = errors.New("input exceeds the context length")
+
+	// ErrServerOverloaded matches errors for requests that the server
+	// rejected because too many requests are waiting already.
+	ErrServerOverloaded = errors.New("server overloaded")
+
+	// ErrOutOfMemory matches errors for requests that failed because the
+	// model ran out of memory while processing them. Other requests to the
+	// model continue, so the request may succeed when retried later or with
+	// a shorter input.
+	ErrOutOfMemory = errors.New("out of memory")
+)
+
+// Is reports whether e is of the kind of target, one of [ErrModelNotFound],
+// [ErrContextExceeded], [ErrServerOverloaded] or [ErrOutOfMemory]. Errors of
+// servers that don't send codes are recognized by their status and message.
+func (e StatusError) Is(target error) bool {
+	switch target {
+	case ErrModelNotFound:
+		return e.Code == ErrorCodeModelNotFound ||
+			e.Code == "" && e.StatusCode == http.StatusNotFound && strings.HasPrefix(e.ErrorMessage, "model ")
+	case ErrContextExceeded:
+		return e.Code == ErrorCodeContextExceeded ||
+			e.Code == "" && e.StatusCode == http.StatusBadRequest && strings.Contains(e.ErrorMessage, "context length")
+	case ErrServerOverloaded:
+		return e.Code == ErrorCodeServerOverloaded ||
+			e.Code == "" && (e.StatusCode == http.StatusServiceUnavailable || e.StatusCode == http.StatusTooManyRequests)
+	case ErrOutOfMemory:
+		return e.Code == ErrorCodeOutOfMemory
+	}
+
+	return false
 }
 
 func (e StatusError) Error() string {
@@ -82,6 +159,44 @@ type GenerateRequest struct {
 	// Options lists model-specific options. For example, temperature can be
 	// set through this field, if the model supports it.
 	Options map[string]interface{} `json:"options"`
+
+	// Checkpoint periodically saves the response generated so far so that
+	// the generation can be resumed with the continuation token returned in
+	// [GenerateResponse] if it is interrupted.
+	Checkpoint bool `json:"checkpoint,omitempty"`
+
+	// Continuation resumes an interrupted generation from its last
+	// checkpoint. The other fields of the original request are reused,
+	// except Stream and KeepAlive.
+	Continuation string `json:"continuation,omitempty"`
+
+	// Progress adds an estimate of how much of the response has been
+	// generated to each [GenerateResponse].
+	Progress bool `json:"progress,omitempty"`
+
+	// Strict rejects the request if Options has unknown or invalid options
+	// instead of ignoring them. It defaults to the server's
+	// OLLAMA_STRICT_OPTIONS setting.
+	Strict *bool `json:"strict,omitempty"`
+
+	// Logprobs adds the log probability of each generated token to each
+	// [GenerateResponse].
+	Logprobs bool `json:"logprobs,omitempty"`
+
+	// TopLogprobs is the number of the most likely tokens, up to 20, to list
+	// with their log probabilities at each position. It implies Logprobs.
+	TopLogprobs int `json:"top_logprobs,omitempty"`
+
+	// CacheKey names the prompt, such as a long system prompt shared by
+	// many requests, so that the runner keeps the context it computed for
+	// it and reuses it for later requests with the same key. Keys may have
+	// up to 128 letters, digits, '.', '_', ':' and '-'.
+	CacheKey string `json:"cache_key,omitempty"`
+
+	// RequestID identifies the request in the server's logs and to
+	// [Client.Cancel]. It is sent in the X-Request-ID header rather than the
+	// body, and the server chooses an ID when it is empty.
+	RequestID string `json:"-"`
 }
 
 // ChatRequest describes a request sent by [Client.Chat].
@@ -107,6 +222,29 @@ type ChatRequest struct {
 
 	// Options lists model-specific options.
 	Options map[string]interface{} `json:"options"`
+
+	// Progress adds an estimate of how much of the response has been
+	// generated to each [ChatResponse].
+	Progress bool `json:"progress,omitempty"`
+
+	// Strict is as in [GenerateRequest].
+	Strict *bool `json:"strict,omitempty"`
+
+	// Logprobs and TopLogprobs are as in [GenerateRequest].
+	Logprobs    bool `json:"logprobs,omitempty"`
+	TopLogprobs int  `json:"top_logprobs,omitempty"`
+
+	// Session is the ID of a session created with [Client.CreateSession].
+	// Its messages come before Messages, and its tools, options and keep
+	// alive are used unless the request sets them. The new messages and
+	// the response are added to the session when the response is done.
+	Session string `json:"session,omitempty"`
+
+	// CacheKey is as in [GenerateRequest].
+	CacheKey string `json:"cache_key,omitempty"`
+
+	// RequestID identifies the request, as in [GenerateRequest].
+	RequestID string `json:"-"`
 }
 
 type Tools []Tool
@@ -155,6 +293,17 @@ type ToolCallFunction struct {
 
 type ToolCallFunctionArguments map[string]any
 
+// ToolCallDelta is part of a tool call that is streamed while the model
+// generates it. The name of a call arrives first, then its arguments in
+// fragments of JSON that form an object when they are joined.
+type ToolCallDelta struct {
+	// Index is the index of the call, as in [ToolCallFunction].
+	Index int `json:"index"`
+
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+}
+
 func (t *ToolCallFunctionArguments) String() string {
 	bts, _ := json.Marshal(t)
 	return string(bts)
@@ -194,9 +343,213 @@ type ChatResponse struct {
 
 	Done bool `json:"done"`
 
+	// Progress is an estimate, as in [GenerateResponse].
+	Progress float64 `json:"progress,omitempty"`
+
+	// Logprobs is as in [GenerateResponse].
+	Logprobs []Logprob `json:"logprobs,omitempty"`
+
+	// ToolCallDeltas are the parts of tool calls generated since the last
+	// response of a stream. The complete tool calls follow in the
+	// ToolCalls of a later Message.
+	ToolCallDeltas []ToolCallDelta `json:"tool_call_deltas,omitempty"`
+
+	// Fingerprint is as in [GenerateResponse].
+	Fingerprint *Fingerprint `json:"fingerprint,omitempty"`
+
+	// StreamSummary is set on the final message of a stream.
+	StreamSummary *StreamSummary `json:"summary,omitempty"`
+
+	// Warnings are set on the final message of a stream.
+	Warnings []Warning `json:"warnings,omitempty"`
+
 	Metrics
 }
 
+// TokenLogprob is a token with its natural log probability.
+type TokenLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+}
+
+// Logprob is a generated token with its log probability and, when
+// requested, the most likely tokens at its position.
+type Logprob struct {
+	TokenLogprob
+
+	TopLogprobs []TokenLogprob `json:"top_logprobs,omitempty"`
+}
+
+// Reasons reported in the DoneReason field of [GenerateResponse] and
+// [ChatResponse] once a response is complete.
+const (
+	// DoneReasonStop means the model produced an end of sequence token or
+	// matched one of the stop sequences.
+	DoneReasonStop = "stop"
+
+	// DoneReasonLength means generation was truncated after reaching
+	// num_predict or the maximum number of tokens allowed for the context.
+	DoneReasonLength = "length"
+
+	// DoneReasonToolCalls means the model finished by calling one or more tools.
+	DoneReasonToolCalls = "tool_calls"
+
+	// DoneReasonContentFilter means the output was withheld by a content filter.
+	DoneReasonContentFilter = "content_filter"
+
+	// DoneReasonCanceled means the request was canceled before completion,
+	// usually because the client closed the connection.
+	DoneReasonCanceled = "canceled"
+
+	// DoneReasonError means generation was aborted by an error.
+	DoneReasonError = "error"
+
+	// DoneReasonRepetitionDetected means generation was aborted because the
+	// model kept repeating the same token.
+	DoneReasonRepetitionDetected = "repetition_detected"
+
+	// DoneReasonLoad means the request only loaded the model.
+	DoneReasonLoad = "load"
+
+	// DoneReasonUnload means the request only unloaded the model.
+	DoneReasonUnload = "unload"
+)
+
+// Warning is a problem with a request that didn't stop the server from
+// answering it, such as an option that the model ignores.
+type Warning struct {
+	// Code is one of the Warning constants below, for clients that act on
+	// some warnings.
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Codes of [Warning].
+const (
+	// WarningUnknownOption means an option of the request is not one of
+	// [Options] and was ignored.
+	WarningUnknownOption = "unknown_option"
+
+	// WarningOptionIgnored means the engine running the model doesn't use
+	// an option of the request.
+	WarningOptionIgnored = "option_ignored"
+
+	// WarningSamplerFallback means the engine running the model doesn't
+	// support the sampler asked for and used its default sampler instead.
+	WarningSamplerFallback = "sampler_fallback"
+
+	// WarningPromptTruncated means the prompt or an input didn't fit in
+	// the context window and part of it was left out.
+	WarningPromptTruncated = "prompt_truncated"
+
+	// WarningModelFallback means the model may not fit in memory and a
+	// smaller quantization of it is available, which served the request
+	// if OLLAMA_QUANT_FALLBACK is "auto".
+	WarningModelFallback = "model_fallback"
+)
+
+// StreamSummary is the trailing metadata attached to the final message of a
+// streamed [GenerateResponse] or [ChatResponse]. It collects the finish reason,
+// token usage and any warnings so callers don't need to track them while
+// iterating over the stream. Streams that end with an error have no final
+// message, so the client returns their summary in a [StreamError].
+type StreamSummary struct {
+	DoneReason string `json:"done_reason,omitempty"`
+	Usage      Usage  `json:"usage"`
+
+	// Warnings are the messages of the warnings of the response.
+	//
+	// Deprecated: Use the Warnings of the response, which have codes.
+	Warnings []string `json:"warnings,omitempty"`
+
+	// Route is set for requests to a router or fallback model, or to a
+	// model with a canary.
+	Route *RouteDecision `json:"route,omitempty"`
+}
+
+// RouteDecision describes how a router or fallback model picked the model
+// that served a request.
+type RouteDecision struct {
+	// Router is the name of the router, fallback or canaried model in the
+	// request.
+	Router string `json:"router"`
+
+	// Model is the model that served the request.
+	Model string `json:"model"`
+
+	// Reason is "classifier" if the classifier model picked the model,
+	// "rule" if the rules of its route matched the request, or "default"
+	// if no route matched and the last one was used. Fallback models
+	// report "primary" if their first model served the request and
+	// "fallback" otherwise, and models with a canary report "stable" or
+	// "canary".
+	Reason string `json:"reason"`
+
+	// Skipped lists why the models of a fallback model tried before Model
+	// did not serve the request.
+	Skipped []SkippedModel `json:"skipped,omitempty"`
+}
+
+// SkippedModel is a model of a fallback model that did not serve a request.
+type SkippedModel struct {
+	Model string `json:"model"`
+
+	// Reason is "error", "timeout" or "confidence"
+	Reason string `json:"reason"`
+
+	// Error is the error of the model if Reason is "error" or "timeout"
+	Error string `json:"error,omitempty"`
+
+	// Logprob is the mean log probability of the tokens of the response if
+	// Reason is "confidence"
+	Logprob float64 `json:"logprob,omitempty"`
+}
+
+// Fingerprint identifies what produced a response so that callers can detect
+// when a model changes under the same name.
+type Fingerprint struct {
+	// Digest is the digest of the model's manifest.
+	Digest string `json:"digest"`
+
+	// TemplateHash is the digest of the template applied to the prompt. It
+	// is empty for raw prompts and models without a template.
+	TemplateHash string `json:"template_hash,omitempty"`
+
+	// Engine is the engine that ran the model, "ollama" or "llama.cpp".
+	Engine string `json:"engine,omitempty"`
+}
+
+// SystemFingerprint summarizes f in the form of OpenAI's system_fingerprint.
+func (f Fingerprint) SystemFingerprint() string {
+	h := sha256.New()
+	for _, s := range []string{f.Digest, f.TemplateHash, f.Engine} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+
+	return "fp_" + hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// Usage reports the number of tokens processed by a request.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// NewStreamSummary builds the [StreamSummary] for a response that finished
+// with doneReason after processing the tokens counted in m.
+func NewStreamSummary(doneReason string, m Metrics) *StreamSummary {
+	return &StreamSummary{
+		DoneReason: doneReason,
+		Usage: Usage{
+			PromptTokens:     m.PromptEvalCount,
+			CompletionTokens: m.EvalCount,
+			TotalTokens:      m.PromptEvalCount + m.EvalCount,
+		},
+	}
+}
+
 type Metrics struct {
 	TotalDuration      time.Duration `json:"total_duration,omitempty"`
 	LoadDuration       time.Duration `json:"load_duration,omitempty"`
@@ -204,6 +557,13 @@ type Metrics struct {
 	PromptEvalDuration time.Duration `json:"prompt_eval_duration,omitempty"`
 	EvalCount          int           `json:"eval_count,omitempty"`
 	EvalDuration       time.Duration `json:"eval_duration,omitempty"`
+
+	// Energy is an estimate of the energy in joules used by the GPUs running
+	// the model during the request and GPUTemperature the highest GPU
+	// temperature in degrees Celsius seen during the request. They are left
+	// out when the GPUs don't report their power draw or temperature.
+	Energy         float64 `json:"energy_joules,omitempty"`
+	GPUTemperature float64 `json:"gpu_temperature,omitempty"`
 }
 
 // Options specified in [GenerateRequest].  If you add a new option here, also
@@ -228,12 +588,19 @@ type Options struct {
 	MirostatTau      float32  `json:"mirostat_tau,omitempty"`
 	MirostatEta      float32  `json:"mirostat_eta,omitempty"`
 	Stop             []string `json:"stop,omitempty"`
+	StopRegex        []string `json:"stop_regex,omitempty"`
+	IgnoreEOS        bool     `json:"ignore_eos,omitempty"`
+
+	// MaxTPS limits how fast the generated tokens are returned, in tokens
+	// per second
+	MaxTPS float32 `json:"max_tps,omitempty"`
 }
 
 // Runner options which must be set when the model is loaded into memory
 type Runner struct {
 	NumCtx    int   `json:"num_ctx,omitempty"`
 	NumBatch  int   `json:"num_batch,omitempty"`
+	NumUBatch int   `json:"num_ubatch,omitempty"`
 	NumGPU    int   `json:"num_gpu,omitempty"`
 	MainGPU   int   `json:"main_gpu,omitempty"`
 	LowVRAM   bool  `json:"low_vram,omitempty"`
@@ -243,6 +610,16 @@ type Runner struct {
 	UseMMap   *bool `json:"use_mmap,omitempty"`
 	UseMLock  bool  `json:"use_mlock,omitempty"`
 	NumThread int   `json:"num_thread,omitempty"`
+
+	// NumParallel is the number of requests the model serves at the same
+	// time, overriding OLLAMA_NUM_PARALLEL. Each adds to the context.
+	NumParallel int `json:"num_parallel,omitempty"`
+
+	// LayerSplit places contiguous ranges of layers on the GPUs in
+	// proportion to its comma-separated weights, e.g. "1,3" puts the first
+	// quarter of the layers on the first GPU and the rest on the second.
+	// It overrides the general.layer_split metadata of the model.
+	LayerSplit string `json:"layer_split,omitempty"`
 }
 
 // EmbedRequest is the request passed to [Client.Embed].
@@ -259,8 +636,88 @@ type EmbedRequest struct {
 
 	Truncate *bool `json:"truncate,omitempty"`
 
+	// Dimensions truncates the embeddings to this many dimensions. The model
+	// must have been trained to support it, with Matryoshka representation
+	// learning.
+	Dimensions int `json:"dimensions,omitempty"`
+
+	// Normalize scales the embeddings to unit length; true by default.
+	Normalize *bool `json:"normalize,omitempty"`
+
+	// Chunking embeds inputs in chunks of tokens rather than truncating
+	// those longer than the context length.
+	Chunking *EmbedChunking `json:"chunking,omitempty"`
+
 	// Options lists model-specific options.
 	Options map[string]interface{} `json:"options"`
+
+	// Strict is as in [GenerateRequest].
+	Strict *bool `json:"strict,omitempty"`
+}
+
+// EmbedBatchRequest is the request passed to [Client.EmbedBatch].
+type EmbedBatchRequest struct {
+	// Model is the model name.
+	Model string `json:"model"`
+
+	// Inputs are the texts to embed.
+	Inputs []string `json:"inputs"`
+
+	// KeepAlive controls how long the model will stay loaded in memory following
+	// this request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// Truncate shortens inputs longer than the context length instead of
+	// failing them; true by default.
+	Truncate *bool `json:"truncate,omitempty"`
+
+	// Dimensions truncates the embeddings to this many dimensions, like
+	// [EmbedRequest.Dimensions].
+	Dimensions int `json:"dimensions,omitempty"`
+
+	// Normalize scales the embeddings to unit length; true by default.
+	Normalize *bool `json:"normalize,omitempty"`
+
+	// Options lists model-specific options.
+	Options map[string]any `json:"options"`
+}
+
+// EmbedBatchResponse is the response from [Client.EmbedBatch].
+type EmbedBatchResponse struct {
+	Model string `json:"model"`
+
+	// Results are the results for each input, in the order of the inputs.
+	Results []EmbedBatchResult `json:"results"`
+
+	TotalDuration   time.Duration `json:"total_duration,omitempty"`
+	LoadDuration    time.Duration `json:"load_duration,omitempty"`
+	PromptEvalCount int           `json:"prompt_eval_count,omitempty"`
+
+	// Fingerprint identifies the model and engine that generated the
+	// embeddings.
+	Fingerprint *Fingerprint `json:"fingerprint,omitempty"`
+}
+
+// EmbedBatchResult is the embedding of an input in [EmbedBatchResponse], or
+// the error that kept it from being embedded.
+type EmbedBatchResult struct {
+	Embedding []float32 `json:"embedding,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// EmbedChunking controls how [EmbedRequest] splits its inputs into chunks.
+type EmbedChunking struct {
+	// Size is the number of tokens of each chunk. It defaults to, and can't
+	// be more than, the context length.
+	Size int `json:"size,omitempty"`
+
+	// Overlap is the number of tokens shared by consecutive chunks.
+	Overlap int `json:"overlap,omitempty"`
+
+	// Pooling is "mean" to only return the average of the embeddings of the
+	// chunks of each input weighted by their number of tokens, or "none" to
+	// also return the embedding of each chunk. It defaults to "mean".
+	Pooling string `json:"pooling,omitempty"`
 }
 
 // EmbedResponse is the response from [Client.Embed].
@@ -271,6 +728,171 @@ type EmbedResponse struct {
 	TotalDuration   time.Duration `json:"total_duration,omitempty"`
 	LoadDuration    time.Duration `json:"load_duration,omitempty"`
 	PromptEvalCount int           `json:"prompt_eval_count,omitempty"`
+
+	// Chunks are the chunks of each input, in the order of the inputs, if
+	// chunking was requested with pooling "none".
+	Chunks [][]EmbedChunk `json:"chunks,omitempty"`
+
+	// Fingerprint identifies the model and engine that generated the
+	// embeddings.
+	Fingerprint *Fingerprint `json:"fingerprint,omitempty"`
+
+	Warnings []Warning `json:"warnings,omitempty"`
+}
+
+// EmbedChunk is a chunk of an input of [EmbedRequest] and its embedding.
+type EmbedChunk struct {
+	Text      string    `json:"text"`
+	Tokens    int       `json:"tokens"`
+	Embedding []float32 `json:"embedding"`
+}
+
+// ClassifyRequest is the request passed to [Client.Classify].
+type ClassifyRequest struct {
+	// Model is the model name. It must have a sequence classification head.
+	Model string `json:"model"`
+
+	// Input is the input to classify: a string or a list of strings.
+	Input any `json:"input"`
+
+	// KeepAlive controls how long the model will stay loaded into memory
+	// following the request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// Truncate truncates the input to fit the model's max sequence length.
+	Truncate *bool `json:"truncate,omitempty"`
+
+	// Options lists model-specific options.
+	Options map[string]any `json:"options"`
+}
+
+// ClassifyResponse is the response from [Client.Classify].
+type ClassifyResponse struct {
+	Model string `json:"model"`
+
+	// Classifications are the scores of each label for each input, in the
+	// order of the inputs. The labels of each are sorted from the highest
+	// score to the lowest.
+	Classifications [][]LabelScore `json:"classifications"`
+
+	TotalDuration   time.Duration `json:"total_duration,omitempty"`
+	LoadDuration    time.Duration `json:"load_duration,omitempty"`
+	PromptEvalCount int           `json:"prompt_eval_count,omitempty"`
+
+	// Fingerprint identifies the model and engine that classified the
+	// inputs.
+	Fingerprint *Fingerprint `json:"fingerprint,omitempty"`
+}
+
+// LabelScore is the score of a label of a classification. Logit is the raw
+// output of the classification head and Score its softmax across the labels,
+// or its sigmoid if the head has a single output, as reward models do.
+type LabelScore struct {
+	Label string  `json:"label"`
+	Score float64 `json:"score"`
+	Logit float64 `json:"logit"`
+}
+
+// PerplexityRequest is the request passed to [Client.Perplexity].
+type PerplexityRequest struct {
+	// Model is the model name.
+	Model string `json:"model"`
+
+	// Text is the text to score. Texts longer than about half the context
+	// length are scored in windows of that length.
+	Text string `json:"text"`
+
+	// KeepAlive controls how long the model will stay loaded into memory
+	// following the request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// Options lists model-specific options.
+	Options map[string]any `json:"options"`
+}
+
+// PerplexityResponse is the response from [Client.Perplexity].
+type PerplexityResponse struct {
+	Model string `json:"model"`
+
+	// Tokens are the scored tokens of the text, in order. The first token
+	// of each window is only used as context and is not scored.
+	Tokens []TokenNLL `json:"tokens"`
+
+	// NLL is the mean negative log likelihood of the scored tokens and
+	// Perplexity its exponential.
+	NLL        float64 `json:"nll"`
+	Perplexity float64 `json:"perplexity"`
+
+	TotalDuration time.Duration `json:"total_duration,omitempty"`
+	LoadDuration  time.Duration `json:"load_duration,omitempty"`
+
+	// Fingerprint identifies the model and engine that scored the text.
+	Fingerprint *Fingerprint `json:"fingerprint,omitempty"`
+}
+
+// TokenNLL is the negative log likelihood the model gives to a token of a
+// text, in nats.
+type TokenNLL struct {
+	Token string  `json:"token"`
+	NLL   float64 `json:"nll"`
+}
+
+// SimilarityRequest is the request passed to [Client.Similarity]. It has
+// either Vectors or Texts, which are embedded with Model.
+type SimilarityRequest struct {
+	// Vectors are the vectors to compare.
+	Vectors [][]float32 `json:"vectors,omitempty"`
+
+	// Texts are the texts to compare and Model the model that embeds them.
+	Texts []string `json:"texts,omitempty"`
+	Model string   `json:"model,omitempty"`
+
+	// Metric is "cosine" or "dot"; cosine by default.
+	Metric string `json:"metric,omitempty"`
+
+	// KeepAlive controls how long the model will stay loaded into memory
+	// following the request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// Options lists model-specific options.
+	Options map[string]any `json:"options"`
+}
+
+// SimilarityResponse is the response from [Client.Similarity].
+type SimilarityResponse struct {
+	// Scores are the similarities of each pair of inputs: Scores[i][j] is
+	// the similarity of input i and input j.
+	Scores [][]float64 `json:"scores"`
+}
+
+// ClusterRequest is the request passed to [Client.Cluster].
+type ClusterRequest struct {
+	// Vectors are the vectors to cluster, such as embeddings.
+	Vectors [][]float32 `json:"vectors"`
+
+	// K is the number of clusters.
+	K int `json:"k"`
+
+	// MaxIterations limits the iterations of k-means; 100 by default.
+	MaxIterations int `json:"max_iterations,omitempty"`
+
+	// Seed seeds the choice of the initial centroids.
+	Seed int `json:"seed,omitempty"`
+}
+
+// ClusterResponse is the response from [Client.Cluster].
+type ClusterResponse struct {
+	// Labels are the cluster of each vector, in the order of the vectors.
+	Labels []int `json:"labels"`
+
+	// Centroids are the centers of the clusters.
+	Centroids [][]float32 `json:"centroids"`
+
+	// Inertia is the sum of the squared distances of the vectors to the
+	// centroids of their clusters.
+	Inertia float64 `json:"inertia"`
+
+	Iterations int `json:"iterations"`
 }
 
 // EmbeddingRequest is the request passed to [Client.Embeddings].
@@ -292,6 +914,9 @@ type EmbeddingRequest struct {
 // EmbeddingResponse is the response from [Client.Embeddings].
 type EmbeddingResponse struct {
 	Embedding []float64 `json:"embedding"`
+
+	// Fingerprint is as in [EmbedResponse].
+	Fingerprint *Fingerprint `json:"fingerprint,omitempty"`
 }
 
 // CreateRequest is the request passed to [Client.Create].
@@ -300,6 +925,13 @@ type CreateRequest struct {
 	Stream   *bool  `json:"stream,omitempty"`
 	Quantize string `json:"quantize,omitempty"`
 
+	// QuantizeTensors overrides the types that tensors are quantized to
+	// when Quantize is set, to mix quantizations in a model. Keys are
+	// patterns of tensor names in which * matches any characters, such as
+	// "blk.*.attn_v.weight", and values are tensor types such as "q6_K".
+	// The longest pattern that matches a tensor applies to it.
+	QuantizeTensors map[string]string `json:"quantize_tensors,omitempty"`
+
 	From       string            `json:"from,omitempty"`
 	Files      map[string]string `json:"files,omitempty"`
 	Adapters   map[string]string `json:"adapters,omitempty"`
@@ -371,6 +1003,280 @@ type PullRequest struct {
 	Name string `json:"name"`
 }
 
+// PullPreviewResponse is the response from [Client.PullPreview], describing
+// what pulling a model would download without downloading it.
+type PullPreviewResponse struct {
+	Model  string             `json:"model"`
+	Layers []PullPreviewLayer `json:"layers"`
+
+	// Total is the size of all layers of the model.
+	Total int64 `json:"total"`
+
+	// Download is the size of the layers that are not stored locally yet,
+	// less what interrupted pulls of them already downloaded.
+	Download int64 `json:"download"`
+
+	// Disk is the disk space the model needs in addition to the layers
+	// stored locally, which it shares with other models.
+	Disk int64 `json:"disk"`
+}
+
+// PullPreviewLayer is a layer of the model in [PullPreviewResponse].
+type PullPreviewLayer struct {
+	Digest    string `json:"digest"`
+	MediaType string `json:"media_type"`
+	Size      int64  `json:"size"`
+
+	// Completed is how much of the layer is stored locally, which is its
+	// size once it is complete.
+	Completed int64 `json:"completed,omitempty"`
+}
+
+// SearchRequest is the request passed to [Client.Search].
+type SearchRequest struct {
+	// Query is a substring of the names of the models to find, ignoring
+	// case. An empty query matches all models.
+	Query string `json:"query"`
+
+	// Registry is the registry to search, such as "registry.ollama.ai",
+	// optionally followed by a namespace to only search models in it, such
+	// as "registry.ollama.ai/library". It defaults to the default registry
+	// and, like model names, may start with "http://" for a registry
+	// without TLS.
+	Registry string `json:"registry,omitempty"`
+
+	// Limit is the maximum number of models to return, 20 by default.
+	Limit int `json:"limit,omitempty"`
+}
+
+// SearchResponse is the response from [Client.Search].
+type SearchResponse struct {
+	Models []SearchResult `json:"models"`
+}
+
+// SearchResult is a model of a registry in [SearchResponse].
+type SearchResult struct {
+	Name string            `json:"name"`
+	Tags []SearchResultTag `json:"tags"`
+}
+
+// SearchResultTag is a tag of a model in [SearchResult], named as it is
+// pulled.
+type SearchResultTag struct {
+	Name    string       `json:"name"`
+	Tag     string       `json:"tag"`
+	Size    int64        `json:"size"`
+	Digest  string       `json:"digest"`
+	Details ModelDetails `json:"details,omitempty"`
+}
+
+// UpdatePolicyRequest is the request passed to [Client.SetUpdatePolicy].
+type UpdatePolicyRequest struct {
+	Model string `json:"model"`
+
+	// Policy is "auto" to pull updates of the model as soon as they are
+	// found, "manual" to only report them or "pinned" to not check for
+	// them. An empty policy resets the model to the default policy.
+	Policy string `json:"policy"`
+}
+
+// UpdatesResponse is the response from [Client.Updates].
+type UpdatesResponse struct {
+	Models []ModelUpdate `json:"models"`
+}
+
+// ModelUpdate is the update status of a local model.
+type ModelUpdate struct {
+	Model  string `json:"model"`
+	Policy string `json:"policy"`
+
+	// Status is "up to date", "available" when an update has been found
+	// but not pulled, "updated" when it has been pulled or "failed". It
+	// is empty until the model has been checked.
+	Status    string    `json:"status,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at,omitzero"`
+	UpdatedAt time.Time `json:"updated_at,omitzero"`
+}
+
+// CanaryRequest is the request passed to [Client.StartCanary]. It sends a
+// share of the requests to Model to the model Canary instead.
+type CanaryRequest struct {
+	Model  string `json:"model"`
+	Canary string `json:"canary"`
+
+	// Percent is the share of requests sent to Canary, from 0 to 100
+	Percent float64 `json:"percent"`
+}
+
+// CanaryEndRequest is the request passed to [Client.PromoteCanary] and
+// [Client.RollbackCanary].
+type CanaryEndRequest struct {
+	Model string `json:"model"`
+}
+
+// CanariesResponse is the response from [Client.Canaries].
+type CanariesResponse struct {
+	Canaries []Canary `json:"canaries"`
+}
+
+// Canary is a model whose requests are split between its stable version
+// and a canary.
+type Canary struct {
+	Model     string        `json:"model"`
+	Percent   float64       `json:"percent"`
+	StartedAt time.Time     `json:"started_at"`
+	Stable    CanaryVariant `json:"stable"`
+	Canary    CanaryVariant `json:"canary"`
+}
+
+// CanaryVariant is the version of a model that serves a share of the
+// requests of a canary, and what the server saw of these requests since it
+// started. The counts don't include requests that clients canceled.
+type CanaryVariant struct {
+	Model  string `json:"model"`
+	Digest string `json:"digest"`
+
+	Requests int `json:"requests"`
+	Errors   int `json:"errors"`
+
+	// Truncated counts the responses that reached the limit of tokens to
+	// predict or the context length, which may be a sign of repetition
+	Truncated int `json:"truncated"`
+
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+	EvalDuration    time.Duration `json:"eval_duration"`
+	TotalDuration   time.Duration `json:"total_duration"`
+}
+
+// SessionRequest is the request passed to [Client.CreateSession].
+type SessionRequest struct {
+	Model string `json:"model"`
+
+	// Messages start the chat of the session, such as with a system
+	// message.
+	Messages []Message `json:"messages,omitempty"`
+
+	Tools     `json:"tools,omitempty"`
+	Options   map[string]any `json:"options,omitempty"`
+	KeepAlive *Duration      `json:"keep_alive,omitempty"`
+}
+
+// Session is a chat whose messages are kept by the server, so that each
+// [ChatRequest] in it only sends the new messages. Repeating the messages
+// of the session as the start of each prompt also lets the runner reuse
+// the context it computed for them.
+type Session struct {
+	ID       string    `json:"id"`
+	Model    string    `json:"model"`
+	Messages []Message `json:"messages,omitempty"`
+
+	Tools     `json:"tools,omitempty"`
+	Options   map[string]any `json:"options,omitempty"`
+	KeepAlive *Duration      `json:"keep_alive,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SessionsResponse is the response from [Client.Sessions]. The sessions
+// don't include their messages.
+type SessionsResponse struct {
+	Sessions []Session `json:"sessions"`
+}
+
+// CacheRequest is the request passed to [Client.PinCache],
+// [Client.UnpinCache] and [Client.EvictCache].
+type CacheRequest struct {
+	Model    string `json:"model"`
+	CacheKey string `json:"cache_key"`
+}
+
+// CacheResponse is the response from [Client.Cache].
+type CacheResponse struct {
+	Entries []CacheEntry `json:"entries"`
+}
+
+// CacheEntry is a prompt with a cache key that a loaded model keeps in its
+// cache. A key has several entries for a model when its requests were
+// served in parallel.
+type CacheEntry struct {
+	Model    string `json:"model"`
+	CacheKey string `json:"cache_key"`
+
+	// Tokens is the number of tokens of the prompt and response that are
+	// cached.
+	Tokens int `json:"tokens"`
+
+	// Pinned entries are not evicted for other prompts.
+	Pinned bool `json:"pinned"`
+
+	// InUse is set while a request uses the entry.
+	InUse bool `json:"in_use"`
+
+	LastUsed time.Time `json:"last_used"`
+}
+
+// LogLevels are the log levels of the server, as returned by
+// [Client.LogLevels] and passed to [Client.SetLogLevels].
+type LogLevels struct {
+	// Level is the default level, such as "debug" or "info". When setting
+	// levels, an empty level leaves it unchanged.
+	Level string `json:"level,omitempty"`
+
+	// Subsystems holds the levels of subsystems, "scheduler", "runner" or
+	// "registry", that differ from the default level. When setting levels,
+	// an empty level makes the subsystem follow the default level again.
+	Subsystems map[string]string `json:"subsystems,omitempty"`
+}
+
+// CancelRequest is the request passed to [Client.Cancel].
+type CancelRequest struct {
+	// RequestID is the ID of the generate or chat request to stop.
+	RequestID string `json:"request_id"`
+}
+
+// VersionResponse is the response from the /api/version endpoint.
+type VersionResponse struct {
+	Version string `json:"version"`
+
+	// SelfTest holds the latest result of the numerical self-test of each
+	// device that runners have loaded models on.
+	SelfTest []SelfTestResult `json:"self_test,omitempty"`
+}
+
+// SelfTestResult is the outcome of checking that a device computes the same
+// results as a reference implementation when a model is loaded.
+type SelfTestResult struct {
+	Device   string   `json:"device"`
+	Passed   bool     `json:"passed"`
+	MaxError float64  `json:"max_error"`
+	Failed   []string `json:"failed,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// ReadyResponse is the response from the /readyz and /drainz endpoints.
+type ReadyResponse struct {
+	// Ready is true when every prefetched model is ready and the server is
+	// not draining.
+	Ready    bool `json:"ready"`
+	Draining bool `json:"draining,omitempty"`
+
+	// Active is the number of requests in progress.
+	Active int             `json:"active"`
+	Models []ModelPrefetch `json:"models,omitempty"`
+}
+
+// ModelPrefetch is the status of a model that is pulled at startup.
+type ModelPrefetch struct {
+	Model string `json:"model"`
+
+	// Status is "pending", "pulling", "ready" or "failed".
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
 // ProgressResponse is the response passed to progress functions like
 // [PullProgressFunc] and [PushProgressFunc].
 type ProgressResponse struct {
@@ -378,6 +1284,195 @@ type ProgressResponse struct {
 	Digest    string `json:"digest,omitempty"`
 	Total     int64  `json:"total,omitempty"`
 	Completed int64  `json:"completed,omitempty"`
+
+	// Rate is the maximum transfer rate enforced while pulling or pushing,
+	// in bytes per second. It is 0 if the transfer is not limited.
+	Rate uint64 `json:"rate,omitempty"`
+
+	// Speed is the recent transfer speed of the layer with Digest, in bytes
+	// per second, averaged over the last few seconds.
+	Speed float64 `json:"speed,omitempty"`
+
+	// Retries is the number of times transferring part of the layer with
+	// Digest failed and was retried.
+	Retries int `json:"retries,omitempty"`
+}
+
+// WarmRequest is the request passed to [Client.Warm].
+type WarmRequest struct {
+	// Model is the model name.
+	Model string `json:"model"`
+
+	// Lock locks the model's files in memory after reading them so they
+	// cannot be evicted from the page cache.
+	Lock bool `json:"lock,omitempty"`
+
+	// KeepAlive controls how long the files stay locked in memory. It has
+	// no effect unless Lock is set, in which case a KeepAlive of 0 unpins
+	// files locked by an earlier request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// Stream enables streaming of returned progress; true by default.
+	Stream *bool `json:"stream,omitempty"`
+}
+
+// VerifyRequest is the request passed to [Client.Verify].
+type VerifyRequest struct {
+	// Model is the model name.
+	Model string `json:"model"`
+
+	// Golden also runs a short prompt with fixed options and compares a
+	// hash of the output to the one recorded the first time the model was
+	// verified with Golden set.
+	Golden bool `json:"golden,omitempty"`
+
+	// Stream enables streaming of returned progress; true by default.
+	Stream *bool `json:"stream,omitempty"`
+}
+
+// EvalRequest is the request passed to [Client.Eval].
+type EvalRequest struct {
+	// Model is the model name.
+	Model string `json:"model"`
+
+	// Task is the evaluation to run: "exact_match", "perplexity" or
+	// "multiple_choice".
+	Task string `json:"task"`
+
+	// Items are the questions of the exact_match and multiple_choice tasks.
+	Items []EvalItem `json:"items,omitempty"`
+
+	// Text is the text the perplexity task measures the model's perplexity
+	// on.
+	Text string `json:"text,omitempty"`
+
+	// Options lists model-specific options.
+	Options map[string]any `json:"options"`
+
+	// KeepAlive controls how long the model will stay loaded into memory
+	// following the request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// Stream enables streaming of returned progress; true by default.
+	Stream *bool `json:"stream,omitempty"`
+}
+
+// EvalItem is a question of an evaluation task.
+type EvalItem struct {
+	// Prompt is the question. For exact_match it is formatted with the
+	// model's template, and for multiple_choice each choice is appended to
+	// it as is.
+	Prompt string `json:"prompt"`
+
+	// Answer is the expected response of an exact_match question.
+	Answer string `json:"answer,omitempty"`
+
+	// Choices are the possible continuations of the prompt of a
+	// multiple_choice question and Label is the index of the correct one.
+	Choices []string `json:"choices,omitempty"`
+	Label   int      `json:"label,omitempty"`
+}
+
+// EvalResponse is the progress of an evaluation. The final response has the
+// report.
+type EvalResponse struct {
+	Status    string      `json:"status"`
+	Total     int         `json:"total,omitempty"`
+	Completed int         `json:"completed,omitempty"`
+	Report    *EvalReport `json:"report,omitempty"`
+}
+
+// EvalReport is the result of an evaluation.
+type EvalReport struct {
+	Model       string       `json:"model"`
+	Task        string       `json:"task"`
+	Fingerprint *Fingerprint `json:"fingerprint,omitempty"`
+
+	// Count is the number of items evaluated. Correct is the number the
+	// model got right and Accuracy the fraction, for exact_match and
+	// multiple_choice.
+	Count    int      `json:"count"`
+	Correct  int      `json:"correct,omitempty"`
+	Accuracy *float64 `json:"accuracy,omitempty"`
+
+	// Tokens is the number of tokens scored by the perplexity task and
+	// Perplexity the model's perplexity on them.
+	Tokens     int     `json:"tokens,omitempty"`
+	Perplexity float64 `json:"perplexity,omitempty"`
+
+	Duration time.Duration `json:"duration"`
+
+	// Results are the results of each item, in order.
+	Results []EvalResult `json:"results,omitempty"`
+}
+
+// FinetuneRequest is the request passed to [Client.Finetune].
+type FinetuneRequest struct {
+	// Model is the name of the base model.
+	Model string `json:"model"`
+
+	// Output is the name of the model created from the base model and the
+	// trained adapter.
+	Output string `json:"output"`
+
+	// Dataset holds the training examples as JSON lines. Each line has a
+	// prompt and a response, or messages ending with the assistant's
+	// response. Both are formatted with the model's template and only the
+	// response is trained on.
+	Dataset string `json:"dataset"`
+
+	// Epochs is the number of passes over the dataset.
+	Epochs int `json:"epochs,omitempty"`
+
+	// LearningRate is the learning rate of the AdamW optimizer.
+	LearningRate float32 `json:"learning_rate,omitempty"`
+
+	// Rank and Alpha are the rank of the adapter and its scale, which is
+	// applied as alpha / rank.
+	Rank  int     `json:"rank,omitempty"`
+	Alpha float32 `json:"alpha,omitempty"`
+
+	// Targets are the names of the layers the adapter is trained for, such
+	// as "attn_q" and "attn_v".
+	Targets []string `json:"targets,omitempty"`
+
+	// Seed seeds the initialization of the adapter and the order of the
+	// examples.
+	Seed int `json:"seed,omitempty"`
+
+	// Options lists model-specific options.
+	Options map[string]any `json:"options"`
+
+	// KeepAlive controls how long the model will stay loaded into memory
+	// following the request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// Stream enables streaming of returned progress; true by default.
+	Stream *bool `json:"stream,omitempty"`
+}
+
+// FinetuneResponse is the progress of fine-tuning. Loss is the mean loss of
+// the examples of the current epoch seen so far.
+type FinetuneResponse struct {
+	Status    string  `json:"status"`
+	Epoch     int     `json:"epoch,omitempty"`
+	Total     int     `json:"total,omitempty"`
+	Completed int     `json:"completed,omitempty"`
+	Loss      float32 `json:"loss,omitempty"`
+}
+
+// EvalResult is the result of an [EvalItem].
+type EvalResult struct {
+	// Response is the model's response to an exact_match question.
+	Response string `json:"response,omitempty"`
+
+	// Choice is the index of the choice the model found most likely and
+	// LogProbs the log probability of each choice, for a multiple_choice
+	// question.
+	Choice   int       `json:"choice,omitempty"`
+	LogProbs []float64 `json:"logprobs,omitempty"`
+
+	Correct bool `json:"correct"`
 }
 
 // PushRequest is the request passed to [Client.Push].
@@ -395,6 +1490,89 @@ type PushRequest struct {
 // ListResponse is the response from [Client.List].
 type ListResponse struct {
 	Models []ListModelResponse `json:"models"`
+
+	// Total is the number of models matching the filters of the request,
+	// before Limit and Offset are applied.
+	Total int `json:"total"`
+}
+
+// ListRequest filters, sorts and paginates the models listed by
+// [Client.ListWithOptions]. The zero value lists all models, most recently
+// modified first.
+type ListRequest struct {
+	// Family lists models of this family, such as "llama".
+	Family string
+
+	// Capabilities lists models with all of these capabilities, such as
+	// "tools" or "completion".
+	Capabilities []string
+
+	// MinSize and MaxSize, in bytes, list models of this size when they
+	// are not zero.
+	MinSize int64
+	MaxSize int64
+
+	// ModifiedAfter and ModifiedBefore list models modified in this range
+	// when they are not zero.
+	ModifiedAfter  time.Time
+	ModifiedBefore time.Time
+
+	// Sort is "modified", "name" or "size" and Order "asc" or "desc". Models
+	// are sorted by modification time by default, in descending order unless
+	// sorted by name. Models that sort equally are sorted by name.
+	Sort  string
+	Order string
+
+	// Limit lists at most this many models when it is not zero, skipping
+	// the first Offset of them.
+	Limit  int
+	Offset int
+}
+
+// Query returns the query parameters of r for /api/tags.
+func (r ListRequest) Query() url.Values {
+	q := url.Values{}
+	if r.Family != "" {
+		q.Set("family", r.Family)
+	}
+
+	for _, c := range r.Capabilities {
+		q.Add("capability", c)
+	}
+
+	if r.MinSize != 0 {
+		q.Set("min_size", strconv.FormatInt(r.MinSize, 10))
+	}
+
+	if r.MaxSize != 0 {
+		q.Set("max_size", strconv.FormatInt(r.MaxSize, 10))
+	}
+
+	if !r.ModifiedAfter.IsZero() {
+		q.Set("modified_after", r.ModifiedAfter.Format(time.RFC3339))
+	}
+
+	if !r.ModifiedBefore.IsZero() {
+		q.Set("modified_before", r.ModifiedBefore.Format(time.RFC3339))
+	}
+
+	if r.Sort != "" {
+		q.Set("sort", r.Sort)
+	}
+
+	if r.Order != "" {
+		q.Set("order", r.Order)
+	}
+
+	if r.Limit != 0 {
+		q.Set("limit", strconv.Itoa(r.Limit))
+	}
+
+	if r.Offset != 0 {
+		q.Set("offset", strconv.Itoa(r.Offset))
+	}
+
+	return q
 }
 
 // ProcessResponse is the response from [Client.Process].
@@ -412,6 +1590,50 @@ type ListModelResponse struct {
 	Details    ModelDetails `json:"details,omitempty"`
 }
 
+// SearchModelsRequest is the request passed to [Client.SearchModels]. Models
+// match all of its non-zero fields.
+type SearchModelsRequest struct {
+	// Name matches model names, ignoring case. It is a substring of the name
+	// unless it has wildcards, in which case it matches the whole name with
+	// '*' matching any text and '?' any single character.
+	Name string `json:"name,omitempty"`
+
+	// Family matches the model family, such as "llama".
+	Family string `json:"family,omitempty"`
+
+	// Quantization matches the quantization level, such as "Q4_K_M".
+	Quantization string `json:"quantization,omitempty"`
+
+	// MinParameters and MaxParameters match the number of parameters.
+	MinParameters uint64 `json:"min_parameters,omitempty"`
+	MaxParameters uint64 `json:"max_parameters,omitempty"`
+
+	// License is a substring of the license of the model.
+	License string `json:"license,omitempty"`
+
+	// Capabilities are capabilities the model must all have, such as
+	// "tools" or "completion".
+	Capabilities []string `json:"capabilities,omitempty"`
+}
+
+// SearchModelsResponse is the response from [Client.SearchModels].
+type SearchModelsResponse struct {
+	Models []SearchModelResponse `json:"models"`
+}
+
+// SearchModelResponse is a single model description in [SearchModelsResponse].
+type SearchModelResponse struct {
+	Name           string       `json:"name"`
+	Model          string       `json:"model"`
+	ModifiedAt     time.Time    `json:"modified_at"`
+	Size           int64        `json:"size"`
+	Digest         string       `json:"digest"`
+	Details        ModelDetails `json:"details,omitempty"`
+	ParameterCount uint64       `json:"parameter_count"`
+	License        string       `json:"license,omitempty"`
+	Capabilities   []string     `json:"capabilities"`
+}
+
 // ProcessModelResponse is a single model description in [ProcessResponse].
 type ProcessModelResponse struct {
 	Name      string       `json:"name"`
@@ -421,6 +1643,47 @@ type ProcessModelResponse struct {
 	Details   ModelDetails `json:"details,omitempty"`
 	ExpiresAt time.Time    `json:"expires_at"`
 	SizeVRAM  int64        `json:"size_vram"`
+
+	// Loading is true until all of the model's weights have been read and
+	// LoadProgress reports the fraction read so far, from 0 to 1.
+	Loading      bool    `json:"loading,omitempty"`
+	LoadProgress float32 `json:"load_progress,omitempty"`
+
+	// Runtime is the settings the model was loaded with.
+	Runtime *ProcessModelRuntime `json:"runtime,omitempty"`
+}
+
+// ProcessModelRuntime describes the settings a loaded model is running with
+// after the server has applied its defaults and fit the model to the
+// available memory.
+type ProcessModelRuntime struct {
+	// NumCtx is the context length available to each parallel request.
+	NumCtx         int    `json:"num_ctx"`
+	NumBatch       int    `json:"num_batch"`
+	NumUBatch      int    `json:"num_ubatch"`
+	NumParallel    int    `json:"num_parallel"`
+	KvCacheType    string `json:"kv_cache_type"`
+	FlashAttention bool   `json:"flash_attention"`
+
+	// GPULayers is the number of the model's TotalLayers offloaded to GPUs.
+	GPULayers   int `json:"gpu_layers"`
+	TotalLayers int `json:"total_layers"`
+
+	// UnifiedMemory is set when the model was loaded into CUDA managed
+	// memory because it is larger than the free VRAM, see
+	// OLLAMA_UNIFIED_MEMORY. Parts of it are then paged between system
+	// memory and VRAM, which is slower.
+	UnifiedMemory bool `json:"unified_memory,omitempty"`
+
+	// StreamWeights is set when the model is larger than system memory and
+	// its weights are streamed from disk as it runs, see
+	// OLLAMA_STREAM_WEIGHTS.
+	StreamWeights bool `json:"stream_weights,omitempty"`
+
+	// Engine is "ollama" or "llama.cpp" and Library is the compute library
+	// the runner uses, such as "cuda_v12" or "cpu".
+	Engine  string `json:"engine"`
+	Library string `json:"library"`
 }
 
 type RetrieveModelResponse struct {
@@ -455,6 +1718,29 @@ type GenerateResponse struct {
 	// can be sent in the next request to keep a conversational memory.
 	Context []int `json:"context,omitempty"`
 
+	// Continuation is the token to resume this generation with if it is
+	// interrupted, when [GenerateRequest.Checkpoint] is set.
+	Continuation string `json:"continuation,omitempty"`
+
+	// Progress is an estimate between 0 and 1 of how much of the response
+	// has been generated, when [GenerateRequest.Progress] is set. It only
+	// reaches 1 when Done is true.
+	Progress float64 `json:"progress,omitempty"`
+
+	// Logprobs are the log probabilities of the tokens of Response, when
+	// [GenerateRequest.Logprobs] is set.
+	Logprobs []Logprob `json:"logprobs,omitempty"`
+
+	// Fingerprint identifies the model, template and engine that generated
+	// the response.
+	Fingerprint *Fingerprint `json:"fingerprint,omitempty"`
+
+	// StreamSummary is set on the final message of a stream.
+	StreamSummary *StreamSummary `json:"summary,omitempty"`
+
+	// Warnings are set on the final message of a stream.
+	Warnings []Warning `json:"warnings,omitempty"`
+
 	Metrics
 }
 
@@ -501,6 +1787,14 @@ func (m *Metrics) Summary() {
 		fmt.Fprintf(os.Stderr, "eval duration:        %s\n", m.EvalDuration)
 		fmt.Fprintf(os.Stderr, "eval rate:            %.2f tokens/s\n", float64(m.EvalCount)/m.EvalDuration.Seconds())
 	}
+
+	if m.Energy > 0 {
+		fmt.Fprintf(os.Stderr, "energy:               %.1f J\n", m.Energy)
+	}
+
+	if m.GPUTemperature > 0 {
+		fmt.Fprintf(os.Stderr, "gpu temperature:      %.0f °C\n", m.GPUTemperature)
+	}
 }
 
 func (opts *Options) FromMap(m map[string]interface{}) error {
@@ -621,6 +1915,7 @@ func DefaultOptions() Options {
 			// options set when the model is loaded
 			NumCtx:    int(envconfig.ContextLength()),
 			NumBatch:  512,
+			NumUBatch: 0,  // let the runtime decide
 			NumGPU:    -1, // -1 here indicates that NumGPU should be set dynamically
 			NumThread: 0,  // let the runtime decide
 			LowVRAM:   false,
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"errors"
 	"math"
+	"net/http"
+	"strings"
 	"testing"
 	"time"
 
@@ -231,3 +233,57 @@ func TestMessage_UnmarshalJSON(t *testing.T) {
 		}
 	}
 }
+
+func TestSystemFingerprint(t *testing.T) {
+	f := Fingerprint{Digest: "abc", TemplateHash: "def", Engine: "ollama"}
+
+	fp := f.SystemFingerprint()
+	if !strings.HasPrefix(fp, "fp_") || len(fp) != len("fp_")+12 {
+		t.Errorf("unexpected system fingerprint %q", fp)
+	}
+
+	if f.SystemFingerprint() != fp {
+		t.Error("expected the system fingerprint to be stable")
+	}
+
+	for _, changed := range []Fingerprint{
+		{Digest: "abd", TemplateHash: "def", Engine: "ollama"},
+		{Digest: "abc", TemplateHash: "", Engine: "ollama"},
+		{Digest: "abc", TemplateHash: "def", Engine: "llama.cpp"},
+		{Digest: "abcdef", Engine: "ollama"},
+	} {
+		if changed.SystemFingerprint() == fp {
+			t.Errorf("expected %+v to change the system fingerprint", changed)
+		}
+	}
+}
+
+func TestStatusErrorIs(t *testing.T) {
+	cases := []struct {
+		name string
+		err  StatusError
+		want error
+	}{
+		{"model not found code", StatusError{StatusCode: http.StatusNotFound, Code: ErrorCodeModelNotFound}, ErrModelNotFound},
+		{"model not found message", StatusError{StatusCode: http.StatusNotFound, ErrorMessage: `model "llama3" not found, try pulling it first`}, ErrModelNotFound},
+		{"context exceeded code", StatusError{StatusCode: http.StatusBadRequest, Code: ErrorCodeContextExceeded}, ErrContextExceeded},
+		{"context exceeded message", StatusError{StatusCode: http.StatusBadRequest, ErrorMessage: "input length exceeds maximum context length"}, ErrContextExceeded},
+		{"overloaded code", StatusError{StatusCode: http.StatusServiceUnavailable, Code: ErrorCodeServerOverloaded}, ErrServerOverloaded},
+		{"too many requests", StatusError{StatusCode: http.StatusTooManyRequests}, ErrServerOverloaded},
+		{"out of memory code", StatusError{Code: ErrorCodeOutOfMemory, ErrorMessage: "out of memory"}, ErrOutOfMemory},
+		{"out of memory message", StatusError{StatusCode: http.StatusInternalServerError, ErrorMessage: "out of memory"}, nil},
+		{"other not found", StatusError{StatusCode: http.StatusNotFound, ErrorMessage: `blob "sha256:abc" not found`}, nil},
+		{"other code", StatusError{StatusCode: http.StatusNotFound, ErrorMessage: "model x not found", Code: "other"}, nil},
+		{"other bad request", StatusError{StatusCode: http.StatusBadRequest, ErrorMessage: "invalid options"}, nil},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, target := range []error{ErrModelNotFound, ErrContextExceeded, ErrServerOverloaded, ErrOutOfMemory} {
+				if got := errors.Is(tt.err, target); got != (target == tt.want) {
+					t.Errorf("errors.Is(%v, %v) = %t", tt.err, target, got)
+				}
+			}
+		})
+	}
+}
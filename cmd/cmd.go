@@ -14,6 +14,7 @@ import (
 	"math"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -35,6 +36,7 @@ import (
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/format"
+	"github.com/ollama/ollama/npipe"
 	"github.com/ollama/ollama/parser"
 	"github.com/ollama/ollama/progress"
 	"github.com/ollama/ollama/runner"
@@ -111,6 +113,19 @@ func CreateHandler(cmd *cobra.Command, args []string) error {
 		req.Quantize = quantize
 	}
 
+	quantizeTensors, _ := cmd.Flags().GetStringArray("quantize-tensor")
+	for _, qt := range quantizeTensors {
+		pattern, t, ok := strings.Cut(qt, "=")
+		if !ok {
+			return fmt.Errorf("invalid tensor quantization %q, expected PATTERN=TYPE", qt)
+		}
+
+		if req.QuantizeTensors == nil {
+			req.QuantizeTensors = make(map[string]string)
+		}
+		req.QuantizeTensors[pattern] = t
+	}
+
 	client, err := api.ClientFromEnvironment()
 	if err != nil {
 		return err
@@ -477,6 +492,64 @@ func ListHandler(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+func SearchHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	registry, err := cmd.Flags().GetString("registry")
+	if err != nil {
+		return err
+	}
+
+	limit, err := cmd.Flags().GetInt("limit")
+	if err != nil {
+		return err
+	}
+
+	asJSON, err := cmd.Flags().GetBool("json")
+	if err != nil {
+		return err
+	}
+
+	var query string
+	if len(args) > 0 {
+		query = args[0]
+	}
+
+	resp, err := client.Search(cmd.Context(), &api.SearchRequest{Query: query, Registry: registry, Limit: limit})
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(resp)
+	}
+
+	var data [][]string
+	for _, m := range resp.Models {
+		for _, t := range m.Tags {
+			data = append(data, []string{t.Name, format.HumanBytes(t.Size), t.Details.ParameterSize, t.Details.QuantizationLevel})
+		}
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"NAME", "SIZE", "PARAMETERS", "QUANTIZATION"})
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetHeaderLine(false)
+	table.SetBorder(false)
+	table.SetNoWhiteSpace(true)
+	table.SetTablePadding("    ")
+	table.AppendBulk(data)
+	table.Render()
+
+	return nil
+}
+
 func ListRunningHandler(cmd *cobra.Command, args []string) error {
 	client, err := api.ClientFromEnvironment()
 	if err != nil {
@@ -508,7 +581,9 @@ func ListRunningHandler(cmd *cobra.Command, args []string) error {
 
 			var until string
 			delta := time.Since(m.ExpiresAt)
-			if delta > 0 {
+			if m.Loading {
+				until = fmt.Sprintf("Loading (%d%%)", int(m.LoadProgress*100))
+			} else if delta > 0 {
 				until = "Stopping..."
 			} else {
 				until = format.HumanTime(m.ExpiresAt, "Never")
@@ -826,6 +901,211 @@ func PullHandler(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+func WarmHandler(cmd *cobra.Command, args []string) error {
+	lock, err := cmd.Flags().GetBool("lock")
+	if err != nil {
+		return err
+	}
+
+	request := api.WarmRequest{Model: args[0], Lock: lock}
+
+	keepAlive, err := cmd.Flags().GetString("keepalive")
+	if err != nil {
+		return err
+	}
+	if keepAlive != "" {
+		d, err := time.ParseDuration(keepAlive)
+		if err != nil {
+			return err
+		}
+		request.KeepAlive = &api.Duration{Duration: d}
+	}
+
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	p := progress.NewProgress(os.Stderr)
+	defer p.Stop()
+
+	bars := make(map[string]*progress.Bar)
+
+	fn := func(resp api.ProgressResponse) error {
+		if resp.Digest == "" {
+			return nil
+		}
+
+		bar, ok := bars[resp.Digest]
+		if !ok {
+			bar = progress.NewBar(resp.Status+"...", resp.Total, resp.Completed)
+			bars[resp.Digest] = bar
+			p.Add(resp.Digest, bar)
+		}
+
+		bar.Set(resp.Completed)
+		return nil
+	}
+
+	if err := client.Warm(cmd.Context(), &request, fn); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func VerifyHandler(cmd *cobra.Command, args []string) error {
+	golden, err := cmd.Flags().GetBool("golden")
+	if err != nil {
+		return err
+	}
+
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	p := progress.NewProgress(os.Stderr)
+	defer p.Stop()
+
+	bars := make(map[string]*progress.Bar)
+
+	var status string
+	var spinner *progress.Spinner
+
+	fn := func(resp api.ProgressResponse) error {
+		if resp.Digest != "" {
+			if spinner != nil {
+				spinner.Stop()
+			}
+
+			bar, ok := bars[resp.Digest]
+			if !ok {
+				bar = progress.NewBar(resp.Status+"...", resp.Total, resp.Completed)
+				bars[resp.Digest] = bar
+				p.Add(resp.Digest, bar)
+			}
+
+			bar.Set(resp.Completed)
+		} else if status != resp.Status {
+			if spinner != nil {
+				spinner.Stop()
+			}
+
+			status = resp.Status
+			spinner = progress.NewSpinner(status)
+			p.Add(status, spinner)
+		}
+
+		return nil
+	}
+
+	request := api.VerifyRequest{Model: args[0], Golden: golden}
+	if err := client.Verify(cmd.Context(), &request, fn); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// readEvalItems reads the items of an evaluation task from a file with one
+// JSON object per line.
+func readEvalItems(path string) ([]api.EvalItem, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var items []api.EvalItem
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		var item api.EvalItem
+		if err := json.Unmarshal(scanner.Bytes(), &item); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+
+		items = append(items, item)
+	}
+
+	return items, scanner.Err()
+}
+
+func EvalHandler(cmd *cobra.Command, args []string) error {
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return err
+	}
+
+	request := api.EvalRequest{Model: args[0], Task: args[1]}
+	if request.Task == "perplexity" {
+		bts, err := os.ReadFile(args[2])
+		if err != nil {
+			return err
+		}
+
+		request.Text = string(bts)
+	} else {
+		request.Items, err = readEvalItems(args[2])
+		if err != nil {
+			return err
+		}
+	}
+
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	p := progress.NewProgress(os.Stderr)
+	defer p.Stop()
+
+	var bar *progress.Bar
+	var report *api.EvalReport
+	fn := func(resp api.EvalResponse) error {
+		if resp.Report != nil {
+			report = resp.Report
+			return nil
+		}
+
+		if bar == nil {
+			bar = progress.NewBar(resp.Status+"...", int64(resp.Total), int64(resp.Completed))
+			p.Add(resp.Status, bar)
+		}
+
+		bar.Set(int64(resp.Completed))
+		return nil
+	}
+
+	if err := client.Eval(cmd.Context(), &request, fn); err != nil {
+		return err
+	}
+
+	p.Stop()
+
+	if report == nil {
+		return errors.New("no report received")
+	}
+
+	bts, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	bts = append(bts, '\n')
+
+	if output != "" {
+		return os.WriteFile(output, bts, 0o644)
+	}
+
+	_, err = os.Stdout.Write(bts)
+	return err
+}
+
 type generateContextKey string
 
 type runOptions struct {
@@ -1077,12 +1357,18 @@ func RunServer(_ *cobra.Command, _ []string) error {
 		return err
 	}
 
-	ln, err := net.Listen("tcp", envconfig.Host().Host)
-	if err != nil {
-		return err
+	var lns []net.Listener
+	for _, host := range envconfig.Hosts() {
+		ln, err := listen(host)
+		if err != nil {
+			return err
+		}
+		defer ln.Close()
+
+		lns = append(lns, ln)
 	}
 
-	err = server.Serve(ln)
+	err := server.Serve(lns...)
 	if errors.Is(err, http.ErrServerClosed) {
 		return nil
 	}
@@ -1090,6 +1376,39 @@ func RunServer(_ *cobra.Command, _ []string) error {
 	return err
 }
 
+// listen creates a listener for a single OLLAMA_HOST address
+func listen(host *url.URL) (net.Listener, error) {
+	if host.Scheme == "npipe" {
+		return npipe.Listen(host.Path)
+	}
+
+	if host.Scheme == "unix" {
+		// remove a stale socket left behind by a previous server
+		if err := os.Remove(host.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+
+		return net.Listen("unix", host.Path)
+	}
+
+	ln, err := net.Listen("tcp", host.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	if host.Scheme == "https" {
+		tln, err := server.ListenTLS(ln, host)
+		if err != nil {
+			ln.Close()
+			return nil, err
+		}
+
+		return tln, nil
+	}
+
+	return ln, nil
+}
+
 func initializeKeypair() error {
 	home, err := os.UserHomeDir()
 	if err != nil {
@@ -1225,6 +1544,7 @@ func NewCLI() *cobra.Command {
 
 	createCmd.Flags().StringP("file", "f", "", "Name of the Modelfile (default \"Modelfile\"")
 	createCmd.Flags().StringP("quantize", "q", "", "Quantize model to this level (e.g. q4_0)")
+	createCmd.Flags().StringArray("quantize-tensor", nil, "Quantize tensors matching a pattern to a type (e.g. 'blk.*.attn_v.weight=q6_K'), may be repeated")
 
 	showCmd := &cobra.Command{
 		Use:     "show MODEL",
@@ -1281,6 +1601,47 @@ func NewCLI() *cobra.Command {
 
 	pullCmd.Flags().Bool("insecure", false, "Use an insecure registry")
 
+	warmCmd := &cobra.Command{
+		Use:     "warm MODEL",
+		Short:   "Read a model into memory ahead of its first use",
+		Args:    cobra.ExactArgs(1),
+		PreRunE: checkServerHeartbeat,
+		RunE:    WarmHandler,
+	}
+
+	warmCmd.Flags().Bool("lock", false, "Lock the model in memory so it cannot be evicted")
+	warmCmd.Flags().String("keepalive", "", "Duration to keep the model locked in memory (e.g. 5m)")
+
+	verifyCmd := &cobra.Command{
+		Use:     "verify MODEL",
+		Short:   "Check a model's files for corruption",
+		Args:    cobra.ExactArgs(1),
+		PreRunE: checkServerHeartbeat,
+		RunE:    VerifyHandler,
+	}
+
+	verifyCmd.Flags().Bool("golden", false, "Also compare the output of a short prompt to the output recorded on the first run")
+
+	evalCmd := &cobra.Command{
+		Use:   "eval MODEL TASK FILE",
+		Short: "Evaluate a model on a task and report the results as JSON",
+		Long: `Evaluate a model on a task and report the results as JSON.
+
+Tasks:
+  exact_match      Compare the model's answers to the questions in FILE to the expected answers
+  multiple_choice  Pick the most likely choice for each question in FILE
+  perplexity       Measure the model's perplexity on the text in FILE
+
+For exact_match and multiple_choice, FILE has one JSON object per line, such as
+{"prompt": "What is the capital of France?", "answer": "Paris"} or
+{"prompt": "The capital of France is", "choices": [" Paris", " Lyon"], "label": 0}.`,
+		Args:    cobra.ExactArgs(3),
+		PreRunE: checkServerHeartbeat,
+		RunE:    EvalHandler,
+	}
+
+	evalCmd.Flags().StringP("output", "o", "", "Write the report to a file instead of stdout")
+
 	pushCmd := &cobra.Command{
 		Use:     "push MODEL",
 		Short:   "Push a model to a registry",
@@ -1299,6 +1660,18 @@ func NewCLI() *cobra.Command {
 		RunE:    ListHandler,
 	}
 
+	searchCmd := &cobra.Command{
+		Use:     "search [TERM]",
+		Short:   "Search a registry for models",
+		Args:    cobra.MaximumNArgs(1),
+		PreRunE: checkServerHeartbeat,
+		RunE:    SearchHandler,
+	}
+
+	searchCmd.Flags().String("registry", "", "Registry to search, optionally with a namespace (e.g. registry.ollama.ai/library)")
+	searchCmd.Flags().Int("limit", 0, "Maximum number of models to show (default 20)")
+	searchCmd.Flags().Bool("json", false, "Output the results as JSON")
+
 	psCmd := &cobra.Command{
 		Use:     "ps",
 		Short:   "List running models",
@@ -1322,6 +1695,22 @@ func NewCLI() *cobra.Command {
 		RunE:    DeleteHandler,
 	}
 
+	doctorCmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Collect debug information for a bug report",
+		Long: `Collect debug information for a bug report into a tarball, including version
+and build information, devices, configuration, models, recent server logs and
+the result of a short test generation. Secrets, prompts and the home directory
+are redacted.`,
+		Args: cobra.NoArgs,
+		RunE: DoctorHandler,
+	}
+
+	doctorCmd.Flags().StringP("output", "o", "", "Path of the tarball (default ollama-doctor-TIME.tar.gz)")
+	doctorCmd.Flags().String("model", "", "Model to run the test generation with (default a running or the smallest model)")
+	doctorCmd.Flags().StringSlice("log", nil, "Server log files to include (default the logs of the desktop app or the systemd journal)")
+	doctorCmd.Flags().Bool("no-generate", false, "Skip the test generation")
+
 	runnerCmd := &cobra.Command{
 		Use:    "runner",
 		Hidden: true,
@@ -1344,11 +1733,16 @@ func NewCLI() *cobra.Command {
 		runCmd,
 		stopCmd,
 		pullCmd,
+		warmCmd,
+		verifyCmd,
+		evalCmd,
 		pushCmd,
 		listCmd,
+		searchCmd,
 		psCmd,
 		copyCmd,
 		deleteCmd,
+		doctorCmd,
 		serveCmd,
 	} {
 		switch cmd {
@@ -1365,13 +1759,60 @@ func NewCLI() *cobra.Command {
 				envVars["OLLAMA_NUM_PARALLEL"],
 				envVars["OLLAMA_NOPRUNE"],
 				envVars["OLLAMA_ORIGINS"],
+				envVars["OLLAMA_CORS_METHODS"],
+				envVars["OLLAMA_CORS_HEADERS"],
+				envVars["OLLAMA_CORS_CREDENTIALS"],
+				envVars["OLLAMA_CORS_CONFIG"],
 				envVars["OLLAMA_SCHED_SPREAD"],
 				envVars["OLLAMA_TMPDIR"],
 				envVars["OLLAMA_FLASH_ATTENTION"],
 				envVars["OLLAMA_KV_CACHE_TYPE"],
+				envVars["OLLAMA_KV_CACHE_DIR"],
 				envVars["OLLAMA_LLM_LIBRARY"],
 				envVars["OLLAMA_GPU_OVERHEAD"],
 				envVars["OLLAMA_LOAD_TIMEOUT"],
+				envVars["OLLAMA_PROGRESSIVE_LOAD"],
+				envVars["OLLAMA_LOAD_BANDWIDTH"],
+				envVars["OLLAMA_LOAD_LOW_PRIORITY"],
+				envVars["OLLAMA_VERIFY_TENSORS"],
+				envVars["OLLAMA_QUANT_FALLBACK"],
+				envVars["OLLAMA_ADDR_FILE"],
+				envVars["OLLAMA_BASE_PATH"],
+				envVars["OLLAMA_TLS_CERT"],
+				envVars["OLLAMA_TLS_KEY"],
+				envVars["OLLAMA_ACME_DOMAINS"],
+				envVars["OLLAMA_ACME_EMAIL"],
+				envVars["OLLAMA_UPDATE_INTERVAL"],
+				envVars["OLLAMA_UPDATE_POLICY"],
+				envVars["OLLAMA_UPDATE_WEBHOOK"],
+				envVars["OLLAMA_MAX_DOWNLOAD_RATE"],
+				envVars["OLLAMA_MAX_UPLOAD_RATE"],
+				envVars["OLLAMA_RATE_WINDOWS"],
+				envVars["OLLAMA_MODELS_READONLY"],
+				envVars["OLLAMA_SCRATCH_DIR"],
+				envVars["OLLAMA_BLOB_STORE"],
+				envVars["OLLAMA_BLOB_STORE_ENDPOINT"],
+				envVars["OLLAMA_BLOB_CACHE_SIZE"],
+				envVars["OLLAMA_PREFETCH_FILE"],
+				envVars["OLLAMA_HIBERNATE_FILE"],
+				envVars["OLLAMA_DRAIN_TIMEOUT"],
+				envVars["OLLAMA_RUNNER_SANDBOX"],
+				envVars["OLLAMA_CRASH_DIR"],
+				envVars["OLLAMA_LOG_LEVEL"],
+				envVars["OLLAMA_LOG_FORMAT"],
+				envVars["OLLAMA_SELF_TEST"],
+				envVars["OLLAMA_STREAM_BUFFER"],
+				envVars["OLLAMA_MAX_EMBED_BATCH"],
+				envVars["OLLAMA_READ_HEADER_TIMEOUT"],
+				envVars["OLLAMA_READ_TIMEOUT"],
+				envVars["OLLAMA_WRITE_TIMEOUT"],
+				envVars["OLLAMA_IDLE_TIMEOUT"],
+				envVars["OLLAMA_MAX_HEADER_BYTES"],
+				envVars["OLLAMA_MAX_CONNECTIONS"],
+				envVars["OLLAMA_ALLOW_REMOTE"],
+				envVars["OLLAMA_ALLOWED_IPS"],
+				envVars["OLLAMA_DENIED_IPS"],
+				envVars["OLLAMA_ROUTERS"],
 			})
 		default:
 			appendEnvDocs(cmd, envs)
@@ -1385,11 +1826,16 @@ func NewCLI() *cobra.Command {
 		runCmd,
 		stopCmd,
 		pullCmd,
+		warmCmd,
+		verifyCmd,
+		evalCmd,
 		pushCmd,
 		listCmd,
+		searchCmd,
 		psCmd,
 		copyCmd,
 		deleteCmd,
+		doctorCmd,
 		runnerCmd,
 	)
 
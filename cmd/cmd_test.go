@@ -16,6 +16,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/format"
 )
 
 func TestShowInfo(t *testing.T) {
@@ -635,6 +636,94 @@ func TestListHandler(t *testing.T) {
 	}
 }
 
+func TestSearchHandler(t *testing.T) {
+	resp := api.SearchResponse{
+		Models: []api.SearchResult{
+			{
+				Name: "llama3",
+				Tags: []api.SearchResultTag{
+					{Name: "llama3:8b", Tag: "8b", Size: 4 * format.GigaByte, Details: api.ModelDetails{ParameterSize: "8B", QuantizationLevel: "Q4_0"}},
+					{Name: "llama3:70b", Tag: "70b", Size: 40 * format.GigaByte, Details: api.ModelDetails{ParameterSize: "70B", QuantizationLevel: "Q4_0"}},
+				},
+			},
+		},
+	}
+
+	var got api.SearchRequest
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/search" || r.Method != http.MethodPost {
+			t.Errorf("unexpected request to %s %s", r.Method, r.URL.Path)
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer mockServer.Close()
+
+	t.Setenv("OLLAMA_HOST", mockServer.URL)
+
+	search := func(t *testing.T, args ...string) string {
+		t.Helper()
+
+		cmd := &cobra.Command{}
+		cmd.SetContext(context.TODO())
+		cmd.Flags().String("registry", "", "")
+		cmd.Flags().Int("limit", 0, "")
+		cmd.Flags().Bool("json", false, "")
+		if err := cmd.Flags().Parse(args); err != nil {
+			t.Fatal(err)
+		}
+
+		oldStdout := os.Stdout
+		r, w, _ := os.Pipe()
+		os.Stdout = w
+
+		err := SearchHandler(cmd, cmd.Flags().Args())
+
+		w.Close()
+		os.Stdout = oldStdout
+		output, _ := io.ReadAll(r)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return string(output)
+	}
+
+	t.Run("table", func(t *testing.T) {
+		output := search(t, "llama", "--limit", "5", "--registry", "example.com/myns")
+		expected := "NAME          SIZE     PARAMETERS    QUANTIZATION \n" +
+			"llama3:8b     4 GB     8B            Q4_0            \n" +
+			"llama3:70b    40 GB    70B           Q4_0            \n"
+		if output != expected {
+			t.Errorf("expected output:\n%s\ngot:\n%s", expected, output)
+		}
+
+		if want := (api.SearchRequest{Query: "llama", Registry: "example.com/myns", Limit: 5}); got != want {
+			t.Errorf("expected request %+v, got %+v", want, got)
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var actual api.SearchResponse
+		if err := json.Unmarshal([]byte(search(t, "--json")), &actual); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(actual.Models) != 1 || len(actual.Models[0].Tags) != 2 || actual.Models[0].Tags[1].Name != "llama3:70b" {
+			t.Errorf("unexpected output %+v", actual)
+		}
+	})
+}
+
 func TestCreateHandler(t *testing.T) {
 	tests := []struct {
 		name           string
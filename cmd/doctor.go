@@ -0,0 +1,463 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/progress"
+	"github.com/ollama/ollama/version"
+)
+
+// doctorLogSize is how much of the end of each server log is collected
+const doctorLogSize = 1 << 20
+
+// doctorDevices returns the device inventory of the bundle. It is a variable
+// so tests can avoid discovering GPUs.
+var doctorDevices = func() any {
+	return discover.GetSystemInfo()
+}
+
+// doctorPrompt is the prompt of the self-test generation. Its answer is
+// short and well known, which makes garbled output easy to spot.
+const doctorPrompt = "What is the capital of France? Answer in one word."
+
+// doctorSummary is the summary.json file of a debug bundle.
+type doctorSummary struct {
+	Time          time.Time         `json:"time"`
+	ClientVersion string            `json:"client_version"`
+	ServerVersion string            `json:"server_version,omitempty"`
+	Errors        map[string]string `json:"errors,omitempty"`
+}
+
+// doctorBundle writes the files of a debug bundle to a gzipped tarball.
+type doctorBundle struct {
+	tw      *tar.Writer
+	dir     string
+	time    time.Time
+	summary doctorSummary
+}
+
+// add adds a file to the bundle, after redacting it.
+func (b *doctorBundle) add(name string, data []byte) error {
+	data = []byte(redact(string(data)))
+	if err := b.tw.WriteHeader(&tar.Header{
+		Name:    b.dir + "/" + name,
+		Mode:    0o600,
+		Size:    int64(len(data)),
+		ModTime: b.time,
+	}); err != nil {
+		return err
+	}
+
+	_, err := b.tw.Write(data)
+	return err
+}
+
+// addJSON adds the value returned by fn to the bundle as indented JSON, or
+// records the error it returned in the summary.
+func (b *doctorBundle) addJSON(name string, fn func() (any, error)) error {
+	v, err := fn()
+	if err != nil {
+		b.summary.Errors[name] = err.Error()
+		return nil
+	}
+
+	bts, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return b.add(name, append(bts, '\n'))
+}
+
+func DoctorHandler(cmd *cobra.Command, _ []string) error {
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return err
+	}
+
+	modelName, err := cmd.Flags().GetString("model")
+	if err != nil {
+		return err
+	}
+
+	logs, err := cmd.Flags().GetStringSlice("log")
+	if err != nil {
+		return err
+	}
+
+	noGenerate, err := cmd.Flags().GetBool("no-generate")
+	if err != nil {
+		return err
+	}
+
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	name := "ollama-doctor-" + now.Format("20060102T150405Z")
+	if output == "" {
+		output = name + ".tar.gz"
+	}
+
+	f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gw := gzip.NewWriter(f)
+	b := &doctorBundle{
+		tw:   tar.NewWriter(gw),
+		dir:  name,
+		time: now,
+		summary: doctorSummary{
+			Time:          now,
+			ClientVersion: version.Version,
+			Errors:        make(map[string]string),
+		},
+	}
+
+	p := progress.NewProgress(os.Stderr)
+	var spinner *progress.Spinner
+	step := func(status string) {
+		if spinner != nil {
+			spinner.Stop()
+		}
+
+		spinner = progress.NewSpinner(status)
+		p.Add(status, spinner)
+	}
+
+	ctx := cmd.Context()
+	err = func() error {
+		step("collecting version information")
+		serverVersion, err := client.Version(ctx)
+		if err != nil {
+			b.summary.Errors["server"] = err.Error()
+		}
+		b.summary.ServerVersion = serverVersion
+
+		if err := b.addJSON("version.json", func() (any, error) { return doctorVersion(serverVersion), nil }); err != nil {
+			return err
+		}
+
+		step("collecting device inventory")
+		if err := b.addJSON("devices.json", func() (any, error) { return doctorDevices(), nil }); err != nil {
+			return err
+		}
+
+		step("collecting configuration")
+		if err := b.addJSON("config.json", func() (any, error) { return doctorConfig(ctx, client), nil }); err != nil {
+			return err
+		}
+
+		step("collecting models")
+		if err := b.addJSON("models.json", func() (any, error) { return client.List(ctx) }); err != nil {
+			return err
+		}
+
+		if err := b.addJSON("running.json", func() (any, error) { return client.ListRunning(ctx) }); err != nil {
+			return err
+		}
+
+		step("collecting logs")
+		if err := doctorLogs(ctx, b, logs); err != nil {
+			return err
+		}
+
+		if !noGenerate && serverVersion != "" {
+			step("running a test generation")
+			if err := b.addJSON("generate.json", func() (any, error) { return doctorGenerate(ctx, client, modelName) }); err != nil {
+				return err
+			}
+		}
+
+		return b.addJSON("summary.json", func() (any, error) { return b.summary, nil })
+	}()
+
+	if spinner != nil {
+		spinner.Stop()
+	}
+	p.Stop()
+
+	if err == nil {
+		err = errors.Join(b.tw.Close(), gw.Close(), f.Close())
+	}
+
+	if err != nil {
+		os.Remove(output)
+		return err
+	}
+
+	fmt.Printf("Wrote %s\n", output)
+	fmt.Println("Secrets, prompts and the home directory are redacted, but please review the bundle before attaching it to an issue.")
+	for _, section := range slices.Sorted(maps.Keys(b.summary.Errors)) {
+		fmt.Fprintf(os.Stderr, "Warning: %s: %s\n", section, b.summary.Errors[section])
+	}
+
+	return nil
+}
+
+// doctorVersion describes the client and server builds.
+func doctorVersion(serverVersion string) any {
+	v := struct {
+		ClientVersion string            `json:"client_version"`
+		ServerVersion string            `json:"server_version,omitempty"`
+		OS            string            `json:"os"`
+		Arch          string            `json:"arch"`
+		GoVersion     string            `json:"go_version"`
+		Build         map[string]string `json:"build,omitempty"`
+	}{
+		ClientVersion: version.Version,
+		ServerVersion: serverVersion,
+		OS:            runtime.GOOS,
+		Arch:          runtime.GOARCH,
+		GoVersion:     runtime.Version(),
+	}
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		v.Build = make(map[string]string)
+		for _, setting := range info.Settings {
+			v.Build[setting.Key] = setting.Value
+		}
+	}
+
+	return v
+}
+
+// doctorConfig returns the configuration of the environment of the command,
+// which is usually the environment of the server too, and the log levels of
+// the server.
+func doctorConfig(ctx context.Context, client *api.Client) any {
+	env := envconfig.Values()
+	for k := range env {
+		if reSecretName.MatchString(k) && env[k] != "" {
+			env[k] = redacted
+		}
+	}
+
+	config := struct {
+		Environment    map[string]string `json:"environment"`
+		LogLevels      *api.LogLevels    `json:"log_levels,omitempty"`
+		LogLevelsError string            `json:"log_levels_error,omitempty"`
+	}{Environment: env}
+
+	var err error
+	if config.LogLevels, err = client.LogLevels(ctx); err != nil {
+		config.LogLevelsError = err.Error()
+	}
+
+	return config
+}
+
+// doctorLogs adds the end of each server log to the bundle.
+func doctorLogs(ctx context.Context, b *doctorBundle, paths []string) error {
+	if len(paths) == 0 {
+		paths = serverLogPaths()
+	}
+
+	for _, path := range paths {
+		data, err := tailFile(path, doctorLogSize)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			b.summary.Errors["logs/"+filepath.Base(path)] = err.Error()
+			continue
+		}
+
+		if err := b.add("logs/"+filepath.Base(path), data); err != nil {
+			return err
+		}
+	}
+
+	// the server is usually a systemd service on Linux, which logs to the journal
+	if runtime.GOOS == "linux" {
+		if _, err := exec.LookPath("journalctl"); err == nil {
+			out, err := exec.CommandContext(ctx, "journalctl", "--unit", "ollama", "--no-pager", "--lines", "5000").Output()
+			if err == nil && len(bytes.TrimSpace(out)) > 0 && !bytes.HasPrefix(out, []byte("-- No entries --")) {
+				return b.add("logs/journal.log", tail(out, doctorLogSize))
+			}
+		}
+	}
+
+	return nil
+}
+
+// serverLogPaths returns the paths the desktop apps write server logs to.
+func serverLogPaths() []string {
+	var dir string
+	switch runtime.GOOS {
+	case "windows":
+		dir = filepath.Join(os.Getenv("LOCALAPPDATA"), "Ollama")
+	case "darwin":
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+
+		dir = filepath.Join(home, ".ollama", "logs")
+	default:
+		return nil
+	}
+
+	// the current log followed by the most recent rotated one
+	return []string{filepath.Join(dir, "server.log"), filepath.Join(dir, "server-1.log")}
+}
+
+// tailFile returns up to the last n bytes of the file at path.
+func tailFile(path string, n int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if fi.Size() > n {
+		if _, err := f.Seek(-n, io.SeekEnd); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(f, n))
+	if err != nil {
+		return nil, err
+	}
+
+	return tail(data, n), nil
+}
+
+// tail returns up to the last n bytes of data, starting at a line.
+func tail(data []byte, n int64) []byte {
+	if int64(len(data)) <= n {
+		return data
+	}
+
+	data = data[int64(len(data))-n:]
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		data = data[i+1:]
+	}
+
+	return data
+}
+
+// doctorGenerate generates a short response with the model name, or with a
+// running or the smallest local model, to check that inference works. It
+// only fails if there is no model to test, errors of the generation are
+// part of its result.
+func doctorGenerate(ctx context.Context, client *api.Client, name string) (any, error) {
+	if name == "" {
+		if running, err := client.ListRunning(ctx); err == nil && len(running.Models) > 0 {
+			name = running.Models[0].Name
+		}
+	}
+
+	if name == "" {
+		models, err := client.List(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(models.Models) == 0 {
+			return nil, errors.New("no models to test")
+		}
+
+		name = slices.MinFunc(models.Models, func(a, b api.ListModelResponse) int {
+			return cmp.Compare(a.Size, b.Size)
+		}).Name
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	stream := false
+	var resp api.GenerateResponse
+	err := client.Generate(ctx, &api.GenerateRequest{
+		Model:   name,
+		Prompt:  doctorPrompt,
+		Stream:  &stream,
+		Options: map[string]any{"temperature": 0, "num_predict": 16},
+	}, func(r api.GenerateResponse) error {
+		resp = r
+		return nil
+	})
+
+	result := struct {
+		Model    string `json:"model"`
+		Prompt   string `json:"prompt"`
+		Response string `json:"response,omitempty"`
+		Error    string `json:"error,omitempty"`
+		api.Metrics
+	}{Model: name, Prompt: doctorPrompt, Response: resp.Response, Metrics: resp.Metrics}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	return result, nil
+}
+
+const redacted = "[redacted]"
+
+var (
+	// reSecretName matches the names of variables that hold secrets
+	reSecretName = regexp.MustCompile(`(?i)key|token|secret|password|credential`)
+
+	reUserinfo = regexp.MustCompile(`(://)[^/\s@"]+@`)
+	reBearer   = regexp.MustCompile(`(?i)(bearer\s+)[^\s"]+`)
+	reSecret   = regexp.MustCompile(`(?i)((?:api[_-]?key|token|secret|password)"?\s*[:=]\s*"?)[^\s",]+`)
+
+	// rePrompt matches prompts and messages in text and JSON log lines,
+	// which are logged at the debug level
+	rePrompt = regexp.MustCompile(`((?:\b|")(?:prompt|content|system)"?[=:]\s*)("(?:[^"\\]|\\.)*"|[^\s"]+)`)
+)
+
+// redact removes credentials, prompts and the home directory of the user
+// from s. The prompt of the test generation is kept.
+func redact(s string) string {
+	s = reUserinfo.ReplaceAllString(s, "${1}"+redacted+"@")
+	s = reBearer.ReplaceAllString(s, "${1}"+redacted)
+	s = reSecret.ReplaceAllString(s, "${1}"+redacted)
+	s = rePrompt.ReplaceAllStringFunc(s, func(m string) string {
+		if strings.Contains(m, doctorPrompt) {
+			return m
+		}
+
+		sub := rePrompt.FindStringSubmatch(m)
+		return sub[1] + `"` + redacted + `"`
+	})
+
+	if home, err := os.UserHomeDir(); err == nil && len(home) > 1 {
+		s = strings.ReplaceAll(s, home, "~")
+	}
+
+	return s
+}
@@ -42,6 +42,7 @@ type llamaModel struct {
 	LayerNormEpsilon float32 `json:"layer_norm_epsilon"`
 	NormEpsilon      float32 `json:"norm_epsilon"`
 	HeadDim          uint32  `json:"head_dim"`
+	SlidingWindow    uint32  `json:"sliding_window"`
 }
 
 var _ ModelConverter = (*llamaModel)(nil)
@@ -117,6 +118,10 @@ func (p *llamaModel) KV(t *Tokenizer) ggml.KV {
 		kv["llama.attention.value_length"] = p.HeadDim
 	}
 
+	if p.SlidingWindow > 0 {
+		kv["llama.attention.sliding_window"] = p.SlidingWindow
+	}
+
 	return kv
 }
 
@@ -0,0 +1,115 @@
+// Package crash reports panics of the server and runners in a form that can
+// be attached to bug reports.
+package crash
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/version"
+)
+
+// Report describes a panic. It leaves out the values of arguments and the
+// request, which may contain prompts or credentials.
+type Report struct {
+	Time      time.Time `json:"time"`
+	Version   string    `json:"version"`
+	OS        string    `json:"os"`
+	Arch      string    `json:"arch"`
+	GoVersion string    `json:"go_version"`
+
+	// Component is the process that panicked, such as "server" or "runner"
+	Component string `json:"component"`
+
+	RequestID string `json:"request_id,omitempty"`
+	Method    string `json:"method,omitempty"`
+	Route     string `json:"route,omitempty"`
+
+	Panic string `json:"panic"`
+	Stack string `json:"stack"`
+}
+
+// New returns a report of the panic v. It must be called by the deferred
+// function that recovered v so that the stack includes where v was raised.
+func New(component string, v any) *Report {
+	return &Report{
+		Time:      time.Now().UTC(),
+		Version:   version.Version,
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		GoVersion: runtime.Version(),
+		Component: component,
+		Panic:     fmt.Sprint(v),
+		Stack:     Redact(debug.Stack()),
+	}
+}
+
+// Log logs the report and writes it to OLLAMA_CRASH_DIR, if set.
+func (r *Report) Log() {
+	attrs := []any{"component", r.Component, "panic", r.Panic}
+	if r.RequestID != "" {
+		attrs = append(attrs, "request_id", r.RequestID, "method", r.Method, "route", r.Route)
+	}
+
+	if dir := envconfig.CrashDir(); dir != "" {
+		if path, err := r.Write(dir); err != nil {
+			slog.Warn("failed to write crash report", "error", err)
+		} else {
+			attrs = append(attrs, "report", path)
+		}
+	}
+
+	slog.Error("recovered from panic", append(attrs, "stack", r.Stack)...)
+}
+
+// Write writes the report to a new file in dir and returns its path.
+func (r *Report) Write(dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+
+	id := r.RequestID
+	if id == "" {
+		var b [4]byte
+		rand.Read(b[:])
+		id = hex.EncodeToString(b[:])
+	}
+
+	bts, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("ollama-%s-%s-%s.json", r.Component, r.Time.Format("20060102T150405Z"), id))
+	if err := os.WriteFile(path, append(bts, '\n'), 0o600); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// reArguments matches the arguments of a function call in a stack trace,
+// which are printed as raw words of memory
+var reArguments = regexp.MustCompile(`(?m)^(\S.*)\([^()\n]+\)$`)
+
+// Redact removes the values of arguments and the home directory of the user
+// from a stack trace.
+func Redact(stack []byte) string {
+	s := reArguments.ReplaceAllString(string(stack), "$1(...)")
+	if home, err := os.UserHomeDir(); err == nil && len(home) > 1 {
+		s = strings.ReplaceAll(s, home, "~")
+	}
+
+	return s
+}
@@ -0,0 +1,74 @@
+package crash
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	stack := "goroutine 7 [running]:\n" +
+		"runtime/debug.Stack()\n" +
+		"\t/usr/local/go/src/runtime/debug/stack.go:26 +0x5e\n" +
+		"panic({0x1a2b3c0?, 0xc0000b2018?})\n" +
+		"\t/usr/local/go/src/runtime/panic.go:785 +0x132\n" +
+		"github.com/ollama/ollama/server.(*Server).GenerateHandler(0xc000132000, 0xc0001f4100)\n" +
+		"\t" + home + "/ollama/server/routes.go:120 +0x25\n"
+
+	expect := "goroutine 7 [running]:\n" +
+		"runtime/debug.Stack()\n" +
+		"\t/usr/local/go/src/runtime/debug/stack.go:26 +0x5e\n" +
+		"panic(...)\n" +
+		"\t/usr/local/go/src/runtime/panic.go:785 +0x132\n" +
+		"github.com/ollama/ollama/server.(*Server).GenerateHandler(...)\n" +
+		"\t~/ollama/server/routes.go:120 +0x25\n"
+
+	if got := Redact([]byte(stack)); got != expect {
+		t.Errorf("expected\n%s\ngot\n%s", expect, got)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("OLLAMA_CRASH_DIR", dir)
+
+	func() {
+		defer func() {
+			r := New("server", recover())
+			r.RequestID = "abc"
+			r.Log()
+		}()
+
+		panic("boom")
+	}()
+
+	matches, err := filepath.Glob(filepath.Join(dir, "ollama-server-*-abc.json"))
+	if err != nil || len(matches) != 1 {
+		t.Fatalf("expected one report, got %v %v", matches, err)
+	}
+
+	bts, err := os.ReadFile(matches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var r Report
+	if err := json.Unmarshal(bts, &r); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Panic != "boom" || r.RequestID != "abc" || r.Component != "server" {
+		t.Errorf("unexpected report %+v", r)
+	}
+
+	if !strings.Contains(r.Stack, "crash.TestWrite") {
+		t.Errorf("expected the stack to include the panicking function, got %s", r.Stack)
+	}
+}
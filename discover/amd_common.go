@@ -3,12 +3,17 @@
 package discover
 
 import (
+	"cmp"
 	"errors"
+	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
+
+	"github.com/ollama/ollama/envconfig"
 )
 
 // Determine if the given ROCm lib directory is usable by checking for existence of some glob patterns
@@ -35,6 +40,99 @@ func GetSupportedGFX(libDir string) ([]string, error) {
 	return ret, nil
 }
 
+// gfxTarget is an LLVM target of AMD GPUs such as gfx1031, which is version
+// 10.3.1. The minor version and stepping are single hex digits.
+type gfxTarget struct {
+	major, minor, stepping uint64
+}
+
+// parseGFX parses a target such as gfx90a, ignoring target features such as
+// in gfx90a:xnack-.
+func parseGFX(s string) (gfxTarget, bool) {
+	s, _, _ = strings.Cut(s, ":")
+	s, ok := strings.CutPrefix(s, "gfx")
+	if !ok || len(s) < 3 {
+		return gfxTarget{}, false
+	}
+
+	major, err := strconv.ParseUint(s[:len(s)-2], 10, 64)
+	if err != nil {
+		return gfxTarget{}, false
+	}
+
+	minor, err := strconv.ParseUint(s[len(s)-2:len(s)-1], 16, 64)
+	if err != nil {
+		return gfxTarget{}, false
+	}
+
+	stepping, err := strconv.ParseUint(s[len(s)-1:], 16, 64)
+	if err != nil {
+		return gfxTarget{}, false
+	}
+
+	return gfxTarget{major, minor, stepping}, true
+}
+
+func (t gfxTarget) String() string {
+	return fmt.Sprintf("gfx%d%x%x", t.major, t.minor, t.stepping)
+}
+
+// hsaVersion is the target in the syntax of HSA_OVERRIDE_GFX_VERSION
+func (t gfxTarget) hsaVersion() string {
+	return fmt.Sprintf("%d.%d.%d", t.major, t.minor, t.stepping)
+}
+
+// closestGFX picks the supported target whose kernels a GPU of target gfx
+// runs: gfx itself, or else the supported target of the same major and minor
+// version with the closest lower stepping, as GPUs of a family run the
+// kernels of its earlier steppings. Before RDNA the steppings of gfx9 are
+// different architectures, which only run the kernels of gfx900. It returns
+// false if there is none.
+func closestGFX(gfx string, supported []string) (gfxTarget, bool) {
+	want, ok := parseGFX(gfx)
+	if !ok {
+		return gfxTarget{}, false
+	}
+
+	var best gfxTarget
+	var found bool
+	for _, s := range supported {
+		t, ok := parseGFX(s)
+		if !ok || t.major != want.major || t.minor != want.minor || t.stepping > want.stepping {
+			continue
+		}
+
+		if t != want && want.major < 10 && t.stepping != 0 {
+			continue
+		}
+
+		if !found || t.stepping > best.stepping {
+			best, found = t, true
+		}
+	}
+
+	return best, found
+}
+
+// gfxOverride is the HSA_OVERRIDE_GFX_VERSION set by the user for the GPU
+// with the numeric ID index, or "" if there is none.
+func gfxOverride(index int) string {
+	return cmp.Or(envconfig.Var(fmt.Sprintf("HSA_OVERRIDE_GFX_VERSION_%d", index)), envconfig.HsaOverrideGfxVersion())
+}
+
+// rocmExcluded reports whether OLLAMA_ROCM_EXCLUDE_DEVICES lists the GPU by
+// its ID, numeric ID or target.
+func rocmExcluded(gpu RocmGPUInfo) bool {
+	for _, s := range strings.Split(envconfig.RocmExcludeDevices(), ",") {
+		s = strings.TrimSpace(s)
+		if s != "" && (s == gpu.ID || s == strconv.Itoa(gpu.index) || strings.EqualFold(s, strings.Split(gpu.Compute, ":")[0])) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func commonAMDValidateLibDir() (string, error) {
 	// Favor our bundled version
 
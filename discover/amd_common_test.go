@@ -0,0 +1,63 @@
+//go:build linux || windows
+
+package discover
+
+import "testing"
+
+func TestClosestGFX(t *testing.T) {
+	supported := []string{"gfx900", "gfx906", "gfx90a", "gfx1030", "gfx1100", "gfx1101", "gfx1102"}
+
+	cases := []struct {
+		gfx     string
+		kernels string
+		hsa     string
+	}{
+		{"gfx1030", "gfx1030", "10.3.0"},
+		{"gfx1031", "gfx1030", "10.3.0"},
+		{"gfx1034", "gfx1030", "10.3.0"},
+		{"gfx1103", "gfx1102", "11.0.2"},
+		{"gfx90c", "gfx900", "9.0.0"},
+		{"gfx908", "gfx900", "9.0.0"},
+		{"gfx90a:sramecc+:xnack-", "gfx90a", "9.0.10"},
+		{"gfx1010", "", ""},
+		{"gfx1150", "", ""},
+		{"gfx803", "", ""},
+		{"unknown", "", ""},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.gfx, func(t *testing.T) {
+			kernels, ok := closestGFX(tt.gfx, supported)
+			if tt.kernels == "" {
+				if ok {
+					t.Fatalf("expected no kernels, got %s", kernels)
+				}
+				return
+			}
+
+			if !ok || kernels.String() != tt.kernels || kernels.hsaVersion() != tt.hsa {
+				t.Errorf("expected %s (%s), got %s (%s)", tt.kernels, tt.hsa, kernels, kernels.hsaVersion())
+			}
+		})
+	}
+}
+
+func TestRocmExcluded(t *testing.T) {
+	t.Setenv("OLLAMA_ROCM_EXCLUDE_DEVICES", "GPU-4f2a, 2,gfx1034")
+
+	cases := []struct {
+		gpu  RocmGPUInfo
+		want bool
+	}{
+		{RocmGPUInfo{GpuInfo: GpuInfo{ID: "GPU-4f2a", Compute: "gfx1100"}, index: 0}, true},
+		{RocmGPUInfo{GpuInfo: GpuInfo{ID: "GPU-9c1b", Compute: "gfx1100"}, index: 2}, true},
+		{RocmGPUInfo{GpuInfo: GpuInfo{ID: "1", Compute: "gfx1034"}, index: 1}, true},
+		{RocmGPUInfo{GpuInfo: GpuInfo{ID: "GPU-9c1b", Compute: "gfx1030"}, index: 1}, false},
+	}
+
+	for _, tt := range cases {
+		if got := rocmExcluded(tt.gpu); got != tt.want {
+			t.Errorf("%+v: expected %v, got %v", tt.gpu, tt.want, got)
+		}
+	}
+}
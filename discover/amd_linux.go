@@ -10,7 +10,6 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
-	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -38,6 +37,11 @@ const (
 	DRMUniqueIDFile = "unique_id"
 	DRMVendorFile   = "vendor"
 	DRMDeviceFile   = "device"
+
+	// Hardware monitor of the device, prefixed with the device dir
+	DRMHwmonDirGlob      = "hwmon/hwmon*"
+	HwmonPowerFiles      = "power1_average power1_input" // microwatts
+	HwmonTemperatureFile = "temp1_input"                 // millidegrees Celsius
 )
 
 var (
@@ -75,7 +79,6 @@ func AMDGetGPUInfo() ([]RocmGPUInfo, error) {
 		visibleDevices = strings.Split(gpuDO, ",")
 	}
 
-	gfxOverride := envconfig.HsaOverrideGfxVersion()
 	var supported []string
 	var libDir string
 
@@ -339,6 +342,17 @@ func AMDGetGPUInfo() ([]RocmGPUInfo, error) {
 			}
 		}
 
+		if rocmExcluded(gpuInfo) {
+			reason := "filtering out device per OLLAMA_ROCM_EXCLUDE_DEVICES"
+			slog.Info(reason, "id", gpuInfo.ID, "gpu_type", gpuInfo.Compute)
+			unsupportedGPUs = append(unsupportedGPUs, UnsupportedGPUInfo{
+				GpuInfo: gpuInfo.GpuInfo,
+				Reason:  reason,
+			})
+
+			continue
+		}
+
 		// Final validation is gfx compatibility - load the library if we haven't already loaded it
 		// even if the user overrides, we still need to validate the library
 		if libDir == "" {
@@ -355,7 +369,7 @@ func AMDGetGPUInfo() ([]RocmGPUInfo, error) {
 		}
 		gpuInfo.DependencyPath = []string{libDir}
 
-		if gfxOverride == "" {
+		if override := gfxOverride(gpuInfo.index); override == "" {
 			// Only load supported list once
 			if len(supported) == 0 {
 				supported, err = GetSupportedGFX(libDir)
@@ -371,7 +385,8 @@ func AMDGetGPUInfo() ([]RocmGPUInfo, error) {
 				slog.Debug("rocm supported GPUs", "types", supported)
 			}
 			gfx := gpuInfo.Compute
-			if !slices.Contains[[]string, string](supported, gfx) {
+			kernels, ok := closestGFX(gfx, supported)
+			if !ok {
 				reason := fmt.Sprintf("amdgpu is not supported (supported types:%s)", supported)
 				slog.Warn(reason, "gpu_type", gfx, "gpu", gpuInfo.ID, "library", libDir)
 				unsupportedGPUs = append(unsupportedGPUs, UnsupportedGPUInfo{
@@ -382,11 +397,17 @@ func AMDGetGPUInfo() ([]RocmGPUInfo, error) {
 				// TODO - consider discrete markdown just for ROCM troubleshooting?
 				slog.Warn("See https://github.com/ollama/ollama/blob/main/docs/gpu.md#overrides for HSA_OVERRIDE_GFX_VERSION usage")
 				continue
+			}
+
+			if kernels.String() != gfx {
+				// run the kernels of a compatible target, as if the user had set the override
+				gpuInfo.EnvWorkarounds = append(gpuInfo.EnvWorkarounds, [2]string{fmt.Sprintf("HSA_OVERRIDE_GFX_VERSION_%d", gpuInfo.index), kernels.hsaVersion()})
+				slog.Info("amdgpu is not supported, using kernels of a compatible target", "gpu", gpuInfo.ID, "gpu_type", gfx, "kernels", kernels.String(), "HSA_OVERRIDE_GFX_VERSION", kernels.hsaVersion())
 			} else {
-				slog.Info("amdgpu is supported", "gpu", gpuInfo.ID, "gpu_type", gfx)
+				slog.Info("amdgpu is supported", "gpu", gpuInfo.ID, "gpu_type", gfx, "kernels", kernels.String())
 			}
 		} else {
-			slog.Info("skipping rocm gfx compatibility check", "HSA_OVERRIDE_GFX_VERSION", gfxOverride)
+			slog.Info("skipping rocm gfx compatibility check", "gpu", gpuInfo.ID, "gpu_type", gpuInfo.Compute, "HSA_OVERRIDE_GFX_VERSION", override)
 		}
 
 		// Check for env var workarounds
@@ -491,6 +512,38 @@ func (gpus RocmGPUInfoList) RefreshFreeMemory() error {
 	return nil
 }
 
+// Telemetry samples the power draw and temperature of the GPUs from the
+// hardware monitor of the amdgpu driver
+func (gpus RocmGPUInfoList) Telemetry() []GpuTelemetry {
+	var telemetry []GpuTelemetry
+	for _, gpu := range gpus {
+		if gpu.usedFilepath == "" {
+			continue
+		}
+
+		dirs, _ := filepath.Glob(filepath.Join(filepath.Dir(gpu.usedFilepath), DRMHwmonDirGlob))
+		if len(dirs) == 0 {
+			continue
+		}
+
+		t := GpuTelemetry{Library: gpu.Library, ID: gpu.ID, Name: gpu.Name}
+		for _, name := range strings.Fields(HwmonPowerFiles) {
+			if microwatts, err := getFreeMemory(filepath.Join(dirs[0], name)); err == nil {
+				t.Watts = float64(microwatts) / 1e6
+				break
+			}
+		}
+
+		if millidegrees, err := getFreeMemory(filepath.Join(dirs[0], HwmonTemperatureFile)); err == nil {
+			t.Temperature = float64(millidegrees) / 1e3
+		}
+
+		telemetry = append(telemetry, t)
+	}
+
+	return telemetry
+}
+
 func getFreeMemory(usedFile string) (uint64, error) {
 	buf, err := os.ReadFile(usedFile)
 	if err != nil {
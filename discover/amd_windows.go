@@ -58,8 +58,7 @@ func AMDGetGPUInfo() ([]RocmGPUInfo, error) {
 	}
 
 	var supported []string
-	gfxOverride := envconfig.HsaOverrideGfxVersion()
-	if gfxOverride == "" {
+	if override := envconfig.HsaOverrideGfxVersion(); override == "" {
 		supported, err = GetSupportedGFX(libDir)
 		if err != nil {
 			err = fmt.Errorf("failed to lookup supported GFX types: %w", err)
@@ -67,7 +66,7 @@ func AMDGetGPUInfo() ([]RocmGPUInfo, error) {
 			return nil, err
 		}
 	} else {
-		slog.Info("skipping rocm gfx compatibility check", "HSA_OVERRIDE_GFX_VERSION", gfxOverride)
+		slog.Info("skipping rocm gfx compatibility check", "HSA_OVERRIDE_GFX_VERSION", override)
 	}
 
 	slog.Debug("detected hip devices", "count", count)
@@ -132,6 +131,16 @@ func AMDGetGPUInfo() ([]RocmGPUInfo, error) {
 			continue
 		}
 
+		if rocmExcluded(gpuInfo) {
+			reason := "filtering out device per OLLAMA_ROCM_EXCLUDE_DEVICES"
+			slog.Info(reason, "id", gpuInfo.ID, "gpu_type", gfx)
+			unsupportedGPUs = append(unsupportedGPUs, UnsupportedGPUInfo{
+				GpuInfo: gpuInfo.GpuInfo,
+				Reason:  reason,
+			})
+			continue
+		}
+
 		// Strip off Target Features when comparing
 		if !slices.Contains[[]string, string](supported, strings.Split(gfx, ":")[0]) {
 			reason := fmt.Sprintf("amdgpu is not supported (supported types:%s)", supported)
@@ -140,10 +149,13 @@ func AMDGetGPUInfo() ([]RocmGPUInfo, error) {
 				GpuInfo: gpuInfo.GpuInfo,
 				Reason:  reason,
 			})
-			// HSA_OVERRIDE_GFX_VERSION not supported on windows
+			// HSA_OVERRIDE_GFX_VERSION not supported on windows, so the kernels of a compatible target can't be used
+			if kernels, ok := closestGFX(gfx, supported); ok {
+				slog.Warn("amdgpu has no kernels of its own, the kernels of a compatible target are only usable on linux", "gpu", gpuInfo.ID, "gpu_type", gfx, "compatible", kernels.String())
+			}
 			continue
 		} else {
-			slog.Debug("amdgpu is supported", "gpu", i, "gpu_type", gfx)
+			slog.Info("amdgpu is supported", "gpu", gpuInfo.ID, "gpu_type", gfx, "kernels", strings.Split(gfx, ":")[0])
 		}
 
 		slog.Debug("amdgpu memory", "gpu", i, "total", format.HumanBytes2(totalMemory))
@@ -173,6 +185,11 @@ func AMDValidateLibDir() (string, error) {
 	return "", errors.New("no suitable rocm found, falling back to CPU")
 }
 
+// Telemetry is not supported for AMD GPUs on windows
+func (gpus RocmGPUInfoList) Telemetry() []GpuTelemetry {
+	return nil
+}
+
 func (gpus RocmGPUInfoList) RefreshFreeMemory() error {
 	if len(gpus) == 0 {
 		return nil
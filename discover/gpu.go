@@ -16,6 +16,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -61,6 +62,11 @@ var (
 	// Keep track of errors during bootstrapping so that if GPUs are missing
 	// they expected to be present this may explain why
 	bootstrapErrors []error
+
+	// The management library stays loaded for GetGPUTelemetry, which is
+	// called frequently while models are running
+	telemetryNVML       *C.nvml_handle_t
+	telemetryNVMLLoaded bool
 )
 
 // With our current CUDA compile flags, older than 5.0 will not work properly
@@ -495,6 +501,53 @@ func GetGPUInfo() GpuInfoList {
 	return resp
 }
 
+// GetGPUTelemetry samples the power draw and temperature of the GPUs found by
+// GetGPUInfo that report them.
+func GetGPUTelemetry() []GpuTelemetry {
+	gpuMutex.Lock()
+	defer gpuMutex.Unlock()
+	if !bootstrapped {
+		return nil
+	}
+
+	var telemetry []GpuTelemetry
+	if len(cudaGPUs) > 0 {
+		if !telemetryNVMLLoaded {
+			telemetryNVMLLoaded = true
+			libPaths := []string{nvmlLibPath}
+			if nvmlLibPath == "" {
+				libPaths = FindGPULibs(NvmlTelemetryName, NvmlTelemetryGlobs)
+			}
+
+			if len(libPaths) > 0 {
+				telemetryNVML, _, _ = loadNVMLMgmt(libPaths)
+			}
+		}
+
+		if telemetryNVML != nil {
+			for _, gpu := range cudaGPUs {
+				var milliwatts, celsius C.uint
+				uuid := C.CString(gpu.ID)
+				C.nvml_get_power(*telemetryNVML, uuid, &milliwatts, &celsius)
+				C.free(unsafe.Pointer(uuid))
+
+				telemetry = append(telemetry, GpuTelemetry{
+					Library:     gpu.Library,
+					ID:          gpu.ID,
+					Name:        gpu.Name,
+					Watts:       float64(milliwatts) / 1e3,
+					Temperature: float64(celsius),
+				})
+			}
+		}
+	}
+
+	telemetry = append(telemetry, RocmGPUInfoList(rocmGPUs).Telemetry()...)
+	return slices.DeleteFunc(telemetry, func(t GpuTelemetry) bool {
+		return t.Watts == 0 && t.Temperature == 0
+	})
+}
+
 func FindGPULibs(baseLibName string, defaultPatterns []string) []string {
 	// Multiple GPU libraries may exist, and some may not work, so keep trying until we exhaust them
 	gpuLibPaths := []string{}
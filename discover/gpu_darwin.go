@@ -11,7 +11,11 @@ import "C"
 
 import (
 	"log/slog"
+	"os"
+	"os/exec"
 	"runtime"
+	"strconv"
+	"strings"
 	"syscall"
 
 	"github.com/ollama/ollama/format"
@@ -44,6 +48,35 @@ func GetGPUInfo() GpuInfoList {
 	return []GpuInfo{info}
 }
 
+// GetGPUTelemetry samples the power draw of the GPU with powermetrics, which
+// only works when running as root. The GPU temperature isn't reported.
+func GetGPUTelemetry() []GpuTelemetry {
+	if runtime.GOARCH == "amd64" || os.Geteuid() != 0 {
+		return nil
+	}
+
+	out, err := exec.Command("powermetrics", "--samplers", "gpu_power", "-i", "100", "-n", "1").Output()
+	if err != nil {
+		slog.Debug("failed to sample gpu power", "error", err)
+		return nil
+	}
+
+	for line := range strings.Lines(string(out)) {
+		// e.g. "GPU Power: 1234 mW"
+		if v, ok := strings.CutPrefix(strings.TrimSpace(line), "GPU Power:"); ok {
+			milliwatts, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(v), "mW")), 64)
+			if err != nil {
+				slog.Debug("failed to parse gpu power", "line", line, "error", err)
+				return nil
+			}
+
+			return []GpuTelemetry{{Library: "metal", ID: "0", Watts: milliwatts / 1e3}}
+		}
+	}
+
+	return nil
+}
+
 func GetCPUInfo() GpuInfoList {
 	mem, _ := GetCPUMem()
 	return []GpuInfo{
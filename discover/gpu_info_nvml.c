@@ -19,6 +19,8 @@ void nvml_init(char *nvml_lib_path, nvml_init_resp_t *resp) {
       {"nvmlShutdown", (void *)&resp->ch.nvmlShutdown},
       {"nvmlDeviceGetHandleByUUID", (void *)&resp->ch.nvmlDeviceGetHandleByUUID},
       {"nvmlDeviceGetMemoryInfo", (void *)&resp->ch.nvmlDeviceGetMemoryInfo},
+      {"nvmlDeviceGetPowerUsage", (void *)&resp->ch.nvmlDeviceGetPowerUsage},
+      {"nvmlDeviceGetTemperature", (void *)&resp->ch.nvmlDeviceGetTemperature},
       {NULL, NULL},
   };
 
@@ -89,6 +91,31 @@ void nvml_get_free(nvml_handle_t h, char *uuid, uint64_t *free, uint64_t *total,
     *used = memInfo.used;
 }
 
+// nvml_get_power reports the power draw and temperature of a GPU, leaving
+// zero for values the GPU doesn't support
+void nvml_get_power(nvml_handle_t h, char *uuid, unsigned int *milliwatts, unsigned int *celsius) {
+    nvmlDevice_t device;
+    nvmlReturn_t ret;
+    *milliwatts = 0;
+    *celsius = 0;
+    ret = (*h.nvmlDeviceGetHandleByUUID)((const char *)(uuid), &device);
+    if (ret != NVML_SUCCESS) {
+        LOG(h.verbose, "unable to get device handle %s: %d", uuid, ret);
+        return;
+    }
+
+    ret = (*h.nvmlDeviceGetPowerUsage)(device, milliwatts);
+    if (ret != NVML_SUCCESS) {
+        LOG(h.verbose, "device power usage lookup failure %s: %d", uuid, ret);
+        *milliwatts = 0;
+    }
+
+    ret = (*h.nvmlDeviceGetTemperature)(device, NVML_TEMPERATURE_GPU, celsius);
+    if (ret != NVML_SUCCESS) {
+        LOG(h.verbose, "device temperature lookup failure %s: %d", uuid, ret);
+        *celsius = 0;
+    }
+}
 
 void nvml_release(nvml_handle_t h) {
   LOG(h.verbose, "releasing nvml library\n");
@@ -20,6 +20,11 @@ typedef enum nvmlBrandType_enum
     NVML_BRAND_UNKNOWN          = 0,
 } nvmlBrandType_t;
 
+typedef enum nvmlTemperatureSensors_enum
+{
+    NVML_TEMPERATURE_GPU        = 0,
+} nvmlTemperatureSensors_t;
+
 typedef struct nvml_handle {
   void *handle;
   uint16_t verbose;
@@ -27,6 +32,8 @@ typedef struct nvml_handle {
   nvmlReturn_t (*nvmlShutdown)(void);
   nvmlReturn_t (*nvmlDeviceGetHandleByUUID)(const char *, nvmlDevice_t *);
   nvmlReturn_t (*nvmlDeviceGetMemoryInfo)(nvmlDevice_t, nvmlMemory_t *);
+  nvmlReturn_t (*nvmlDeviceGetPowerUsage)(nvmlDevice_t, unsigned int *);
+  nvmlReturn_t (*nvmlDeviceGetTemperature)(nvmlDevice_t, nvmlTemperatureSensors_t, unsigned int *);
 } nvml_handle_t;
 
 typedef struct nvml_init_resp {
@@ -42,6 +49,7 @@ typedef struct nvml_compute_capability {
 
 void nvml_init(char *nvml_lib_path, nvml_init_resp_t *resp);
 void nvml_get_free(nvml_handle_t ch, char *uuid, uint64_t *free, uint64_t *total, uint64_t *used);
+void nvml_get_power(nvml_handle_t ch, char *uuid, unsigned int *milliwatts, unsigned int *celsius);
 void nvml_release(nvml_handle_t ch);
 
 #endif  // __GPU_INFO_NVML_H__
@@ -30,6 +30,16 @@ var CudartGlobs = []string{
 
 var NvmlGlobs = []string{}
 
+// NvmlTelemetryGlobs locate the management library used to sample GPU power
+// draw and temperature, which is loaded even though discovery doesn't use it
+var NvmlTelemetryGlobs = []string{
+	"/usr/lib/*-linux-gnu/nvidia/current/libnvidia-ml.so*",
+	"/usr/lib/*-linux-gnu/libnvidia-ml.so*",
+	"/usr/lib/wsl/lib/libnvidia-ml.so*",
+	"/usr/lib*/libnvidia-ml.so*",
+	"/usr/local/lib*/libnvidia-ml.so*",
+}
+
 var NvcudaGlobs = []string{
 	"/usr/local/cuda*/targets/*/lib/libcuda.so*",
 	"/usr/lib/*-linux-gnu/nvidia/current/libcuda.so*",
@@ -52,6 +62,8 @@ var (
 	NvcudaMgmtName = "libcuda.so*"
 	NvmlMgmtName   = "" // not currently wired on linux
 	OneapiMgmtName = "libze_intel_gpu.so*"
+
+	NvmlTelemetryName = "libnvidia-ml.so*"
 )
 
 func GetCPUMem() (memInfo, error) {
@@ -94,6 +94,16 @@ type OneapiGPUInfoList []OneapiGPUInfo
 
 type GpuInfoList []GpuInfo
 
+// GpuTelemetry is a sample of the power draw and temperature of a GPU. Values
+// the GPU or its driver don't report are zero.
+type GpuTelemetry struct {
+	Library     string  `json:"library"`
+	ID          string  `json:"gpu_id"`
+	Name        string  `json:"name"`
+	Watts       float64 `json:"watts"`
+	Temperature float64 `json:"temperature"` // degrees Celsius
+}
+
 type UnsupportedGPUInfo struct {
 	GpuInfo
 	Reason string `json:"reason"`
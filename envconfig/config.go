@@ -17,19 +17,45 @@ import (
 // Host returns the scheme and host. Host can be configured via the OLLAMA_HOST environment variable.
 // Default is scheme "http" and host "127.0.0.1:11434"
 func Host() *url.URL {
+	return Hosts()[0]
+}
+
+// Hosts returns the addresses the server listens on. Multiple addresses can be configured by
+// separating them with commas in the OLLAMA_HOST environment variable, e.g.
+// "127.0.0.1:11434,192.168.1.10:11434,unix:///run/ollama.sock". On Windows, a named pipe may be specified with
+// npipe:////./pipe/<name>. Clients use the first address.
+func Hosts() []*url.URL {
+	var hosts []*url.URL
+	for _, s := range strings.Split(Var("OLLAMA_HOST"), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			hosts = append(hosts, parseHost(s))
+		}
+	}
+
+	if len(hosts) == 0 {
+		hosts = append(hosts, parseHost(""))
+	}
+
+	return hosts
+}
+
+func parseHost(s string) *url.URL {
 	defaultPort := "11434"
 
-	s := strings.TrimSpace(Var("OLLAMA_HOST"))
+	s = strings.Trim(strings.TrimSpace(s), "\"'")
 	scheme, hostport, ok := strings.Cut(s, "://")
 	switch {
 	case !ok:
 		scheme, hostport = "http", s
+	case scheme == "unix", scheme == "npipe":
+		return &url.URL{Scheme: scheme, Path: hostport}
 	case scheme == "http":
 		defaultPort = "80"
 	case scheme == "https":
 		defaultPort = "443"
 	}
 
+	hostport, query, _ := strings.Cut(hostport, "?")
 	hostport, path, _ := strings.Cut(hostport, "/")
 	host, port, err := net.SplitHostPort(hostport)
 	if err != nil {
@@ -47,9 +73,10 @@ func Host() *url.URL {
 	}
 
 	return &url.URL{
-		Scheme: scheme,
-		Host:   net.JoinHostPort(host, port),
-		Path:   path,
+		Scheme:   scheme,
+		Host:     net.JoinHostPort(host, port),
+		Path:     path,
+		RawQuery: query,
 	}
 }
 
@@ -79,6 +106,18 @@ func AllowedOrigins() (origins []string) {
 	return origins
 }
 
+// BasePath returns the path prefix the server registers its routes under, normalized to have a
+// leading slash and no trailing slash. BasePath can be configured via the OLLAMA_BASE_PATH
+// environment variable. Default is no prefix.
+func BasePath() string {
+	s := strings.Trim(Var("OLLAMA_BASE_PATH"), "/")
+	if s == "" {
+		return ""
+	}
+
+	return "/" + s
+}
+
 // Models returns the path to the models directory. Models directory can be configured via the OLLAMA_MODELS environment variable.
 // Default is $HOME/.ollama/models
 func Models() string {
@@ -94,6 +133,21 @@ func Models() string {
 	return filepath.Join(home, ".ollama", "models")
 }
 
+// Scratch returns the path to the directory of files written while serving models, such as caches, checksums and
+// checkpoints. Scratch directory can be configured via the OLLAMA_SCRATCH_DIR environment variable.
+// Default is the models directory, or a directory in the system's temporary directory if it is read-only.
+func Scratch() string {
+	if s := Var("OLLAMA_SCRATCH_DIR"); s != "" {
+		return s
+	}
+
+	if ModelsReadOnly() {
+		return filepath.Join(os.TempDir(), "ollama")
+	}
+
+	return Models()
+}
+
 // KeepAlive returns the duration that models stay loaded in memory. KeepAlive can be configured via the OLLAMA_KEEP_ALIVE environment variable.
 // Negative values are treated as infinite. Zero is treated as no keep alive.
 // Default is 5 minutes.
@@ -134,6 +188,90 @@ func LoadTimeout() (loadTimeout time.Duration) {
 	return loadTimeout
 }
 
+// QuantFallback returns what to do when a model does not fit in memory and a smaller quantization of
+// the same model is available locally: "suggest" reports it in a warning and "auto" loads it instead.
+// QuantFallback can be configured via the OLLAMA_QUANT_FALLBACK environment variable.
+// Default is to do neither.
+func QuantFallback() string {
+	switch s := strings.ToLower(Var("OLLAMA_QUANT_FALLBACK")); s {
+	case "suggest", "auto":
+		return s
+	default:
+		return ""
+	}
+}
+
+// SelfTest returns what to do when a device fails the numerical self-test runs when loading a model:
+// "warn" logs a warning, "strict" refuses to load the model and "off" skips the test.
+// SelfTest can be configured via the OLLAMA_SELF_TEST environment variable. Default is "warn".
+func SelfTest() string {
+	switch s := strings.ToLower(Var("OLLAMA_SELF_TEST")); s {
+	case "strict", "off":
+		return s
+	default:
+		return "warn"
+	}
+}
+
+// UpdateInterval returns how often the registry is checked for new versions of local models. UpdateInterval can be
+// configured via the OLLAMA_UPDATE_INTERVAL environment variable.
+// Zero or negative values disable the checks, which is the default.
+func UpdateInterval() (interval time.Duration) {
+	if s := Var("OLLAMA_UPDATE_INTERVAL"); s != "" {
+		if d, err := time.ParseDuration(s); err == nil {
+			interval = d
+		} else if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			interval = time.Duration(n) * time.Second
+		}
+	}
+
+	return max(interval, 0)
+}
+
+// DrainTimeout returns how long the server waits for requests in progress to finish when it is drained or
+// stopped. DrainTimeout can be configured via the OLLAMA_DRAIN_TIMEOUT environment variable.
+// Default is 0, which stops the server without waiting.
+func DrainTimeout() (timeout time.Duration) {
+	if s := Var("OLLAMA_DRAIN_TIMEOUT"); s != "" {
+		if d, err := time.ParseDuration(s); err == nil {
+			timeout = d
+		} else if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			timeout = time.Duration(n) * time.Second
+		}
+	}
+
+	return max(timeout, 0)
+}
+
+// UpdatePolicy returns the update policy of models without one: "auto" pulls updates, "manual" only reports them and
+// "pinned" does not check for them. UpdatePolicy can be configured via the OLLAMA_UPDATE_POLICY environment variable.
+// Default is "manual".
+func UpdatePolicy() string {
+	switch s := strings.ToLower(Var("OLLAMA_UPDATE_POLICY")); s {
+	case "auto", "pinned":
+		return s
+	default:
+		return "manual"
+	}
+}
+
+// RunnerSandbox starts runners with reduced privileges, and without network access except on Windows. It is
+// enabled unless OLLAMA_RUNNER_SANDBOX is false, except on Windows, where it is only enabled if
+// OLLAMA_RUNNER_SANDBOX is true since runners at the low integrity level can't write the files of the server.
+func RunnerSandbox() bool {
+	enabled := runtime.GOOS != "windows"
+	if s := Var("OLLAMA_RUNNER_SANDBOX"); s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return enabled
+		}
+
+		return b
+	}
+
+	return enabled
+}
+
 func Bool(k string) func() bool {
 	return func() bool {
 		if s := Var(k); s != "" {
@@ -156,18 +294,46 @@ var (
 	FlashAttention = Bool("OLLAMA_FLASH_ATTENTION")
 	// KvCacheType is the quantization type for the K/V cache.
 	KvCacheType = String("OLLAMA_KV_CACHE_TYPE")
+	// KvCacheDir is a directory that the K/V cache of prompts with a cache key is saved to, so that it can be
+	// restored rather than computed again after the model is loaded again, such as after the server restarts.
+	KvCacheDir = String("OLLAMA_KV_CACHE_DIR")
 	// NoHistory disables readline history.
 	NoHistory = Bool("OLLAMA_NOHISTORY")
 	// NoPrune disables pruning of model blobs on startup.
 	NoPrune = Bool("OLLAMA_NOPRUNE")
+	// ModelsReadOnly serves models from a read-only models directory, such as one baked into an image, and rejects
+	// pulls, creates and other changes to it.
+	ModelsReadOnly = Bool("OLLAMA_MODELS_READONLY")
 	// SchedSpread allows scheduling models across all GPUs.
 	SchedSpread = Bool("OLLAMA_SCHED_SPREAD")
+	// UnifiedMemory loads models slightly larger than the free VRAM of an Nvidia GPU entirely onto
+	// it with CUDA managed memory, which overflows into system memory, instead of partially.
+	UnifiedMemory = Bool("OLLAMA_UNIFIED_MEMORY")
+	// StreamWeights runs models larger than system memory on the CPU by streaming their weights
+	// from disk as layers are computed. This is experimental and much slower than running from memory.
+	StreamWeights = Bool("OLLAMA_STREAM_WEIGHTS")
 	// IntelGPU enables experimental Intel GPU detection.
 	IntelGPU = Bool("OLLAMA_INTEL_GPU")
 	// MultiUserCache optimizes prompt caching for multi-user scenarios
 	MultiUserCache = Bool("OLLAMA_MULTIUSER_CACHE")
 	// Enable the new Ollama engine
 	NewEngine = Bool("OLLAMA_NEW_ENGINE")
+	// H2C makes clients speak HTTP/2 over cleartext connections instead of HTTP/1.1.
+	H2C = Bool("OLLAMA_H2C")
+	// AllowRemote acknowledges that the server listens on addresses reachable from other machines,
+	// which it refuses to do otherwise since it has no authentication.
+	AllowRemote = Bool("OLLAMA_ALLOW_REMOTE")
+	// CORSCredentials allows browsers to send credentials with cross-origin requests from OLLAMA_ORIGINS.
+	CORSCredentials = Bool("OLLAMA_CORS_CREDENTIALS")
+	// ProgressiveLoad lets the Ollama engine process the first request while the remaining layers
+	// of a model are still loading.
+	ProgressiveLoad = Bool("OLLAMA_PROGRESSIVE_LOAD")
+	// LowPriorityLoad reads models with a low I/O priority so loading does not starve other disk users (Linux only).
+	LowPriorityLoad = Bool("OLLAMA_LOAD_LOW_PRIORITY")
+	// VerifyTensors checks model tensors against their recorded checksums before loading.
+	VerifyTensors = Bool("OLLAMA_VERIFY_TENSORS")
+	// StrictOptions rejects requests with unknown or invalid options instead of ignoring them.
+	StrictOptions = Bool("OLLAMA_STRICT_OPTIONS")
 	// ContextLength sets the default context length
 	ContextLength = Uint("OLLAMA_CONTEXT_LENGTH", 2048)
 )
@@ -180,6 +346,61 @@ func String(s string) func() string {
 
 var (
 	LLMLibrary = String("OLLAMA_LLM_LIBRARY")
+	// AddrFile is a file the server writes its bound addresses to, one per line, once it is
+	// listening. This is useful when binding to port 0. A value of "-" writes to stdout.
+	AddrFile = String("OLLAMA_ADDR_FILE")
+	// TLSCert and TLSKey are the certificate and private key files served by https listeners.
+	TLSCert = String("OLLAMA_TLS_CERT")
+	TLSKey  = String("OLLAMA_TLS_KEY")
+	// ACMEDomains is a comma separated list of domains to request certificates for with ACME
+	// when an https listener has no certificate configured.
+	ACMEDomains = String("OLLAMA_ACME_DOMAINS")
+	// ACMEEmail is an optional contact address for the ACME account.
+	ACMEEmail = String("OLLAMA_ACME_EMAIL")
+	// CORSMethods is a comma separated list of HTTP methods allowed for cross-origin requests.
+	CORSMethods = String("OLLAMA_CORS_METHODS")
+	// AllowedIPs is a comma separated list of client addresses or ranges, such as 192.168.1.0/24, that may
+	// connect to the server. Clients on other addresses are rejected unless they connect over loopback.
+	AllowedIPs = String("OLLAMA_ALLOWED_IPS")
+	// DeniedIPs is a comma separated list of client addresses or ranges that are always rejected.
+	DeniedIPs = String("OLLAMA_DENIED_IPS")
+	// CORSHeaders is a comma separated list of additional request headers allowed for cross-origin requests.
+	CORSHeaders = String("OLLAMA_CORS_HEADERS")
+	// CORSConfig is a JSON file with per-origin CORS rules.
+	CORSConfig = String("OLLAMA_CORS_CONFIG")
+	// UpdateWebhook is a URL that model update notifications are posted to.
+	UpdateWebhook = String("OLLAMA_UPDATE_WEBHOOK")
+	// BlobStore is the URL of a bucket, such as s3://bucket/prefix or gs://bucket/prefix, that models are shared
+	// through. Models are fetched from it on demand, and models pulled or created locally are stored in it.
+	BlobStore = String("OLLAMA_BLOB_STORE")
+	// BlobStoreEndpoint is the URL of an S3 compatible service, such as MinIO, that hosts the bucket of BlobStore.
+	BlobStoreEndpoint = String("OLLAMA_BLOB_STORE_ENDPOINT")
+	// PrefetchFile is the path of a file listing models to pull at startup before the server reports that it is
+	// ready, such as a Kubernetes annotations file mounted with the downward API.
+	PrefetchFile = String("OLLAMA_PREFETCH_FILE")
+	// HibernateFile is the path of a file that the models loaded when the server stops are recorded in, to be loaded
+	// again with the same options and remaining keep alive when it starts, such as after an upgrade. Restore images of
+	// their weights are written next to it.
+	HibernateFile = String("OLLAMA_HIBERNATE_FILE")
+	// Routers is a JSON file defining router models, names that send each request to one of several models picked
+	// by rules or by a classifier model, and fallback models, names that try several models in order.
+	Routers = String("OLLAMA_ROUTERS")
+	// CrashDir is a directory that reports of panics in the server and runners are written to, for attaching to
+	// bug reports.
+	CrashDir = String("OLLAMA_CRASH_DIR")
+	// LogLevel is the level of the server and runner logs, optionally followed by levels of subsystems, e.g.
+	// "info,scheduler=debug". It takes precedence over OLLAMA_DEBUG.
+	LogLevel = String("OLLAMA_LOG_LEVEL")
+	// LogFormat is the format of the server and runner logs, "text" or "json".
+	LogFormat = String("OLLAMA_LOG_FORMAT")
+	// RateWindows is a comma separated list of times when OLLAMA_MAX_DOWNLOAD_RATE and
+	// OLLAMA_MAX_UPLOAD_RATE apply, in local time, e.g. "mon-fri 09:00-17:00,sat 10:00-14:00".
+	// The rates always apply if it is empty.
+	RateWindows = String("OLLAMA_RATE_WINDOWS")
+
+	// RocmExcludeDevices is a comma separated list of AMD GPUs that are never used, by UUID, numeric ID or LLVM
+	// target such as gfx1034.
+	RocmExcludeDevices = String("OLLAMA_ROCM_EXCLUDE_DEVICES")
 
 	CudaVisibleDevices    = String("CUDA_VISIBLE_DEVICES")
 	HipVisibleDevices     = String("HIP_VISIBLE_DEVICES")
@@ -209,6 +430,10 @@ var (
 	MaxRunners = Uint("OLLAMA_MAX_LOADED_MODELS", 0)
 	// MaxQueue sets the maximum number of queued requests. MaxQueue can be configured via the OLLAMA_MAX_QUEUE environment variable.
 	MaxQueue = Uint("OLLAMA_MAX_QUEUE", 512)
+	// StreamBuffer sets how many responses runners buffer for each streaming request before pausing its decoding until the client catches up. StreamBuffer can be configured via the OLLAMA_STREAM_BUFFER environment variable.
+	StreamBuffer = Uint("OLLAMA_STREAM_BUFFER", 100)
+	// MaxEmbedBatch sets the maximum number of inputs of a request to /api/embed/batch. MaxEmbedBatch can be configured via the OLLAMA_MAX_EMBED_BATCH environment variable.
+	MaxEmbedBatch = Uint("OLLAMA_MAX_EMBED_BATCH", 1024)
 	// MaxVRAM sets a maximum VRAM override in bytes. MaxVRAM can be configured via the OLLAMA_MAX_VRAM environment variable.
 	MaxVRAM = Uint("OLLAMA_MAX_VRAM", 0)
 )
@@ -227,8 +452,57 @@ func Uint64(key string, defaultValue uint64) func() uint64 {
 	}
 }
 
-// Set aside VRAM per GPU
-var GpuOverhead = Uint64("OLLAMA_GPU_OVERHEAD", 0)
+var (
+	// Set aside VRAM per GPU
+	GpuOverhead = Uint64("OLLAMA_GPU_OVERHEAD", 0)
+	// LoadBandwidth limits how fast model weights are read from disk, in bytes per second.
+	LoadBandwidth = Uint64("OLLAMA_LOAD_BANDWIDTH", 0)
+	// MaxDownloadRate limits how fast models are pulled, in bytes per second.
+	MaxDownloadRate = Uint64("OLLAMA_MAX_DOWNLOAD_RATE", 0)
+	// MaxUploadRate limits how fast models are pushed, in bytes per second.
+	MaxUploadRate = Uint64("OLLAMA_MAX_UPLOAD_RATE", 0)
+	// MaxTPS limits how fast generated tokens are returned, in tokens per second, to each API key or each request without one.
+	MaxTPS = Uint64("OLLAMA_MAX_TPS", 0)
+	// BlobCacheSize limits the size of the model weights kept on disk when models are fetched from BlobStore, in bytes.
+	// The least recently used weights that are in the bucket are removed to make space.
+	BlobCacheSize = Uint64("OLLAMA_BLOB_CACHE_SIZE", 0)
+)
+
+// Duration returns a duration from the environment variable key, in Go's format such as "30s" or as a
+// number of seconds. Zero or negative values disable what the duration limits and return 0.
+func Duration(key string, defaultValue time.Duration) func() time.Duration {
+	return func() time.Duration {
+		if s := Var(key); s != "" {
+			if d, err := time.ParseDuration(s); err == nil {
+				return max(d, 0)
+			} else if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+				return max(time.Duration(n)*time.Second, 0)
+			}
+
+			slog.Warn("invalid environment variable, using default", "key", key, "value", s, "default", defaultValue)
+		}
+
+		return defaultValue
+	}
+}
+
+var (
+	// ReadHeaderTimeout limits how long clients may take to send the headers of a request.
+	ReadHeaderTimeout = Duration("OLLAMA_READ_HEADER_TIMEOUT", 10*time.Second)
+	// ReadTimeout limits how long clients may stall while sending the body of a request.
+	ReadTimeout = Duration("OLLAMA_READ_TIMEOUT", time.Minute)
+	// WriteTimeout limits how long clients may stall while receiving a response. It is disabled by default.
+	WriteTimeout = Duration("OLLAMA_WRITE_TIMEOUT", 0)
+	// IdleTimeout limits how long connections are kept open between requests.
+	IdleTimeout = Duration("OLLAMA_IDLE_TIMEOUT", 2*time.Minute)
+)
+
+var (
+	// MaxHeaderBytes limits the size of the headers of a request.
+	MaxHeaderBytes = Uint("OLLAMA_MAX_HEADER_BYTES", 1<<20)
+	// MaxConnections limits the number of connections the server accepts at once. It is unlimited by default.
+	MaxConnections = Uint("OLLAMA_MAX_CONNECTIONS", 0)
+)
 
 type EnvVar struct {
 	Name        string
@@ -238,25 +512,77 @@ type EnvVar struct {
 
 func AsMap() map[string]EnvVar {
 	ret := map[string]EnvVar{
-		"OLLAMA_DEBUG":             {"OLLAMA_DEBUG", Debug(), "Show additional debug information (e.g. OLLAMA_DEBUG=1)"},
-		"OLLAMA_FLASH_ATTENTION":   {"OLLAMA_FLASH_ATTENTION", FlashAttention(), "Enabled flash attention"},
-		"OLLAMA_KV_CACHE_TYPE":     {"OLLAMA_KV_CACHE_TYPE", KvCacheType(), "Quantization type for the K/V cache (default: f16)"},
-		"OLLAMA_GPU_OVERHEAD":      {"OLLAMA_GPU_OVERHEAD", GpuOverhead(), "Reserve a portion of VRAM per GPU (bytes)"},
-		"OLLAMA_HOST":              {"OLLAMA_HOST", Host(), "IP Address for the ollama server (default 127.0.0.1:11434)"},
-		"OLLAMA_KEEP_ALIVE":        {"OLLAMA_KEEP_ALIVE", KeepAlive(), "The duration that models stay loaded in memory (default \"5m\")"},
-		"OLLAMA_LLM_LIBRARY":       {"OLLAMA_LLM_LIBRARY", LLMLibrary(), "Set LLM library to bypass autodetection"},
-		"OLLAMA_LOAD_TIMEOUT":      {"OLLAMA_LOAD_TIMEOUT", LoadTimeout(), "How long to allow model loads to stall before giving up (default \"5m\")"},
-		"OLLAMA_MAX_LOADED_MODELS": {"OLLAMA_MAX_LOADED_MODELS", MaxRunners(), "Maximum number of loaded models per GPU"},
-		"OLLAMA_MAX_QUEUE":         {"OLLAMA_MAX_QUEUE", MaxQueue(), "Maximum number of queued requests"},
-		"OLLAMA_MODELS":            {"OLLAMA_MODELS", Models(), "The path to the models directory"},
-		"OLLAMA_NOHISTORY":         {"OLLAMA_NOHISTORY", NoHistory(), "Do not preserve readline history"},
-		"OLLAMA_NOPRUNE":           {"OLLAMA_NOPRUNE", NoPrune(), "Do not prune model blobs on startup"},
-		"OLLAMA_NUM_PARALLEL":      {"OLLAMA_NUM_PARALLEL", NumParallel(), "Maximum number of parallel requests"},
-		"OLLAMA_ORIGINS":           {"OLLAMA_ORIGINS", AllowedOrigins(), "A comma separated list of allowed origins"},
-		"OLLAMA_SCHED_SPREAD":      {"OLLAMA_SCHED_SPREAD", SchedSpread(), "Always schedule model across all GPUs"},
-		"OLLAMA_MULTIUSER_CACHE":   {"OLLAMA_MULTIUSER_CACHE", MultiUserCache(), "Optimize prompt caching for multi-user scenarios"},
-		"OLLAMA_CONTEXT_LENGTH":    {"OLLAMA_CONTEXT_LENGTH", ContextLength(), "Context length to use unless otherwise specified (default: 2048)"},
-		"OLLAMA_NEW_ENGINE":        {"OLLAMA_NEW_ENGINE", NewEngine(), "Enable the new Ollama engine"},
+		"OLLAMA_DEBUG":               {"OLLAMA_DEBUG", Debug(), "Show additional debug information (e.g. OLLAMA_DEBUG=1)"},
+		"OLLAMA_FLASH_ATTENTION":     {"OLLAMA_FLASH_ATTENTION", FlashAttention(), "Enabled flash attention"},
+		"OLLAMA_KV_CACHE_TYPE":       {"OLLAMA_KV_CACHE_TYPE", KvCacheType(), "Quantization type for the K/V cache (default: f16)"},
+		"OLLAMA_KV_CACHE_DIR":        {"OLLAMA_KV_CACHE_DIR", KvCacheDir(), "Directory to save the K/V cache of prompts with a cache key in, to restore after the model is loaded again"},
+		"OLLAMA_GPU_OVERHEAD":        {"OLLAMA_GPU_OVERHEAD", GpuOverhead(), "Reserve a portion of VRAM per GPU (bytes)"},
+		"OLLAMA_HOST":                {"OLLAMA_HOST", Hosts(), "Comma separated list of addresses for the ollama server (default 127.0.0.1:11434)"},
+		"OLLAMA_H2C":                 {"OLLAMA_H2C", H2C(), "Use HTTP/2 without TLS when connecting to the server"},
+		"OLLAMA_BASE_PATH":           {"OLLAMA_BASE_PATH", BasePath(), "Path prefix to serve the API under, e.g. /ollama"},
+		"OLLAMA_ADDR_FILE":           {"OLLAMA_ADDR_FILE", AddrFile(), "File to write the bound server addresses to, or \"-\" for stdout"},
+		"OLLAMA_TLS_CERT":            {"OLLAMA_TLS_CERT", TLSCert(), "TLS certificate file for https listeners"},
+		"OLLAMA_TLS_KEY":             {"OLLAMA_TLS_KEY", TLSKey(), "TLS private key file for https listeners"},
+		"OLLAMA_ALLOW_REMOTE":        {"OLLAMA_ALLOW_REMOTE", AllowRemote(), "Allow listening on addresses reachable from other machines"},
+		"OLLAMA_ALLOWED_IPS":         {"OLLAMA_ALLOWED_IPS", AllowedIPs(), "Comma separated list of client addresses or ranges allowed to connect"},
+		"OLLAMA_DENIED_IPS":          {"OLLAMA_DENIED_IPS", DeniedIPs(), "Comma separated list of client addresses or ranges denied to connect"},
+		"OLLAMA_ACME_DOMAINS":        {"OLLAMA_ACME_DOMAINS", ACMEDomains(), "Comma separated list of domains to obtain TLS certificates for with ACME"},
+		"OLLAMA_ACME_EMAIL":          {"OLLAMA_ACME_EMAIL", ACMEEmail(), "Contact email for the ACME account"},
+		"OLLAMA_KEEP_ALIVE":          {"OLLAMA_KEEP_ALIVE", KeepAlive(), "The duration that models stay loaded in memory (default \"5m\")"},
+		"OLLAMA_LLM_LIBRARY":         {"OLLAMA_LLM_LIBRARY", LLMLibrary(), "Set LLM library to bypass autodetection"},
+		"OLLAMA_LOAD_TIMEOUT":        {"OLLAMA_LOAD_TIMEOUT", LoadTimeout(), "How long to allow model loads to stall before giving up (default \"5m\")"},
+		"OLLAMA_MAX_LOADED_MODELS":   {"OLLAMA_MAX_LOADED_MODELS", MaxRunners(), "Maximum number of loaded models per GPU"},
+		"OLLAMA_MAX_QUEUE":           {"OLLAMA_MAX_QUEUE", MaxQueue(), "Maximum number of queued requests"},
+		"OLLAMA_MODELS":              {"OLLAMA_MODELS", Models(), "The path to the models directory"},
+		"OLLAMA_NOHISTORY":           {"OLLAMA_NOHISTORY", NoHistory(), "Do not preserve readline history"},
+		"OLLAMA_NOPRUNE":             {"OLLAMA_NOPRUNE", NoPrune(), "Do not prune model blobs on startup"},
+		"OLLAMA_NUM_PARALLEL":        {"OLLAMA_NUM_PARALLEL", NumParallel(), "Maximum number of parallel requests"},
+		"OLLAMA_READ_HEADER_TIMEOUT": {"OLLAMA_READ_HEADER_TIMEOUT", ReadHeaderTimeout(), "How long clients may take to send request headers (default \"10s\")"},
+		"OLLAMA_READ_TIMEOUT":        {"OLLAMA_READ_TIMEOUT", ReadTimeout(), "How long clients may stall while sending a request body (default \"1m\")"},
+		"OLLAMA_WRITE_TIMEOUT":       {"OLLAMA_WRITE_TIMEOUT", WriteTimeout(), "How long clients may stall while receiving a response (default unlimited)"},
+		"OLLAMA_IDLE_TIMEOUT":        {"OLLAMA_IDLE_TIMEOUT", IdleTimeout(), "How long idle connections are kept open (default \"2m\")"},
+		"OLLAMA_MAX_HEADER_BYTES":    {"OLLAMA_MAX_HEADER_BYTES", MaxHeaderBytes(), "Maximum size of request headers (default 1048576)"},
+		"OLLAMA_MAX_CONNECTIONS":     {"OLLAMA_MAX_CONNECTIONS", MaxConnections(), "Maximum number of open connections (default unlimited)"},
+		"OLLAMA_STREAM_BUFFER":       {"OLLAMA_STREAM_BUFFER", StreamBuffer(), "Responses buffered per streaming request before its decoding pauses (default 100)"},
+		"OLLAMA_MAX_EMBED_BATCH":     {"OLLAMA_MAX_EMBED_BATCH", MaxEmbedBatch(), "Maximum number of inputs of a batch embedding request (default 1024)"},
+		"OLLAMA_ORIGINS":             {"OLLAMA_ORIGINS", AllowedOrigins(), "A comma separated list of allowed origins"},
+		"OLLAMA_CORS_METHODS":        {"OLLAMA_CORS_METHODS", CORSMethods(), "A comma separated list of allowed cross-origin methods"},
+		"OLLAMA_CORS_HEADERS":        {"OLLAMA_CORS_HEADERS", CORSHeaders(), "A comma separated list of additional allowed cross-origin headers"},
+		"OLLAMA_CORS_CREDENTIALS":    {"OLLAMA_CORS_CREDENTIALS", CORSCredentials(), "Allow credentials on cross-origin requests"},
+		"OLLAMA_CORS_CONFIG":         {"OLLAMA_CORS_CONFIG", CORSConfig(), "Path to a JSON file with per-origin CORS rules"},
+		"OLLAMA_SCHED_SPREAD":        {"OLLAMA_SCHED_SPREAD", SchedSpread(), "Always schedule model across all GPUs"},
+		"OLLAMA_UNIFIED_MEMORY":      {"OLLAMA_UNIFIED_MEMORY", UnifiedMemory(), "Load models slightly larger than the free VRAM of an Nvidia GPU into unified memory"},
+		"OLLAMA_STREAM_WEIGHTS":      {"OLLAMA_STREAM_WEIGHTS", StreamWeights(), "Stream weights from disk for CPU models larger than system memory (experimental)"},
+		"OLLAMA_MULTIUSER_CACHE":     {"OLLAMA_MULTIUSER_CACHE", MultiUserCache(), "Optimize prompt caching for multi-user scenarios"},
+		"OLLAMA_CONTEXT_LENGTH":      {"OLLAMA_CONTEXT_LENGTH", ContextLength(), "Context length to use unless otherwise specified (default: 2048)"},
+		"OLLAMA_NEW_ENGINE":          {"OLLAMA_NEW_ENGINE", NewEngine(), "Enable the new Ollama engine"},
+		"OLLAMA_PROGRESSIVE_LOAD":    {"OLLAMA_PROGRESSIVE_LOAD", ProgressiveLoad(), "Start processing requests before all layers of a model are loaded (Ollama engine only)"},
+		"OLLAMA_LOAD_BANDWIDTH":      {"OLLAMA_LOAD_BANDWIDTH", LoadBandwidth(), "Maximum disk read rate while loading a model (bytes/s, Ollama engine only)"},
+		"OLLAMA_LOAD_LOW_PRIORITY":   {"OLLAMA_LOAD_LOW_PRIORITY", LowPriorityLoad(), "Load models with a low I/O priority (Linux only)"},
+		"OLLAMA_RUNNER_SANDBOX":      {"OLLAMA_RUNNER_SANDBOX", RunnerSandbox(), "Start runners with reduced privileges and, except on Windows, without network access (default: true, false on Windows)"},
+		"OLLAMA_VERIFY_TENSORS":      {"OLLAMA_VERIFY_TENSORS", VerifyTensors(), "Verify model tensor checksums before loading"},
+		"OLLAMA_STRICT_OPTIONS":      {"OLLAMA_STRICT_OPTIONS", StrictOptions(), "Reject requests with unknown or out of range options"},
+		"OLLAMA_QUANT_FALLBACK":      {"OLLAMA_QUANT_FALLBACK", QuantFallback(), "Suggest (suggest) or use (auto) a smaller local quantization of models that do not fit in memory"},
+		"OLLAMA_UPDATE_INTERVAL":     {"OLLAMA_UPDATE_INTERVAL", UpdateInterval(), "How often to check the registry for model updates (e.g. 24h, default: never)"},
+		"OLLAMA_UPDATE_POLICY":       {"OLLAMA_UPDATE_POLICY", UpdatePolicy(), "Update policy of models without one: auto, manual or pinned (default: manual)"},
+		"OLLAMA_UPDATE_WEBHOOK":      {"OLLAMA_UPDATE_WEBHOOK", UpdateWebhook(), "URL to post model update notifications to"},
+		"OLLAMA_MODELS_READONLY":     {"OLLAMA_MODELS_READONLY", ModelsReadOnly(), "Serve models from a read-only models directory"},
+		"OLLAMA_SCRATCH_DIR":         {"OLLAMA_SCRATCH_DIR", Scratch(), "The path to writable files such as model caches (default: the models directory)"},
+		"OLLAMA_BLOB_STORE":          {"OLLAMA_BLOB_STORE", BlobStore(), "Bucket to share models through, e.g. s3://bucket/models or gs://bucket/models"},
+		"OLLAMA_BLOB_STORE_ENDPOINT": {"OLLAMA_BLOB_STORE_ENDPOINT", BlobStoreEndpoint(), "URL of an S3 compatible service hosting OLLAMA_BLOB_STORE"},
+		"OLLAMA_BLOB_CACHE_SIZE":     {"OLLAMA_BLOB_CACHE_SIZE", BlobCacheSize(), "Maximum size of model weights cached from OLLAMA_BLOB_STORE (bytes, default: unlimited)"},
+		"OLLAMA_PREFETCH_FILE":       {"OLLAMA_PREFETCH_FILE", PrefetchFile(), "File listing models to pull before the server is ready"},
+		"OLLAMA_HIBERNATE_FILE":      {"OLLAMA_HIBERNATE_FILE", HibernateFile(), "File to record the loaded models in when the server stops, to load them again when it starts"},
+		"OLLAMA_ROUTERS":             {"OLLAMA_ROUTERS", Routers(), "Path to a JSON file defining router and fallback models"},
+		"OLLAMA_DRAIN_TIMEOUT":       {"OLLAMA_DRAIN_TIMEOUT", DrainTimeout(), "How long to wait for requests in progress when the server is drained or stopped (default: 0)"},
+		"OLLAMA_CRASH_DIR":           {"OLLAMA_CRASH_DIR", CrashDir(), "Directory to write crash reports to"},
+		"OLLAMA_LOG_LEVEL":           {"OLLAMA_LOG_LEVEL", LogLevel(), "Log level, optionally per subsystem, e.g. \"info,scheduler=debug\" (default: info)"},
+		"OLLAMA_LOG_FORMAT":          {"OLLAMA_LOG_FORMAT", LogFormat(), "Log format, text or json (default: text)"},
+		"OLLAMA_SELF_TEST":           {"OLLAMA_SELF_TEST", SelfTest(), "Warn (warn) or refuse to load models (strict) when a GPU fails its numerical self-test, or skip it (off)"},
+		"OLLAMA_MAX_DOWNLOAD_RATE":   {"OLLAMA_MAX_DOWNLOAD_RATE", MaxDownloadRate(), "Maximum rate of model pulls (bytes/s)"},
+		"OLLAMA_MAX_UPLOAD_RATE":     {"OLLAMA_MAX_UPLOAD_RATE", MaxUploadRate(), "Maximum rate of model pushes (bytes/s)"},
+		"OLLAMA_MAX_TPS":             {"OLLAMA_MAX_TPS", MaxTPS(), "Maximum rate of generated tokens per API key, or per request without one (tokens/s)"},
+		"OLLAMA_RATE_WINDOWS":        {"OLLAMA_RATE_WINDOWS", RateWindows(), "Times when the pull and push rates are limited, e.g. \"mon-fri 09:00-17:00\" (default: always)"},
 
 		// Informational
 		"HTTP_PROXY":  {"HTTP_PROXY", String("HTTP_PROXY")(), "HTTP proxy"},
@@ -277,6 +603,7 @@ func AsMap() map[string]EnvVar {
 		ret["ROCR_VISIBLE_DEVICES"] = EnvVar{"ROCR_VISIBLE_DEVICES", RocrVisibleDevices(), "Set which AMD devices are visible by UUID or numeric ID"}
 		ret["GPU_DEVICE_ORDINAL"] = EnvVar{"GPU_DEVICE_ORDINAL", GpuDeviceOrdinal(), "Set which AMD devices are visible by numeric ID"}
 		ret["HSA_OVERRIDE_GFX_VERSION"] = EnvVar{"HSA_OVERRIDE_GFX_VERSION", HsaOverrideGfxVersion(), "Override the gfx used for all detected AMD GPUs"}
+		ret["OLLAMA_ROCM_EXCLUDE_DEVICES"] = EnvVar{"OLLAMA_ROCM_EXCLUDE_DEVICES", RocmExcludeDevices(), "Set which AMD devices are never used by UUID, numeric ID or gfx target"}
 		ret["OLLAMA_INTEL_GPU"] = EnvVar{"OLLAMA_INTEL_GPU", IntelGPU(), "Enable experimental Intel GPU detection"}
 	}
 
@@ -2,6 +2,9 @@ package envconfig
 
 import (
 	"math"
+	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
 	"time"
 
@@ -35,6 +38,9 @@ func TestHost(t *testing.T) {
 		"https":               {"https://1.2.3.4", "https://1.2.3.4:443"},
 		"https port":          {"https://1.2.3.4:4321", "https://1.2.3.4:4321"},
 		"proxy path":          {"https://example.com/ollama", "https://example.com:443/ollama"},
+		"unix socket":         {"unix:///run/ollama.sock", "unix:///run/ollama.sock"},
+		"named pipe":          {"npipe:////./pipe/ollama", "npipe:////./pipe/ollama"},
+		"multiple":            {"1.2.3.4:1234,[::1]:1337", "http://1.2.3.4:1234"},
 	}
 
 	for name, tt := range cases {
@@ -47,6 +53,57 @@ func TestHost(t *testing.T) {
 	}
 }
 
+func TestHosts(t *testing.T) {
+	cases := map[string]struct {
+		value  string
+		expect []string
+	}{
+		"empty":          {"", []string{"http://127.0.0.1:11434"}},
+		"single":         {"1.2.3.4", []string{"http://1.2.3.4:11434"}},
+		"multiple":       {"127.0.0.1,192.168.1.10:1234", []string{"http://127.0.0.1:11434", "http://192.168.1.10:1234"}},
+		"unix socket":    {"127.0.0.1:0,unix:///run/ollama.sock", []string{"http://127.0.0.1:0", "unix:///run/ollama.sock"}},
+		"extra commas":   {",1.2.3.4,,", []string{"http://1.2.3.4:11434"}},
+		"extra spaces":   {" 1.2.3.4 , https://5.6.7.8 ", []string{"http://1.2.3.4:11434", "https://5.6.7.8:443"}},
+		"query settings": {"https://0.0.0.0?cert=a.pem", []string{"https://0.0.0.0:443?cert=a.pem"}},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("OLLAMA_HOST", tt.value)
+
+			var actual []string
+			for _, host := range Hosts() {
+				actual = append(actual, host.String())
+			}
+
+			if diff := cmp.Diff(tt.expect, actual); diff != "" {
+				t.Errorf("%s: mismatch (-want +got):\n%s", name, diff)
+			}
+		})
+	}
+}
+
+func TestBasePath(t *testing.T) {
+	cases := map[string]string{
+		"":          "",
+		"/":         "",
+		"ollama":    "/ollama",
+		"/ollama":   "/ollama",
+		"/ollama/":  "/ollama",
+		"/a/b/":     "/a/b",
+		"'/quoted'": "/quoted",
+	}
+
+	for value, expect := range cases {
+		t.Run(value, func(t *testing.T) {
+			t.Setenv("OLLAMA_BASE_PATH", value)
+			if actual := BasePath(); actual != expect {
+				t.Errorf("%s: expected %s, got %s", value, expect, actual)
+			}
+		})
+	}
+}
+
 func TestOrigins(t *testing.T) {
 	cases := []struct {
 		value  string
@@ -167,6 +224,27 @@ func TestBool(t *testing.T) {
 	}
 }
 
+func TestRunnerSandbox(t *testing.T) {
+	enabled := runtime.GOOS != "windows"
+	cases := map[string]bool{
+		"":      enabled,
+		"true":  true,
+		"false": false,
+		"0":     false,
+		// invalid values
+		"random": enabled,
+	}
+
+	for k, v := range cases {
+		t.Run(k, func(t *testing.T) {
+			t.Setenv("OLLAMA_RUNNER_SANDBOX", k)
+			if b := RunnerSandbox(); b != v {
+				t.Errorf("%s: expected %t, got %t", k, v, b)
+			}
+		})
+	}
+}
+
 func TestUint(t *testing.T) {
 	cases := map[string]uint{
 		"0":    0,
@@ -190,6 +268,29 @@ func TestUint(t *testing.T) {
 	}
 }
 
+func TestDuration(t *testing.T) {
+	cases := map[string]time.Duration{
+		"30s": 30 * time.Second,
+		"2m":  2 * time.Minute,
+		"45":  45 * time.Second,
+		"0":   0,
+		"-1s": 0,
+		"-5":  0,
+		// default values
+		"":       10 * time.Second,
+		"string": 10 * time.Second,
+	}
+
+	for k, v := range cases {
+		t.Run(k, func(t *testing.T) {
+			t.Setenv("OLLAMA_DURATION", k)
+			if d := Duration("OLLAMA_DURATION", 10*time.Second)(); d != v {
+				t.Errorf("%s: expected %s, got %s", k, v, d)
+			}
+		})
+	}
+}
+
 func TestKeepAlive(t *testing.T) {
 	cases := map[string]time.Duration{
 		"":       5 * time.Minute,
@@ -257,6 +358,105 @@ func TestLoadTimeout(t *testing.T) {
 	}
 }
 
+func TestScratch(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", "/models")
+
+	cases := []struct {
+		scratch, readOnly string
+		expect            string
+	}{
+		{"", "", "/models"},
+		{"", "1", filepath.Join(os.TempDir(), "ollama")},
+		{"/scratch", "", "/scratch"},
+		{"/scratch", "1", "/scratch"},
+	}
+
+	for _, tt := range cases {
+		t.Setenv("OLLAMA_SCRATCH_DIR", tt.scratch)
+		t.Setenv("OLLAMA_MODELS_READONLY", tt.readOnly)
+		if actual := Scratch(); actual != tt.expect {
+			t.Errorf("%+v: expected %s, got %s", tt, tt.expect, actual)
+		}
+	}
+}
+
+func TestUpdateInterval(t *testing.T) {
+	cases := map[string]time.Duration{
+		"":    0,
+		"24h": 24 * time.Hour,
+		"60":  time.Minute,
+		"0":   0,
+		"-1h": 0,
+		"1d":  0,
+	}
+
+	for tt, expect := range cases {
+		t.Run(tt, func(t *testing.T) {
+			t.Setenv("OLLAMA_UPDATE_INTERVAL", tt)
+			if actual := UpdateInterval(); actual != expect {
+				t.Errorf("%s: expected %s, got %s", tt, expect, actual)
+			}
+		})
+	}
+}
+
+func TestUpdatePolicy(t *testing.T) {
+	cases := map[string]string{
+		"":       "manual",
+		"auto":   "auto",
+		"PINNED": "pinned",
+		"manual": "manual",
+		"always": "manual",
+	}
+
+	for tt, expect := range cases {
+		t.Run(tt, func(t *testing.T) {
+			t.Setenv("OLLAMA_UPDATE_POLICY", tt)
+			if actual := UpdatePolicy(); actual != expect {
+				t.Errorf("%s: expected %q, got %q", tt, expect, actual)
+			}
+		})
+	}
+}
+
+func TestQuantFallback(t *testing.T) {
+	cases := map[string]string{
+		"":        "",
+		"suggest": "suggest",
+		"AUTO":    "auto",
+		"1":       "",
+		"yes":     "",
+	}
+
+	for tt, expect := range cases {
+		t.Run(tt, func(t *testing.T) {
+			t.Setenv("OLLAMA_QUANT_FALLBACK", tt)
+			if actual := QuantFallback(); actual != expect {
+				t.Errorf("%s: expected %q, got %q", tt, expect, actual)
+			}
+		})
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	cases := map[string]string{
+		"":       "warn",
+		"warn":   "warn",
+		"STRICT": "strict",
+		"off":    "off",
+		"1":      "warn",
+	}
+
+	for tt, expect := range cases {
+		t.Run(tt, func(t *testing.T) {
+			t.Setenv("OLLAMA_SELF_TEST", tt)
+			if actual := SelfTest(); actual != expect {
+				t.Errorf("%s: expected %q, got %q", tt, expect, actual)
+			}
+		})
+	}
+}
+
 func TestVar(t *testing.T) {
 	cases := map[string]string{
 		"value":       "value",
@@ -107,8 +107,8 @@ func (kv KV) Bool(key string, defaultValue ...bool) bool {
 func (kv KV) Strings(key string, defaultValue ...[]string) []string {
 	r := keyValue(kv, key, &array{})
 	s := make([]string, r.size)
-	for i := range r.size {
-		s[i] = r.values[i].(string)
+	for i, v := range r.values {
+		s[i], _ = v.(string)
 	}
 
 	return s
@@ -117,8 +117,9 @@ func (kv KV) Strings(key string, defaultValue ...[]string) []string {
 func (kv KV) Uints(key string, defaultValue ...[]uint32) []uint32 {
 	r := keyValue(kv, key, &array{})
 	s := make([]uint32, r.size)
-	for i := range r.size {
-		s[i] = uint32(r.values[i].(int32))
+	for i, v := range r.values {
+		n, _ := v.(int32)
+		s[i] = uint32(n)
 	}
 
 	return s
@@ -127,14 +128,66 @@ func (kv KV) Uints(key string, defaultValue ...[]uint32) []uint32 {
 func (kv KV) Floats(key string, defaultValue ...[]float32) []float32 {
 	r := keyValue(kv, key, &array{})
 	s := make([]float32, r.size)
-	for i := range r.size {
-		s[i] = float32(r.values[i].(float32))
+	for i, v := range r.values {
+		s[i], _ = v.(float32)
 	}
 	return s
 }
 
+// LayerSplit returns the relative share of the layers of the model that
+// should be placed on each GPU, if the model suggests one.
+func (kv KV) LayerSplit() []float32 {
+	r, ok := kv["general.layer_split"].(*array)
+	if !ok {
+		return nil
+	}
+
+	split := make([]float32, 0, r.size)
+	for _, v := range r.values {
+		switch v := v.(type) {
+		case float32:
+			split = append(split, v)
+		case int32:
+			split = append(split, float32(v))
+		case uint32:
+			split = append(split, float32(v))
+		}
+	}
+
+	return split
+}
+
+// EmbeddingDimensions returns the sizes the embeddings of the model can be
+// truncated to, which it declares if it was trained with Matryoshka
+// representation learning.
+func (kv KV) EmbeddingDimensions() []uint64 {
+	r, ok := kv[kv.Architecture()+".embedding.matryoshka_dimensions"].(*array)
+	if !ok {
+		return nil
+	}
+
+	dims := make([]uint64, 0, r.size)
+	for _, v := range r.values {
+		switch v := v.(type) {
+		case int32:
+			dims = append(dims, uint64(v))
+		case uint32:
+			dims = append(dims, uint64(v))
+		}
+	}
+
+	return dims
+}
+
+// Classifier returns true if the model has a sequence classification head in
+// place of a language modeling head.
+func (kv KV) Classifier() bool {
+	_, ok := kv[kv.Architecture()+".classifier.output_labels"]
+	return ok
+}
+
 func (kv KV) OllamaEngineRequired() bool {
-	return kv.Architecture() == "gemma3"
+	return kv.Architecture() == "gemma3" || kv.Classifier()
 }
 
 func keyValue[T string | uint32 | uint64 | float32 | *array | bool](kv KV, key string, defaultValue ...T) T {
@@ -143,7 +196,12 @@ func keyValue[T string | uint32 | uint64 | float32 | *array | bool](kv KV, key s
 	}
 
 	if val, ok := kv[key]; ok {
-		return val.(T)
+		if v, ok := val.(T); ok {
+			return v
+		}
+
+		slog.Warn("key has an unexpected type", "key", key, "type", fmt.Sprintf("%T", val))
+		return defaultValue[0]
 	}
 
 	slog.Warn("key not found", "key", key, "default", defaultValue[0])
@@ -417,7 +475,10 @@ func (f GGML) GraphSize(context, batch uint64, kvCacheType string) (kv, partialO
 	embedding := f.KV().EmbeddingLength()
 	heads := f.KV().HeadCount()
 	headsKV := f.KV().HeadCountKV()
-	vocab := uint64(f.KV()["tokenizer.ggml.tokens"].(*array).size)
+	var vocab uint64
+	if tokens, ok := f.KV()["tokenizer.ggml.tokens"].(*array); ok {
+		vocab = uint64(tokens.size)
+	}
 
 	embeddingHeads := f.KV().EmbeddingHeadCount()
 	embeddingHeadsK := f.KV().EmbeddingHeadCountK()
@@ -634,7 +695,21 @@ func (llm GGML) VisionGraphSize() (weights, graphSize uint64) {
 
 // SupportsKVCacheType checks if the requested cache type is supported
 func (f GGML) SupportsKVCacheType(cacheType string) bool {
-	return slices.Contains([]string{"f16", "q8_0", "q4_0"}, cacheType)
+	if !slices.Contains([]string{"f16", "q8_0", "q4_0"}, cacheType) {
+		return false
+	}
+
+	if cacheType == "f16" {
+		return true
+	}
+
+	// Quantized types store each head in blocks of 32 values
+	if f.KV().EmbeddingHeadCountK()%32 != 0 || f.KV().EmbeddingHeadCountV()%32 != 0 {
+		return false
+	}
+
+	// gpt-oss computes attention with sinks itself, which needs unquantized values
+	return f.KV().Architecture() != "gpt-oss"
 }
 
 // SupportsFlashAttention checks if the model supports flash attention
@@ -210,3 +210,30 @@ func TestTensorTypes(t *testing.T) {
 		})
 	}
 }
+
+func TestSupportsKVCacheType(t *testing.T) {
+	cases := []struct {
+		name      string
+		kv        KV
+		cacheType string
+		want      bool
+	}{
+		{"f16", KV{"general.architecture": "llama", "llama.embedding_length": uint32(4096), "llama.attention.head_count": uint32(32)}, "f16", true},
+		{"q8_0", KV{"general.architecture": "llama", "llama.embedding_length": uint32(4096), "llama.attention.head_count": uint32(32)}, "q8_0", true},
+		{"q4_0", KV{"general.architecture": "llama", "llama.embedding_length": uint32(4096), "llama.attention.head_count": uint32(32)}, "q4_0", true},
+		{"unknown", KV{"general.architecture": "llama", "llama.embedding_length": uint32(4096), "llama.attention.head_count": uint32(32)}, "q5_1", false},
+		{"head dim", KV{"general.architecture": "llama", "llama.embedding_length": uint32(80), "llama.attention.head_count": uint32(1)}, "q8_0", false},
+		{"value head dim", KV{"general.architecture": "llama", "llama.embedding_length": uint32(4096), "llama.attention.head_count": uint32(32), "llama.attention.value_length": uint32(80)}, "q8_0", false},
+		{"gpt-oss", KV{"general.architecture": "gpt-oss", "gpt-oss.attention.key_length": uint32(64), "gpt-oss.attention.value_length": uint32(64)}, "q8_0", false},
+		{"gpt-oss f16", KV{"general.architecture": "gpt-oss", "gpt-oss.attention.key_length": uint32(64), "gpt-oss.attention.value_length": uint32(64)}, "f16", true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			f := GGML{model: &gguf{kv: tt.kv}}
+			if got := f.SupportsKVCacheType(tt.cacheType); got != tt.want {
+				t.Errorf("SupportsKVCacheType(%q) = %v, want %v", tt.cacheType, got, tt.want)
+			}
+		})
+	}
+}
@@ -5,14 +5,81 @@ import (
 	"cmp"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"maps"
+	"math/bits"
 	"slices"
 	"strings"
 )
 
+// Limits of the GGUF decoder. Real models stay far below them; they keep
+// crafted files from exhausting memory before they are rejected.
+const (
+	maxGGUFKVs          = 1 << 16
+	maxGGUFTensors      = 1 << 20
+	maxGGUFStringLength = 16 << 20
+	maxGGUFArrayLength  = 1 << 24
+
+	// maxGGUFMetadataSize is the most bytes of key-values and tensor
+	// infos, which precede the tensor data
+	maxGGUFMetadataSize = 512 << 20
+)
+
+// ErrMalformed is matched by the errors of decoding model files that are
+// truncated, inconsistent or exceed a limit of the decoder.
+var ErrMalformed = errors.New("malformed model file")
+
+// FormatError reports a malformed model file and the offset in the file
+// where decoding it failed.
+type FormatError struct {
+	Offset int64
+	Err    error
+}
+
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("malformed model file at offset %d: %v", e.Offset, e.Err)
+}
+
+func (e *FormatError) Unwrap() []error {
+	return []error{ErrMalformed, e.Err}
+}
+
+// LimitError reports a value in a model file that exceeds a limit of the
+// decoder.
+type LimitError struct {
+	Name  string
+	Value uint64
+	Limit uint64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s %d exceeds the limit of %d", e.Name, e.Value, e.Limit)
+}
+
+// ggufReader tracks the offset of a reader in a model file so that errors can
+// point at it and lengths can be checked against the rest of the file.
+type ggufReader struct {
+	io.ReadSeeker
+	offset, size int64
+}
+
+func (r *ggufReader) Read(p []byte) (int, error) {
+	n, err := r.ReadSeeker.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *ggufReader) Seek(offset int64, whence int) (int64, error) {
+	n, err := r.ReadSeeker.Seek(offset, whence)
+	if err == nil {
+		r.offset = n
+	}
+	return n, err
+}
+
 type containerGGUF struct {
 	ByteOrder binary.ByteOrder
 
@@ -45,25 +112,63 @@ func (c *containerGGUF) Name() string {
 }
 
 func (c *containerGGUF) Decode(rs io.ReadSeeker) (model, error) {
-	if err := binary.Read(rs, c.ByteOrder, &c.Version); err != nil {
+	offset, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
 		return nil, err
 	}
 
+	size, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := rs.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	r := &ggufReader{ReadSeeker: rs, offset: offset, size: size}
+	model, err := c.decode(r)
+	if err != nil {
+		// the file ends before all of the model was read
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return nil, &FormatError{Offset: r.offset, Err: err}
+	}
+
+	return model, nil
+}
+
+func (c *containerGGUF) decode(r *ggufReader) (model, error) {
+	if err := binary.Read(r, c.ByteOrder, &c.Version); err != nil {
+		return nil, err
+	}
+
+	// files written by llama.cpp on big endian machines keep the little
+	// endian magic so the byte order has to be detected from the version
+	if c.Version&0xffff == 0 {
+		c.ByteOrder = otherByteOrder(c.ByteOrder)
+		c.Version = bits.ReverseBytes32(c.Version)
+	}
+
 	var err error
 	switch c.Version {
 	case 1:
-		err = binary.Read(rs, c.ByteOrder, &c.V1)
+		err = binary.Read(r, c.ByteOrder, &c.V1)
 	case 2:
-		err = binary.Read(rs, c.ByteOrder, &c.V2)
+		err = binary.Read(r, c.ByteOrder, &c.V2)
+	case 3:
+		err = binary.Read(r, c.ByteOrder, &c.V3)
 	default:
-		err = binary.Read(rs, c.ByteOrder, &c.V3)
+		return nil, fmt.Errorf("unsupported GGUF version %d", c.Version)
 	}
 	if err != nil {
 		return nil, err
 	}
 
 	model := newGGUF(c)
-	if err := model.Decode(rs); err != nil {
+	if err := model.Decode(r); err != nil {
 		return nil, err
 	}
 
@@ -89,6 +194,9 @@ const (
 type gguf struct {
 	*containerGGUF
 
+	r     *ggufReader
+	start int64
+
 	kv      KV
 	tensors []*Tensor
 
@@ -138,7 +246,17 @@ func (llm *gguf) numKV() uint64 {
 	}
 }
 
-func (llm *gguf) Decode(rs io.ReadSeeker) error {
+func (llm *gguf) Decode(rs *ggufReader) error {
+	llm.r, llm.start = rs, rs.offset
+
+	if n := llm.numKV(); n > maxGGUFKVs {
+		return &LimitError{Name: "key-value count", Value: n, Limit: maxGGUFKVs}
+	}
+
+	if n := llm.numTensor(); n > maxGGUFTensors {
+		return &LimitError{Name: "tensor count", Value: n, Limit: maxGGUFTensors}
+	}
+
 	// decode key-values
 	for i := 0; uint64(i) < llm.numKV(); i++ {
 		k, err := readGGUFString(llm, rs)
@@ -180,10 +298,14 @@ func (llm *gguf) Decode(rs io.ReadSeeker) error {
 		case ggufTypeArray:
 			v, err = readGGUFArray(llm, rs)
 		default:
-			return fmt.Errorf("invalid type: %d", t)
+			return fmt.Errorf("%s: invalid type: %d", k, t)
 		}
 
 		if err != nil {
+			return fmt.Errorf("%s: %w", k, err)
+		}
+
+		if err := llm.checkMetadataSize(); err != nil {
 			return err
 		}
 
@@ -203,6 +325,10 @@ func (llm *gguf) Decode(rs io.ReadSeeker) error {
 			return fmt.Errorf("failed to read tensor dimensions: %w", err)
 		}
 
+		if dims > maxDims {
+			return &TensorError{Name: name, Err: fmt.Errorf("invalid number of dimensions %d", dims)}
+		}
+
 		shape := make([]uint64, dims)
 		for i := 0; uint32(i) < dims; i++ {
 			shape[i], err = readGGUF[uint64](llm, rs)
@@ -228,6 +354,10 @@ func (llm *gguf) Decode(rs io.ReadSeeker) error {
 			Shape:  shape[:],
 		}
 
+		if err := llm.checkMetadataSize(); err != nil {
+			return err
+		}
+
 		llm.tensors = append(llm.tensors, &tensor)
 		llm.parameters += tensor.parameters()
 	}
@@ -238,6 +368,8 @@ func (llm *gguf) Decode(rs io.ReadSeeker) error {
 	alignment, ok := llm.kv["general.alignment"].(uint32)
 	if !ok {
 		alignment = 32
+	} else if alignment == 0 || alignment&(alignment-1) != 0 {
+		return fmt.Errorf("invalid alignment %d", alignment)
 	}
 
 	offset, err := rs.Seek(0, io.SeekCurrent)
@@ -248,20 +380,53 @@ func (llm *gguf) Decode(rs io.ReadSeeker) error {
 	padding := ggufPadding(offset, int64(alignment))
 	llm.tensorOffset = uint64(offset + padding)
 
+	size, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	var dataSize uint64
+	if uint64(size) > llm.tensorOffset {
+		dataSize = uint64(size) - llm.tensorOffset
+	}
+
+	if err := llm.Tensors().Validate(dataSize, uint64(alignment)); err != nil {
+		return err
+	}
+
+	// seek to the end of the tensor data, which may be followed by another model
+	var end uint64
 	for _, tensor := range llm.tensors {
-		offset, err := rs.Seek(0, io.SeekCurrent)
-		if err != nil {
-			return fmt.Errorf("failed to get current offset: %w", err)
-		}
+		end = max(end, tensor.Offset+tensor.Size())
+	}
 
-		padding := ggufPadding(offset, int64(alignment))
-		if _, err := rs.Seek(padding, io.SeekCurrent); err != nil {
-			return fmt.Errorf("failed to seek to init padding: %w", err)
-		}
+	if _, err := rs.Seek(int64(llm.tensorOffset+end), io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek to end of tensors: %w", err)
+	}
 
-		if _, err := rs.Seek(int64(tensor.Size()), io.SeekCurrent); err != nil {
-			return fmt.Errorf("failed to seek to tensor: %w", err)
-		}
+	return nil
+}
+
+// checkMetadataSize returns an error if more than maxGGUFMetadataSize bytes of
+// key-values and tensor infos were read.
+func (llm *gguf) checkMetadataSize() error {
+	if n := uint64(llm.r.offset - llm.start); n > maxGGUFMetadataSize {
+		return &LimitError{Name: "metadata size", Value: n, Limit: maxGGUFMetadataSize}
+	}
+
+	return nil
+}
+
+// checkLength returns an error if a string or array of n elements, each of
+// which takes at least size bytes, is longer than limit or the rest of the
+// file.
+func (llm *gguf) checkLength(name string, n, size, limit uint64) error {
+	if n > limit {
+		return &LimitError{Name: name, Value: n, Limit: limit}
+	}
+
+	if remaining := uint64(max(llm.r.size-llm.r.offset, 0)); n*size > remaining {
+		return fmt.Errorf("%s %d is past the end of the file", name, n)
 	}
 
 	return nil
@@ -287,6 +452,15 @@ func readGGUFV1String(llm *gguf, r io.Reader) (string, error) {
 		return "", err
 	}
 
+	// the length includes the terminating null
+	if length == 0 {
+		return "", errors.New("invalid string length 0")
+	}
+
+	if err := llm.checkLength("string length", length, 1, maxGGUFStringLength); err != nil {
+		return "", err
+	}
+
 	var b bytes.Buffer
 	if _, err := io.CopyN(&b, r, int64(length)); err != nil {
 		return "", err
@@ -305,7 +479,12 @@ func discardGGUFString(llm *gguf, r io.Reader) error {
 		return err
 	}
 
-	size := int(llm.ByteOrder.Uint64(buf))
+	length := llm.ByteOrder.Uint64(buf)
+	if err := llm.checkLength("string length", length, 1, maxGGUFStringLength); err != nil {
+		return err
+	}
+
+	size := int(length)
 	for size > 0 {
 		n, err := r.Read(llm.scratch[:min(size, cap(llm.scratch))])
 		if err != nil {
@@ -327,7 +506,12 @@ func readGGUFString(llm *gguf, r io.Reader) (string, error) {
 		return "", err
 	}
 
-	length := int(llm.ByteOrder.Uint64(buf))
+	n := llm.ByteOrder.Uint64(buf)
+	if err := llm.checkLength("string length", n, 1, maxGGUFStringLength); err != nil {
+		return "", err
+	}
+
+	length := int(n)
 	if length > len(llm.scratch) {
 		buf = make([]byte, length)
 	} else {
@@ -375,12 +559,23 @@ func readGGUFV1Array(llm *gguf, r io.Reader) (*array, error) {
 		return nil, err
 	}
 
+	size := ggufTypeSize(t)
+	if size == 0 {
+		return nil, fmt.Errorf("invalid array type: %d", t)
+	}
+
+	if err := llm.checkLength("array length", uint64(n), size, maxGGUFArrayLength); err != nil {
+		return nil, err
+	}
+
 	a := &array{size: int(n)}
 	if llm.canCollectArray(int(n)) {
-		a.values = make([]any, 0, int(n))
+		// grow as values are read so that the array isn't allocated
+		// before the file is known to hold it
+		a.values = make([]any, 0, min(int(n), 1<<16))
 	}
 
-	for i := range n {
+	for range n {
 		var e any
 		switch t {
 		case ggufTypeUint8:
@@ -415,7 +610,7 @@ func readGGUFV1Array(llm *gguf, r io.Reader) (*array, error) {
 		}
 
 		if a.values != nil {
-			a.values[i] = e
+			a.values = append(a.values, e)
 		}
 	}
 
@@ -437,12 +632,21 @@ func readGGUFArray(llm *gguf, r io.Reader) (*array, error) {
 		return nil, err
 	}
 
+	size := ggufTypeSize(t)
+	if size == 0 {
+		return nil, fmt.Errorf("invalid array type: %d", t)
+	}
+
+	if err := llm.checkLength("array length", uint64(n), size, maxGGUFArrayLength); err != nil {
+		return nil, err
+	}
+
 	a := &array{size: int(n)}
 	if llm.canCollectArray(int(n)) {
-		a.values = make([]any, int(n))
+		a.values = make([]any, 0, min(int(n), 1<<16))
 	}
 
-	for i := range n {
+	for range n {
 		var e any
 		switch t {
 		case ggufTypeUint8:
@@ -481,13 +685,33 @@ func readGGUFArray(llm *gguf, r io.Reader) (*array, error) {
 		}
 
 		if a.values != nil {
-			a.values[i] = e
+			a.values = append(a.values, e)
 		}
 	}
 
 	return a, nil
 }
 
+// ggufTypeSize returns the fewest bytes a value of type t takes in a file, or
+// 0 if t is not a valid array element type.
+func ggufTypeSize(t uint32) uint64 {
+	switch t {
+	case ggufTypeUint8, ggufTypeInt8, ggufTypeBool:
+		return 1
+	case ggufTypeUint16, ggufTypeInt16:
+		return 2
+	case ggufTypeUint32, ggufTypeInt32, ggufTypeFloat32:
+		return 4
+	case ggufTypeUint64, ggufTypeInt64, ggufTypeFloat64:
+		return 8
+	case ggufTypeString:
+		// the length of the string
+		return 8
+	default:
+		return 0
+	}
+}
+
 // writeGGUFArray writes a slice s of type E to the write with a gguf type of t
 func writeGGUFArray[S ~[]E, E any](w io.Writer, t uint32, s S) error {
 	if err := binary.Write(w, binary.LittleEndian, ggufTypeArray); err != nil {
@@ -541,6 +765,8 @@ func WriteGGUF(ws io.WriteSeeker, kv KV, ts []Tensor) error {
 		}
 	})
 
+	var alignment int64 = 32
+
 	var s uint64
 	for _, t := range ts {
 		t.Offset = s
@@ -548,9 +774,9 @@ func WriteGGUF(ws io.WriteSeeker, kv KV, ts []Tensor) error {
 			return err
 		}
 		s += t.Size()
+		s += uint64(ggufPadding(int64(s), alignment))
 	}
 
-	var alignment int64 = 32
 	for _, t := range ts {
 		if err := ggufWriteTensor(ws, t, alignment); err != nil {
 			return err
@@ -0,0 +1,221 @@
+package ggml
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// craftGGUF builds a little endian GGUF file of the given version from the
+// counts in its header and the values that follow.
+func craftGGUF(version uint32, numTensor, numKV uint64, vs ...any) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, uint32(FILE_MAGIC_GGUF_LE))
+	binary.Write(&b, binary.LittleEndian, version)
+	if version == 1 {
+		binary.Write(&b, binary.LittleEndian, uint32(numTensor))
+		binary.Write(&b, binary.LittleEndian, uint32(numKV))
+	} else {
+		binary.Write(&b, binary.LittleEndian, numTensor)
+		binary.Write(&b, binary.LittleEndian, numKV)
+	}
+
+	for _, v := range vs {
+		switch v := v.(type) {
+		case string:
+			binary.Write(&b, binary.LittleEndian, uint64(len(v)))
+			b.WriteString(v)
+		default:
+			binary.Write(&b, binary.LittleEndian, v)
+		}
+	}
+
+	return b.Bytes()
+}
+
+func validGGUF(t testing.TB) []byte {
+	t.Helper()
+
+	f, err := os.CreateTemp(t.TempDir(), "model.gguf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := WriteGGUF(f, KV{
+		"general.architecture":  "llama",
+		"general.alignment":     uint32(32),
+		"llama.block_count":     uint32(1),
+		"tokenizer.ggml.tokens": []string{"a", "b", "c"},
+		"tokenizer.ggml.scores": []float32{1, 2, 3},
+	}, []Tensor{
+		{Name: "blk.0.attn_q.weight", Kind: 0, Shape: []uint64{4}, WriterTo: bytes.NewReader(make([]byte, 16))},
+		{Name: "output.weight", Kind: 0, Shape: []uint64{2, 2}, WriterTo: bytes.NewReader(make([]byte, 16))},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	bts, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return bts
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	valid := validGGUF(t)
+
+	cases := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{
+			name: "truncated",
+			data: valid[:64],
+			err:  "unexpected EOF",
+		},
+		{
+			name: "unsupported version",
+			data: craftGGUF(7, 0, 0),
+			err:  "unsupported GGUF version 7",
+		},
+		{
+			name: "too many key-values",
+			data: craftGGUF(3, 0, 1<<40),
+			err:  "key-value count 1099511627776 exceeds the limit of 65536",
+		},
+		{
+			name: "too many tensors",
+			data: craftGGUF(3, 1<<40, 0),
+			err:  "tensor count 1099511627776 exceeds the limit of 1048576",
+		},
+		{
+			name: "string too long",
+			data: craftGGUF(3, 0, 1, uint64(1<<62)),
+			err:  "string length 4611686018427387904 exceeds the limit of 16777216",
+		},
+		{
+			name: "string past end",
+			data: craftGGUF(3, 0, 1, uint64(100), "abc"),
+			err:  "string length 100 is past the end of the file",
+		},
+		{
+			name: "empty v1 string",
+			data: craftGGUF(1, 0, 1, uint64(0)),
+			err:  "invalid string length 0",
+		},
+		{
+			name: "invalid type",
+			data: craftGGUF(3, 0, 1, "key", uint32(99)),
+			err:  "key: invalid type: 99",
+		},
+		{
+			name: "array too long",
+			data: craftGGUF(3, 0, 1, "key", ggufTypeArray, ggufTypeUint8, uint64(1<<62)),
+			err:  "key: array length 4611686018427387904 exceeds the limit of 16777216",
+		},
+		{
+			name: "array past end",
+			data: craftGGUF(3, 0, 1, "key", ggufTypeArray, ggufTypeUint32, uint64(100), uint32(1)),
+			err:  "key: array length 100 is past the end of the file",
+		},
+		{
+			name: "nested array",
+			data: craftGGUF(3, 0, 1, "key", ggufTypeArray, ggufTypeArray, uint64(1)),
+			err:  "key: invalid array type: 9",
+		},
+		{
+			name: "too many dimensions",
+			data: craftGGUF(3, 1, 0, "t", uint32(1<<31)),
+			err:  `tensor "t": invalid number of dimensions 2147483648`,
+		},
+		{
+			name: "zero alignment",
+			data: craftGGUF(3, 0, 1, "general.alignment", ggufTypeUint32, uint32(0)),
+			err:  "invalid alignment 0",
+		},
+		{
+			name: "tensor past end",
+			data: craftGGUF(3, 1, 0, "t", uint32(1), uint64(1<<20), uint32(0), uint64(0)),
+			err:  `tensor "t": data at offset 0 with size 4194304 is past the end of the file`,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := Decode(bytes.NewReader(tt.data), -1)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			if !errors.Is(err, ErrMalformed) {
+				t.Errorf("expected %v, got %v", ErrMalformed, err)
+			}
+
+			var fe *FormatError
+			if !errors.As(err, &fe) || fe.Offset <= 0 || fe.Offset > int64(len(tt.data)) {
+				t.Errorf("expected an offset within the file, got %v", err)
+			}
+
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected %q, got %q", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestDecodeLimitError(t *testing.T) {
+	_, _, err := Decode(bytes.NewReader(craftGGUF(3, 0, 1, uint64(1<<62))), 0)
+
+	var le *LimitError
+	if !errors.As(err, &le) {
+		t.Fatalf("expected a limit error, got %v", err)
+	}
+
+	if le.Name != "string length" || le.Limit != maxGGUFStringLength {
+		t.Errorf("unexpected limit error %+v", le)
+	}
+}
+
+func TestDecodeEOF(t *testing.T) {
+	// an empty reader has no more models, which isn't malformed
+	if _, _, err := Decode(bytes.NewReader(nil), 0); !errors.Is(err, io.EOF) || errors.Is(err, ErrMalformed) {
+		t.Errorf("expected %v, got %v", io.EOF, err)
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	valid := validGGUF(f)
+	f.Add(valid)
+	for _, n := range []int{8, 24, 64, len(valid) / 2, len(valid) - 1} {
+		f.Add(valid[:n])
+	}
+	f.Add(craftGGUF(1, 0, 1, uint64(4), "abc\x00", ggufTypeString, uint64(2), "a\x00"))
+	f.Add(craftGGUF(2, 0, 1, "key", ggufTypeArray, ggufTypeString, uint64(1), "v"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, maxArraySize := range []int{0, -1} {
+			f, _, err := Decode(bytes.NewReader(data), maxArraySize)
+			if err != nil {
+				continue
+			}
+
+			// accessors of crafted values must not panic either
+			kv := f.KV()
+			kv.Architecture()
+			kv.Kind()
+			kv.ChatTemplate()
+			kv.BlockCount()
+			kv.Strings("tokenizer.ggml.tokens")
+			kv.Floats("tokenizer.ggml.scores")
+			kv.Uints("tokenizer.ggml.token_type")
+			f.Tensors().GroupLayers()
+		}
+	})
+}
@@ -0,0 +1,577 @@
+package ggml
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"slices"
+	"strings"
+)
+
+// Shard returns the index of the file in a model that has been split across
+// several files and the number of files in the model. count is 0 if the model
+// is not split.
+func (kv KV) Shard() (no, count int) {
+	return anyInt(kv["split.no"]), anyInt(kv["split.count"])
+}
+
+func anyInt(v any) int {
+	switch v := v.(type) {
+	case uint8:
+		return int(v)
+	case int8:
+		return int(v)
+	case uint16:
+		return int(v)
+	case int16:
+		return int(v)
+	case uint32:
+		return int(v)
+	case int32:
+		return int(v)
+	case uint64:
+		return int(v)
+	case int64:
+		return int(v)
+	}
+
+	return 0
+}
+
+// ByteOrder returns the byte order of the model file.
+func (f GGML) ByteOrder() binary.ByteOrder {
+	if c, ok := f.container.(*containerGGUF); ok {
+		return c.ByteOrder
+	}
+
+	return binary.LittleEndian
+}
+
+// otherByteOrder returns the byte order opposite to order.
+func otherByteOrder(order binary.ByteOrder) binary.ByteOrder {
+	if order == binary.ByteOrder(binary.BigEndian) {
+		return binary.LittleEndian
+	}
+
+	return binary.BigEndian
+}
+
+// MergeShards writes the model split across shards as a single file to w in
+// byte order order, or that of the first shard if order is nil. shards must be
+// ordered by their split.no.
+func MergeShards(w io.Writer, order binary.ByteOrder, shards ...io.ReaderAt) error {
+	if len(shards) == 0 {
+		return errors.New("no shards")
+	}
+
+	return rewrite(w, order, shards)
+}
+
+// ConvertByteOrder writes the model read from r to w in byte order order.
+func ConvertByteOrder(w io.Writer, r io.ReaderAt, order binary.ByteOrder) error {
+	return rewrite(w, order, []io.ReaderAt{r})
+}
+
+// rawArray is an array as it is stored in a GGUF file. Unlike [array] it keeps
+// the element type so that it can be written back unchanged.
+type rawArray struct {
+	t      uint32
+	values []any
+}
+
+type rawKV struct {
+	key   string
+	t     uint32
+	value any
+}
+
+// rawGGUF is the header of a GGUF file read without interpreting any of its
+// key values.
+type rawGGUF struct {
+	order     binary.ByteOrder
+	kvs       []rawKV
+	tensors   []*Tensor
+	alignment uint64
+
+	// offset is where the tensor data starts
+	offset uint64
+}
+
+func (g *rawGGUF) kv() KV {
+	kv := make(KV, len(g.kvs))
+	for _, e := range g.kvs {
+		kv[e.key] = e.value
+	}
+
+	return kv
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ReadByteOrder returns the byte order of the GGUF file read from r.
+func ReadByteOrder(r io.Reader) (binary.ByteOrder, error) {
+	order, _, err := readMagic(r)
+	return order, err
+}
+
+// readMagic reads the magic and version at the start of a GGUF file and
+// returns the byte order and version of the file.
+func readMagic(r io.Reader) (binary.ByteOrder, uint32, error) {
+	var magic uint32
+	if err := binary.Read(r, binary.LittleEndian, &magic); err != nil {
+		return nil, 0, err
+	}
+
+	var order binary.ByteOrder
+	switch magic {
+	case FILE_MAGIC_GGUF_LE:
+		order = binary.LittleEndian
+	case FILE_MAGIC_GGUF_BE:
+		order = binary.BigEndian
+	default:
+		return nil, 0, errors.New("invalid file magic")
+	}
+
+	var version uint32
+	if err := binary.Read(r, order, &version); err != nil {
+		return nil, 0, err
+	}
+
+	// files written by llama.cpp on big endian machines keep the little
+	// endian magic
+	if version&0xffff == 0 {
+		order = otherByteOrder(order)
+		version = bits.ReverseBytes32(version)
+	}
+
+	return order, version, nil
+}
+
+func readRawGGUF(r io.ReaderAt) (*rawGGUF, error) {
+	cr := &countingReader{r: bufio.NewReaderSize(io.NewSectionReader(r, 0, math.MaxInt64), 32<<10)}
+
+	order, version, err := readMagic(cr)
+	if err != nil {
+		return nil, err
+	}
+
+	if version < 2 {
+		return nil, fmt.Errorf("unsupported GGUF version %d", version)
+	}
+
+	g := rawGGUF{order: order, alignment: 32}
+
+	var counts struct {
+		NumTensor uint64
+		NumKV     uint64
+	}
+	if err := binary.Read(cr, g.order, &counts); err != nil {
+		return nil, err
+	}
+
+	if counts.NumKV > maxGGUFKVs {
+		return nil, &LimitError{Name: "key-value count", Value: counts.NumKV, Limit: maxGGUFKVs}
+	}
+
+	if counts.NumTensor > maxGGUFTensors {
+		return nil, &LimitError{Name: "tensor count", Value: counts.NumTensor, Limit: maxGGUFTensors}
+	}
+
+	for range counts.NumKV {
+		k, err := readRawString(cr, g.order)
+		if err != nil {
+			return nil, err
+		}
+
+		var t uint32
+		if err := binary.Read(cr, g.order, &t); err != nil {
+			return nil, err
+		}
+
+		v, err := readRawValue(cr, g.order, t)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+
+		if a, ok := v.(uint32); ok && k == "general.alignment" {
+			if a == 0 || a&(a-1) != 0 {
+				return nil, fmt.Errorf("invalid alignment %d", a)
+			}
+			g.alignment = uint64(a)
+		}
+
+		g.kvs = append(g.kvs, rawKV{key: k, t: t, value: v})
+	}
+
+	for range counts.NumTensor {
+		name, err := readRawString(cr, g.order)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tensor name: %w", err)
+		}
+
+		var dims uint32
+		if err := binary.Read(cr, g.order, &dims); err != nil {
+			return nil, fmt.Errorf("failed to read tensor dimensions: %w", err)
+		}
+
+		if dims > maxDims {
+			return nil, &TensorError{Name: name, Err: fmt.Errorf("invalid number of dimensions %d", dims)}
+		}
+
+		t := Tensor{Name: name, Shape: make([]uint64, dims)}
+		if err := binary.Read(cr, g.order, t.Shape); err != nil {
+			return nil, fmt.Errorf("failed to read tensor shape: %w", err)
+		}
+
+		if err := binary.Read(cr, g.order, &t.Kind); err != nil {
+			return nil, fmt.Errorf("failed to read tensor kind: %w", err)
+		}
+
+		if err := binary.Read(cr, g.order, &t.Offset); err != nil {
+			return nil, fmt.Errorf("failed to read tensor offset: %w", err)
+		}
+
+		g.tensors = append(g.tensors, &t)
+	}
+
+	g.offset = uint64(cr.n + ggufPadding(cr.n, int64(g.alignment)))
+	return &g, nil
+}
+
+func readRawString(r io.Reader, order binary.ByteOrder) (string, error) {
+	var n uint64
+	if err := binary.Read(r, order, &n); err != nil {
+		return "", err
+	}
+
+	if n > maxGGUFStringLength {
+		return "", &LimitError{Name: "string length", Value: n, Limit: maxGGUFStringLength}
+	}
+
+	var b strings.Builder
+	if _, err := io.CopyN(&b, r, int64(n)); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+func readRaw[T any](r io.Reader, order binary.ByteOrder) (any, error) {
+	var t T
+	err := binary.Read(r, order, &t)
+	return t, err
+}
+
+func readRawValue(r io.Reader, order binary.ByteOrder, t uint32) (any, error) {
+	switch t {
+	case ggufTypeUint8:
+		return readRaw[uint8](r, order)
+	case ggufTypeInt8:
+		return readRaw[int8](r, order)
+	case ggufTypeUint16:
+		return readRaw[uint16](r, order)
+	case ggufTypeInt16:
+		return readRaw[int16](r, order)
+	case ggufTypeUint32:
+		return readRaw[uint32](r, order)
+	case ggufTypeInt32:
+		return readRaw[int32](r, order)
+	case ggufTypeUint64:
+		return readRaw[uint64](r, order)
+	case ggufTypeInt64:
+		return readRaw[int64](r, order)
+	case ggufTypeFloat32:
+		return readRaw[float32](r, order)
+	case ggufTypeFloat64:
+		return readRaw[float64](r, order)
+	case ggufTypeBool:
+		return readRaw[bool](r, order)
+	case ggufTypeString:
+		return readRawString(r, order)
+	case ggufTypeArray:
+		var a rawArray
+		if err := binary.Read(r, order, &a.t); err != nil {
+			return nil, err
+		}
+
+		if a.t == ggufTypeArray {
+			return nil, errors.New("nested arrays are not supported")
+		}
+
+		var n uint64
+		if err := binary.Read(r, order, &n); err != nil {
+			return nil, err
+		}
+
+		if n > maxGGUFArrayLength {
+			return nil, &LimitError{Name: "array length", Value: n, Limit: maxGGUFArrayLength}
+		}
+
+		// grow as values are read so a corrupt size can't exhaust memory
+		a.values = make([]any, 0, min(n, 1<<16))
+		for range n {
+			e, err := readRawValue(r, order, a.t)
+			if err != nil {
+				return nil, err
+			}
+
+			a.values = append(a.values, e)
+		}
+
+		return &a, nil
+	default:
+		return nil, fmt.Errorf("invalid type: %d", t)
+	}
+}
+
+func writeRawString(w io.Writer, order binary.ByteOrder, s string) error {
+	if err := binary.Write(w, order, uint64(len(s))); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, s)
+	return err
+}
+
+func writeRawValue(w io.Writer, order binary.ByteOrder, v any) error {
+	switch v := v.(type) {
+	case string:
+		return writeRawString(w, order, v)
+	case *rawArray:
+		if err := binary.Write(w, order, v.t); err != nil {
+			return err
+		}
+
+		if err := binary.Write(w, order, uint64(len(v.values))); err != nil {
+			return err
+		}
+
+		for _, e := range v.values {
+			if err := writeRawValue(w, order, e); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	default:
+		return binary.Write(w, order, v)
+	}
+}
+
+// rewriteTensor is a tensor to be written and where its data is read from.
+type rewriteTensor struct {
+	*Tensor
+
+	r      io.ReaderAt
+	order  binary.ByteOrder
+	offset uint64
+}
+
+// rewrite writes the key values of the first model read from rs and the
+// tensors of all of them to w as a single GGUF file in byte order order, or
+// the byte order of the first model if order is nil.
+func rewrite(w io.Writer, order binary.ByteOrder, rs []io.ReaderAt) error {
+	var first *rawGGUF
+	var tensors []rewriteTensor
+	for i, r := range rs {
+		g, err := readRawGGUF(r)
+		if err != nil {
+			return err
+		}
+
+		kv := g.kv()
+		no, count := kv.Shard()
+		if count > 1 || len(rs) > 1 {
+			if count != len(rs) {
+				return fmt.Errorf("model is split into %d files but %d were given", count, len(rs))
+			} else if no != i {
+				return fmt.Errorf("expected split %d, got %d", i, no)
+			}
+		}
+
+		if i == 0 {
+			first = g
+		}
+
+		for _, t := range g.tensors {
+			tensors = append(tensors, rewriteTensor{Tensor: t, r: r, order: g.order, offset: g.offset})
+		}
+	}
+
+	if n, ok := first.kv()["split.tensors.count"]; ok && anyInt(n) != len(tensors) {
+		return fmt.Errorf("expected %d tensors across splits, got %d", anyInt(n), len(tensors))
+	}
+
+	if order == nil {
+		order = first.order
+	}
+
+	kvs := slices.DeleteFunc(slices.Clone(first.kvs), func(e rawKV) bool {
+		return strings.HasPrefix(e.key, "split.")
+	})
+
+	bw := bufio.NewWriterSize(w, 32<<10)
+	cw := &countingWriter{w: bw}
+
+	// the magic is always written little endian; readers detect the byte
+	// order from the version
+	if err := binary.Write(cw, binary.LittleEndian, uint32(FILE_MAGIC_GGUF_LE)); err != nil {
+		return err
+	}
+
+	if err := binary.Write(cw, order, uint32(3)); err != nil {
+		return err
+	}
+
+	if err := binary.Write(cw, order, []uint64{uint64(len(tensors)), uint64(len(kvs))}); err != nil {
+		return err
+	}
+
+	for _, e := range kvs {
+		if err := writeRawString(cw, order, e.key); err != nil {
+			return err
+		}
+
+		if err := binary.Write(cw, order, e.t); err != nil {
+			return err
+		}
+
+		if err := writeRawValue(cw, order, e.value); err != nil {
+			return err
+		}
+	}
+
+	var offset uint64
+	for _, t := range tensors {
+		if err := writeRawString(cw, order, t.Name); err != nil {
+			return err
+		}
+
+		if err := binary.Write(cw, order, uint32(len(t.Shape))); err != nil {
+			return err
+		}
+
+		if err := binary.Write(cw, order, t.Shape); err != nil {
+			return err
+		}
+
+		if err := binary.Write(cw, order, t.Kind); err != nil {
+			return err
+		}
+
+		offset += uint64(ggufPadding(int64(offset), int64(first.alignment)))
+		if err := binary.Write(cw, order, offset); err != nil {
+			return err
+		}
+
+		offset += t.Size()
+	}
+
+	for _, t := range tensors {
+		if err := cw.pad(first.alignment); err != nil {
+			return err
+		}
+
+		sr := io.NewSectionReader(t.r, int64(t.offset+t.Offset), int64(t.Size()))
+		if t.order == order {
+			if _, err := io.Copy(cw, sr); err != nil {
+				return err
+			}
+		} else if err := byteSwap(cw, sr, t.Tensor); err != nil {
+			return &TensorError{Name: t.Name, Err: err}
+		}
+	}
+
+	return bw.Flush()
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// pad writes zeros up to the next multiple of alignment.
+func (c *countingWriter) pad(alignment uint64) error {
+	_, err := c.Write(bytes.Repeat([]byte{0}, int(ggufPadding(c.n, int64(alignment)))))
+	return err
+}
+
+// byteSwapLayout returns the size of a block of tensor type kind and the
+// offset and width of every value in the block that must be swapped to change
+// its byte order.
+func byteSwapLayout(kind uint32) (size int, fields [][2]int, ok bool) {
+	size = int(Tensor{Kind: kind}.typeSize())
+	switch kind {
+	case 24: // I8
+		return size, nil, true
+	case 0, 1, 25, 26, 27, 28, 30: // F32, F16, I16, I32, I64, F64, BF16
+		return size, [][2]int{{0, size}}, true
+	case 2, 6, 8, 20: // Q4_0, Q5_0, Q8_0, IQ4_NL
+		return size, [][2]int{{0, 2}}, true
+	case 3, 7, 9, 12, 13: // Q4_1, Q5_1, Q8_1, Q4_K, Q5_K
+		return size, [][2]int{{0, 2}, {2, 2}}, true
+	case 10: // Q2_K
+		return size, [][2]int{{size - 4, 2}, {size - 2, 2}}, true
+	case 11, 14: // Q3_K, Q6_K
+		return size, [][2]int{{size - 2, 2}}, true
+	case 15: // Q8_K
+		fields = [][2]int{{0, 4}}
+		for i := range 16 {
+			fields = append(fields, [2]int{4 + 256 + 2*i, 2})
+		}
+		return size, fields, true
+	default:
+		return 0, nil, false
+	}
+}
+
+// byteSwap copies the data of t from r to w, swapping the byte order of
+// every value.
+func byteSwap(w io.Writer, r io.Reader, t *Tensor) error {
+	size, fields, ok := byteSwapLayout(t.Kind)
+	if !ok {
+		return fmt.Errorf("cannot change the byte order of %s tensors", t.Type())
+	}
+
+	bts := make([]byte, size*4096)
+	for {
+		n, err := io.ReadFull(r, bts)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		for b := 0; b+size <= n; b += size {
+			for _, f := range fields {
+				slices.Reverse(bts[b+f[0] : b+f[0]+f[1]])
+			}
+		}
+
+		if _, err := w.Write(bts[:n]); err != nil {
+			return err
+		}
+
+		if n < len(bts) {
+			return nil
+		}
+	}
+}
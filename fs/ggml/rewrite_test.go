@@ -0,0 +1,238 @@
+package ggml
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func writeTestGGUF(t *testing.T, kv KV, ts []Tensor) *os.File {
+	t.Helper()
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "model.gguf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+
+	if err := WriteGGUF(f, kv, ts); err != nil {
+		t.Fatal(err)
+	}
+
+	return f
+}
+
+func float32s(order binary.ByteOrder, vs ...float32) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, order, vs)
+	return b.Bytes()
+}
+
+func tensorData(t *testing.T, f io.ReadSeeker, name string) []byte {
+	t.Helper()
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	meta, _, err := Decode(f, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range meta.Tensors().Items() {
+		if tt.Name == name {
+			bts := make([]byte, tt.Size())
+			if _, err := f.Seek(int64(meta.Tensors().Offset+tt.Offset), io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := io.ReadFull(f, bts); err != nil {
+				t.Fatal(err)
+			}
+
+			return bts
+		}
+	}
+
+	t.Fatalf("tensor %q not found", name)
+	return nil
+}
+
+func TestMergeShards(t *testing.T) {
+	shard := func(no uint32, ts ...Tensor) *os.File {
+		return writeTestGGUF(t, KV{
+			"general.architecture": "test",
+			"split.no":             no,
+			"split.count":          uint32(2),
+			"split.tensors.count":  uint32(3),
+		}, ts)
+	}
+
+	a := shard(0,
+		Tensor{Name: "token_embd.weight", Kind: 0, Shape: []uint64{4}, WriterTo: bytes.NewReader(float32s(binary.LittleEndian, 1, 2, 3, 4))},
+		Tensor{Name: "blk.0.attn_q.weight", Kind: 0, Shape: []uint64{2}, WriterTo: bytes.NewReader(float32s(binary.LittleEndian, 5, 6))},
+	)
+	b := shard(1,
+		Tensor{Name: "output.weight", Kind: 0, Shape: []uint64{4}, WriterTo: bytes.NewReader(float32s(binary.LittleEndian, 7, 8, 9, 10))},
+	)
+
+	t.Run("merge", func(t *testing.T) {
+		f, err := os.Create(filepath.Join(t.TempDir(), "merged.gguf"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		if err := MergeShards(f, nil, a, b); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+
+		meta, _, err := Decode(f, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if no, count := meta.KV().Shard(); no != 0 || count != 0 {
+			t.Errorf("expected split keys to be removed, got %d of %d", no, count)
+		}
+
+		if arch := meta.KV().Architecture(); arch != "test" {
+			t.Errorf("expected architecture test, got %s", arch)
+		}
+
+		if n := len(meta.Tensors().Items()); n != 3 {
+			t.Fatalf("expected 3 tensors, got %d", n)
+		}
+
+		for name, expect := range map[string][]byte{
+			"token_embd.weight":   float32s(binary.LittleEndian, 1, 2, 3, 4),
+			"blk.0.attn_q.weight": float32s(binary.LittleEndian, 5, 6),
+			"output.weight":       float32s(binary.LittleEndian, 7, 8, 9, 10),
+		} {
+			if actual := tensorData(t, f, name); !bytes.Equal(actual, expect) {
+				t.Errorf("%s: expected %v, got %v", name, expect, actual)
+			}
+		}
+	})
+
+	cases := []struct {
+		name   string
+		shards []io.ReaderAt
+		err    string
+	}{
+		{"missing", []io.ReaderAt{a}, "model is split into 2 files but 1 were given"},
+		{"order", []io.ReaderAt{b, a}, "expected split 0, got 1"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := MergeShards(io.Discard, nil, tt.shards...)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected error %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestConvertByteOrder(t *testing.T) {
+	q8 := make([]byte, 34)
+	binary.LittleEndian.PutUint16(q8, 0x3c00)
+	for i := range 32 {
+		q8[2+i] = byte(i)
+	}
+
+	le := writeTestGGUF(t, KV{
+		"general.architecture":  "test",
+		"test.block_count":      uint32(1),
+		"test.rope.scale":       float32(0.5),
+		"tokenizer.ggml.tokens": []string{"a", "b"},
+		"tokenizer.ggml.scores": []float32{1, 2},
+	}, []Tensor{
+		{Name: "token_embd.weight", Kind: 0, Shape: []uint64{2}, WriterTo: bytes.NewReader(float32s(binary.LittleEndian, 1, math.Pi))},
+		{Name: "blk.0.attn_q.weight", Kind: 8, Shape: []uint64{32}, WriterTo: bytes.NewReader(q8)},
+	})
+
+	be, err := os.Create(filepath.Join(t.TempDir(), "model-be.gguf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer be.Close()
+
+	if err := ConvertByteOrder(be, le, binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := be.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	meta, _, err := Decode(be, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if order := meta.ByteOrder(); order != binary.ByteOrder(binary.BigEndian) {
+		t.Errorf("expected big endian, got %v", order)
+	}
+
+	if n := meta.KV().BlockCount(); n != 1 {
+		t.Errorf("expected block count 1, got %d", n)
+	}
+
+	if scale := meta.KV().Float("rope.scale"); scale != 0.5 {
+		t.Errorf("expected rope scale 0.5, got %f", scale)
+	}
+
+	if tokens := meta.KV().Strings("tokenizer.ggml.tokens"); !slices.Equal(tokens, []string{"a", "b"}) {
+		t.Errorf("expected tokens [a b], got %v", tokens)
+	}
+
+	if scores := meta.KV().Floats("tokenizer.ggml.scores"); !slices.Equal(scores, []float32{1, 2}) {
+		t.Errorf("expected scores [1 2], got %v", scores)
+	}
+
+	if actual, expect := tensorData(t, be, "token_embd.weight"), float32s(binary.BigEndian, 1, math.Pi); !bytes.Equal(actual, expect) {
+		t.Errorf("expected %v, got %v", expect, actual)
+	}
+
+	expect := slices.Clone(q8)
+	expect[0], expect[1] = expect[1], expect[0]
+	if actual := tensorData(t, be, "blk.0.attn_q.weight"); !bytes.Equal(actual, expect) {
+		t.Errorf("expected %v, got %v", expect, actual)
+	}
+
+	var back bytes.Buffer
+	if err := ConvertByteOrder(&back, be, binary.LittleEndian); err != nil {
+		t.Fatal(err)
+	}
+
+	original, err := os.ReadFile(le.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(back.Bytes(), original) {
+		t.Error("expected converting back to little endian to reproduce the original file")
+	}
+
+	t.Run("unsupported", func(t *testing.T) {
+		f := writeTestGGUF(t, KV{"general.architecture": "test"}, []Tensor{
+			{Name: "a", Kind: 16, Shape: []uint64{256}, WriterTo: bytes.NewReader(make([]byte, 66))},
+		})
+
+		if err := ConvertByteOrder(io.Discard, f, binary.BigEndian); err == nil || !strings.Contains(err.Error(), "cannot change the byte order") {
+			t.Errorf("expected unsupported type error, got %v", err)
+		}
+	})
+}
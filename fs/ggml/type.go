@@ -1,6 +1,9 @@
 package ggml
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 type fileType uint32
 
@@ -183,3 +186,39 @@ func (t fileType) String() string {
 func (t fileType) Value() uint32 {
 	return uint32(t)
 }
+
+// ParseTensorType returns the ggml type of a tensor type name, such as
+// "q6_K". Only types that tensors can be quantized to without an importance
+// matrix are supported.
+func ParseTensorType(s string) (uint32, error) {
+	switch strings.ToUpper(s) {
+	case "F32":
+		return 0, nil
+	case "F16":
+		return 1, nil
+	case "Q4_0":
+		return 2, nil
+	case "Q4_1":
+		return 3, nil
+	case "Q5_0":
+		return 6, nil
+	case "Q5_1":
+		return 7, nil
+	case "Q8_0":
+		return 8, nil
+	case "Q2_K":
+		return 10, nil
+	case "Q3_K":
+		return 11, nil
+	case "Q4_K":
+		return 12, nil
+	case "Q5_K":
+		return 13, nil
+	case "Q6_K":
+		return 14, nil
+	case "BF16":
+		return 30, nil
+	default:
+		return 0, fmt.Errorf("unsupported tensor type: %s", s)
+	}
+}
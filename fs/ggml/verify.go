@@ -0,0 +1,183 @@
+package ggml
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"runtime"
+	"slices"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// maxDims is the most dimensions a tensor can have.
+const maxDims = 4
+
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// TensorError reports a problem with a single tensor in a model file.
+type TensorError struct {
+	Name string
+	Err  error
+}
+
+func (e *TensorError) Error() string {
+	return fmt.Sprintf("tensor %q: %v", e.Name, e.Err)
+}
+
+func (e *TensorError) Unwrap() error {
+	return e.Err
+}
+
+// Checksums maps tensor names to the hex encoded SHA-256 of their data.
+type Checksums map[string]string
+
+// parallel calls fn for every tensor using one goroutine per CPU and returns
+// the first error.
+func (ts Tensors) parallel(ctx context.Context, fn func(int, *Tensor) error) error {
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(runtime.GOMAXPROCS(0))
+	for i, t := range ts.items {
+		g.Go(func() error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			return fn(i, t)
+		})
+	}
+
+	return g.Wait()
+}
+
+// Validate checks that every tensor has a known type and a valid shape, is
+// aligned, lies within the size bytes of tensor data and does not overlap
+// another tensor.
+func (ts Tensors) Validate(size, alignment uint64) error {
+	if err := ts.parallel(context.Background(), func(_ int, t *Tensor) error {
+		if err := t.validate(alignment); err != nil {
+			return &TensorError{Name: t.Name, Err: err}
+		}
+
+		if end := t.Offset + t.Size(); end < t.Offset || end > size {
+			return &TensorError{Name: t.Name, Err: fmt.Errorf("data at offset %d with size %d is past the end of the file", t.Offset, t.Size())}
+		}
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	sorted := slices.SortedFunc(slices.Values(ts.items), func(a, b *Tensor) int {
+		return cmp.Compare(a.Offset, b.Offset)
+	})
+
+	names := make(map[string]struct{}, len(sorted))
+	for i, t := range sorted {
+		if _, ok := names[t.Name]; ok {
+			return &TensorError{Name: t.Name, Err: errors.New("duplicate tensor")}
+		}
+		names[t.Name] = struct{}{}
+
+		if i > 0 {
+			if prev := sorted[i-1]; prev.Offset+prev.Size() > t.Offset {
+				return &TensorError{Name: t.Name, Err: fmt.Errorf("data overlaps tensor %q", prev.Name)}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (t Tensor) validate(alignment uint64) error {
+	if t.typeSize() == 0 {
+		return fmt.Errorf("unknown type %d", t.Kind)
+	}
+
+	if len(t.Shape) > maxDims {
+		return fmt.Errorf("invalid number of dimensions %d", len(t.Shape))
+	}
+
+	var count uint64 = 1
+	for _, n := range t.Shape {
+		hi, lo := bits.Mul64(count, n)
+		if hi != 0 {
+			return fmt.Errorf("invalid shape %v", t.Shape)
+		}
+		count = lo
+	}
+
+	if hi, _ := bits.Mul64(count, t.typeSize()); hi != 0 {
+		return fmt.Errorf("invalid shape %v", t.Shape)
+	}
+
+	if len(t.Shape) > 0 && t.Shape[0]%t.blockSize() != 0 {
+		return fmt.Errorf("shape %v is not a multiple of the %s block size %d", t.Shape, t.Type(), t.blockSize())
+	}
+
+	if alignment > 0 && t.Offset%alignment != 0 {
+		return fmt.Errorf("offset %d is not aligned to %d bytes", t.Offset, alignment)
+	}
+
+	return nil
+}
+
+func (t Tensor) checksum(r io.ReaderAt, offset uint64) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(r, int64(offset+t.Offset), int64(t.Size()))); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Checksums hashes the data of every tensor read from r in parallel.
+func (ts Tensors) Checksums(ctx context.Context, r io.ReaderAt) (Checksums, error) {
+	sums := make([]string, len(ts.items))
+	if err := ts.parallel(ctx, func(i int, t *Tensor) error {
+		sum, err := t.checksum(r, ts.Offset)
+		if err != nil {
+			return &TensorError{Name: t.Name, Err: err}
+		}
+
+		sums[i] = sum
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	checksums := make(Checksums, len(ts.items))
+	for i, t := range ts.items {
+		checksums[t.Name] = sums[i]
+	}
+
+	return checksums, nil
+}
+
+// Verify hashes the data of every tensor read from r in parallel and compares
+// it to sums. It returns a [*TensorError] wrapping [ErrChecksumMismatch] for
+// the first tensor that does not match.
+func (ts Tensors) Verify(ctx context.Context, r io.ReaderAt, sums Checksums) error {
+	return ts.parallel(ctx, func(_ int, t *Tensor) error {
+		expect, ok := sums[t.Name]
+		if !ok {
+			return &TensorError{Name: t.Name, Err: errors.New("missing from checksums")}
+		}
+
+		actual, err := t.checksum(r, ts.Offset)
+		if err != nil {
+			return &TensorError{Name: t.Name, Err: err}
+		}
+
+		if actual != expect {
+			return &TensorError{Name: t.Name, Err: fmt.Errorf("%w: expected %s, got %s", ErrChecksumMismatch, expect, actual)}
+		}
+
+		return nil
+	})
+}
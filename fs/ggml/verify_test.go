@@ -0,0 +1,143 @@
+package ggml
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTensorsValidate(t *testing.T) {
+	cases := []struct {
+		name    string
+		tensors []*Tensor
+		err     string
+	}{
+		{
+			name: "valid",
+			tensors: []*Tensor{
+				{Name: "a", Kind: 0, Offset: 0, Shape: []uint64{8}},
+				{Name: "b", Kind: 0, Offset: 32, Shape: []uint64{8}},
+			},
+		},
+		{
+			name:    "unknown type",
+			tensors: []*Tensor{{Name: "a", Kind: 99, Shape: []uint64{8}}},
+			err:     `tensor "a": unknown type 99`,
+		},
+		{
+			name:    "too many dimensions",
+			tensors: []*Tensor{{Name: "a", Kind: 0, Shape: []uint64{1, 1, 1, 1, 1}}},
+			err:     `tensor "a": invalid number of dimensions 5`,
+		},
+		{
+			name:    "partial block",
+			tensors: []*Tensor{{Name: "a", Kind: 2, Shape: []uint64{16}}},
+			err:     `tensor "a": shape [16] is not a multiple of the Q4_0 block size 32`,
+		},
+		{
+			name:    "overflow",
+			tensors: []*Tensor{{Name: "a", Kind: 0, Shape: []uint64{1 << 32, 1 << 32}}},
+			err:     `tensor "a": invalid shape`,
+		},
+		{
+			name:    "unaligned",
+			tensors: []*Tensor{{Name: "a", Kind: 0, Offset: 16, Shape: []uint64{4}}},
+			err:     `tensor "a": offset 16 is not aligned to 32 bytes`,
+		},
+		{
+			name:    "out of bounds",
+			tensors: []*Tensor{{Name: "a", Kind: 0, Offset: 32, Shape: []uint64{16}}},
+			err:     `tensor "a": data at offset 32 with size 64 is past the end of the file`,
+		},
+		{
+			name: "overlap",
+			tensors: []*Tensor{
+				{Name: "a", Kind: 0, Offset: 0, Shape: []uint64{16}},
+				{Name: "b", Kind: 0, Offset: 32, Shape: []uint64{8}},
+			},
+			err: `tensor "b": data overlaps tensor "a"`,
+		},
+		{
+			name: "duplicate",
+			tensors: []*Tensor{
+				{Name: "a", Kind: 0, Offset: 0, Shape: []uint64{8}},
+				{Name: "a", Kind: 0, Offset: 32, Shape: []uint64{8}},
+			},
+			err: `tensor "a": duplicate tensor`,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Tensors{items: tt.tensors}.Validate(64, 32)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			var terr *TensorError
+			if !errors.As(err, &terr) {
+				t.Fatalf("expected a tensor error, got %v", err)
+			}
+
+			if !strings.HasPrefix(err.Error(), tt.err) {
+				t.Errorf("expected error %q, got %q", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestTensorsVerify(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "model.gguf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := WriteGGUF(f, KV{"general.architecture": "test"}, []Tensor{
+		{Name: "a", Kind: 0, Shape: []uint64{4}, WriterTo: bytes.NewReader(bytes.Repeat([]byte{1}, 16))},
+		{Name: "b", Kind: 0, Shape: []uint64{4}, WriterTo: bytes.NewReader(bytes.Repeat([]byte{2}, 16))},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	meta, _, err := Decode(f, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sums, err := meta.Tensors().Checksums(context.Background(), f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sums) != 2 || sums["a"] == sums["b"] {
+		t.Fatalf("unexpected checksums %v", sums)
+	}
+
+	if err := meta.Tensors().Verify(context.Background(), f, sums); err != nil {
+		t.Fatal(err)
+	}
+
+	// corrupt the data of b
+	b := meta.Tensors().Items("b")[0]
+	if _, err := f.WriteAt([]byte{0}, int64(meta.Tensors().Offset+b.Offset)); err != nil {
+		t.Fatal(err)
+	}
+
+	err = meta.Tensors().Verify(context.Background(), f, sums)
+	var terr *TensorError
+	if !errors.As(err, &terr) || terr.Name != "b" || !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("expected checksum mismatch for b, got %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package kvcache
 
 import (
 	"errors"
+	"io"
 
 	"github.com/ollama/ollama/ml"
 	"github.com/ollama/ollama/model/input"
@@ -44,7 +45,7 @@ type Cache interface {
 	// ** cache management **
 
 	// Init sets up runtime parameters.
-	// backend: Used to allocate cache data storage and execute management operations (such as defrag)
+	// backend: Used to allocate cache data storage and execute management operations (such as shifting)
 	// dtype: The data type for storing cache entries
 	// maxSequences: The maximum number of sequences stored in the cache - across all batches
 	// capacity: The number of cache entries to store, per sequence
@@ -69,3 +70,15 @@ type Cache interface {
 	// removed by calling Remove(seq, 0, math.MaxInt32)
 	Remove(seq int, beginIndex, endIndex int32) error
 }
+
+// Snapshotter is implemented by caches that can save the entries of a
+// sequence and restore them later, such as after the model is loaded again.
+type Snapshotter interface {
+	// Snapshot writes the entries of seq to w
+	Snapshot(w io.Writer, seq int) error
+
+	// Restore reads entries written by Snapshot from r into seq, which must
+	// be empty. If an error occurs, the entire context for the sequence
+	// should be removed by calling Remove(seq, 0, math.MaxInt32)
+	Restore(r io.Reader, seq int) error
+}
@@ -1,8 +1,11 @@
 package kvcache
 
 import (
+	"cmp"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"math"
 	"slices"
@@ -13,15 +16,29 @@ import (
 
 type shiftFn func(ctx ml.Context, layer int, key, shift ml.Tensor) (ml.Tensor, error)
 
+// maxPageSize is the largest number of cells in a page of the cache
+const maxPageSize = 256
+
 // Causal cache stores K and V tensors according to their position in the
 // sequence. Returns the history and a mask for attending to past tokens
 //
 // The tensors are of shape embed dim, kv heads, batch size
 // The mask is of shape history size, batch size
+//
+// The cells of the cache are a pool shared by all sequences, which is split
+// into pages. A sequence is given an empty page once the pages it was given
+// before are full, so the cells of each sequence stay together and whole
+// pages become free again when a sequence is removed. Entries of a batch
+// don't need to be next to each other, so the cache never has to be
+// defragmented and is only full once there are no free cells left.
 type Causal struct {
 	DType      ml.DType
 	windowSize int32
 
+	// sinkSize is the number of positions at the start of each sequence
+	// that are kept and attended to even once they are outside of the window
+	sinkSize int32
+
 	opts CausalOptions
 
 	// config controls mostly backend-specific optimizations
@@ -32,8 +49,8 @@ type Causal struct {
 	// the active layer for Get and Put
 	curLayer int
 
-	// starting location for data storage for this batch
-	curLoc int
+	// locations for data storage of each entry in this batch
+	curLocs []int
 
 	// size of the current batch
 	curBatchSize int
@@ -59,12 +76,30 @@ type Causal struct {
 	// maps from sequence to the range of locations where it is stored in the cache
 	cellRanges map[int]cellRange
 
+	// pageSize is the number of cells in each page
+	pageSize int
+
+	// pageOwners is the sequence that each page was last given to
+	pageOwners []int
+
 	// ** cache data storage **
 
 	shiftFn      shiftFn
 	backend      ml.Backend
 	ctxs         map[int]ml.Context
 	keys, values map[int]ml.Tensor
+
+	// restored holds the layers of a snapshot restored before the model has
+	// stored anything, which are written once the model first stores to them
+	restored map[int]restoredLayer
+}
+
+// restoredLayer is the keys and values of a layer of a snapshot, entry by
+// entry, and the cells that they are stored in
+type restoredLayer struct {
+	locs                           []int
+	kData, vData                   []byte
+	kHeadDim, vHeadDim, numKVHeads int
 }
 
 type cacheCell struct {
@@ -97,7 +132,22 @@ func NewSWACache(windowSize int32, shift shiftFn) *Causal {
 	}
 }
 
+// NewSWASinkCache is a sliding window cache that also keeps the first
+// sinkSize positions of each sequence as attention sinks, which models can
+// rely on to stay stable when the window slides over long contexts.
+func NewSWASinkCache(windowSize, sinkSize int32, shift shiftFn) *Causal {
+	return &Causal{
+		windowSize: windowSize,
+		sinkSize:   sinkSize,
+		shiftFn:    shift,
+		ctxs:       make(map[int]ml.Context),
+		keys:       make(map[int]ml.Tensor),
+		values:     make(map[int]ml.Tensor),
+	}
+}
+
 func (c *Causal) Init(backend ml.Backend, dtype ml.DType, maxSequences, capacity, maxBatch int) {
+	modelConfig := c.config != nil
 	if c.config == nil {
 		var config ml.CacheConfig
 		if cc, ok := backend.(ml.BackendCacheConfig); ok {
@@ -118,15 +168,26 @@ func (c *Causal) Init(backend ml.Backend, dtype ml.DType, maxSequences, capacity
 		c.config.MaskDType = ml.DTypeF32
 	}
 
-	var cacheSize int
-	if c.windowSize == math.MaxInt32 || capacity < int(c.windowSize)+maxBatch {
-		cacheSize = maxSequences * capacity
-	} else {
-		cacheSize = maxSequences * (int(c.windowSize) + maxBatch)
+	// Quantized blocks can only be read by the backend's fused attention, so models
+	// that compute attention themselves and permuted values need f16 instead
+	if isQuantized(dtype) && (modelConfig || c.config.PermutedV) {
+		slog.Warn("quantized kv cache not supported, using f16", "dtype", dtype)
+		dtype = ml.DTypeF16
+	}
+
+	seqSize := capacity
+	if c.windowSize != math.MaxInt32 && capacity >= int(c.windowSize)+int(c.sinkSize)+maxBatch {
+		seqSize = int(c.windowSize) + int(c.sinkSize) + maxBatch
 	}
-	cacheSize = roundUp(cacheSize, c.config.CachePadding)
+	cacheSize := roundUp(maxSequences*seqSize, c.config.CachePadding)
 	c.cells = make([]cacheCell, cacheSize)
 
+	c.pageSize = roundUp(min(seqSize, maxPageSize), c.config.CachePadding)
+	c.pageOwners = make([]int, (cacheSize+c.pageSize-1)/c.pageSize)
+	for i := range c.pageOwners {
+		c.pageOwners[i] = -1
+	}
+
 	c.DType = dtype
 	c.cellRanges = make(map[int]cellRange)
 	c.backend = backend
@@ -152,14 +213,19 @@ func (c *Causal) StartForward(ctx ml.Context, batch input.Batch) error {
 	c.curPositions = batch.Positions
 	c.opts.Except = nil
 
+	// restored layers that the model didn't store to in its last forward pass
+	// are not layers of the model
+	if len(c.keys) > 0 {
+		for layer := range c.restored {
+			slog.Warn("snapshot has a layer that the model doesn't have", "layer", layer)
+			delete(c.restored, layer)
+		}
+	}
+
 	c.updateSlidingWindow()
 
 	var err error
-	c.curLoc, err = c.findStartLoc()
-	if errors.Is(err, ErrKvCacheFull) {
-		c.defrag()
-		c.curLoc, err = c.findStartLoc()
-	}
+	c.curLocs, err = c.findLocs(c.maxRuns(ctx.MaxGraphNodes()))
 	if err != nil {
 		return err
 	}
@@ -167,23 +233,24 @@ func (c *Causal) StartForward(ctx ml.Context, batch input.Batch) error {
 	c.curCellRange = newRange()
 	for i, pos := range batch.Positions {
 		seq := batch.Sequences[i]
+		loc := c.curLocs[i]
 
-		c.cells[c.curLoc+i] = cacheCell{pos: pos, sequences: []int{seq}}
+		c.cells[loc] = cacheCell{pos: pos, sequences: []int{seq}}
 
 		seqRange, ok := c.cellRanges[seq]
 		if !ok {
 			seqRange = newRange()
 		}
 
-		if c.curLoc+i > seqRange.max {
-			seqRange.max = c.curLoc + i
+		if loc > seqRange.max {
+			seqRange.max = loc
 		}
 		if seqRange.max > c.curCellRange.max {
 			c.curCellRange.max = seqRange.max
 		}
 
-		if c.curLoc+i < seqRange.min {
-			seqRange.min = c.curLoc + i
+		if loc < seqRange.min {
+			seqRange.min = loc
 		}
 		if seqRange.min < c.curCellRange.min {
 			c.curCellRange.min = seqRange.min
@@ -203,22 +270,118 @@ func newRange() cellRange {
 	}
 }
 
-// Find the first contiguous block of at least curBatchSize
-func (c *Causal) findStartLoc() (int, error) {
-	var start, count int
-	for i := range c.cells {
-		if len(c.cells[i].sequences) == 0 {
-			count++
-			if count >= c.curBatchSize {
-				return start, nil
+// findLocs finds a free cell for each entry of the batch. Sequences use the
+// free cells of their own pages first, then empty pages, and then the longest
+// stretches of free cells of any page once there are no empty pages left.
+// Each run of consecutive cells is stored with its own copies, so the batch
+// may be stored in at most maxRuns runs. Pages are only given to sequences
+// if a cell is found for the whole batch.
+func (c *Causal) findLocs(maxRuns int) ([]int, error) {
+	locs := make([]int, c.curBatchSize)
+	taken := make(map[int]bool, c.curBatchSize)
+	owners := slices.Clone(c.pageOwners)
+
+	free := func(i int) bool {
+		return len(c.cells[i].sequences) == 0 && !taken[i]
+	}
+
+	// there are no free cells for this batch before the cursors
+	seqCursors := make(map[int]int)
+	var pageCursor int
+
+	var pending []int
+	for i, seq := range c.curSequences {
+		loc := -1
+
+		for j := seqCursors[seq]; j < len(c.cells); j++ {
+			if owners[j/c.pageSize] != seq {
+				j = (j/c.pageSize+1)*c.pageSize - 1
+			} else if free(j) {
+				loc = j
+				break
 			}
-		} else {
-			start = i + 1
-			count = 0
 		}
+
+		for ; loc < 0 && pageCursor < len(owners); pageCursor++ {
+			start := pageCursor * c.pageSize
+			end := min(start+c.pageSize, len(c.cells))
+
+			empty := true
+			for j := start; j < end && empty; j++ {
+				empty = free(j)
+			}
+
+			if empty {
+				owners[pageCursor] = seq
+				loc = start
+			}
+		}
+
+		if loc < 0 {
+			// the pages of the sequence are full
+			pending = append(pending, i)
+			seqCursors[seq] = len(c.cells)
+			continue
+		}
+
+		locs[i] = loc
+		taken[loc] = true
+		seqCursors[seq] = loc + 1
+	}
+
+	if len(pending) > 0 {
+		var stretches []cellRange
+		for i := 0; i < len(c.cells); i++ {
+			if free(i) {
+				start := i
+				for i+1 < len(c.cells) && free(i+1) {
+					i++
+				}
+				stretches = append(stretches, cellRange{min: start, max: i})
+			}
+		}
+
+		slices.SortStableFunc(stretches, func(a, b cellRange) int {
+			return cmp.Compare(b.max-b.min, a.max-a.min)
+		})
+
+		for _, stretch := range stretches {
+			for loc := stretch.min; loc <= stretch.max && len(pending) > 0; loc++ {
+				locs[pending[0]] = loc
+				pending = pending[1:]
+			}
+		}
+
+		if len(pending) > 0 {
+			return nil, fmt.Errorf("%w (length: %v)", ErrKvCacheFull, len(c.cells))
+		}
+	}
+
+	runs := 1
+	for i := 1; i < len(locs); i++ {
+		if locs[i] != locs[i-1]+1 {
+			runs++
+		}
+	}
+
+	if runs > maxRuns {
+		return nil, fmt.Errorf("%w (length: %v, free cells are too fragmented: %v runs, max %v)", ErrKvCacheFull, len(c.cells), runs, maxRuns)
 	}
 
-	return 0, fmt.Errorf("%w (length: %v)", ErrKvCacheFull, len(c.cells))
+	c.pageOwners = owners
+	return locs, nil
+}
+
+// graphNodesPerRun is the number of graph nodes that storing the keys and
+// values of a run of consecutive cells adds for each layer
+const graphNodesPerRun = 8
+
+// maxRuns returns the number of runs of consecutive cells that a batch can
+// be stored in, so that storing it in all of the layers of the cache uses at
+// most a quarter of a graph of graphNodes nodes and leaves the rest for the
+// model and any other caches.
+func (c *Causal) maxRuns(graphNodes int) int {
+	return max(1, graphNodes/4/(graphNodesPerRun*max(1, len(c.keys))))
 }
 
 func (c *Causal) updateSlidingWindow() {
@@ -241,7 +404,8 @@ func (c *Causal) updateSlidingWindow() {
 		lowestPos[seq] = pos
 	}
 
-	// delete any entries that are beyond the window of the oldest position in the sequence
+	// delete any entries that are beyond the window of the oldest position in the sequence,
+	// except for the attention sinks
 	for seq, pos := range lowestPos {
 		oldRange, ok := c.cellRanges[seq]
 		if !ok {
@@ -252,7 +416,7 @@ func (c *Causal) updateSlidingWindow() {
 
 		for i := oldRange.min; i <= oldRange.max; i++ {
 			if slices.Contains(c.cells[i].sequences, seq) {
-				if c.cells[i].pos < pos-c.windowSize {
+				if c.cells[i].pos >= c.sinkSize && c.cells[i].pos < pos-c.windowSize {
 					c.cells[i].sequences = slices.DeleteFunc(c.cells[i].sequences, func(s int) bool { return s == seq })
 				} else {
 					newRange.min = min(newRange.min, i)
@@ -273,6 +437,10 @@ func roundUp(length, pad int) int {
 	return ((length + pad - 1) / pad) * pad
 }
 
+func isQuantized(dtype ml.DType) bool {
+	return dtype == ml.DTypeQ80 || dtype == ml.DTypeQ40
+}
+
 // Builds a mask of history x batch indicating whether for each token in the batch the
 // token in the history should apply. This is based on both the sequence and causality (the
 // position of the history is not ahead of the token in the batch).
@@ -291,7 +459,7 @@ func (c *Causal) buildMask(ctx ml.Context) (ml.Tensor, error) {
 		for j := c.curCellRange.min; j <= c.curCellRange.max; j++ {
 			if !slices.Contains(c.cells[j].sequences, c.curSequences[i]) ||
 				(enabled && c.cells[j].pos > c.curPositions[i]) ||
-				c.cells[j].pos < c.curPositions[i]-c.windowSize {
+				(c.cells[j].pos >= c.sinkSize && c.cells[j].pos < c.curPositions[i]-c.windowSize) {
 				mask[i*length+(j-c.curCellRange.min)] = float32(math.Inf(-1))
 			}
 		}
@@ -317,143 +485,6 @@ func (c *Causal) buildMask(ctx ml.Context) (ml.Tensor, error) {
 	return maskTensor, nil
 }
 
-func (c *Causal) moveCells(ctx ml.Context, src, dst, length int) {
-	for i, key := range c.keys {
-		if key == nil {
-			continue
-		}
-
-		kHeadDim := key.Dim(0)
-		numKVHeads := key.Dim(1)
-		rowSize := key.Stride(2)
-
-		kSrcView := key.View(ctx, rowSize*src, kHeadDim*numKVHeads*length)
-		kDstView := key.View(ctx, rowSize*dst, kHeadDim*numKVHeads*length)
-
-		value := c.values[i]
-		var vSrcView, vDstView ml.Tensor
-		if c.config.PermutedV {
-			vHeadDim := value.Dim(1)
-			elemSize := value.Stride(0)
-
-			vSrcView = value.View(ctx, elemSize*src, length, len(c.cells)*elemSize, vHeadDim*numKVHeads)
-			vDstView = value.View(ctx, elemSize*dst, length, len(c.cells)*elemSize, vHeadDim*numKVHeads)
-		} else {
-			vHeadDim := value.Dim(0)
-			rowSize := value.Stride(2)
-
-			vSrcView = value.View(ctx, rowSize*src, vHeadDim*numKVHeads*length)
-			vDstView = value.View(ctx, rowSize*dst, vHeadDim*numKVHeads*length)
-		}
-
-		ctx.Forward(
-			kSrcView.Copy(ctx, kDstView),
-			vSrcView.Copy(ctx, vDstView),
-		)
-	}
-}
-
-func (c *Causal) defrag() {
-	slog.Debug("defragmenting kv cache")
-
-	// Defrag strategy:
-	// - Search for empty holes at the beginning of the cache,
-	//   filling them with active data starting at the end
-	// - If there are contiguous elements that need to be moved,
-	//   combine them into a single operation by holding new moves
-	//   until we see that the next one is non-contiguous
-	// - Fill up the context with the maximum number of operations it
-	//   can hold then compute that and continue with a new context
-	//
-	// We could try to optimize placement by grouping blocks from
-	// the same sequences together but most likely the next forward
-	// pass will disrupt this anyways, so the real world benefit
-	// seems limited as this time.
-
-	ctx := c.backend.NewContext()
-
-	// For every move, 6 tensors are required per layer (2 views and a
-	// copy for each of k and v). We also need to refer to the original
-	// k and v cache tensors - once per layer, not per move.
-	layers := 0
-	for _, key := range c.keys {
-		if key == nil {
-			continue
-		}
-		layers++
-	}
-
-	maxMoves := (ctx.MaxGraphNodes() - 2*layers) / (6 * layers)
-	moves := 0
-
-	var pendingSrc, pendingDst, pendingLen int
-	src := len(c.cells) - 1
-
-	for dst := 0; dst < src; dst++ {
-		if len(c.cells[dst].sequences) == 0 {
-			for ; src > dst; src-- {
-				if len(c.cells[src].sequences) != 0 {
-					c.cells[dst] = c.cells[src]
-					c.cells[src] = cacheCell{}
-
-					if pendingLen > 0 {
-						if src == pendingSrc-pendingLen && dst == pendingDst+pendingLen {
-							pendingSrc = src
-							pendingLen++
-							break
-						} else {
-							c.moveCells(ctx, pendingSrc, pendingDst, pendingLen)
-							moves++
-						}
-					}
-
-					pendingSrc = src
-					pendingDst = dst
-					pendingLen = 1
-
-					break
-				}
-			}
-		}
-
-		if moves >= maxMoves {
-			ctx.Compute()
-			ctx.Close()
-			ctx = c.backend.NewContext()
-
-			moves = 0
-		}
-	}
-
-	if pendingLen > 0 {
-		c.moveCells(ctx, pendingSrc, pendingDst, pendingLen)
-		moves++
-	}
-
-	if moves > 0 {
-		ctx.Compute()
-	}
-	ctx.Close()
-
-	// Reset range metadata
-	for seq := range c.cellRanges {
-		seqRange := newRange()
-
-		for i, cell := range c.cells {
-			if slices.Contains(cell.sequences, seq) {
-				if i < seqRange.min {
-					seqRange.min = i
-				}
-				if i > seqRange.max {
-					seqRange.max = i
-				}
-			}
-		}
-
-		c.cellRanges[seq] = seqRange
-	}
-}
-
 func (c *Causal) SetLayer(layer int) {
 	c.curLayer = layer
 }
@@ -479,6 +510,18 @@ func (c *Causal) SetCausal(ctx ml.Context, opts CausalOptions) {
 	}
 }
 
+// Positions returns the position of each entry of the history returned by Get,
+// for models that compute attention biases from the distance between tokens.
+// Entries that are masked for the whole batch have meaningless positions.
+func (c *Causal) Positions() []int32 {
+	positions := make([]int32, c.curCellRange.max-c.curCellRange.min+1)
+	for i := range positions {
+		positions[i] = c.cells[c.curCellRange.min+i].pos
+	}
+
+	return positions
+}
+
 func (c *Causal) Get(ctx ml.Context) (ml.Tensor, ml.Tensor, ml.Tensor) {
 	key := c.keys[c.curLayer]
 	value := c.values[c.curLayer]
@@ -543,18 +586,54 @@ func (c *Causal) Put(ctx ml.Context, key, value ml.Tensor) {
 		}
 	}
 
+	if r, ok := c.restored[c.curLayer]; ok {
+		delete(c.restored, c.curLayer)
+
+		if r.kHeadDim != kHeadDim || r.vHeadDim != vHeadDim || r.numKVHeads != numKVHeads {
+			slog.Warn("snapshot of layer has a different shape than the model", "layer", c.curLayer)
+		} else if err := c.restoreLayer(c.curLayer, r); err != nil {
+			slog.Warn("unable to restore layer", "layer", c.curLayer, "error", err)
+		}
+	}
+
+	// entries that are next to each other in the cache are stored together
+	for start := 0; start < batchSize; {
+		end := start + 1
+		for end < batchSize && c.curLocs[end] == c.curLocs[end-1]+1 {
+			end++
+		}
+
+		c.put(ctx, key, value, start, end)
+		start = end
+	}
+}
+
+// put stores the entries of the batch from start to end in the cells from
+// the location of start.
+func (c *Causal) put(ctx ml.Context, key, value ml.Tensor, start, end int) {
+	kHeadDim := key.Dim(0)
+	vHeadDim := value.Dim(0)
+	numKVHeads := key.Dim(1)
+	length := end - start
+	loc := c.curLocs[start]
+
+	if length != key.Dim(2) {
+		key = key.View(ctx, key.Stride(2)*start, kHeadDim, key.Stride(1), numKVHeads, key.Stride(2), length)
+		value = value.View(ctx, value.Stride(2)*start, vHeadDim, value.Stride(1), numKVHeads, value.Stride(2), length)
+	}
+
 	rowSize := c.keys[c.curLayer].Stride(2)
-	ctx.Forward(key.Copy(ctx, c.keys[c.curLayer].View(ctx, rowSize*c.curLoc, kHeadDim*numKVHeads*batchSize)))
+	ctx.Forward(key.Copy(ctx, c.keys[c.curLayer].View(ctx, rowSize*loc, kHeadDim*numKVHeads*length)))
 
 	if c.config.PermutedV {
 		elemSize := c.values[c.curLayer].Stride(0)
 
 		value = value.Permute(ctx, 1, 2, 0, 3)
-		ctx.Forward(value.Copy(ctx, c.values[c.curLayer].View(ctx, elemSize*c.curLoc, batchSize, len(c.cells)*elemSize, vHeadDim*numKVHeads)))
+		ctx.Forward(value.Copy(ctx, c.values[c.curLayer].View(ctx, elemSize*loc, length, len(c.cells)*elemSize, vHeadDim*numKVHeads)))
 	} else {
 		rowSize := c.values[c.curLayer].Stride(2)
 
-		ctx.Forward(value.Copy(ctx, c.values[c.curLayer].View(ctx, rowSize*c.curLoc, vHeadDim*numKVHeads*batchSize)))
+		ctx.Forward(value.Copy(ctx, c.values[c.curLayer].View(ctx, rowSize*loc, vHeadDim*numKVHeads*length)))
 	}
 }
 
@@ -621,7 +700,14 @@ func (c *Causal) shift(seq int, beginIndex, offset int32) error {
 			size,
 		)
 
-		roped, err := c.shiftFn(ctx, i, key, kShift)
+		// RoPE can't be applied to quantized blocks, so those are
+		// dequantized for the shift and quantized again when stored
+		shifted := key
+		if isQuantized(c.DType) {
+			shifted = key.Cast(ctx, ml.DTypeF32)
+		}
+
+		roped, err := c.shiftFn(ctx, i, shifted, kShift)
 		if err != nil {
 			return err
 		}
@@ -629,7 +715,43 @@ func (c *Causal) shift(seq int, beginIndex, offset int32) error {
 		ctx.Forward(roped.Copy(ctx, key))
 	}
 
-	ctx.Compute()
+	// restored layers are shifted where they are held
+	var layers []int
+	var restored []ml.Tensor
+	for layer, r := range c.restored {
+		offsets := make([]int32, len(r.locs))
+		for i, loc := range r.locs {
+			if slices.Contains(c.cells[loc].sequences, seq) && c.cells[loc].pos >= beginIndex {
+				offsets[i] = offset
+			}
+		}
+
+		kShift, err := ctx.Input().FromIntSlice(offsets, len(offsets))
+		if err != nil {
+			return err
+		}
+
+		key, err := ctx.Input().FromBytes(c.DType, r.kData, r.kHeadDim, r.numKVHeads, len(r.locs))
+		if err != nil {
+			return err
+		}
+
+		roped, err := c.shiftFn(ctx, layer, key, kShift)
+		if err != nil {
+			return err
+		}
+
+		layers = append(layers, layer)
+		restored = append(restored, roped.Copy(ctx, ctx.Input().Empty(c.DType, key.Shape()...)))
+	}
+
+	ctx.Forward(restored...).Compute(restored...)
+
+	for i, layer := range layers {
+		r := c.restored[layer]
+		r.kData = restored[i].Bytes()
+		c.restored[layer] = r
+	}
 
 	return nil
 }
@@ -681,3 +803,328 @@ func (c *Causal) Remove(seq int, beginIndex, endIndex int32) error {
 
 	return nil
 }
+
+// snapshotHeader starts the entries of a sequence written by Snapshot,
+// followed by their positions
+type snapshotHeader struct {
+	DType   int32
+	Entries uint32
+	Layers  uint32
+}
+
+// snapshotLayer starts the keys and values of a layer written by Snapshot,
+// each of which are stored entry by entry in the order of the positions
+type snapshotLayer struct {
+	Layer                          uint32
+	KHeadDim, VHeadDim, NumKVHeads uint32
+	KSize, VSize                   uint64
+}
+
+func (c *Causal) Snapshot(w io.Writer, seq int) error {
+	// quantized blocks can't be read back from the backend on their own
+	if isQuantized(c.DType) {
+		return ErrNotSupported
+	}
+
+	if len(c.restored) > 0 {
+		return errors.New("restored entries are not yet stored in the cache")
+	}
+
+	var locs []int
+	if seqRange, ok := c.cellRanges[seq]; ok {
+		for i := seqRange.min; i <= seqRange.max; i++ {
+			if slices.Contains(c.cells[i].sequences, seq) {
+				locs = append(locs, i)
+			}
+		}
+	}
+
+	var layers []int
+	if len(locs) > 0 {
+		for layer, key := range c.keys {
+			if key != nil {
+				layers = append(layers, layer)
+			}
+		}
+		slices.Sort(layers)
+	}
+
+	header := snapshotHeader{DType: int32(c.DType), Entries: uint32(len(locs)), Layers: uint32(len(layers))}
+	if err := binary.Write(w, binary.LittleEndian, header); err != nil {
+		return err
+	}
+
+	// entries are written in the order of their positions
+	order := make([]int, len(locs))
+	for i := range order {
+		order[i] = i
+	}
+
+	slices.SortFunc(order, func(a, b int) int {
+		return cmp.Compare(c.cells[locs[a]].pos, c.cells[locs[b]].pos)
+	})
+
+	positions := make([]int32, len(locs))
+	for i, j := range order {
+		positions[i] = c.cells[locs[j]].pos
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, positions); err != nil {
+		return err
+	}
+
+	for _, layer := range layers {
+		key, value, vHeadDim := c.readCells(layer, locs)
+
+		kRowSize := len(key) / len(locs)
+		vRowSize := len(value) / len(locs)
+
+		l := snapshotLayer{
+			Layer:      uint32(layer),
+			KHeadDim:   uint32(c.keys[layer].Dim(0)),
+			VHeadDim:   uint32(vHeadDim),
+			NumKVHeads: uint32(c.keys[layer].Dim(1)),
+			KSize:      uint64(len(key)),
+			VSize:      uint64(len(value)),
+		}
+
+		if err := binary.Write(w, binary.LittleEndian, l); err != nil {
+			return err
+		}
+
+		for _, data := range []struct {
+			b       []byte
+			rowSize int
+		}{{key, kRowSize}, {value, vRowSize}} {
+			rows := make([]byte, 0, len(data.b))
+			for _, j := range order {
+				rows = append(rows, data.b[j*data.rowSize:(j+1)*data.rowSize]...)
+			}
+
+			if _, err := w.Write(rows); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// readCells returns the keys and values of layer in the cells at locs, which
+// are in ascending order, cell by cell. Cells that are next to each other are
+// read together, in as many graphs as it takes to stay within their size.
+func (c *Causal) readCells(layer int, locs []int) (key, value []byte, vHeadDim int) {
+	var runs []cellRange
+	for i, loc := range locs {
+		if i > 0 && loc == locs[i-1]+1 {
+			runs[len(runs)-1].max = loc
+		} else {
+			runs = append(runs, cellRange{min: loc, max: loc})
+		}
+	}
+
+	for len(runs) > 0 {
+		ctx := c.backend.NewContext()
+
+		n := min(len(runs), max(1, ctx.MaxGraphNodes()/graphNodesPerRun))
+
+		var tensors []ml.Tensor
+		for _, run := range runs[:n] {
+			var k, v ml.Tensor
+			k, v, vHeadDim = c.viewCells(ctx, layer, run.min, run.max-run.min+1)
+			tensors = append(tensors, k, v)
+		}
+
+		ctx.Forward(tensors...).Compute(tensors...)
+
+		for i := 0; i < len(tensors); i += 2 {
+			key = append(key, tensors[i].Bytes()...)
+			value = append(value, tensors[i+1].Bytes()...)
+		}
+
+		ctx.Close()
+		runs = runs[n:]
+	}
+
+	return key, value, vHeadDim
+}
+
+// viewCells returns contiguous copies of the keys and values of layer in
+// length cells starting at loc, cell by cell.
+func (c *Causal) viewCells(ctx ml.Context, layer, loc, length int) (key, value ml.Tensor, vHeadDim int) {
+	key = c.keys[layer]
+	kHeadDim := key.Dim(0)
+	numKVHeads := key.Dim(1)
+	key = key.View(ctx, key.Stride(2)*loc, kHeadDim*numKVHeads*length).Contiguous(ctx)
+
+	value = c.values[layer]
+	if c.config.PermutedV {
+		vHeadDim = value.Dim(1)
+		elemSize := value.Stride(0)
+
+		value = value.View(ctx, elemSize*loc, length, len(c.cells)*elemSize, vHeadDim*numKVHeads)
+		value = value.Permute(ctx, 1, 0, 2, 3).Contiguous(ctx)
+	} else {
+		vHeadDim = value.Dim(0)
+
+		value = value.View(ctx, value.Stride(2)*loc, vHeadDim*numKVHeads*length).Contiguous(ctx)
+	}
+
+	return key, value, vHeadDim
+}
+
+func (c *Causal) Restore(r io.Reader, seq int) error {
+	if isQuantized(c.DType) {
+		return ErrNotSupported
+	}
+
+	var header snapshotHeader
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return err
+	}
+
+	if ml.DType(header.DType) != c.DType {
+		return fmt.Errorf("snapshot has a different data type (snapshot: %v, cache: %v)", header.DType, c.DType)
+	}
+
+	if int(header.Entries) > len(c.cells) {
+		return fmt.Errorf("%w (length: %v, entries: %v)", ErrKvCacheFull, len(c.cells), header.Entries)
+	}
+
+	positions := make([]int32, header.Entries)
+	if err := binary.Read(r, binary.LittleEndian, positions); err != nil {
+		return err
+	}
+
+	if len(positions) == 0 {
+		if header.Layers > 0 {
+			return errors.New("snapshot has layers without entries")
+		}
+		return nil
+	}
+
+	c.curBatchSize = len(positions)
+	c.curSequences = slices.Repeat([]int{seq}, len(positions))
+	c.curPositions = positions
+
+	// the entries are stored one layer at a time, each in a graph of its own
+	ctx := c.backend.NewContext()
+	maxRuns := ctx.MaxGraphNodes() / graphNodesPerRun
+	ctx.Close()
+
+	var err error
+	c.curLocs, err = c.findLocs(maxRuns)
+	if err != nil {
+		return err
+	}
+
+	seqRange := newRange()
+	for i, loc := range c.curLocs {
+		c.cells[loc] = cacheCell{pos: positions[i], sequences: []int{seq}}
+		seqRange.min = min(seqRange.min, loc)
+		seqRange.max = max(seqRange.max, loc)
+	}
+	c.cellRanges[seq] = seqRange
+
+	// until the model has stored anything, the cache doesn't know its layers,
+	// so they are held until the model stores to them
+	restored := make(map[int]restoredLayer)
+	for range header.Layers {
+		var l snapshotLayer
+		if err := binary.Read(r, binary.LittleEndian, &l); err != nil {
+			return err
+		}
+
+		// entries are at most 4 bytes per element, which bounds what is read
+		// from a snapshot that is corrupt
+		kElems := uint64(l.KHeadDim) * uint64(l.NumKVHeads) * uint64(len(positions))
+		vElems := uint64(l.VHeadDim) * uint64(l.NumKVHeads) * uint64(len(positions))
+		if l.KSize > 4*kElems || l.VSize > 4*vElems {
+			return fmt.Errorf("snapshot of layer %d is too large", l.Layer)
+		}
+
+		layer := int(l.Layer)
+		if _, ok := restored[layer]; ok {
+			return fmt.Errorf("snapshot has layer %d more than once", l.Layer)
+		}
+
+		key, ok := c.keys[layer]
+		if len(c.keys) > 0 && !ok {
+			return fmt.Errorf("snapshot has layer %d, which the model doesn't have", l.Layer)
+		} else if ok && (key.Dim(0) != int(l.KHeadDim) || key.Dim(1) != int(l.NumKVHeads)) {
+			return fmt.Errorf("snapshot of layer %d has a different shape", l.Layer)
+		}
+
+		rl := restoredLayer{
+			locs:       c.curLocs,
+			kData:      make([]byte, l.KSize),
+			vData:      make([]byte, l.VSize),
+			kHeadDim:   int(l.KHeadDim),
+			vHeadDim:   int(l.VHeadDim),
+			numKVHeads: int(l.NumKVHeads),
+		}
+
+		if _, err := io.ReadFull(r, rl.kData); err != nil {
+			return err
+		}
+
+		if _, err := io.ReadFull(r, rl.vData); err != nil {
+			return err
+		}
+
+		// layers restored into other sequences are held with these
+		if prev, ok := c.restored[layer]; ok {
+			if prev.kHeadDim != rl.kHeadDim || prev.vHeadDim != rl.vHeadDim || prev.numKVHeads != rl.numKVHeads {
+				return fmt.Errorf("snapshot of layer %d has a different shape", l.Layer)
+			}
+
+			rl.locs = slices.Concat(prev.locs, rl.locs)
+			rl.kData = slices.Concat(prev.kData, rl.kData)
+			rl.vData = slices.Concat(prev.vData, rl.vData)
+		}
+
+		restored[layer] = rl
+	}
+
+	for layer, rl := range restored {
+		if _, ok := c.keys[layer]; !ok {
+			if c.restored == nil {
+				c.restored = make(map[int]restoredLayer)
+			}
+			c.restored[layer] = rl
+		} else if err := c.restoreLayer(layer, rl); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// restoreLayer stores the keys and values of layer in the cells of r.
+func (c *Causal) restoreLayer(layer int, r restoredLayer) error {
+	ctx := c.backend.NewContext()
+	defer ctx.Close()
+
+	key, err := ctx.Input().FromBytes(c.DType, r.kData, r.kHeadDim, r.numKVHeads, len(r.locs))
+	if err != nil {
+		return err
+	}
+
+	value, err := ctx.Input().FromBytes(c.DType, r.vData, r.vHeadDim, r.numKVHeads, len(r.locs))
+	if err != nil {
+		return err
+	}
+
+	// this may be called while storing the batch of a forward pass, whose
+	// state is kept for it
+	curLayer, curLocs, curBatchSize := c.curLayer, c.curLocs, c.curBatchSize
+	defer func() {
+		c.curLayer, c.curLocs, c.curBatchSize = curLayer, curLocs, curBatchSize
+	}()
+
+	c.curLayer, c.curLocs, c.curBatchSize = layer, r.locs, len(r.locs)
+	c.Put(ctx, key, value)
+	ctx.Compute()
+
+	return nil
+}
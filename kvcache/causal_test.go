@@ -1,6 +1,10 @@
 package kvcache
 
 import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
 	"math"
 	"slices"
 	"testing"
@@ -86,6 +90,39 @@ func TestSWA(t *testing.T) {
 	testCache(t, backend, cache, tests)
 }
 
+func TestSWASink(t *testing.T) {
+	backend := &testBackend{}
+	cache := NewSWASinkCache(1, 1, nil)
+	defer cache.Close()
+
+	cache.Init(backend, ml.DTypeF16, 1, 16, 16)
+
+	tests := []testCase{
+		{
+			name:          "FirstBatch",
+			in:            []float32{1, 2, 3, 4},
+			inShape:       []int{1, 1, 4},
+			seqs:          []int{0, 0, 0, 0},
+			pos:           []int32{0, 1, 2, 3},
+			expected:      []float32{1, 2, 3, 4},
+			expectedShape: []int{1, 1, 4},
+			expectedMask:  []float32{0, float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)), 0, 0, float32(math.Inf(-1)), float32(math.Inf(-1)), 0, 0, 0, float32(math.Inf(-1)), 0, float32(math.Inf(-1)), 0, 0},
+		},
+		{
+			name:          "SecondBatch",
+			in:            []float32{5, 6},
+			inShape:       []int{1, 1, 2},
+			seqs:          []int{0, 0},
+			pos:           []int32{4, 5},
+			expected:      []float32{1, 5, 6, 4},
+			expectedShape: []int{1, 1, 4},
+			expectedMask:  []float32{0, 0, float32(math.Inf(-1)), 0, 0, 0, 0, float32(math.Inf(-1))},
+		},
+	}
+
+	testCache(t, backend, cache, tests)
+}
+
 func TestSequences(t *testing.T) {
 	backend := &testBackend{}
 	cache := NewCausalCache(nil)
@@ -155,9 +192,9 @@ func TestRemove(t *testing.T) {
 			inShape:       []int{1, 1, 2},
 			seqs:          []int{0, 1},
 			pos:           []int32{1, 2},
-			expected:      []float32{1, 2, 3, 4, 5, 6},
-			expectedShape: []int{1, 1, 6},
-			expectedMask:  []float32{0, float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)), 0, float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)), 0, 0, float32(math.Inf(-1)), 0},
+			expected:      []float32{1, 5, 3, 4, 6},
+			expectedShape: []int{1, 1, 5},
+			expectedMask:  []float32{0, 0, float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)), 0, 0, 0},
 		},
 	}
 
@@ -175,16 +212,16 @@ func TestRemove(t *testing.T) {
 			inShape:       []int{1, 1, 2},
 			seqs:          []int{0, 0},
 			pos:           []int32{1, 2},
-			expected:      []float32{7, 8, 3, 4, 4},
-			expectedShape: []int{1, 1, 5},
-			expectedMask:  []float32{0, float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)), 0, 0, 0, float32(math.Inf(-1)), float32(math.Inf(-1)), 0},
+			expected:      []float32{7, 4, 3, 4, 6, 8},
+			expectedShape: []int{1, 1, 6},
+			expectedMask:  []float32{0, 0, float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)), 0, 0, float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)), 0},
 		},
 	}
 
 	testCache(t, backend, cache, tests)
 }
 
-func TestDefrag(t *testing.T) {
+func TestFillHoles(t *testing.T) {
 	backend := &testBackend{}
 	cache := NewCausalCache(func(ctx ml.Context, layer int, key, shift ml.Tensor) (ml.Tensor, error) {
 		return key.Add(ctx, shift), nil
@@ -220,14 +257,14 @@ func TestDefrag(t *testing.T) {
 
 	tests = []testCase{
 		{
-			name:          "Defrag",
+			name:          "FillHoles",
 			in:            []float32{17, 18, 19},
 			inShape:       []int{1, 1, 3},
 			seqs:          []int{0, 0, 0},
 			pos:           []int32{16, 17, 18},
-			expected:      []float32{1, 2, 12, 13, 3, 4, 5, 6, 7, 8, 9, 10, 11, 17, 18, 19},
+			expected:      []float32{1, 2, 17, 18, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 19},
 			expectedShape: []int{1, 1, 16},
-			expectedMask:  []float32{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, float32(math.Inf(-1)), float32(math.Inf(-1)), 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, float32(math.Inf(-1)), 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+			expectedMask:  []float32{0, 0, 0, float32(math.Inf(-1)), 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, float32(math.Inf(-1)), 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, float32(math.Inf(-1)), 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
 		},
 	}
 
@@ -274,6 +311,303 @@ func TestCopy(t *testing.T) {
 	testCache(t, backend, cache, tests)
 }
 
+func TestPages(t *testing.T) {
+	backend := &testBackend{}
+	cache := NewCausalCache(nil)
+	defer cache.Close()
+
+	cache.Init(backend, ml.DTypeF16, 2, 4, 4)
+
+	tests := []testCase{
+		{
+			name:          "FirstBatch",
+			in:            []float32{1, 2, 3},
+			inShape:       []int{1, 1, 3},
+			seqs:          []int{0, 1, 0},
+			pos:           []int32{0, 0, 1},
+			expected:      []float32{1, 3, 0, 0, 2},
+			expectedShape: []int{1, 1, 5},
+			expectedMask:  []float32{0, float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)), 0, 0, 0, float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1))},
+		},
+	}
+
+	testCache(t, backend, cache, tests)
+
+	if err := cache.Remove(0, 0, math.MaxInt32); err != nil {
+		t.Fatal(err)
+	}
+
+	// the page of the removed sequence is empty again
+	tests = []testCase{
+		{
+			name:          "EmptyPage",
+			in:            []float32{4, 5, 6, 7},
+			inShape:       []int{1, 1, 4},
+			seqs:          []int{2, 2, 2, 1},
+			pos:           []int32{0, 1, 2, 1},
+			expected:      []float32{4, 5, 6, 0, 2, 7},
+			expectedShape: []int{1, 1, 6},
+			expectedMask:  []float32{0, float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)), 0, 0, float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)), 0, 0, 0, float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)), 0, 0},
+		},
+	}
+
+	testCache(t, backend, cache, tests)
+
+	// free cells of other pages are used once there are no empty pages
+	tests = []testCase{
+		{
+			name:          "Shared",
+			in:            []float32{8, 9, 10},
+			inShape:       []int{1, 1, 3},
+			seqs:          []int{1, 1, 1},
+			pos:           []int32{2, 3, 4},
+			expected:      []float32{10, 2, 7, 8, 9},
+			expectedShape: []int{1, 1, 5},
+			expectedMask:  []float32{float32(math.Inf(-1)), 0, 0, 0, float32(math.Inf(-1)), float32(math.Inf(-1)), 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		},
+	}
+
+	testCache(t, backend, cache, tests)
+
+	ctx := backend.NewContext()
+	defer ctx.Close()
+
+	if err := cache.StartForward(ctx, input.Batch{Positions: []int32{3}, Sequences: []int{2}}); !errors.Is(err, ErrKvCacheFull) {
+		t.Errorf("expected the cache to be full, got %v", err)
+	}
+}
+
+func TestFragmented(t *testing.T) {
+	backend := &testBackend{}
+	cache := NewCausalCache(nil)
+	defer cache.Close()
+
+	cache.Init(backend, ml.DTypeF16, 4, 4, 8)
+
+	startForward := func(seqs []int, pos []int32) error {
+		ctx := backend.NewContext()
+		defer ctx.Close()
+
+		return cache.StartForward(ctx, input.Batch{Positions: pos, Sequences: seqs})
+	}
+
+	// each sequence is given a page and leaves half of it free
+	if err := startForward([]int{0, 0, 1, 1, 2, 2, 3, 3}, []int32{0, 1, 0, 1, 0, 1, 0, 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the free cells are spread over two runs, which is too many to store in
+	// a small graph
+	backend.maxGraphNodes = 32
+	owners := slices.Clone(cache.pageOwners)
+	if err := startForward([]int{4, 4, 4, 4}, []int32{0, 1, 2, 3}); !errors.Is(err, ErrKvCacheFull) {
+		t.Fatalf("expected the cache to be full, got %v", err)
+	}
+
+	if !slices.Equal(cache.pageOwners, owners) {
+		t.Errorf("expected the pages to keep their owners, got %v want %v", cache.pageOwners, owners)
+	}
+
+	backend.maxGraphNodes = 64
+	if err := startForward([]int{4, 4, 4, 4}, []int32{0, 1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(cache.curLocs, []int{2, 3, 6, 7}) {
+		t.Errorf("expected the longest free runs, got %v", cache.curLocs)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	backend := &testBackend{}
+	cache := NewCausalCache(nil)
+	defer cache.Close()
+
+	cache.Init(backend, ml.DTypeF16, 2, 4, 4)
+
+	tests := []testCase{
+		{
+			name:          "FirstBatch",
+			in:            []float32{1, 2, 3, 4},
+			inShape:       []int{1, 1, 4},
+			seqs:          []int{0, 1, 0, 0},
+			pos:           []int32{0, 0, 1, 2},
+			expected:      []float32{1, 3, 4, 0, 2},
+			expectedShape: []int{1, 1, 5},
+			expectedMask:  []float32{0, float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)), 0, 0, 0, float32(math.Inf(-1)), float32(math.Inf(-1)), float32(math.Inf(-1)), 0, 0, 0, float32(math.Inf(-1)), float32(math.Inf(-1))},
+		},
+	}
+
+	testCache(t, backend, cache, tests)
+
+	// the entries of the sequence are in two runs, since the last one only
+	// fits in the page of the other sequence
+	inf := float32(math.Inf(-1))
+	tests = []testCase{
+		{
+			name:          "SecondBatch",
+			in:            []float32{6, 7},
+			inShape:       []int{1, 1, 2},
+			seqs:          []int{0, 0},
+			pos:           []int32{3, 4},
+			expected:      []float32{1, 3, 4, 6, 2, 7},
+			expectedShape: []int{1, 1, 6},
+			expectedMask:  []float32{0, 0, 0, 0, inf, inf, 0, 0, 0, 0, inf, 0},
+		},
+	}
+
+	testCache(t, backend, cache, tests)
+
+	var b bytes.Buffer
+	if err := cache.Snapshot(&b, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewCausalCache(nil)
+	defer restored.Close()
+
+	restored.Init(backend, ml.DTypeF16, 2, 4, 4)
+	if err := restored.Restore(bytes.NewReader(b.Bytes()), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	tests = []testCase{
+		{
+			name:          "Restored",
+			in:            []float32{8},
+			inShape:       []int{1, 1, 1},
+			seqs:          []int{1},
+			pos:           []int32{5},
+			expected:      []float32{1, 3, 4, 6, 7, 8},
+			expectedShape: []int{1, 1, 6},
+			expectedMask:  []float32{0, 0, 0, 0, 0, 0},
+		},
+	}
+
+	testCache(t, backend, restored, tests)
+
+	b.Reset()
+	if err := cache.Snapshot(&b, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	other := NewCausalCache(nil)
+	defer other.Close()
+
+	other.Init(backend, ml.DTypeF32, 2, 4, 4)
+	if err := other.Restore(bytes.NewReader(b.Bytes()), 0); err == nil {
+		t.Error("expected an error restoring a snapshot of a different type")
+	}
+
+	quantized := NewCausalCache(nil)
+	defer quantized.Close()
+
+	quantized.Init(backend, ml.DTypeQ80, 2, 4, 4)
+	if err := quantized.Snapshot(&b, 0); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("expected ErrNotSupported snapshotting a quantized cache, got %v", err)
+	}
+}
+
+func TestRestoreLayers(t *testing.T) {
+	backend := &testBackend{}
+	cache := NewCausalCache(nil)
+	defer cache.Close()
+
+	cache.Init(backend, ml.DTypeF16, 2, 4, 4)
+
+	tests := []testCase{
+		{
+			name:          "FirstBatch",
+			in:            []float32{1, 2},
+			inShape:       []int{1, 1, 2},
+			seqs:          []int{0, 0},
+			pos:           []int32{0, 1},
+			expected:      []float32{1, 2},
+			expectedShape: []int{1, 1, 2},
+			expectedMask:  []float32{0, float32(math.Inf(-1)), 0, 0},
+		},
+	}
+
+	testCache(t, backend, cache, tests)
+
+	var b bytes.Buffer
+	if err := cache.Snapshot(&b, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// the snapshot is changed to have a layer that the model doesn't store to
+	snapshot := b.Bytes()
+	binary.LittleEndian.PutUint32(snapshot[12+4*2:], 7)
+
+	if err := cache.Restore(bytes.NewReader(snapshot), 1); err == nil {
+		t.Error("expected an error restoring a layer that the cache doesn't have")
+	}
+
+	// a cache that hasn't stored anything holds the layer until the model
+	// shows that it doesn't have it
+	restored := NewCausalCache(nil)
+	defer restored.Close()
+
+	restored.Init(backend, ml.DTypeF16, 2, 4, 4)
+	if err := restored.Restore(bytes.NewReader(snapshot), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	tests = []testCase{
+		{
+			name:          "SecondBatch",
+			in:            []float32{3},
+			inShape:       []int{1, 1, 1},
+			seqs:          []int{1},
+			pos:           []int32{0},
+			expected:      []float32{3},
+			expectedShape: []int{1, 1, 1},
+			expectedMask:  []float32{0},
+		},
+	}
+
+	testCache(t, backend, restored, tests)
+
+	ctx := backend.NewContext()
+	defer ctx.Close()
+
+	if err := restored.StartForward(ctx, input.Batch{Positions: []int32{1}, Sequences: []int{1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(restored.restored) > 0 || len(restored.keys) != 1 {
+		t.Errorf("expected only the layer of the model, got %v restored and %v stored", len(restored.restored), len(restored.keys))
+	}
+}
+
+func TestQuantizedFallback(t *testing.T) {
+	cases := []struct {
+		name   string
+		config *ml.CacheConfig
+		want   ml.DType
+	}{
+		{"backend", nil, ml.DTypeQ80},
+		{"model", &ml.CacheConfig{}, ml.DTypeF16},
+		{"permuted", &ml.CacheConfig{PermutedV: true}, ml.DTypeF16},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := NewCausalCache(nil)
+			defer cache.Close()
+
+			if tt.config != nil {
+				cache.SetConfig(*tt.config)
+			}
+
+			cache.Init(&testBackend{}, ml.DTypeQ80, 1, 16, 16)
+			if cache.DType != tt.want {
+				t.Errorf("expected dtype %v, got %v", tt.want, cache.DType)
+			}
+		})
+	}
+}
+
 func testCache(t *testing.T, backend ml.Backend, cache Cache, tests []testCase) {
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -300,7 +634,10 @@ func testCache(t *testing.T, backend ml.Backend, cache Cache, tests []testCase)
 	}
 }
 
-type testBackend struct{}
+type testBackend struct {
+	// maxGraphNodes is the size of the graphs of contexts, if not the default
+	maxGraphNodes int
+}
 
 func (b *testBackend) Config() ml.Config {
 	panic("not implemented")
@@ -311,18 +648,20 @@ func (b *testBackend) Get(name string) ml.Tensor {
 }
 
 func (b *testBackend) NewContext() ml.Context {
-	return &testContext{}
+	return &testContext{maxGraphNodes: cmp.Or(b.maxGraphNodes, 8192)}
 }
 
-func (b *testBackend) NewContextSize(int) ml.Context {
-	return &testContext{}
+func (b *testBackend) NewContextSize(n int) ml.Context {
+	return &testContext{maxGraphNodes: n}
 }
 
 func (b *testBackend) SystemInfo() string {
 	return "not implemented"
 }
 
-type testContext struct{}
+type testContext struct {
+	maxGraphNodes int
+}
 
 func (c *testContext) Empty(dtype ml.DType, shape ...int) ml.Tensor {
 	total := 0
@@ -361,6 +700,18 @@ func (c *testContext) FromIntSlice(s []int32, shape ...int) (ml.Tensor, error) {
 	return out, nil
 }
 
+func (c *testContext) FromBytes(dtype ml.DType, s []byte, shape ...int) (ml.Tensor, error) {
+	f := make([]float32, len(s)/4)
+	for i := range f {
+		f[i] = math.Float32frombits(binary.LittleEndian.Uint32(s[i*4:]))
+	}
+
+	out, _ := c.FromFloatSlice(f, shape...)
+	out.(*testTensor).dtype = dtype
+
+	return out, nil
+}
+
 func (c *testContext) Input() ml.Context    { return c }
 func (c *testContext) Output() ml.Context   { return c }
 func (c *testContext) Layer(int) ml.Context { return c }
@@ -370,7 +721,7 @@ func (c *testContext) Forward(...ml.Tensor) ml.Context { return c }
 func (c *testContext) Compute(...ml.Tensor) {}
 
 func (c *testContext) MaxGraphNodes() int {
-	return 10
+	return c.maxGraphNodes
 }
 
 func (c *testContext) Close() {}
@@ -404,7 +755,12 @@ func (t *testTensor) DType() ml.DType {
 }
 
 func (t *testTensor) Bytes() []byte {
-	panic("not implemented")
+	b := make([]byte, 4*len(t.data))
+	for i, f := range t.data {
+		binary.LittleEndian.PutUint32(b[i*4:], math.Float32bits(f))
+	}
+
+	return b
 }
 
 func (t *testTensor) Floats() []float32 {
@@ -431,6 +787,10 @@ func (t *testTensor) Mulmat(ctx ml.Context, t2 ml.Tensor) ml.Tensor {
 	panic("not implemented")
 }
 
+func (t *testTensor) MulmatID(ctx ml.Context, t2, ids ml.Tensor) ml.Tensor {
+	panic("not implemented")
+}
+
 func (t *testTensor) MulmatFullPrec(ctx ml.Context, t2 ml.Tensor) ml.Tensor {
 	panic("not implemented")
 }
@@ -451,6 +811,14 @@ func (t *testTensor) Scale(ctx ml.Context, s float64) ml.Tensor {
 	panic("not implemented")
 }
 
+func (t *testTensor) Sigmoid(ctx ml.Context) ml.Tensor {
+	panic("not implemented")
+}
+
+func (t *testTensor) Clamp(ctx ml.Context, min, max float32) ml.Tensor {
+	panic("not implemented")
+}
+
 func (t *testTensor) AvgPool1D(ctx ml.Context, k, s, p int) ml.Tensor {
 	panic("not implemented")
 }
@@ -479,6 +847,10 @@ func (t *testTensor) SILU(ctx ml.Context) ml.Tensor {
 	panic("not implemented")
 }
 
+func (t *testTensor) RELU(ctx ml.Context) ml.Tensor {
+	panic("not implemented")
+}
+
 func (t *testTensor) Reshape(ctx ml.Context, shape ...int) ml.Tensor {
 	panic("not implemented")
 }
@@ -510,7 +882,10 @@ func (t *testTensor) Permute(ctx ml.Context, shape ...int) ml.Tensor {
 }
 
 func (t *testTensor) Contiguous(ctx ml.Context) ml.Tensor {
-	panic("not implemented")
+	out := ctx.Empty(t.DType(), t.Shape()...).(*testTensor)
+	copy(out.data, t.data)
+
+	return out
 }
 
 func (t *testTensor) Set(ctx ml.Context, t2 ml.Tensor, offset int, strides ...int) ml.Tensor {
@@ -537,7 +912,15 @@ func (t *testTensor) Rows(ctx ml.Context, t2 ml.Tensor) ml.Tensor {
 	panic("not implemented")
 }
 
+func (t *testTensor) TopK(ctx ml.Context, k int) ml.Tensor {
+	panic("not implemented")
+}
+
 func (t *testTensor) Copy(ctx ml.Context, t2 ml.Tensor) ml.Tensor {
 	copy(t2.(*testTensor).data, t.data)
 	return nil
 }
+
+func (t *testTensor) Cast(ctx ml.Context, dtype ml.DType) ml.Tensor {
+	panic("not implemented")
+}
@@ -0,0 +1,279 @@
+package kvcache
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/model/input"
+)
+
+// Cross cache stores K and V tensors for cross attention, computed from the
+// output of an encoder that is attached to an input as its multimodal data.
+// Each sequence keeps the encoder output of its most recent such input until
+// that input is removed, and all tokens of the sequence attend to it.
+//
+// Put must be called once per layer for each input of the batch with an
+// encoder output, in the order of the batch. Sequences that are processed
+// without a stored encoder output are an error.
+//
+// The tensors are of shape embed dim, kv heads, encoder length
+// The mask is of shape history size, batch size
+type Cross struct {
+	DType ml.DType
+
+	// config controls mostly backend-specific optimizations
+	config *ml.CacheConfig
+
+	// ** current forward pass **
+
+	// the active layer for Get and Put
+	curLayer int
+
+	// curSequences is the sequences corresponding to this pass's entries in the batch
+	curSequences []int
+
+	// inputs of this pass with an encoder output, in the order that Put stores them
+	curEncoded []crossEntry
+
+	// number of encoder outputs stored by Put in each layer
+	curPuts map[int]int
+
+	// mask of the cache as used by this batch, built by the first Get
+	curMask ml.Tensor
+
+	// locations in the cache that are needed for this batch
+	curCellRange cellRange
+
+	// ** cache metadata **
+
+	// number of locations in the cache, reserved in blocks of
+	// seqCapacity for each sequence
+	numCells, seqCapacity int
+
+	// encoder output stored for each sequence
+	entries map[int]crossEntry
+
+	// ** cache data storage **
+
+	backend      ml.Backend
+	ctxs         map[int]ml.Context
+	keys, values map[int]ml.Tensor
+}
+
+type crossEntry struct {
+	seq int
+
+	// position of the input that the encoder output is attached to
+	pos int32
+
+	// length of the encoder output
+	length int
+}
+
+func NewCrossCache() *Cross {
+	return &Cross{
+		curPuts: make(map[int]int),
+		entries: make(map[int]crossEntry),
+		ctxs:    make(map[int]ml.Context),
+		keys:    make(map[int]ml.Tensor),
+		values:  make(map[int]ml.Tensor),
+	}
+}
+
+func (c *Cross) Init(backend ml.Backend, dtype ml.DType, maxSequences, capacity, maxBatch int) {
+	// Cross attention is implemented by models, so the backend's preferences don't apply
+	if c.config == nil {
+		c.config = &ml.CacheConfig{}
+	}
+
+	if c.config.CachePadding > 1 || c.config.MaskBatchPadding > 1 || c.config.PermutedV ||
+		(c.config.MaskDType != ml.DTypeOther && c.config.MaskDType != ml.DTypeF32) {
+		panic(fmt.Errorf("cross cache is unable to apply the requested CacheConfig (%+v)", *c.config))
+	}
+
+	if isQuantized(dtype) {
+		slog.Warn("quantized kv cache not supported for cross attention, using f16", "dtype", dtype)
+		dtype = ml.DTypeF16
+	}
+
+	c.DType = dtype
+	c.seqCapacity = capacity
+	c.numCells = maxSequences * capacity
+	c.backend = backend
+}
+
+func (c *Cross) SetConfig(config ml.CacheConfig) {
+	if c.config != nil {
+		panic("config cannot be changed after being previously set, either by the model or backend")
+	}
+
+	c.config = &config
+}
+
+func (c *Cross) Close() {
+	for _, ctx := range c.ctxs {
+		ctx.Close()
+	}
+}
+
+func (c *Cross) StartForward(ctx ml.Context, batch input.Batch) error {
+	c.curSequences = batch.Sequences
+	c.curEncoded = c.curEncoded[:0]
+	c.curMask = nil
+	clear(c.curPuts)
+
+	for _, mm := range batch.Multimodal {
+		c.curEncoded = append(c.curEncoded, crossEntry{seq: batch.Sequences[mm.Index], pos: batch.Positions[mm.Index]})
+	}
+
+	for _, seq := range batch.Sequences {
+		if _, ok := c.entries[seq]; !ok && !slices.ContainsFunc(c.curEncoded, func(e crossEntry) bool { return e.seq == seq }) {
+			return fmt.Errorf("no encoder output for sequence %v", seq)
+		}
+	}
+
+	return nil
+}
+
+func (c *Cross) SetLayer(layer int) {
+	c.curLayer = layer
+}
+
+// Builds a mask of history x batch that lets each token in the batch attend
+// to just the encoder output of its own sequence
+func (c *Cross) buildMask(ctx ml.Context) (ml.Tensor, error) {
+	c.curCellRange = newRange()
+	for _, seq := range c.curSequences {
+		entry := c.entries[seq]
+		c.curCellRange.min = min(c.curCellRange.min, seq*c.seqCapacity)
+		c.curCellRange.max = max(c.curCellRange.max, seq*c.seqCapacity+entry.length-1)
+	}
+
+	length := c.curCellRange.max - c.curCellRange.min + 1
+	mask := make([]float32, len(c.curSequences)*length)
+
+	for i, seq := range c.curSequences {
+		begin := seq*c.seqCapacity - c.curCellRange.min
+		end := begin + c.entries[seq].length
+		for j := range length {
+			if j < begin || j >= end {
+				mask[i*length+j] = float32(math.Inf(-1))
+			}
+		}
+	}
+
+	return ctx.Input().FromFloatSlice(mask, length, len(c.curSequences))
+}
+
+func (c *Cross) Get(ctx ml.Context) (ml.Tensor, ml.Tensor, ml.Tensor) {
+	if c.curMask == nil {
+		var err error
+		c.curMask, err = c.buildMask(ctx)
+		if err != nil {
+			panic(fmt.Errorf("cross cache: %w", err))
+		}
+	}
+
+	key := c.keys[c.curLayer]
+	value := c.values[c.curLayer]
+	cachedSize := c.curMask.Dim(0)
+
+	key = key.View(ctx, key.Stride(2)*c.curCellRange.min,
+		key.Dim(0), key.Stride(1),
+		key.Dim(1), key.Stride(2),
+		cachedSize,
+	)
+
+	value = value.View(ctx, value.Stride(2)*c.curCellRange.min,
+		value.Dim(0), value.Stride(1),
+		value.Dim(1), value.Stride(2),
+		cachedSize,
+	)
+
+	return key, value, c.curMask
+}
+
+func (c *Cross) Put(ctx ml.Context, key, value ml.Tensor) {
+	n := c.curPuts[c.curLayer]
+	if n >= len(c.curEncoded) {
+		panic(fmt.Errorf("more encoder outputs than in the batch (layer: %v, encoder outputs: %v)", c.curLayer, len(c.curEncoded)))
+	}
+	c.curPuts[c.curLayer] = n + 1
+
+	entry := c.curEncoded[n]
+	entry.length = key.Dim(2)
+	if entry.length > c.seqCapacity {
+		panic(fmt.Errorf("encoder output is larger than the cache (length: %v, capacity: %v)", entry.length, c.seqCapacity))
+	}
+	c.entries[entry.seq] = entry
+
+	kHeadDim := key.Dim(0)
+	vHeadDim := value.Dim(0)
+	numKVHeads := key.Dim(1)
+
+	if _, ok := c.ctxs[c.curLayer]; !ok {
+		c.ctxs[c.curLayer] = c.backend.NewContextSize(2).Layer(c.curLayer)
+	}
+
+	if _, ok := c.keys[c.curLayer]; !ok {
+		c.keys[c.curLayer] = c.ctxs[c.curLayer].Zeros(c.DType, kHeadDim, numKVHeads, c.numCells)
+	}
+
+	if _, ok := c.values[c.curLayer]; !ok {
+		c.values[c.curLayer] = c.ctxs[c.curLayer].Zeros(c.DType, vHeadDim, numKVHeads, c.numCells)
+	}
+
+	loc := entry.seq * c.seqCapacity
+	ctx.Forward(
+		key.Copy(ctx, c.keys[c.curLayer].View(ctx, c.keys[c.curLayer].Stride(2)*loc, kHeadDim*numKVHeads*entry.length)),
+		value.Copy(ctx, c.values[c.curLayer].View(ctx, c.values[c.curLayer].Stride(2)*loc, vHeadDim*numKVHeads*entry.length)),
+	)
+}
+
+func (c *Cross) CopyPrefix(srcSeq, dstSeq int, len int32) {
+	delete(c.entries, dstSeq)
+
+	entry, ok := c.entries[srcSeq]
+	if !ok || entry.pos >= len {
+		return
+	}
+
+	ctx := c.backend.NewContext()
+	defer ctx.Close()
+
+	for i, key := range c.keys {
+		value := c.values[i]
+
+		kSize := key.Dim(0) * key.Dim(1) * entry.length
+		vSize := value.Dim(0) * value.Dim(1) * entry.length
+
+		ctx.Forward(
+			key.View(ctx, key.Stride(2)*srcSeq*c.seqCapacity, kSize).Copy(ctx, key.View(ctx, key.Stride(2)*dstSeq*c.seqCapacity, kSize)),
+			value.View(ctx, value.Stride(2)*srcSeq*c.seqCapacity, vSize).Copy(ctx, value.View(ctx, value.Stride(2)*dstSeq*c.seqCapacity, vSize)),
+		)
+	}
+
+	ctx.Compute()
+
+	entry.seq = dstSeq
+	c.entries[dstSeq] = entry
+}
+
+func (c *Cross) Remove(seq int, beginIndex, endIndex int32) error {
+	entry, ok := c.entries[seq]
+	if !ok {
+		return nil
+	}
+
+	if entry.pos >= beginIndex && entry.pos < endIndex {
+		delete(c.entries, seq)
+	} else if entry.pos >= endIndex {
+		entry.pos -= endIndex - beginIndex
+		c.entries[seq] = entry
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package kvcache
+
+import (
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/model/input"
+)
+
+func TestCross(t *testing.T) {
+	backend := &testBackend{}
+	cache := NewCrossCache()
+	defer cache.Close()
+
+	cache.Init(backend, ml.DTypeF16, 2, 4, 16)
+
+	inf := float32(math.Inf(-1))
+
+	// Both sequences start with an encoder output
+	context := backend.NewContext()
+	err := cache.StartForward(context, input.Batch{
+		Positions:  []int32{0, 0},
+		Sequences:  []int{0, 1},
+		Multimodal: []input.MultimodalIndex{{Index: 0}, {Index: 1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.SetLayer(0)
+	for _, in := range [][]float32{{1, 2}, {3, 4, 5}} {
+		tensor, _ := context.FromFloatSlice(in, 1, 1, len(in))
+		cache.Put(context, tensor, tensor)
+	}
+
+	testCrossGet(t, context, cache, []float32{1, 2, 0, 0, 3, 4, 5}, []float32{
+		0, 0, inf, inf, inf, inf, inf,
+		inf, inf, inf, inf, 0, 0, 0,
+	})
+
+	// Later batches attend to the stored output of their sequence
+	err = cache.StartForward(context, input.Batch{Positions: []int32{1, 2}, Sequences: []int{1, 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCrossGet(t, context, cache, []float32{3, 4, 5}, []float32{0, 0, 0, 0, 0, 0})
+
+	// Copying a prefix that includes the encoder output copies the output
+	cache.CopyPrefix(0, 1, 1)
+
+	err = cache.StartForward(context, input.Batch{Positions: []int32{1}, Sequences: []int{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCrossGet(t, context, cache, []float32{1, 2}, []float32{0, 0})
+
+	// Removing the input with the encoder output removes the output
+	if err := cache.Remove(0, 0, math.MaxInt32); err != nil {
+		t.Fatal(err)
+	}
+
+	err = cache.StartForward(context, input.Batch{Positions: []int32{1}, Sequences: []int{0}})
+	if err == nil {
+		t.Fatal("expected error for sequence without an encoder output")
+	}
+}
+
+func testCrossGet(t *testing.T, context ml.Context, cache *Cross, expected, expectedMask []float32) {
+	t.Helper()
+
+	cache.SetLayer(0)
+	out, _, mask := cache.Get(context)
+
+	if !slices.Equal(out.Floats(), expected) || !slices.Equal(mask.Floats(), expectedMask) {
+		t.Errorf("have %v; want %v; mask: have %v want %v", out.Floats(), expected, mask.Floats(), expectedMask)
+	}
+}
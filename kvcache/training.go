@@ -0,0 +1,106 @@
+package kvcache
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/model/input"
+)
+
+// Training is a cache for forward passes that compute a training loss. It
+// does not store anything: Get returns the keys and values of the current
+// batch as they were given to Put so that gradients can flow back through
+// attention. Each token attends to the tokens of its sequence in the batch
+// at the same or earlier positions.
+//
+// The tensors are of shape embed dim, kv heads, batch size
+// The mask is of shape batch size, batch size
+type Training struct {
+	// config controls mostly backend-specific optimizations
+	config *ml.CacheConfig
+
+	curLayer     int
+	curBatchSize int
+	curMask      ml.Tensor
+
+	keys, values map[int]ml.Tensor
+}
+
+func NewTrainingCache() *Training {
+	return &Training{
+		keys:   make(map[int]ml.Tensor),
+		values: make(map[int]ml.Tensor),
+	}
+}
+
+func (c *Training) Init(backend ml.Backend, dtype ml.DType, maxSequences, capacity, maxBatch int) {
+	if c.config == nil {
+		// training never uses fused attention kernels, which have no backward
+		// pass, so produce the layout expected by the vanilla implementation
+		c.config = &ml.CacheConfig{PermutedV: true}
+	}
+}
+
+func (c *Training) SetConfig(config ml.CacheConfig) {
+	if c.config != nil {
+		panic("config cannot be changed after being previously set, either by the model or backend")
+	}
+
+	c.config = &config
+}
+
+func (c *Training) Close() {}
+
+func (c *Training) StartForward(ctx ml.Context, batch input.Batch) error {
+	if c.config == nil {
+		c.Init(nil, ml.DTypeF32, 0, 0, 0)
+	}
+
+	c.curBatchSize = len(batch.Positions)
+	clear(c.keys)
+	clear(c.values)
+
+	mask := make([]float32, c.curBatchSize*c.curBatchSize)
+	for i := range c.curBatchSize {
+		for j := range c.curBatchSize {
+			if batch.Sequences[j] != batch.Sequences[i] || batch.Positions[j] > batch.Positions[i] {
+				mask[i*c.curBatchSize+j] = float32(math.Inf(-1))
+			}
+		}
+	}
+
+	var err error
+	c.curMask, err = ctx.Input().FromFloatSlice(mask, c.curBatchSize, c.curBatchSize)
+	return err
+}
+
+func (c *Training) SetLayer(layer int) {
+	c.curLayer = layer
+}
+
+func (c *Training) Get(ctx ml.Context) (ml.Tensor, ml.Tensor, ml.Tensor) {
+	key := c.keys[c.curLayer]
+	value := c.values[c.curLayer]
+
+	if c.config.PermutedV {
+		value = value.Permute(ctx, 1, 2, 0, 3).Contiguous(ctx)
+	}
+
+	return key, value, c.curMask
+}
+
+func (c *Training) Put(ctx ml.Context, key, value ml.Tensor) {
+	if batchSize := key.Dim(2); batchSize != c.curBatchSize {
+		panic(fmt.Errorf("inconsistent batch sizes (layer: %v, batch size: %v layer batch size: %v)", c.curLayer, c.curBatchSize, batchSize))
+	}
+
+	c.keys[c.curLayer] = key
+	c.values[c.curLayer] = value
+}
+
+func (c *Training) CopyPrefix(srcSeq, dstSeq int, len int32) {}
+
+func (c *Training) Remove(seq int, beginIndex, endIndex int32) error {
+	return nil
+}
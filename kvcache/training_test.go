@@ -0,0 +1,50 @@
+package kvcache
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ollama/ollama/ml"
+)
+
+func TestTraining(t *testing.T) {
+	backend := &testBackend{}
+	cache := NewTrainingCache()
+	defer cache.Close()
+
+	cache.SetConfig(ml.CacheConfig{})
+	cache.Init(backend, ml.DTypeF32, 1, 16, 16)
+
+	inf := float32(math.Inf(-1))
+
+	tests := []testCase{
+		{
+			name:          "FirstBatch",
+			in:            []float32{1, 2, 3},
+			inShape:       []int{1, 1, 3},
+			seqs:          []int{0, 0, 0},
+			pos:           []int32{0, 1, 2},
+			expected:      []float32{1, 2, 3},
+			expectedShape: []int{1, 1, 3},
+			expectedMask:  []float32{0, inf, inf, 0, 0, inf, 0, 0, 0},
+		},
+		{
+			// nothing is kept from the previous batch
+			name:          "Sequences",
+			in:            []float32{4, 5, 6, 7},
+			inShape:       []int{1, 1, 4},
+			seqs:          []int{0, 1, 0, 1},
+			pos:           []int32{0, 0, 1, 1},
+			expected:      []float32{4, 5, 6, 7},
+			expectedShape: []int{1, 1, 4},
+			expectedMask: []float32{
+				0, inf, inf, inf,
+				inf, 0, inf, inf,
+				0, inf, 0, inf,
+				inf, 0, inf, 0,
+			},
+		},
+	}
+
+	testCache(t, backend, cache, tests)
+}
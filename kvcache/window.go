@@ -0,0 +1,51 @@
+package kvcache
+
+import "math"
+
+// LayerWindowCache is a cache for models whose layers attend to windows of
+// different sizes, such as Gemma 2 and 3 which interleave local layers that
+// attend to a sliding window with global layers that attend to the whole
+// context. Layers with the same window share an underlying cache, so the
+// caches of local layers evict positions once they leave the window and only
+// need memory for the window rather than the context.
+type LayerWindowCache struct {
+	WrapperCache
+
+	// layerTypes is the index of the cache of each layer
+	layerTypes []int
+}
+
+// NewLayerWindowCache returns a cache for layers that attend to the number
+// of past positions in windowSizes, with 0 or [math.MaxInt32] meaning the
+// whole context.
+func NewLayerWindowCache(windowSizes []int32, shift shiftFn) *LayerWindowCache {
+	c := LayerWindowCache{layerTypes: make([]int, len(windowSizes))}
+
+	types := make(map[int32]int)
+	for i, windowSize := range windowSizes {
+		if windowSize <= 0 {
+			windowSize = math.MaxInt32
+		}
+
+		layerType, ok := types[windowSize]
+		if !ok {
+			layerType = len(c.caches)
+			types[windowSize] = layerType
+
+			if windowSize == math.MaxInt32 {
+				c.caches = append(c.caches, NewCausalCache(shift))
+			} else {
+				c.caches = append(c.caches, NewSWACache(windowSize, shift))
+			}
+		}
+
+		c.layerTypes[i] = layerType
+	}
+
+	return &c
+}
+
+func (c *LayerWindowCache) SetLayer(layer int) {
+	c.WrapperCache.SetLayer(layer)
+	c.SetLayerType(c.layerTypes[layer])
+}
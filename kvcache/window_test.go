@@ -0,0 +1,95 @@
+package kvcache
+
+import (
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/model/input"
+)
+
+func TestLayerWindow(t *testing.T) {
+	backend := &testBackend{}
+	cache := NewLayerWindowCache([]int32{1, 0, 1}, nil)
+	defer cache.Close()
+
+	cache.Init(backend, ml.DTypeF16, 1, 64, 4)
+
+	// the local layers share a cache that only holds the window and a batch
+	if len(cache.caches) != 2 {
+		t.Fatalf("expected 2 caches, got %d", len(cache.caches))
+	}
+	if cells := len(cache.caches[0].(*Causal).cells); cells != 5 {
+		t.Errorf("expected 5 cells for the sliding window, got %d", cells)
+	}
+	if cells := len(cache.caches[1].(*Causal).cells); cells != 64 {
+		t.Errorf("expected 64 cells for the whole context, got %d", cells)
+	}
+
+	inf := float32(math.Inf(-1))
+
+	batches := []struct {
+		name          string
+		in            []float32
+		pos           []int32
+		local, global []float32
+		localMask     []float32
+		globalMask    []float32
+		localShape    []int
+		globalShape   []int
+	}{
+		{
+			name:        "FirstBatch",
+			in:          []float32{1, 2, 3, 4},
+			pos:         []int32{0, 1, 2, 3},
+			local:       []float32{1, 2, 3, 4},
+			localShape:  []int{1, 1, 4},
+			localMask:   []float32{0, inf, inf, inf, 0, 0, inf, inf, inf, 0, 0, inf, inf, inf, 0, 0},
+			global:      []float32{1, 2, 3, 4},
+			globalShape: []int{1, 1, 4},
+			globalMask:  []float32{0, inf, inf, inf, 0, 0, inf, inf, 0, 0, 0, inf, 0, 0, 0, 0},
+		},
+		{
+			name:        "SecondBatch",
+			in:          []float32{5, 6},
+			pos:         []int32{4, 5},
+			local:       []float32{5, 6, 3, 4},
+			localShape:  []int{1, 1, 4},
+			localMask:   []float32{0, inf, inf, 0, 0, 0, inf, inf},
+			global:      []float32{1, 2, 3, 4, 5, 6},
+			globalShape: []int{1, 1, 6},
+			globalMask:  []float32{0, 0, 0, 0, 0, inf, 0, 0, 0, 0, 0, 0},
+		},
+	}
+
+	for _, batch := range batches {
+		t.Run(batch.name, func(t *testing.T) {
+			context := backend.NewContext()
+			defer context.Close()
+
+			err := cache.StartForward(context, input.Batch{Positions: batch.pos, Sequences: make([]int, len(batch.pos))})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for layer := range 3 {
+				cache.SetLayer(layer)
+				tensor, _ := context.FromFloatSlice(batch.in, 1, 1, len(batch.in))
+				cache.Put(context, tensor, tensor)
+
+				out, _, mask := cache.Get(context)
+				context.Forward(out, mask).Compute(out, mask)
+
+				expected, expectedShape, expectedMask := batch.local, batch.localShape, batch.localMask
+				if layer == 1 {
+					expected, expectedShape, expectedMask = batch.global, batch.globalShape, batch.globalMask
+				}
+
+				if !slices.Equal(out.Floats(), expected) || !slices.Equal(out.Shape(), expectedShape) || !slices.Equal(mask.Floats(), expectedMask) {
+					t.Errorf("layer %d: have %v (shape %v); want %v (shape %v); mask: have %v want %v", layer, out.Floats(), out.Shape(), expected, expectedShape, mask.Floats(), expectedMask)
+				}
+			}
+		})
+	}
+}
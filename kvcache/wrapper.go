@@ -1,6 +1,7 @@
 package kvcache
 
 import (
+	"io"
 	"math"
 
 	"github.com/ollama/ollama/ml"
@@ -98,3 +99,33 @@ func (c *WrapperCache) Remove(seq int, beginIndex, endIndex int32) error {
 
 	return nil
 }
+
+func (c *WrapperCache) Snapshot(w io.Writer, seq int) error {
+	for _, cache := range c.caches {
+		snapshotter, ok := cache.(Snapshotter)
+		if !ok {
+			return ErrNotSupported
+		}
+
+		if err := snapshotter.Snapshot(w, seq); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *WrapperCache) Restore(r io.Reader, seq int) error {
+	for _, cache := range c.caches {
+		snapshotter, ok := cache.(Snapshotter)
+		if !ok {
+			return ErrNotSupported
+		}
+
+		if err := snapshotter.Restore(r, seq); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -354,6 +354,12 @@ extern "C" {
         void *              abort_callback_data;
     };
 
+    // type to quantize a tensor to, overriding the type chosen for the ftype
+    typedef struct llama_model_quantize_tensor_type {
+        const char * name;   // name of the tensor
+        enum ggml_type type; // quantize the tensor to this type
+    } llama_model_quantize_tensor_type;
+
     // model quantization parameters
     typedef struct llama_model_quantize_params {
         int32_t nthread;                     // number of threads to use for quantizing, if <=0 will use std::thread::hardware_concurrency()
@@ -367,6 +373,8 @@ extern "C" {
         bool keep_split;                     // quantize to the same number of shards
         void * imatrix;                      // pointer to importance matrix data
         void * kv_overrides;                 // pointer to vector containing overrides
+        const llama_model_quantize_tensor_type * tensor_types; // types of individual tensors
+        size_t n_tensor_types;               // number of tensor_types
     } llama_model_quantize_params;
 
     typedef struct llama_logit_bias {
@@ -795,6 +795,20 @@ static void llama_model_quantize_impl(const std::string & fname_inp, const std::
             if (params->output_tensor_type < GGML_TYPE_COUNT && strcmp(tensor->name, "output.weight") == 0) {
                 new_type = params->output_tensor_type;
             }
+            for (size_t i = 0; i < params->n_tensor_types; ++i) {
+                const llama_model_quantize_tensor_type & tt = params->tensor_types[i];
+                if (strcmp(tensor->name, tt.name) != 0) {
+                    continue;
+                }
+
+                if (tensor->ne[0] % ggml_blck_size(tt.type) != 0) {
+                    LLAMA_LOG_WARN("%s: tensor cols %" PRId64 " are not divisible by %" PRId64 ", not overriding %s with %s\n",
+                            __func__, tensor->ne[0], ggml_blck_size(tt.type), ggml_type_name(new_type), ggml_type_name(tt.type));
+                } else {
+                    new_type = tt.type;
+                }
+                break;
+            }
 
             // If we've decided to quantize to the same type the tensor is already
             // in then there's nothing to do.
@@ -925,6 +939,8 @@ struct llama_model_quantize_params llama_model_quantize_default_params() {
         /*.keep_split                  =*/ false,
         /*.imatrix                     =*/ nullptr,
         /*.kv_overrides                =*/ nullptr,
+        /*.tensor_types                =*/ nullptr,
+        /*.n_tensor_types              =*/ 0,
     };
 
     return result;
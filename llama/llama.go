@@ -86,10 +86,11 @@ type ContextParams struct {
 	c C.struct_llama_context_params
 }
 
-func NewContextParams(numCtx int, batchSize int, numSeqMax int, threads int, flashAttention bool, kvCacheType string) ContextParams {
+func NewContextParams(numCtx int, batchSize int, ubatchSize int, numSeqMax int, threads int, flashAttention bool, kvCacheType string) ContextParams {
 	params := C.llama_context_default_params()
 	params.n_ctx = C.uint(numCtx)
 	params.n_batch = C.uint(batchSize)
+	params.n_ubatch = C.uint(min(ubatchSize, batchSize))
 	params.n_seq_max = C.uint(numSeqMax)
 	params.n_threads = C.int(threads)
 	params.n_threads_batch = params.n_threads
@@ -189,6 +190,17 @@ func (c *Context) GetEmbeddingsIth(i int) []float32 {
 	return embeddings
 }
 
+// GetLogitsIth returns the logits of the ith output of the last batch. The
+// slice is only valid until the next call to Decode.
+func (c *Context) GetLogitsIth(i int) []float32 {
+	l := unsafe.Pointer(C.llama_get_logits_ith(c.c, C.int32_t(i)))
+	if l == nil {
+		return nil
+	}
+
+	return unsafe.Slice((*float32)(l), c.Model().NumVocab())
+}
+
 type ModelParams struct {
 	NumGpuLayers int
 	MainGpu      int
@@ -474,7 +486,9 @@ func (m *Model) NEmbd() int {
 	return int(C.llama_model_n_embd(m.c))
 }
 
-func Quantize(infile, outfile string, ftype uint32) error {
+// Quantize quantizes the model in infile to ftype and writes it to outfile.
+// tensorTypes overrides the ggml type of tensors by their names.
+func Quantize(infile, outfile string, ftype uint32, tensorTypes map[string]uint32) error {
 	cinfile := C.CString(infile)
 	defer C.free(unsafe.Pointer(cinfile))
 
@@ -485,6 +499,23 @@ func Quantize(infile, outfile string, ftype uint32) error {
 	params.nthread = -1
 	params.ftype = ftype
 
+	if len(tensorTypes) > 0 {
+		ctypes := (*C.llama_model_quantize_tensor_type)(C.malloc(C.size_t(len(tensorTypes)) * C.size_t(unsafe.Sizeof(C.llama_model_quantize_tensor_type{}))))
+		defer C.free(unsafe.Pointer(ctypes))
+
+		types := unsafe.Slice(ctypes, len(tensorTypes))
+		var i int
+		for name, t := range tensorTypes {
+			types[i].name = C.CString(name)
+			defer C.free(unsafe.Pointer(types[i].name))
+			types[i]._type = C.enum_ggml_type(t)
+			i++
+		}
+
+		params.tensor_types = ctypes
+		params.n_tensor_types = C.size_t(len(tensorTypes))
+	}
+
 	if rc := C.llama_model_quantize(cinfile, coutfile, &params); rc != 0 {
 		return fmt.Errorf("llama_model_quantize: %d", rc)
 	}
@@ -624,6 +655,7 @@ type SamplingParams struct {
 	MirostatEta    float32
 	PenalizeNl     bool
 	Seed           uint32
+	IgnoreEOS      bool
 	Grammar        string
 }
 
@@ -642,6 +674,7 @@ func NewSamplingContext(model *Model, params SamplingParams) (*SamplingContext,
 	cparams.mirostat_tau = C.float(params.MirostatTau)
 	cparams.mirostat_eta = C.float(params.MirostatEta)
 	cparams.seed = C.uint32_t(params.Seed)
+	cparams.ignore_eos = C.bool(params.IgnoreEOS)
 
 	grammar := C.CString(params.Grammar)
 	defer C.free(unsafe.Pointer(grammar))
@@ -688,6 +721,15 @@ func SchemaToGrammar(schema []byte) []byte {
 	return buf[:n]
 }
 
+// ValidGrammar reports whether grammar is a GBNF grammar with a root rule
+// that samplers can be constrained by.
+func ValidGrammar(grammar string) bool {
+	cGrammar := C.CString(grammar)
+	defer C.free(unsafe.Pointer(cGrammar))
+
+	return bool(C.grammar_validate(cGrammar))
+}
+
 type Sampler struct {
 	c *C.struct_llama_sampler
 }
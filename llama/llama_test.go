@@ -103,3 +103,24 @@ func TestSchemaToGrammer(t *testing.T) {
 		})
 	}
 }
+
+func TestValidGrammar(t *testing.T) {
+	cases := []struct {
+		grammar string
+		want    bool
+	}{
+		{`root ::= "yes" | "no"`, true},
+		{`root ::= answer
+answer ::= [0-9]+`, true},
+		{`answer ::= "yes"`, false},       // no root
+		{`root ::= answer`, false},        // undefined rule
+		{`root ::= "unterminated`, false}, // parse error
+		{``, false},
+	}
+
+	for _, c := range cases {
+		if got := ValidGrammar(c.grammar); got != c.want {
+			t.Errorf("ValidGrammar(%q) = %v, want %v", c.grammar, got, c.want)
+		}
+	}
+}
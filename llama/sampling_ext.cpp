@@ -3,6 +3,7 @@
 #include "sampling_ext.h"
 #include "json-schema-to-grammar.h"
 #include "llama.h"
+#include "llama-grammar.h"
 #include "llama-model.h"
 #include "llama-model-loader.h"
 
@@ -25,6 +26,16 @@ struct common_sampler *common_sampler_cinit(const struct llama_model *model, str
         sparams.grammar = params->grammar;
         sparams.xtc_probability = 0.0;
         sparams.xtc_threshold = 0.5;
+
+        if (params->ignore_eos) {
+            const llama_vocab * vocab = llama_model_get_vocab(model);
+            for (llama_token i = 0; i < llama_vocab_n_tokens(vocab); i++) {
+                if (llama_vocab_is_eog(vocab, i)) {
+                    sparams.logit_bias.push_back({i, -INFINITY});
+                }
+            }
+        }
+
         return common_sampler_init(model, sparams);
     } catch (const std::exception &err) {
         return nullptr;
@@ -68,6 +79,17 @@ int schema_to_grammar(const char *json_schema, char *grammar, size_t max_len)
     }
 }
 
+bool grammar_validate(const char *grammar)
+{
+    llama_grammar_parser parser;
+    if (!parser.parse(grammar) || parser.rules.empty())
+    {
+        return false;
+    }
+
+    return parser.symbol_ids.find("root") != parser.symbol_ids.end();
+}
+
 struct llama_vocab * llama_load_vocab_from_file(const char * fname) {
     llama_vocab * vocab = new llama_vocab();
     try {
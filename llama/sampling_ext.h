@@ -24,6 +24,7 @@ extern "C"
         float mirostat_tau;
         float mirostat_eta;
         uint32_t seed;
+        bool ignore_eos;
         char *grammar;
     };
 
@@ -34,6 +35,7 @@ extern "C"
     llama_token common_sampler_csample(struct common_sampler *sampler, struct llama_context *ctx, int idx);
 
     int schema_to_grammar(const char *json_schema, char *grammar, size_t max_len);
+    bool grammar_validate(const char *grammar);
 
     struct llama_vocab * llama_load_vocab_from_file(const char * fname);
     void llama_free_vocab(struct llama_vocab * vocab);
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -69,6 +71,79 @@ type MemoryEstimate struct {
 	projectorWeights, projectorGraph uint64
 }
 
+// unifiedMemoryOverflow is how much larger than the free VRAM a model may be,
+// as a fraction of it, to be loaded into unified memory
+const unifiedMemoryOverflow = 0.25
+
+// useUnifiedMemory reports whether a model that only partially fits in the
+// free VRAM of gpus should be loaded entirely onto them with CUDA managed
+// memory instead, which overflows into system memory. This is opt-in with
+// OLLAMA_UNIFIED_MEMORY, and limited to a single Nvidia GPU and models that
+// are slightly larger than its free VRAM, since paging much of a model
+// between system memory and VRAM is slower than running layers on the CPU.
+func useUnifiedMemory(gpus discover.GpuInfoList, estimate MemoryEstimate, totalLayers int) bool {
+	if !envconfig.UnifiedMemory() || len(gpus) != 1 || gpus[0].Library != "cuda" || estimate.Layers >= totalLayers {
+		return false
+	}
+
+	reserved := gpus[0].MinimumMemory + envconfig.GpuOverhead()
+	if gpus[0].FreeMemory <= reserved {
+		return false
+	}
+
+	free := gpus[0].FreeMemory - reserved
+	return float64(estimate.TotalSize) <= float64(free)*(1+unifiedMemoryOverflow)
+}
+
+// streamWeightsAhead is how many layers past the one being computed have
+// their weights prefetched from disk when streaming weights
+const streamWeightsAhead = 2
+
+// streamWeights reports whether a model that needs more system memory than
+// is available should be run on the CPU with its weights memory mapped and
+// prefetched from disk just before each layer is computed. This is opt-in
+// with OLLAMA_STREAM_WEIGHTS and needs the llama.cpp runner on Linux. The
+// model must still fit in memory without its weights, along with the
+// weights of the layers being prefetched.
+func streamWeights(gpus discover.GpuInfoList, f *ggml.GGML, adapters []string, opts api.Options, estimate MemoryEstimate, available uint64) bool {
+	if !envconfig.StreamWeights() || runtime.GOOS != "linux" || gpus[0].Library != "cpu" {
+		return false
+	}
+
+	// adapters are applied to the weights in memory so they disable mmap
+	if f.KV().OllamaEngineRequired() || len(adapters) > 0 || (opts.UseMMap != nil && !*opts.UseMMap) {
+		return false
+	}
+
+	weights := min(estimate.memoryWeights+estimate.memoryLayerOutput, estimate.TotalSize)
+	layer := estimate.memoryWeights / max(f.KV().BlockCount(), 1)
+	return estimate.TotalSize-weights+streamWeightsAhead*layer <= available
+}
+
+// pipelineBatchSize returns the size of the micro-batches the Ollama engine
+// splits batches into, or 0 to compute each batch at once. Unless num_ubatch
+// sets it, batches are split into as many micro-batches as there are GPUs
+// holding layers of the model, so that each GPU can work on a different one
+// while the others compute their layers.
+func pipelineBatchSize(opts api.Options, estimate MemoryEstimate) int {
+	if opts.NumUBatch > 0 {
+		return opts.NumUBatch
+	}
+
+	var stages int
+	for count := range strings.SplitSeq(estimate.TensorSplit, ",") {
+		if count != "" && count != "0" {
+			stages++
+		}
+	}
+
+	if stages < 2 {
+		return 0
+	}
+
+	return max(opts.NumBatch/stages, 1)
+}
+
 // Given a model and one or more GPU targets, predict how many layers and bytes we can load, and the total size
 // The GPUs provided must all be the same Library
 func EstimateGPULayers(gpus []discover.GpuInfo, f *ggml.GGML, projectors []string, opts api.Options) MemoryEstimate {
@@ -212,6 +287,21 @@ func EstimateGPULayers(gpus []discover.GpuInfo, f *ggml.GGML, projectors []strin
 		gpuAllocations[gpuZeroID] += gpuZeroOverhead
 	}
 
+	// next picks which of the first j GPUs with space to try for layer i,
+	// spreading the layers evenly unless the model or the options split them
+	next := func(i, j int) int { return i % j }
+	if split := layerSplit(f, opts, len(gpus)); split != nil {
+		// place contiguous ranges of layers on the GPUs in proportion to the
+		// split, moving on to the next GPU once one is full
+		next = func(i, j int) int {
+			want := slices.IndexFunc(split, func(s float32) bool { return float32(i)/float32(f.KV().BlockCount()+1) < s })
+			if k := slices.IndexFunc(gpusWithSpace[:j], func(g gs) bool { return g.i >= want }); k >= 0 {
+				return k
+			}
+			return j - 1
+		}
+	}
+
 	// For all the layers, find where they can fit on the GPU(s)
 	for i := range int(f.KV().BlockCount()) {
 		// Some models have inconsistent layer sizes
@@ -228,7 +318,8 @@ func EstimateGPULayers(gpus []discover.GpuInfo, f *ggml.GGML, projectors []strin
 
 		// distribute the layers across the GPU(s) that have space
 		for j := len(gpusWithSpace); j > 0; j-- {
-			g := gpusWithSpace[i%j]
+			k := next(i, j)
+			g := gpusWithSpace[k]
 			used := gpuAllocations[g.i] + max(graphPartialOffload, graphFullOffload)
 			if g.g.FreeMemory > overhead+used+layerSize {
 				gpuAllocations[g.i] += layerSize
@@ -236,7 +327,7 @@ func EstimateGPULayers(gpus []discover.GpuInfo, f *ggml.GGML, projectors []strin
 				layerCount++
 				break
 			} else {
-				gpusWithSpace = append(gpusWithSpace[:i%j], gpusWithSpace[i%j+1:]...)
+				gpusWithSpace = append(gpusWithSpace[:k], gpusWithSpace[k+1:]...)
 			}
 		}
 	}
@@ -251,7 +342,7 @@ func EstimateGPULayers(gpus []discover.GpuInfo, f *ggml.GGML, projectors []strin
 	// Determine if we need to consider output then find where it fits
 	if memoryLayerOutput > 0 && (opts.NumGPU < 0 || layerCount < opts.NumGPU) {
 		for j := len(gpusWithSpace); j > 0; j-- {
-			g := gpusWithSpace[layerCount%j]
+			g := gpusWithSpace[next(layerCount, j)]
 			used := gpuAllocations[g.i] + max(graphPartialOffload, graphFullOffload)
 			if g.g.FreeMemory > overhead+used+memoryLayerOutput {
 				gpuAllocations[g.i] += memoryLayerOutput
@@ -341,6 +432,51 @@ func EstimateGPULayers(gpus []discover.GpuInfo, f *ggml.GGML, projectors []strin
 	return estimate
 }
 
+// layerSplit returns the cumulative shares of the layers to place on each of
+// n GPUs, normalized to end at 1, from the layer_split option or else the
+// metadata of the model. It returns nil to distribute the layers evenly if
+// neither is set or either doesn't match the GPUs.
+func layerSplit(f *ggml.GGML, opts api.Options, n int) []float32 {
+	split := f.KV().LayerSplit()
+	if opts.LayerSplit != "" {
+		split = nil
+		for s := range strings.SplitSeq(opts.LayerSplit, ",") {
+			v, err := strconv.ParseFloat(strings.TrimSpace(s), 32)
+			if err != nil || v < 0 {
+				slog.Warn("ignoring invalid layer split", "layer_split", opts.LayerSplit)
+				return nil
+			}
+			split = append(split, float32(v))
+		}
+	}
+
+	if len(split) == 0 || n < 2 {
+		return nil
+	}
+
+	if len(split) != n {
+		slog.Warn("ignoring layer split that doesn't match the number of gpus", "split", split, "gpu_count", n)
+		return nil
+	}
+
+	var sum float32
+	cumulative := make([]float32, n)
+	for i, v := range split {
+		sum += v
+		cumulative[i] = sum
+	}
+
+	if sum <= 0 {
+		return nil
+	}
+
+	for i := range cumulative {
+		cumulative[i] /= sum
+	}
+
+	return cumulative
+}
+
 func (m MemoryEstimate) LogValue() slog.Value {
 	attrs := []slog.Attr{
 		slog.String("library", m.inferenceLibrary),
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"runtime"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -11,6 +12,7 @@ import (
 
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/format"
 	"github.com/ollama/ollama/fs/ggml"
 )
 
@@ -128,4 +130,138 @@ func TestEstimateGPULayers(t *testing.T) {
 			}
 		})
 	}
+
+	// Layer split: GPU0 layer space, GPU1 layer space, split, expected tensor split
+	for _, s := range []struct {
+		layer0, layer1 uint64
+		split          string
+		expect         string
+	}{
+		{6, 6, "1,4", "2,4"},
+		{6, 6, "1,1", "3,3"},
+		{6, 6, "0,1", "0,6"},
+		{1, 6, "1,1", "1,5"},
+		{6, 6, "1,2,3", "3,3"},
+		{6, 6, "1,x", "3,3"},
+	} {
+		t.Run(fmt.Sprintf("layer_split %v", s), func(t *testing.T) {
+			gpus[0].FreeMemory = gpuMinimumMemory + layerSize + s.layer0*layerSize + memoryLayerOutput + max(graphFullOffload, graphPartialOffload) + 1
+			gpus[1].FreeMemory = gpuMinimumMemory + layerSize + s.layer1*layerSize + memoryLayerOutput + max(graphFullOffload, graphPartialOffload) + 1
+
+			opts := opts
+			opts.LayerSplit = s.split
+			estimate := EstimateGPULayers(gpus, ggml, projectors, opts)
+			assert.Equal(t, inputLayerCount+1, estimate.Layers)
+			assert.Equal(t, s.expect, estimate.TensorSplit)
+		})
+	}
+}
+
+func TestUseUnifiedMemory(t *testing.T) {
+	gpu := discover.GpuInfo{Library: "cuda"}
+	gpu.FreeMemory = 8 * format.GibiByte
+	rocm := gpu
+	rocm.Library = "rocm"
+
+	cases := []struct {
+		name    string
+		enabled string
+		gpus    discover.GpuInfoList
+		total   uint64
+		layers  int
+		want    bool
+	}{
+		{"slightly over", "1", discover.GpuInfoList{gpu}, 9 * format.GibiByte, 20, true},
+		{"disabled", "", discover.GpuInfoList{gpu}, 9 * format.GibiByte, 20, false},
+		{"far over", "1", discover.GpuInfoList{gpu}, 12 * format.GibiByte, 10, false},
+		{"fits", "1", discover.GpuInfoList{gpu}, 7 * format.GibiByte, 33, false},
+		{"multiple gpus", "1", discover.GpuInfoList{gpu, gpu}, 9 * format.GibiByte, 20, false},
+		{"rocm", "1", discover.GpuInfoList{rocm}, 9 * format.GibiByte, 20, false},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OLLAMA_UNIFIED_MEMORY", tt.enabled)
+			estimate := MemoryEstimate{Layers: tt.layers, TotalSize: tt.total}
+			assert.Equal(t, tt.want, useUnifiedMemory(tt.gpus, estimate, 33))
+		})
+	}
+}
+
+func TestStreamWeights(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("weights are only streamed on linux")
+	}
+
+	f, err := os.CreateTemp(t.TempDir(), "model")
+	require.NoError(t, err)
+	defer f.Close()
+
+	require.NoError(t, ggml.WriteGGUF(f, ggml.KV{
+		"general.architecture": "llama",
+		"llama.block_count":    uint32(4),
+	}, []ggml.Tensor{
+		{Name: "blk.0.attn.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+	}))
+
+	g, err := LoadModel(f.Name(), 0)
+	require.NoError(t, err)
+
+	cpu := discover.GpuInfoList{{Library: "cpu"}}
+	cuda := discover.GpuInfoList{{Library: "cuda"}}
+
+	// 1GiB resident without weights and 2GiB for each layer
+	estimate := MemoryEstimate{
+		TotalSize:         10 * format.GibiByte,
+		memoryWeights:     8 * format.GibiByte,
+		memoryLayerOutput: 1 * format.GibiByte,
+	}
+
+	noMMap := false
+	cases := []struct {
+		name      string
+		enabled   string
+		gpus      discover.GpuInfoList
+		adapters  []string
+		opts      api.Options
+		available uint64
+		want      bool
+	}{
+		{"enough for layers ahead", "1", cpu, nil, api.Options{}, 6 * format.GibiByte, true},
+		{"disabled", "", cpu, nil, api.Options{}, 6 * format.GibiByte, false},
+		{"too little", "1", cpu, nil, api.Options{}, 4 * format.GibiByte, false},
+		{"gpu", "1", cuda, nil, api.Options{}, 6 * format.GibiByte, false},
+		{"adapters", "1", cpu, []string{"adapter"}, api.Options{}, 6 * format.GibiByte, false},
+		{"no mmap", "1", cpu, nil, api.Options{Runner: api.Runner{UseMMap: &noMMap}}, 6 * format.GibiByte, false},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OLLAMA_STREAM_WEIGHTS", tt.enabled)
+			assert.Equal(t, tt.want, streamWeights(tt.gpus, g, tt.adapters, tt.opts, estimate, tt.available))
+		})
+	}
+}
+
+func TestPipelineBatchSize(t *testing.T) {
+	cases := []struct {
+		name   string
+		ubatch int
+		split  string
+		want   int
+	}{
+		{"single gpu", 0, "", 0},
+		{"two gpus", 0, "10,22", 256},
+		{"four gpus", 0, "8,8,8,9", 128},
+		{"layers on one gpu", 0, "0,33", 0},
+		{"num_ubatch", 64, "", 64},
+		{"num_ubatch with gpus", 64, "10,22", 64},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := api.Options{Runner: api.Runner{NumBatch: 512, NumUBatch: tt.ubatch}}
+			assert.Equal(t, tt.want, pipelineBatchSize(opts, MemoryEstimate{TensorSplit: tt.split}))
+		})
+	}
 }
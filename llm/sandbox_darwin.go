@@ -0,0 +1,29 @@
+package llm
+
+import (
+	"os"
+	"os/exec"
+)
+
+const sandboxExec = "/usr/bin/sandbox-exec"
+
+// sandboxProfile denies the runner network access except for serving the
+// loopback port the server connects to.
+const sandboxProfile = `(version 1)
+(allow default)
+(deny network*)
+(allow network-bind network-inbound (local ip "localhost:*"))
+(allow network* (remote unix-socket))
+`
+
+// sandbox wraps the runner with sandbox-exec, which applies sandboxProfile
+// before the runner starts.
+func sandbox(cmd *exec.Cmd) (func(), error) {
+	if _, err := os.Stat(sandboxExec); err != nil {
+		return nil, err
+	}
+
+	cmd.Args = append([]string{sandboxExec, "-p", sandboxProfile, cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = sandboxExec
+	return func() {}, nil
+}
@@ -0,0 +1,60 @@
+package llm
+
+import (
+	"os/exec"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// sandbox starts the runner with a copy of the token of the server lowered to
+// the low integrity level, so that the runner can read models and libraries
+// but not write to files, registry keys or processes of the user. The
+// returned function closes the token once the runner started.
+//
+// Unlike on Linux and macOS, network access is not restricted. That would
+// take an AppContainer without the internetClient capability, but the server
+// couldn't connect to the runner inside one, since loopback connections into
+// AppContainers are blocked unless exempted for the whole package, and
+// exec.Cmd can't pass the security capabilities that start a process in one.
+//
+// The runner also can't write files that the server created, such as the
+// adapters of fine-tuning and the KV cache snapshots, which is why the
+// sandbox is off by default on Windows.
+func sandbox(cmd *exec.Cmd) (func(), error) {
+	var token windows.Token
+	if err := windows.OpenProcessToken(windows.CurrentProcess(), windows.TOKEN_DUPLICATE|windows.TOKEN_QUERY, &token); err != nil {
+		return nil, err
+	}
+	defer token.Close()
+
+	var low windows.Token
+	if err := windows.DuplicateTokenEx(token,
+		windows.TOKEN_ASSIGN_PRIMARY|windows.TOKEN_DUPLICATE|windows.TOKEN_QUERY|windows.TOKEN_ADJUST_DEFAULT,
+		nil, windows.SecurityImpersonation, windows.TokenPrimary, &low); err != nil {
+		return nil, err
+	}
+
+	sid, err := windows.CreateWellKnownSid(windows.WinLowLabelSid)
+	if err != nil {
+		low.Close()
+		return nil, err
+	}
+
+	label := windows.Tokenmandatorylabel{Label: windows.SIDAndAttributes{Sid: sid, Attributes: windows.SE_GROUP_INTEGRITY}}
+	if err := windows.SetTokenInformation(low, windows.TokenIntegrityLevel, (*byte)(unsafe.Pointer(&label)), label.Size()); err != nil {
+		low.Close()
+		return nil, err
+	}
+
+	// LlamaServerSysProcAttr is shared by every runner
+	var attr syscall.SysProcAttr
+	if cmd.SysProcAttr != nil {
+		attr = *cmd.SysProcAttr
+	}
+	attr.Token = syscall.Token(low)
+	cmd.SysProcAttr = &attr
+
+	return func() { low.Close() }, nil
+}
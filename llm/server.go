@@ -3,13 +3,13 @@ package llm
 import (
 	"bufio"
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
-	"log/slog"
+	"maps"
 	"math/rand"
 	"net"
 	"net/http"
@@ -17,6 +17,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -30,20 +31,35 @@ import (
 	"github.com/ollama/ollama/format"
 	"github.com/ollama/ollama/fs/ggml"
 	"github.com/ollama/ollama/llama"
+	"github.com/ollama/ollama/logutil"
+	"github.com/ollama/ollama/ml"
 	"github.com/ollama/ollama/model"
 )
 
+// runnerLog logs the management of runners at the level of their subsystem
+var runnerLog = logutil.Subsystem(logutil.Runner)
+
 type LlamaServer interface {
 	Ping(ctx context.Context) error
 	WaitUntilRunning(ctx context.Context) error
 	Completion(ctx context.Context, req CompletionRequest, fn func(CompletionResponse)) error
 	Embedding(ctx context.Context, input string) ([]float32, error)
+	Embeddings(ctx context.Context, inputs []string) ([]EmbeddingResult, error)
+	Score(ctx context.Context, req ScoreRequest) (*ScoreResponse, error)
+	Classify(ctx context.Context, input string) (*ClassifyResponse, error)
+	Finetune(ctx context.Context, req FinetuneRequest, fn func(FinetuneResponse)) error
 	Tokenize(ctx context.Context, content string) ([]int, error)
 	Detokenize(ctx context.Context, tokens []int) (string, error)
+	CacheSlots(ctx context.Context) ([]CacheSlot, error)
+	PinCache(ctx context.Context, key string, pinned bool) error
+	EvictCache(ctx context.Context, key string) error
+	SaveImage(ctx context.Context, path string) error
 	Close() error
 	EstimatedVRAM() uint64 // Total VRAM across all GPUs
 	EstimatedTotal() uint64
 	EstimatedVRAMByGPU(gpuID string) uint64
+	LoadProgress() float32 // Fraction of the model read from disk, as of the last status check
+	Runtime() api.ProcessModelRuntime
 }
 
 // llmServer is an instance of the llama.cpp server
@@ -67,10 +83,17 @@ type llmServer struct {
 
 	estimate    MemoryEstimate
 	totalLayers uint64
+	runtime     api.ProcessModelRuntime
 	// gpuCount     int
 	gpus         discover.GpuInfoList // Recorded just before the model loaded, free space will be incorrect
 	loadDuration time.Duration        // Record how long it took the model to load
-	loadProgress float32
+
+	loadProgressMu sync.Mutex
+	loadProgress   float32
+
+	// streams is the latest count of paused sequences the runner reported
+	streamsMu sync.Mutex
+	streams   StreamStats
 
 	sem *semaphore.Weighted
 }
@@ -102,7 +125,7 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 	systemTotalMemory := systemInfo.System.TotalMemory
 	systemFreeMemory := systemInfo.System.FreeMemory
 	systemSwapFreeMemory := systemInfo.System.FreeSwap
-	slog.Info("system memory", "total", format.HumanBytes2(systemTotalMemory), "free", format.HumanBytes2(systemFreeMemory), "free_swap", format.HumanBytes2(systemSwapFreeMemory))
+	runnerLog.Info("system memory", "total", format.HumanBytes2(systemTotalMemory), "free", format.HumanBytes2(systemFreeMemory), "free_swap", format.HumanBytes2(systemSwapFreeMemory))
 
 	// If the user wants zero GPU layers, reset the gpu list to be CPU/system ram info
 	if opts.NumGPU == 0 {
@@ -110,7 +133,17 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 	}
 
 	estimate := EstimateGPULayers(gpus, f, projectors, opts)
-	if len(gpus) > 1 || gpus[0].Library != "cpu" {
+	unified := opts.NumGPU < 0 && useUnifiedMemory(gpus, estimate, int(f.KV().BlockCount()+1))
+	if unified {
+		runnerLog.Warn("model is larger than the free VRAM, loading it into unified memory which is slower",
+			"required", format.HumanBytes2(estimate.TotalSize), "free", format.HumanBytes2(gpus[0].FreeMemory))
+		opts.NumGPU = int(f.KV().BlockCount() + 1)
+
+		// the model takes all of the VRAM, and the rest from system memory
+		estimate.Layers = opts.NumGPU
+		estimate.VRAMSize = min(estimate.TotalSize, gpus[0].FreeMemory)
+		estimate.GPUSizes = []uint64{estimate.VRAMSize}
+	} else if len(gpus) > 1 || gpus[0].Library != "cpu" {
 		switch {
 		case gpus[0].Library == "metal" && estimate.VRAMSize > systemTotalMemory:
 			// disable partial offloading when model is greater than total system memory as this
@@ -126,16 +159,20 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 
 	// On linux and windows, over-allocating CPU memory will almost always result in an error
 	// Darwin has fully dynamic swap so has no direct concept of free swap space
+	var streaming bool
 	if runtime.GOOS != "darwin" {
 		systemMemoryRequired := estimate.TotalSize - estimate.VRAMSize
 		available := systemFreeMemory + systemSwapFreeMemory
-		if systemMemoryRequired > available {
-			slog.Warn("model request too large for system", "requested", format.HumanBytes2(systemMemoryRequired), "available", available, "total", format.HumanBytes2(systemTotalMemory), "free", format.HumanBytes2(systemFreeMemory), "swap", format.HumanBytes2(systemSwapFreeMemory))
+		if systemMemoryRequired > available && streamWeights(gpus, f, adapters, opts, estimate, available) {
+			runnerLog.Warn("model is larger than system memory, streaming weights from disk", "requested", format.HumanBytes2(systemMemoryRequired), "available", format.HumanBytes2(available))
+			streaming = true
+		} else if systemMemoryRequired > available {
+			runnerLog.Warn("model request too large for system", "requested", format.HumanBytes2(systemMemoryRequired), "available", available, "total", format.HumanBytes2(systemTotalMemory), "free", format.HumanBytes2(systemFreeMemory), "swap", format.HumanBytes2(systemSwapFreeMemory))
 			return nil, fmt.Errorf("model requires more system memory (%s) than is available (%s)", format.HumanBytes2(systemMemoryRequired), format.HumanBytes2(available))
 		}
 	}
 
-	slog.Info("offload", "", estimate)
+	runnerLog.Info("offload", "", estimate)
 
 	params := []string{
 		"--model", modelPath,
@@ -170,30 +207,32 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 
 	fa := envconfig.FlashAttention()
 	if fa && !gpus.FlashAttentionSupported() {
-		slog.Warn("flash attention enabled but not supported by gpu")
+		runnerLog.Warn("flash attention enabled but not supported by gpu")
 		fa = false
 	}
 
 	if fa && !f.SupportsFlashAttention() {
-		slog.Warn("flash attention enabled but not supported by model")
+		runnerLog.Warn("flash attention enabled but not supported by model")
 		fa = false
 	}
 
 	kvct := strings.ToLower(envconfig.KvCacheType())
+	cacheType := "f16"
 
 	if fa {
-		slog.Info("enabling flash attention")
+		runnerLog.Info("enabling flash attention")
 		params = append(params, "--flash-attn")
 
 		// Flash Attention also supports kv cache quantization
 		// Enable if the requested and kv cache type is supported by the model
 		if kvct != "" && f.SupportsKVCacheType(kvct) {
 			params = append(params, "--kv-cache-type", kvct)
+			cacheType = kvct
 		} else {
-			slog.Warn("kv cache type not supported by model", "type", kvct)
+			runnerLog.Warn("kv cache type not supported by model", "type", kvct)
 		}
 	} else if kvct != "" && kvct != "f16" {
-		slog.Warn("quantized kv cache requested but flash attention disabled", "type", kvct)
+		runnerLog.Warn("quantized kv cache requested but flash attention disabled", "type", kvct)
 	}
 
 	// mmap has issues with partial offloading on metal
@@ -209,7 +248,10 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 	// Windows CUDA should not use mmap for best performance
 	// Linux  with a model larger than free space, mmap leads to thrashing
 	// For CPU loads we want the memory to be allocated, not FS cache
-	if (runtime.GOOS == "windows" && gpus[0].Library == "cuda" && opts.UseMMap == nil) ||
+	// Streaming weights relies on mmap to page them in and out
+	if streaming {
+		params = append(params, "--stream-weights", strconv.Itoa(streamWeightsAhead))
+	} else if (runtime.GOOS == "windows" && gpus[0].Library == "cuda" && opts.UseMMap == nil) ||
 		(runtime.GOOS == "linux" && systemFreeMemory < estimate.TotalSize && opts.UseMMap == nil) ||
 		(gpus[0].Library == "cpu" && opts.UseMMap == nil) ||
 		(opts.UseMMap != nil && !*opts.UseMMap) {
@@ -242,7 +284,7 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 	lib := gpus[0].RunnerName()
 	requested := envconfig.LLMLibrary()
 	if libs[requested] != "" {
-		slog.Info("using requested gpu library", "requested", requested)
+		runnerLog.Info("using requested gpu library", "requested", requested)
 		lib = requested
 	}
 
@@ -259,7 +301,7 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 			compatible = append(compatible, k)
 		}
 	}
-	slog.Debug("compatible gpu libraries", "compatible", compatible)
+	runnerLog.Debug("compatible gpu libraries", "compatible", compatible)
 	exe, err := os.Executable()
 	if err != nil {
 		return nil, fmt.Errorf("unable to lookup executable path: %w", err)
@@ -271,11 +313,19 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 
 	var llamaModel *llama.Model
 	var textProcessor model.TextProcessor
-	if envconfig.NewEngine() || f.KV().OllamaEngineRequired() {
-		textProcessor, err = model.NewTextProcessor(modelPath)
-		if err != nil {
+	// only the llama.cpp runner streams weights
+	if !streaming && (envconfig.NewEngine() || f.KV().OllamaEngineRequired()) {
+		if err := model.Supported(f.KV().Architecture()); err != nil {
+			var unsupported *model.UnsupportedError
+			if f.KV().OllamaEngineRequired() || !errors.As(err, &unsupported) {
+				return nil, err
+			}
+
+			runnerLog.Info("model not supported by Ollama engine, switching to compatibility mode",
+				"model", modelPath, "architecture", unsupported.Architecture, "reason", "architecture not registered")
+		} else if textProcessor, err = model.NewTextProcessor(modelPath); err != nil {
 			// To prepare for opt-out mode, instead of treating this as an error, we fallback to the old runner
-			slog.Debug("model not yet supported by Ollama engine, switching to compatibility mode", "model", modelPath, "error", err)
+			runnerLog.Debug("model not yet supported by Ollama engine, switching to compatibility mode", "model", modelPath, "error", err)
 		}
 	}
 	if textProcessor == nil {
@@ -289,6 +339,37 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 		params = append(params, "--mmproj", projectors[0])
 	}
 
+	rt := api.ProcessModelRuntime{
+		NumCtx:         opts.NumCtx / max(numParallel, 1),
+		NumBatch:       opts.NumBatch,
+		NumUBatch:      min(cmp.Or(opts.NumUBatch, 512), opts.NumBatch),
+		NumParallel:    numParallel,
+		KvCacheType:    cacheType,
+		FlashAttention: fa,
+		TotalLayers:    int(f.KV().BlockCount() + 1),
+		Engine:         "llama.cpp",
+		Library:        gpus[0].Library,
+		UnifiedMemory:  unified,
+		StreamWeights:  streaming,
+	}
+
+	if textProcessor != nil {
+		rt.Engine = "ollama"
+		// the Ollama engine computes each batch at once
+		rt.NumUBatch = opts.NumBatch
+	}
+
+	if len(compatible) > 0 {
+		rt.Library = compatible[0]
+	}
+
+	if gpus[0].Library != "cpu" {
+		rt.GPULayers = estimate.Layers
+		if opts.NumGPU >= 0 {
+			rt.GPULayers = min(opts.NumGPU, rt.TotalLayers)
+		}
+	}
+
 	// iterate through compatible GPU libraries such as 'cuda_v12', 'cuda_v11', 'rocm', etc.
 	// adding each library's respective path to the LD_LIBRARY_PATH, until finally running
 	// without any LD_LIBRARY_PATH flags
@@ -302,7 +383,7 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 			}
 		}
 		if port == 0 {
-			slog.Debug("ResolveTCPAddr failed, using random port")
+			runnerLog.Debug("ResolveTCPAddr failed, using random port")
 			port = rand.Intn(65535-49152) + 49152 // get a random port in the ephemeral range
 		}
 		finalParams := []string{"runner"}
@@ -310,6 +391,28 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 			// New engine
 			// TODO - if we have failure to load scenarios, add logic to retry with the old runner
 			finalParams = append(finalParams, "--ollama-engine")
+			if envconfig.ProgressiveLoad() {
+				finalParams = append(finalParams, "--progressive-load")
+			}
+			if image := ImagePath(envconfig.HibernateFile(), modelPath); image != "" {
+				if _, err := os.Stat(image); err == nil {
+					finalParams = append(finalParams, "--image", image)
+				}
+			}
+			if bandwidth := envconfig.LoadBandwidth(); bandwidth > 0 {
+				finalParams = append(finalParams, "--load-bandwidth", strconv.FormatUint(bandwidth, 10))
+			}
+			if ubatch := pipelineBatchSize(opts, estimate); ubatch > 0 {
+				finalParams = append(finalParams, "--ubatch-size", strconv.Itoa(ubatch))
+			}
+		} else if opts.NumUBatch > 0 {
+			finalParams = append(finalParams, "--ubatch-size", strconv.Itoa(opts.NumUBatch))
+		}
+		if buffer := envconfig.StreamBuffer(); buffer > 0 {
+			finalParams = append(finalParams, "--stream-buffer", strconv.FormatUint(uint64(buffer), 10))
+		}
+		if envconfig.LowPriorityLoad() {
+			finalParams = append(finalParams, "--low-priority-load")
 		}
 		finalParams = append(finalParams, params...)
 		finalParams = append(finalParams, "--port", strconv.Itoa(port))
@@ -332,7 +435,7 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 		if len(compatible) > 0 {
 			c := compatible[0]
 			if libpath, ok := libs[c]; ok {
-				slog.Debug("adding gpu library", "path", libpath)
+				runnerLog.Debug("adding gpu library", "path", libpath)
 				libraryPaths = append(libraryPaths, libpath)
 			}
 		}
@@ -340,7 +443,7 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 		// Note: we always put the dependency path first
 		// since this was the exact version we compiled/linked against
 		if gpus[0].DependencyPath != nil {
-			slog.Debug("adding gpu dependency paths", "paths", gpus[0].DependencyPath)
+			runnerLog.Debug("adding gpu dependency paths", "paths", gpus[0].DependencyPath)
 			// assume gpus from the same library have the same dependency path
 			libraryPaths = append(gpus[0].DependencyPath, libraryPaths...)
 		}
@@ -360,6 +463,7 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 			numParallel:   numParallel,
 			sem:           semaphore.NewWeighted(int64(numParallel)),
 			totalLayers:   f.KV().BlockCount() + 1,
+			runtime:       rt,
 			gpus:          gpus,
 			done:          make(chan error, 1),
 		}
@@ -369,16 +473,32 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 		s.cmd.Stderr = s.status
 		s.cmd.SysProcAttr = LlamaServerSysProcAttr
 
+		release := func() {}
+		if envconfig.RunnerSandbox() {
+			if r, err := sandbox(s.cmd); err != nil {
+				runnerLog.Warn("unable to sandbox runner", "error", err)
+			} else {
+				release = r
+			}
+		}
+
 		envWorkarounds := [][2]string{}
 		for _, gpu := range gpus {
 			envWorkarounds = append(envWorkarounds, gpu.EnvWorkarounds...)
 		}
+		if unified {
+			envWorkarounds = append(envWorkarounds, [2]string{"GGML_CUDA_ENABLE_UNIFIED_MEMORY", "1"})
+		}
 		visibleDevicesEnv, visibleDevicesEnvVal := gpus.GetVisibleDevicesEnv()
 		pathEnvVal := strings.Join(libraryPaths, string(filepath.ListSeparator))
 
 		// Update or add the path and visible devices variable with our adjusted version
 		pathNeeded := true
 		devicesNeeded := visibleDevicesEnv != ""
+		workaroundsNeeded := make([]bool, len(envWorkarounds))
+		for i := range workaroundsNeeded {
+			workaroundsNeeded[i] = true
+		}
 		for i := range s.cmd.Env {
 			cmp := strings.SplitN(s.cmd.Env[i], "=", 2)
 			if strings.EqualFold(cmp[0], pathEnv) {
@@ -388,9 +508,10 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 				s.cmd.Env[i] = visibleDevicesEnv + "=" + visibleDevicesEnvVal
 				devicesNeeded = false
 			} else if len(envWorkarounds) != 0 {
-				for _, kv := range envWorkarounds {
+				for j, kv := range envWorkarounds {
 					if strings.EqualFold(cmp[0], kv[0]) {
 						s.cmd.Env[i] = kv[0] + "=" + kv[1]
+						workaroundsNeeded[j] = false
 					}
 				}
 			}
@@ -401,8 +522,17 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 		if devicesNeeded {
 			s.cmd.Env = append(s.cmd.Env, visibleDevicesEnv+"="+visibleDevicesEnvVal)
 		}
+		for i, kv := range envWorkarounds {
+			if workaroundsNeeded[i] {
+				s.cmd.Env = append(s.cmd.Env, kv[0]+"="+kv[1])
+			}
+		}
+
+		// runners log at the levels of the server when they start, which
+		// may have been changed since it did
+		s.cmd.Env = append(s.cmd.Env, "OLLAMA_LOG_LEVEL="+logutil.FormatLevels())
 
-		slog.Info("starting llama server", "cmd", s.cmd)
+		runnerLog.Info("starting llama server", "cmd", s.cmd)
 		if envconfig.Debug() {
 			filteredEnv := []string{}
 			for _, ev := range s.cmd.Env {
@@ -420,10 +550,12 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 				}
 			}
 			// Log at debug as the environment is inherited and might contain sensitive information
-			slog.Debug("subprocess", "environment", filteredEnv)
+			runnerLog.Debug("subprocess", "environment", filteredEnv)
 		}
 
-		if err = s.cmd.Start(); err != nil {
+		err = s.cmd.Start()
+		release()
+		if err != nil {
 			var msg string
 			if s.status != nil && s.status.LastErrMsg != "" {
 				msg = s.status.LastErrMsg
@@ -436,7 +568,7 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 				return nil, err
 			}
 
-			slog.Warn("unable to start runner with compatible gpu", "error", err, "compatible", compatible)
+			runnerLog.Warn("unable to start runner with compatible gpu", "error", err, "compatible", compatible)
 			compatible = compatible[1:]
 			continue
 		}
@@ -446,7 +578,7 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 			err := s.cmd.Wait()
 			// Favor a more detailed message over the process exit status
 			if err != nil && s.status != nil && s.status.LastErrMsg != "" {
-				slog.Error("llama runner terminated", "error", err)
+				runnerLog.Error("llama runner terminated", "error", err)
 				if strings.Contains(s.status.LastErrMsg, "unknown model") {
 					s.status.LastErrMsg = "this model is not supported by your version of Ollama. You may need to upgrade"
 				}
@@ -486,8 +618,51 @@ func (s ServerStatus) String() string {
 }
 
 type ServerStatusResponse struct {
-	Status   ServerStatus `json:"status"`
-	Progress float32      `json:"progress"`
+	Status   ServerStatus        `json:"status"`
+	Progress float32             `json:"progress"`
+	SelfTest []ml.SelfTestResult `json:"self_test,omitempty"`
+	Streams  StreamStats         `json:"streams"`
+
+	// Error is why the runner failed, such as when loading the weights in
+	// the background, if its status is ServerStatusError
+	Error string `json:"error,omitempty"`
+}
+
+// StreamStats counts the sequences of a runner whose decoding paused because
+// their client read responses slower than they were generated.
+type StreamStats struct {
+	// Paused is the number of sequences that are currently paused
+	Paused int `json:"paused"`
+
+	// Pauses is the number of times any sequence has paused
+	Pauses uint64 `json:"pauses"`
+}
+
+var (
+	selfTestMu      sync.Mutex
+	selfTestResults = map[string]ml.SelfTestResult{}
+)
+
+// SelfTestResults returns the latest self-test result of each device that
+// runners have tested, ordered by device.
+func SelfTestResults() []ml.SelfTestResult {
+	selfTestMu.Lock()
+	defer selfTestMu.Unlock()
+
+	results := slices.Collect(maps.Values(selfTestResults))
+	slices.SortFunc(results, func(a, b ml.SelfTestResult) int {
+		return cmp.Compare(a.Device, b.Device)
+	})
+	return results
+}
+
+func recordSelfTest(results []ml.SelfTestResult) {
+	selfTestMu.Lock()
+	defer selfTestMu.Unlock()
+
+	for _, result := range results {
+		selfTestResults[result.Device] = result
+	}
 }
 
 func (s *llmServer) getServerStatus(ctx context.Context) (ServerStatus, error) {
@@ -499,7 +674,7 @@ func (s *llmServer) getServerStatus(ctx context.Context) (ServerStatus, error) {
 		}
 		if s.cmd.ProcessState.ExitCode() == -1 {
 			// Most likely a signal killed it, log some more details to try to help troubleshoot
-			slog.Warn("llama runner process no longer running", "sys", s.cmd.ProcessState.Sys(), "string", s.cmd.ProcessState)
+			runnerLog.Warn("llama runner process no longer running", "sys", s.cmd.ProcessState.Sys(), "string", s.cmd.ProcessState)
 		}
 		return ServerStatusError, fmt.Errorf("llama runner process no longer running: %d %s", s.cmd.ProcessState.ExitCode(), msg)
 	}
@@ -509,6 +684,7 @@ func (s *llmServer) getServerStatus(ctx context.Context) (ServerStatus, error) {
 		return ServerStatusError, fmt.Errorf("error creating GET request: %v", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	logutil.SetRequestID(req)
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
@@ -529,13 +705,24 @@ func (s *llmServer) getServerStatus(ctx context.Context) (ServerStatus, error) {
 		return ServerStatusError, fmt.Errorf("health unmarshal encode response: %w", err)
 	}
 
+	recordSelfTest(ssr.SelfTest)
+
+	s.streamsMu.Lock()
+	s.streams = ssr.Streams
+	s.streamsMu.Unlock()
+
 	switch ssr.Status {
-	case ServerStatusLoadingModel:
+	case ServerStatusLoadingModel, ServerStatusReady, ServerStatusNoSlotsAvailable:
+		// weights may still be loading after the runner is ready when
+		// loading progressively
+		s.loadProgressMu.Lock()
 		s.loadProgress = ssr.Progress
-		return ssr.Status, nil
-	case ServerStatusReady, ServerStatusNoSlotsAvailable:
+		s.loadProgressMu.Unlock()
 		return ssr.Status, nil
 	default:
+		if ssr.Error != "" {
+			return ssr.Status, fmt.Errorf("server error: %s", ssr.Error)
+		}
 		return ssr.Status, fmt.Errorf("server error: %+v", ssr)
 	}
 }
@@ -566,25 +753,43 @@ func (s *llmServer) getServerStatusRetry(ctx context.Context) (ServerStatus, err
 func (s *llmServer) Ping(ctx context.Context) error {
 	_, err := s.getServerStatus(ctx)
 	if err != nil {
-		slog.Debug("server unhealthy", "error", err)
+		runnerLog.Debug("server unhealthy", "error", err)
 		return err
 	}
 	return nil
 }
 
+func (s *llmServer) LoadProgress() float32 {
+	s.loadProgressMu.Lock()
+	defer s.loadProgressMu.Unlock()
+	return s.loadProgress
+}
+
+// StreamStats asks the runner how many of its sequences are paused waiting
+// for their clients.
+func (s *llmServer) StreamStats(ctx context.Context) (StreamStats, error) {
+	if _, err := s.getServerStatus(ctx); err != nil {
+		return StreamStats{}, err
+	}
+
+	s.streamsMu.Lock()
+	defer s.streamsMu.Unlock()
+	return s.streams, nil
+}
+
 func (s *llmServer) WaitUntilRunning(ctx context.Context) error {
 	start := time.Now()
 	stallDuration := envconfig.LoadTimeout()    // If no progress happens
 	stallTimer := time.Now().Add(stallDuration) // give up if we stall
 
-	slog.Info("waiting for llama runner to start responding")
+	runnerLog.Info("waiting for llama runner to start responding")
 	var lastStatus ServerStatus = -1
 	fullyLoaded := false
 
 	for {
 		select {
 		case <-ctx.Done():
-			slog.Warn("client connection closed before server finished loading, aborting load")
+			runnerLog.Warn("client connection closed before server finished loading, aborting load")
 			return fmt.Errorf("timed out waiting for llama runner to start: %w", ctx.Err())
 		case err := <-s.done:
 			return fmt.Errorf("llama runner process has terminated: %w", err)
@@ -596,7 +801,7 @@ func (s *llmServer) WaitUntilRunning(ctx context.Context) error {
 			if s.status != nil && s.status.LastErrMsg != "" {
 				msg = s.status.LastErrMsg
 			}
-			return fmt.Errorf("timed out waiting for llama runner to start - progress %0.2f - %s", s.loadProgress, msg)
+			return fmt.Errorf("timed out waiting for llama runner to start - progress %0.2f - %s", s.LoadProgress(), msg)
 		}
 		if s.cmd.ProcessState != nil {
 			msg := ""
@@ -607,25 +812,25 @@ func (s *llmServer) WaitUntilRunning(ctx context.Context) error {
 		}
 		ctx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
 		defer cancel()
-		priorProgress := s.loadProgress
+		priorProgress := s.LoadProgress()
 		status, _ := s.getServerStatus(ctx)
 		if lastStatus != status && status != ServerStatusReady {
 			// Only log on status changes
-			slog.Info("waiting for server to become available", "status", status)
+			runnerLog.Info("waiting for server to become available", "status", status)
 		}
 		switch status {
 		case ServerStatusReady:
 			s.loadDuration = time.Since(start)
-			slog.Info(fmt.Sprintf("llama runner started in %0.2f seconds", s.loadDuration.Seconds()))
+			runnerLog.Info(fmt.Sprintf("llama runner started in %0.2f seconds", s.loadDuration.Seconds()))
 			return nil
 		default:
 			lastStatus = status
 			// Reset the timer as long as we're making forward progress on the load
-			if priorProgress != s.loadProgress {
-				slog.Debug(fmt.Sprintf("model load progress %0.2f", s.loadProgress))
+			if progress := s.LoadProgress(); priorProgress != progress {
+				runnerLog.Debug(fmt.Sprintf("model load progress %0.2f", progress))
 				stallTimer = time.Now().Add(stallDuration)
-			} else if !fullyLoaded && int(s.loadProgress*100.0) >= 100 {
-				slog.Debug("model load completed, waiting for server to become available", "status", status)
+			} else if !fullyLoaded && int(progress*100.0) >= 100 {
+				runnerLog.Debug("model load completed, waiting for server to become available", "status", status)
 				stallTimer = time.Now().Add(stallDuration)
 				fullyLoaded = true
 			}
@@ -672,6 +877,23 @@ type CompletionRequest struct {
 	Images  []ImageData
 	Options *api.Options
 
+	// EOSProbability reports the probability of ending the sequence with each
+	// response
+	EOSProbability bool
+
+	// Logprobs reports the log probability of each generated token, with
+	// the TopLogprobs most likely tokens at its position
+	Logprobs    bool
+	TopLogprobs int
+
+	// CacheKey names the prompt so that the runner keeps it in a cache
+	// slot for later requests with the same key
+	CacheKey string
+
+	// Throttle, if set, is called with the number of tokens of each response
+	// before it is returned and blocks to limit the rate of output
+	Throttle func(ctx context.Context, tokens int) error `json:"-"`
+
 	Grammar string // set before sending the request to the subprocess
 }
 
@@ -683,6 +905,40 @@ type CompletionResponse struct {
 	PromptEvalDuration time.Duration `json:"prompt_eval_duration"`
 	EvalCount          int           `json:"eval_count"`
 	EvalDuration       time.Duration `json:"eval_duration"`
+
+	// Tokens is the number of generated tokens in Content
+	Tokens int `json:"tokens,omitempty"`
+
+	// EOSProbability is the probability the model gave to ending the sequence
+	// instead of generating the last token of Content
+	EOSProbability float32 `json:"eos_probability,omitempty"`
+
+	// Logprobs are the log probabilities of the tokens of Content
+	Logprobs []api.Logprob `json:"logprobs,omitempty"`
+
+	// PromptTruncated is the number of tokens left out of a prompt that
+	// didn't fit in the context window, set on the final response
+	PromptTruncated int `json:"prompt_truncated,omitempty"`
+
+	// Error is why a completion that the runner ended early failed, set on
+	// the final response with a Code like [api.ErrorResponse.Code]
+	Error string `json:"error,omitempty"`
+	Code  string `json:"code,omitempty"`
+}
+
+// CompletionError is the error of a completion that the runner ended early,
+// with the tokens it processed until then.
+type CompletionError struct {
+	Err error
+	api.Metrics
+}
+
+func (e *CompletionError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CompletionError) Unwrap() error {
+	return e.Err
 }
 
 func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn func(CompletionResponse)) error {
@@ -695,16 +951,24 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 		case `"json"`:
 			req.Grammar = grammarJSON
 		default:
-			if req.Format[0] != '{' {
-				return fmt.Errorf("invalid format: %q; expected \"json\" or a valid JSON Schema object", req.Format)
-			}
-
-			// User provided a JSON schema
-			g := llama.SchemaToGrammar(req.Format)
-			if g == nil {
-				return fmt.Errorf("invalid JSON schema in format")
+			var grammar string
+			switch {
+			case req.Format[0] == '{':
+				// User provided a JSON schema
+				g := llama.SchemaToGrammar(req.Format)
+				if g == nil {
+					return fmt.Errorf("invalid JSON schema in format")
+				}
+				req.Grammar = string(g)
+			case json.Unmarshal(req.Format, &grammar) == nil && strings.Contains(grammar, "::="):
+				// User provided a GBNF grammar
+				if !llama.ValidGrammar(grammar) {
+					return fmt.Errorf("invalid GBNF grammar in format")
+				}
+				req.Grammar = grammar
+			default:
+				return fmt.Errorf("invalid format: %q; expected \"json\", a valid JSON Schema object or a GBNF grammar", req.Format)
 			}
-			req.Grammar = string(g)
 		}
 	}
 
@@ -713,11 +977,15 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 		req.Options = &opts
 	}
 
+	if req.Options.IgnoreEOS && req.Options.NumPredict <= 0 {
+		return errors.New("ignore_eos requires num_predict to be set")
+	}
+
 	if err := s.sem.Acquire(ctx, 1); err != nil {
 		if errors.Is(err, context.Canceled) {
-			slog.Info("aborting completion request due to client closing the connection")
+			runnerLog.InfoContext(ctx, "aborting completion request due to client closing the connection")
 		} else {
-			slog.Error("Failed to acquire semaphore", "error", err)
+			runnerLog.ErrorContext(ctx, "Failed to acquire semaphore", "error", err)
 		}
 		return err
 	}
@@ -751,6 +1019,7 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 		return fmt.Errorf("error creating POST request: %v", err)
 	}
 	serverReq.Header.Set("Content-Type", "application/json")
+	logutil.SetRequestID(serverReq)
 
 	res, err := http.DefaultClient.Do(serverReq)
 	if err != nil {
@@ -763,7 +1032,7 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 		if err != nil {
 			return fmt.Errorf("failed reading llm error response: %w", err)
 		}
-		log.Printf("llm predict error: %s", bodyBytes)
+		runnerLog.ErrorContext(ctx, "llm predict error", "error", string(bodyBytes))
 		return fmt.Errorf("%s", bodyBytes)
 	}
 
@@ -786,7 +1055,7 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 				continue
 			}
 
-			// slog.Debug("got line", "line", string(line))
+			// runnerLog.Debug("got line", "line", string(line))
 			evt, ok := bytes.CutPrefix(line, []byte("data: "))
 			if !ok {
 				evt = line
@@ -806,17 +1075,41 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 
 			// 30 picked as an arbitrary max token repeat limit, modify as needed
 			if tokenRepeat > 30 {
-				slog.Debug("prediction aborted, token repeat limit reached")
-				return ctx.Err()
+				runnerLog.DebugContext(ctx, "prediction aborted, token repeat limit reached")
+				fn(CompletionResponse{
+					Done:       true,
+					DoneReason: api.DoneReasonRepetitionDetected,
+				})
+				return nil
 			}
 
 			if c.Content != "" {
+				if req.Throttle != nil {
+					if err := req.Throttle(ctx, c.Tokens); err != nil {
+						return err
+					}
+				}
+
 				fn(CompletionResponse{
-					Content: c.Content,
+					Content:        c.Content,
+					EOSProbability: c.EOSProbability,
+					Logprobs:       c.Logprobs,
 				})
 			}
 
 			if c.Done {
+				if c.Error != "" {
+					return &CompletionError{
+						Err: api.StatusError{StatusCode: http.StatusInternalServerError, ErrorMessage: c.Error, Code: c.Code},
+						Metrics: api.Metrics{
+							PromptEvalCount:    c.PromptEvalCount,
+							PromptEvalDuration: c.PromptEvalDuration,
+							EvalCount:          c.EvalCount,
+							EvalDuration:       c.EvalDuration,
+						},
+					}
+				}
+
 				fn(c)
 				return nil
 			}
@@ -843,18 +1136,57 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 
 type EmbeddingRequest struct {
 	Content string `json:"content"`
+
+	// Contents are several inputs to embed instead of Content, which the
+	// runner processes in the same batches
+	Contents []string `json:"contents,omitempty"`
 }
 
 type EmbeddingResponse struct {
 	Embedding []float32 `json:"embedding"`
+
+	// Embeddings are the results for Contents, in the same order
+	Embeddings []EmbeddingResult `json:"embeddings,omitempty"`
+}
+
+// EmbeddingResult is the embedding of one of several inputs, or the error
+// that kept it from being embedded.
+type EmbeddingResult struct {
+	Embedding []float32 `json:"embedding,omitempty"`
+	Error     string    `json:"error,omitempty"`
 }
 
 func (s *llmServer) Embedding(ctx context.Context, input string) ([]float32, error) {
+	resp, err := s.embed(ctx, EmbeddingRequest{Content: input})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Embedding, nil
+}
+
+// Embeddings embeds inputs in as few forward passes as the parallel
+// sequences of the runner allow. Inputs that fail have the error in their
+// result rather than failing the others.
+func (s *llmServer) Embeddings(ctx context.Context, inputs []string) ([]EmbeddingResult, error) {
+	resp, err := s.embed(ctx, EmbeddingRequest{Contents: inputs})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.Embeddings) != len(inputs) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(inputs), len(resp.Embeddings))
+	}
+
+	return resp.Embeddings, nil
+}
+
+func (s *llmServer) embed(ctx context.Context, req EmbeddingRequest) (*EmbeddingResponse, error) {
 	if err := s.sem.Acquire(ctx, 1); err != nil {
 		if errors.Is(err, context.Canceled) {
-			slog.Info("aborting embedding request due to client closing the connection")
+			runnerLog.InfoContext(ctx, "aborting embedding request due to client closing the connection")
 		} else {
-			slog.Error("Failed to acquire semaphore", "error", err)
+			runnerLog.ErrorContext(ctx, "Failed to acquire semaphore", "error", err)
 		}
 		return nil, err
 	}
@@ -868,7 +1200,7 @@ func (s *llmServer) Embedding(ctx context.Context, input string) ([]float32, err
 		return nil, fmt.Errorf("unexpected server status: %s", status)
 	}
 
-	data, err := json.Marshal(EmbeddingRequest{Content: input})
+	data, err := json.Marshal(req)
 	if err != nil {
 		return nil, fmt.Errorf("error marshaling embed data: %w", err)
 	}
@@ -878,6 +1210,7 @@ func (s *llmServer) Embedding(ctx context.Context, input string) ([]float32, err
 		return nil, fmt.Errorf("error creating embed request: %w", err)
 	}
 	r.Header.Set("Content-Type", "application/json")
+	logutil.SetRequestID(r)
 
 	resp, err := http.DefaultClient.Do(r)
 	if err != nil {
@@ -891,7 +1224,7 @@ func (s *llmServer) Embedding(ctx context.Context, input string) ([]float32, err
 	}
 
 	if resp.StatusCode >= 400 {
-		log.Printf("llm embedding error: %s", body)
+		runnerLog.ErrorContext(ctx, "llm embedding error", "error", string(body))
 		return nil, fmt.Errorf("%s", body)
 	}
 
@@ -900,7 +1233,257 @@ func (s *llmServer) Embedding(ctx context.Context, input string) ([]float32, err
 		return nil, fmt.Errorf("unmarshal tokenize response: %w", err)
 	}
 
-	return e.Embedding, nil
+	return &e, nil
+}
+
+type ClassifyRequest struct {
+	Content string `json:"content"`
+}
+
+type ClassifyResponse struct {
+	// Labels are the labels of the model's classification head and Logits
+	// the raw score of each for the content
+	Labels []string  `json:"labels"`
+	Logits []float32 `json:"logits"`
+}
+
+func (s *llmServer) Classify(ctx context.Context, input string) (*ClassifyResponse, error) {
+	if err := s.sem.Acquire(ctx, 1); err != nil {
+		if errors.Is(err, context.Canceled) {
+			runnerLog.InfoContext(ctx, "aborting classify request due to client closing the connection")
+		} else {
+			runnerLog.ErrorContext(ctx, "Failed to acquire semaphore", "error", err)
+		}
+		return nil, err
+	}
+	defer s.sem.Release(1)
+
+	// Make sure the server is ready
+	status, err := s.getServerStatusRetry(ctx)
+	if err != nil {
+		return nil, err
+	} else if status != ServerStatusReady {
+		return nil, fmt.Errorf("unexpected server status: %s", status)
+	}
+
+	data, err := json.Marshal(ClassifyRequest{Content: input})
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling classify data: %w", err)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("http://127.0.0.1:%d/classify", s.port), bytes.NewBuffer(data))
+	if err != nil {
+		return nil, fmt.Errorf("error creating classify request: %w", err)
+	}
+	r.Header.Set("Content-Type", "application/json")
+	logutil.SetRequestID(r)
+
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return nil, fmt.Errorf("do classify request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading classify response: %w", err)
+	}
+
+	if resp.StatusCode >= 400 {
+		runnerLog.ErrorContext(ctx, "llm classify error", "error", string(body))
+		return nil, fmt.Errorf("%s", body)
+	}
+
+	var cr ClassifyResponse
+	if err := json.Unmarshal(body, &cr); err != nil {
+		return nil, fmt.Errorf("unmarshal classify response: %w", err)
+	}
+
+	return &cr, nil
+}
+
+// ScoreRequest asks for the log probabilities the model gives to the tokens of
+// Continuation following Prompt. If Prompt is empty, the first token of
+// Continuation is used as the prompt and is not scored.
+type ScoreRequest struct {
+	Prompt       string `json:"prompt"`
+	Continuation string `json:"continuation"`
+}
+
+type ScoreResponse struct {
+	// LogProbs is the natural log probability of each token of the
+	// continuation
+	LogProbs []float64 `json:"logprobs"`
+
+	// Tokens is the text of each token of the continuation
+	Tokens []string `json:"tokens"`
+
+	// Greedy is true if every token of the continuation was the most
+	// likely one
+	Greedy bool `json:"greedy"`
+}
+
+func (s *llmServer) Score(ctx context.Context, req ScoreRequest) (*ScoreResponse, error) {
+	if err := s.sem.Acquire(ctx, 1); err != nil {
+		if errors.Is(err, context.Canceled) {
+			runnerLog.InfoContext(ctx, "aborting score request due to client closing the connection")
+		} else {
+			runnerLog.ErrorContext(ctx, "Failed to acquire semaphore", "error", err)
+		}
+		return nil, err
+	}
+	defer s.sem.Release(1)
+
+	// Make sure the server is ready
+	status, err := s.getServerStatusRetry(ctx)
+	if err != nil {
+		return nil, err
+	} else if status != ServerStatusReady {
+		return nil, fmt.Errorf("unexpected server status: %s", status)
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling score data: %w", err)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("http://127.0.0.1:%d/score", s.port), bytes.NewBuffer(data))
+	if err != nil {
+		return nil, fmt.Errorf("error creating score request: %w", err)
+	}
+	r.Header.Set("Content-Type", "application/json")
+	logutil.SetRequestID(r)
+
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return nil, fmt.Errorf("do score request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading score response: %w", err)
+	}
+
+	if resp.StatusCode >= 400 {
+		runnerLog.ErrorContext(ctx, "llm score error", "error", string(body))
+		return nil, fmt.Errorf("%s", body)
+	}
+
+	var sr ScoreResponse
+	if err := json.Unmarshal(body, &sr); err != nil {
+		return nil, fmt.Errorf("unmarshal score response: %w", err)
+	}
+
+	return &sr, nil
+}
+
+// FinetuneRequest asks the runner to train a LoRA adapter for the loaded
+// model and write it to Adapter.
+type FinetuneRequest struct {
+	Examples []FinetuneExample `json:"examples"`
+
+	// Adapter is the path where the trained adapter is written
+	Adapter string `json:"adapter"`
+
+	Epochs       int      `json:"epochs"`
+	LearningRate float32  `json:"learning_rate"`
+	Rank         int      `json:"rank"`
+	Alpha        float32  `json:"alpha"`
+	Targets      []string `json:"targets"`
+	Seed         int      `json:"seed"`
+
+	// NumCtx is the maximum number of tokens of an example
+	NumCtx int `json:"num_ctx"`
+}
+
+// FinetuneExample is a training example. The model learns to produce
+// Completion following Prompt; the tokens of Prompt only provide context.
+type FinetuneExample struct {
+	Prompt     string `json:"prompt"`
+	Completion string `json:"completion"`
+}
+
+type FinetuneResponse struct {
+	Epoch int `json:"epoch"`
+	Step  int `json:"step"`
+	Steps int `json:"steps"`
+
+	// Loss is the mean loss of the examples of the current epoch so far
+	Loss float32 `json:"loss"`
+
+	Done  bool   `json:"done"`
+	Error string `json:"error,omitempty"`
+}
+
+func (s *llmServer) Finetune(ctx context.Context, req FinetuneRequest, fn func(FinetuneResponse)) error {
+	if err := s.sem.Acquire(ctx, 1); err != nil {
+		if errors.Is(err, context.Canceled) {
+			runnerLog.InfoContext(ctx, "aborting finetune request due to client closing the connection")
+		} else {
+			runnerLog.ErrorContext(ctx, "Failed to acquire semaphore", "error", err)
+		}
+		return err
+	}
+	defer s.sem.Release(1)
+
+	// Make sure the server is ready
+	status, err := s.getServerStatusRetry(ctx)
+	if err != nil {
+		return err
+	} else if status != ServerStatusReady {
+		return fmt.Errorf("unexpected server status: %s", status)
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("error marshaling finetune data: %w", err)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("http://127.0.0.1:%d/finetune", s.port), bytes.NewBuffer(data))
+	if err != nil {
+		return fmt.Errorf("error creating finetune request: %w", err)
+	}
+	r.Header.Set("Content-Type", "application/json")
+	logutil.SetRequestID(r)
+
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return fmt.Errorf("do finetune request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("error reading finetune response: %w", err)
+		}
+		runnerLog.ErrorContext(ctx, "llm finetune error", "error", string(body))
+		return fmt.Errorf("%s", body)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var fr FinetuneResponse
+		if err := json.Unmarshal(scanner.Bytes(), &fr); err != nil {
+			return fmt.Errorf("unmarshal finetune response: %w", err)
+		}
+
+		if fr.Error != "" {
+			return errors.New(fr.Error)
+		}
+
+		fn(fr)
+		if fr.Done {
+			return nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading finetune response: %w", err)
+	}
+
+	return errors.New("finetune ended unexpectedly")
 }
 
 type TokenizeRequest struct {
@@ -967,6 +1550,128 @@ func (s *llmServer) Detokenize(ctx context.Context, tokens []int) (string, error
 	return "", fmt.Errorf("no tokenizer configured")
 }
 
+// CacheSlot is a slot of the cache of a runner that holds the prompt of a
+// request with a cache key.
+type CacheSlot struct {
+	Key      string    `json:"key"`
+	Inputs   int       `json:"inputs"`
+	Pinned   bool      `json:"pinned"`
+	InUse    bool      `json:"in_use"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// CachePinRequest pins or unpins the cache slots of a cache key.
+type CachePinRequest struct {
+	Key    string `json:"key"`
+	Pinned bool   `json:"pinned"`
+}
+
+// CacheEvictRequest removes the prompts of a cache key from the cache.
+type CacheEvictRequest struct {
+	Key string `json:"key"`
+}
+
+// ErrCacheKeyNotFound is returned when no cache slot of a runner holds the
+// prompt of a cache key.
+var ErrCacheKeyNotFound = errors.New("no cached prompt has this key")
+
+// ErrCacheAllPinned is returned when pinning would leave no cache slot of a
+// runner for other prompts.
+var ErrCacheAllPinned = errors.New("pinning would leave no cache slot for other prompts")
+
+// CacheSlots lists the cache slots that hold the prompts of requests with a
+// cache key.
+func (s *llmServer) CacheSlots(ctx context.Context) ([]CacheSlot, error) {
+	var slots []CacheSlot
+	if err := s.cacheRequest(ctx, http.MethodGet, "/cache", nil, &slots); err != nil {
+		return nil, err
+	}
+
+	return slots, nil
+}
+
+// PinCache keeps the prompts of key in the cache, or allows them to be
+// evicted again if pinned is false.
+func (s *llmServer) PinCache(ctx context.Context, key string, pinned bool) error {
+	return s.cacheRequest(ctx, http.MethodPost, "/cache/pin", CachePinRequest{Key: key, Pinned: pinned}, nil)
+}
+
+// EvictCache removes the prompts of key from the cache.
+func (s *llmServer) EvictCache(ctx context.Context, key string) error {
+	return s.cacheRequest(ctx, http.MethodPost, "/cache/evict", CacheEvictRequest{Key: key}, nil)
+}
+
+// ImageRequest saves a restore image of the weights of a runner to Path.
+type ImageRequest struct {
+	Path string `json:"path"`
+}
+
+// ErrImageUnsupported is returned when a runner can't save a restore image.
+var ErrImageUnsupported = errors.New("restore images require the Ollama engine")
+
+// ImagePath returns where the restore image of the model at modelPath is
+// saved when the loaded models are recorded in hibernateFile, or "" if they
+// aren't. Images are named after the model file, so an image is only read
+// back by a runner of the same model.
+func ImagePath(hibernateFile, modelPath string) string {
+	if hibernateFile == "" {
+		return ""
+	}
+
+	return filepath.Join(hibernateFile+".images", filepath.Base(modelPath))
+}
+
+// SaveImage writes the weights of the model, as they were placed on the
+// devices, to path. A runner loading the same model with the same placement
+// reads them from the image rather than the model file.
+func (s *llmServer) SaveImage(ctx context.Context, path string) error {
+	if s.textProcessor == nil {
+		return ErrImageUnsupported
+	}
+
+	return s.cacheRequest(ctx, http.MethodPost, "/image", ImageRequest{Path: path}, nil)
+}
+
+func (s *llmServer) cacheRequest(ctx context.Context, method, path string, body, v any) error {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return err
+		}
+	}
+
+	r, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("http://127.0.0.1:%d%s", s.port, path), &buf)
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	logutil.SetRequestID(r)
+
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return fmt.Errorf("do cache request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	bts, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return ErrCacheKeyNotFound
+	case resp.StatusCode == http.StatusConflict:
+		return ErrCacheAllPinned
+	case resp.StatusCode >= 400:
+		return fmt.Errorf("%s", bytes.TrimSpace(bts))
+	case v != nil:
+		return json.Unmarshal(bts, v)
+	}
+
+	return nil
+}
+
 func (s *llmServer) Close() error {
 	s.llamaModelLock.Lock()
 	if s.llamaModel != nil {
@@ -976,17 +1681,17 @@ func (s *llmServer) Close() error {
 	s.llamaModelLock.Unlock()
 
 	if s.cmd != nil {
-		slog.Debug("stopping llama server")
+		runnerLog.Debug("stopping llama server")
 		if err := s.cmd.Process.Kill(); err != nil {
 			return err
 		}
 		// if ProcessState is already populated, Wait already completed, no need to wait again
 		if s.cmd.ProcessState == nil {
-			slog.Debug("waiting for llama server to exit")
+			runnerLog.Debug("waiting for llama server to exit")
 			<-s.done
 		}
 
-		slog.Debug("llama server stopped")
+		runnerLog.Debug("llama server stopped")
 	}
 
 	return nil
@@ -1000,6 +1705,10 @@ func (s *llmServer) EstimatedTotal() uint64 {
 	return s.estimate.TotalSize
 }
 
+func (s *llmServer) Runtime() api.ProcessModelRuntime {
+	return s.runtime
+}
+
 func (s *llmServer) EstimatedVRAMByGPU(gpuID string) uint64 {
 	for i, gpu := range s.gpus {
 		if gpu.ID == gpuID {
@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/ml"
 	"golang.org/x/sync/semaphore"
 )
 
@@ -28,14 +29,22 @@ func TestLLMServerCompletionFormat(t *testing.T) {
 			Format:  []byte(format),
 		}, nil)
 
-		want := fmt.Sprintf("invalid format: %q; expected \"json\" or a valid JSON Schema", format)
+		want := fmt.Sprintf("invalid format: %q; expected \"json\", a valid JSON Schema object or a GBNF grammar", format)
 		if err == nil || !strings.Contains(err.Error(), want) {
 			t.Fatalf("err = %v; want %q", err, want)
 		}
 	}
 
 	checkInvalid("X")   // invalid format
-	checkInvalid(`"X"`) // invalid JSON Schema
+	checkInvalid(`"X"`) // neither JSON Schema nor grammar
+
+	err := s.Completion(ctx, CompletionRequest{
+		Options: new(api.Options),
+		Format:  []byte(`"root ::= answer"`),
+	}, nil)
+	if err == nil || err.Error() != "invalid GBNF grammar in format" {
+		t.Fatalf("err = %v; want invalid GBNF grammar", err)
+	}
 
 	cancel() // prevent further processing if request makes it past the format check
 
@@ -55,6 +64,9 @@ func TestLLMServerCompletionFormat(t *testing.T) {
 		// JSON
 		`"json"`,
 		`{"type":"object"}`,
+
+		// GBNF
+		`"root ::= \"yes\" | \"no\""`,
 	}
 	for _, valid := range valids {
 		err := s.Completion(ctx, CompletionRequest{
@@ -64,9 +76,35 @@ func TestLLMServerCompletionFormat(t *testing.T) {
 		checkValid(err)
 	}
 
-	err := s.Completion(ctx, CompletionRequest{
+	err = s.Completion(ctx, CompletionRequest{
 		Options: new(api.Options),
 		Format:  nil, // missing format
 	}, nil)
 	checkValid(err)
 }
+
+func TestSelfTestResults(t *testing.T) {
+	t.Cleanup(func() { selfTestResults = map[string]ml.SelfTestResult{} })
+
+	recordSelfTest([]ml.SelfTestResult{
+		{Device: "CUDA0", Passed: true, MaxError: 1e-4},
+		{Device: "CPU", Passed: true},
+	})
+	recordSelfTest([]ml.SelfTestResult{
+		{Device: "CUDA0", MaxError: 0.5, Failed: []string{"attention"}},
+	})
+
+	results := SelfTestResults()
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %v", results)
+	}
+
+	if results[0].Device != "CPU" || !results[0].Passed {
+		t.Errorf("unexpected result for CPU: %+v", results[0])
+	}
+
+	// the latest result of a device replaces earlier ones
+	if results[1].Device != "CUDA0" || results[1].Passed || results[1].Failed[0] != "attention" {
+		t.Errorf("unexpected result for CUDA0: %+v", results[1])
+	}
+}
@@ -27,6 +27,7 @@ var errorPrefixes = []string{
 	"\"ERR\"",
 	"error loading model",
 	"GGML_ASSERT",
+	"panic:",
 	"Deepseek2 does not support K-shift",
 }
 
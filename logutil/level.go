@@ -0,0 +1,216 @@
+package logutil
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+// Subsystems whose level can be set apart from the rest of the log. Their
+// records carry the subsystem attribute.
+const (
+	Scheduler = "scheduler"
+	Runner    = "runner"
+	Registry  = "registry"
+)
+
+// Subsystems lists the subsystems with levels of their own.
+var Subsystems = []string{Scheduler, Runner, Registry}
+
+const subsystemKey = "subsystem"
+
+var levels = struct {
+	sync.RWMutex
+	level      slog.Level
+	subsystems map[string]slog.Level
+}{subsystems: make(map[string]slog.Level)}
+
+// Level returns the level of subsystem, which is the default level unless
+// it was set with SetLevel.
+func Level(subsystem string) slog.Level {
+	levels.RLock()
+	defer levels.RUnlock()
+	if level, ok := levels.subsystems[subsystem]; ok {
+		return level
+	}
+
+	return levels.level
+}
+
+// Levels returns the default level and the levels of subsystems that were
+// set apart from it.
+func Levels() (slog.Level, map[string]slog.Level) {
+	levels.RLock()
+	defer levels.RUnlock()
+	subsystems := make(map[string]slog.Level, len(levels.subsystems))
+	for name, level := range levels.subsystems {
+		subsystems[name] = level
+	}
+
+	return levels.level, subsystems
+}
+
+// SetLevel sets the level of subsystem, or the default level if subsystem
+// is empty.
+func SetLevel(subsystem string, level slog.Level) error {
+	if subsystem != "" && !slices.Contains(Subsystems, subsystem) {
+		return fmt.Errorf("unknown log subsystem %q", subsystem)
+	}
+
+	levels.Lock()
+	defer levels.Unlock()
+	if subsystem == "" {
+		levels.level = level
+	} else {
+		levels.subsystems[subsystem] = level
+	}
+
+	return nil
+}
+
+// ResetLevel makes subsystem follow the default level again.
+func ResetLevel(subsystem string) error {
+	if !slices.Contains(Subsystems, subsystem) {
+		return fmt.Errorf("unknown log subsystem %q", subsystem)
+	}
+
+	levels.Lock()
+	defer levels.Unlock()
+	delete(levels.subsystems, subsystem)
+	return nil
+}
+
+// FormatLevels returns the levels in the form of OLLAMA_LOG_LEVEL, such as
+// "INFO,scheduler=DEBUG".
+func FormatLevels() string {
+	level, subsystems := Levels()
+	parts := []string{level.String()}
+	for _, name := range Subsystems {
+		if level, ok := subsystems[name]; ok {
+			parts = append(parts, name+"="+level.String())
+		}
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// setLevels sets the levels of spec, a default level followed by levels of
+// subsystems such as "info,scheduler=debug", each of which may be omitted.
+func setLevels(spec string) error {
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		subsystem, name, ok := strings.Cut(part, "=")
+		if !ok {
+			subsystem, name = "", part
+		}
+
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(name)); err != nil {
+			return fmt.Errorf("invalid log level %q", part)
+		}
+
+		if err := SetLevel(subsystem, level); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Setup makes the default logger write records to w in the format set by
+// OLLAMA_LOG_FORMAT at the levels set by OLLAMA_LOG_LEVEL, or at level if
+// it isn't set. The levels can be changed later with SetLevel. Processes
+// that make up a subsystem, such as runners, pass its name so that all of
+// their records are logged at its level.
+func Setup(w io.Writer, level slog.Level, subsystem string) error {
+	if err := SetLevel("", level); err != nil {
+		return err
+	}
+
+	if err := setLevels(envconfig.LogLevel()); err != nil {
+		return fmt.Errorf("OLLAMA_LOG_LEVEL: %w", err)
+	}
+
+	opts := &slog.HandlerOptions{
+		// records are filtered by the levels above before they get here
+		Level:     slog.Level(-100),
+		AddSource: true,
+		ReplaceAttr: func(_ []string, attr slog.Attr) slog.Attr {
+			if attr.Key == slog.SourceKey {
+				source := attr.Value.Any().(*slog.Source)
+				source.File = filepath.Base(source.File)
+			}
+
+			return attr
+		},
+	}
+
+	var h slog.Handler
+	switch format := envconfig.LogFormat(); format {
+	case "", "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("OLLAMA_LOG_FORMAT: invalid format %q, expected text or json", format)
+	}
+
+	logger := slog.New(NewHandler(h))
+	if subsystem != "" {
+		logger = logger.With(subsystemKey, subsystem)
+	}
+
+	slog.SetDefault(logger)
+	return nil
+}
+
+// Subsystem returns a logger for the subsystem name. It logs to the handler
+// of the default logger at the time of each record, so it can be created
+// before the default logger is set up.
+func Subsystem(name string) *slog.Logger {
+	return slog.New(&subsystemHandler{name: name})
+}
+
+type subsystemHandler struct {
+	name string
+
+	// with holds the attributes and groups added to the handler, which are
+	// applied to the default handler when a record is logged
+	with []func(slog.Handler) slog.Handler
+}
+
+func (h *subsystemHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= Level(h.name)
+}
+
+func (h *subsystemHandler) Handle(ctx context.Context, r slog.Record) error {
+	handler := slog.Default().Handler().WithAttrs([]slog.Attr{slog.String(subsystemKey, h.name)})
+	for _, with := range h.with {
+		handler = with(handler)
+	}
+
+	return handler.Handle(ctx, r)
+}
+
+func (h *subsystemHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &subsystemHandler{h.name, append(slices.Clip(h.with), func(handler slog.Handler) slog.Handler {
+		return handler.WithAttrs(attrs)
+	})}
+}
+
+func (h *subsystemHandler) WithGroup(name string) slog.Handler {
+	return &subsystemHandler{h.name, append(slices.Clip(h.with), func(handler slog.Handler) slog.Handler {
+		return handler.WithGroup(name)
+	})}
+}
@@ -0,0 +1,126 @@
+package logutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// resetLevels restores the levels when the test ends.
+func resetLevels(t *testing.T) {
+	t.Helper()
+	level, subsystems := Levels()
+	t.Cleanup(func() {
+		levels.Lock()
+		defer levels.Unlock()
+		levels.level, levels.subsystems = level, subsystems
+	})
+}
+
+func TestSetLevels(t *testing.T) {
+	cases := []struct {
+		spec   string
+		expect string
+		err    string
+	}{
+		{spec: "", expect: "INFO"},
+		{spec: "debug", expect: "DEBUG"},
+		{spec: "warn, scheduler=debug,registry=error", expect: "WARN,scheduler=DEBUG,registry=ERROR"},
+		{spec: "runner=debug", expect: "INFO,runner=DEBUG"},
+		{spec: "loud", err: `invalid log level "loud"`},
+		{spec: "scheduler=loud", err: `invalid log level "scheduler=loud"`},
+		{spec: "gpu=debug", err: `unknown log subsystem "gpu"`},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.spec, func(t *testing.T) {
+			resetLevels(t)
+			SetLevel("", slog.LevelInfo)
+			for _, name := range Subsystems {
+				ResetLevel(name)
+			}
+
+			err := setLevels(tt.spec)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := FormatLevels(); got != tt.expect {
+				t.Errorf("expected %q, got %q", tt.expect, got)
+			}
+		})
+	}
+}
+
+func TestSubsystem(t *testing.T) {
+	resetLevels(t)
+	SetLevel("", slog.LevelInfo)
+	SetLevel(Scheduler, slog.LevelDebug)
+
+	var b bytes.Buffer
+	defaultLogger := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+	slog.SetDefault(slog.New(NewHandler(slog.NewTextHandler(&b, &slog.HandlerOptions{Level: slog.Level(-100)}))))
+
+	// created before the default logger would be set up by a program
+	scheduler, registry := Subsystem(Scheduler).With("model", "m"), Subsystem(Registry)
+
+	slog.Debug("default")
+	scheduler.Debug("scheduler")
+	registry.Debug("registry")
+	registry.Info("registry info")
+
+	expect := []string{
+		"level=DEBUG msg=scheduler subsystem=scheduler model=m",
+		"level=INFO msg=\"registry info\" subsystem=registry",
+	}
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != len(expect) {
+		t.Fatalf("expected %d lines, got %q", len(expect), lines)
+	}
+
+	for i, line := range lines {
+		if !strings.HasSuffix(line, expect[i]) {
+			t.Errorf("expected %q, got %q", expect[i], line)
+		}
+	}
+}
+
+func TestSetup(t *testing.T) {
+	resetLevels(t)
+	defaultLogger := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	t.Setenv("OLLAMA_LOG_FORMAT", "json")
+	t.Setenv("OLLAMA_LOG_LEVEL", "warn,runner=debug")
+
+	var b bytes.Buffer
+	if err := Setup(&b, slog.LevelInfo, Runner); err != nil {
+		t.Fatal(err)
+	}
+
+	slog.Debug("loading", "layers", 3)
+	Subsystem(Scheduler).Info("hidden")
+
+	var record map[string]any
+	if err := json.Unmarshal(b.Bytes(), &record); err != nil {
+		t.Fatalf("expected a single json record, got %q: %v", b.String(), err)
+	}
+
+	if record["msg"] != "loading" || record["subsystem"] != Runner || record["layers"] != 3.0 {
+		t.Errorf("unexpected record %v", record)
+	}
+
+	t.Setenv("OLLAMA_LOG_FORMAT", "xml")
+	if err := Setup(&b, slog.LevelInfo, ""); err == nil {
+		t.Error("expected an error for an invalid format")
+	}
+}
@@ -0,0 +1,89 @@
+// Package logutil ties log lines of the server and runners to the requests
+// they were logged for.
+package logutil
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+)
+
+// RequestIDHeader is the header that carries the ID of a request from
+// clients to the server and from the server to runners.
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx that carries the request ID id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, if any.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// NewHandler returns a handler that passes records at or above the levels
+// set with SetLevel to h, adding the request ID of their context, as logged
+// by slog.InfoContext and the like.
+func NewHandler(h slog.Handler) slog.Handler {
+	return &handler{Handler: h}
+}
+
+type handler struct {
+	slog.Handler
+
+	// subsystem is the value of the subsystem attribute added to the
+	// handler, which selects its level
+	subsystem string
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= Level(h.subsystem)
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r = r.Clone()
+		r.AddAttrs(slog.String("request_id", id))
+	}
+
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	subsystem := h.subsystem
+	for _, attr := range attrs {
+		if attr.Key == subsystemKey {
+			subsystem = attr.Value.String()
+		}
+	}
+
+	return &handler{h.Handler.WithAttrs(attrs), subsystem}
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return &handler{h.Handler.WithGroup(name), h.subsystem}
+}
+
+// Middleware adds the request ID sent in the RequestIDHeader of requests to
+// their contexts.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := r.Header.Get(RequestIDHeader); id != "" {
+			r = r.WithContext(WithRequestID(r.Context(), id))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// SetRequestID sets the RequestIDHeader of r to the request ID of its
+// context, if any.
+func SetRequestID(r *http.Request) {
+	if id := RequestID(r.Context()); id != "" {
+		r.Header.Set(RequestIDHeader, id)
+	}
+}
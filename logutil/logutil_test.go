@@ -0,0 +1,61 @@
+package logutil
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	var b bytes.Buffer
+	logger := slog.New(NewHandler(slog.NewTextHandler(&b, nil))).With("component", "test")
+
+	logger.InfoContext(WithRequestID(context.Background(), "abc"), "with id")
+	logger.Info("without id")
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", lines)
+	}
+
+	if !strings.HasSuffix(lines[0], "component=test request_id=abc") {
+		t.Errorf("expected a request id, got %q", lines[0])
+	}
+
+	if strings.Contains(lines[1], "request_id") {
+		t.Errorf("expected no request id, got %q", lines[1])
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var got string
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = RequestID(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(RequestIDHeader, "abc")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got != "abc" {
+		t.Errorf("expected request id abc, got %q", got)
+	}
+}
+
+func TestSetRequestID(t *testing.T) {
+	req := httptest.NewRequestWithContext(WithRequestID(context.Background(), "abc"), http.MethodGet, "/", nil)
+	SetRequestID(req)
+	if id := req.Header.Get(RequestIDHeader); id != "abc" {
+		t.Errorf("expected request id abc, got %q", id)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	SetRequestID(req)
+	if _, ok := req.Header[RequestIDHeader]; ok {
+		t.Error("expected no request id header")
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"slices"
 	"strconv"
@@ -37,6 +39,82 @@ type BackendCacheConfig interface {
 	CacheConfig() CacheConfig
 }
 
+// BackendTensors should be implemented by backends that can list the names
+// of the tensors in the model file, so that tensors that a model doesn't
+// load can be reported.
+type BackendTensors interface {
+	TensorNames() []string
+}
+
+// BackendLoader should be implemented by backends that can return from
+// NewBackend before all weights have been read, as requested by
+// BackendParams.Progressive.
+type BackendLoader interface {
+	// WaitLoaded blocks until every weight has been loaded and returns
+	// any error encountered while reading them.
+	WaitLoaded(context.Context) error
+}
+
+// BackendImager should be implemented by backends that can save the weights
+// as they were placed on devices, so that a backend with the same placement
+// can restore them from BackendParams.Image instead of the model file.
+type BackendImager interface {
+	// SaveImage writes the weights to w once they have been loaded.
+	SaveImage(context.Context, io.Writer) error
+}
+
+// Trainer should be implemented by backends that can compute gradients and
+// update weights.
+type Trainer interface {
+	// NewTrainingContext returns a context whose graphs record the
+	// operations needed for back propagation.
+	NewTrainingContext() TrainingContext
+
+	// NewAdamW returns an optimizer that updates params, which must be
+	// F32 tensors that outlive the optimizer.
+	NewAdamW(params []Tensor, opts AdamWOptions) Optimizer
+}
+
+// TrainingContext is a Context used to compute a training loss
+type TrainingContext interface {
+	Context
+
+	// CrossEntropy returns the mean cross entropy loss between logits of
+	// shape [vocab, n] and the target index of each column. Columns with a
+	// negative target do not contribute to the loss.
+	CrossEntropy(logits Tensor, targets []int32) (Tensor, error)
+}
+
+// Optimizer updates trainable parameters from the gradient of a loss
+type Optimizer interface {
+	// Step computes loss, which must have been built in ctx, back
+	// propagates it and updates the parameters. It returns the value of
+	// the loss before the update.
+	Step(ctx TrainingContext, loss Tensor) float32
+
+	Close()
+}
+
+// AdamWOptions are the hyperparameters of the AdamW optimizer
+type AdamWOptions struct {
+	LearningRate float32
+	Beta1        float32
+	Beta2        float32
+	Epsilon      float32
+	WeightDecay  float32
+}
+
+// DefaultAdamWOptions returns the commonly used AdamW hyperparameters for
+// the given learning rate
+func DefaultAdamWOptions(lr float32) AdamWOptions {
+	return AdamWOptions{
+		LearningRate: lr,
+		Beta1:        0.9,
+		Beta2:        0.999,
+		Epsilon:      1e-8,
+	}
+}
+
 // CacheConfig controls optimizations (mostly backend-specific) that may transform
 // the output the cache to work better with specific kernels.
 type CacheConfig struct {
@@ -79,6 +157,25 @@ type BackendParams struct {
 
 	// FlashAttention indicates that we should use a fused flash attention kernel
 	FlashAttention bool
+
+	// Progressive returns from loading as soon as weights are allocated and
+	// reads them in the background, in layer order. Computation waits only
+	// for the weights it uses so the first batch can be processed while the
+	// remaining layers are still loading.
+	Progressive bool
+
+	// LoadBandwidth limits how fast weights are read from disk, in bytes per
+	// second. Zero means no limit.
+	LoadBandwidth uint64
+
+	// SelfTest checks the numerical results of each device against a
+	// reference implementation, as reported by SelfTester
+	SelfTest bool
+
+	// Image is the path of a restore image written by BackendImager. If
+	// the weights were placed the same way, they are read from it instead
+	// of the model file.
+	Image string
 }
 
 var backends = make(map[string]func(context.Context, *os.File, BackendParams) (Backend, error))
@@ -99,12 +196,22 @@ func NewBackend(ctx context.Context, f *os.File, params BackendParams) (Backend,
 	return nil, fmt.Errorf("unsupported backend")
 }
 
+// ErrNoMem is the error of a backend that was unable to allocate memory for
+// a tensor or a graph. Contexts panic with errors that match it, as Empty,
+// Zeros and Compute don't return errors, so that callers can recover from
+// running out of memory for a single computation.
+var ErrNoMem = errors.New("out of memory")
+
 type Context interface {
 	Empty(dtype DType, shape ...int) Tensor
 	Zeros(dtype DType, shape ...int) Tensor
 	FromFloatSlice(s []float32, shape ...int) (Tensor, error)
 	FromIntSlice(s []int32, shape ...int) (Tensor, error)
 
+	// FromBytes creates a tensor of dtype from its data as returned by
+	// [Tensor.Bytes]
+	FromBytes(dtype DType, s []byte, shape ...int) (Tensor, error)
+
 	Forward(...Tensor) Context
 	Compute(...Tensor)
 	MaxGraphNodes() int
@@ -134,11 +241,13 @@ type Tensor interface {
 	Mul(ctx Context, t2 Tensor) Tensor
 	Mulmat(ctx Context, t2 Tensor) Tensor
 	MulmatFullPrec(ctx Context, t2 Tensor) Tensor
+	MulmatID(ctx Context, t2, ids Tensor) Tensor
 
 	Softmax(ctx Context) Tensor
 	LayerNorm(ctx Context, weight, bias Tensor, eps float32) Tensor
 	RMSNorm(ctx Context, weight Tensor, eps float32) Tensor
 	Scale(ctx Context, s float64) Tensor
+	Clamp(ctx Context, min, max float32) Tensor
 
 	AvgPool2D(ctx Context, k, s int, p float32) Tensor
 	Conv2D(ctx Context, weight Tensor, s0, s1, p0, p1, d0, d1 int) Tensor
@@ -148,6 +257,8 @@ type Tensor interface {
 	Tanh(ctx Context) Tensor
 	GELU(ctx Context) Tensor
 	SILU(ctx Context) Tensor
+	RELU(ctx Context) Tensor
+	Sigmoid(ctx Context) Tensor
 
 	Reshape(ctx Context, shape ...int) Tensor
 	View(ctx Context, offset int, shape ...int) Tensor
@@ -162,6 +273,9 @@ type Tensor interface {
 	Concat(ctx Context, t2 Tensor, dim int) Tensor
 	Rows(ctx Context, t2 Tensor) Tensor
 	Copy(ctx Context, t2 Tensor) Tensor
+	Cast(ctx Context, dtype DType) Tensor
+
+	TopK(ctx Context, k int) Tensor
 }
 
 // ScaledDotProductAttention implements a fused attention
@@ -11,15 +11,12 @@ import "C"
 import (
 	"context"
 	"fmt"
-	"io"
 	"log/slog"
 	"maps"
 	"os"
-	"runtime"
 	"slices"
 	"strconv"
 	"strings"
-	"sync/atomic"
 	"unicode"
 	"unsafe"
 
@@ -27,7 +24,6 @@ import (
 	fs "github.com/ollama/ollama/fs/ggml"
 	"github.com/ollama/ollama/ml"
 	ggml "github.com/ollama/ollama/ml/backend/ggml/ggml/src"
-	"golang.org/x/sync/errgroup"
 )
 
 func devices() []*C.struct_ggml_backend_device {
@@ -58,6 +54,20 @@ type Backend struct {
 
 	// maxGraphNodes is the maximum allowed number of graph nodes in this scheduler
 	maxGraphNodes int
+
+	// loader reads the weights, possibly still in the background
+	loader *weightLoader
+
+	// weights are the contexts of the weights of each buffer type, in the
+	// order they are saved to a restore image
+	weights []weightBuffer
+
+	// modelSize is the size of the model file, which restore images are
+	// checked against
+	modelSize uint64
+
+	// selfTest holds the results of the self-tests of the devices
+	selfTest []ml.SelfTestResult
 }
 
 func New(ctx context.Context, r *os.File, params ml.BackendParams) (ml.Backend, error) {
@@ -299,64 +309,74 @@ func New(ctx context.Context, r *os.File, params ml.BackendParams) (ml.Backend,
 		}
 	}
 
-	var doneBytes atomic.Uint64
-	totalBytes := uint64(n) - meta.Tensors().Offset
-
-	g, ctx := errgroup.WithContext(ctx)
-	g.SetLimit(runtime.GOMAXPROCS(0))
-	for _, t := range meta.Tensors().Items() {
-		g.Go(func() error {
-			tts := make([]*C.struct_ggml_tensor, max(1, len(targets[t.Name])))
-			for i := range tts {
-				target := targets[t.Name][i]
-				if target == "" {
-					target = t.Name
-				}
-
-				tt, ok := tensors[target]
-				if !ok {
-					return fmt.Errorf("unassigned tensor: %s", t.Name)
-				}
+	l, err := newWeightLoader(meta.Tensors().Items(), blocks, func(t *fs.Tensor) ([]*C.struct_ggml_tensor, error) {
+		tts := make([]*C.struct_ggml_tensor, max(1, len(targets[t.Name])))
+		for i := range tts {
+			target := targets[t.Name][i]
+			if target == "" {
+				target = t.Name
+			}
 
-				tts[i] = tt
+			tt, ok := tensors[target]
+			if !ok {
+				return nil, fmt.Errorf("unassigned tensor: %s", t.Name)
 			}
 
-			sr := io.NewSectionReader(r, int64(meta.Tensors().Offset+t.Offset), int64(t.Size()))
-			bts := make([]byte, 128*format.KibiByte)
+			tts[i] = tt
+		}
 
-			var s uint64
-			for s < t.Size() {
-				n, err := io.ReadFull(sr, bts[:min(len(bts), int(t.Size()-s))])
-				if err != nil {
-					return err
-				}
+		return tts, nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-				for _, tt := range tts {
-					C.ggml_backend_tensor_set(tt, unsafe.Pointer(&bts[0]), C.size_t(s), C.size_t(n))
-				}
+	// the scheduler may copy weights held on the cpu to a gpu before the eval
+	// callback that waits for them runs, so weights can only be streamed in
+	// when every layer is computed on the device that holds it
+	progressive := params.Progressive && (len(gpus) == 0 || params.NumGPULayers == 0 || params.NumGPULayers > blocks)
+	if params.Progressive && !progressive {
+		slog.Info("progressive loading requires the model to be fully on the cpu or fully offloaded, loading synchronously")
+	}
 
-				s += uint64(n)
+	if params.LoadBandwidth > 0 {
+		l.throttle(params.LoadBandwidth)
+	}
 
-				if params.Progress != nil {
-					done := doneBytes.Add(uint64(n))
-					params.Progress(float32(done) / float32(totalBytes))
-				}
-			}
+	weights := sortWeightBuffers(ctxs)
 
-			return nil
-		})
+	var restored bool
+	if params.Image != "" {
+		if err := loadImage(ctx, params.Image, uint64(n), weights, params.Progress); err != nil {
+			slog.Warn("unable to restore weights from image, reading the model file", "image", params.Image, "error", err)
+		} else {
+			slog.Info("restored weights from image", "image", params.Image)
+			restored = true
+			progressive = false
+		}
 	}
 
-	// start a goroutine to cancel the errgroup if the parent context is done
-	go func() {
-		<-ctx.Done()
-		g.Go(func() error {
-			return ctx.Err()
-		})
-	}()
+	offset, total := meta.Tensors().Offset, uint64(n)-meta.Tensors().Offset
+	if restored {
+		l.skip()
+	} else if progressive {
+		// the caller may close r once we return so read from a separate handle
+		f, err := os.Open(r.Name())
+		if err != nil {
+			return nil, err
+		}
 
-	if err := g.Wait(); err != nil {
-		return nil, err
+		go func() {
+			defer close(l.done)
+			defer f.Close()
+			l.err = l.load(ctx, f, offset, total, params.Progress)
+		}()
+	} else {
+		if err := l.load(ctx, r, offset, total, params.Progress); err != nil {
+			return nil, err
+		}
+
+		close(l.done)
 	}
 
 	// map devices to backend buffer types so new tensors can be assigned to the correct device
@@ -388,20 +408,36 @@ func New(ctx context.Context, r *os.File, params ml.BackendParams) (ml.Backend,
 		}
 	}
 
+	var selfTests []ml.SelfTestResult
+	if params.SelfTest {
+		for _, b := range schedBackends {
+			selfTests = append(selfTests, selfTest(b))
+		}
+	}
+
 	maxGraphNodes := max(8192, len(meta.Tensors().Items())*5)
+	sched := C.ggml_backend_sched_new(
+		(*C.ggml_backend_t)(unsafe.Pointer(&schedBackends[0])),
+		(*C.ggml_backend_buffer_type_t)(unsafe.Pointer(&schedBufts[0])),
+		C.int(len(schedBackends)),
+		C.size_t(maxGraphNodes),
+		C._Bool(len(gpus) > 1 && slices.Contains(gpus, output.d)),
+	)
+
+	if progressive {
+		l.attach(sched)
+	}
+
 	return &Backend{
 		flashAttention: params.FlashAttention,
 		meta:           meta,
 		tensors:        tensors,
-		sched: C.ggml_backend_sched_new(
-			(*C.ggml_backend_t)(unsafe.Pointer(&schedBackends[0])),
-			(*C.ggml_backend_buffer_type_t)(unsafe.Pointer(&schedBufts[0])),
-			C.int(len(schedBackends)),
-			C.size_t(maxGraphNodes),
-			C._Bool(len(gpus) > 1 && slices.Contains(gpus, output.d)),
-		),
-		input:  deviceBufferTypes[input.d],
-		output: deviceBufferTypes[output.d],
+		loader:         l,
+		weights:        weights,
+		modelSize:      uint64(n),
+		sched:          sched,
+		input:          deviceBufferTypes[input.d],
+		output:         deviceBufferTypes[output.d],
 		layers: func() map[int]*C.struct_ggml_backend_buffer_type {
 			m := make(map[int]*C.struct_ggml_backend_buffer_type)
 			for i, layer := range layers {
@@ -410,6 +446,7 @@ func New(ctx context.Context, r *os.File, params ml.BackendParams) (ml.Backend,
 			return m
 		}(),
 		maxGraphNodes: maxGraphNodes,
+		selfTest:      selfTests,
 	}, nil
 }
 
@@ -417,6 +454,15 @@ func init() {
 	ml.RegisterBackend("ggml", New)
 }
 
+func (b *Backend) WaitLoaded(ctx context.Context) error {
+	select {
+	case <-b.loader.done:
+		return b.loader.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (b *Backend) Config() ml.Config {
 	return b.meta.KV()
 }
@@ -429,6 +475,10 @@ func (b *Backend) Get(name string) ml.Tensor {
 	return nil
 }
 
+func (b *Backend) TensorNames() []string {
+	return slices.Collect(maps.Keys(b.tensors))
+}
+
 func (b *Backend) NewContext() ml.Context {
 	return b.NewContextSize(b.maxGraphNodes)
 }
@@ -467,6 +517,9 @@ type Context struct {
 
 	// maxGraphNodes is the maximum allowed number of graph nodes in this context
 	maxGraphNodes int
+
+	// grads is set for training contexts, whose graphs record gradients
+	grads bool
 }
 
 func (c Context) Input() ml.Context {
@@ -476,6 +529,7 @@ func (c Context) Input() ml.Context {
 			ctx:           c.ctx,
 			buft:          c.b.input,
 			maxGraphNodes: c.maxGraphNodes,
+			grads:         c.grads,
 		}
 	}
 
@@ -489,6 +543,7 @@ func (c Context) Output() ml.Context {
 			ctx:           c.ctx,
 			buft:          c.b.output,
 			maxGraphNodes: c.maxGraphNodes,
+			grads:         c.grads,
 		}
 	}
 
@@ -502,6 +557,7 @@ func (c Context) Layer(i int) ml.Context {
 			ctx:           c.ctx,
 			buft:          buft,
 			maxGraphNodes: c.maxGraphNodes,
+			grads:         c.grads,
 		}
 	}
 
@@ -510,7 +566,7 @@ func (c Context) Layer(i int) ml.Context {
 
 func (c *Context) Forward(tensors ...ml.Tensor) ml.Context {
 	if c.graph == nil {
-		c.graph = C.ggml_new_graph_custom(c.ctx, C.size_t(c.maxGraphNodes), false)
+		c.graph = C.ggml_new_graph_custom(c.ctx, C.size_t(c.maxGraphNodes), C.bool(c.grads))
 	}
 
 	for _, tensor := range tensors {
@@ -521,8 +577,16 @@ func (c *Context) Forward(tensors ...ml.Tensor) ml.Context {
 }
 
 func (c Context) Compute(tensors ...ml.Tensor) {
-	C.ggml_backend_sched_graph_compute_async(c.b.sched, c.graph)
+	if l := c.b.loader; l.handle != nil && l.loaded() {
+		// every weight is available, stop checking nodes before computing them
+		l.detach(c.b.sched)
+	}
+
+	status := C.ggml_backend_sched_graph_compute_async(c.b.sched, c.graph)
 	C.ggml_backend_sched_reset(c.b.sched)
+	if status == C.GGML_STATUS_ALLOC_FAILED {
+		panic(fmt.Errorf("%w: unable to allocate the compute graph", ml.ErrNoMem))
+	}
 
 	needSync := true
 	sync := func() {
@@ -556,26 +620,29 @@ func pad(length, pad C.size_t) C.size_t {
 	return ((length + pad - 1) / pad) * pad
 }
 
-func (c Context) newTensor(dtype ml.DType, shape []int) ml.Tensor {
-	if c.buft == nil {
-		panic("set Input, Output, or Layer before creating tensors")
-	}
-
-	var cdtype uint32
+func ggmlDType(dtype ml.DType) uint32 {
 	switch dtype {
 	case ml.DTypeF32:
-		cdtype = C.GGML_TYPE_F32
+		return C.GGML_TYPE_F32
 	case ml.DTypeF16:
-		cdtype = C.GGML_TYPE_F16
+		return C.GGML_TYPE_F16
 	case ml.DTypeQ80:
-		cdtype = C.GGML_TYPE_Q8_0
+		return C.GGML_TYPE_Q8_0
 	case ml.DTypeQ40:
-		cdtype = C.GGML_TYPE_Q4_0
+		return C.GGML_TYPE_Q4_0
 	case ml.DTypeI32:
-		cdtype = C.GGML_TYPE_I32
+		return C.GGML_TYPE_I32
 	default:
 		panic("unsupported dtype")
 	}
+}
+
+func (c Context) newTensor(dtype ml.DType, shape []int) ml.Tensor {
+	if c.buft == nil {
+		panic("set Input, Output, or Layer before creating tensors")
+	}
+
+	cdtype := ggmlDType(dtype)
 
 	if len(shape) < 1 || shape[0] == 0 {
 		var shape C.int64_t = 0
@@ -593,6 +660,9 @@ func (c Context) newTensor(dtype ml.DType, shape []int) ml.Tensor {
 	t := C.ggml_new_tensor(c.ctx, cdtype, C.int(len(shape)), shapeToGGML(shape))
 	size := pad(C.ggml_backend_buft_get_alloc_size(c.buft, t), C.ggml_backend_buft_get_alignment(c.buft))
 	b := C.ggml_backend_buft_alloc_buffer(c.buft, size)
+	if b == nil {
+		panic(fmt.Errorf("%w: unable to allocate %s from %s", ml.ErrNoMem, format.HumanBytes2(uint64(size)), C.GoString(C.ggml_backend_buft_name(c.buft))))
+	}
 	C.ggml_backend_tensor_alloc(b, t, C.ggml_backend_buffer_get_base(b))
 	return &Tensor{b: c.b, t: t}
 }
@@ -651,6 +721,19 @@ func (c Context) FromIntSlice(s []int32, shape ...int) (ml.Tensor, error) {
 	return t, nil
 }
 
+func (c Context) FromBytes(dtype ml.DType, s []byte, shape ...int) (ml.Tensor, error) {
+	t := c.newTensor(dtype, shape)
+	if n := int(C.ggml_nbytes(t.(*Tensor).t)); n != len(s) {
+		return nil, fmt.Errorf("invalid size for shape %v: have %d bytes, want %d", shape, len(s), n)
+	}
+
+	if len(s) > 0 {
+		C.ggml_backend_tensor_set(t.(*Tensor).t, unsafe.Pointer(&s[0]), 0, C.ggml_nbytes(t.(*Tensor).t))
+	}
+
+	return t, nil
+}
+
 func (c *Context) Close() {
 	if c != nil {
 		C.ggml_free(c.ctx)
@@ -770,6 +853,16 @@ func (t *Tensor) Mulmat(ctx ml.Context, t2 ml.Tensor) ml.Tensor {
 	}
 }
 
+// MulmatID multiplies t2 by the matrices of t selected by ids, such as the
+// experts of a mixture of experts layer. t is [n, m, experts], t2 is
+// [n, used or 1, tokens] and ids is [used, tokens] of I32.
+func (t *Tensor) MulmatID(ctx ml.Context, t2, ids ml.Tensor) ml.Tensor {
+	return &Tensor{
+		b: t.b,
+		t: C.ggml_mul_mat_id(ctx.(*Context).ctx, t.t, t2.(*Tensor).t, ids.(*Tensor).t),
+	}
+}
+
 func (t *Tensor) MulmatFullPrec(ctx ml.Context, t2 ml.Tensor) ml.Tensor {
 	mul := C.ggml_mul_mat(ctx.(*Context).ctx, t.t, t2.(*Tensor).t)
 	C.ggml_mul_mat_set_prec(mul, C.GGML_PREC_F32)
@@ -822,6 +915,14 @@ func (t *Tensor) Rows(ctx ml.Context, t2 ml.Tensor) ml.Tensor {
 	}
 }
 
+// TopK returns the indices of the k largest values of each row of t.
+func (t *Tensor) TopK(ctx ml.Context, k int) ml.Tensor {
+	return &Tensor{
+		b: t.b,
+		t: C.ggml_top_k(ctx.(*Context).ctx, t.t, C.int(k)),
+	}
+}
+
 func (t *Tensor) Copy(ctx ml.Context, t2 ml.Tensor) ml.Tensor {
 	return &Tensor{
 		b: t.b,
@@ -829,6 +930,13 @@ func (t *Tensor) Copy(ctx ml.Context, t2 ml.Tensor) ml.Tensor {
 	}
 }
 
+func (t *Tensor) Cast(ctx ml.Context, dtype ml.DType) ml.Tensor {
+	return &Tensor{
+		b: t.b,
+		t: C.ggml_cast(ctx.(*Context).ctx, t.t, ggmlDType(dtype)),
+	}
+}
+
 func (t *Tensor) Reshape(ctx ml.Context, shape ...int) ml.Tensor {
 	switch len(shape) {
 	case 1:
@@ -863,6 +971,13 @@ func (t *Tensor) Scale(ctx ml.Context, s float64) ml.Tensor {
 	}
 }
 
+func (t *Tensor) Clamp(ctx ml.Context, min, max float32) ml.Tensor {
+	return &Tensor{
+		b: t.b,
+		t: C.ggml_clamp(ctx.(*Context).ctx, t.t, C.float(min), C.float(max)),
+	}
+}
+
 func (t *Tensor) Softmax(ctx ml.Context) ml.Tensor {
 	return &Tensor{
 		b: t.b,
@@ -871,6 +986,14 @@ func (t *Tensor) Softmax(ctx ml.Context) ml.Tensor {
 }
 
 func (t *Tensor) Tanh(ctx ml.Context) ml.Tensor {
+	if ctx.(*Context).grads {
+		// back propagation does not support in place operations
+		return &Tensor{
+			b: t.b,
+			t: C.ggml_tanh(ctx.(*Context).ctx, t.t),
+		}
+	}
+
 	return &Tensor{
 		b: t.b,
 		t: C.ggml_tanh_inplace(ctx.(*Context).ctx, t.t),
@@ -959,6 +1082,14 @@ func (t *Tensor) RoPE(ctx ml.Context, positionIDs, ropeFactors ml.Tensor, ropeDi
 }
 
 func (t *Tensor) GELU(ctx ml.Context) ml.Tensor {
+	if ctx.(*Context).grads {
+		// back propagation does not support in place operations
+		return &Tensor{
+			b: t.b,
+			t: C.ggml_gelu(ctx.(*Context).ctx, t.t),
+		}
+	}
+
 	return &Tensor{
 		b: t.b,
 		t: C.ggml_gelu_inplace(ctx.(*Context).ctx, t.t),
@@ -966,12 +1097,50 @@ func (t *Tensor) GELU(ctx ml.Context) ml.Tensor {
 }
 
 func (t *Tensor) SILU(ctx ml.Context) ml.Tensor {
+	if ctx.(*Context).grads {
+		// back propagation does not support in place operations
+		return &Tensor{
+			b: t.b,
+			t: C.ggml_silu(ctx.(*Context).ctx, t.t),
+		}
+	}
+
 	return &Tensor{
 		b: t.b,
 		t: C.ggml_silu_inplace(ctx.(*Context).ctx, t.t),
 	}
 }
 
+func (t *Tensor) RELU(ctx ml.Context) ml.Tensor {
+	if ctx.(*Context).grads {
+		// back propagation does not support in place operations
+		return &Tensor{
+			b: t.b,
+			t: C.ggml_relu(ctx.(*Context).ctx, t.t),
+		}
+	}
+
+	return &Tensor{
+		b: t.b,
+		t: C.ggml_relu_inplace(ctx.(*Context).ctx, t.t),
+	}
+}
+
+func (t *Tensor) Sigmoid(ctx ml.Context) ml.Tensor {
+	if ctx.(*Context).grads {
+		// back propagation does not support in place operations
+		return &Tensor{
+			b: t.b,
+			t: C.ggml_sigmoid(ctx.(*Context).ctx, t.t),
+		}
+	}
+
+	return &Tensor{
+		b: t.b,
+		t: C.ggml_sigmoid_inplace(ctx.(*Context).ctx, t.t),
+	}
+}
+
 func (t *Tensor) Conv2D(ctx ml.Context, t2 ml.Tensor, s0, s1, p0, p1, d0, d1 int) ml.Tensor {
 	return &Tensor{
 		b: t.b,
@@ -1009,7 +1178,8 @@ func (t *Tensor) ScaledDotProductAttention(ctx ml.Context, key, value, mask ml.T
 	query := t.Permute(ctx, 0, 2, 1, 3)
 	key = key.Permute(ctx, 0, 2, 1, 3)
 
-	if t.b.flashAttention {
+	// flash attention has no backward pass
+	if t.b.flashAttention && !ctx.(*Context).grads {
 		value = value.Permute(ctx, 0, 2, 1, 3)
 
 		kqv := C.ggml_flash_attn_ext(ctx.(*Context).ctx, query.(*Tensor).t, key.(*Tensor).t, value.(*Tensor).t, kqMask, C.float(scale), 0, 0)
@@ -7310,10 +7310,11 @@ static void ggml_compute_forward_rms_norm_back_f32(
                 float * dx = (float *) ((char *) dst->data + i01*nb1 + i02*nb2 + i03*nb3);
 
                 // dx[i00] = (x*(-sum_xdz/sum_eps) + dz) / sqrtf(mean_eps)
-                ggml_vec_cpy_f32  (ne00, dx, x);
-                // ggml_vec_scale_f32(ne00, dx, -mean_xdz/mean_eps);
-                ggml_vec_scale_f32(ne00, dx, (float)(-sum_xdz)/sum_eps);
-                ggml_vec_acc_f32  (ne00, dx, dz);
+                // dx may share memory with dz when computed in place
+                if (dx != dz) {
+                    ggml_vec_cpy_f32(ne00, dx, dz);
+                }
+                ggml_vec_mad_f32  (ne00, dx, x, (float)(-sum_xdz)/sum_eps);
                 ggml_vec_scale_f32(ne00, dx, rrms);
             }
         }
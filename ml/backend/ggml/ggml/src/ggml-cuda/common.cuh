@@ -699,6 +699,8 @@ struct ggml_cuda_graph {
     bool disable_due_to_too_many_updates = false;
     bool disable_due_to_failed_graph_capture = false;
     int number_consecutive_updates = 0;
+    // number of consecutive graphs that didn't need an update while disabled due to too many updates
+    int number_consecutive_stable = 0;
     std::vector<ggml_graph_node_properties> ggml_graph_properties;
     std::vector<char **> updated_kernel_arg;
 #endif
@@ -2417,6 +2417,10 @@ static void ggml_backend_cuda_synchronize(ggml_backend_t backend) {
 }
 
 #ifdef USE_CUDA_GRAPH
+// number of consecutive graphs that must match the previous one before CUDA graphs that were disabled due to
+// too many updates are captured again
+#define GGML_CUDA_GRAPH_STABLE_CALLS 16
+
 static bool check_node_graph_compatibility_and_refresh_copy_ops(ggml_backend_cuda_context * cuda_ctx, ggml_cgraph * cgraph,
     std::vector<void *> & ggml_cuda_cpy_fn_ptrs, bool use_cuda_graph) {
 
@@ -2725,12 +2729,10 @@ static enum ggml_status ggml_backend_cuda_graph_compute(ggml_backend_t backend,
         }
     }
 
-    // Disable CUDA graphs in presence of env var, old GPU, use-case which is changing too rapidly,
-    // or previous graph capture failure.
+    // Disable CUDA graphs in presence of env var, old GPU, or previous graph capture failure.
     // Also disable for multi-gpu for now. TO DO investigate
     if (disable_cuda_graphs_due_to_env
         || cuda_ctx->cuda_graph->disable_due_to_gpu_arch
-        || cuda_ctx->cuda_graph->disable_due_to_too_many_updates
         || cuda_ctx->cuda_graph->disable_due_to_failed_graph_capture) {
         use_cuda_graph = false;
     }
@@ -2741,6 +2743,28 @@ static enum ggml_status ggml_backend_cuda_graph_compute(ggml_backend_t backend,
         use_cuda_graph = check_node_graph_compatibility_and_refresh_copy_ops(cuda_ctx, cgraph,
                              ggml_cuda_cpy_fn_ptrs, use_cuda_graph);
 
+        // A use-case which is changing too rapidly disables CUDA graphs until the graph is stable again,
+        // such as when the sequences of a batch settle into decoding one token each, and then recaptures it.
+        if (cuda_ctx->cuda_graph->disable_due_to_too_many_updates) {
+            if (use_cuda_graph && !cuda_graph_update_required) {
+                cuda_ctx->cuda_graph->number_consecutive_stable++;
+            } else {
+                cuda_ctx->cuda_graph->number_consecutive_stable = 0;
+            }
+
+            if (cuda_ctx->cuda_graph->number_consecutive_stable >= GGML_CUDA_GRAPH_STABLE_CALLS) {
+                cuda_ctx->cuda_graph->disable_due_to_too_many_updates = false;
+                cuda_ctx->cuda_graph->number_consecutive_updates = 0;
+                cuda_ctx->cuda_graph->number_consecutive_stable = 0;
+                cuda_graph_update_required = true; // the captured graph is stale
+#ifndef NDEBUG
+                GGML_LOG_DEBUG("%s: re-enabling CUDA graphs as the graph is stable\n", __func__);
+#endif
+            } else {
+                use_cuda_graph = false;
+            }
+        }
+
         // Disable CUDA graphs (from the next token) if the use-case is demanding too many consecutive graph updates.
         if (use_cuda_graph && cuda_graph_update_required) {
             cuda_ctx->cuda_graph->number_consecutive_updates++;
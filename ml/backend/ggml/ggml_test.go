@@ -0,0 +1,233 @@
+package ggml
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	fs "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/ml"
+)
+
+func TestLoadOrder(t *testing.T) {
+	cases := map[string]int{
+		"token_embd.weight":    -1,
+		"position_embd.weight": -1,
+		"blk.0.attn_q.weight":  0,
+		"blk.11.ffn_up.weight": 11,
+		"output_norm.weight":   32,
+		"output.weight":        32,
+		"v.blk.0.attn_q.bias":  32,
+		"rope_freqs.weight":    32,
+	}
+
+	for name, expect := range cases {
+		if actual := loadOrder(name, 32); actual != expect {
+			t.Errorf("loadOrder(%q) = %d, want %d", name, actual, expect)
+		}
+	}
+}
+
+func writeTestModel(t *testing.T, blocks int) string {
+	t.Helper()
+
+	values := func(v float32) *bytes.Reader {
+		var b bytes.Buffer
+		binary.Write(&b, binary.LittleEndian, slices.Repeat([]float32{v}, 4))
+		return bytes.NewReader(b.Bytes())
+	}
+
+	tensors := []fs.Tensor{
+		{Name: "output.weight", Kind: 0, Shape: []uint64{4}, WriterTo: values(0)},
+		{Name: "token_embd.weight", Kind: 0, Shape: []uint64{4}, WriterTo: values(1)},
+	}
+
+	for i := range blocks {
+		tensors = append(tensors, fs.Tensor{Name: "blk." + string(rune('0'+i)) + ".weight", Kind: 0, Shape: []uint64{4}, WriterTo: values(float32(i + 1))})
+	}
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "model.gguf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := fs.WriteGGUF(f, fs.KV{
+		"general.architecture": "test",
+		"test.block_count":     uint32(blocks),
+	}, tensors); err != nil {
+		t.Fatal(err)
+	}
+
+	return f.Name()
+}
+
+func TestProgressiveLoad(t *testing.T) {
+	const blocks = 3
+	name := writeTestModel(t, blocks)
+
+	for _, progressive := range []bool{false, true} {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := New(context.Background(), f, ml.BackendParams{Progressive: progressive})
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx := b.NewContext()
+		x := b.Get("token_embd.weight")
+		for i := range blocks {
+			x = x.Add(ctx, b.Get("blk."+string(rune('0'+i))+".weight"))
+		}
+
+		ctx.Forward(x).Compute(x)
+
+		// 1 + 1 + 2 + 3
+		if diff := slices.Compare(x.Floats(), []float32{7, 7, 7, 7}); diff != 0 {
+			t.Errorf("progressive=%v: unexpected result %v", progressive, x.Floats())
+		}
+
+		if err := b.(ml.BackendLoader).WaitLoaded(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx.Close()
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	f, err := os.Open(writeTestModel(t, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	b, err := New(context.Background(), f, ml.BackendParams{SelfTest: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := b.(ml.SelfTester).SelfTest()
+	if len(results) == 0 {
+		t.Fatal("expected self-test results")
+	}
+
+	for _, r := range results {
+		if !r.Passed || r.Error != "" {
+			t.Errorf("%s failed its self-test: %+v", r.Device, r)
+		}
+
+		if r.MaxError == 0 || r.MaxError > ml.SelfTestTolerance {
+			t.Errorf("%s: unexpected error %v", r.Device, r.MaxError)
+		}
+	}
+}
+
+func TestSelfTestError(t *testing.T) {
+	expect := []float32{1, -2, 4}
+	cases := []struct {
+		got    []float32
+		expect float64
+	}{
+		{[]float32{1, -2, 4}, 0},
+		{[]float32{1, -2, 3}, 0.25},
+		{[]float32{1, float32(math.NaN()), 4}, math.MaxFloat32},
+		{[]float32{1, float32(math.Inf(1)), 4}, math.MaxFloat32},
+		{[]float32{1, -2}, math.MaxFloat32},
+	}
+
+	for _, tt := range cases {
+		if got := ml.SelfTestError(tt.got, expect); got != tt.expect {
+			t.Errorf("SelfTestError(%v) = %v, expected %v", tt.got, got, tt.expect)
+		}
+	}
+}
+
+func TestImage(t *testing.T) {
+	const blocks = 3
+	name := writeTestModel(t, blocks)
+
+	load := func(image string) ml.Backend {
+		t.Helper()
+
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		b, err := New(context.Background(), f, ml.BackendParams{Image: image})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return b
+	}
+
+	sum := func(b ml.Backend) []float32 {
+		t.Helper()
+
+		ctx := b.NewContext()
+		defer ctx.Close()
+
+		x := b.Get("token_embd.weight")
+		for i := range blocks {
+			x = x.Add(ctx, b.Get("blk."+string(rune('0'+i))+".weight"))
+		}
+
+		ctx.Forward(x).Compute(x)
+		return x.Floats()
+	}
+
+	var image bytes.Buffer
+	if err := load("").(ml.BackendImager).SaveImage(context.Background(), &image); err != nil {
+		t.Fatal(err)
+	}
+
+	// weights restored from the image are read from it rather than the
+	// model file, which still holds 2s for blk.1
+	var two, five bytes.Buffer
+	binary.Write(&two, binary.LittleEndian, slices.Repeat([]float32{2}, 4))
+	binary.Write(&five, binary.LittleEndian, slices.Repeat([]float32{5}, 4))
+	if bytes.Count(image.Bytes(), two.Bytes()) != 1 {
+		t.Fatal("expected the image to hold the weights of blk.1 once")
+	}
+
+	path := filepath.Join(t.TempDir(), "image")
+	if err := os.WriteFile(path, bytes.Replace(image.Bytes(), two.Bytes(), five.Bytes(), 1), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 1 + 1 + 5 + 3
+	if diff := slices.Compare(sum(load(path)), []float32{10, 10, 10, 10}); diff != 0 {
+		t.Errorf("expected the weights of the image, got %v", sum(load(path)))
+	}
+
+	// images that can't be restored fall back to the model file
+	for name, bts := range map[string][]byte{
+		"truncated": image.Bytes()[:image.Len()-4],
+		"mismatch":  bytes.Replace(image.Bytes(), []byte(`"blk.1.weight"`), []byte(`"blk.7.weight"`), 1),
+	} {
+		path := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(path, bts, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := slices.Compare(sum(load(path)), []float32{7, 7, 7, 7}); diff != 0 {
+			t.Errorf("%s: expected the weights of the model file, got %v", name, sum(load(path)))
+		}
+	}
+
+	if diff := slices.Compare(sum(load(filepath.Join(t.TempDir(), "missing"))), []float32{7, 7, 7, 7}); diff != 0 {
+		t.Error("expected a missing image to fall back to the model file")
+	}
+}
@@ -0,0 +1,233 @@
+package ggml
+
+// #include "ggml.h"
+// #include "ggml-backend.h"
+import "C"
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/ollama/ollama/format"
+)
+
+// imageVersion changes when restore images written by older versions can no
+// longer be read.
+const imageVersion = 1
+
+// imageChunkSize is how much of a tensor is copied between a device and a
+// restore image at a time.
+const imageChunkSize = 16 * format.MebiByte
+
+var errImageMismatch = errors.New("weights were placed differently when the image was saved")
+
+// weightBuffer is a context of weight tensors and the buffer type they were
+// allocated in.
+type weightBuffer struct {
+	ctx *C.struct_ggml_context
+	bt  *C.struct_ggml_backend_buffer_type
+}
+
+// sortWeightBuffers orders weight buffers by the name of their buffer type,
+// so that backends with the same placement save and restore them in the
+// same order.
+func sortWeightBuffers(ctxs map[*C.struct_ggml_backend_buffer_type]*C.struct_ggml_context) []weightBuffer {
+	var wbs []weightBuffer
+	for bt, ctx := range ctxs {
+		if C.ggml_get_first_tensor(ctx) != nil {
+			wbs = append(wbs, weightBuffer{ctx: ctx, bt: bt})
+		}
+	}
+
+	slices.SortFunc(wbs, func(a, b weightBuffer) int {
+		return cmp.Compare(C.GoString(C.ggml_backend_buft_name(a.bt)), C.GoString(C.ggml_backend_buft_name(b.bt)))
+	})
+
+	return wbs
+}
+
+// imageLayout is the header of a restore image. It records the weights of
+// each buffer type in the order their data follows the header, which is
+// compared with the placement of the backend restoring them.
+type imageLayout struct {
+	Version   int           `json:"version"`
+	ModelSize uint64        `json:"model_size"`
+	Buffers   []imageBuffer `json:"buffers"`
+}
+
+type imageBuffer struct {
+	Type    string        `json:"type"`
+	Tensors []imageTensor `json:"tensors"`
+}
+
+type imageTensor struct {
+	Name string `json:"name"`
+	Size uint64 `json:"size"`
+}
+
+func newImageLayout(modelSize uint64, wbs []weightBuffer) imageLayout {
+	layout := imageLayout{Version: imageVersion, ModelSize: modelSize}
+	for _, wb := range wbs {
+		b := imageBuffer{Type: C.GoString(C.ggml_backend_buft_name(wb.bt))}
+		for t := C.ggml_get_first_tensor(wb.ctx); t != nil; t = C.ggml_get_next_tensor(wb.ctx, t) {
+			b.Tensors = append(b.Tensors, imageTensor{Name: C.GoString(C.ggml_get_name(t)), Size: uint64(C.ggml_nbytes(t))})
+		}
+
+		layout.Buffers = append(layout.Buffers, b)
+	}
+
+	return layout
+}
+
+// size returns the number of bytes of weights in b
+func (b imageBuffer) size() (n uint64) {
+	for _, t := range b.Tensors {
+		n += t.Size
+	}
+
+	return n
+}
+
+// SaveImage writes a restore image of the weights, which is a header
+// describing how they were placed followed by the data of each tensor as it
+// is held on its device.
+func (b *Backend) SaveImage(ctx context.Context, w io.Writer) error {
+	if err := b.WaitLoaded(ctx); err != nil {
+		return err
+	}
+
+	header, err := json.Marshal(newImageLayout(b.modelSize, b.weights))
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriterSize(w, imageChunkSize)
+	if err := binary.Write(bw, binary.LittleEndian, uint64(len(header))); err != nil {
+		return err
+	}
+
+	if _, err := bw.Write(header); err != nil {
+		return err
+	}
+
+	bts := make([]byte, imageChunkSize)
+	for _, wb := range b.weights {
+		for t := C.ggml_get_first_tensor(wb.ctx); t != nil; t = C.ggml_get_next_tensor(wb.ctx, t) {
+			size := uint64(C.ggml_nbytes(t))
+			for s := uint64(0); s < size; {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+
+				n := min(uint64(len(bts)), size-s)
+				C.ggml_backend_tensor_get(t, unsafe.Pointer(&bts[0]), C.size_t(s), C.size_t(n))
+				if _, err := bw.Write(bts[:n]); err != nil {
+					return err
+				}
+
+				s += n
+			}
+		}
+	}
+
+	return bw.Flush()
+}
+
+// loadImage reads the weights of wbs from the restore image at path, which
+// must have been saved by a backend that placed them the same way. Buffers
+// are read in parallel and the fraction of bytes read is reported to
+// progress.
+func loadImage(ctx context.Context, path string, modelSize uint64, wbs []weightBuffer, progress func(float32)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var n uint64
+	if err := binary.Read(f, binary.LittleEndian, &n); err != nil {
+		return err
+	}
+
+	layout := newImageLayout(modelSize, wbs)
+	expect, err := json.Marshal(layout)
+	if err != nil {
+		return err
+	}
+
+	if n != uint64(len(expect)) {
+		return errImageMismatch
+	}
+
+	header := make([]byte, n)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return err
+	}
+
+	if !bytes.Equal(header, expect) {
+		return errImageMismatch
+	}
+
+	var total uint64
+	for _, b := range layout.Buffers {
+		total += b.size()
+	}
+
+	// a truncated image is found before any weight is overwritten
+	if fi, err := f.Stat(); err != nil {
+		return err
+	} else if uint64(fi.Size()) != 8+n+total {
+		return fmt.Errorf("image is %d bytes, expected %d", fi.Size(), 8+n+total)
+	}
+
+	var doneBytes atomic.Uint64
+
+	g, ctx := errgroup.WithContext(ctx)
+	offset := 8 + n
+	for i, wb := range wbs {
+		sr := io.NewSectionReader(f, int64(offset), int64(layout.Buffers[i].size()))
+		offset += layout.Buffers[i].size()
+
+		g.Go(func() error {
+			bts := make([]byte, imageChunkSize)
+			for t := C.ggml_get_first_tensor(wb.ctx); t != nil; t = C.ggml_get_next_tensor(wb.ctx, t) {
+				size := uint64(C.ggml_nbytes(t))
+				for s := uint64(0); s < size; {
+					if err := ctx.Err(); err != nil {
+						return err
+					}
+
+					n, err := io.ReadFull(sr, bts[:min(uint64(len(bts)), size-s)])
+					if err != nil {
+						return err
+					}
+
+					C.ggml_backend_tensor_set(t, unsafe.Pointer(&bts[0]), C.size_t(s), C.size_t(n))
+					s += uint64(n)
+
+					if progress != nil {
+						done := doneBytes.Add(uint64(n))
+						progress(float32(done) / float32(total))
+					}
+				}
+			}
+
+			return nil
+		})
+	}
+
+	return g.Wait()
+}
@@ -0,0 +1,227 @@
+package ggml
+
+// #include <stdlib.h>
+// #include <stdint.h>
+// #include "ggml.h"
+// #include "ggml-backend.h"
+// extern bool waitForWeights(struct ggml_tensor *t, bool ask, void *user_data);
+import "C"
+
+import (
+	"cmp"
+	"context"
+	"io"
+	"runtime"
+	"runtime/cgo"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
+
+	"github.com/ollama/ollama/format"
+	fs "github.com/ollama/ollama/fs/ggml"
+)
+
+// readSize is how much of a tensor is read from the model file at a time.
+const readSize = 128 * format.KibiByte
+
+// weightLoad is a tensor in the model file and the backend tensors it is
+// read into.
+type weightLoad struct {
+	source  *fs.Tensor
+	targets []*C.struct_ggml_tensor
+	loaded  chan struct{}
+}
+
+// weightLoader reads weights from the model file and tracks which of them
+// are ready so computation can start before loading has finished.
+type weightLoader struct {
+	loads []weightLoad
+
+	// pending maps each backend tensor to a channel that is closed once its
+	// data has been loaded
+	pending map[*C.struct_ggml_tensor]chan struct{}
+
+	done chan struct{}
+	err  error
+
+	// limit, if set, throttles how fast weights are read
+	limit *rate.Limiter
+
+	// handle is C memory holding a cgo.Handle to the loader, passed to the
+	// scheduler's eval callback
+	handle *C.uintptr_t
+}
+
+// loadOrder ranks a tensor by when it is first needed in a forward pass:
+// embeddings first, then repeating layers in order, then everything else.
+func loadOrder(name string, blocks int) int {
+	switch {
+	case strings.HasPrefix(name, "token_embd."), strings.HasPrefix(name, "position_embd."),
+		strings.HasPrefix(name, "token_types."), strings.HasPrefix(name, "token_norm_embd."):
+		return -1
+	case strings.HasPrefix(name, "blk."):
+		if i, err := strconv.Atoi(strings.Split(name, ".")[1]); err == nil {
+			return i
+		}
+	}
+
+	return blocks
+}
+
+func newWeightLoader(items []*fs.Tensor, blocks int, targets func(*fs.Tensor) ([]*C.struct_ggml_tensor, error)) (*weightLoader, error) {
+	items = slices.Clone(items)
+	slices.SortStableFunc(items, func(a, b *fs.Tensor) int {
+		return cmp.Compare(loadOrder(a.Name, blocks), loadOrder(b.Name, blocks))
+	})
+
+	l := weightLoader{
+		pending: make(map[*C.struct_ggml_tensor]chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	for _, t := range items {
+		tts, err := targets(t)
+		if err != nil {
+			return nil, err
+		}
+
+		loaded := make(chan struct{})
+		for _, tt := range tts {
+			l.pending[tt] = loaded
+		}
+
+		l.loads = append(l.loads, weightLoad{source: t, targets: tts, loaded: loaded})
+	}
+
+	return &l, nil
+}
+
+// load reads every weight from r, starting at offset, and reports the
+// fraction of bytes read to progress.
+func (l *weightLoader) load(ctx context.Context, r io.ReaderAt, offset, total uint64, progress func(float32)) error {
+	var doneBytes atomic.Uint64
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(runtime.GOMAXPROCS(0))
+	for _, w := range l.loads {
+		g.Go(func() error {
+			defer close(w.loaded)
+
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			sr := io.NewSectionReader(r, int64(offset+w.source.Offset), int64(w.source.Size()))
+			bts := make([]byte, readSize)
+
+			var s uint64
+			for s < w.source.Size() {
+				n := min(len(bts), int(w.source.Size()-s))
+				if l.limit != nil {
+					if err := l.limit.WaitN(ctx, n); err != nil {
+						return err
+					}
+				}
+
+				n, err := io.ReadFull(sr, bts[:n])
+				if err != nil {
+					return err
+				}
+
+				for _, tt := range w.targets {
+					C.ggml_backend_tensor_set(tt, unsafe.Pointer(&bts[0]), C.size_t(s), C.size_t(n))
+				}
+
+				s += uint64(n)
+
+				if progress != nil {
+					done := doneBytes.Add(uint64(n))
+					progress(float32(done) / float32(total))
+				}
+			}
+
+			return nil
+		})
+	}
+
+	return g.Wait()
+}
+
+// skip marks every weight as loaded without reading it, once the weights
+// have been restored from an image.
+func (l *weightLoader) skip() {
+	for _, w := range l.loads {
+		close(w.loaded)
+	}
+
+	close(l.done)
+}
+
+// throttle limits reading weights to bandwidth bytes per second.
+func (l *weightLoader) throttle(bandwidth uint64) {
+	// allow bursts of at least one read
+	l.limit = rate.NewLimiter(rate.Limit(bandwidth), max(int(bandwidth), readSize))
+}
+
+// loaded reports whether background loading has finished.
+func (l *weightLoader) loaded() bool {
+	select {
+	case <-l.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// attach installs an eval callback on sched that holds back each node until
+// the weights it reads have been loaded.
+func (l *weightLoader) attach(sched *C.struct_ggml_backend_sched) {
+	l.handle = (*C.uintptr_t)(C.malloc(C.sizeof_uintptr_t))
+	*l.handle = C.uintptr_t(cgo.NewHandle(l))
+	C.ggml_backend_sched_set_eval_callback(sched, C.ggml_backend_sched_eval_callback(C.waitForWeights), unsafe.Pointer(l.handle))
+}
+
+// detach removes the eval callback once loading has finished. It must not be
+// called while sched is computing a graph.
+func (l *weightLoader) detach(sched *C.struct_ggml_backend_sched) {
+	C.ggml_backend_sched_set_eval_callback(sched, nil, nil)
+	cgo.Handle(*l.handle).Delete()
+	C.free(unsafe.Pointer(l.handle))
+	l.handle = nil
+}
+
+// waitForWeights is the scheduler's eval callback. Before computing a range
+// of nodes, the scheduler asks about the nodes in order until one ends the
+// range. Nodes that read a weight block until it is loaded, holding back the
+// nodes before them in the range, and end the range there. Ranges are
+// computed as soon as the weights they read are loaded, so the first layers
+// are computed while the weights of later ones are still being read. A
+// weight that failed to load is not waited for; the error is reported by
+// WaitLoaded.
+//
+//export waitForWeights
+func waitForWeights(t *C.struct_ggml_tensor, ask C.bool, userData unsafe.Pointer) C.bool {
+	if !ask {
+		return true
+	}
+
+	l := cgo.Handle(*(*C.uintptr_t)(userData)).Value().(*weightLoader)
+
+	var need bool
+	for _, src := range t.src {
+		for ; src != nil; src = src.view_src {
+			if loaded, ok := l.pending[src]; ok {
+				<-loaded
+				need = true
+				break
+			}
+		}
+	}
+
+	return C.bool(need)
+}
@@ -0,0 +1,129 @@
+package ggml
+
+// #include "ggml.h"
+// #include "ggml-alloc.h"
+// #include "ggml-backend.h"
+import "C"
+
+import (
+	"fmt"
+	"math"
+	"unsafe"
+
+	"github.com/ollama/ollama/ml"
+)
+
+// dimensions of the self-test, small enough to take a few milliseconds but
+// large enough to use the tiled kernels of GPUs
+const (
+	selfTestK  = 128
+	selfTestM  = 64
+	selfTestN  = 32
+	selfTestNK = 64
+)
+
+// selfTest checks that matrix multiplications in full and half precision
+// and attention computed by backend match the reference implementation.
+func selfTest(backend C.ggml_backend_t) ml.SelfTestResult {
+	result := ml.SelfTestResult{Device: C.GoString(C.ggml_backend_name(backend))}
+
+	ctx := C.ggml_init(C.struct_ggml_init_params{
+		mem_size: 16*C.ggml_tensor_overhead() + C.ggml_graph_overhead(),
+		no_alloc: true,
+	})
+	defer C.ggml_free(ctx)
+
+	newTensor := func(typ uint32, ne0, ne1 int) *C.struct_ggml_tensor {
+		return C.ggml_new_tensor_2d(ctx, typ, C.int64_t(ne0), C.int64_t(ne1))
+	}
+
+	a := ml.SelfTestData(selfTestK*selfTestM, 1)
+	b := ml.SelfTestData(selfTestK*selfTestN, 2)
+	q := ml.SelfTestData(selfTestK*selfTestN, 3)
+	k := ml.SelfTestData(selfTestK*selfTestNK, 4)
+	vt := ml.SelfTestData(selfTestNK*selfTestK, 5)
+	scale := 1 / math.Sqrt(selfTestK)
+
+	ta := newTensor(C.GGML_TYPE_F32, selfTestK, selfTestM)
+	ta16 := newTensor(C.GGML_TYPE_F16, selfTestK, selfTestM)
+	tb := newTensor(C.GGML_TYPE_F32, selfTestK, selfTestN)
+	tq := newTensor(C.GGML_TYPE_F32, selfTestK, selfTestN)
+	tk := newTensor(C.GGML_TYPE_F32, selfTestK, selfTestNK)
+	tvt := newTensor(C.GGML_TYPE_F32, selfTestNK, selfTestK)
+
+	kq := C.ggml_soft_max_ext(ctx, C.ggml_mul_mat(ctx, tk, tq), nil, C.float(scale), 0)
+
+	mulmat := ml.ReferenceMulmat(a, b, selfTestK)
+	checks := []struct {
+		name   string
+		t      *C.struct_ggml_tensor
+		expect []float32
+	}{
+		{"mulmat_f32", C.ggml_mul_mat(ctx, ta, tb), mulmat},
+		{"mulmat_f16", C.ggml_mul_mat(ctx, ta16, tb), mulmat},
+		{"attention", C.ggml_mul_mat(ctx, tvt, kq), ml.ReferenceAttention(q, k, vt, selfTestK, scale)},
+	}
+
+	graph := C.ggml_new_graph(ctx)
+	for i := range checks {
+		// operations a device doesn't support would run on the cpu when a
+		// model is evaluated, so they aren't part of its test
+		if !C.ggml_backend_supports_op(backend, checks[i].t) || !C.ggml_backend_supports_op(backend, kq) {
+			checks[i].t = nil
+			continue
+		}
+
+		C.ggml_build_forward_expand(graph, checks[i].t)
+	}
+
+	buf := C.ggml_backend_alloc_ctx_tensors(ctx, backend)
+	if buf == nil {
+		result.Error = "failed to allocate self-test tensors"
+		return result
+	}
+	defer C.ggml_backend_buffer_free(buf)
+
+	set := func(t *C.struct_ggml_tensor, s []float32) {
+		C.ggml_backend_tensor_set(t, unsafe.Pointer(&s[0]), 0, C.ggml_nbytes(t))
+	}
+
+	set(ta, a)
+	set(tb, b)
+	set(tq, q)
+	set(tk, k)
+	set(tvt, vt)
+
+	a16 := make([]uint16, len(a))
+	C.ggml_fp32_to_fp16_row((*C.float)(&a[0]), (*C.ggml_fp16_t)(&a16[0]), C.int64_t(len(a)))
+	C.ggml_backend_tensor_set(ta16, unsafe.Pointer(&a16[0]), 0, C.ggml_nbytes(ta16))
+
+	if status := C.ggml_backend_graph_compute(backend, graph); status != C.GGML_STATUS_SUCCESS {
+		result.Error = fmt.Sprintf("self-test failed to compute: status %d", status)
+		return result
+	}
+
+	result.Passed = true
+	for _, check := range checks {
+		if check.t == nil {
+			continue
+		}
+
+		got := make([]float32, C.ggml_nelements(check.t))
+		C.ggml_backend_tensor_get(check.t, unsafe.Pointer(&got[0]), 0, C.ggml_nbytes(check.t))
+
+		err := ml.SelfTestError(got, check.expect)
+		result.MaxError = max(result.MaxError, err)
+		if err > ml.SelfTestTolerance {
+			result.Passed = false
+			result.Failed = append(result.Failed, check.name)
+		}
+	}
+
+	return result
+}
+
+// SelfTest returns the results of the self-tests of the devices of the
+// backend, which ran when it was created if BackendParams.SelfTest was set.
+func (b *Backend) SelfTest() []ml.SelfTestResult {
+	return b.selfTest
+}
@@ -0,0 +1,186 @@
+package ggml
+
+// #include "ggml.h"
+// #include "ggml-backend.h"
+// #include "ggml-cpu.h"
+import "C"
+
+import (
+	"errors"
+	"math"
+	"unsafe"
+
+	"github.com/ollama/ollama/ml"
+)
+
+func (b *Backend) NewTrainingContext() ml.TrainingContext {
+	n := b.maxGraphNodes
+	return &Context{
+		b:             b,
+		maxGraphNodes: n,
+		grads:         true,
+		ctx: C.ggml_init(C.struct_ggml_init_params{
+			// the backward pass creates roughly as many tensors as the forward pass
+			mem_size: 2*C.size_t(n)*C.ggml_tensor_overhead() + C.ggml_graph_overhead_custom(C.size_t(n), true),
+			no_alloc: true,
+		}),
+	}
+}
+
+func (c *Context) CrossEntropy(logits ml.Tensor, targets []int32) (ml.Tensor, error) {
+	vocab, n := logits.Dim(0), logits.Dim(1)
+	if len(targets) != n {
+		return nil, errors.New("number of targets does not match logits")
+	}
+
+	var columns []int32
+	for i, target := range targets {
+		if target < 0 {
+			continue
+		} else if int(target) >= vocab {
+			return nil, errors.New("target is out of range")
+		}
+
+		columns = append(columns, int32(i))
+	}
+
+	if len(columns) == 0 {
+		return nil, errors.New("no targets")
+	}
+
+	labels := make([]float32, vocab*len(columns))
+	for i, column := range columns {
+		labels[i*vocab+int(targets[column])] = 1
+	}
+
+	// ggml expects every column to be a probability distribution, so only
+	// keep the ones that have a target
+	if len(columns) < n {
+		rows, err := c.Input().FromIntSlice(columns, len(columns))
+		if err != nil {
+			return nil, err
+		}
+
+		logits = logits.Rows(c, rows)
+	}
+
+	t, err := c.Input().FromFloatSlice(labels, vocab, len(columns))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tensor{
+		b: c.b,
+		t: C.ggml_cross_entropy_loss(c.ctx, logits.(*Tensor).t, t.(*Tensor).t),
+	}, nil
+}
+
+type adamW struct {
+	opts ml.AdamWOptions
+
+	// ctx holds the first and second moments of each parameter
+	ctx    *C.struct_ggml_context
+	params []*Tensor
+	m, v   []*C.struct_ggml_tensor
+
+	buffers []*C.struct_ggml_backend_buffer
+
+	// steps is the number of updates applied so far
+	steps int
+}
+
+func (b *Backend) NewAdamW(params []ml.Tensor, opts ml.AdamWOptions) ml.Optimizer {
+	o := adamW{
+		opts: opts,
+		ctx: C.ggml_init(C.struct_ggml_init_params{
+			mem_size: 2 * C.size_t(len(params)) * C.ggml_tensor_overhead(),
+			no_alloc: true,
+		}),
+	}
+
+	for _, p := range params {
+		t := p.(*Tensor).t
+		if t._type != C.GGML_TYPE_F32 {
+			panic("trainable parameters must be F32")
+		}
+
+		// params must be flagged before they are added to a graph
+		C.ggml_set_param(o.ctx, t)
+		o.params = append(o.params, p.(*Tensor))
+
+		// keep the moments next to the parameter
+		buft := C.ggml_backend_buffer_get_type(t.buffer)
+		for _, moments := range []*[]*C.struct_ggml_tensor{&o.m, &o.v} {
+			m := C.ggml_dup_tensor(o.ctx, t)
+			o.buffers = append(o.buffers, alloc(buft, m))
+			C.ggml_set_zero(m)
+			*moments = append(*moments, m)
+		}
+	}
+
+	return &o
+}
+
+// alloc allocates t in a new buffer of type buft
+func alloc(buft *C.struct_ggml_backend_buffer_type, t *C.struct_ggml_tensor) *C.struct_ggml_backend_buffer {
+	size := pad(C.ggml_backend_buft_get_alloc_size(buft, t), C.ggml_backend_buft_get_alignment(buft))
+	b := C.ggml_backend_buft_alloc_buffer(buft, size)
+	C.ggml_backend_tensor_alloc(b, t, C.ggml_backend_buffer_get_base(b))
+	return b
+}
+
+func (o *adamW) Step(ctx ml.TrainingContext, loss ml.Tensor) float32 {
+	c := ctx.(*Context)
+	l := loss.(*Tensor)
+
+	C.ggml_set_loss(l.t)
+	C.ggml_set_output(l.t)
+	c.Forward(loss)
+
+	C.ggml_build_backward_expand(c.ctx, c.ctx, c.graph, false)
+
+	// the gradient of the loss with respect to itself seeds back propagation
+	one := float32(1)
+	seed := C.ggml_graph_get_grad_acc(c.graph, l.t)
+	buf := alloc(C.ggml_backend_cpu_buffer_type(), seed)
+	defer C.ggml_backend_buffer_free(buf)
+	C.ggml_backend_tensor_set(seed, unsafe.Pointer(&one), 0, C.size_t(unsafe.Sizeof(one)))
+
+	o.steps++
+	params, err := c.Input().FromFloatSlice([]float32{
+		o.opts.LearningRate,
+		o.opts.Beta1,
+		o.opts.Beta2,
+		o.opts.Epsilon,
+		o.opts.WeightDecay,
+		1 / (1 - float32(math.Pow(float64(o.opts.Beta1), float64(o.steps)))),
+		1 / (1 - float32(math.Pow(float64(o.opts.Beta2), float64(o.steps)))),
+	}, 7)
+	if err != nil {
+		panic(err)
+	}
+
+	outputs := []ml.Tensor{loss}
+	for i, p := range o.params {
+		grad := C.ggml_graph_get_grad(c.graph, p.t)
+		if grad == nil {
+			// the loss does not depend on this parameter
+			continue
+		}
+
+		C.ggml_build_forward_expand(c.graph, C.ggml_opt_step_adamw(c.ctx, p.t, grad, o.m[i], o.v[i], params.(*Tensor).t))
+		outputs = append(outputs, p)
+	}
+
+	// updated parameters can be read back after the step
+	c.Compute(outputs...)
+	return loss.Floats()[0]
+}
+
+func (o *adamW) Close() {
+	for _, b := range o.buffers {
+		C.ggml_backend_buffer_free(b)
+	}
+
+	C.ggml_free(o.ctx)
+}
@@ -0,0 +1,152 @@
+package ggml
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/ollama/ollama/ml"
+)
+
+func TestTrain(t *testing.T) {
+	f, err := os.Open(writeTestModel(t, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := New(context.Background(), f, ml.BackendParams{NumThreads: 1})
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := b.NewContext()
+	defer ctx.Close()
+
+	// a linear layer with 3 inputs and 4 outputs
+	w := ctx.Layer(0).Zeros(ml.DTypeF32, 3, 4)
+
+	opt := b.(ml.Trainer).NewAdamW([]ml.Tensor{w}, ml.DefaultAdamWOptions(0.1))
+	defer opt.Close()
+
+	step := func() float32 {
+		ctx := b.(ml.Trainer).NewTrainingContext()
+		defer ctx.Close()
+
+		x, err := ctx.Input().FromFloatSlice([]float32{1, 0, 0, 0, 1, 0, 0, 0, 1}, 3, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the last column is not part of the loss
+		loss, err := ctx.CrossEntropy(w.Mulmat(ctx, x), []int32{1, 3, -1})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return opt.Step(ctx, loss)
+	}
+
+	first := step()
+	for range 50 {
+		step()
+	}
+	last := step()
+
+	// with zero weights every output is equally likely
+	if first < 1.38 || first > 1.39 {
+		t.Errorf("initial loss = %v, want ln(4)", first)
+	}
+
+	if last > 0.1 {
+		t.Errorf("loss did not decrease: %v -> %v", first, last)
+	}
+
+	// the column that does not contribute to the loss is left untouched
+	if weights := w.Floats(); weights[2] != 0 || weights[5] != 0 || weights[8] != 0 || weights[11] != 0 {
+		t.Errorf("unexpected update of unused weights: %v", weights)
+	}
+}
+
+func TestCrossEntropyNoTargets(t *testing.T) {
+	f, err := os.Open(writeTestModel(t, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := New(context.Background(), f, ml.BackendParams{NumThreads: 1})
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := b.(ml.Trainer).NewTrainingContext()
+	defer ctx.Close()
+
+	logits := ctx.Input().Zeros(ml.DTypeF32, 4, 2)
+	if _, err := ctx.CrossEntropy(logits, []int32{-1, -1}); err == nil {
+		t.Error("expected error")
+	}
+
+	if _, err := ctx.CrossEntropy(logits, []int32{0}); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestTrainRMSNorm(t *testing.T) {
+	f, err := os.Open(writeTestModel(t, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := New(context.Background(), f, ml.BackendParams{NumThreads: 1})
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := b.NewContext()
+	defer ctx.Close()
+
+	x, err := ctx.Layer(0).FromFloatSlice([]float32{1, -2, 3, 0.5, -1, 2, 0.25, 1}, 4, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opt := b.(ml.Trainer).NewAdamW([]ml.Tensor{x}, ml.DefaultAdamWOptions(0.1))
+	defer opt.Close()
+
+	step := func() float32 {
+		ctx := b.(ml.Trainer).NewTrainingContext()
+		defer ctx.Close()
+
+		norm, err := ctx.Input().FromFloatSlice([]float32{1, 1, 1, 1}, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w, err := ctx.Input().FromFloatSlice([]float32{1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0}, 4, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		loss, err := ctx.CrossEntropy(w.Mulmat(ctx, x.RMSNorm(ctx, norm, 1e-5)), []int32{0, 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return opt.Step(ctx, loss)
+	}
+
+	first := step()
+	for range 50 {
+		step()
+	}
+	last := step()
+
+	// the output of RMSNorm does not depend on the scale of its input so
+	// the loss only decreases if the gradient changes its direction
+	if last > first/2 {
+		t.Errorf("loss did not decrease: %v -> %v", first, last)
+	}
+}
@@ -2,6 +2,7 @@ package nn
 
 import (
 	"fmt"
+	"math"
 
 	"github.com/ollama/ollama/kvcache"
 	"github.com/ollama/ollama/ml"
@@ -68,3 +69,159 @@ func Attention(ctx ml.Context, query, key, value ml.Tensor, scale float64, cache
 		return kqv.Permute(ctx, 0, 2, 1, 3).Contiguous(ctx)
 	}
 }
+
+// BidirectionalMask returns a mask for attention over a batch without a cache,
+// such as in encoder-only models. Each input attends to every input of its own
+// sequence, in either direction, but not to other sequences in the batch.
+//
+// sequences is the sequence of each input of the batch and the mask is of
+// shape batch size, batch size.
+func BidirectionalMask(ctx ml.Context, sequences []int) (ml.Tensor, error) {
+	mask := make([]float32, len(sequences)*len(sequences))
+	for i := range sequences {
+		for j := range sequences {
+			if sequences[i] != sequences[j] {
+				mask[i*len(sequences)+j] = float32(math.Inf(-1))
+			}
+		}
+	}
+
+	return ctx.Input().FromFloatSlice(mask, len(sequences), len(sequences))
+}
+
+// SelfAttention is the attention block shared by most decoder-only
+// transformers: linear projections of the queries, keys and values, which
+// may have biases, optional normalization of the queries and keys, rotary
+// embeddings and grouped-query attention over the cache.
+type SelfAttention struct {
+	Query  *Linear `gguf:"attn_q"`
+	Key    *Linear `gguf:"attn_k"`
+	Value  *Linear `gguf:"attn_v"`
+	Output *Linear `gguf:"attn_output"`
+
+	// QueryNorm and KeyNorm are only applied if the model has them
+	QueryNorm *QKNorm `gguf:"attn_q_norm"`
+	KeyNorm   *QKNorm `gguf:"attn_k_norm"`
+
+	// RopeFactors scales the frequencies of the rotary embeddings of
+	// models extended to long contexts, such as Llama 3.1
+	RopeFactors ml.Tensor `gguf:"rope_freqs.weight"`
+}
+
+// SelfAttentionOptions configures a [SelfAttention], so that architectures
+// which only differ in the shape of their heads or their rotary embeddings
+// can share it.
+type SelfAttentionOptions struct {
+	// HeadDim is the size of each head, which isn't necessarily the
+	// hidden size divided by the number of heads
+	HeadDim int
+
+	// NumKVHeads is less than NumHeads for grouped-query attention and 1
+	// for multi-query attention
+	NumHeads, NumKVHeads int
+
+	// RopeDim is the number of values at the start of each head that are
+	// rotated, all of them if 0
+	RopeDim uint32
+
+	// RopeType is [RopeTypeNorm] or [RopeTypeNeox]
+	RopeType            uint32
+	RopeBase, RopeScale float32
+
+	// Scale multiplies the attention logits, 1/√HeadDim if 0
+	Scale float64
+
+	// NormEps is the epsilon of QueryNorm and KeyNorm, which normalize
+	// each head separately if NormPerHead is set and all of the heads
+	// together otherwise. They are RMS norms unless LayerNorm is set, as in
+	// Command R+.
+	NormEps     float32
+	NormPerHead bool
+	LayerNorm   bool
+}
+
+// Types of rotary embeddings, as passed to [ml.Tensor.RoPE]
+const (
+	// RopeTypeNorm rotates pairs of adjacent values, as in the original llama
+	RopeTypeNorm uint32 = 0
+
+	// RopeTypeNeox rotates pairs of values from the two halves of each head,
+	// as in GPT-NeoX
+	RopeTypeNeox uint32 = 2
+)
+
+// ParseRopeType returns the type of rotary embeddings named by the rope.type
+// metadata of a model, "norm" or "neox".
+func ParseRopeType(s string) (uint32, error) {
+	switch s {
+	case "norm":
+		return RopeTypeNorm, nil
+	case "neox":
+		return RopeTypeNeox, nil
+	default:
+		return 0, fmt.Errorf("unsupported rope type %q", s)
+	}
+}
+
+// RoPE applies the rotary embeddings of the options to t, of shape
+// [HeadDim, heads, batch]. Models also use it to shift the keys of their
+// cache.
+func (o *SelfAttentionOptions) RoPE(ctx ml.Context, t, positions, factors ml.Tensor) ml.Tensor {
+	ropeDim := o.RopeDim
+	if ropeDim == 0 {
+		ropeDim = uint32(o.HeadDim)
+	}
+
+	return t.RoPE(ctx, positions, factors, ropeDim, o.RopeType, o.RopeBase, o.RopeScale)
+}
+
+// QKNorm normalizes the queries or keys of a [SelfAttention] as set by
+// [SelfAttentionOptions]. The bias is only used by layer norms.
+type QKNorm struct {
+	Weight ml.Tensor `gguf:"weight"`
+	Bias   ml.Tensor `gguf:"bias"`
+}
+
+func (n *QKNorm) Forward(ctx ml.Context, t ml.Tensor, opts *SelfAttentionOptions) ml.Tensor {
+	if opts.LayerNorm {
+		return t.LayerNorm(ctx, n.Weight, n.Bias, opts.NormEps)
+	}
+
+	return t.RMSNorm(ctx, n.Weight, opts.NormEps)
+}
+
+func (sa *SelfAttention) Forward(ctx ml.Context, hiddenState, positions ml.Tensor, cache kvcache.Cache, opts *SelfAttentionOptions) ml.Tensor {
+	batchSize := hiddenState.Dim(1)
+
+	project := func(proj *Linear, norm *QKNorm, numHeads int) ml.Tensor {
+		t := proj.Forward(ctx, hiddenState)
+		if norm != nil && !opts.NormPerHead {
+			t = norm.Forward(ctx, t, opts)
+		}
+
+		t = t.Reshape(ctx, opts.HeadDim, numHeads, batchSize)
+		if norm != nil && opts.NormPerHead {
+			t = norm.Forward(ctx, t, opts)
+		}
+
+		return t
+	}
+
+	q := project(sa.Query, sa.QueryNorm, opts.NumHeads)
+	q = opts.RoPE(ctx, q, positions, sa.RopeFactors)
+
+	k := project(sa.Key, sa.KeyNorm, opts.NumKVHeads)
+	k = opts.RoPE(ctx, k, positions, sa.RopeFactors)
+
+	v := project(sa.Value, nil, opts.NumKVHeads)
+
+	scale := opts.Scale
+	if scale == 0 {
+		scale = 1 / math.Sqrt(float64(opts.HeadDim))
+	}
+
+	kqv := Attention(ctx, q, k, v, scale, cache)
+	kqv = kqv.Reshape(ctx, opts.HeadDim*opts.NumHeads, batchSize)
+
+	return sa.Output.Forward(ctx, kqv)
+}
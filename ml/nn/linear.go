@@ -5,10 +5,27 @@ import "github.com/ollama/ollama/ml"
 type Linear struct {
 	Weight ml.Tensor `gguf:"weight"`
 	Bias   ml.Tensor `gguf:"bias"`
+
+	// LoRA is an optional low rank adapter added to the layer
+	LoRA *LoRA `gguf:"-"`
+}
+
+// LoRA is a low rank adapter for a Linear layer. It adds Scale * B(A(x)) to
+// the output of the layer, where A has shape [in, rank] and B has shape
+// [rank, out].
+type LoRA struct {
+	A, B  ml.Tensor
+	Scale float32
 }
 
 func (m *Linear) Forward(ctx ml.Context, t ml.Tensor) ml.Tensor {
+	x := t
+
 	t = m.Weight.Mulmat(ctx, t)
+	if m.LoRA != nil {
+		t = t.Add(ctx, m.LoRA.B.Mulmat(ctx, m.LoRA.A.Mulmat(ctx, x)).Scale(ctx, float64(m.LoRA.Scale)))
+	}
+
 	if m.Bias != nil {
 		t = t.Add(ctx, m.Bias)
 	}
@@ -0,0 +1,76 @@
+package nn
+
+import "github.com/ollama/ollama/ml"
+
+// MoE is a sparse mixture of SwiGLU experts. A router picks the experts
+// with the highest probabilities for each token, whose outputs are summed
+// weighted by those probabilities. Some models, such as Qwen2-MoE, also
+// have shared experts that process every token.
+type MoE struct {
+	Router *Linear `gguf:"ffn_gate_inp"`
+
+	// Up, Gate and Down hold the weights of all experts, stacked along
+	// their outermost dimension
+	Up   ml.Tensor `gguf:"ffn_up_exps.weight"`
+	Gate ml.Tensor `gguf:"ffn_gate_exps.weight"`
+	Down ml.Tensor `gguf:"ffn_down_exps.weight"`
+
+	// SharedUp, SharedGate and SharedDown are the shared experts, if any,
+	// and SharedRouter optionally gates their output with a sigmoid
+	SharedUp     *Linear `gguf:"ffn_up_shexp"`
+	SharedGate   *Linear `gguf:"ffn_gate_shexp"`
+	SharedDown   *Linear `gguf:"ffn_down_shexp"`
+	SharedRouter *Linear `gguf:"ffn_gate_inp_shexp"`
+}
+
+// MoEOptions configures a [MoE].
+type MoEOptions struct {
+	NumExperts, NumExpertsUsed int
+
+	// NormTopK renormalizes the probabilities of the selected experts so
+	// that they sum to 1, as Mixtral does, instead of weighting them by
+	// their probabilities over all experts
+	NormTopK bool
+}
+
+func (moe *MoE) Forward(ctx ml.Context, hiddenState ml.Tensor, opts *MoEOptions) ml.Tensor {
+	hiddenSize, batchSize := hiddenState.Dim(0), hiddenState.Dim(1)
+
+	logits := moe.Router.Forward(ctx, hiddenState)
+	probs := logits.Softmax(ctx)
+	experts := probs.TopK(ctx, opts.NumExpertsUsed)
+
+	var weights ml.Tensor
+	if opts.NormTopK {
+		// the softmax of the selected logits is their renormalized probabilities
+		weights = logits.Reshape(ctx, 1, opts.NumExperts, batchSize).Rows(ctx, experts)
+		weights = weights.Reshape(ctx, opts.NumExpertsUsed, batchSize).Softmax(ctx)
+		weights = weights.Reshape(ctx, 1, opts.NumExpertsUsed, batchSize)
+	} else {
+		weights = probs.Reshape(ctx, 1, opts.NumExperts, batchSize).Rows(ctx, experts)
+	}
+
+	x := hiddenState.Reshape(ctx, hiddenSize, 1, batchSize)
+	up := moe.Up.MulmatID(ctx, x, experts)
+	x = moe.Gate.MulmatID(ctx, x, experts).SILU(ctx).Mul(ctx, up)
+
+	x = moe.Down.MulmatID(ctx, x, experts)
+	x = x.Mul(ctx, weights)
+
+	out := x.View(ctx, 0, hiddenSize, x.Stride(2), batchSize)
+	for i := 1; i < opts.NumExpertsUsed; i++ {
+		out = out.Add(ctx, x.View(ctx, i*x.Stride(1), hiddenSize, x.Stride(2), batchSize))
+	}
+
+	if moe.SharedUp != nil {
+		shared := moe.SharedGate.Forward(ctx, hiddenState).SILU(ctx).Mul(ctx, moe.SharedUp.Forward(ctx, hiddenState))
+		shared = moe.SharedDown.Forward(ctx, shared)
+		if moe.SharedRouter != nil {
+			shared = shared.Mul(ctx, moe.SharedRouter.Forward(ctx, hiddenState).Sigmoid(ctx))
+		}
+
+		out = out.Add(ctx, shared)
+	}
+
+	return out
+}
@@ -0,0 +1,72 @@
+package nn
+
+import (
+	"github.com/ollama/ollama/ml"
+)
+
+// Pooling is how the hidden states of the inputs of a sequence are combined
+// into an embedding of the whole sequence. The values match the pooling
+// types of gguf files.
+type Pooling uint32
+
+const (
+	// PoolingNone uses the hidden state of each output itself
+	PoolingNone Pooling = iota
+	PoolingMean
+	PoolingCLS
+	PoolingLast
+)
+
+// Forward pools the hidden states of a batch, with shape [hidden size, batch
+// size], into an embedding for each output, with shape [hidden size, outputs].
+// The embedding of an output combines the inputs of the batch from its
+// sequence, so every input of the sequence must be in the batch.
+func (p Pooling) Forward(ctx ml.Context, hiddenState ml.Tensor, sequences []int, outputs []int32) (ml.Tensor, error) {
+	if p == PoolingNone {
+		o, err := ctx.Input().FromIntSlice(outputs, len(outputs))
+		if err != nil {
+			return nil, err
+		}
+
+		return hiddenState.Rows(ctx, o), nil
+	}
+
+	// weights of each input of the batch in the embedding of each output
+	weights := make([]float32, len(sequences)*len(outputs))
+	for i, output := range outputs {
+		w := weights[i*len(sequences) : (i+1)*len(sequences)]
+		seq := sequences[output]
+
+		switch p {
+		case PoolingMean:
+			var n int
+			for j := range sequences {
+				if sequences[j] == seq {
+					n++
+				}
+			}
+
+			for j := range sequences {
+				if sequences[j] == seq {
+					w[j] = 1 / float32(n)
+				}
+			}
+		case PoolingCLS:
+			for j := range sequences {
+				if sequences[j] == seq {
+					w[j] = 1
+					break
+				}
+			}
+		case PoolingLast:
+			w[output] = 1
+		}
+	}
+
+	t, err := ctx.Input().FromFloatSlice(weights, len(sequences), len(outputs))
+	if err != nil {
+		return nil, err
+	}
+
+	return hiddenState.Permute(ctx, 1, 0, 2, 3).Contiguous(ctx).Mulmat(ctx, t), nil
+}
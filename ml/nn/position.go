@@ -0,0 +1,61 @@
+package nn
+
+import (
+	"math"
+
+	"github.com/ollama/ollama/ml"
+)
+
+// RelativePositionBias is the learned bias of attention scores used by T5,
+// which depends on the distance from the query to the key. Distances are
+// grouped into buckets, one per distance for short distances and then
+// logarithmically larger up to maxDistance.
+type RelativePositionBias struct {
+	Weight ml.Tensor `gguf:"weight"`
+}
+
+// Forward returns the bias for each key at keyPositions from each query at
+// queryPositions, with shape [len(keyPositions), len(queryPositions), heads]
+// to be added to the scores of attention. Bidirectional biases distinguish
+// keys before and after the query, otherwise keys after the query share
+// a bucket with the query.
+func (m *RelativePositionBias) Forward(ctx ml.Context, queryPositions, keyPositions []int32, bidirectional bool, maxDistance int) (ml.Tensor, error) {
+	numBuckets := m.Weight.Dim(1)
+
+	buckets := make([]int32, len(queryPositions)*len(keyPositions))
+	for i, q := range queryPositions {
+		for j, k := range keyPositions {
+			buckets[i*len(keyPositions)+j] = relativePositionBucket(k-q, bidirectional, numBuckets, maxDistance)
+		}
+	}
+
+	t, err := ctx.Input().FromIntSlice(buckets, len(buckets))
+	if err != nil {
+		return nil, err
+	}
+
+	bias := m.Weight.Rows(ctx, t)
+	bias = bias.Reshape(ctx, bias.Dim(0), len(keyPositions), len(queryPositions))
+	return bias.Permute(ctx, 2, 0, 1, 3).Contiguous(ctx), nil
+}
+
+func relativePositionBucket(distance int32, bidirectional bool, numBuckets, maxDistance int) int32 {
+	var bucket int32
+	if bidirectional {
+		numBuckets /= 2
+		if distance > 0 {
+			bucket = int32(numBuckets)
+		}
+		distance = max(distance, -distance)
+	} else {
+		distance = max(-distance, 0)
+	}
+
+	maxExact := int32(numBuckets / 2)
+	if distance < maxExact {
+		return bucket + distance
+	}
+
+	large := maxExact + int32(math.Log(float64(distance)/float64(maxExact))/math.Log(float64(maxDistance)/float64(maxExact))*float64(int32(numBuckets)-maxExact))
+	return bucket + min(large, int32(numBuckets)-1)
+}
@@ -0,0 +1,116 @@
+package ml
+
+import (
+	"math"
+)
+
+// SelfTestTolerance is the largest error, relative to the largest value of
+// the reference, that a device may make and still pass its self-test. It
+// allows for the precision of half precision kernels while a faulty kernel
+// or driver is usually off by the order of the values themselves.
+const SelfTestTolerance = 1e-2
+
+// SelfTestResult is the outcome of checking that a device computes the
+// same results as the reference implementation.
+type SelfTestResult struct {
+	// Device is the name of the device, such as "CUDA0" or "CPU"
+	Device string `json:"device"`
+
+	Passed bool `json:"passed"`
+
+	// MaxError is the largest relative error of any result
+	MaxError float64 `json:"max_error"`
+
+	// Failed names the computations whose results were wrong, or Error
+	// describes why the test couldn't be run
+	Failed []string `json:"failed,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// SelfTester is implemented by backends that check the numerical results
+// of their devices when they are created.
+type SelfTester interface {
+	SelfTest() []SelfTestResult
+}
+
+// SelfTestData returns n deterministic pseudo random values in [-1, 1).
+func SelfTestData(n int, seed uint32) []float32 {
+	s := make([]float32, n)
+	x := seed | 1
+	for i := range s {
+		// xorshift32
+		x ^= x << 13
+		x ^= x >> 17
+		x ^= x << 5
+		s[i] = float32(x)/float32(math.MaxUint32)*2 - 1
+	}
+
+	return s
+}
+
+// ReferenceMulmat multiplies a and b, each holding rows of k values, like
+// Tensor.Mulmat: the result has a row of len(a)/k values for each row of b.
+func ReferenceMulmat(a, b []float32, k int) []float32 {
+	m, n := len(a)/k, len(b)/k
+	out := make([]float32, m*n)
+	for j := range n {
+		for i := range m {
+			var sum float64
+			for l := range k {
+				sum += float64(a[i*k+l]) * float64(b[j*k+l])
+			}
+			out[j*m+i] = float32(sum)
+		}
+	}
+
+	return out
+}
+
+// ReferenceAttention computes softmax(scale * k·q) · v for rows of d values
+// in q and k, and v transposed to rows of len(k)/d values.
+func ReferenceAttention(q, k, vt []float32, d int, scale float64) []float32 {
+	kq := ReferenceMulmat(k, q, d)
+	nk := len(k) / d
+	for j := 0; j < len(kq); j += nk {
+		row := kq[j : j+nk]
+		maxValue := math.Inf(-1)
+		for _, v := range row {
+			maxValue = max(maxValue, float64(v)*scale)
+		}
+
+		var sum float64
+		for i, v := range row {
+			e := math.Exp(float64(v)*scale - maxValue)
+			row[i] = float32(e)
+			sum += e
+		}
+
+		for i := range row {
+			row[i] = float32(float64(row[i]) / sum)
+		}
+	}
+
+	return ReferenceMulmat(vt, kq, nk)
+}
+
+// SelfTestError returns the largest difference between got and expect,
+// relative to the largest magnitude in expect. Results that can't be
+// compared, such as NaN or infinity, have the largest finite error.
+func SelfTestError(got, expect []float32) float64 {
+	if len(got) != len(expect) {
+		return math.MaxFloat32
+	}
+
+	var maxDiff, maxValue float64
+	for i := range expect {
+		diff := math.Abs(float64(got[i]) - float64(expect[i]))
+		if math.IsNaN(diff) || math.IsInf(diff, 0) {
+			return math.MaxFloat32
+		}
+
+		maxDiff = max(maxDiff, diff)
+		maxValue = max(maxValue, math.Abs(float64(expect[i])))
+	}
+
+	return maxDiff / max(maxValue, 1e-6)
+}
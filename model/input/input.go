@@ -1,6 +1,11 @@
 package input
 
-import "github.com/ollama/ollama/ml"
+import (
+	"encoding/binary"
+	"hash/fnv"
+
+	"github.com/ollama/ollama/ml"
+)
 
 // Input represents one token in the input stream
 type Input struct {
@@ -56,3 +61,52 @@ type Batch struct {
 	// be returned.
 	Outputs []int32
 }
+
+// Options describe how a model places the embeddings of images among its
+// input tokens. Images are split into rows of patches, with a placeholder
+// token for each patch.
+type Options struct {
+	// ImageToken is the placeholder of each patch of an image
+	ImageToken int32
+
+	// ImageBreakToken ends each row of patches except the last
+	ImageBreakToken int32
+
+	// ImageEndToken ends the last row of patches
+	ImageEndToken int32
+}
+
+// PlaceImage returns the inputs that hold an image with the embeddings of
+// each of its rows of patches in rows, which have shape [embedding, patches].
+// The first placeholder of a row carries the embeddings of the row, hashed
+// from the row and hash, the hash of the image. The rows share the
+// computation of the image, so all of the inputs are processed in one batch.
+func (o Options) PlaceImage(rows []ml.Tensor, hash uint64) []Input {
+	var inputs []Input
+	for i, row := range rows {
+		first := Input{Token: o.ImageToken, Multimodal: row, MultimodalHash: rowHash(hash, i)}
+		if i == 0 {
+			first.SameBatch = len(rows)*(row.Dim(1)+1) - 1
+		}
+
+		inputs = append(inputs, first)
+		for range row.Dim(1) - 1 {
+			inputs = append(inputs, Input{Token: o.ImageToken})
+		}
+
+		if i < len(rows)-1 {
+			inputs = append(inputs, Input{Token: o.ImageBreakToken})
+		} else {
+			inputs = append(inputs, Input{Token: o.ImageEndToken})
+		}
+	}
+
+	return inputs
+}
+
+func rowHash(hash uint64, row int) uint64 {
+	h := fnv.New64a()
+	binary.Write(h, binary.NativeEndian, hash)
+	binary.Write(h, binary.NativeEndian, int64(row))
+	return h.Sum64()
+}
@@ -0,0 +1,232 @@
+package model
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/x448/float16"
+
+	fs "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/ml/nn"
+)
+
+// Linears returns the linear layers of m keyed by the name of their tensors
+// without the weight suffix, such as blk.0.attn_q
+func Linears(m Model) map[string]*nn.Linear {
+	linears := make(map[string]*nn.Linear)
+
+	var fn func(v reflect.Value, names []string)
+	fn = func(v reflect.Value, names []string) {
+		switch v.Kind() {
+		case reflect.Pointer:
+			if v.IsNil() {
+				return
+			}
+
+			if l, ok := v.Interface().(*nn.Linear); ok {
+				linears[strings.Join(names, ".")] = l
+				return
+			}
+
+			fn(v.Elem(), names)
+		case reflect.Struct:
+			t := v.Type()
+			for i := range t.NumField() {
+				f := t.Field(i)
+				if !f.IsExported() || f.Type == reflect.TypeOf(Base{}) {
+					continue
+				}
+
+				names := names
+				if tag := f.Tag.Get("gguf"); tag != "" {
+					names = append(names[:len(names):len(names)], ParseTags(tag).Name)
+				}
+
+				fn(v.Field(i), names)
+			}
+		case reflect.Slice, reflect.Array:
+			for i := range v.Len() {
+				fn(v.Index(i), append(names[:len(names):len(names)], strconv.Itoa(i)))
+			}
+		}
+	}
+
+	fn(reflect.ValueOf(m), nil)
+	return linears
+}
+
+// layer returns the context for the tensors of a linear layer
+func layer(ctx ml.Context, name string) ml.Context {
+	var n int
+	if _, err := fmt.Sscanf(name, "blk.%d.", &n); err == nil {
+		return ctx.Layer(n)
+	}
+
+	return ctx.Output()
+}
+
+// LoadAdapter reads a LoRA adapter in the GGUF format used by llama.cpp and
+// attaches it to the linear layers of m. The tensors of the adapter are
+// allocated in ctx, which must not be closed while the adapter is in use.
+func LoadAdapter(ctx ml.Context, m Model, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	meta, _, err := fs.Decode(f, -1)
+	if err != nil {
+		return err
+	}
+
+	kv := meta.KV()
+	if kv.Kind() != "adapter" || kv["adapter.type"] != "lora" {
+		return fmt.Errorf("%s is not a LoRA adapter", path)
+	}
+
+	alpha, _ := kv["adapter.lora.alpha"].(float32)
+
+	linears := Linears(m)
+	loras := make(map[string]*nn.LoRA)
+	for _, t := range meta.Tensors().Items() {
+		name, ab, ok := strings.Cut(t.Name, ".weight.lora_")
+		if !ok {
+			return fmt.Errorf("unexpected tensor %s in adapter", t.Name)
+		}
+
+		if _, ok := linears[name]; !ok {
+			return fmt.Errorf("adapter tensor %s does not match the model", t.Name)
+		}
+
+		data, err := readFloats(io.NewSectionReader(f, int64(meta.Tensors().Offset+t.Offset), int64(t.Size())), t)
+		if err != nil {
+			return fmt.Errorf("%s: %w", t.Name, err)
+		}
+
+		shape := make([]int, len(t.Shape))
+		for i, s := range t.Shape {
+			shape[i] = int(s)
+		}
+
+		tensor, err := layer(ctx, name).FromFloatSlice(data, shape...)
+		if err != nil {
+			return fmt.Errorf("%s: %w", t.Name, err)
+		}
+
+		if loras[name] == nil {
+			loras[name] = &nn.LoRA{}
+		}
+
+		switch ab {
+		case "a":
+			loras[name].A = tensor
+		case "b":
+			loras[name].B = tensor
+		default:
+			return fmt.Errorf("unexpected tensor %s in adapter", t.Name)
+		}
+	}
+
+	for name, lora := range loras {
+		if lora.A == nil || lora.B == nil {
+			return fmt.Errorf("adapter is missing a tensor for %s", name)
+		}
+
+		rank := lora.A.Dim(1)
+		if lora.B.Dim(0) != rank {
+			return fmt.Errorf("adapter tensors for %s have mismatched ranks", name)
+		}
+
+		if linears[name].LoRA != nil {
+			return fmt.Errorf("%s already has an adapter", name)
+		}
+
+		lora.Scale = 1
+		if alpha != 0 {
+			lora.Scale = alpha / float32(rank)
+		}
+
+		linears[name].LoRA = lora
+	}
+
+	return nil
+}
+
+func readFloats(r io.Reader, t *fs.Tensor) ([]float32, error) {
+	switch t.Kind {
+	case 0: // F32
+		s := make([]float32, t.Size()/4)
+		if err := binary.Read(r, binary.LittleEndian, s); err != nil {
+			return nil, err
+		}
+
+		return s, nil
+	case 1: // F16
+		u := make([]uint16, t.Size()/2)
+		if err := binary.Read(r, binary.LittleEndian, u); err != nil {
+			return nil, err
+		}
+
+		s := make([]float32, len(u))
+		for i := range u {
+			s[i] = float16.Frombits(u[i]).Float32()
+		}
+
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unsupported tensor type %s", t.Type())
+	}
+}
+
+// WriteAdapter writes the adapters of a model with architecture arch in the
+// GGUF format read by LoadAdapter and llama.cpp. The adapter tensors must
+// have been computed.
+func WriteAdapter(ws io.WriteSeeker, arch string, alpha float32, loras map[string]*nn.LoRA) error {
+	var ts []fs.Tensor
+	for name, lora := range loras {
+		for suffix, t := range map[string]ml.Tensor{"lora_a": lora.A, "lora_b": lora.B} {
+			data := t.Floats()
+			if data == nil {
+				return errors.New("adapter tensors have not been computed")
+			}
+
+			// gguf shapes are written outermost dimension first
+			shape := make([]uint64, len(t.Shape()))
+			for i, s := range t.Shape() {
+				shape[len(shape)-i-1] = uint64(s)
+			}
+
+			ts = append(ts, fs.Tensor{
+				Name:     name + ".weight." + suffix,
+				Kind:     0,
+				Shape:    shape,
+				WriterTo: floats(data),
+			})
+		}
+	}
+
+	return fs.WriteGGUF(ws, fs.KV{
+		"general.architecture": arch,
+		"general.type":         "adapter",
+		"adapter.type":         "lora",
+		"adapter.lora.alpha":   alpha,
+	}, ts)
+}
+
+type floats []float32
+
+func (f floats) WriteTo(w io.Writer) (int64, error) {
+	if err := binary.Write(w, binary.LittleEndian, f); err != nil {
+		return 0, err
+	}
+
+	return int64(len(f) * 4), nil
+}
@@ -0,0 +1,169 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	fs "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/ml/nn"
+	"github.com/ollama/ollama/model/input"
+)
+
+type fakeLayer struct {
+	Query *nn.Linear  `gguf:"attn_q"`
+	Value *nn.Linear  `gguf:"attn_v"`
+	Norm  *nn.RMSNorm `gguf:"attn_norm"`
+}
+
+type fakeModel struct {
+	Base
+
+	Layers []fakeLayer `gguf:"blk"`
+	Output *nn.Linear  `gguf:"output,alt:token_embd"`
+}
+
+func (fakeModel) Forward(ml.Context, input.Batch) (ml.Tensor, error) {
+	panic("not implemented")
+}
+
+func TestLinears(t *testing.T) {
+	m := fakeModel{
+		Layers: []fakeLayer{
+			{Query: &nn.Linear{}, Value: &nn.Linear{}},
+			{Query: &nn.Linear{}},
+		},
+		Output: &nn.Linear{},
+	}
+
+	linears := Linears(&m)
+	if diff := cmp.Diff(map[string]*nn.Linear{
+		"blk.0.attn_q": m.Layers[0].Query,
+		"blk.0.attn_v": m.Layers[0].Value,
+		"blk.1.attn_q": m.Layers[1].Query,
+		"output":       m.Output,
+	}, linears); diff != "" {
+		t.Errorf("Linears() mismatch (-want +got):\n%s", diff)
+	}
+
+	if linears["blk.1.attn_q"] != m.Layers[1].Query {
+		t.Error("Linears() must return the layers of the model")
+	}
+}
+
+func writeGGUF(t *testing.T, kv fs.KV, tensors []fs.Tensor) string {
+	t.Helper()
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "model.gguf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := fs.WriteGGUF(f, kv, tensors); err != nil {
+		t.Fatal(err)
+	}
+
+	return f.Name()
+}
+
+func values(n int) *bytes.Reader {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, slices.Repeat([]float32{1}, n))
+	return bytes.NewReader(b.Bytes())
+}
+
+func TestLoadAdapter(t *testing.T) {
+	f, err := os.Open(writeGGUF(t, fs.KV{
+		"general.architecture": "test",
+		"test.block_count":     uint32(1),
+	}, []fs.Tensor{
+		{Name: "blk.0.attn_q.weight", Kind: 0, Shape: []uint64{4, 4}, WriterTo: values(16)},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	b, err := ml.NewBackend(context.Background(), f, ml.BackendParams{NumThreads: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := b.NewContext()
+	defer ctx.Close()
+
+	adapter := func(t *testing.T, kv fs.KV, names ...string) string {
+		var tensors []fs.Tensor
+		for _, name := range names {
+			// A is [in, rank] and B is [rank, out], written outermost first
+			shape := []uint64{2, 4}
+			if name[len(name)-1] == 'b' {
+				shape = []uint64{4, 2}
+			}
+
+			tensors = append(tensors, fs.Tensor{Name: name, Kind: 0, Shape: shape, WriterTo: values(8)})
+		}
+
+		return writeGGUF(t, kv, tensors)
+	}
+
+	lora := fs.KV{
+		"general.architecture": "test",
+		"general.type":         "adapter",
+		"adapter.type":         "lora",
+		"adapter.lora.alpha":   float32(8),
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		m := fakeModel{Layers: []fakeLayer{{Query: &nn.Linear{}, Value: &nn.Linear{}}}}
+		if err := LoadAdapter(ctx, &m, adapter(t, lora, "blk.0.attn_q.weight.lora_a", "blk.0.attn_q.weight.lora_b")); err != nil {
+			t.Fatal(err)
+		}
+
+		q := m.Layers[0].Query.LoRA
+		if q == nil {
+			t.Fatal("adapter was not attached")
+		}
+
+		if !slices.Equal(q.A.Shape(), []int{4, 2}) || !slices.Equal(q.B.Shape(), []int{2, 4}) {
+			t.Errorf("unexpected shapes %v %v", q.A.Shape(), q.B.Shape())
+		}
+
+		// alpha / rank
+		if q.Scale != 4 {
+			t.Errorf("scale = %v, want 4", q.Scale)
+		}
+
+		if m.Layers[0].Value.LoRA != nil {
+			t.Error("adapter attached to the wrong layer")
+		}
+	})
+
+	t.Run("missing tensor", func(t *testing.T) {
+		m := fakeModel{Layers: []fakeLayer{{Query: &nn.Linear{}}}}
+		if err := LoadAdapter(ctx, &m, adapter(t, lora, "blk.0.attn_q.weight.lora_a")); err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("unknown layer", func(t *testing.T) {
+		m := fakeModel{Layers: []fakeLayer{{Query: &nn.Linear{}}}}
+		if err := LoadAdapter(ctx, &m, adapter(t, lora, "blk.1.attn_q.weight.lora_a", "blk.1.attn_q.weight.lora_b")); err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("not an adapter", func(t *testing.T) {
+		m := fakeModel{Layers: []fakeLayer{{Query: &nn.Linear{}}}}
+		if err := LoadAdapter(ctx, &m, adapter(t, fs.KV{"general.architecture": "test"}, "blk.0.attn_q.weight.lora_a", "blk.0.attn_q.weight.lora_b")); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
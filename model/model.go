@@ -9,6 +9,8 @@ import (
 	"log/slog"
 	"os"
 	"reflect"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -64,6 +66,59 @@ type MultimodalProcessor interface {
 	PostTokenize([]input.Input) ([]input.Input, error)
 }
 
+// Classifier is implemented by models that can have a sequence classification
+// head in place of the language modeling head, such as reward models and
+// toxicity classifiers. When the head is present, the outputs of Forward are
+// the score of each label for the sequence up to each output instead of
+// logits over the vocabulary.
+type Classifier interface {
+	// Labels returns the labels of the classification head, in the order of
+	// the outputs, or nil if the model doesn't have one.
+	Labels() []string
+}
+
+// Embedder is implemented by encoder-only models, such as BERT, which embed
+// whole sequences instead of generating text. Their inputs attend to each
+// other in both directions, so they run without a cache and the runner
+// processes each sequence in a single batch. The outputs of Forward are
+// the pooled embeddings of the sequences, or the scores of the labels if
+// the model is also a Classifier with a classification head.
+type Embedder interface {
+	// EmbeddingLength returns the length of the pooled embeddings
+	EmbeddingLength() int
+}
+
+// EncoderDecoder is implemented by models that run the prompt through a
+// separate encoder, such as T5. Instead of passing the prompt to Forward, the
+// runner passes the result of EncodeTokens as the multimodal data of a single
+// input holding DecoderStart, which begins the output of the decoder.
+type EncoderDecoder interface {
+	// EncodeTokens processes the tokens of a prompt with the encoder. As with
+	// EncodeMultimodal, the return value is most typically an ml.Tensor.
+	EncodeTokens(ctx ml.Context, tokens []int32) (any, error)
+
+	// DecoderStart returns the token that the decoder starts from
+	DecoderStart() int32
+}
+
+// TensorMapping maps the name that the gguf tags of a model's fields give a
+// tensor to its name in files that name it differently. Pattern is a regular
+// expression that must match the whole name from the tags, and Name is its
+// replacement, in which $1 and so on refer to the captures of Pattern, for
+// example {`blk\.(\d+)\.attn_q\.weight`, "layers.$1.attention.wq.weight"}.
+type TensorMapping struct {
+	Pattern string
+	Name    string
+}
+
+// TensorMapper is implemented by models that load files whose tensor names
+// differ from their gguf tags in more than the alternate names of the tags.
+// The mappings are tried in order for tensors that aren't found under any
+// of the names from the tags.
+type TensorMapper interface {
+	TensorMappings() []TensorMapping
+}
+
 // Base implements the common fields and methods for all models
 type Base struct {
 	b ml.Backend
@@ -72,6 +127,10 @@ type Base struct {
 
 type config struct {
 	Cache kvcache.Cache
+
+	// InputOptions is how multimodal models place the embeddings of images
+	// among their inputs
+	InputOptions input.Options
 }
 
 // Backend returns the underlying backend that will run the model
@@ -83,6 +142,12 @@ func (m *Base) Config() config {
 	return m.config
 }
 
+// SetCache replaces the cache used by the model's forward pass, for example
+// with a [kvcache.Training] cache while computing a training loss
+func (m *Base) SetCache(cache kvcache.Cache) {
+	m.config.Cache = cache
+}
+
 var models = make(map[string]func(ml.Config) (Model, error))
 
 // Register registers a model constructor for the given architecture
@@ -94,6 +159,28 @@ func Register(name string, f func(ml.Config) (Model, error)) {
 	models[name] = f
 }
 
+// UnsupportedError is returned by [Supported] when the Ollama engine can't
+// run a model and it should be run by the llama.cpp runner instead
+type UnsupportedError struct {
+	Architecture string
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("unsupported model architecture %q", e.Architecture)
+}
+
+// Supported checks whether the Ollama engine implements the architecture,
+// returning an [*UnsupportedError] if it doesn't. Ops that the devices of a
+// compute library don't implement need no check, since the backend runs
+// them on the CPU instead.
+func Supported(arch string) error {
+	if _, ok := models[arch]; !ok {
+		return &UnsupportedError{Architecture: arch}
+	}
+
+	return nil
+}
+
 // New initializes a new model instance with the provided configuration based on the metadata in the model file
 func New(ctx context.Context, modelPath string, params ml.BackendParams) (Model, error) {
 	r, err := os.Open(modelPath)
@@ -110,7 +197,7 @@ func New(ctx context.Context, modelPath string, params ml.BackendParams) (Model,
 	arch := b.Config().Architecture()
 	f, ok := models[arch]
 	if !ok {
-		return nil, fmt.Errorf("unsupported model architecture %q", arch)
+		return nil, &UnsupportedError{Architecture: arch}
 	}
 
 	m, err := f(b.Config())
@@ -118,13 +205,117 @@ func New(ctx context.Context, modelPath string, params ml.BackendParams) (Model,
 		return nil, err
 	}
 
-	base := Base{b: b, config: m.Config()}
+	l := loader{base: Base{b: b, config: m.Config()}}
+	if mapper, ok := m.(TensorMapper); ok {
+		if l.mappings, err = compileMappings(mapper.TensorMappings()); err != nil {
+			return nil, fmt.Errorf("%s: %w", arch, err)
+		}
+	}
 
 	v := reflect.ValueOf(m)
-	v.Elem().Set(populateFields(base, v.Elem()))
+	v.Elem().Set(populateFields(&l, v.Elem()))
+
+	if unmatched := l.unmatched(); len(unmatched) > 0 {
+		slog.Warn("model file has tensors that the model doesn't load", "arch", arch, "tensors", unmatched)
+	}
+
 	return m, nil
 }
 
+type tensorMapping struct {
+	pattern *regexp.Regexp
+	name    string
+}
+
+func compileMappings(mappings []TensorMapping) ([]tensorMapping, error) {
+	compiled := make([]tensorMapping, len(mappings))
+	for i, m := range mappings {
+		re, err := regexp.Compile("^(?:" + m.Pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid tensor mapping %q: %w", m.Pattern, err)
+		}
+
+		compiled[i] = tensorMapping{pattern: re, name: m.Name}
+	}
+
+	return compiled, nil
+}
+
+// loader finds the tensors for the fields of a model in its backend
+type loader struct {
+	base     Base
+	mappings []tensorMapping
+
+	// loaded is the set of names of the tensors that were found
+	loaded map[string]struct{}
+}
+
+// get returns the first tensor found under names, which are tried in order,
+// and then under the mappings of the first name
+func (l *loader) get(names []string) ml.Tensor {
+	for _, name := range names {
+		if tensor := l.lookup(name); tensor != nil {
+			return tensor
+		}
+	}
+
+	if len(names) > 0 {
+		for _, m := range l.mappings {
+			if m.pattern.MatchString(names[0]) {
+				name := m.pattern.ReplaceAllString(names[0], m.name)
+				if tensor := l.lookup(name); tensor != nil {
+					return tensor
+				}
+
+				names = append(names, name)
+			}
+		}
+	}
+
+	slog.Debug("tensor not found", "names", names)
+	return nil
+}
+
+func (l *loader) lookup(name string) ml.Tensor {
+	tensor := l.base.Backend().Get(name)
+	if tensor != nil {
+		slog.Debug("found tensor", "", tensor)
+		if l.loaded == nil {
+			l.loaded = make(map[string]struct{})
+		}
+		l.loaded[name] = struct{}{}
+	}
+
+	return tensor
+}
+
+// unmatched returns the names of the tensors in the model file that no
+// field was loaded from, with the indices of layers replaced by * so that
+// each appears once. It returns nil if the backend can't list its tensors.
+func (l *loader) unmatched() []string {
+	b, ok := l.base.Backend().(ml.BackendTensors)
+	if !ok {
+		return nil
+	}
+
+	var unmatched []string
+	for _, name := range b.TensorNames() {
+		if _, ok := l.loaded[name]; ok {
+			continue
+		}
+
+		name = layerIndexRegexp.ReplaceAllString(name, ".*.")
+		if !slices.Contains(unmatched, name) {
+			unmatched = append(unmatched, name)
+		}
+	}
+
+	slices.Sort(unmatched)
+	return unmatched
+}
+
+var layerIndexRegexp = regexp.MustCompile(`\.\d+\.`)
+
 func NewTextProcessor(s string) (TextProcessor, error) {
 	r, err := os.Open(s)
 	if err != nil {
@@ -142,7 +333,7 @@ func getTextProcessor(kv fs.KV) (TextProcessor, error) {
 	arch := kv.Architecture()
 	f, ok := models[arch]
 	if !ok {
-		return nil, fmt.Errorf("unsupported model architecture %q", arch)
+		return nil, &UnsupportedError{Architecture: arch}
 	}
 	m, err := f(kv)
 	if err != nil {
@@ -155,7 +346,7 @@ func getTextProcessor(kv fs.KV) (TextProcessor, error) {
 	return tp, nil
 }
 
-func populateFields(base Base, v reflect.Value, tags ...Tag) reflect.Value {
+func populateFields(l *loader, v reflect.Value, tags ...Tag) reflect.Value {
 	t := v.Type()
 
 	if t.Kind() == reflect.Struct {
@@ -169,12 +360,15 @@ func populateFields(base Base, v reflect.Value, tags ...Tag) reflect.Value {
 
 			// make a copy
 			tagsCopy := tags
-			if tag := t.Field(i).Tag.Get("gguf"); tag != "" {
+			if tag := t.Field(i).Tag.Get("gguf"); tag == "-" {
+				// not loaded from the model file
+				continue
+			} else if tag != "" {
 				tagsCopy = append(tagsCopy, ParseTags(tag))
 			}
 
 			if tt == reflect.TypeOf((*Base)(nil)).Elem() {
-				vv.Set(reflect.ValueOf(base))
+				vv.Set(reflect.ValueOf(l.base))
 			} else if tt == reflect.TypeOf((*ml.Tensor)(nil)).Elem() {
 				var fn func([]Tag) [][]string
 				fn = func(tags []Tag) (values [][]string) {
@@ -198,23 +392,23 @@ func populateFields(base Base, v reflect.Value, tags ...Tag) reflect.Value {
 					return values
 				}
 
-				names := fn(tagsCopy)
-				for _, name := range names {
-					if tensor := base.Backend().Get(strings.Join(name, ".")); tensor != nil {
-						slog.Debug("found tensor", "", tensor)
-						vv.Set(reflect.ValueOf(tensor))
-						break
-					}
+				var names []string
+				for _, name := range fn(tagsCopy) {
+					names = append(names, strings.Join(name, "."))
+				}
+
+				if tensor := l.get(names); tensor != nil {
+					vv.Set(reflect.ValueOf(tensor))
 				}
 			} else if tt.Kind() == reflect.Pointer || tt.Kind() == reflect.Interface {
-				setPointer(base, vv, tagsCopy)
+				setPointer(l, vv, tagsCopy)
 			} else if tt.Kind() == reflect.Slice || tt.Kind() == reflect.Array {
 				for i := range vv.Len() {
 					vvv := vv.Index(i)
 					if vvv.Kind() == reflect.Pointer || vvv.Kind() == reflect.Interface {
-						setPointer(base, vvv, append(tagsCopy, Tag{Name: strconv.Itoa(i)}))
+						setPointer(l, vvv, append(tagsCopy, Tag{Name: strconv.Itoa(i)}))
 					} else {
-						vvv.Set(populateFields(base, vvv, append(tagsCopy, Tag{Name: strconv.Itoa(i)})...))
+						vvv.Set(populateFields(l, vvv, append(tagsCopy, Tag{Name: strconv.Itoa(i)})...))
 					}
 				}
 			}
@@ -232,7 +426,7 @@ func populateFields(base Base, v reflect.Value, tags ...Tag) reflect.Value {
 	return v
 }
 
-func setPointer(base Base, v reflect.Value, tags []Tag) {
+func setPointer(l *loader, v reflect.Value, tags []Tag) {
 	vv := v
 	if v.Kind() == reflect.Interface {
 		if v.IsNil() {
@@ -247,7 +441,7 @@ func setPointer(base Base, v reflect.Value, tags []Tag) {
 		vv = reflect.New(v.Type().Elem()).Elem()
 	}
 
-	if f := populateFields(base, vv, tags...); f.CanAddr() {
+	if f := populateFields(l, vv, tags...); f.CanAddr() {
 		v.Set(f.Addr())
 	}
 }
@@ -281,6 +475,9 @@ func canNil(t reflect.Type) bool {
 		t.Kind() == reflect.Slice
 }
 
+// Forward computes the outputs of a batch. The result is held by ctx rather
+// than the shared compute buffers, so it stays valid while later batches are
+// computed until ctx is closed.
 func Forward(ctx ml.Context, m Model, inputs []int32, batch input.Batch) (ml.Tensor, error) {
 	if len(batch.Positions) != len(batch.Sequences) {
 		return nil, fmt.Errorf("length of positions (%v) must match length of seqs (%v)", len(batch.Positions), len(batch.Sequences))
@@ -309,6 +506,10 @@ func Forward(ctx ml.Context, m Model, inputs []int32, batch input.Batch) (ml.Ten
 		return nil, err
 	}
 
+	if t.Dim(0)*t.Dim(1)*t.Dim(2)*t.Dim(3) > 0 {
+		t = t.Copy(ctx, ctx.Output().Empty(t.DType(), t.Shape()...))
+	}
+
 	ctx.Forward(t).Compute(t)
 
 	return t, nil
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"errors"
 	"reflect"
 	"slices"
 	"strings"
@@ -34,6 +35,16 @@ func TestParseTags(t *testing.T) {
 				},
 			},
 		},
+		{
+			value: "output,alt:lm_head,alt:token_embd",
+			want: Tag{
+				Name: "output",
+				Alternate: []string{
+					"lm_head",
+					"token_embd",
+				},
+			},
+		},
 	}
 
 	for _, tt := range cases {
@@ -81,7 +92,7 @@ func TestPopulateFields(t *testing.T) {
 
 	var m fakeModel
 	v := reflect.ValueOf(&m)
-	v.Elem().Set(populateFields(Base{b: &fakeBackend{
+	v.Elem().Set(populateFields(&loader{base: Base{b: &fakeBackend{
 		names: []string{
 			"input.weight",
 			"blk.0.attn_q.weight",
@@ -93,7 +104,7 @@ func TestPopulateFields(t *testing.T) {
 			"output_norm.weight",
 			"output.weight",
 		},
-	}}, v.Elem()))
+	}}}, v.Elem()))
 
 	if diff := cmp.Diff(fakeModel{
 		Input:      &nn.Embedding{Weight: &fakeTensor{Name: "input.weight"}},
@@ -124,11 +135,11 @@ func TestPopulateFieldsAlternateName(t *testing.T) {
 
 	m := fakeModel{}
 	v := reflect.ValueOf(&m)
-	v.Elem().Set(populateFields(Base{b: &fakeBackend{
+	v.Elem().Set(populateFields(&loader{base: Base{b: &fakeBackend{
 		names: []string{
 			"input.weight",
 		},
-	}}, v.Elem()))
+	}}}, v.Elem()))
 
 	if diff := cmp.Diff(fakeModel{
 		Input:  &nn.Embedding{Weight: &fakeTensor{Name: "input.weight"}},
@@ -161,6 +172,26 @@ func TestGetTextProcessor(t *testing.T) {
 	}
 }
 
+func TestSupported(t *testing.T) {
+	models["supported"] = func(ml.Config) (Model, error) {
+		return notTextProcessorModel{}, nil
+	}
+	defer delete(models, "supported")
+
+	if err := Supported("supported"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var unsupported *UnsupportedError
+	if err := Supported("unregistered"); !errors.As(err, &unsupported) {
+		t.Fatalf("expected an UnsupportedError, got %v", err)
+	}
+
+	if diff := cmp.Diff(&UnsupportedError{Architecture: "unregistered"}, unsupported); diff != "" {
+		t.Errorf("error mismatch (-want +got):\n%s", diff)
+	}
+}
+
 type notTextProcessorModel struct{}
 
 func (notTextProcessorModel) Forward(ml.Context, input.Batch) (ml.Tensor, error) {
@@ -174,3 +205,71 @@ func (notTextProcessorModel) Backend() ml.Backend {
 func (notTextProcessorModel) Config() config {
 	panic("unimplemented")
 }
+
+func (m *fakeBackend) TensorNames() []string {
+	return m.names
+}
+
+func TestPopulateFieldsMappings(t *testing.T) {
+	type fakeLayer struct {
+		Query *nn.Linear `gguf:"attn_q"`
+		Key   *nn.Linear `gguf:"attn_k"`
+	}
+
+	type fakeModel struct {
+		Output *nn.Linear   `gguf:"output,alt:lm_head,alt:token_embd"`
+		Layers [2]fakeLayer `gguf:"blk"`
+	}
+
+	mappings, err := compileMappings([]TensorMapping{
+		{Pattern: `blk\.(\d+)\.attn_q\.weight`, Name: "layers.$1.attention.wq.weight"},
+		{Pattern: `blk\.(\d+)\.attn_(.)\.weight`, Name: "layers.$1.attention.w$2.weight"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := loader{
+		base: Base{b: &fakeBackend{
+			names: []string{
+				"token_embd.weight",
+				"layers.0.attention.wq.weight",
+				"layers.0.attention.wk.weight",
+				"layers.1.attention.wq.weight",
+				"layers.1.attention.wk.weight",
+				"layers.0.attention.wo.weight",
+				"layers.1.attention.wo.weight",
+				"rope_freqs.weight",
+			},
+		}},
+		mappings: mappings,
+	}
+
+	var m fakeModel
+	v := reflect.ValueOf(&m)
+	v.Elem().Set(populateFields(&l, v.Elem()))
+
+	if diff := cmp.Diff(fakeModel{
+		Output: &nn.Linear{Weight: &fakeTensor{Name: "token_embd.weight"}},
+		Layers: [2]fakeLayer{
+			{
+				Query: &nn.Linear{Weight: &fakeTensor{Name: "layers.0.attention.wq.weight"}},
+				Key:   &nn.Linear{Weight: &fakeTensor{Name: "layers.0.attention.wk.weight"}},
+			},
+			{
+				Query: &nn.Linear{Weight: &fakeTensor{Name: "layers.1.attention.wq.weight"}},
+				Key:   &nn.Linear{Weight: &fakeTensor{Name: "layers.1.attention.wk.weight"}},
+			},
+		},
+	}, m); diff != "" {
+		t.Errorf("populateFields() set incorrect values (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff([]string{"layers.*.attention.wo.weight", "rope_freqs.weight"}, l.unmatched()); diff != "" {
+		t.Errorf("unmatched() returned unexpected names (-want +got):\n%s", diff)
+	}
+
+	if _, err := compileMappings([]TensorMapping{{Pattern: `blk\.(\d+`}}); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}
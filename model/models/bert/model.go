@@ -0,0 +1,202 @@
+package bert
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/ml/nn"
+	"github.com/ollama/ollama/model"
+	"github.com/ollama/ollama/model/input"
+)
+
+type Options struct {
+	hiddenSize, numHeads int
+	eps                  float32
+	pooling              nn.Pooling
+	labels               []string
+}
+
+type Model struct {
+	model.Base
+	model.TextProcessor `gguf:"-"`
+
+	TokenEmbedding    *nn.Embedding `gguf:"token_embd"`
+	TypeEmbedding     *nn.Embedding `gguf:"token_types"`
+	PositionEmbedding *nn.Embedding `gguf:"position_embd"`
+	EmbeddingNorm     *nn.LayerNorm `gguf:"token_embd_norm"`
+
+	Layers []Layer `gguf:"blk"`
+
+	// Pooler and Classifier make up the classification head of models for
+	// sequence classification, such as rerankers
+	Pooler     *nn.Linear `gguf:"cls"`
+	Classifier *nn.Linear `gguf:"cls.output"`
+
+	*Options
+}
+
+func New(c ml.Config) (model.Model, error) {
+	vocab := &model.Vocabulary{
+		Values: c.Strings("tokenizer.ggml.tokens"),
+		Types:  c.Uints("tokenizer.ggml.token_type"),
+		Merges: c.Strings("tokenizer.ggml.merges"),
+		BOS:    int32(c.Uint("tokenizer.ggml.cls_token_id", c.Uint("tokenizer.ggml.bos_token_id"))),
+		AddBOS: c.Bool("tokenizer.ggml.add_bos_token", true),
+		EOS:    int32(c.Uint("tokenizer.ggml.seperator_token_id", c.Uint("tokenizer.ggml.eos_token_id"))),
+		AddEOS: c.Bool("tokenizer.ggml.add_eos_token", true),
+	}
+
+	var processor model.TextProcessor
+	switch c.String("tokenizer.ggml.model") {
+	case "bert":
+		wpm := model.NewWordPiece(vocab)
+		processor = &wpm
+	case "gpt2":
+		// RoBERTa models
+		bpe := model.NewBytePairEncoding(
+			c.String("tokenizer.ggml.pretokenizer", `(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`),
+			vocab,
+		)
+		processor = &bpe
+	default:
+		return nil, fmt.Errorf("tokenizer %s not yet supported", c.String("tokenizer.ggml.model"))
+	}
+
+	m := Model{
+		TextProcessor: processor,
+		Layers:        make([]Layer, c.Uint("block_count")),
+		Options: &Options{
+			hiddenSize: int(c.Uint("embedding_length")),
+			numHeads:   int(c.Uint("attention.head_count")),
+			eps:        c.Float("attention.layer_norm_epsilon"),
+			pooling:    nn.Pooling(c.Uint("pooling_type", uint32(nn.PoolingCLS))),
+			labels:     c.Strings("classifier.output_labels"),
+		},
+	}
+
+	// There is no cache since inputs attend to each other in both
+	// directions, so each forward pass holds whole sequences
+	return &m, nil
+}
+
+type SelfAttention struct {
+	Query  *nn.Linear `gguf:"attn_q"`
+	Key    *nn.Linear `gguf:"attn_k"`
+	Value  *nn.Linear `gguf:"attn_v"`
+	Output *nn.Linear `gguf:"attn_output"`
+}
+
+func (sa *SelfAttention) Forward(ctx ml.Context, hiddenState, mask ml.Tensor, opts *Options) ml.Tensor {
+	batchSize := hiddenState.Dim(1)
+	headDim := opts.hiddenSize / opts.numHeads
+
+	query := sa.Query.Forward(ctx, hiddenState)
+	query = query.Reshape(ctx, headDim, opts.numHeads, batchSize)
+
+	key := sa.Key.Forward(ctx, hiddenState)
+	key = key.Reshape(ctx, headDim, opts.numHeads, batchSize)
+
+	value := sa.Value.Forward(ctx, hiddenState)
+	value = value.Reshape(ctx, headDim, opts.numHeads, batchSize)
+
+	query = query.Permute(ctx, 0, 2, 1, 3)
+	key = key.Permute(ctx, 0, 2, 1, 3)
+	value = value.Permute(ctx, 1, 2, 0, 3).Contiguous(ctx)
+
+	kq := key.MulmatFullPrec(ctx, query)
+	kq = kq.Scale(ctx, 1.0/math.Sqrt(float64(headDim)))
+	kq = kq.Add(ctx, mask)
+	kq = kq.Softmax(ctx)
+
+	kqv := value.Mulmat(ctx, kq)
+	kqv = kqv.Permute(ctx, 0, 2, 1, 3).Contiguous(ctx)
+	kqv = kqv.Reshape(ctx, opts.hiddenSize, batchSize)
+
+	return sa.Output.Forward(ctx, kqv)
+}
+
+type MLP struct {
+	Up   *nn.Linear `gguf:"ffn_up"`
+	Down *nn.Linear `gguf:"ffn_down"`
+}
+
+func (mlp *MLP) Forward(ctx ml.Context, hiddenState ml.Tensor) ml.Tensor {
+	return mlp.Down.Forward(ctx, mlp.Up.Forward(ctx, hiddenState).GELU(ctx))
+}
+
+type Layer struct {
+	SelfAttention *SelfAttention
+	AttentionNorm *nn.LayerNorm `gguf:"attn_output_norm"`
+
+	MLP        *MLP
+	OutputNorm *nn.LayerNorm `gguf:"layer_output_norm"`
+}
+
+func (l *Layer) Forward(ctx ml.Context, hiddenState, mask ml.Tensor, opts *Options) ml.Tensor {
+	residual := hiddenState
+
+	hiddenState = l.SelfAttention.Forward(ctx, hiddenState, mask, opts)
+	hiddenState = l.AttentionNorm.Forward(ctx, hiddenState.Add(ctx, residual), opts.eps)
+	residual = hiddenState
+
+	hiddenState = l.MLP.Forward(ctx, hiddenState)
+	return l.OutputNorm.Forward(ctx, hiddenState.Add(ctx, residual), opts.eps)
+}
+
+func (m *Model) Forward(ctx ml.Context, batch input.Batch) (ml.Tensor, error) {
+	positions, err := ctx.Input().FromIntSlice(batch.Positions, len(batch.Positions))
+	if err != nil {
+		return nil, err
+	}
+
+	hiddenState := m.TokenEmbedding.Forward(ctx, batch.Inputs)
+	hiddenState = hiddenState.Add(ctx, m.PositionEmbedding.Forward(ctx, positions))
+
+	// all inputs have the first token type
+	if m.TypeEmbedding != nil {
+		hiddenState = hiddenState.Add(ctx, m.TypeEmbedding.Weight.View(ctx, 0, m.hiddenSize))
+	}
+
+	hiddenState = m.EmbeddingNorm.Forward(ctx, hiddenState, m.eps)
+
+	mask, err := nn.BidirectionalMask(ctx, batch.Sequences)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range m.Layers {
+		hiddenState = m.Layers[i].Forward(ctx, hiddenState, mask, m.Options)
+	}
+
+	if m.Classifier != nil {
+		hiddenState, err = nn.PoolingCLS.Forward(ctx, hiddenState, batch.Sequences, batch.Outputs)
+		if err != nil {
+			return nil, err
+		}
+
+		if m.Pooler != nil {
+			hiddenState = m.Pooler.Forward(ctx, hiddenState).Tanh(ctx)
+		}
+
+		return m.Classifier.Forward(ctx, hiddenState), nil
+	}
+
+	return m.pooling.Forward(ctx, hiddenState, batch.Sequences, batch.Outputs)
+}
+
+func (m *Model) EmbeddingLength() int {
+	return m.hiddenSize
+}
+
+func (m *Model) Labels() []string {
+	if m.Classifier == nil {
+		return nil
+	}
+
+	return m.labels
+}
+
+func init() {
+	model.Register("bert", New)
+}
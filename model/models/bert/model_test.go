@@ -0,0 +1,130 @@
+package bert
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+
+	fs "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/ml/nn"
+	"github.com/ollama/ollama/model/models/internal/modeltest"
+)
+
+func TestForward(t *testing.T) {
+	// computed by reference.py in the modeltest package
+	cases := []struct {
+		name       string
+		tokenizer  string
+		pooling    nn.Pooling
+		eps        float32
+		tokenTypes bool
+		labels     []string
+		want       []float32
+	}{
+		{
+			name:       "bert",
+			tokenizer:  "bert",
+			pooling:    nn.PoolingCLS,
+			eps:        1e-12,
+			tokenTypes: true,
+			want: []float32{
+				1.39827, -0.337087, -1.077, 0.0176577, 0.0875629, -0.479515, 0.169574, 0.598622, 1.04813, 1.43833, -0.059934, -1.18965, -0.193475, 0.243077, -0.387855, 0.116548,
+				-1.63158, -1.01934, 1.46311, 0.383233, -0.23424, 0.574765, -0.481917, 0.594674, 1.91928, -1.10595, -1.53682, 1.24125, 0.796361, -0.323625, 0.577502, -0.278653,
+			},
+		},
+		{
+			// RoBERTa has a byte pair encoding and no token types
+			name:      "bert (roberta)",
+			tokenizer: "gpt2",
+			pooling:   nn.PoolingMean,
+			eps:       1e-5,
+			want: []float32{
+				0.277667, -0.645753, -0.375671, -0.572272, 0.339484, 0.914565, -0.573063, 0.116427, 1.97737, 0.615711, -0.720452, -0.413129, -0.523104, 0.127126, 1.09375, -0.235827,
+				0.366834, -0.619498, 0.0453134, 0.199234, -0.47004, 0.0662668, 0.436951, 0.145505, 0.849024, 0.556669, -0.611096, -0.163418, 0.312838, -0.366554, -0.0643282, 0.539988,
+			},
+		},
+		{
+			name:       "bert (classifier)",
+			tokenizer:  "bert",
+			pooling:    nn.PoolingCLS,
+			eps:        1e-12,
+			tokenTypes: true,
+			labels:     []string{"a", "b", "c"},
+			want: []float32{
+				0.328814, 0.590472, 0.421507,
+				2.20997, 1.16346, -1.22701,
+			},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			kv := fs.KV{
+				"general.architecture":              "bert",
+				"bert.block_count":                  uint32(2),
+				"bert.context_length":               uint32(8),
+				"bert.embedding_length":             uint32(16),
+				"bert.feed_forward_length":          uint32(24),
+				"bert.attention.head_count":         uint32(4),
+				"bert.attention.layer_norm_epsilon": tt.eps,
+				"bert.pooling_type":                 uint32(tt.pooling),
+			}
+			maps.Copy(kv, modeltest.Vocabulary(16))
+			kv["tokenizer.ggml.model"] = tt.tokenizer
+
+			tensors := []modeltest.Tensor{
+				{Name: "token_embd.weight", Shape: []uint64{16, 16}},
+				{Name: "position_embd.weight", Shape: []uint64{8, 16}},
+				{Name: "token_embd_norm.weight", Shape: []uint64{16}},
+				{Name: "token_embd_norm.bias", Shape: []uint64{16}},
+			}
+
+			if tt.tokenTypes {
+				tensors = append(tensors, modeltest.Tensor{Name: "token_types.weight", Shape: []uint64{2, 16}})
+			}
+
+			if tt.labels != nil {
+				kv["bert.classifier.output_labels"] = tt.labels
+				tensors = append(tensors,
+					modeltest.Tensor{Name: "cls.weight", Shape: []uint64{16, 16}},
+					modeltest.Tensor{Name: "cls.bias", Shape: []uint64{16}},
+					modeltest.Tensor{Name: "cls.output.weight", Shape: []uint64{uint64(len(tt.labels)), 16}},
+					modeltest.Tensor{Name: "cls.output.bias", Shape: []uint64{uint64(len(tt.labels))}},
+				)
+			}
+
+			for i := range 2 {
+				for _, name := range []string{"attn_q", "attn_k", "attn_v", "attn_output"} {
+					tensors = append(tensors,
+						modeltest.Tensor{Name: fmt.Sprintf("blk.%d.%s.weight", i, name), Shape: []uint64{16, 16}},
+						modeltest.Tensor{Name: fmt.Sprintf("blk.%d.%s.bias", i, name), Shape: []uint64{16}},
+					)
+				}
+
+				for _, name := range []string{"attn_output_norm", "layer_output_norm"} {
+					tensors = append(tensors,
+						modeltest.Tensor{Name: fmt.Sprintf("blk.%d.%s.weight", i, name), Shape: []uint64{16}},
+						modeltest.Tensor{Name: fmt.Sprintf("blk.%d.%s.bias", i, name), Shape: []uint64{16}},
+					)
+				}
+
+				tensors = append(tensors,
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_up.weight", i), Shape: []uint64{24, 16}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_up.bias", i), Shape: []uint64{24}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_down.weight", i), Shape: []uint64{16, 24}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_down.bias", i), Shape: []uint64{16}},
+				)
+			}
+
+			m := modeltest.Load(t, kv, tensors).(*Model)
+			if !slices.Equal(m.Labels(), tt.labels) {
+				t.Errorf("expected labels %v, got %v", tt.labels, m.Labels())
+			}
+
+			// the sequences are embedded in one batch, so each only attends
+			// to its own inputs
+			modeltest.Compare(t, modeltest.Embed(t, m, [][]int32{{1, 5, 2, 7}, {3, 6, 4}}), tt.want)
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package commandr
+
+import (
+	"fmt"
+	"maps"
+	"testing"
+
+	fs "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/model/models/internal/modeltest"
+)
+
+func TestForward(t *testing.T) {
+	// computed by reference.py in the modeltest package
+	cases := []struct {
+		name   string
+		qkNorm bool
+		want   []float32
+	}{
+		{
+			name: "command-r",
+			want: []float32{
+				-0.694968, -0.678624, 0.41944, 0.84892, -0.07477, -0.879278, -0.282225, 0.764691, 0.592697, -0.524051, -0.805467, 0.197023, 0.88546, 0.162482, -0.819491, -0.495204,
+				-0.486217, -0.0067944, 0.483459, 0.203083, -0.401005, -0.365895, 0.252448, 0.468392, -0.0622764, -0.493676, -0.138161, 0.437582, 0.315824, -0.309354, -0.441424, 0.130132,
+				0.003733, -0.796467, -0.327106, 0.663659, 0.596558, -0.42145, -0.767671, 0.109768, 0.812238, 0.220008, -0.722913, -0.513518, 0.514419, 0.722377, -0.221127, -0.812157,
+				0.138173, -0.645376, -0.400202, 0.48289, 0.59626, -0.240803, -0.694028, -0.0409794, 0.67739, 0.316006, -0.549089, -0.538942, 0.330273, 0.673036, -0.0570139, -0.696184,
+			},
+		},
+		{
+			name:   "command-r (qk_norm)",
+			qkNorm: true,
+			want: []float32{
+				-0.694968, -0.678624, 0.41944, 0.84892, -0.07477, -0.879278, -0.282225, 0.764691, 0.592697, -0.524051, -0.805467, 0.197023, 0.88546, 0.162482, -0.819491, -0.495204,
+				-0.505355, 0.0434683, 0.523004, 0.168876, -0.454438, -0.353383, 0.310962, 0.479636, -0.116225, -0.526825, -0.0976713, 0.487169, 0.295467, -0.367207, -0.444556, 0.186713,
+				-0.0284291, -0.834024, -0.310193, 0.708082, 0.597681, -0.465418, -0.786645, 0.146033, 0.845936, 0.197426, -0.765779, -0.508339, 0.559388, 0.735457, -0.260786, -0.841338,
+				0.173434, -0.601553, -0.417671, 0.431974, 0.593056, -0.191188, -0.670681, -0.0811151, 0.637747, 0.340047, -0.499685, -0.542923, 0.279253, 0.656303, -0.0127873, -0.661494,
+			},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			kv := fs.KV{
+				"general.architecture":                   "command-r",
+				"command-r.block_count":                  uint32(2),
+				"command-r.embedding_length":             uint32(16),
+				"command-r.feed_forward_length":          uint32(24),
+				"command-r.attention.head_count":         uint32(4),
+				"command-r.attention.head_count_kv":      uint32(2),
+				"command-r.attention.layer_norm_epsilon": float32(1e-5),
+				"command-r.logit_scale":                  float32(0.5),
+			}
+			maps.Copy(kv, modeltest.Vocabulary(16))
+
+			tensors := []modeltest.Tensor{
+				{Name: "token_embd.weight", Shape: []uint64{16, 16}},
+				{Name: "output_norm.weight", Shape: []uint64{16}},
+				{Name: "output.weight", Shape: []uint64{16, 16}},
+			}
+
+			for i := range 2 {
+				tensors = append(tensors,
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_norm.weight", i), Shape: []uint64{16}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_q.weight", i), Shape: []uint64{16, 16}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_k.weight", i), Shape: []uint64{8, 16}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_v.weight", i), Shape: []uint64{8, 16}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_output.weight", i), Shape: []uint64{16, 16}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_gate.weight", i), Shape: []uint64{24, 16}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_up.weight", i), Shape: []uint64{24, 16}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_down.weight", i), Shape: []uint64{16, 24}},
+				)
+
+				if tt.qkNorm {
+					// one set of weights for each head
+					tensors = append(tensors,
+						modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_q_norm.weight", i), Shape: []uint64{4, 4}},
+						modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_k_norm.weight", i), Shape: []uint64{2, 4}},
+					)
+				}
+			}
+
+			modeltest.Compare(t, modeltest.Forward(t, kv, tensors, []int32{1, 5, 2, 7}), tt.want)
+		})
+	}
+}
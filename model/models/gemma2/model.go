@@ -62,8 +62,16 @@ func New(c ml.Config) (model.Model, error) {
 		},
 	}
 
+	// layers alternate between a sliding window, starting with the first
+	// layer, and the whole context
 	slidingWindowLen := int32(c.Uint("attention.sliding_window"))
-	m.Cache = kvcache.NewWrapperCache(kvcache.NewSWACache(slidingWindowLen, m.Shift), kvcache.NewCausalCache(m.Shift))
+	windowSizes := make([]int32, len(m.Layers))
+	for i := range windowSizes {
+		if i%2 == 0 {
+			windowSizes[i] = slidingWindowLen
+		}
+	}
+	m.Cache = kvcache.NewLayerWindowCache(windowSizes, m.Shift)
 	m.Cache.SetConfig(ml.CacheConfig{})
 
 	return &m, nil
@@ -187,10 +195,7 @@ func (m *Model) Forward(ctx ml.Context, batch input.Batch) (ml.Tensor, error) {
 	}
 
 	for i, layer := range m.Layers {
-		cacheType := i % 2
 		m.Cache.SetLayer(i)
-		wc := m.Cache.(*kvcache.WrapperCache)
-		wc.SetLayerType(cacheType)
 
 		var lastLayerOutputs ml.Tensor
 		if i == len(m.Layers)-1 {
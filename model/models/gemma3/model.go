@@ -76,8 +76,16 @@ func New(c ml.Config) (model.Model, error) {
 		},
 	}
 
+	// gemma attends to a sliding window in all but every sixth layer, which
+	// attends to the whole context
 	slidingWindowLen := int32(c.Uint("attention.sliding_window"))
-	m.Cache = kvcache.NewWrapperCache(kvcache.NewSWACache(slidingWindowLen, m.Shift), kvcache.NewCausalCache(m.Shift))
+	windowSizes := make([]int32, c.Uint("block_count"))
+	for i := range windowSizes {
+		if (i+1)%gemmaGlobalCacheCount != 0 {
+			windowSizes[i] = slidingWindowLen
+		}
+	}
+	m.Cache = kvcache.NewLayerWindowCache(windowSizes, m.Shift)
 
 	return &m, nil
 }
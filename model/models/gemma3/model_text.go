@@ -35,11 +35,6 @@ const (
 	gemma27BLayerCount    = 62
 )
 
-const (
-	cacheTypeSWA = iota
-	cacheTypeCausal
-)
-
 func newTextModel(c ml.Config) *TextModel {
 	numBlocks := int(c.Uint("block_count"))
 
@@ -187,17 +182,8 @@ func (m *TextModel) Forward(ctx ml.Context, inputs, positions, outputs ml.Tensor
 	}
 
 	for i, layer := range m.Layers {
-		// gemma alternates between the sliding window (local) and causal (global)
-		// kv cache every 6 layers
-		cacheType := cacheTypeSWA
-		if (i+1)%gemmaGlobalCacheCount == 0 {
-			cacheType = cacheTypeCausal
-		}
 		cache.SetLayer(i)
-		wc := cache.(*kvcache.WrapperCache)
-		wc.SetLayerType(cacheType)
-
-		if causal, ok := wc.UnderlyingCache().(*kvcache.Causal); ok {
+		if causal, ok := cache.(*kvcache.LayerWindowCache).UnderlyingCache().(*kvcache.Causal); ok {
 			causal.SetCausal(ctx, kvcache.CausalOptions{Except: except})
 		}
 
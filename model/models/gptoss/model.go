@@ -0,0 +1,269 @@
+package gptoss
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/ollama/ollama/kvcache"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/ml/nn"
+	"github.com/ollama/ollama/model"
+	"github.com/ollama/ollama/model/input"
+)
+
+type Options struct {
+	hiddenSize, headDim, numHeads, numKVHeads int
+	numExperts, numExpertsUsed                int
+	eps, ropeBase, ropeScale                  float32
+}
+
+type Model struct {
+	model.Base
+	model.BytePairEncoding
+
+	TokenEmbedding *nn.Embedding `gguf:"token_embd"`
+	Layers         []Layer       `gguf:"blk"`
+	OutputNorm     *nn.RMSNorm   `gguf:"output_norm"`
+	Output         *nn.Linear    `gguf:"output,alt:token_embd"`
+
+	*Options
+}
+
+func New(c ml.Config) (model.Model, error) {
+	if !strings.EqualFold(c.String("tokenizer.ggml.model"), "gpt2") {
+		return nil, fmt.Errorf("tokenizer %s not yet supported", c.String("tokenizer.ggml.model"))
+	}
+
+	eos := c.Uint("tokenizer.ggml.eos_token_id")
+	numHeads := int(c.Uint("attention.head_count"))
+	// heads are not a split of the hidden state
+	headDim := int(c.Uint("attention.key_length", c.Uint("embedding_length")/uint32(numHeads)))
+
+	m := Model{
+		BytePairEncoding: model.NewBytePairEncoding(
+			// the o200k tokenizer
+			c.String("tokenizer.ggml.pretokenizer", `[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+(?i:'s|'t|'re|'ve|'m|'ll|'d)?|[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*(?i:'s|'t|'re|'ve|'m|'ll|'d)?|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n/]*|\s*[\r\n]+|\s+(?!\S)|\s+`),
+			&model.Vocabulary{
+				Values: c.Strings("tokenizer.ggml.tokens"),
+				Types:  c.Uints("tokenizer.ggml.token_type"),
+				Merges: c.Strings("tokenizer.ggml.merges"),
+				BOS:    int32(c.Uint("tokenizer.ggml.bos_token_id")),
+				AddBOS: c.Bool("tokenizer.ggml.add_bos_token", false),
+				EOS:    int32(eos),
+				AddEOS: c.Bool("tokenizer.ggml.add_eos_token", false),
+				EOT:    int32(c.Uint("tokenizer.ggml.eot_token_id", eos)),
+			},
+		),
+		Layers: make([]Layer, c.Uint("block_count")),
+		Options: &Options{
+			hiddenSize:     int(c.Uint("embedding_length")),
+			headDim:        headDim,
+			numHeads:       numHeads,
+			numKVHeads:     int(c.Uint("attention.head_count_kv")),
+			numExperts:     int(c.Uint("expert_count")),
+			numExpertsUsed: int(c.Uint("expert_used_count")),
+			eps:            c.Float("attention.layer_norm_rms_epsilon"),
+			ropeBase:       c.Float("rope.freq_base", 150000),
+			// YaRN scaling of long contexts is not applied to the rotary embeddings
+			ropeScale: c.Float("rope.freq_scale", 1),
+		},
+	}
+
+	if m.numExperts == 0 || m.numExpertsUsed == 0 || m.numExpertsUsed > m.numExperts {
+		return nil, fmt.Errorf("invalid number of experts %d of which %d are used", m.numExperts, m.numExpertsUsed)
+	}
+
+	// layers alternate between a sliding window, starting with the first
+	// layer, and the full context
+	slidingWindowLen := int32(c.Uint("attention.sliding_window", 128))
+	windowSizes := make([]int32, len(m.Layers))
+	for i := range windowSizes {
+		if i%2 == 0 {
+			windowSizes[i] = slidingWindowLen
+		}
+	}
+	m.Cache = kvcache.NewLayerWindowCache(windowSizes, m.Shift)
+	m.Cache.SetConfig(ml.CacheConfig{})
+
+	return &m, nil
+}
+
+// SelfAttention has a learned sink per head: an extra logit that takes part
+// in the softmax without contributing a value, so that heads can attend to
+// nothing when none of the tokens in their window are relevant.
+type SelfAttention struct {
+	Query  *nn.Linear `gguf:"attn_q"`
+	Key    *nn.Linear `gguf:"attn_k"`
+	Value  *nn.Linear `gguf:"attn_v"`
+	Output *nn.Linear `gguf:"attn_output"`
+	Sinks  ml.Tensor  `gguf:"attn_sinks.weight"`
+}
+
+func (sa *SelfAttention) Forward(ctx ml.Context, hiddenState, positionIDs ml.Tensor, cache kvcache.Cache, opts *Options) ml.Tensor {
+	batchSize := hiddenState.Dim(1)
+
+	q := sa.Query.Forward(ctx, hiddenState)
+	q = q.Reshape(ctx, opts.headDim, opts.numHeads, batchSize)
+	q = q.RoPE(ctx, positionIDs, nil, uint32(opts.headDim), nn.RopeTypeNeox, opts.ropeBase, opts.ropeScale)
+
+	k := sa.Key.Forward(ctx, hiddenState)
+	k = k.Reshape(ctx, opts.headDim, opts.numKVHeads, batchSize)
+	k = k.RoPE(ctx, positionIDs, nil, uint32(opts.headDim), nn.RopeTypeNeox, opts.ropeBase, opts.ropeScale)
+
+	v := sa.Value.Forward(ctx, hiddenState)
+	v = v.Reshape(ctx, opts.headDim, opts.numKVHeads, batchSize)
+
+	cache.Put(ctx, k, v)
+	k, v, mask := cache.Get(ctx)
+
+	q = q.Permute(ctx, 0, 2, 1, 3)
+	k = k.Permute(ctx, 0, 2, 1, 3)
+	v = v.Permute(ctx, 1, 2, 0, 3).Contiguous(ctx)
+
+	kq := k.MulmatFullPrec(ctx, q)
+	kq = kq.Scale(ctx, 1.0/math.Sqrt(float64(opts.headDim)))
+	kq = kq.Add(ctx, mask)
+
+	// append the sink of each head as a last column of its logits, which is
+	// dropped again once the softmax has accounted for it
+	historySize := kq.Dim(0)
+	sinks := ctx.Input().Zeros(ml.DTypeF32, historySize, 1, opts.numHeads)
+	sinks = sinks.Concat(ctx, sa.Sinks.Reshape(ctx, 1, 1, opts.numHeads), 0)
+
+	kq = kq.Pad(ctx, 1, 0, 0, 0).Add(ctx, sinks)
+	kq = kq.Softmax(ctx)
+	kq = kq.View(ctx, 0, historySize, kq.Stride(1), batchSize, kq.Stride(2), opts.numHeads).Contiguous(ctx)
+
+	kqv := v.Mulmat(ctx, kq)
+	kqv = kqv.Permute(ctx, 0, 2, 1, 3).Contiguous(ctx)
+	kqv = kqv.Reshape(ctx, opts.headDim*opts.numHeads, batchSize)
+
+	return sa.Output.Forward(ctx, kqv)
+}
+
+func (m *Model) Shift(ctx ml.Context, layer int, key, shift ml.Tensor) (ml.Tensor, error) {
+	return key.RoPE(ctx, shift, nil, uint32(m.headDim), nn.RopeTypeNeox, m.ropeBase, m.ropeScale), nil
+}
+
+const (
+	// swigluLimit clamps the gate from above and the linear unit from both
+	// sides before they are combined
+	swigluLimit = 7.0
+
+	// swigluAlpha scales the gate inside of its sigmoid
+	swigluAlpha = 1.702
+)
+
+// SparseMLP routes each token to the experts with the highest logits and
+// sums their outputs weighted by the softmax of the logits of the selected
+// experts only.
+type SparseMLP struct {
+	Router *nn.Linear `gguf:"ffn_gate_inp"`
+
+	// Up, Gate and Down hold the weights of all experts, stacked along
+	// their outermost dimension, and their biases one expert per row
+	Up       ml.Tensor `gguf:"ffn_up_exps.weight"`
+	UpBias   ml.Tensor `gguf:"ffn_up_exps.bias"`
+	Gate     ml.Tensor `gguf:"ffn_gate_exps.weight"`
+	GateBias ml.Tensor `gguf:"ffn_gate_exps.bias"`
+	Down     ml.Tensor `gguf:"ffn_down_exps.weight"`
+	DownBias ml.Tensor `gguf:"ffn_down_exps.bias"`
+}
+
+func (mlp *SparseMLP) Forward(ctx ml.Context, hiddenState ml.Tensor, opts *Options) ml.Tensor {
+	hiddenSize, batchSize := hiddenState.Dim(0), hiddenState.Dim(1)
+
+	logits := mlp.Router.Forward(ctx, hiddenState)
+	experts := logits.TopK(ctx, opts.numExpertsUsed)
+	weights := logits.Reshape(ctx, 1, opts.numExperts, batchSize).Rows(ctx, experts)
+	weights = weights.Reshape(ctx, opts.numExpertsUsed, batchSize).Softmax(ctx)
+	weights = weights.Reshape(ctx, 1, opts.numExpertsUsed, batchSize)
+
+	// the biases of the selected experts, in the shape of the outputs of MulmatID
+	ids := experts.Contiguous(ctx).Reshape(ctx, opts.numExpertsUsed*batchSize)
+	bias := func(b ml.Tensor) ml.Tensor {
+		return b.Rows(ctx, ids).Reshape(ctx, b.Dim(0), opts.numExpertsUsed, batchSize)
+	}
+
+	hiddenState = hiddenState.Reshape(ctx, hiddenSize, 1, batchSize)
+	up := mlp.Up.MulmatID(ctx, hiddenState, experts).Add(ctx, bias(mlp.UpBias))
+	up = up.Clamp(ctx, -swigluLimit, swigluLimit)
+
+	gate := mlp.Gate.MulmatID(ctx, hiddenState, experts).Add(ctx, bias(mlp.GateBias))
+	gate = gate.Clamp(ctx, float32(math.Inf(-1)), swigluLimit)
+
+	// gate * sigmoid(alpha * gate) * (up + 1), with x * sigmoid(alpha * x) = silu(alpha * x) / alpha
+	glu := gate.Scale(ctx, swigluAlpha).SILU(ctx).Scale(ctx, 1/swigluAlpha)
+	hiddenState = glu.Mul(ctx, up).Add(ctx, glu)
+
+	hiddenState = mlp.Down.MulmatID(ctx, hiddenState, experts).Add(ctx, bias(mlp.DownBias))
+	hiddenState = hiddenState.Mul(ctx, weights)
+
+	out := hiddenState.View(ctx, 0, hiddenSize, hiddenState.Stride(2), batchSize)
+	for i := 1; i < opts.numExpertsUsed; i++ {
+		out = out.Add(ctx, hiddenState.View(ctx, i*hiddenState.Stride(1), hiddenSize, hiddenState.Stride(2), batchSize))
+	}
+
+	return out
+}
+
+type Layer struct {
+	AttentionNorm *nn.RMSNorm `gguf:"attn_norm"`
+	SelfAttention *SelfAttention
+	MLPNorm       *nn.RMSNorm `gguf:"post_attention_norm"`
+	MLP           *SparseMLP
+}
+
+func (l *Layer) Forward(ctx ml.Context, hiddenState, positionIDs, outputs ml.Tensor, cache kvcache.Cache, opts *Options) ml.Tensor {
+	residual := hiddenState
+
+	hiddenState = l.AttentionNorm.Forward(ctx, hiddenState, opts.eps)
+	hiddenState = l.SelfAttention.Forward(ctx, hiddenState, positionIDs, cache, opts)
+
+	// In the final layer (outputs != nil), optimize by pruning to just the token positions
+	// we need logits for.
+	if outputs != nil {
+		hiddenState = hiddenState.Rows(ctx, outputs)
+		residual = residual.Rows(ctx, outputs)
+	}
+
+	hiddenState = hiddenState.Add(ctx, residual)
+	residual = hiddenState
+
+	hiddenState = l.MLPNorm.Forward(ctx, hiddenState, opts.eps)
+	hiddenState = l.MLP.Forward(ctx, hiddenState, opts)
+	return hiddenState.Add(ctx, residual)
+}
+
+func (m *Model) Forward(ctx ml.Context, batch input.Batch) (ml.Tensor, error) {
+	positions, err := ctx.Input().FromIntSlice(batch.Positions, len(batch.Positions))
+	if err != nil {
+		return nil, err
+	}
+
+	outputs, err := ctx.Input().FromIntSlice(batch.Outputs, len(batch.Outputs))
+	if err != nil {
+		return nil, err
+	}
+
+	hiddenState := m.TokenEmbedding.Forward(ctx, batch.Inputs)
+
+	for i, layer := range m.Layers {
+		m.Cache.SetLayer(i)
+
+		var lastLayerOutputs ml.Tensor
+		if i == len(m.Layers)-1 {
+			lastLayerOutputs = outputs
+		}
+
+		hiddenState = layer.Forward(ctx, hiddenState, positions, lastLayerOutputs, m.Cache, m.Options)
+	}
+
+	hiddenState = m.OutputNorm.Forward(ctx, hiddenState, m.eps)
+	return m.Output.Forward(ctx, hiddenState), nil
+}
+
+func init() {
+	model.Register("gpt-oss", New)
+}
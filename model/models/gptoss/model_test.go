@@ -0,0 +1,67 @@
+package gptoss
+
+import (
+	"fmt"
+	"maps"
+	"testing"
+
+	fs "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/model/models/internal/modeltest"
+)
+
+func TestForward(t *testing.T) {
+	kv := fs.KV{
+		"general.architecture":                     "gpt-oss",
+		"gpt-oss.block_count":                      uint32(2),
+		"gpt-oss.embedding_length":                 uint32(16),
+		"gpt-oss.feed_forward_length":              uint32(8),
+		"gpt-oss.expert_count":                     uint32(4),
+		"gpt-oss.expert_used_count":                uint32(2),
+		"gpt-oss.attention.head_count":             uint32(4),
+		"gpt-oss.attention.head_count_kv":          uint32(2),
+		"gpt-oss.attention.key_length":             uint32(6),
+		"gpt-oss.attention.value_length":           uint32(6),
+		"gpt-oss.attention.sliding_window":         uint32(1),
+		"gpt-oss.attention.layer_norm_rms_epsilon": float32(1e-5),
+		"gpt-oss.rope.freq_base":                   float32(10000),
+	}
+	maps.Copy(kv, modeltest.Vocabulary(16))
+
+	tensors := []modeltest.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{16, 16}},
+		{Name: "output_norm.weight", Shape: []uint64{16}},
+		{Name: "output.weight", Shape: []uint64{16, 16}},
+	}
+
+	for i := range 2 {
+		tensors = append(tensors,
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_norm.weight", i), Shape: []uint64{16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_q.weight", i), Shape: []uint64{24, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_q.bias", i), Shape: []uint64{24}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_k.weight", i), Shape: []uint64{12, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_k.bias", i), Shape: []uint64{12}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_v.weight", i), Shape: []uint64{12, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_v.bias", i), Shape: []uint64{12}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_output.weight", i), Shape: []uint64{16, 24}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_output.bias", i), Shape: []uint64{16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_sinks.weight", i), Shape: []uint64{4}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.post_attention_norm.weight", i), Shape: []uint64{16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_gate_inp.weight", i), Shape: []uint64{4, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_gate_inp.bias", i), Shape: []uint64{4}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_gate_exps.weight", i), Shape: []uint64{4, 8, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_gate_exps.bias", i), Shape: []uint64{4, 8}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_up_exps.weight", i), Shape: []uint64{4, 8, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_up_exps.bias", i), Shape: []uint64{4, 8}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_down_exps.weight", i), Shape: []uint64{4, 16, 8}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_down_exps.bias", i), Shape: []uint64{4, 16}},
+		)
+	}
+
+	// computed by reference.py in the modeltest package
+	modeltest.Compare(t, modeltest.Forward(t, kv, tensors, []int32{1, 5, 2, 7}), []float32{
+		0.233427, 0.571252, -0.00149348, -0.571858, -0.230686, 0.478197, 0.424839, -0.305708, -0.54896, 0.0828253, 0.582588, 0.153711, -0.520179, -0.364909, 0.372023, 0.515954,
+		-0.30499, 0.854422, 0.651893, -0.589747, -0.891336, 0.227856, 0.983848, 0.171596, -0.914178, -0.542761, 0.693812, 0.824456, -0.359075, -0.970244, -0.0348536, 0.956093,
+		1.3798, 0.498033, -1.17759, -0.976147, 0.781268, 1.29335, -0.256156, -1.39735, -0.311183, 1.27101, 0.827224, -0.935147, -1.2069, 0.445133, 1.38763, 0.118259,
+		0.644786, -0.717382, -0.93605, 0.337337, 1.07301, 0.0983167, -1.03309, -0.517763, 0.822878, 0.851859, -0.477014, -1.04553, 0.0525182, 1.06685, 0.380635, -0.912313,
+	})
+}
@@ -0,0 +1,57 @@
+package granite
+
+import (
+	"fmt"
+	"maps"
+	"testing"
+
+	fs "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/model/models/internal/modeltest"
+)
+
+func TestForward(t *testing.T) {
+	// computed by reference.py in the modeltest package
+	want := []float32{
+		-0.751784, -0.214239, 0.664801, 0.484155, -0.468229, -0.674261, 0.194473, 0.753219, 0.111341, -0.708013, -0.398801, 0.546096, 0.620521, -0.294158, -0.739952, -0.00626978,
+		-0.460514, 0.186996, 0.536437, 0.0308026, -0.52393, -0.243523, 0.425058, 0.416101, -0.256116, -0.520087, 0.0449562, 0.538339, 0.173615, -0.46785, -0.363566, 0.320239,
+		-0.0243754, -0.558431, -0.202353, 0.476274, 0.395725, -0.315606, -0.523864, 0.102912, 0.565647, 0.126746, -0.514187, -0.335511, 0.377966, 0.488969, -0.17944, -0.561824,
+		0.182888, -0.505756, -0.38823, 0.348131, 0.529575, -0.133118, -0.583622, -0.103838, 0.541463, 0.323677, -0.410047, -0.49016, 0.211037, 0.575844, 0.0227609, -0.566602,
+	}
+
+	kv := fs.KV{
+		"general.architecture":                     "granite",
+		"granite.block_count":                      uint32(2),
+		"granite.embedding_length":                 uint32(16),
+		"granite.feed_forward_length":              uint32(24),
+		"granite.attention.head_count":             uint32(4),
+		"granite.attention.head_count_kv":          uint32(2),
+		"granite.attention.layer_norm_rms_epsilon": float32(1e-6),
+		"granite.attention.scale":                  float32(0.25),
+		"granite.embedding_scale":                  float32(2),
+		"granite.residual_scale":                   float32(0.5),
+		"granite.logit_scale":                      float32(4),
+	}
+	maps.Copy(kv, modeltest.Vocabulary(16))
+
+	tensors := []modeltest.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{16, 16}},
+		{Name: "output_norm.weight", Shape: []uint64{16}},
+		{Name: "output.weight", Shape: []uint64{16, 16}},
+	}
+
+	for i := range 2 {
+		tensors = append(tensors,
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_norm.weight", i), Shape: []uint64{16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_q.weight", i), Shape: []uint64{16, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_k.weight", i), Shape: []uint64{8, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_v.weight", i), Shape: []uint64{8, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_output.weight", i), Shape: []uint64{16, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_norm.weight", i), Shape: []uint64{16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_gate.weight", i), Shape: []uint64{24, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_up.weight", i), Shape: []uint64{24, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_down.weight", i), Shape: []uint64{16, 24}},
+		)
+	}
+
+	modeltest.Compare(t, modeltest.Forward(t, kv, tensors, []int32{1, 5, 2, 7}), want)
+}
@@ -0,0 +1,201 @@
+// Package modeltest runs tiny models with deterministic weights so that
+// their outputs can be compared to references computed independently of the
+// engine, by the float64 implementations of the architectures in
+// reference.py.
+package modeltest
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	fs "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/model"
+	"github.com/ollama/ollama/model/input"
+)
+
+// Tensor is a tensor of a model file with its shape written outermost
+// first, e.g. [out, in] for the weight of a linear layer.
+type Tensor struct {
+	Name  string
+	Shape []uint64
+}
+
+// Values returns n deterministic values for the tensor name. The weights of
+// normalization layers are close to 1 and all others are small, so that the
+// activations of a few layers stay in a reasonable range.
+func Values(name string, n int) []float32 {
+	phase := float64(crc32.ChecksumIEEE([]byte(name))) / math.MaxUint32 * 2 * math.Pi
+
+	s := make([]float32, n)
+	for i := range s {
+		v := math.Sin(phase + 0.7*float64(i))
+		if strings.Contains(name, "norm") && strings.HasSuffix(name, ".weight") {
+			s[i] = float32(1 + 0.1*v)
+		} else {
+			s[i] = float32(0.5 * v)
+		}
+	}
+
+	return s
+}
+
+// Vocabulary returns the tokenizer metadata of a vocabulary of n tokens.
+func Vocabulary(n int) fs.KV {
+	tokens := make([]string, n)
+	types := make([]int32, n)
+	scores := make([]float32, n)
+	for i := range tokens {
+		tokens[i] = string(rune('a' + i))
+		types[i] = 1
+		scores[i] = float32(-i)
+	}
+
+	return fs.KV{
+		"tokenizer.ggml.model":      "gpt2",
+		"tokenizer.ggml.tokens":     tokens,
+		"tokenizer.ggml.token_type": types,
+		"tokenizer.ggml.scores":     scores,
+	}
+}
+
+// Forward writes a model file with kv and tensors filled by [Values], loads
+// it and returns the logits of all inputs, processed in one batch of a
+// single sequence.
+func Forward(t *testing.T, kv fs.KV, tensors []Tensor, inputs []int32) []float32 {
+	t.Helper()
+
+	ins := make([]input.Input, len(inputs))
+	for i, token := range inputs {
+		ins[i] = input.Input{Token: token}
+	}
+
+	return Run(t, Load(t, kv, tensors), ins)
+}
+
+// Load writes a model file with kv and tensors filled by [Values] and loads
+// it.
+func Load(t *testing.T, kv fs.KV, tensors []Tensor) model.Model {
+	t.Helper()
+
+	var ts []fs.Tensor
+	for _, tensor := range tensors {
+		n := 1
+		for _, d := range tensor.Shape {
+			n *= int(d)
+		}
+
+		var b bytes.Buffer
+		if err := binary.Write(&b, binary.LittleEndian, Values(tensor.Name, n)); err != nil {
+			t.Fatal(err)
+		}
+
+		ts = append(ts, fs.Tensor{Name: tensor.Name, Kind: 0, Shape: tensor.Shape, WriterTo: bytes.NewReader(b.Bytes())})
+	}
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "model.gguf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := fs.WriteGGUF(f, kv, ts); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := model.New(context.Background(), f.Name(), ml.BackendParams{NumThreads: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+// Run returns the logits of all inputs of m, including those with multimodal
+// data, processed in one batch of a single sequence.
+func Run(t *testing.T, m model.Model, inputs []input.Input) []float32 {
+	t.Helper()
+
+	cache := m.Config().Cache
+	cache.Init(m.Backend(), ml.DTypeF32, 1, len(inputs), len(inputs))
+	defer cache.Close()
+
+	ctx := m.Backend().NewContext()
+	defer ctx.Close()
+
+	tokens := make([]int32, len(inputs))
+	batch := input.Batch{
+		Positions: make([]int32, len(inputs)),
+		Sequences: make([]int, len(inputs)),
+		Outputs:   make([]int32, len(inputs)),
+	}
+	for i, inp := range inputs {
+		tokens[i] = inp.Token
+		batch.Positions[i] = int32(i)
+		batch.Outputs[i] = int32(i)
+		if inp.Multimodal != nil {
+			batch.Multimodal = append(batch.Multimodal, input.MultimodalIndex{Index: i, Multimodal: inp.Multimodal})
+		}
+	}
+
+	logits, err := model.Forward(ctx, m, tokens, batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return slices.Clone(logits.Floats())
+}
+
+// Embed returns the pooled outputs of sequences, processed together in one
+// batch by a model without a cache, such as the embeddings of an encoder-only
+// model or the label scores of its classification head. As in the runner,
+// the output of each sequence is its last input.
+func Embed(t *testing.T, m model.Model, sequences [][]int32) []float32 {
+	t.Helper()
+
+	ctx := m.Backend().NewContext()
+	defer ctx.Close()
+
+	var tokens []int32
+	var batch input.Batch
+	for seq, inputs := range sequences {
+		for i, token := range inputs {
+			tokens = append(tokens, token)
+			batch.Positions = append(batch.Positions, int32(i))
+			batch.Sequences = append(batch.Sequences, seq)
+		}
+
+		batch.Outputs = append(batch.Outputs, int32(len(tokens)-1))
+	}
+
+	outputs, err := model.Forward(ctx, m, tokens, batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return slices.Clone(outputs.Floats())
+}
+
+// Compare reports the values of got that differ from want by more than an
+// absolute and relative tolerance of float32 accumulations.
+func Compare(t *testing.T, got, want []float32) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("expected %d values, got %d", len(want), len(got))
+	}
+
+	for i := range want {
+		if diff := math.Abs(float64(got[i] - want[i])); diff > 1e-4+1e-3*math.Abs(float64(want[i])) {
+			t.Errorf("value %d: expected %v, got %v", i, want[i], got[i])
+		}
+	}
+}
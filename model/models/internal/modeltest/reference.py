@@ -0,0 +1,581 @@
+#!/usr/bin/env python3
+"""Reference implementations of the architectures tested with modeltest.
+
+The models are tiny and their weights are those of modeltest.Values, so the
+logits can be computed in float64 with plain Python and compared to those of
+the engine. Run this script to print the references of the tests:
+
+    python3 model/models/internal/modeltest/reference.py
+"""
+
+import math
+import struct
+import zlib
+
+
+def f32(x):
+    return struct.unpack('<f', struct.pack('<f', x))[0]
+
+
+def values(name, n):
+    phase = zlib.crc32(name.encode()) / 0xFFFFFFFF * 2 * math.pi
+    norm = 'norm' in name and name.endswith('.weight')
+    return [f32(1 + 0.1 * math.sin(phase + 0.7 * i)) if norm else f32(0.5 * math.sin(phase + 0.7 * i)) for i in range(n)]
+
+
+def vector(name, n):
+    return values(name, n)
+
+
+def matrix(name, rows, cols):
+    v = values(name, rows * cols)
+    return [v[r * cols:(r + 1) * cols] for r in range(rows)]
+
+
+def linear(w, x, b=None):
+    y = [sum(wi * xi for wi, xi in zip(row, x)) for row in w]
+    if b is not None:
+        y = [yi + bi for yi, bi in zip(y, b)]
+    return y
+
+
+def add(a, b):
+    return [x + y for x, y in zip(a, b)]
+
+
+def rms_norm(x, w, eps):
+    scale = 1 / math.sqrt(sum(v * v for v in x) / len(x) + eps)
+    return [v * scale * wi for v, wi in zip(x, w)]
+
+
+def layer_norm(x, w, b, eps):
+    mean = sum(x) / len(x)
+    var = sum((v - mean) ** 2 for v in x) / len(x)
+    scale = 1 / math.sqrt(var + eps)
+    return [(v - mean) * scale * wi + bi for v, wi, bi in zip(x, w, b)]
+
+
+def softmax(x):
+    m = max(x)
+    e = [math.exp(v - m) for v in x]
+    s = sum(e)
+    return [v / s for v in e]
+
+
+def silu(x):
+    return x / (1 + math.exp(-x))
+
+
+def rope_neox(x, pos, dims, base):
+    half = dims // 2
+    out = list(x)
+    for i in range(half):
+        theta = pos * base ** (-2 * i / dims)
+        c, s = math.cos(theta), math.sin(theta)
+        out[i] = x[i] * c - x[i + half] * s
+        out[i + half] = x[i] * s + x[i + half] * c
+    return out
+
+
+def rope_norm(x, pos, dims, base):
+    out = list(x)
+    for i in range(dims // 2):
+        theta = pos * base ** (-2 * i / dims)
+        c, s = math.cos(theta), math.sin(theta)
+        out[2 * i] = x[2 * i] * c - x[2 * i + 1] * s
+        out[2 * i + 1] = x[2 * i] * s + x[2 * i + 1] * c
+    return out
+
+
+def heads(x, head_dim):
+    return [x[i:i + head_dim] for i in range(0, len(x), head_dim)]
+
+
+def f16(x):
+    return struct.unpack('<e', struct.pack('<e', x))[0]
+
+
+def gelu(x):
+    """The tanh approximation of GELU, which ggml looks up in a table of
+    half precision values on the CPU."""
+    if abs(x) >= 10:
+        return max(x, 0)
+    x = f16(x)
+    return f16(0.5 * x * (1 + math.tanh(math.sqrt(2 / math.pi) * (x + 0.044715 * x ** 3))))
+
+
+def attention(qs, ks, vs, num_heads, num_kv_heads, window=None, scale=None):
+    """qs, ks and vs are per token lists of per head vectors. With a window,
+    each token only attends to that many tokens before it. The scores are
+    scaled by 1/sqrt(head_dim) unless scale is given."""
+    head_dim = len(qs[0][0])
+    scale = scale or 1 / math.sqrt(head_dim)
+    group = num_heads // num_kv_heads
+    out = []
+    for t, q in enumerate(qs):
+        o = []
+        for h in range(num_heads):
+            kv = h // group
+            js = [j for j in range(t + 1) if window is None or j >= t - window]
+            scores = [sum(a * b for a, b in zip(q[h], ks[j][kv])) * scale for j in js]
+            p = softmax(scores)
+            o += [sum(pr * vs[j][kv][d] for pr, j in zip(p, js)) for d in range(head_dim)]
+        out.append(o)
+    return out
+
+
+def self_attention(xs, prefix, cfg, qk_norm=None, bias=False, output_bias=False):
+    hidden, num_heads, num_kv_heads = cfg['hidden'], cfg['heads'], cfg['kv_heads']
+    head_dim = cfg.get('head_dim', hidden // num_heads)
+    q_dim, kv_dim = head_dim * num_heads, head_dim * num_kv_heads
+
+    def proj(name, rows):
+        w = matrix(f'{prefix}.{name}.weight', rows, hidden)
+        b = vector(f'{prefix}.{name}.bias', rows) if bias else None
+        return [linear(w, x, b) for x in xs]
+
+    q, k, v = proj('attn_q', q_dim), proj('attn_k', kv_dim), proj('attn_v', kv_dim)
+    if qk_norm:
+        q = [qk_norm(x, vector(f'{prefix}.attn_q_norm.weight', q_dim)) for x in q]
+        k = [qk_norm(x, vector(f'{prefix}.attn_k_norm.weight', kv_dim)) for x in k]
+
+    dims = cfg.get('rope_dim', head_dim)
+    rope = rope_norm if cfg.get('rope_norm') else rope_neox
+    q = [[rope(h, t, dims, 10000) for h in heads(x, head_dim)] for t, x in enumerate(q)]
+    k = [[rope(h, t, dims, 10000) for h in heads(x, head_dim)] for t, x in enumerate(k)]
+    v = [heads(x, head_dim) for x in v]
+
+    o = matrix(f'{prefix}.attn_output.weight', hidden, q_dim)
+    ob = vector(f'{prefix}.attn_output.bias', hidden) if output_bias else None
+    return [linear(o, x, ob) for x in attention(q, k, v, num_heads, num_kv_heads, cfg.get('window'), cfg.get('scale'))]
+
+
+def swiglu(x, prefix, hidden, ff, suffix=''):
+    gate = linear(matrix(f'{prefix}.ffn_gate{suffix}.weight', ff, hidden), x)
+    up = linear(matrix(f'{prefix}.ffn_up{suffix}.weight', ff, hidden), x)
+    return linear(matrix(f'{prefix}.ffn_down{suffix}.weight', hidden, ff), [silu(g) * u for g, u in zip(gate, up)])
+
+
+def logits(xs, cfg, norm):
+    w = matrix('output.weight', cfg['vocab'], cfg['hidden'])
+    return [v for x in xs for v in linear(w, norm(x))]
+
+
+def embed(inputs, cfg):
+    w = matrix('token_embd.weight', cfg['vocab'], cfg['hidden'])
+    return [list(w[i]) for i in inputs]
+
+
+def olmo2(inputs, cfg):
+    eps = cfg['eps']
+    norm = lambda x, w: rms_norm(x, w, eps)
+    xs = embed(inputs, cfg)
+    for i in range(cfg['layers']):
+        p = f'blk.{i}'
+        a = self_attention(xs, p, cfg, qk_norm=norm)
+        xs = [add(x, norm(y, vector(f'{p}.post_attention_norm.weight', cfg['hidden']))) for x, y in zip(xs, a)]
+        xs = [add(x, norm(swiglu(x, p, cfg['hidden'], cfg['ff']), vector(f'{p}.post_ffw_norm.weight', cfg['hidden']))) for x in xs]
+    return logits(xs, cfg, lambda x: norm(x, vector('output_norm.weight', cfg['hidden'])))
+
+
+def experts(x, p, cfg):
+    hidden, ff, n = cfg['hidden'], cfg['ff'], cfg['experts']
+    logits = linear(matrix(f'{p}.ffn_gate_inp.weight', n, hidden), x)
+    probs = softmax(logits)
+    selected = sorted(range(n), key=lambda e: -probs[e])[:cfg['experts_used']]
+    weights = dict(zip(selected, softmax([logits[e] for e in selected]))) if cfg.get('norm_top_k') else probs
+
+    gate = values(f'{p}.ffn_gate_exps.weight', n * ff * hidden)
+    up = values(f'{p}.ffn_up_exps.weight', n * ff * hidden)
+    down = values(f'{p}.ffn_down_exps.weight', n * hidden * ff)
+
+    out = [0.0] * hidden
+    for e in selected:
+        g = linear([gate[(e * ff + r) * hidden:(e * ff + r + 1) * hidden] for r in range(ff)], x)
+        u = linear([up[(e * ff + r) * hidden:(e * ff + r + 1) * hidden] for r in range(ff)], x)
+        d = linear([down[(e * hidden + r) * ff:(e * hidden + r + 1) * ff] for r in range(hidden)], [silu(a) * b for a, b in zip(g, u)])
+        out = [o + weights[e] * v for o, v in zip(out, d)]
+
+    if 'shared_ff' in cfg:
+        shared = swiglu(x, p, hidden, cfg['shared_ff'], suffix='_shexp')
+        g = sum(a * b for a, b in zip(vector(f'{p}.ffn_gate_inp_shexp.weight', hidden), x))
+        out = add(out, [v / (1 + math.exp(-g)) for v in shared])
+    return out
+
+
+def olmoe(inputs, cfg, qk_norm=True, bias=False):
+    eps = cfg['eps']
+    norm = lambda x, w: rms_norm(x, w, eps)
+    xs = embed(inputs, cfg)
+    for i in range(cfg['layers']):
+        p = f'blk.{i}'
+        h = [norm(x, vector(f'{p}.attn_norm.weight', cfg['hidden'])) for x in xs]
+        xs = [add(x, y) for x, y in zip(xs, self_attention(h, p, cfg, qk_norm=norm if qk_norm else None, bias=bias))]
+        xs = [add(x, experts(norm(x, vector(f'{p}.ffn_norm.weight', cfg['hidden'])), p, cfg)) for x in xs]
+    return logits(xs, cfg, lambda x: norm(x, vector('output_norm.weight', cfg['hidden'])))
+
+
+def qwen2moe(inputs, cfg):
+    return olmoe(inputs, cfg, qk_norm=False, bias=True)
+
+
+def mixtral(inputs, cfg):
+    return olmoe(inputs, cfg, qk_norm=False)
+
+
+def nemotron(inputs, cfg):
+    eps, hidden, ff = cfg['eps'], cfg['hidden'], cfg['ff']
+
+    def norm(x, name):
+        return layer_norm(x, vector(f'{name}.weight', hidden), vector(f'{name}.bias', hidden), eps)
+
+    xs = embed(inputs, cfg)
+    for i in range(cfg['layers']):
+        p = f'blk.{i}'
+        h = [norm(x, f'{p}.attn_norm') for x in xs]
+        xs = [add(x, y) for x, y in zip(xs, self_attention(h, p, cfg, bias=True))]
+        for j, x in enumerate(xs):
+            up = linear(matrix(f'{p}.ffn_up.weight', ff, hidden), norm(x, f'{p}.ffn_norm'))
+            xs[j] = add(x, linear(matrix(f'{p}.ffn_down.weight', hidden, ff), [max(u, 0) ** 2 for u in up]))
+    return logits(xs, cfg, lambda x: norm(x, 'output_norm'))
+
+
+def gptoss(inputs, cfg):
+    eps, hidden, head_dim, n = cfg['eps'], cfg['hidden'], cfg['head_dim'], cfg['experts']
+    num_heads, num_kv_heads = cfg['heads'], cfg['kv_heads']
+    q_dim, kv_dim, ff, limit, alpha = num_heads * head_dim, num_kv_heads * head_dim, cfg['ff'], 7.0, 1.702
+    norm = lambda x, w: rms_norm(x, w, eps)
+
+    def attention_with_sinks(xs, p, window):
+        def proj(name, rows):
+            return [linear(matrix(f'{p}.{name}.weight', rows, hidden), x, vector(f'{p}.{name}.bias', rows)) for x in xs]
+
+        q, k, v = proj('attn_q', q_dim), proj('attn_k', kv_dim), proj('attn_v', kv_dim)
+        q = [[rope_neox(h, t, head_dim, cfg['rope_base']) for h in heads(x, head_dim)] for t, x in enumerate(q)]
+        k = [[rope_neox(h, t, head_dim, cfg['rope_base']) for h in heads(x, head_dim)] for t, x in enumerate(k)]
+        v = [heads(x, head_dim) for x in v]
+        sinks = vector(f'{p}.attn_sinks.weight', num_heads)
+
+        out = []
+        for t in range(len(xs)):
+            o = []
+            for h in range(num_heads):
+                kv = h // (num_heads // num_kv_heads)
+                js = [j for j in range(t + 1) if j >= t - window]
+                scores = [sum(a * b for a, b in zip(q[t][h], k[j][kv])) / math.sqrt(head_dim) for j in js]
+                probs = softmax(scores + [sinks[h]])
+                o += [sum(pr * v[j][kv][d] for pr, j in zip(probs, js)) for d in range(head_dim)]
+            out.append(linear(matrix(f'{p}.attn_output.weight', hidden, q_dim), o, vector(f'{p}.attn_output.bias', hidden)))
+        return out
+
+    def experts(x, p):
+        router = linear(matrix(f'{p}.ffn_gate_inp.weight', n, hidden), x, vector(f'{p}.ffn_gate_inp.bias', n))
+        selected = sorted(range(n), key=lambda e: -router[e])[:cfg['experts_used']]
+        weights = softmax([router[e] for e in selected])
+
+        w = {name: values(f'{p}.ffn_{name}_exps.weight', n * ff * hidden) for name in ('gate', 'up', 'down')}
+        b = {name: values(f'{p}.ffn_{name}_exps.bias', n * (hidden if name == 'down' else ff)) for name in ('gate', 'up', 'down')}
+
+        out = [0.0] * hidden
+        for e, we in zip(selected, weights):
+            g = linear([w['gate'][(e * ff + r) * hidden:(e * ff + r + 1) * hidden] for r in range(ff)], x, b['gate'][e * ff:(e + 1) * ff])
+            u = linear([w['up'][(e * ff + r) * hidden:(e * ff + r + 1) * hidden] for r in range(ff)], x, b['up'][e * ff:(e + 1) * ff])
+            g = [min(a, limit) for a in g]
+            u = [min(max(a, -limit), limit) for a in u]
+            act = [a / (1 + math.exp(-alpha * a)) * (c + 1) for a, c in zip(g, u)]
+            d = linear([w['down'][(e * hidden + r) * ff:(e * hidden + r + 1) * ff] for r in range(hidden)], act, b['down'][e * hidden:(e + 1) * hidden])
+            out = [o + we * y for o, y in zip(out, d)]
+        return out
+
+    xs = embed(inputs, cfg)
+    for i in range(cfg['layers']):
+        p = f'blk.{i}'
+        window = cfg['window'] if i % 2 == 0 else len(inputs)
+        h = [norm(x, vector(f'{p}.attn_norm.weight', hidden)) for x in xs]
+        xs = [add(x, y) for x, y in zip(xs, attention_with_sinks(h, p, window))]
+        xs = [add(x, experts(norm(x, vector(f'{p}.post_attention_norm.weight', hidden)), p)) for x in xs]
+    return logits(xs, cfg, lambda x: norm(x, vector('output_norm.weight', hidden)))
+
+
+def granite(inputs, cfg):
+    eps, hidden = cfg['eps'], cfg['hidden']
+    norm = lambda x, w: rms_norm(x, w, eps)
+    residual = lambda x, y: [a + b * cfg['residual_scale'] for a, b in zip(x, y)]
+    xs = [[v * cfg['embedding_scale'] for v in x] for x in embed(inputs, cfg)]
+    for i in range(cfg['layers']):
+        p = f'blk.{i}'
+        h = [norm(x, vector(f'{p}.attn_norm.weight', hidden)) for x in xs]
+        xs = [residual(x, y) for x, y in zip(xs, self_attention(h, p, cfg))]
+        xs = [residual(x, swiglu(norm(x, vector(f'{p}.ffn_norm.weight', hidden)), p, hidden, cfg['ff'])) for x in xs]
+    return [v / cfg['logit_scale'] for v in logits(xs, cfg, lambda x: norm(x, vector('output_norm.weight', hidden)))]
+
+
+def starcoder2(inputs, cfg):
+    eps, hidden, ff = cfg['eps'], cfg['hidden'], cfg['ff']
+
+    def norm(x, name):
+        return layer_norm(x, vector(f'{name}.weight', hidden), vector(f'{name}.bias', hidden), eps)
+
+    xs = embed(inputs, cfg)
+    if cfg.get('position_embd'):
+        w = matrix('position_embd.weight', cfg['context'], hidden)
+        xs = [add(x, w[t]) for t, x in enumerate(xs)]
+
+    for i in range(cfg['layers']):
+        p = f'blk.{i}'
+        h = [norm(x, f'{p}.attn_norm') for x in xs]
+        xs = [add(x, y) for x, y in zip(xs, self_attention(h, p, cfg, bias=True, output_bias=True))]
+        for j, x in enumerate(xs):
+            up = linear(matrix(f'{p}.ffn_up.weight', ff, hidden), norm(x, f'{p}.ffn_norm'), vector(f'{p}.ffn_up.bias', ff))
+            xs[j] = add(x, linear(matrix(f'{p}.ffn_down.weight', hidden, ff), [gelu(u) for u in up], vector(f'{p}.ffn_down.bias', hidden)))
+    return logits(xs, cfg, lambda x: norm(x, 'output_norm'))
+
+
+def commandr(inputs, cfg):
+    eps, hidden, head_dim = cfg['eps'], cfg['hidden'], cfg['hidden'] // cfg['heads']
+
+    # Cohere's layer norms have no biases
+    def norm(x, w):
+        return layer_norm(x, w, [0.0] * len(x), eps)
+
+    def qk_norm(x, w):
+        return [v for h, wh in zip(heads(x, head_dim), heads(w, head_dim)) for v in norm(h, wh)]
+
+    xs = embed(inputs, cfg)
+    for i in range(cfg['layers']):
+        p = f'blk.{i}'
+        h = [norm(x, vector(f'{p}.attn_norm.weight', hidden)) for x in xs]
+        a = self_attention(h, p, cfg, qk_norm=qk_norm if cfg.get('qk_norm') else None)
+        xs = [add(add(x, y), swiglu(z, p, hidden, cfg['ff'])) for x, y, z in zip(xs, a, h)]
+    return [v * cfg['logit_scale'] for v in logits(xs, cfg, lambda x: norm(x, vector('output_norm.weight', hidden)))]
+
+
+def pixtral_image(width, height):
+    """The pixels of the test image of pixtral as rows of (r, g, b)."""
+    return [[(20 + 50 * x + 10 * y, 200 - 30 * y - 15 * x, 37 * (x + 4 * y) % 256) for x in range(width)] for y in range(height)]
+
+
+def pixtral(inputs, cfg):
+    vis, hidden, eps = cfg['vision'], cfg['hidden'], cfg['eps']
+    vh, patch, head_dim = vis['hidden'], vis['patch'], vis['hidden'] // vis['heads']
+    norm = lambda x, w: rms_norm(x, w, eps)
+    vnorm = lambda x, w: rms_norm(x, w, vis['eps'])
+
+    # the image is normalized with the mean and standard deviation of CLIP
+    mean, std = (0.48145466, 0.4578275, 0.40821073), (0.26862954, 0.26130258, 0.27577711)
+    pixels = pixtral_image(vis['width'], vis['height'])
+    image = [[[(f32(px[c] / 255) - mean[c]) / std[c] for px in row] for row in pixels] for c in range(3)]
+
+    rows, cols = vis['height'] // patch, vis['width'] // patch
+    conv = values('v.patch_embd.weight', vh * 3 * patch * patch)
+    xs = []
+    for r in range(rows):
+        for c in range(cols):
+            x = [sum(conv[((o * 3 + ch) * patch + ky) * patch + kx] * image[ch][r * patch + ky][c * patch + kx]
+                     for ch in range(3) for ky in range(patch) for kx in range(patch)) for o in range(vh)]
+            xs.append(vnorm(x, vector('v.pre_ln.weight', vh)))
+
+    # rows rotate the first half of the frequencies and columns the second
+    freqs = [vis['rope_base'] ** (-2 * i / head_dim) for i in range(head_dim // 2)]
+    angles = []
+    for r in range(rows):
+        for c in range(cols):
+            a = [r * freqs[2 * i] for i in range(head_dim // 4)] + [c * freqs[2 * i + 1] for i in range(head_dim // 4)]
+            angles.append(a + a)
+
+    def rotate(h, a):
+        half = len(h) // 2
+        rotated = [-v for v in h[half:]] + h[:half]
+        return [v * math.cos(t) + w * math.sin(t) for v, w, t in zip(h, rotated, a)]
+
+    for i in range(vis['layers']):
+        p = f'v.blk.{i}'
+        h = [vnorm(x, vector(f'{p}.ln1.weight', vh)) for x in xs]
+        q, k, v = ([linear(matrix(f'{p}.attn_{n}.weight', vh, vh), x) for x in h] for n in 'qkv')
+        q = [[rotate(y, a) for y in heads(x, head_dim)] for x, a in zip(q, angles)]
+        k = [[rotate(y, a) for y in heads(x, head_dim)] for x, a in zip(k, angles)]
+        v = [heads(x, head_dim) for x in v]
+
+        # patches attend to all of the others
+        out = []
+        for t in range(len(xs)):
+            o = []
+            for hd in range(vis['heads']):
+                probs = softmax([sum(a * b for a, b in zip(q[t][hd], k[j][hd])) / math.sqrt(head_dim) for j in range(len(xs))])
+                o += [sum(pr * v[j][hd][d] for j, pr in enumerate(probs)) for d in range(head_dim)]
+            out.append(linear(matrix(f'{p}.attn_out.weight', vh, vh), o))
+        xs = [add(x, y) for x, y in zip(xs, out)]
+        xs = [add(x, swiglu(vnorm(x, vector(f'{p}.ln2.weight', vh)), p, vh, vis['ff'])) for x in xs]
+
+    patches = [linear(matrix('mm.1.weight', hidden, vh), x, vector('mm.1.bias', hidden)) for x in xs]
+    patches = [linear(matrix('mm.2.weight', hidden, hidden), [gelu(v) for v in x], vector('mm.2.bias', hidden)) for x in patches]
+
+    # each row of patches is followed by [IMG_BREAK], the last by [IMG_END]
+    tokens, embeddings = [], {}
+    for t in inputs:
+        if t != 'image':
+            tokens.append(t)
+            continue
+        for r in range(rows):
+            for c in range(cols):
+                embeddings[len(tokens)] = patches[r * cols + c]
+                tokens.append(cfg['img'])
+            tokens.append(cfg['img_break'] if r < rows - 1 else cfg['img_end'])
+
+    xs = [embeddings.get(t, x) for t, x in enumerate(embed(tokens, cfg))]
+    for i in range(cfg['layers']):
+        p = f'blk.{i}'
+        h = [norm(x, vector(f'{p}.attn_norm.weight', hidden)) for x in xs]
+        xs = [add(x, y) for x, y in zip(xs, self_attention(h, p, cfg))]
+        xs = [add(x, swiglu(norm(x, vector(f'{p}.ffn_norm.weight', hidden)), p, hidden, cfg['ff'])) for x in xs]
+    return logits(xs, cfg, lambda x: norm(x, vector('output_norm.weight', hidden)))
+
+
+def t5(inputs, cfg):
+    """The encoder runs over inputs and the decoder over decoder_inputs, which
+    start from the decoder start token."""
+    eps, hidden, ff, num_heads, head_dim = cfg['eps'], cfg['hidden'], cfg['ff'], cfg['heads'], cfg['head_dim']
+    norm = lambda x, name: rms_norm(x, vector(f'{name}.weight', hidden), eps)
+
+    def bucket(distance, bidirectional):
+        n, b = cfg['buckets'], 0
+        if bidirectional:
+            n //= 2
+            b = n if distance > 0 else 0
+            distance = abs(distance)
+        else:
+            distance = max(-distance, 0)
+        exact = n // 2
+        if distance < exact:
+            return b + distance
+        return b + min(exact + int(math.log(distance / exact) / math.log(cfg['max_distance'] / exact) * (n - exact)), n - 1)
+
+    def bias(p, n, bidirectional):
+        w = matrix(f'{p}.attn_rel_b.weight', cfg['buckets'], num_heads)
+        return [[[w[bucket(k - q, bidirectional)][h] for k in range(n)] for q in range(n)] for h in range(num_heads)]
+
+    # the scores of T5 are not scaled and the decoder attends to the whole
+    # output of the encoder
+    def attend(p, name, xs, kvs, causal, b=None):
+        q = [heads(linear(matrix(f'{p}.{name}_q.weight', num_heads * head_dim, hidden), x), head_dim) for x in xs]
+        k = [heads(linear(matrix(f'{p}.{name}_k.weight', num_heads * head_dim, hidden), x), head_dim) for x in kvs]
+        v = [heads(linear(matrix(f'{p}.{name}_v.weight', num_heads * head_dim, hidden), x), head_dim) for x in kvs]
+        out = []
+        for t in range(len(xs)):
+            o = []
+            for h in range(num_heads):
+                js = range(t + 1) if causal else range(len(kvs))
+                probs = softmax([sum(a * c for a, c in zip(q[t][h], k[j][h])) + (b[h][t][j] if b else 0) for j in js])
+                o += [sum(pr * v[j][h][d] for pr, j in zip(probs, js)) for d in range(head_dim)]
+            out.append(linear(matrix(f'{p}.{name}_o.weight', hidden, num_heads * head_dim), o))
+        return out
+
+    def mlp(x, p):
+        gate = linear(matrix(f'{p}.ffn_gate.weight', ff, hidden), x)
+        up = linear(matrix(f'{p}.ffn_up.weight', ff, hidden), x)
+        return linear(matrix(f'{p}.ffn_down.weight', hidden, ff), [gelu(g) * u for g, u in zip(gate, up)])
+
+    # the relative position bias of the first layer is shared by the others
+    encoded = embed(inputs, cfg)
+    b = bias('enc.blk.0', len(encoded), True)
+    for i in range(cfg['layers']):
+        p = f'enc.blk.{i}'
+        h = [norm(x, f'{p}.attn_norm') for x in encoded]
+        encoded = [add(x, y) for x, y in zip(encoded, attend(p, 'attn', h, h, False, b))]
+        encoded = [add(x, mlp(norm(x, f'{p}.ffn_norm'), p)) for x in encoded]
+    encoded = [norm(x, 'enc.output_norm') for x in encoded]
+
+    xs = embed(cfg['decoder_inputs'], cfg)
+    b = bias('dec.blk.0', len(xs), False)
+    for i in range(cfg['layers']):
+        p = f'dec.blk.{i}'
+        h = [norm(x, f'{p}.attn_norm') for x in xs]
+        xs = [add(x, y) for x, y in zip(xs, attend(p, 'attn', h, h, True, b))]
+        h = [norm(x, f'{p}.cross_attn_norm') for x in xs]
+        xs = [add(x, y) for x, y in zip(xs, attend(p, 'cross_attn', h, encoded, False))]
+        xs = [add(x, mlp(norm(x, f'{p}.ffn_norm'), p)) for x in xs]
+    return logits(xs, cfg, lambda x: norm(x, 'dec.output_norm'))
+
+
+def bert(inputs, cfg):
+    """inputs are sequences that are processed together in one batch, each
+    attending to all of its own inputs and none of the others. The result is
+    the pooled embedding of each sequence, or the scores of its labels with a
+    classification head."""
+    eps, hidden, ff, num_heads = cfg['eps'], cfg['hidden'], cfg['ff'], cfg['heads']
+    head_dim = hidden // num_heads
+
+    def norm(x, name):
+        return layer_norm(x, vector(f'{name}.weight', hidden), vector(f'{name}.bias', hidden), eps)
+
+    def proj(name, rows, cols, x):
+        return linear(matrix(f'{name}.weight', rows, cols), x, vector(f'{name}.bias', rows))
+
+    out = []
+    for seq in inputs:
+        positions = matrix('position_embd.weight', cfg['context'], hidden)
+        xs = [add(x, positions[t]) for t, x in enumerate(embed(seq, cfg))]
+        if cfg.get('token_types'):
+            xs = [add(x, matrix('token_types.weight', 2, hidden)[0]) for x in xs]
+        xs = [norm(x, 'token_embd_norm') for x in xs]
+
+        for i in range(cfg['layers']):
+            p = f'blk.{i}'
+            q, k, v = ([heads(proj(f'{p}.attn_{n}', hidden, hidden, x), head_dim) for x in xs] for n in 'qkv')
+            a = []
+            for t in range(len(xs)):
+                o = []
+                for h in range(num_heads):
+                    probs = softmax([sum(c * d for c, d in zip(q[t][h], k[j][h])) / math.sqrt(head_dim) for j in range(len(xs))])
+                    o += [sum(pr * v[j][h][d] for j, pr in enumerate(probs)) for d in range(head_dim)]
+                a.append(proj(f'{p}.attn_output', hidden, hidden, o))
+            xs = [norm(add(x, y), f'{p}.attn_output_norm') for x, y in zip(xs, a)]
+            xs = [norm(add(x, proj(f'{p}.ffn_down', hidden, ff, [gelu(u) for u in proj(f'{p}.ffn_up', ff, hidden, x)])), f'{p}.layer_output_norm') for x in xs]
+
+        if 'labels' in cfg:
+            pooled = [math.tanh(v) for v in proj('cls', hidden, hidden, xs[0])]
+            out += proj('cls.output', len(cfg['labels']), hidden, pooled)
+        elif cfg['pooling'] == 'mean':
+            out += [sum(x[d] for x in xs) / len(xs) for d in range(hidden)]
+        else:
+            out += xs[0]
+    return out
+
+
+INPUTS = [1, 5, 2, 7]
+
+MODELS = {
+    'olmo2': (olmo2, dict(vocab=16, hidden=16, heads=4, kv_heads=2, ff=24, layers=2, eps=1e-6)),
+    'olmo2 (head_dim=6)': (olmo2, dict(vocab=16, hidden=16, heads=4, kv_heads=2, head_dim=6, ff=24, layers=2, eps=1e-6)),
+    'olmoe': (olmoe, dict(vocab=16, hidden=16, heads=4, kv_heads=4, ff=8, experts=4, experts_used=2, layers=2, eps=1e-6)),
+    'qwen2moe': (qwen2moe, dict(vocab=16, hidden=16, heads=4, kv_heads=2, ff=8, shared_ff=12, experts=4, experts_used=2, layers=2, eps=1e-6)),
+    'mixtral': (mixtral, dict(vocab=16, hidden=16, heads=4, kv_heads=2, ff=8, experts=4, experts_used=2, norm_top_k=True, rope_norm=True, layers=2, eps=1e-5)),
+    'mixtral (sliding_window=1)': (mixtral, dict(vocab=16, hidden=16, heads=4, kv_heads=2, ff=8, experts=4, experts_used=2, norm_top_k=True, rope_norm=True, window=1, layers=2, eps=1e-5)),
+    'mixtral (rope.type=neox)': (mixtral, dict(vocab=16, hidden=16, heads=4, kv_heads=2, ff=8, experts=4, experts_used=2, norm_top_k=True, layers=2, eps=1e-5)),
+    'nemotron': (nemotron, dict(vocab=16, hidden=16, heads=2, kv_heads=1, rope_dim=4, ff=24, layers=2, eps=1e-5)),
+    'granite': (granite, dict(vocab=16, hidden=16, heads=4, kv_heads=2, ff=24, rope_norm=True, scale=0.25, embedding_scale=2, residual_scale=0.5, logit_scale=4, layers=2, eps=1e-6)),
+    'starcoder2': (starcoder2, dict(vocab=16, hidden=16, heads=4, kv_heads=2, ff=24, layers=2, eps=1e-5)),
+    'starcoder2 (position_embd)': (starcoder2, dict(vocab=16, hidden=16, heads=4, kv_heads=2, ff=24, position_embd=True, context=8, layers=2, eps=1e-5)),
+    'command-r': (commandr, dict(vocab=16, hidden=16, heads=4, kv_heads=2, ff=24, rope_norm=True, logit_scale=0.5, layers=2, eps=1e-5)),
+    'command-r (qk_norm)': (commandr, dict(vocab=16, hidden=16, heads=4, kv_heads=2, ff=24, rope_norm=True, qk_norm=True, logit_scale=0.5, layers=2, eps=1e-5)),
+    'gptoss': (gptoss, dict(vocab=16, hidden=16, heads=4, kv_heads=2, head_dim=6, ff=8, experts=4, experts_used=2, window=1, rope_base=10000, layers=2, eps=1e-5)),
+    'pixtral': (pixtral, dict(vocab=16, hidden=16, heads=4, kv_heads=2, ff=24, rope_norm=True, layers=2, eps=1e-5,
+                              img=13, img_break=14, img_end=15, inputs=[1, 5, 'image', 2],
+                              vision=dict(width=4, height=4, patch=2, hidden=16, heads=2, ff=12, rope_base=10000, layers=2, eps=1e-5))),
+    't5': (t5, dict(vocab=16, hidden=16, heads=4, head_dim=4, ff=24, buckets=8, max_distance=128, layers=2, eps=1e-6,
+                    decoder_inputs=[0, 3, 6, 4, 2])),
+    'bert': (bert, dict(vocab=16, hidden=16, heads=4, ff=24, context=8, token_types=True, pooling='cls', layers=2, eps=1e-12,
+                        inputs=[[1, 5, 2, 7], [3, 6, 4]])),
+    'bert (roberta)': (bert, dict(vocab=16, hidden=16, heads=4, ff=24, context=8, pooling='mean', layers=2, eps=1e-5,
+                                  inputs=[[1, 5, 2, 7], [3, 6, 4]])),
+    'bert (classifier)': (bert, dict(vocab=16, hidden=16, heads=4, ff=24, context=8, token_types=True, labels=['a', 'b', 'c'], layers=2, eps=1e-12,
+                                     inputs=[[1, 5, 2, 7], [3, 6, 4]])),
+}
+
+if __name__ == '__main__':
+    for name, (fn, cfg) in MODELS.items():
+        out = fn(cfg.get('inputs', INPUTS), cfg)
+        print(f'{name}:')
+        width = len(cfg['labels']) if 'labels' in cfg else cfg['hidden'] if fn is bert else cfg['vocab']
+        for i in range(0, len(out), width):
+            print('\t' + ' '.join(f'{v:.6g},' for v in out[i:i + width]))
@@ -2,7 +2,6 @@ package llama
 
 import (
 	"fmt"
-	"math"
 	"strings"
 
 	"github.com/ollama/ollama/kvcache"
@@ -13,9 +12,11 @@ import (
 )
 
 type Options struct {
-	hiddenSize, numHeads, numKVHeads int
-	eps, ropeBase, ropeScale         float32
-	ropeDim                          uint32
+	hiddenSize int
+	eps        float32
+	attention  nn.SelfAttentionOptions
+	moe        nn.MoEOptions
+	labels     []string
 }
 
 type Model struct {
@@ -26,6 +27,7 @@ type Model struct {
 	Layers         []Layer       `gguf:"blk"`
 	OutputNorm     *nn.RMSNorm   `gguf:"output_norm"`
 	Output         *nn.Linear    `gguf:"output,alt:token_embd"`
+	Classifier     *nn.Linear    `gguf:"cls.output"`
 
 	*Options
 }
@@ -35,6 +37,14 @@ func New(c ml.Config) (model.Model, error) {
 		return nil, fmt.Errorf("tokenizer %s not yet supported", c.String("tokenizer.ggml.model"))
 	}
 
+	numHeads := int(c.Uint("attention.head_count"))
+	headDim := int(c.Uint("attention.key_length", c.Uint("embedding_length")/uint32(numHeads)))
+
+	ropeType, err := nn.ParseRopeType(c.String("rope.type", "norm"))
+	if err != nil {
+		return nil, err
+	}
+
 	m := Model{
 		BytePairEncoding: model.NewBytePairEncoding(
 			c.String("tokenizer.ggml.pretokenizer", `(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`),
@@ -51,53 +61,42 @@ func New(c ml.Config) (model.Model, error) {
 		Layers: make([]Layer, c.Uint("block_count")),
 		Options: &Options{
 			hiddenSize: int(c.Uint("embedding_length")),
-			numHeads:   int(c.Uint("attention.head_count")),
-			numKVHeads: int(c.Uint("attention.head_count_kv")),
 			eps:        c.Float("attention.layer_norm_rms_epsilon"),
-			ropeBase:   c.Float("rope.freq_base"),
-			ropeScale:  c.Float("rope.freq_scale", 1),
-			ropeDim:    c.Uint("rope.dimension_count"),
+			attention: nn.SelfAttentionOptions{
+				HeadDim:    headDim,
+				NumHeads:   numHeads,
+				NumKVHeads: int(c.Uint("attention.head_count_kv")),
+				RopeDim:    c.Uint("rope.dimension_count"),
+				RopeType:   ropeType,
+				RopeBase:   c.Float("rope.freq_base"),
+				RopeScale:  c.Float("rope.freq_scale", 1),
+			},
+			// Mixtral renormalizes the probabilities of the selected experts
+			moe: nn.MoEOptions{
+				NumExperts:     int(c.Uint("expert_count")),
+				NumExpertsUsed: int(c.Uint("expert_used_count")),
+				NormTopK:       true,
+			},
+			labels: c.Strings("classifier.output_labels"),
 		},
 	}
 
-	m.Cache = kvcache.NewCausalCache(m.Shift)
-
-	return &m, nil
-}
-
-type SelfAttention struct {
-	Query       *nn.Linear `gguf:"attn_q"`
-	Key         *nn.Linear `gguf:"attn_k"`
-	Value       *nn.Linear `gguf:"attn_v"`
-	Output      *nn.Linear `gguf:"attn_output"`
-	RopeFactors ml.Tensor  `gguf:"rope_freqs.weight"`
-}
-
-func (sa *SelfAttention) Forward(ctx ml.Context, hiddenState, positionIDs ml.Tensor, cache kvcache.Cache, opts *Options) ml.Tensor {
-	batchSize := hiddenState.Dim(1)
-	headDim := opts.hiddenSize / opts.numHeads
-	ropeType := uint32(0)
-
-	q := sa.Query.Forward(ctx, hiddenState)
-	q = q.Reshape(ctx, headDim, opts.numHeads, batchSize)
-	q = q.RoPE(ctx, positionIDs, sa.RopeFactors, opts.ropeDim, ropeType, opts.ropeBase, opts.ropeScale)
-
-	k := sa.Key.Forward(ctx, hiddenState)
-	k = k.Reshape(ctx, headDim, opts.numKVHeads, batchSize)
-	k = k.RoPE(ctx, positionIDs, sa.RopeFactors, opts.ropeDim, ropeType, opts.ropeBase, opts.ropeScale)
-
-	v := sa.Value.Forward(ctx, hiddenState)
-	v = v.Reshape(ctx, headDim, opts.numKVHeads, batchSize)
+	if m.moe.NumExpertsUsed > m.moe.NumExperts {
+		return nil, fmt.Errorf("invalid number of experts %d of which %d are used", m.moe.NumExperts, m.moe.NumExpertsUsed)
+	}
 
-	scaleFactor := 1.0 / math.Sqrt(float64(headDim))
-	kqv := nn.Attention(ctx, q, k, v, scaleFactor, cache)
-	kqv = kqv.Reshape(ctx, opts.hiddenSize, batchSize)
+	// Mistral attends to a sliding window in every layer
+	if slidingWindowLen := c.Uint("attention.sliding_window"); slidingWindowLen > 0 {
+		m.Cache = kvcache.NewSWACache(int32(slidingWindowLen), m.Shift)
+	} else {
+		m.Cache = kvcache.NewCausalCache(m.Shift)
+	}
 
-	return sa.Output.Forward(ctx, kqv)
+	return &m, nil
 }
 
 func (m *Model) Shift(ctx ml.Context, layer int, key, shift ml.Tensor) (ml.Tensor, error) {
-	return key.RoPE(ctx, shift, m.Layers[layer].SelfAttention.RopeFactors, uint32(0), m.ropeDim, m.ropeBase, m.ropeScale), nil
+	return m.attention.RoPE(ctx, key, shift, m.Layers[layer].SelfAttention.RopeFactors), nil
 }
 
 type MLP struct {
@@ -111,18 +110,20 @@ func (mlp *MLP) Forward(ctx ml.Context, hiddenState ml.Tensor, opts *Options) ml
 	return mlp.Down.Forward(ctx, hiddenState)
 }
 
+// Layer has either a dense MLP or, in Mixtral models, a mixture of experts.
 type Layer struct {
 	AttentionNorm *nn.RMSNorm `gguf:"attn_norm"`
-	SelfAttention *SelfAttention
+	SelfAttention *nn.SelfAttention
 	MLPNorm       *nn.RMSNorm `gguf:"ffn_norm"`
 	MLP           *MLP
+	MoE           *nn.MoE
 }
 
 func (l *Layer) Forward(ctx ml.Context, hiddenState, positionIDs, outputs ml.Tensor, cache kvcache.Cache, opts *Options) ml.Tensor {
 	residual := hiddenState
 
 	hiddenState = l.AttentionNorm.Forward(ctx, hiddenState, opts.eps)
-	hiddenState = l.SelfAttention.Forward(ctx, hiddenState, positionIDs, cache, opts)
+	hiddenState = l.SelfAttention.Forward(ctx, hiddenState, positionIDs, cache, &opts.attention)
 
 	// In the final layer (outputs != nil), optimize by pruning to just the token positions
 	// we need logits for.
@@ -135,7 +136,12 @@ func (l *Layer) Forward(ctx ml.Context, hiddenState, positionIDs, outputs ml.Ten
 	residual = hiddenState
 
 	hiddenState = l.MLPNorm.Forward(ctx, hiddenState, opts.eps)
-	hiddenState = l.MLP.Forward(ctx, hiddenState, opts)
+	if l.MoE != nil {
+		hiddenState = l.MoE.Forward(ctx, hiddenState, &opts.moe)
+	} else {
+		hiddenState = l.MLP.Forward(ctx, hiddenState, opts)
+	}
+
 	return hiddenState.Add(ctx, residual)
 }
 
@@ -164,9 +170,21 @@ func (m *Model) Forward(ctx ml.Context, batch input.Batch) (ml.Tensor, error) {
 	}
 
 	hiddenState = m.OutputNorm.Forward(ctx, hiddenState, m.eps)
+	if m.Classifier != nil {
+		return m.Classifier.Forward(ctx, hiddenState), nil
+	}
+
 	return m.Output.Forward(ctx, hiddenState), nil
 }
 
+func (m *Model) Labels() []string {
+	if m.Classifier == nil {
+		return nil
+	}
+
+	return m.labels
+}
+
 func init() {
 	model.Register("llama", New)
 }
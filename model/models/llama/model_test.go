@@ -0,0 +1,98 @@
+package llama
+
+import (
+	"fmt"
+	"maps"
+	"testing"
+
+	fs "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/model/models/internal/modeltest"
+)
+
+func TestForwardMixtral(t *testing.T) {
+	// computed by reference.py in the modeltest package
+	cases := []struct {
+		name          string
+		ropeType      string
+		slidingWindow uint32
+		want          []float32
+	}{
+		{
+			name: "mixtral",
+			want: []float32{
+				-1.84898, -0.92987, 1.47144, 1.52729, -0.851346, -1.87294, 0.0909126, 1.90986, 0.684507, -1.63194, -1.34709, 1.08501, 1.78761, -0.359218, -1.93346, -0.425785,
+				-1.51691, -0.569435, 1.28571, 1.09145, -0.842575, -1.43354, 0.260543, 1.53932, 0.364437, -1.39136, -0.929343, 1.01404, 1.34105, -0.469557, -1.5317, -0.152326,
+				0.15755, -1.35832, -0.70904, 1.07044, 1.14365, -0.606106, -1.38973, 0.0418611, 1.40673, 0.529285, -1.19183, -1.01318, 0.780473, 1.33006, -0.240456, -1.42769,
+				0.39683, -1.447, -0.984325, 1.04735, 1.40956, -0.475057, -1.60244, -0.175549, 1.53116, 0.797216, -1.20749, -1.28747, 0.684762, 1.56549, -0.0491587, -1.58545,
+			},
+		},
+		{
+			name:          "mixtral (sliding_window=1)",
+			slidingWindow: 1,
+			want: []float32{
+				-1.84898, -0.92987, 1.47144, 1.52729, -0.851346, -1.87294, 0.0909126, 1.90986, 0.684507, -1.63194, -1.34709, 1.08501, 1.78761, -0.359218, -1.93346, -0.425785,
+				-1.51691, -0.569435, 1.28571, 1.09145, -0.842575, -1.43354, 0.260543, 1.53932, 0.364437, -1.39136, -0.929343, 1.01404, 1.34105, -0.469557, -1.5317, -0.152326,
+				0.140692, -1.36462, -0.694743, 1.08255, 1.13427, -0.622028, -1.38682, 0.0589657, 1.41076, 0.513816, -1.20214, -1.0019, 0.795364, 1.32482, -0.257473, -1.42936,
+				2.07877, -1.22876, -2.57765, 0.182207, 2.65163, 0.894381, -2.2885, -1.82354, 1.54813, 2.45209, -0.552558, -2.67644, -0.534101, 2.45959, 1.53272, -1.83729,
+			},
+		},
+		{
+			name:     "mixtral (rope.type=neox)",
+			ropeType: "neox",
+			want: []float32{
+				-1.84898, -0.92987, 1.47144, 1.52729, -0.851346, -1.87294, 0.0909126, 1.90986, 0.684507, -1.63194, -1.34709, 1.08501, 1.78761, -0.359218, -1.93346, -0.425785,
+				-3.17927, -3.92435, 1.58595, 4.56826, 0.268808, -4.45912, -2.07925, 3.61492, 3.54695, -2.17483, -4.42995, 0.376225, 4.5827, 1.4844, -3.98002, -3.10032,
+				-0.200583, -1.59194, -0.445759, 1.41095, 1.01862, -0.997385, -1.42357, 0.419402, 1.59385, 0.227716, -1.50139, -0.837297, 1.16144, 1.30885, -0.630036, -1.56466,
+				0.664876, -1.30835, -1.19608, 0.822731, 1.53012, -0.201489, -1.61192, -0.452967, 1.42801, 1.03275, -1.00871, -1.4423, 0.423118, 1.61409, 0.232218, -1.51981,
+			},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			kv := fs.KV{
+				"general.architecture":                   "llama",
+				"llama.block_count":                      uint32(2),
+				"llama.embedding_length":                 uint32(16),
+				"llama.feed_forward_length":              uint32(8),
+				"llama.expert_count":                     uint32(4),
+				"llama.expert_used_count":                uint32(2),
+				"llama.attention.head_count":             uint32(4),
+				"llama.attention.head_count_kv":          uint32(2),
+				"llama.attention.layer_norm_rms_epsilon": float32(1e-5),
+				"llama.rope.dimension_count":             uint32(4),
+				"llama.rope.freq_base":                   float32(10000),
+			}
+			if tt.ropeType != "" {
+				kv["llama.rope.type"] = tt.ropeType
+			}
+			if tt.slidingWindow > 0 {
+				kv["llama.attention.sliding_window"] = tt.slidingWindow
+			}
+			maps.Copy(kv, modeltest.Vocabulary(16))
+
+			tensors := []modeltest.Tensor{
+				{Name: "token_embd.weight", Shape: []uint64{16, 16}},
+				{Name: "output_norm.weight", Shape: []uint64{16}},
+				{Name: "output.weight", Shape: []uint64{16, 16}},
+			}
+
+			for i := range 2 {
+				tensors = append(tensors,
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_norm.weight", i), Shape: []uint64{16}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_q.weight", i), Shape: []uint64{16, 16}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_k.weight", i), Shape: []uint64{8, 16}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_v.weight", i), Shape: []uint64{8, 16}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_output.weight", i), Shape: []uint64{16, 16}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_norm.weight", i), Shape: []uint64{16}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_gate_inp.weight", i), Shape: []uint64{4, 16}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_gate_exps.weight", i), Shape: []uint64{4, 8, 16}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_up_exps.weight", i), Shape: []uint64{4, 8, 16}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_down_exps.weight", i), Shape: []uint64{4, 16, 8}},
+				)
+			}
+
+			modeltest.Compare(t, modeltest.Forward(t, kv, tensors, []int32{1, 5, 2, 7}), tt.want)
+		})
+	}
+}
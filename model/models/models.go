@@ -1,8 +1,19 @@
 package models
 
 import (
+	_ "github.com/ollama/ollama/model/models/bert"
+	_ "github.com/ollama/ollama/model/models/commandr"
 	_ "github.com/ollama/ollama/model/models/gemma2"
 	_ "github.com/ollama/ollama/model/models/gemma3"
+	_ "github.com/ollama/ollama/model/models/gptoss"
+	_ "github.com/ollama/ollama/model/models/granite"
 	_ "github.com/ollama/ollama/model/models/llama"
 	_ "github.com/ollama/ollama/model/models/mllama"
+	_ "github.com/ollama/ollama/model/models/nemotron"
+	_ "github.com/ollama/ollama/model/models/olmo2"
+	_ "github.com/ollama/ollama/model/models/olmoe"
+	_ "github.com/ollama/ollama/model/models/pixtral"
+	_ "github.com/ollama/ollama/model/models/qwen2moe"
+	_ "github.com/ollama/ollama/model/models/starcoder2"
+	_ "github.com/ollama/ollama/model/models/t5"
 )
@@ -0,0 +1,58 @@
+package nemotron
+
+import (
+	"fmt"
+	"maps"
+	"testing"
+
+	fs "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/model/models/internal/modeltest"
+)
+
+func TestForward(t *testing.T) {
+	kv := fs.KV{
+		"general.architecture":                  "nemotron",
+		"nemotron.block_count":                  uint32(2),
+		"nemotron.embedding_length":             uint32(16),
+		"nemotron.feed_forward_length":          uint32(24),
+		"nemotron.attention.head_count":         uint32(2),
+		"nemotron.attention.head_count_kv":      uint32(1),
+		"nemotron.attention.layer_norm_epsilon": float32(1e-5),
+		"nemotron.rope.dimension_count":         uint32(4),
+	}
+	maps.Copy(kv, modeltest.Vocabulary(16))
+	kv["tokenizer.ggml.model"] = "llama"
+
+	tensors := []modeltest.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{16, 16}},
+		{Name: "output_norm.weight", Shape: []uint64{16}},
+		{Name: "output_norm.bias", Shape: []uint64{16}},
+		{Name: "output.weight", Shape: []uint64{16, 16}},
+	}
+
+	for i := range 2 {
+		tensors = append(tensors,
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_norm.weight", i), Shape: []uint64{16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_norm.bias", i), Shape: []uint64{16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_q.weight", i), Shape: []uint64{16, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_q.bias", i), Shape: []uint64{16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_k.weight", i), Shape: []uint64{8, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_k.bias", i), Shape: []uint64{8}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_v.weight", i), Shape: []uint64{8, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_v.bias", i), Shape: []uint64{8}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_output.weight", i), Shape: []uint64{16, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_norm.weight", i), Shape: []uint64{16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_norm.bias", i), Shape: []uint64{16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_up.weight", i), Shape: []uint64{24, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_down.weight", i), Shape: []uint64{16, 24}},
+		)
+	}
+
+	// computed by reference.py in the modeltest package
+	modeltest.Compare(t, modeltest.Forward(t, kv, tensors, []int32{1, 5, 2, 7}), []float32{
+		-1.26199, -1.93555, 0.476138, 2.12887, 0.388204, -1.97126, -1.18855, 1.48869, 1.79298, -0.760726, -2.10184, -0.0926413, 2.06423, 0.930737, -1.68634, -1.61541,
+		-0.0701217, -1.23396, -0.430876, 1.05902, 0.860847, -0.709504, -1.14891, 0.243034, 1.24759, 0.263498, -1.1406, -0.726595, 0.845599, 1.06992, -0.411203, -1.23687,
+		-0.475034, -2.86717, -0.689063, 2.5874, 1.73957, -1.88112, -2.50332, 0.864742, 2.85442, 0.294179, -2.73498, -1.40461, 2.16469, 2.28349, -1.23757, -2.78596,
+		-0.17227, -2.92164, -1.01394, 2.50997, 2.03302, -1.68455, -2.71696, 0.581434, 2.95303, 0.617524, -2.70231, -1.71469, 2.00613, 2.52919, -0.97925, -2.92678,
+	})
+}
@@ -0,0 +1,82 @@
+package olmo2
+
+import (
+	"fmt"
+	"maps"
+	"testing"
+
+	fs "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/model/models/internal/modeltest"
+)
+
+func TestForward(t *testing.T) {
+	// computed by reference.py in the modeltest package
+	cases := []struct {
+		name    string
+		headDim uint64
+		want    []float32
+	}{
+		{
+			name:    "olmo2",
+			headDim: 4,
+			want: []float32{
+				-2.25781, -0.826126, 1.92239, 1.60664, -1.27008, -2.1223, 0.408408, 2.28812, 0.520591, -2.07676, -1.36377, 1.52305, 1.98215, -0.718279, -2.27378, -0.204896,
+				-2.0973, 0.2274, 2.18963, 0.661611, -1.92101, -1.44156, 1.33572, 1.98387, -0.530249, -2.19916, -0.362632, 2.05193, 1.19574, -1.56645, -1.83173, 0.822749,
+				-0.745614, -2.27652, -0.178678, 2.20398, 1.07351, -1.76812, -1.79139, 1.0408, 2.21397, -0.14191, -2.27158, -0.780375, 1.95474, 1.57402, -1.31567, -2.1082,
+				0.183134, -1.89163, -0.951155, 1.50545, 1.56238, -0.871111, -1.91606, 0.0931707, 1.95389, 0.700128, -1.66963, -1.37802, 1.11015, 1.82875, -0.367657, -1.97802,
+			},
+		},
+		{
+			name:    "olmo2 (head_dim=6)",
+			headDim: 6,
+			want: []float32{
+				-3.15306, -0.589824, 2.91359, 1.77277, -2.19383, -2.66348, 1.11243, 3.11514, 0.152351, -3.05328, -1.39201, 2.48811, 2.40221, -1.51279, -3.01642, 0.288096,
+				-1.10439, 1.04055, 1.52686, -0.420634, -1.69764, -0.268627, 1.58858, 0.913606, -1.21765, -1.40798, 0.645993, 1.67026, 0.0321499, -1.65721, -0.704993, 1.37097,
+				0.387967, -1.3698, -0.944118, 0.986476, 1.34464, -0.44054, -1.5235, -0.178016, 1.45122, 0.767227, -1.13972, -1.22997, 0.640345, 1.48995, -0.0354099, -1.50433,
+				0.776881, -0.698603, -1.06052, 0.268021, 1.16934, 0.206742, -1.0854, -0.647425, 0.822539, 0.981384, -0.424088, -1.15357, -0.0442718, 1.13559, 0.505334, -0.930423,
+			},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			kv := fs.KV{
+				"general.architecture":                   "olmo2",
+				"olmo2.block_count":                      uint32(2),
+				"olmo2.embedding_length":                 uint32(16),
+				"olmo2.feed_forward_length":              uint32(24),
+				"olmo2.attention.head_count":             uint32(4),
+				"olmo2.attention.head_count_kv":          uint32(2),
+				"olmo2.attention.key_length":             uint32(tt.headDim),
+				"olmo2.attention.value_length":           uint32(tt.headDim),
+				"olmo2.attention.layer_norm_rms_epsilon": float32(1e-6),
+			}
+			maps.Copy(kv, modeltest.Vocabulary(16))
+
+			tensors := []modeltest.Tensor{
+				{Name: "token_embd.weight", Shape: []uint64{16, 16}},
+				{Name: "output_norm.weight", Shape: []uint64{16}},
+				{Name: "output.weight", Shape: []uint64{16, 16}},
+			}
+
+			qDim, kvDim := 4*tt.headDim, 2*tt.headDim
+			for i := range 2 {
+				tensors = append(tensors,
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_q.weight", i), Shape: []uint64{qDim, 16}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_k.weight", i), Shape: []uint64{kvDim, 16}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_v.weight", i), Shape: []uint64{kvDim, 16}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_output.weight", i), Shape: []uint64{16, qDim}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_q_norm.weight", i), Shape: []uint64{qDim}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_k_norm.weight", i), Shape: []uint64{kvDim}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.post_attention_norm.weight", i), Shape: []uint64{16}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_gate.weight", i), Shape: []uint64{24, 16}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_up.weight", i), Shape: []uint64{24, 16}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_down.weight", i), Shape: []uint64{16, 24}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.post_ffw_norm.weight", i), Shape: []uint64{16}},
+				)
+			}
+
+			modeltest.Compare(t, modeltest.Forward(t, kv, tensors, []int32{1, 5, 2, 7}), tt.want)
+		})
+	}
+}
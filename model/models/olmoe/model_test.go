@@ -0,0 +1,56 @@
+package olmoe
+
+import (
+	"fmt"
+	"maps"
+	"testing"
+
+	fs "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/model/models/internal/modeltest"
+)
+
+func TestForward(t *testing.T) {
+	kv := fs.KV{
+		"general.architecture":                   "olmoe",
+		"olmoe.block_count":                      uint32(2),
+		"olmoe.embedding_length":                 uint32(16),
+		"olmoe.feed_forward_length":              uint32(8),
+		"olmoe.expert_count":                     uint32(4),
+		"olmoe.expert_used_count":                uint32(2),
+		"olmoe.attention.head_count":             uint32(4),
+		"olmoe.attention.head_count_kv":          uint32(4),
+		"olmoe.attention.layer_norm_rms_epsilon": float32(1e-6),
+	}
+	maps.Copy(kv, modeltest.Vocabulary(16))
+
+	tensors := []modeltest.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{16, 16}},
+		{Name: "output_norm.weight", Shape: []uint64{16}},
+		{Name: "output.weight", Shape: []uint64{16, 16}},
+	}
+
+	for i := range 2 {
+		tensors = append(tensors,
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_norm.weight", i), Shape: []uint64{16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_q.weight", i), Shape: []uint64{16, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_k.weight", i), Shape: []uint64{16, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_v.weight", i), Shape: []uint64{16, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_output.weight", i), Shape: []uint64{16, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_q_norm.weight", i), Shape: []uint64{16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_k_norm.weight", i), Shape: []uint64{16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_norm.weight", i), Shape: []uint64{16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_gate_inp.weight", i), Shape: []uint64{4, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_gate_exps.weight", i), Shape: []uint64{4, 8, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_up_exps.weight", i), Shape: []uint64{4, 8, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_down_exps.weight", i), Shape: []uint64{4, 16, 8}},
+		)
+	}
+
+	// computed by reference.py in the modeltest package
+	modeltest.Compare(t, modeltest.Forward(t, kv, tensors, []int32{1, 5, 2, 7}), []float32{
+		-1.3956, 0.714422, 1.68567, -0.0300254, -1.69786, -0.659321, 1.43017, 1.23998, -0.926721, -1.61624, 0.270512, 1.72607, 0.430289, -1.55137, -1.06016, 1.12093,
+		-3.15578, 1.19775, 3.64208, 0.280965, -3.528, -1.71337, 2.83236, 2.86333, -1.66982, -3.54129, 0.232016, 3.6355, 1.24403, -3.13041, -2.51501, 2.10929,
+		-0.307781, -1.84231, -0.440215, 1.66358, 1.11564, -1.21062, -1.60717, 0.558092, 1.83376, 0.186431, -1.75806, -0.900223, 1.39257, 1.46562, -0.79751, -1.78941,
+		2.08158, -2.24951, -2.99491, 1.03355, 3.41454, 0.352784, -3.2713, -1.68097, 2.58882, 2.73205, -1.47958, -3.33277, 0.126439, 3.38411, 1.24754, -2.87759,
+	})
+}
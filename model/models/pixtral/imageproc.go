@@ -8,6 +8,7 @@ import (
 	"io"
 	"math"
 
+	"github.com/ollama/ollama/ml"
 	"github.com/ollama/ollama/model/imageproc"
 )
 
@@ -50,19 +51,29 @@ func resizeImage(img image.Image, format string, longestEdge int, patchSize imag
 	return imageproc.Resize(img, newSize, imageproc.ResizeBilinear)
 }
 
-func Preprocess(imageData io.Reader) ([]float32, map[string]any, error) {
+type ImageProcessor struct {
+	longestEdge, patchSize, numChannels int
+}
+
+func newImageProcessor(c ml.Config) ImageProcessor {
+	return ImageProcessor{
+		longestEdge: int(c.Uint("vision.image_size", 1024)),
+		patchSize:   int(c.Uint("vision.patch_size", 16)),
+		numChannels: int(c.Uint("vision.num_channels", 3)),
+	}
+}
+
+// ProcessImage resizes the image so that its longest edge fits and both of
+// its sides are multiples of the patch size, and returns its normalized
+// pixel values, channel first, together with its new size.
+func (p ImageProcessor) ProcessImage(imageData io.Reader) ([]float32, image.Point, error) {
 	img, format, err := image.Decode(imageData)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to decode image: %w", err)
+		return nil, image.Point{}, fmt.Errorf("failed to decode image: %w", err)
 	}
 
-	longestEdge := 1024
-	patchSize := image.Point{16, 16}
-
-	img = resizeImage(img, format, longestEdge, patchSize)
+	img = resizeImage(img, format, p.longestEdge, image.Point{p.patchSize, p.patchSize})
 
 	data := imageproc.Normalize(img, imageproc.ClipDefaultMean, imageproc.ClipDefaultSTD, true, true)
-
-	opts := map[string]any{}
-	return data, opts, nil
+	return data, img.Bounds().Size(), nil
 }
@@ -152,6 +152,8 @@ func TestResize(t *testing.T) {
 	}
 }
 
+var testImageProcessor = ImageProcessor{longestEdge: 1024, patchSize: 16, numChannels: 3}
+
 func TestPreprocess(t *testing.T) {
 	type preprocessCase struct {
 		TestImage   image.Image
@@ -176,7 +178,7 @@ func TestPreprocess(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		imgData, _, err := Preprocess(&buf)
+		imgData, _, err := testImageProcessor.ProcessImage(&buf)
 		if err != nil {
 			t.Fatalf("error processing: %q", err)
 		}
@@ -200,7 +202,7 @@ func TestPreprocessImages(t *testing.T) {
 		}
 		defer f.Close()
 
-		imgData, _, err := Preprocess(f)
+		imgData, _, err := testImageProcessor.ProcessImage(f)
 		if err != nil {
 			t.Fatalf("error processing: %q", err)
 		}
@@ -0,0 +1,151 @@
+package pixtral
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/ollama/ollama/kvcache"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/ml/nn"
+	"github.com/ollama/ollama/model"
+	"github.com/ollama/ollama/model/input"
+)
+
+// Model is a Mistral text model that takes images encoded by Pixtral's vision
+// encoder, whose patches are projected into the embeddings of the text.
+type Model struct {
+	model.Base
+	model.BytePairEncoding
+
+	*VisionModel `gguf:"v,vision"`
+	*TextModel
+
+	*MultiModalProjector `gguf:"mm"`
+
+	ImageProcessor
+}
+
+var _ model.MultimodalProcessor = (*Model)(nil)
+
+type MultiModalProjector struct {
+	Linear1 *nn.Linear `gguf:"1"`
+	Linear2 *nn.Linear `gguf:"2"`
+}
+
+func (p *MultiModalProjector) Forward(ctx ml.Context, visionOutputs ml.Tensor) ml.Tensor {
+	visionOutputs = p.Linear1.Forward(ctx, visionOutputs).GELU(ctx)
+	return p.Linear2.Forward(ctx, visionOutputs)
+}
+
+func New(c ml.Config) (model.Model, error) {
+	if !strings.EqualFold(c.String("tokenizer.ggml.model"), "gpt2") {
+		return nil, fmt.Errorf("tokenizer %s not yet supported", c.String("tokenizer.ggml.model"))
+	}
+
+	textModel, err := newTextModel(c)
+	if err != nil {
+		return nil, err
+	}
+
+	vocabulary := &model.Vocabulary{
+		Values: c.Strings("tokenizer.ggml.tokens"),
+		Types:  c.Uints("tokenizer.ggml.token_type"),
+		Merges: c.Strings("tokenizer.ggml.merges"),
+		BOS:    int32(c.Uint("tokenizer.ggml.bos_token_id")),
+		AddBOS: c.Bool("tokenizer.ggml.add_bos_token", true),
+		EOS:    int32(c.Uint("tokenizer.ggml.eos_token_id")),
+		AddEOS: c.Bool("tokenizer.ggml.add_eos_token", false),
+	}
+
+	m := Model{
+		BytePairEncoding: model.NewBytePairEncoding(
+			c.String("tokenizer.ggml.pretokenizer", `(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`),
+			vocabulary,
+		),
+		ImageProcessor:      newImageProcessor(c),
+		VisionModel:         newVisionModel(c),
+		TextModel:           textModel,
+		MultiModalProjector: &MultiModalProjector{},
+	}
+
+	m.Cache = kvcache.NewCausalCache(m.TextModel.Shift)
+	m.InputOptions = input.Options{
+		ImageToken:      vocabulary.Encode("[IMG]"),
+		ImageBreakToken: vocabulary.Encode("[IMG_BREAK]"),
+		ImageEndToken:   vocabulary.Encode("[IMG_END]"),
+	}
+
+	return &m, nil
+}
+
+// EncodeMultimodal returns the embeddings of the patches of an image, one
+// tensor per row of patches.
+func (m *Model) EncodeMultimodal(ctx ml.Context, multimodalData []byte) (any, error) {
+	if len(m.VisionModel.Layers) == 0 {
+		return nil, model.ErrNoVisionModel
+	}
+
+	if m.InputOptions.ImageToken < 0 || m.InputOptions.ImageBreakToken < 0 || m.InputOptions.ImageEndToken < 0 {
+		return nil, fmt.Errorf("vocabulary has no image tokens")
+	}
+
+	f32s, size, err := m.ImageProcessor.ProcessImage(bytes.NewReader(multimodalData))
+	if err != nil {
+		return nil, err
+	}
+
+	pixelValues, err := ctx.Input().FromFloatSlice(f32s, size.X, size.Y, m.ImageProcessor.numChannels)
+	if err != nil {
+		return nil, err
+	}
+
+	visionOutputs, err := m.VisionModel.Forward(ctx, pixelValues)
+	if err != nil {
+		return nil, err
+	}
+
+	visionOutputs = m.MultiModalProjector.Forward(ctx, visionOutputs)
+
+	cols := size.X / m.ImageProcessor.patchSize
+	rows := make([]ml.Tensor, size.Y/m.ImageProcessor.patchSize)
+	for i := range rows {
+		rows[i] = visionOutputs.View(ctx, i*cols*visionOutputs.Stride(1), visionOutputs.Dim(0), visionOutputs.Stride(1), cols)
+	}
+
+	return rows, nil
+}
+
+// PostTokenize replaces each image with its placeholder tokens, as placed
+// by the input options of the model.
+func (m *Model) PostTokenize(inputs []input.Input) ([]input.Input, error) {
+	var result []input.Input
+	for _, inp := range inputs {
+		if inp.Multimodal == nil {
+			result = append(result, inp)
+			continue
+		}
+
+		result = append(result, m.InputOptions.PlaceImage(inp.Multimodal.([]ml.Tensor), inp.MultimodalHash)...)
+	}
+
+	return result, nil
+}
+
+func (m *Model) Forward(ctx ml.Context, batch input.Batch) (ml.Tensor, error) {
+	positions, err := ctx.Input().FromIntSlice(batch.Positions, len(batch.Positions))
+	if err != nil {
+		return nil, err
+	}
+
+	outputs, err := ctx.Input().FromIntSlice(batch.Outputs, len(batch.Outputs))
+	if err != nil {
+		return nil, err
+	}
+
+	return m.TextModel.Forward(ctx, batch.Inputs, positions, outputs, batch, m.Cache), nil
+}
+
+func init() {
+	model.Register("pixtral", New)
+}
@@ -0,0 +1,155 @@
+package pixtral
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"maps"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	fs "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/model/input"
+	"github.com/ollama/ollama/model/models/internal/modeltest"
+)
+
+func TestForward(t *testing.T) {
+	kv := fs.KV{
+		"general.architecture":                        "pixtral",
+		"pixtral.block_count":                         uint32(2),
+		"pixtral.embedding_length":                    uint32(16),
+		"pixtral.feed_forward_length":                 uint32(24),
+		"pixtral.attention.head_count":                uint32(4),
+		"pixtral.attention.head_count_kv":             uint32(2),
+		"pixtral.attention.layer_norm_rms_epsilon":    float32(1e-5),
+		"pixtral.rope.freq_base":                      float32(10000),
+		"pixtral.vision.block_count":                  uint32(2),
+		"pixtral.vision.embedding_length":             uint32(16),
+		"pixtral.vision.feed_forward_length":          uint32(12),
+		"pixtral.vision.attention.head_count":         uint32(2),
+		"pixtral.vision.attention.layer_norm_epsilon": float32(1e-5),
+		"pixtral.vision.rope.freq_base":               float32(10000),
+		"pixtral.vision.image_size":                   uint32(8),
+		"pixtral.vision.patch_size":                   uint32(2),
+		"pixtral.vision.num_channels":                 uint32(3),
+	}
+	maps.Copy(kv, modeltest.Vocabulary(16))
+	kv["tokenizer.ggml.tokens"].([]string)[13] = "[IMG]"
+	kv["tokenizer.ggml.tokens"].([]string)[14] = "[IMG_BREAK]"
+	kv["tokenizer.ggml.tokens"].([]string)[15] = "[IMG_END]"
+
+	tensors := []modeltest.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{16, 16}},
+		{Name: "output_norm.weight", Shape: []uint64{16}},
+		{Name: "output.weight", Shape: []uint64{16, 16}},
+		{Name: "v.patch_embd.weight", Shape: []uint64{16, 3, 2, 2}},
+		{Name: "v.pre_ln.weight", Shape: []uint64{16}},
+		{Name: "mm.1.weight", Shape: []uint64{16, 16}},
+		{Name: "mm.1.bias", Shape: []uint64{16}},
+		{Name: "mm.2.weight", Shape: []uint64{16, 16}},
+		{Name: "mm.2.bias", Shape: []uint64{16}},
+	}
+
+	for i := range 2 {
+		tensors = append(tensors,
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_norm.weight", i), Shape: []uint64{16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_q.weight", i), Shape: []uint64{16, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_k.weight", i), Shape: []uint64{8, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_v.weight", i), Shape: []uint64{8, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_output.weight", i), Shape: []uint64{16, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_norm.weight", i), Shape: []uint64{16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_gate.weight", i), Shape: []uint64{24, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_up.weight", i), Shape: []uint64{24, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_down.weight", i), Shape: []uint64{16, 24}},
+			modeltest.Tensor{Name: fmt.Sprintf("v.blk.%d.ln1.weight", i), Shape: []uint64{16}},
+			modeltest.Tensor{Name: fmt.Sprintf("v.blk.%d.attn_q.weight", i), Shape: []uint64{16, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("v.blk.%d.attn_k.weight", i), Shape: []uint64{16, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("v.blk.%d.attn_v.weight", i), Shape: []uint64{16, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("v.blk.%d.attn_out.weight", i), Shape: []uint64{16, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("v.blk.%d.ln2.weight", i), Shape: []uint64{16}},
+			modeltest.Tensor{Name: fmt.Sprintf("v.blk.%d.ffn_gate.weight", i), Shape: []uint64{12, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("v.blk.%d.ffn_up.weight", i), Shape: []uint64{12, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("v.blk.%d.ffn_down.weight", i), Shape: []uint64{16, 12}},
+		)
+	}
+
+	m := modeltest.Load(t, kv, tensors).(*Model)
+	if diff := cmp.Diff(input.Options{ImageToken: 13, ImageBreakToken: 14, ImageEndToken: 15}, m.Config().InputOptions); diff != "" {
+		t.Errorf("input options mismatch (-want +got):\n%s", diff)
+	}
+
+	// the image has 2x2 patches and needs no resizing, matching pixtral_image
+	// in reference.py
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for y := range 4 {
+		for x := range 4 {
+			img.Set(x, y, color.NRGBA{uint8(20 + 50*x + 10*y), uint8(200 - 30*y - 15*x), uint8(37 * (x + 4*y) % 256), 255})
+		}
+	}
+
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := m.Backend().NewContext()
+	defer ctx.Close()
+
+	rows, err := m.EncodeMultimodal(ctx, b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inputs, err := m.PostTokenize([]input.Input{{Token: 1}, {Token: 5}, {Multimodal: rows, MultimodalHash: 1}, {Token: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tokens, sameBatch []int
+	var multimodal []int
+	for i, inp := range inputs {
+		tokens = append(tokens, int(inp.Token))
+		sameBatch = append(sameBatch, inp.SameBatch)
+		if inp.Multimodal != nil {
+			multimodal = append(multimodal, i)
+			if inp.Multimodal.(ml.Tensor).Dim(1) != 2 {
+				t.Errorf("expected 2 patches for input %d, got %d", i, inp.Multimodal.(ml.Tensor).Dim(1))
+			}
+		}
+	}
+
+	// each row of patches ends with [IMG_BREAK] and the last with [IMG_END]
+	if diff := cmp.Diff([]int{1, 5, 13, 13, 14, 13, 13, 15, 2}, tokens); diff != "" {
+		t.Errorf("tokens mismatch (-want +got):\n%s", diff)
+	}
+
+	// the first patch of each row carries its embeddings
+	if diff := cmp.Diff([]int{2, 5}, multimodal); diff != "" {
+		t.Errorf("multimodal inputs mismatch (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff([]int{0, 0, 5, 0, 0, 0, 0, 0, 0}, sameBatch); diff != "" {
+		t.Errorf("same batch mismatch (-want +got):\n%s", diff)
+	}
+
+	if inputs[2].MultimodalHash == inputs[5].MultimodalHash {
+		t.Error("expected the rows of the image to have different hashes")
+	}
+
+	// computed by reference.py in the modeltest package
+	modeltest.Compare(t, modeltest.Run(t, m, inputs), []float32{
+		-1.45625, -1.21151, 0.964365, 1.60305, -0.313509, -1.73034, -0.389026, 1.57239, 1.02743, -1.15525, -1.49647, 0.547662, 1.71883, 0.150199, -1.65785, -0.823301,
+		-1.28171, -0.623064, 1.02874, 1.04074, -0.606184, -1.28686, 0.0837076, 1.32084, 0.452568, -1.1371, -0.91424, 0.765906, 1.22521, -0.268461, -1.3342, -0.273238,
+		0.132381, -1.84647, -0.882068, 1.48835, 1.48635, -0.884873, -1.84562, 0.135535, 1.90065, 0.636146, -1.64236, -1.30296, 1.11335, 1.75499, -0.400805, -1.91772,
+		-0.0526754, -2.02774, -0.770605, 1.71486, 1.46686, -1.1193, -1.9213, 0.339236, 2.05904, 0.496753, -1.85735, -1.25086, 1.34949, 1.79876, -0.619177, -2.05015,
+		-0.48165, 0.341821, 0.620432, -0.0899191, -0.65694, -0.176805, 0.585156, 0.414384, -0.416912, -0.583654, 0.179942, 0.656712, 0.0866892, -0.621516, -0.339031, 0.483866,
+		-0.0823949, -1.88245, -0.681899, 1.60559, 1.33379, -1.06406, -1.76581, 0.34713, 1.90674, 0.427027, -1.73337, -1.13079, 1.27426, 1.64815, -0.60509, -1.89382,
+		-0.250467, -1.7417, -0.45668, 1.55628, 1.08855, -1.11432, -1.54097, 0.488673, 1.73938, 0.217531, -1.65106, -0.887877, 1.29057, 1.41186, -0.717342, -1.70311,
+		-1.11563, -0.224546, 1.02446, 0.640489, -0.764419, -0.95085, 0.378364, 1.10447, 0.070061, -1.07602, -0.506937, 0.870203, 0.860248, -0.520934, -1.07175, 0.0857918,
+		-0.721829, -1.18645, 0.24012, 1.28394, 0.281172, -1.16978, -0.756114, 0.86279, 1.10641, -0.413575, -1.27433, -0.103815, 1.23218, 0.604093, -0.986913, -1.00479,
+	})
+}
@@ -0,0 +1,173 @@
+package pixtral
+
+import (
+	"math"
+
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/ml/nn"
+)
+
+type VisionSelfAttention struct {
+	Query  *nn.Linear `gguf:"attn_q"`
+	Key    *nn.Linear `gguf:"attn_k"`
+	Value  *nn.Linear `gguf:"attn_v"`
+	Output *nn.Linear `gguf:"attn_out"`
+}
+
+func (sa *VisionSelfAttention) Forward(ctx ml.Context, hiddenState, cos, sin ml.Tensor, opts *VisionModelOptions) ml.Tensor {
+	headDim := opts.hiddenSize / opts.numHeads
+
+	query := sa.Query.Forward(ctx, hiddenState)
+	key := sa.Key.Forward(ctx, hiddenState)
+	value := sa.Value.Forward(ctx, hiddenState)
+
+	query = query.Reshape(ctx, headDim, opts.numHeads, query.Dim(1))
+	key = key.Reshape(ctx, headDim, opts.numHeads, key.Dim(1))
+	value = value.Reshape(ctx, headDim, opts.numHeads, value.Dim(1))
+
+	query = applyRotaryEmbedding(ctx, query, cos, sin)
+	key = applyRotaryEmbedding(ctx, key, cos, sin)
+
+	attention := nn.Attention(ctx, query, key, value, 1.0/math.Sqrt(float64(headDim)), nil)
+	attention = attention.Reshape(ctx, opts.hiddenSize, attention.Dim(2))
+
+	return sa.Output.Forward(ctx, attention)
+}
+
+// applyRotaryEmbedding rotates the pairs of values from the two halves of
+// each head of t by the angles of cos and sin, which have one head per patch.
+func applyRotaryEmbedding(ctx ml.Context, t, cos, sin ml.Tensor) ml.Tensor {
+	half := t.Dim(0) / 2
+	x1 := t.View(ctx, 0, half, t.Stride(1), t.Dim(1), t.Stride(2), t.Dim(2)).Contiguous(ctx)
+	x2 := t.View(ctx, half*t.Stride(0), half, t.Stride(1), t.Dim(1), t.Stride(2), t.Dim(2)).Contiguous(ctx)
+
+	rotated := x2.Scale(ctx, -1).Concat(ctx, x1, 0)
+	return t.Mul(ctx, cos).Add(ctx, rotated.Mul(ctx, sin))
+}
+
+type VisionMLP struct {
+	Gate *nn.Linear `gguf:"ffn_gate"`
+	Up   *nn.Linear `gguf:"ffn_up"`
+	Down *nn.Linear `gguf:"ffn_down"`
+}
+
+func (mlp *VisionMLP) Forward(ctx ml.Context, hiddenState ml.Tensor) ml.Tensor {
+	hiddenState = mlp.Gate.Forward(ctx, hiddenState).SILU(ctx).Mul(ctx, mlp.Up.Forward(ctx, hiddenState))
+	return mlp.Down.Forward(ctx, hiddenState)
+}
+
+type VisionEncoderLayer struct {
+	AttentionNorm *nn.RMSNorm `gguf:"ln1"`
+	SelfAttention *VisionSelfAttention
+
+	MLPNorm *nn.RMSNorm `gguf:"ln2"`
+	MLP     *VisionMLP
+}
+
+func (e *VisionEncoderLayer) Forward(ctx ml.Context, hiddenState, cos, sin ml.Tensor, opts *VisionModelOptions) ml.Tensor {
+	residual := hiddenState
+
+	hiddenState = e.AttentionNorm.Forward(ctx, hiddenState, opts.eps)
+	hiddenState = e.SelfAttention.Forward(ctx, hiddenState, cos, sin, opts)
+	hiddenState = hiddenState.Add(ctx, residual)
+	residual = hiddenState
+
+	hiddenState = e.MLPNorm.Forward(ctx, hiddenState, opts.eps)
+	hiddenState = e.MLP.Forward(ctx, hiddenState)
+	return hiddenState.Add(ctx, residual)
+}
+
+type VisionModelOptions struct {
+	hiddenSize, numHeads int
+	patchSize            int
+	ropeBase             float32
+	eps                  float32
+}
+
+type VisionModel struct {
+	PatchEmbedding *nn.Conv2D  `gguf:"patch_embd"`
+	PreLayerNorm   *nn.RMSNorm `gguf:"pre_ln"`
+
+	Layers []VisionEncoderLayer `gguf:"blk"`
+
+	*VisionModelOptions
+}
+
+// rotaryEmbedding returns the cosines and sines of the angles that rotate
+// the heads of each patch of an image with rows by cols patches. Pixtral
+// rotates the first half of the frequencies by the row of a patch and the
+// second half by its column, so that images of any size have positions.
+func (m *VisionModel) rotaryEmbedding(ctx ml.Context, rows, cols int) (ml.Tensor, ml.Tensor, error) {
+	headDim := m.hiddenSize / m.numHeads
+
+	freqs := make([]float64, headDim/2)
+	for i := range freqs {
+		freqs[i] = math.Pow(float64(m.ropeBase), -float64(2*i)/float64(headDim))
+	}
+
+	cos := make([]float32, headDim*rows*cols)
+	sin := make([]float32, headDim*rows*cols)
+	for row := range rows {
+		for col := range cols {
+			offset := (row*cols + col) * headDim
+			for i := range headDim / 2 {
+				var theta float64
+				if i < headDim/4 {
+					theta = float64(row) * freqs[2*i]
+				} else {
+					theta = float64(col) * freqs[2*(i-headDim/4)+1]
+				}
+
+				cos[offset+i], cos[offset+i+headDim/2] = float32(math.Cos(theta)), float32(math.Cos(theta))
+				sin[offset+i], sin[offset+i+headDim/2] = float32(math.Sin(theta)), float32(math.Sin(theta))
+			}
+		}
+	}
+
+	cosTensor, err := ctx.Input().FromFloatSlice(cos, headDim, 1, rows*cols)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sinTensor, err := ctx.Input().FromFloatSlice(sin, headDim, 1, rows*cols)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cosTensor, sinTensor, nil
+}
+
+// Forward returns the hidden states of the patches of pixelValues, row by
+// row.
+func (m *VisionModel) Forward(ctx ml.Context, pixelValues ml.Tensor) (ml.Tensor, error) {
+	hiddenState := m.PatchEmbedding.Forward(ctx, pixelValues, m.patchSize, m.patchSize, 0, 0, 1, 1)
+	cols, rows := hiddenState.Dim(0), hiddenState.Dim(1)
+
+	hiddenState = hiddenState.Reshape(ctx, cols*rows, m.hiddenSize)
+	hiddenState = hiddenState.Permute(ctx, 1, 0, 2, 3).Contiguous(ctx)
+	hiddenState = m.PreLayerNorm.Forward(ctx, hiddenState, m.eps)
+
+	cos, sin, err := m.rotaryEmbedding(ctx, rows, cols)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, layer := range m.Layers {
+		hiddenState = layer.Forward(ctx, hiddenState, cos, sin, m.VisionModelOptions)
+	}
+
+	return hiddenState, nil
+}
+
+func newVisionModel(c ml.Config) *VisionModel {
+	return &VisionModel{
+		Layers: make([]VisionEncoderLayer, c.Uint("vision.block_count")),
+		VisionModelOptions: &VisionModelOptions{
+			hiddenSize: int(c.Uint("vision.embedding_length")),
+			numHeads:   int(c.Uint("vision.attention.head_count")),
+			patchSize:  int(c.Uint("vision.patch_size", 16)),
+			ropeBase:   c.Float("vision.rope.freq_base", 10000),
+			eps:        c.Float("vision.attention.layer_norm_epsilon", 1e-5),
+		},
+	}
+}
@@ -0,0 +1,62 @@
+package qwen2moe
+
+import (
+	"fmt"
+	"maps"
+	"testing"
+
+	fs "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/model/models/internal/modeltest"
+)
+
+func TestForward(t *testing.T) {
+	kv := fs.KV{
+		"general.architecture":                       "qwen2moe",
+		"qwen2moe.block_count":                       uint32(2),
+		"qwen2moe.embedding_length":                  uint32(16),
+		"qwen2moe.expert_feed_forward_length":        uint32(8),
+		"qwen2moe.expert_shared_feed_forward_length": uint32(12),
+		"qwen2moe.expert_count":                      uint32(4),
+		"qwen2moe.expert_used_count":                 uint32(2),
+		"qwen2moe.attention.head_count":              uint32(4),
+		"qwen2moe.attention.head_count_kv":           uint32(2),
+		"qwen2moe.attention.layer_norm_rms_epsilon":  float32(1e-6),
+	}
+	maps.Copy(kv, modeltest.Vocabulary(16))
+
+	tensors := []modeltest.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{16, 16}},
+		{Name: "output_norm.weight", Shape: []uint64{16}},
+		{Name: "output.weight", Shape: []uint64{16, 16}},
+	}
+
+	for i := range 2 {
+		tensors = append(tensors,
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_norm.weight", i), Shape: []uint64{16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_q.weight", i), Shape: []uint64{16, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_q.bias", i), Shape: []uint64{16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_k.weight", i), Shape: []uint64{8, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_k.bias", i), Shape: []uint64{8}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_v.weight", i), Shape: []uint64{8, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_v.bias", i), Shape: []uint64{8}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_output.weight", i), Shape: []uint64{16, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_norm.weight", i), Shape: []uint64{16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_gate_inp.weight", i), Shape: []uint64{4, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_gate_exps.weight", i), Shape: []uint64{4, 8, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_up_exps.weight", i), Shape: []uint64{4, 8, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_down_exps.weight", i), Shape: []uint64{4, 16, 8}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_gate_inp_shexp.weight", i), Shape: []uint64{16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_gate_shexp.weight", i), Shape: []uint64{12, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_up_shexp.weight", i), Shape: []uint64{12, 16}},
+			modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_down_shexp.weight", i), Shape: []uint64{16, 12}},
+		)
+	}
+
+	// computed by reference.py in the modeltest package
+	modeltest.Compare(t, modeltest.Forward(t, kv, tensors, []int32{1, 5, 2, 7}), []float32{
+		-1.81639, -0.995072, 1.41238, 1.56851, -0.775553, -1.8834, 0.0108758, 1.88781, 0.755594, -1.58103, -1.39751, 1.01363, 1.80905, -0.279138, -1.92239, -0.501369,
+		-4.06733, -2.01961, 3.24735, 3.33806, -1.89206, -4.10625, 0.224882, 4.19756, 1.47937, -3.59692, -2.93975, 2.40335, 3.91554, -0.813606, -4.24587, -0.910258,
+		-0.185604, -1.56008, -0.447804, 1.37827, 1.00739, -0.969256, -1.40092, 0.400468, 1.56352, 0.234334, -1.46837, -0.830508, 1.13118, 1.28978, -0.607517, -1.53644,
+		0.57936, -1.34677, -1.12616, 0.889538, 1.48732, -0.28567, -1.60331, -0.365289, 1.455, 0.956032, -1.06684, -1.38918, 0.502819, 1.59333, 0.144088, -1.53483,
+	})
+}
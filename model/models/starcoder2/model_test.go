@@ -0,0 +1,91 @@
+package starcoder2
+
+import (
+	"fmt"
+	"maps"
+	"testing"
+
+	fs "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/model/models/internal/modeltest"
+)
+
+func TestForward(t *testing.T) {
+	// computed by reference.py in the modeltest package
+	cases := []struct {
+		name string
+
+		// positionEmbedding adds learned absolute positions to the rotary
+		// embeddings
+		positionEmbedding bool
+		want              []float32
+	}{
+		{
+			name: "starcoder2",
+			want: []float32{
+				0.351915, -1.86503, -1.10913, 1.41471, 1.68352, -0.731183, -1.98039, -0.0728736, 1.9508, 0.864917, -1.59963, -1.51438, 0.98478, 1.91421, -0.20759, -1.9985,
+				1.44083, -0.464176, -1.62929, -0.197333, 1.54917, 0.826313, -1.21368, -1.31908, 0.678124, 1.59441, -0.0307804, -1.6069, -0.621638, 1.35451, 1.17158, -0.878838,
+				0.755521, -2.77406, -1.88182, 2.01002, 2.69791, -0.914648, -3.06926, -0.331502, 2.93467, 1.52301, -2.31631, -2.46345, 1.31613, 2.99781, -0.098987, -3.038,
+				1.29887, -2.46841, -2.30107, 1.53415, 2.92395, -0.347, -3.06483, -0.897352, 2.7005, 1.99378, -1.891, -2.76155, 0.769789, 3.07409, 0.47832, -2.87989,
+			},
+		},
+		{
+			name:              "starcoder2 (position_embd)",
+			positionEmbedding: true,
+			want: []float32{
+				2.69097, 0.443476, -2.51092, -1.46293, 1.91695, 2.24124, -1.00699, -2.65008, -0.068969, 2.62208, 1.13356, -2.16184, -2.01129, 1.34524, 2.55747, -0.306884,
+				1.39219, 0.837924, -1.05198, -1.26504, 0.538365, 1.48362, 0.0639999, -1.45764, -0.655814, 1.19137, 1.13952, -0.728712, -1.43539, 0.145931, 1.49464, 0.460905,
+				0.371494, -2.14233, -1.2413, 1.63835, 1.90648, -0.864296, -2.2574, -0.0522291, 2.23619, 0.960144, -1.84636, -1.70979, 1.15217, 2.17758, -0.268055, -2.28641,
+				0.845715, -3.1686, -2.1322, 2.30291, 3.0672, -1.05759, -3.49659, -0.362057, 3.34959, 1.72202, -2.65043, -2.79813, 1.51437, 3.41297, -0.128667, -3.46521,
+			},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			kv := fs.KV{
+				"general.architecture":                    "starcoder2",
+				"starcoder2.block_count":                  uint32(2),
+				"starcoder2.embedding_length":             uint32(16),
+				"starcoder2.feed_forward_length":          uint32(24),
+				"starcoder2.attention.head_count":         uint32(4),
+				"starcoder2.attention.head_count_kv":      uint32(2),
+				"starcoder2.attention.layer_norm_epsilon": float32(1e-5),
+			}
+			maps.Copy(kv, modeltest.Vocabulary(16))
+
+			tensors := []modeltest.Tensor{
+				{Name: "token_embd.weight", Shape: []uint64{16, 16}},
+				{Name: "output_norm.weight", Shape: []uint64{16}},
+				{Name: "output_norm.bias", Shape: []uint64{16}},
+				{Name: "output.weight", Shape: []uint64{16, 16}},
+			}
+
+			if tt.positionEmbedding {
+				tensors = append(tensors, modeltest.Tensor{Name: "position_embd.weight", Shape: []uint64{8, 16}})
+			}
+
+			for i := range 2 {
+				tensors = append(tensors,
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_norm.weight", i), Shape: []uint64{16}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_norm.bias", i), Shape: []uint64{16}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_q.weight", i), Shape: []uint64{16, 16}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_q.bias", i), Shape: []uint64{16}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_k.weight", i), Shape: []uint64{8, 16}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_k.bias", i), Shape: []uint64{8}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_v.weight", i), Shape: []uint64{8, 16}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_v.bias", i), Shape: []uint64{8}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_output.weight", i), Shape: []uint64{16, 16}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.attn_output.bias", i), Shape: []uint64{16}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_norm.weight", i), Shape: []uint64{16}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_norm.bias", i), Shape: []uint64{16}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_up.weight", i), Shape: []uint64{24, 16}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_up.bias", i), Shape: []uint64{24}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_down.weight", i), Shape: []uint64{16, 24}},
+					modeltest.Tensor{Name: fmt.Sprintf("blk.%d.ffn_down.bias", i), Shape: []uint64{16}},
+				)
+			}
+
+			modeltest.Compare(t, modeltest.Forward(t, kv, tensors, []int32{1, 5, 2, 7}), tt.want)
+		})
+	}
+}
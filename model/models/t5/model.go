@@ -0,0 +1,125 @@
+package t5
+
+import (
+	"github.com/ollama/ollama/kvcache"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/ml/nn"
+	"github.com/ollama/ollama/model"
+	"github.com/ollama/ollama/model/input"
+)
+
+type Options struct {
+	hiddenSize, numHeads, numKVHeads int
+	keyLength, valueLength           int
+	eps                              float32
+}
+
+type Model struct {
+	model.Base
+	model.SentencePieceModel
+
+	TokenEmbedding *nn.Embedding `gguf:"token_embd"`
+	Encoder        *Encoder      `gguf:"enc"`
+	Decoder        *Decoder      `gguf:"dec"`
+	Output         *nn.Linear    `gguf:"output,alt:token_embd"`
+
+	decoderStart int32
+
+	*Options
+}
+
+const (
+	selfAttentionLayer = iota
+	crossAttentionLayer
+)
+
+// maxRelativeDistance is the distance beyond which the relative position
+// bias is the same for all distances
+const maxRelativeDistance = 128
+
+func New(c ml.Config) (model.Model, error) {
+	m := Model{
+		SentencePieceModel: model.NewSentencePieceModel(
+			c.String("tokenizer.ggml.pretokenizer", `(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`),
+			&model.Vocabulary{
+				Values: c.Strings("tokenizer.ggml.tokens"),
+				Scores: c.Floats("tokenizer.ggml.scores"),
+				Types:  c.Uints("tokenizer.ggml.token_type"),
+				BOS:    int32(c.Uint("tokenizer.ggml.bos_token_id")),
+				AddBOS: c.Bool("tokenizer.ggml.add_bos_token", false),
+				EOS:    int32(c.Uint("tokenizer.ggml.eos_token_id")),
+				AddEOS: c.Bool("tokenizer.ggml.add_eos_token", true),
+			},
+		),
+		Encoder: &Encoder{
+			Layers: make([]EncoderLayer, c.Uint("block_count")),
+		},
+		Decoder: &Decoder{
+			Layers: make([]DecoderLayer, c.Uint("decoder_block_count", c.Uint("block_count"))),
+		},
+		decoderStart: int32(c.Uint("decoder_start_token_id", c.Uint("tokenizer.ggml.padding_token_id"))),
+		Options: &Options{
+			hiddenSize:  int(c.Uint("embedding_length")),
+			numHeads:    int(c.Uint("attention.head_count")),
+			numKVHeads:  int(c.Uint("attention.head_count_kv", c.Uint("attention.head_count"))),
+			keyLength:   int(c.Uint("attention.key_length")),
+			valueLength: int(c.Uint("attention.value_length")),
+			eps:         c.Float("attention.layer_norm_rms_epsilon"),
+		},
+	}
+
+	m.Cache = kvcache.NewWrapperCache(kvcache.NewCausalCache(m.Shift), kvcache.NewCrossCache())
+	m.Cache.SetConfig(ml.CacheConfig{})
+
+	return &m, nil
+}
+
+func (m *Model) EncodeTokens(ctx ml.Context, tokens []int32) (any, error) {
+	inputs, err := ctx.Input().FromIntSlice(tokens, len(tokens))
+	if err != nil {
+		return nil, err
+	}
+
+	positions := make([]int32, len(tokens))
+	for i := range positions {
+		positions[i] = int32(i)
+	}
+
+	hiddenState := m.TokenEmbedding.Forward(ctx, inputs)
+	return m.Encoder.Forward(ctx, hiddenState, positions, m.Options)
+}
+
+func (m *Model) DecoderStart() int32 {
+	return m.decoderStart
+}
+
+// Shift leaves keys unchanged because positions only enter attention through
+// the relative position bias, which is computed from the updated positions
+func (m *Model) Shift(ctx ml.Context, layer int, key, shift ml.Tensor) (ml.Tensor, error) {
+	return key, nil
+}
+
+func (m *Model) Forward(ctx ml.Context, batch input.Batch) (ml.Tensor, error) {
+	outputs, err := ctx.Input().FromIntSlice(batch.Outputs, len(batch.Outputs))
+	if err != nil {
+		return nil, err
+	}
+
+	var encoded []ml.Tensor
+	for _, mm := range batch.Multimodal {
+		encoded = append(encoded, mm.Multimodal.(ml.Tensor))
+	}
+
+	hiddenState := m.TokenEmbedding.Forward(ctx, batch.Inputs)
+
+	hiddenState, err = m.Decoder.Forward(ctx, hiddenState, batch.Positions, outputs, encoded, m.Cache.(*kvcache.WrapperCache), m.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.Output.Forward(ctx, hiddenState), nil
+}
+
+func init() {
+	model.Register("t5", New)
+}
@@ -0,0 +1,106 @@
+package t5
+
+import (
+	"github.com/ollama/ollama/kvcache"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/ml/nn"
+)
+
+type CrossAttention struct {
+	Query  *nn.Linear `gguf:"cross_attn_q"`
+	Key    *nn.Linear `gguf:"cross_attn_k"`
+	Value  *nn.Linear `gguf:"cross_attn_v"`
+	Output *nn.Linear `gguf:"cross_attn_o"`
+}
+
+func (ca *CrossAttention) Forward(ctx ml.Context, hiddenState ml.Tensor, encoded []ml.Tensor, cache *kvcache.WrapperCache, opts *Options) ml.Tensor {
+	batchSize := hiddenState.Dim(1)
+
+	query := ca.Query.Forward(ctx, hiddenState)
+	query = query.Reshape(ctx, opts.keyLength, opts.numHeads, batchSize)
+
+	for _, e := range encoded {
+		key := ca.Key.Forward(ctx, e)
+		key = key.Reshape(ctx, opts.keyLength, opts.numKVHeads, e.Dim(1))
+
+		value := ca.Value.Forward(ctx, e)
+		value = value.Reshape(ctx, opts.valueLength, opts.numKVHeads, e.Dim(1))
+
+		cache.Put(ctx, key, value)
+	}
+
+	key, value, mask := cache.Get(ctx)
+
+	attention := attention(ctx, query, key, value, mask, nil)
+	attention = attention.Reshape(ctx, opts.valueLength*opts.numHeads, batchSize)
+
+	return ca.Output.Forward(ctx, attention)
+}
+
+type DecoderLayer struct {
+	AttentionNorm *nn.RMSNorm `gguf:"attn_norm"`
+	SelfAttention *SelfAttention
+
+	CrossAttentionNorm *nn.RMSNorm `gguf:"cross_attn_norm"`
+	CrossAttention     *CrossAttention
+
+	MLPNorm *nn.RMSNorm `gguf:"ffn_norm"`
+	MLP     *MLP
+}
+
+func (d *DecoderLayer) Forward(ctx ml.Context, hiddenState, bias, outputs ml.Tensor, encoded []ml.Tensor, cache *kvcache.WrapperCache, opts *Options) ml.Tensor {
+	residual := hiddenState
+
+	cache.SetLayerType(selfAttentionLayer)
+	hiddenState = d.AttentionNorm.Forward(ctx, hiddenState, opts.eps)
+	hiddenState = d.SelfAttention.Forward(ctx, hiddenState, bias, cache, opts)
+	hiddenState = hiddenState.Add(ctx, residual)
+	residual = hiddenState
+
+	cache.SetLayerType(crossAttentionLayer)
+	hiddenState = d.CrossAttentionNorm.Forward(ctx, hiddenState, opts.eps)
+	hiddenState = d.CrossAttention.Forward(ctx, hiddenState, encoded, cache, opts)
+
+	// In the final layer (outputs != nil), optimize by pruning to just the token positions
+	// we need logits for.
+	if outputs != nil {
+		hiddenState = hiddenState.Rows(ctx, outputs)
+		residual = residual.Rows(ctx, outputs)
+	}
+
+	hiddenState = hiddenState.Add(ctx, residual)
+	residual = hiddenState
+
+	hiddenState = d.MLPNorm.Forward(ctx, hiddenState, opts.eps)
+	hiddenState = d.MLP.Forward(ctx, hiddenState)
+	return hiddenState.Add(ctx, residual)
+}
+
+type Decoder struct {
+	Layers     []DecoderLayer `gguf:"blk"`
+	OutputNorm *nn.RMSNorm    `gguf:"output_norm"`
+}
+
+// Forward runs the decoder over a batch, storing the keys and values of the
+// encoder outputs attached to it for cross attention by later batches
+func (d *Decoder) Forward(ctx ml.Context, hiddenState ml.Tensor, positions []int32, outputs ml.Tensor, encoded []ml.Tensor, cache *kvcache.WrapperCache, opts *Options) (ml.Tensor, error) {
+	// The history of self attention, and so the bias, is the same in every layer
+	cache.SetLayerType(selfAttentionLayer)
+	bias, err := d.Layers[0].SelfAttention.RelativeBias.Forward(ctx, positions, cache.UnderlyingCache().(*kvcache.Causal).Positions(), false, maxRelativeDistance)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range d.Layers {
+		cache.SetLayer(i)
+
+		var lastLayerOutputs ml.Tensor
+		if i == len(d.Layers)-1 {
+			lastLayerOutputs = outputs
+		}
+
+		hiddenState = d.Layers[i].Forward(ctx, hiddenState, bias, lastLayerOutputs, encoded, cache, opts)
+	}
+
+	return d.OutputNorm.Forward(ctx, hiddenState, opts.eps), nil
+}
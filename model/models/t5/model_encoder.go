@@ -0,0 +1,120 @@
+package t5
+
+import (
+	"github.com/ollama/ollama/kvcache"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/ml/nn"
+)
+
+type SelfAttention struct {
+	Query  *nn.Linear `gguf:"attn_q"`
+	Key    *nn.Linear `gguf:"attn_k"`
+	Value  *nn.Linear `gguf:"attn_v"`
+	Output *nn.Linear `gguf:"attn_o"`
+
+	// RelativeBias is only present in the first layer and shared by the others
+	RelativeBias *nn.RelativePositionBias `gguf:"attn_rel_b"`
+}
+
+func (sa *SelfAttention) Forward(ctx ml.Context, hiddenState, bias ml.Tensor, cache kvcache.Cache, opts *Options) ml.Tensor {
+	batchSize := hiddenState.Dim(1)
+
+	query := sa.Query.Forward(ctx, hiddenState)
+	query = query.Reshape(ctx, opts.keyLength, opts.numHeads, batchSize)
+
+	key := sa.Key.Forward(ctx, hiddenState)
+	key = key.Reshape(ctx, opts.keyLength, opts.numKVHeads, batchSize)
+
+	value := sa.Value.Forward(ctx, hiddenState)
+	value = value.Reshape(ctx, opts.valueLength, opts.numKVHeads, batchSize)
+
+	var mask ml.Tensor
+	if cache != nil {
+		cache.Put(ctx, key, value)
+		key, value, mask = cache.Get(ctx)
+	}
+
+	attention := attention(ctx, query, key, value, mask, bias)
+	attention = attention.Reshape(ctx, opts.valueLength*opts.numHeads, batchSize)
+
+	return sa.Output.Forward(ctx, attention)
+}
+
+// attention is scaled dot-product attention without the scaling, which T5
+// folds into the initialization of its weights, plus an optional bias
+func attention(ctx ml.Context, query, key, value, mask, bias ml.Tensor) ml.Tensor {
+	query = query.Permute(ctx, 0, 2, 1, 3)
+	key = key.Permute(ctx, 0, 2, 1, 3)
+	value = value.Permute(ctx, 1, 2, 0, 3).Contiguous(ctx)
+
+	kq := key.MulmatFullPrec(ctx, query)
+	if bias != nil {
+		kq = kq.Add(ctx, bias)
+	}
+	if mask != nil {
+		kq = kq.Add(ctx, mask)
+	}
+	kq = kq.Softmax(ctx)
+
+	kqv := value.Mulmat(ctx, kq)
+	return kqv.Permute(ctx, 0, 2, 1, 3).Contiguous(ctx)
+}
+
+type MLP struct {
+	Up   *nn.Linear `gguf:"ffn_up"`
+	Gate *nn.Linear `gguf:"ffn_gate"`
+	Down *nn.Linear `gguf:"ffn_down"`
+}
+
+// Forward uses the gated GELU of T5 v1.1 and FLAN-T5 if the gate is present,
+// otherwise the ReLU of the original T5
+func (mlp *MLP) Forward(ctx ml.Context, hiddenState ml.Tensor) ml.Tensor {
+	if mlp.Gate != nil {
+		hiddenState = mlp.Gate.Forward(ctx, hiddenState).GELU(ctx).Mul(ctx, mlp.Up.Forward(ctx, hiddenState))
+	} else {
+		hiddenState = mlp.Up.Forward(ctx, hiddenState).RELU(ctx)
+	}
+
+	return mlp.Down.Forward(ctx, hiddenState)
+}
+
+type EncoderLayer struct {
+	AttentionNorm *nn.RMSNorm `gguf:"attn_norm"`
+	SelfAttention *SelfAttention
+
+	MLPNorm *nn.RMSNorm `gguf:"ffn_norm"`
+	MLP     *MLP
+}
+
+func (e *EncoderLayer) Forward(ctx ml.Context, hiddenState, bias ml.Tensor, opts *Options) ml.Tensor {
+	residual := hiddenState
+
+	hiddenState = e.AttentionNorm.Forward(ctx, hiddenState, opts.eps)
+	hiddenState = e.SelfAttention.Forward(ctx, hiddenState, bias, nil, opts)
+	hiddenState = hiddenState.Add(ctx, residual)
+	residual = hiddenState
+
+	hiddenState = e.MLPNorm.Forward(ctx, hiddenState, opts.eps)
+	hiddenState = e.MLP.Forward(ctx, hiddenState)
+	return hiddenState.Add(ctx, residual)
+}
+
+type Encoder struct {
+	Layers     []EncoderLayer `gguf:"blk"`
+	OutputNorm *nn.RMSNorm    `gguf:"output_norm"`
+}
+
+// Forward runs the encoder over the whole prompt at once, so every token
+// attends to every other token without a cache or a mask
+func (e *Encoder) Forward(ctx ml.Context, hiddenState ml.Tensor, positions []int32, opts *Options) (ml.Tensor, error) {
+	bias, err := e.Layers[0].SelfAttention.RelativeBias.Forward(ctx, positions, positions, true, maxRelativeDistance)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range e.Layers {
+		hiddenState = e.Layers[i].Forward(ctx, hiddenState, bias, opts)
+	}
+
+	return e.OutputNorm.Forward(ctx, hiddenState, opts.eps), nil
+}
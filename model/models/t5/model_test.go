@@ -0,0 +1,85 @@
+package t5
+
+import (
+	"fmt"
+	"maps"
+	"testing"
+
+	fs "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/model/input"
+	"github.com/ollama/ollama/model/models/internal/modeltest"
+)
+
+func TestForward(t *testing.T) {
+	kv := fs.KV{
+		"general.architecture":                "t5",
+		"t5.block_count":                      uint32(2),
+		"t5.embedding_length":                 uint32(16),
+		"t5.feed_forward_length":              uint32(24),
+		"t5.attention.head_count":             uint32(4),
+		"t5.attention.key_length":             uint32(4),
+		"t5.attention.value_length":           uint32(4),
+		"t5.attention.layer_norm_rms_epsilon": float32(1e-6),
+		"t5.decoder_start_token_id":           uint32(0),
+	}
+	maps.Copy(kv, modeltest.Vocabulary(16))
+
+	tensors := []modeltest.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{16, 16}},
+		{Name: "output.weight", Shape: []uint64{16, 16}},
+		{Name: "enc.output_norm.weight", Shape: []uint64{16}},
+		{Name: "dec.output_norm.weight", Shape: []uint64{16}},
+		// 8 buckets of relative positions for each head
+		{Name: "enc.blk.0.attn_rel_b.weight", Shape: []uint64{8, 4}},
+		{Name: "dec.blk.0.attn_rel_b.weight", Shape: []uint64{8, 4}},
+	}
+
+	for i := range 2 {
+		for _, prefix := range []string{"enc", "dec"} {
+			p := fmt.Sprintf("%s.blk.%d", prefix, i)
+			tensors = append(tensors,
+				modeltest.Tensor{Name: p + ".attn_norm.weight", Shape: []uint64{16}},
+				modeltest.Tensor{Name: p + ".attn_q.weight", Shape: []uint64{16, 16}},
+				modeltest.Tensor{Name: p + ".attn_k.weight", Shape: []uint64{16, 16}},
+				modeltest.Tensor{Name: p + ".attn_v.weight", Shape: []uint64{16, 16}},
+				modeltest.Tensor{Name: p + ".attn_o.weight", Shape: []uint64{16, 16}},
+				modeltest.Tensor{Name: p + ".ffn_norm.weight", Shape: []uint64{16}},
+				modeltest.Tensor{Name: p + ".ffn_gate.weight", Shape: []uint64{24, 16}},
+				modeltest.Tensor{Name: p + ".ffn_up.weight", Shape: []uint64{24, 16}},
+				modeltest.Tensor{Name: p + ".ffn_down.weight", Shape: []uint64{16, 24}},
+			)
+		}
+
+		p := fmt.Sprintf("dec.blk.%d", i)
+		tensors = append(tensors,
+			modeltest.Tensor{Name: p + ".cross_attn_norm.weight", Shape: []uint64{16}},
+			modeltest.Tensor{Name: p + ".cross_attn_q.weight", Shape: []uint64{16, 16}},
+			modeltest.Tensor{Name: p + ".cross_attn_k.weight", Shape: []uint64{16, 16}},
+			modeltest.Tensor{Name: p + ".cross_attn_v.weight", Shape: []uint64{16, 16}},
+			modeltest.Tensor{Name: p + ".cross_attn_o.weight", Shape: []uint64{16, 16}},
+		)
+	}
+
+	m := modeltest.Load(t, kv, tensors).(*Model)
+
+	ctx := m.Backend().NewContext()
+	defer ctx.Close()
+
+	encoded, err := m.EncodeTokens(ctx, []int32{1, 5, 2, 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// as in the runner, the output of the encoder is attached to the decoder
+	// start token and stored in the cross attention cache by the first batch
+	inputs := []input.Input{{Token: m.DecoderStart(), Multimodal: encoded, MultimodalHash: 1}, {Token: 3}, {Token: 6}, {Token: 4}, {Token: 2}}
+
+	// computed by reference.py in the modeltest package
+	modeltest.Compare(t, modeltest.Run(t, m, inputs), []float32{
+		-0.943291, -0.0594166, 0.919167, 0.432607, -0.743524, -0.734486, 0.445316, 0.915288, -0.0737001, -0.945211, -0.310065, 0.819322, 0.642717, -0.558372, -0.869422, 0.205379,
+		0.600129, -1.38687, -1.16321, 0.914591, 1.53454, -0.291552, -1.65292, -0.379548, 1.49882, 0.988082, -1.09765, -1.43374, 0.515534, 1.64305, 0.15156, -1.58151,
+		-0.821974, 0.457797, 1.00784, -0.0486024, -1.02758, -0.368604, 0.87792, 0.725048, -0.583544, -0.961973, 0.192974, 1.04032, 0.229407, -0.94718, -0.613972, 0.697902,
+		0.833346, 0.124197, -0.782921, -0.44207, 0.603436, 0.687071, -0.324478, -0.818812, -0.00796745, 0.815577, 0.3391, -0.6779, -0.614334, 0.428474, 0.788298, -0.108417,
+		-0.776931, -0.864401, 0.425976, 1.03735, -0.00480117, -1.0393, -0.417165, 0.869928, 0.770364, -0.557152, -0.996573, 0.152534, 1.0585, 0.277229, -0.945946, -0.661292,
+	})
+}
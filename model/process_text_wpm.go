@@ -0,0 +1,123 @@
+package model
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// WordPiece is the tokenizer of BERT models. Text is split into words on
+// whitespace and punctuation, and each word is split greedily into the
+// longest pieces in the vocabulary.
+//
+// As in gguf files, tokens that begin a word start with spmWhitespaceSep
+// in the vocabulary, where BERT's own vocabularies mark pieces that
+// continue a word with ## instead.
+type WordPiece struct {
+	maxTokenLen int
+	unknown     int32
+	vocab       *Vocabulary
+}
+
+var _ TextProcessor = (*WordPiece)(nil)
+
+func NewWordPiece(vocab *Vocabulary) WordPiece {
+	wpm := WordPiece{unknown: -1, vocab: vocab}
+	for i, value := range vocab.Values {
+		wpm.maxTokenLen = max(wpm.maxTokenLen, len(value))
+		if vocab.Types[i] == TOKEN_TYPE_UNKNOWN && wpm.unknown < 0 {
+			wpm.unknown = int32(i)
+		}
+	}
+
+	return wpm
+}
+
+func (wpm WordPiece) Is(id int32, special Special) bool {
+	return wpm.vocab.Is(id, special)
+}
+
+// words lowercases s, strips its accents and splits it into words. Each
+// punctuation mark, symbol or CJK character is a word of its own.
+func (wpm WordPiece) words(s string) []string {
+	var words []string
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			words = append(words, word.String())
+			word.Reset()
+		}
+	}
+
+	for _, r := range norm.NFD.String(s) {
+		switch {
+		case unicode.IsSpace(r) || unicode.IsControl(r):
+			flush()
+		case unicode.Is(unicode.Mn, r):
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.Is(unicode.Han, r) ||
+			r < unicode.MaxASCII && !unicode.IsLetter(r) && !unicode.IsNumber(r):
+			flush()
+			words = append(words, string(r))
+		default:
+			word.WriteRune(unicode.ToLower(r))
+		}
+	}
+	flush()
+
+	return words
+}
+
+func (wpm WordPiece) Encode(s string, addSpecial bool) ([]int32, error) {
+	var ids []int32
+	for _, word := range wpm.words(s) {
+		word = spmWhitespaceSep + word
+
+		var pieces []int32
+		for i := 0; i < len(word); {
+			j := min(len(word), i+wpm.maxTokenLen)
+			for ; j > i; j-- {
+				if id := wpm.vocab.Encode(word[i:j]); id >= 0 {
+					pieces = append(pieces, id)
+					break
+				}
+			}
+
+			// words that can't be split into pieces are unknown as a whole
+			if j == i {
+				pieces = nil
+				if wpm.unknown >= 0 {
+					pieces = []int32{wpm.unknown}
+				}
+				break
+			}
+
+			i = j
+		}
+
+		ids = append(ids, pieces...)
+	}
+
+	if addSpecial {
+		if wpm.vocab.AddBOS {
+			ids = append([]int32{wpm.vocab.BOS}, ids...)
+		}
+
+		if wpm.vocab.AddEOS {
+			ids = append(ids, wpm.vocab.EOS)
+		}
+	}
+
+	return ids, nil
+}
+
+func (wpm WordPiece) Decode(ids []int32) (string, error) {
+	var sb strings.Builder
+	for _, id := range ids {
+		if _, err := sb.WriteString(strings.ReplaceAll(wpm.vocab.Decode(id), spmWhitespaceSep, " ")); err != nil {
+			return "", err
+		}
+	}
+
+	return strings.TrimPrefix(sb.String(), " "), nil
+}
@@ -0,0 +1,52 @@
+package model
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestWordPiece(t *testing.T) {
+	vocab := &Vocabulary{
+		Values: []string{"[UNK]", "[CLS]", "[SEP]", "▁hello", "▁world", "▁un", "aff", "able", "▁!", "▁cafe", "▁日"},
+		Types:  []uint32{TOKEN_TYPE_UNKNOWN, TOKEN_TYPE_CONTROL, TOKEN_TYPE_CONTROL, 1, 1, 1, 1, 1, 1, 1, 1},
+		BOS:    1,
+		AddBOS: true,
+		EOS:    2,
+		AddEOS: true,
+	}
+
+	wpm := NewWordPiece(vocab)
+
+	cases := []struct {
+		in   string
+		want []int32
+	}{
+		{"hello world", []int32{1, 3, 4, 2}},
+		{"Hello, World!", []int32{1, 3, 0, 4, 8, 2}},
+		{"unaffable", []int32{1, 5, 6, 7, 2}},
+		{"unaffablex", []int32{1, 0, 2}},
+		{"  Café\t日", []int32{1, 9, 10, 2}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.in, func(t *testing.T) {
+			ids, err := wpm.Encode(tt.in, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(ids, tt.want) {
+				t.Errorf("Encode(%q) = %v, want %v", tt.in, ids, tt.want)
+			}
+		})
+	}
+
+	s, err := wpm.Decode([]int32{3, 5, 6, 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s != "hello unaffable" {
+		t.Errorf("Decode = %q, want %q", s, "hello unaffable")
+	}
+}
@@ -0,0 +1,33 @@
+// Package npipe implements the Windows named pipe transport of OLLAMA_HOST
+// addresses of the form npipe:////./pipe/ollama, which lets local clients
+// reach a server without opening a TCP port.
+//
+// Pipes are only accessible to the user running the server and to the
+// system, and reject clients on other machines.
+package npipe
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrUnsupported is returned on platforms without named pipes.
+var ErrUnsupported = errors.New("named pipes are only supported on Windows")
+
+// Addr is the path of a named pipe as given in OLLAMA_HOST.
+type Addr string
+
+func (a Addr) Network() string { return "pipe" }
+
+func (a Addr) String() string { return string(a) }
+
+// pipeName returns the Windows name of the pipe at path, which is of the form
+// //./pipe/name, \\.\pipe\name or just name.
+func pipeName(path string) string {
+	p := strings.ReplaceAll(path, "/", `\`)
+	if strings.HasPrefix(p, `\\`) {
+		return p
+	}
+
+	return `\\.\pipe\` + strings.TrimLeft(p, `\`)
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+
+package npipe
+
+import (
+	"context"
+	"net"
+)
+
+func Listen(path string) (net.Listener, error) {
+	return nil, &net.OpError{Op: "listen", Net: "pipe", Addr: Addr(path), Err: ErrUnsupported}
+}
+
+func Dial(ctx context.Context, path string) (net.Conn, error) {
+	return nil, &net.OpError{Op: "dial", Net: "pipe", Addr: Addr(path), Err: ErrUnsupported}
+}
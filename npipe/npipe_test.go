@@ -0,0 +1,19 @@
+package npipe
+
+import "testing"
+
+func TestPipeName(t *testing.T) {
+	cases := map[string]string{
+		"//./pipe/ollama":     `\\.\pipe\ollama`,
+		`\\.\pipe\ollama`:     `\\.\pipe\ollama`,
+		"ollama":              `\\.\pipe\ollama`,
+		"/ollama":             `\\.\pipe\ollama`,
+		"//./pipe/ollama/dev": `\\.\pipe\ollama\dev`,
+	}
+
+	for path, expect := range cases {
+		if got := pipeName(path); got != expect {
+			t.Errorf("%s: expected %s, got %s", path, expect, got)
+		}
+	}
+}
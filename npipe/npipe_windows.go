@@ -0,0 +1,408 @@
+package npipe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// pipeBufferSize is the size of the buffer of each direction of a pipe
+const pipeBufferSize = 64 << 10
+
+// Listen creates the named pipe path and listens for clients on it. It fails
+// if another process already created a pipe with the same name, so that the
+// server can't be impersonated by a pipe created before it started.
+func Listen(path string) (net.Listener, error) {
+	sa, err := currentUserOnly()
+	if err != nil {
+		return nil, &net.OpError{Op: "listen", Net: "pipe", Addr: Addr(path), Err: err}
+	}
+
+	l := &listener{name: pipeName(path), addr: Addr(path), sa: sa}
+	h, err := l.create(true)
+	if err != nil {
+		return nil, &net.OpError{Op: "listen", Net: "pipe", Addr: l.addr, Err: err}
+	}
+
+	l.next = &conn{h: h, addr: l.addr}
+	return l, nil
+}
+
+// Dial connects to the named pipe path, waiting while all of its instances
+// are busy.
+func Dial(ctx context.Context, path string) (net.Conn, error) {
+	name, err := windows.UTF16PtrFromString(pipeName(path))
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: "pipe", Addr: Addr(path), Err: err}
+	}
+
+	for {
+		// the server may identify the client but not impersonate it
+		h, err := windows.CreateFile(name, windows.GENERIC_READ|windows.GENERIC_WRITE, 0, nil, windows.OPEN_EXISTING,
+			windows.FILE_FLAG_OVERLAPPED|windows.SECURITY_SQOS_PRESENT|windows.SECURITY_IDENTIFICATION, 0)
+		if err == nil {
+			return &conn{h: h, addr: Addr(path)}, nil
+		} else if !errors.Is(err, windows.ERROR_PIPE_BUSY) {
+			return nil, &net.OpError{Op: "dial", Net: "pipe", Addr: Addr(path), Err: err}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, &net.OpError{Op: "dial", Net: "pipe", Addr: Addr(path), Err: ctx.Err()}
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// currentUserOnly returns security attributes that grant access to the user
+// of the process and to the system.
+func currentUserOnly() (*windows.SecurityAttributes, error) {
+	user, err := windows.GetCurrentProcessToken().GetTokenUser()
+	if err != nil {
+		return nil, err
+	}
+
+	sd, err := windows.SecurityDescriptorFromString(fmt.Sprintf("D:P(A;;GA;;;%s)(A;;GA;;;SY)", user.User.Sid))
+	if err != nil {
+		return nil, err
+	}
+
+	return &windows.SecurityAttributes{
+		Length:             uint32(unsafe.Sizeof(windows.SecurityAttributes{})),
+		SecurityDescriptor: sd,
+	}, nil
+}
+
+type listener struct {
+	name string
+	addr Addr
+	sa   *windows.SecurityAttributes
+
+	mu     sync.Mutex
+	closed bool
+
+	// next is the instance of the pipe that waits for the next client
+	next *conn
+}
+
+// create creates an instance of the pipe, which is the first one if first is
+// set.
+func (l *listener) create(first bool) (windows.Handle, error) {
+	name, err := windows.UTF16PtrFromString(l.name)
+	if err != nil {
+		return windows.InvalidHandle, err
+	}
+
+	flags := uint32(windows.PIPE_ACCESS_DUPLEX | windows.FILE_FLAG_OVERLAPPED)
+	if first {
+		flags |= windows.FILE_FLAG_FIRST_PIPE_INSTANCE
+	}
+
+	return windows.CreateNamedPipe(name, flags,
+		windows.PIPE_TYPE_BYTE|windows.PIPE_READMODE_BYTE|windows.PIPE_WAIT|windows.PIPE_REJECT_REMOTE_CLIENTS,
+		windows.PIPE_UNLIMITED_INSTANCES, pipeBufferSize, pipeBufferSize, 0, l.sa)
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	for {
+		l.mu.Lock()
+		if l.closed {
+			l.mu.Unlock()
+			return nil, &net.OpError{Op: "accept", Net: "pipe", Addr: l.addr, Err: net.ErrClosed}
+		}
+
+		if l.next == nil {
+			h, err := l.create(false)
+			if err != nil {
+				l.mu.Unlock()
+				return nil, &net.OpError{Op: "accept", Net: "pipe", Addr: l.addr, Err: err}
+			}
+
+			l.next = &conn{h: h, addr: l.addr}
+		}
+
+		c := l.next
+		l.mu.Unlock()
+
+		_, err := c.do(nil, func(o *windows.Overlapped) error {
+			return windows.ConnectNamedPipe(c.h, o)
+		})
+
+		l.mu.Lock()
+		if l.next == c {
+			l.next = nil
+			if err == nil || errors.Is(err, windows.ERROR_PIPE_CONNECTED) {
+				// create the next instance right away so that clients
+				// find the pipe busy rather than missing
+				if h, err := l.create(false); err == nil {
+					l.next = &conn{h: h, addr: l.addr}
+				}
+			}
+		}
+		l.mu.Unlock()
+
+		switch {
+		case err == nil, errors.Is(err, windows.ERROR_PIPE_CONNECTED):
+			return c, nil
+		case errors.Is(err, windows.ERROR_NO_DATA):
+			// the client disconnected before it was accepted
+			c.Close()
+		default:
+			c.Close()
+			return nil, &net.OpError{Op: "accept", Net: "pipe", Addr: l.addr, Err: err}
+		}
+	}
+}
+
+func (l *listener) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return &net.OpError{Op: "close", Net: "pipe", Addr: l.addr, Err: net.ErrClosed}
+	}
+
+	l.closed = true
+	next := l.next
+	l.next = nil
+	l.mu.Unlock()
+
+	if next != nil {
+		return next.Close()
+	}
+
+	return nil
+}
+
+func (l *listener) Addr() net.Addr {
+	return l.addr
+}
+
+// conn is a connected instance of a pipe. Its handle is opened for
+// overlapped I/O so that operations can be canceled when the connection is
+// closed or a deadline passes.
+type conn struct {
+	h    windows.Handle
+	addr Addr
+
+	mu     sync.Mutex
+	closed bool
+	ops    sync.WaitGroup
+
+	rd, wd deadline
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	n, err := c.do(&c.rd, func(o *windows.Overlapped) error {
+		return windows.ReadFile(c.h, b, nil, o)
+	})
+	switch {
+	case errors.Is(err, windows.ERROR_BROKEN_PIPE), errors.Is(err, windows.ERROR_PIPE_NOT_CONNECTED):
+		return n, io.EOF
+	case err != nil:
+		return n, &net.OpError{Op: "read", Net: "pipe", Addr: c.addr, Err: err}
+	}
+
+	return n, nil
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	var written int
+	for written < len(b) {
+		n, err := c.do(&c.wd, func(o *windows.Overlapped) error {
+			return windows.WriteFile(c.h, b[written:], nil, o)
+		})
+		written += n
+		if err != nil {
+			return written, &net.OpError{Op: "write", Net: "pipe", Addr: c.addr, Err: err}
+		}
+	}
+
+	return written, nil
+}
+
+// do starts an operation with start and waits for it to complete, until the
+// deadline d, if any, passes or the connection is closed.
+func (c *conn) do(d *deadline, start func(*windows.Overlapped) error) (int, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return 0, net.ErrClosed
+	}
+	c.ops.Add(1)
+	c.mu.Unlock()
+	defer c.ops.Done()
+
+	if d != nil && d.passed() {
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	event, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer windows.CloseHandle(event) //nolint:errcheck
+
+	o := &windows.Overlapped{HEvent: event}
+	if err := start(o); err != nil && !errors.Is(err, windows.ERROR_IO_PENDING) {
+		return 0, err
+	}
+
+	cancel := func() { windows.CancelIoEx(c.h, o) } //nolint:errcheck
+	if d != nil {
+		d.start(cancel)
+	}
+
+	// the connection may have been closed before the operation started,
+	// when canceling the operations of the handle had no effect
+	if c.isClosed() {
+		cancel()
+	}
+
+	var n uint32
+	err = windows.GetOverlappedResult(c.h, o, &n, true)
+
+	var expired bool
+	if d != nil {
+		expired = d.stop()
+	}
+
+	if errors.Is(err, windows.ERROR_OPERATION_ABORTED) {
+		switch {
+		case c.isClosed():
+			return int(n), net.ErrClosed
+		case expired:
+			return int(n), os.ErrDeadlineExceeded
+		}
+	}
+
+	return int(n), err
+}
+
+func (c *conn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+func (c *conn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return &net.OpError{Op: "close", Net: "pipe", Addr: c.addr, Err: net.ErrClosed}
+	}
+	c.closed = true
+	c.mu.Unlock()
+
+	windows.CancelIoEx(c.h, nil) //nolint:errcheck
+	c.ops.Wait()
+	return windows.CloseHandle(c.h)
+}
+
+func (c *conn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func (c *conn) SetDeadline(t time.Time) error {
+	c.rd.set(t)
+	c.wd.set(t)
+	return nil
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	c.rd.set(t)
+	return nil
+}
+
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	c.wd.set(t)
+	return nil
+}
+
+// deadline cancels the pending operation in one direction of a connection
+// when it passes. Deadlines can change while an operation is pending, which
+// net/http relies on to abort background reads.
+type deadline struct {
+	mu    sync.Mutex
+	t     time.Time
+	timer *time.Timer
+
+	// cancel cancels the pending operation, of which gen counts the
+	// operations so that timers of earlier ones have no effect
+	cancel  func()
+	gen     int
+	expired bool
+}
+
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.t = t
+	d.arm()
+}
+
+func (d *deadline) passed() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return !d.t.IsZero() && !time.Now().Before(d.t)
+}
+
+// start arms the deadline for a pending operation that cancel cancels.
+func (d *deadline) start(cancel func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.gen++
+	d.cancel = cancel
+	d.expired = false
+	d.arm()
+}
+
+// stop disarms the deadline once the operation completes and reports
+// whether the operation was canceled because the deadline passed.
+func (d *deadline) stop() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+
+	d.cancel = nil
+	return d.expired
+}
+
+func (d *deadline) arm() {
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+
+	if d.cancel == nil || d.t.IsZero() {
+		return
+	}
+
+	gen, cancel := d.gen, d.cancel
+	d.timer = time.AfterFunc(time.Until(d.t), func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if d.gen == gen && d.cancel != nil {
+			d.expired = true
+			cancel()
+		}
+	})
+}
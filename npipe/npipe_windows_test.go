@@ -0,0 +1,124 @@
+package npipe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func testPipe() string {
+	return fmt.Sprintf("//./pipe/ollama-test-%d-%d", os.Getpid(), time.Now().UnixNano())
+}
+
+func TestHTTP(t *testing.T) {
+	path := testPipe()
+	ln, err := Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello "+r.URL.Path) //nolint:errcheck
+	})}
+	go srv.Serve(ln) //nolint:errcheck
+	t.Cleanup(func() { srv.Close() })
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return Dial(ctx, path)
+		},
+	}}
+
+	// several requests exercise keep-alive and concurrent instances
+	for i := range 3 {
+		resp, err := client.Get(fmt.Sprintf("http://localhost/%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bts, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expect := fmt.Sprintf("hello /%d", i); string(bts) != expect {
+			t.Errorf("expected %q, got %q", expect, bts)
+		}
+	}
+}
+
+func TestListenTwice(t *testing.T) {
+	path := testPipe()
+	ln, err := Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	if ln, err := Listen(path); err == nil {
+		ln.Close()
+		t.Error("expected a second listener on the same pipe to fail")
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	path := testPipe()
+	ln, err := Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err == nil {
+			accepted <- c
+		}
+	}()
+
+	c, err := Dial(t.Context(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	s := <-accepted
+	defer s.Close()
+
+	if err := s.SetReadDeadline(time.Now().Add(10 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("expected the deadline to pass, got %v", err)
+	}
+
+	// a deadline in the past aborts a pending read
+	done := make(chan error)
+	s.SetReadDeadline(time.Time{}) //nolint:errcheck
+	go func() {
+		_, err := s.Read(make([]byte, 1))
+		done <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	s.SetReadDeadline(time.Unix(1, 0)) //nolint:errcheck
+	if err := <-done; !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("expected the read to be aborted, got %v", err)
+	}
+
+	// closing the client ends reads of the server
+	s.SetReadDeadline(time.Time{}) //nolint:errcheck
+	c.Close()
+	if _, err := s.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
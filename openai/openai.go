@@ -4,11 +4,13 @@ package openai
 import (
 	"bytes"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"math/rand"
 	"net/http"
 	"strings"
@@ -20,7 +22,24 @@ import (
 	"github.com/ollama/ollama/types/model"
 )
 
-var finishReasonToolCalls = "tool_calls"
+// toFinishReason maps an Ollama done reason to an OpenAI finish_reason. It
+// returns nil if the response is not yet complete.
+func toFinishReason(reason string) *string {
+	var finishReason string
+	switch reason {
+	case "":
+		return nil
+	case api.DoneReasonStop, api.DoneReasonLength, api.DoneReasonToolCalls, api.DoneReasonContentFilter:
+		finishReason = reason
+	case api.DoneReasonRepetitionDetected:
+		// the output was cut short before the model finished
+		finishReason = api.DoneReasonLength
+	default:
+		finishReason = api.DoneReasonStop
+	}
+
+	return &finishReason
+}
 
 type Error struct {
 	Message string      `json:"message"`
@@ -73,8 +92,10 @@ type JsonSchema struct {
 }
 
 type EmbedRequest struct {
-	Input any    `json:"input"`
-	Model string `json:"model"`
+	Input          any    `json:"input"`
+	Model          string `json:"model"`
+	Dimensions     int    `json:"dimensions,omitempty"`
+	EncodingFormat string `json:"encoding_format,omitempty"`
 }
 
 type StreamOptions struct {
@@ -87,6 +108,7 @@ type ChatCompletionRequest struct {
 	Stream           bool            `json:"stream"`
 	StreamOptions    *StreamOptions  `json:"stream_options"`
 	MaxTokens        *int            `json:"max_tokens"`
+	IgnoreEOS        bool            `json:"ignore_eos"`
 	Seed             *int            `json:"seed"`
 	Stop             any             `json:"stop"`
 	Temperature      *float64        `json:"temperature"`
@@ -123,6 +145,7 @@ type CompletionRequest struct {
 	Prompt           string         `json:"prompt"`
 	FrequencyPenalty float32        `json:"frequency_penalty"`
 	MaxTokens        *int           `json:"max_tokens"`
+	IgnoreEOS        bool           `json:"ignore_eos"`
 	PresencePenalty  float32        `json:"presence_penalty"`
 	Seed             *int           `json:"seed"`
 	Stop             any            `json:"stop"`
@@ -171,9 +194,12 @@ type Model struct {
 }
 
 type Embedding struct {
-	Object    string    `json:"object"`
-	Embedding []float32 `json:"embedding"`
-	Index     int       `json:"index"`
+	Object string `json:"object"`
+
+	// Embedding is a []float32, or a string with the base64 encoding of
+	// its little endian bytes if the request's encoding_format is base64
+	Embedding any `json:"embedding"`
+	Index     int `json:"index"`
 }
 
 type ListCompletion struct {
@@ -215,13 +241,18 @@ func toUsage(r api.ChatResponse) Usage {
 	}
 }
 
-func toolCallId() string {
+// randomId returns prefix followed by random lowercase letters and digits.
+func randomId(prefix string) string {
 	const letterBytes = "abcdefghijklmnopqrstuvwxyz0123456789"
 	b := make([]byte, 8)
 	for i := range b {
 		b[i] = letterBytes[rand.Intn(len(letterBytes))]
 	}
-	return "call_" + strings.ToLower(string(b))
+	return prefix + string(b)
+}
+
+func toolCallId() string {
+	return randomId("call_")
 }
 
 func toToolCalls(tc []api.ToolCall) []ToolCall {
@@ -243,6 +274,16 @@ func toToolCalls(tc []api.ToolCall) []ToolCall {
 	return toolCalls
 }
 
+// systemFingerprint identifies the model, template and engine that generated
+// a response.
+func systemFingerprint(f *api.Fingerprint) string {
+	if f == nil {
+		return "fp_ollama"
+	}
+
+	return f.SystemFingerprint()
+}
+
 func toChatCompletion(id string, r api.ChatResponse) ChatCompletion {
 	toolCalls := toToolCalls(r.Message.ToolCalls)
 	return ChatCompletion{
@@ -250,18 +291,15 @@ func toChatCompletion(id string, r api.ChatResponse) ChatCompletion {
 		Object:            "chat.completion",
 		Created:           r.CreatedAt.Unix(),
 		Model:             r.Model,
-		SystemFingerprint: "fp_ollama",
+		SystemFingerprint: systemFingerprint(r.Fingerprint),
 		Choices: []Choice{{
 			Index:   0,
 			Message: Message{Role: r.Message.Role, Content: r.Message.Content, ToolCalls: toolCalls},
 			FinishReason: func(reason string) *string {
 				if len(toolCalls) > 0 {
-					reason = "tool_calls"
-				}
-				if len(reason) > 0 {
-					return &reason
+					reason = api.DoneReasonToolCalls
 				}
-				return nil
+				return toFinishReason(reason)
 			}(r.DoneReason),
 		}},
 		Usage: toUsage(r),
@@ -275,18 +313,15 @@ func toChunk(id string, r api.ChatResponse, toolCallSent bool) ChatCompletionChu
 		Object:            "chat.completion.chunk",
 		Created:           time.Now().Unix(),
 		Model:             r.Model,
-		SystemFingerprint: "fp_ollama",
+		SystemFingerprint: systemFingerprint(r.Fingerprint),
 		Choices: []ChunkChoice{{
 			Index: 0,
 			Delta: Message{Role: "assistant", Content: r.Message.Content, ToolCalls: toolCalls},
 			FinishReason: func(reason string) *string {
-				if len(reason) > 0 {
-					if toolCallSent {
-						return &finishReasonToolCalls
-					}
-					return &reason
+				if len(reason) > 0 && toolCallSent {
+					reason = api.DoneReasonToolCalls
 				}
-				return nil
+				return toFinishReason(reason)
 			}(r.DoneReason),
 		}},
 	}
@@ -306,16 +341,11 @@ func toCompletion(id string, r api.GenerateResponse) Completion {
 		Object:            "text_completion",
 		Created:           r.CreatedAt.Unix(),
 		Model:             r.Model,
-		SystemFingerprint: "fp_ollama",
+		SystemFingerprint: systemFingerprint(r.Fingerprint),
 		Choices: []CompleteChunkChoice{{
-			Text:  r.Response,
-			Index: 0,
-			FinishReason: func(reason string) *string {
-				if len(reason) > 0 {
-					return &reason
-				}
-				return nil
-			}(r.DoneReason),
+			Text:         r.Response,
+			Index:        0,
+			FinishReason: toFinishReason(r.DoneReason),
 		}},
 		Usage: toUsageGenerate(r),
 	}
@@ -327,16 +357,11 @@ func toCompleteChunk(id string, r api.GenerateResponse) CompletionChunk {
 		Object:            "text_completion",
 		Created:           time.Now().Unix(),
 		Model:             r.Model,
-		SystemFingerprint: "fp_ollama",
+		SystemFingerprint: systemFingerprint(r.Fingerprint),
 		Choices: []CompleteChunkChoice{{
-			Text:  r.Response,
-			Index: 0,
-			FinishReason: func(reason string) *string {
-				if len(reason) > 0 {
-					return &reason
-				}
-				return nil
-			}(r.DoneReason),
+			Text:         r.Response,
+			Index:        0,
+			FinishReason: toFinishReason(r.DoneReason),
 		}},
 	}
 }
@@ -358,13 +383,13 @@ func toListCompletion(r api.ListResponse) ListCompletion {
 	}
 }
 
-func toEmbeddingList(model string, r api.EmbedResponse) EmbeddingList {
+func toEmbeddingList(model, format string, r api.EmbedResponse) EmbeddingList {
 	if r.Embeddings != nil {
-		var data []Embedding
+		data := make([]Embedding, 0, len(r.Embeddings))
 		for i, e := range r.Embeddings {
 			data = append(data, Embedding{
 				Object:    "embedding",
-				Embedding: e,
+				Embedding: encodeEmbedding(e, format),
 				Index:     i,
 			})
 		}
@@ -383,6 +408,20 @@ func toEmbeddingList(model string, r api.EmbedResponse) EmbeddingList {
 	return EmbeddingList{}
 }
 
+// encodeEmbedding returns e in the encoding_format of a request.
+func encodeEmbedding(e []float32, format string) any {
+	if format != "base64" {
+		return e
+	}
+
+	b := make([]byte, 4*len(e))
+	for i, f := range e {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(f))
+	}
+
+	return base64.StdEncoding.EncodeToString(b)
+}
+
 func toModel(r api.ShowResponse, m string) Model {
 	return Model{
 		Id:      m,
@@ -392,6 +431,32 @@ func toModel(r api.ShowResponse, m string) Model {
 	}
 }
 
+// decodeImageURL decodes the image of a data URL, which is how images are
+// sent in messages.
+func decodeImageURL(url string) (api.ImageData, error) {
+	types := []string{"jpeg", "jpg", "png"}
+	valid := false
+	for _, t := range types {
+		prefix := "data:image/" + t + ";base64,"
+		if strings.HasPrefix(url, prefix) {
+			url = strings.TrimPrefix(url, prefix)
+			valid = true
+			break
+		}
+	}
+
+	if !valid {
+		return nil, errors.New("invalid image input")
+	}
+
+	img, err := base64.StdEncoding.DecodeString(url)
+	if err != nil {
+		return nil, errors.New("invalid message format")
+	}
+
+	return img, nil
+}
+
 func fromChatRequest(r ChatCompletionRequest) (*api.ChatRequest, error) {
 	var messages []api.Message
 	for _, msg := range r.Messages {
@@ -423,24 +488,9 @@ func fromChatRequest(r ChatCompletionRequest) (*api.ChatRequest, error) {
 						}
 					}
 
-					types := []string{"jpeg", "jpg", "png"}
-					valid := false
-					for _, t := range types {
-						prefix := "data:image/" + t + ";base64,"
-						if strings.HasPrefix(url, prefix) {
-							url = strings.TrimPrefix(url, prefix)
-							valid = true
-							break
-						}
-					}
-
-					if !valid {
-						return nil, errors.New("invalid image input")
-					}
-
-					img, err := base64.StdEncoding.DecodeString(url)
+					img, err := decodeImageURL(url)
 					if err != nil {
-						return nil, errors.New("invalid message format")
+						return nil, err
 					}
 
 					messages = append(messages, api.Message{Role: msg.Role, Images: []api.ImageData{img}})
@@ -484,6 +534,10 @@ func fromChatRequest(r ChatCompletionRequest) (*api.ChatRequest, error) {
 		options["num_predict"] = *r.MaxTokens
 	}
 
+	if r.IgnoreEOS {
+		options["ignore_eos"] = true
+	}
+
 	if r.Temperature != nil {
 		options["temperature"] = *r.Temperature
 	} else {
@@ -553,6 +607,10 @@ func fromCompleteRequest(r CompletionRequest) (api.GenerateRequest, error) {
 		options["num_predict"] = *r.MaxTokens
 	}
 
+	if r.IgnoreEOS {
+		options["ignore_eos"] = true
+	}
+
 	if r.Temperature != nil {
 		options["temperature"] = *r.Temperature
 	} else {
@@ -612,7 +670,8 @@ type RetrieveWriter struct {
 
 type EmbedWriter struct {
 	BaseWriter
-	model string
+	model  string
+	format string
 }
 
 func (w *BaseWriter) writeError(data []byte) (int, error) {
@@ -640,6 +699,11 @@ func (w *ChatWriter) writeResponse(data []byte) (int, error) {
 
 	// chat chunk
 	if w.stream {
+		// tool calls are sent whole once they are complete
+		if len(chatResponse.ToolCallDeltas) > 0 && chatResponse.Message.Content == "" && len(chatResponse.Message.ToolCalls) == 0 && !chatResponse.Done {
+			return len(data), nil
+		}
+
 		c := toChunk(w.id, chatResponse, w.toolCallSent)
 		d, err := json.Marshal(c)
 		if err != nil {
@@ -822,7 +886,7 @@ func (w *EmbedWriter) writeResponse(data []byte) (int, error) {
 	}
 
 	w.ResponseWriter.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w.ResponseWriter).Encode(toEmbeddingList(w.model, embedResponse))
+	err = json.NewEncoder(w.ResponseWriter).Encode(toEmbeddingList(w.model, w.format, embedResponse))
 	if err != nil {
 		return 0, err
 	}
@@ -931,8 +995,15 @@ func EmbeddingsMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		switch req.EncodingFormat {
+		case "", "float", "base64":
+		default:
+			c.AbortWithStatusJSON(http.StatusBadRequest, NewError(http.StatusBadRequest, fmt.Sprintf("invalid encoding_format %q, expected float or base64", req.EncodingFormat)))
+			return
+		}
+
 		var b bytes.Buffer
-		if err := json.NewEncoder(&b).Encode(api.EmbedRequest{Model: req.Model, Input: req.Input}); err != nil {
+		if err := json.NewEncoder(&b).Encode(api.EmbedRequest{Model: req.Model, Input: req.Input, Dimensions: req.Dimensions}); err != nil {
 			c.AbortWithStatusJSON(http.StatusInternalServerError, NewError(http.StatusInternalServerError, err.Error()))
 			return
 		}
@@ -942,6 +1013,7 @@ func EmbeddingsMiddleware() gin.HandlerFunc {
 		w := &EmbedWriter{
 			BaseWriter: BaseWriter{ResponseWriter: c.Writer},
 			model:      req.Model,
+			format:     req.EncodingFormat,
 		}
 
 		c.Writer = w
@@ -426,6 +426,29 @@ func TestCompletionsMiddleware(t *testing.T) {
 				Stream: &True,
 			},
 		},
+		{
+			name: "completions handler with ignore_eos",
+			body: `{
+				"model": "test-model",
+				"prompt": "Hello",
+				"max_tokens": 128,
+				"ignore_eos": true,
+				"temperature": 0.8
+			}`,
+			req: api.GenerateRequest{
+				Model:  "test-model",
+				Prompt: "Hello",
+				Options: map[string]any{
+					"num_predict":       128.0,
+					"ignore_eos":        true,
+					"frequency_penalty": 0.0,
+					"presence_penalty":  0.0,
+					"temperature":       0.8,
+					"top_p":             1.0,
+				},
+				Stream: &False,
+			},
+		},
 		{
 			name: "completions handler stream with usage",
 			body: `{
@@ -539,6 +562,45 @@ func TestEmbeddingsMiddleware(t *testing.T) {
 				Model: "test-model",
 			},
 		},
+		{
+			name: "embed handler dimensions",
+			body: `{
+				"input": "Hello",
+				"model": "test-model",
+				"dimensions": 256
+			}`,
+			req: api.EmbedRequest{
+				Input:      "Hello",
+				Model:      "test-model",
+				Dimensions: 256,
+			},
+		},
+		{
+			name: "embed handler encoding format",
+			body: `{
+				"input": ["Hello", "World"],
+				"model": "test-model",
+				"encoding_format": "base64"
+			}`,
+			req: api.EmbedRequest{
+				Input: []any{"Hello", "World"},
+				Model: "test-model",
+			},
+		},
+		{
+			name: "embed handler invalid encoding format",
+			body: `{
+				"input": "Hello",
+				"model": "test-model",
+				"encoding_format": "int8"
+			}`,
+			err: ErrorResponse{
+				Error: Error{
+					Message: `invalid encoding_format "int8", expected float or base64`,
+					Type:    "invalid_request_error",
+				},
+			},
+		},
 		{
 			name: "embed handler error forwarding",
 			body: `{
@@ -590,6 +652,54 @@ func TestEmbeddingsMiddleware(t *testing.T) {
 	}
 }
 
+func TestEmbeddingsResponse(t *testing.T) {
+	endpoint := func(c *gin.Context) {
+		c.JSON(http.StatusOK, api.EmbedResponse{
+			Embeddings:      [][]float32{{1, 0.5}, {-2, 0.25}},
+			PromptEvalCount: 4,
+		})
+	}
+
+	cases := []struct {
+		format string
+		data   string
+	}{
+		{"", `[{"object": "embedding", "embedding": [1, 0.5], "index": 0}, {"object": "embedding", "embedding": [-2, 0.25], "index": 1}]`},
+		{"float", `[{"object": "embedding", "embedding": [1, 0.5], "index": 0}, {"object": "embedding", "embedding": [-2, 0.25], "index": 1}]`},
+		{"base64", `[{"object": "embedding", "embedding": "AACAPwAAAD8=", "index": 0}, {"object": "embedding", "embedding": "AAAAwAAAgD4=", "index": 1}]`},
+	}
+
+	gin.SetMode(gin.TestMode)
+
+	for _, tt := range cases {
+		t.Run(tt.format, func(t *testing.T) {
+			router := gin.New()
+			router.Use(EmbeddingsMiddleware())
+			router.Handle(http.MethodPost, "/api/embed", endpoint)
+
+			body := `{"model": "test-model", "input": ["Hello", "World"], "encoding_format": "` + tt.format + `"}`
+			req, _ := http.NewRequest(http.MethodPost, "/api/embed", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+
+			var expected, actual map[string]any
+			if err := json.Unmarshal([]byte(`{"object": "list", "model": "test-model", "usage": {"prompt_tokens": 4, "total_tokens": 4}, "data": `+tt.data+`}`), &expected); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := json.Unmarshal(resp.Body.Bytes(), &actual); err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(expected, actual); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
 func TestListMiddleware(t *testing.T) {
 	type testCase struct {
 		name     string
@@ -727,3 +837,26 @@ func TestRetrieveMiddleware(t *testing.T) {
 		}
 	}
 }
+
+func TestToFinishReason(t *testing.T) {
+	ptr := func(s string) *string { return &s }
+
+	cases := map[string]*string{
+		"":                               nil,
+		api.DoneReasonStop:               ptr("stop"),
+		api.DoneReasonLength:             ptr("length"),
+		api.DoneReasonToolCalls:          ptr("tool_calls"),
+		api.DoneReasonContentFilter:      ptr("content_filter"),
+		api.DoneReasonRepetitionDetected: ptr("length"),
+		api.DoneReasonCanceled:           ptr("stop"),
+		api.DoneReasonError:              ptr("stop"),
+	}
+
+	for reason, want := range cases {
+		t.Run(reason, func(t *testing.T) {
+			if diff := cmp.Diff(want, toFinishReason(reason)); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,681 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math/rand"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+// ResponsesRequest is a request to the Responses API, which newer OpenAI
+// SDKs use instead of chat completions. Responses aren't stored, so the input
+// of a request must include the whole conversation.
+type ResponsesRequest struct {
+	Model              string          `json:"model"`
+	Input              json.RawMessage `json:"input"`
+	Instructions       string          `json:"instructions"`
+	Stream             bool            `json:"stream"`
+	MaxOutputTokens    *int            `json:"max_output_tokens"`
+	Temperature        *float64        `json:"temperature"`
+	TopP               *float64        `json:"top_p"`
+	Tools              []ResponsesTool `json:"tools"`
+	Text               *ResponsesText  `json:"text"`
+	PreviousResponseID string          `json:"previous_response_id"`
+}
+
+// ResponsesInputItem is a message, a function call of the model or the
+// output of a function call in the input of a [ResponsesRequest].
+type ResponsesInputItem struct {
+	Type string `json:"type"`
+
+	// Role and Content are those of messages. Content is a string or a
+	// list of input_text, output_text and input_image parts.
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+
+	// CallID is set on function calls and their outputs
+	CallID    string `json:"call_id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+	Output    string `json:"output"`
+}
+
+type ResponsesContentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	ImageURL string `json:"image_url"`
+}
+
+// ResponsesTool is a function the model may call. Unlike in chat
+// completions, the function isn't nested in the tool.
+type ResponsesTool struct {
+	Type        string          `json:"type"`
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Parameters  json.RawMessage `json:"parameters"`
+}
+
+type ResponsesText struct {
+	Format *ResponsesTextFormat `json:"format"`
+}
+
+type ResponsesTextFormat struct {
+	Type   string          `json:"type"`
+	Name   string          `json:"name,omitempty"`
+	Schema json.RawMessage `json:"schema,omitempty"`
+}
+
+type Response struct {
+	Id                string             `json:"id"`
+	Object            string             `json:"object"`
+	CreatedAt         int64              `json:"created_at"`
+	Status            string             `json:"status"`
+	Model             string             `json:"model"`
+	Output            []any              `json:"output"`
+	IncompleteDetails *IncompleteDetails `json:"incomplete_details"`
+	Error             *ResponseError     `json:"error"`
+	Usage             *ResponseUsage     `json:"usage"`
+}
+
+type IncompleteDetails struct {
+	Reason string `json:"reason"`
+}
+
+type ResponseError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type ResponseUsage struct {
+	InputTokens        int `json:"input_tokens"`
+	OutputTokens       int `json:"output_tokens"`
+	TotalTokens        int `json:"total_tokens"`
+	InputTokensDetails struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"input_tokens_details"`
+	OutputTokensDetails struct {
+		ReasoningTokens int `json:"reasoning_tokens"`
+	} `json:"output_tokens_details"`
+}
+
+// ResponseMessage is an output item with the text of the model.
+type ResponseMessage struct {
+	Type    string            `json:"type"`
+	Id      string            `json:"id"`
+	Status  string            `json:"status"`
+	Role    string            `json:"role"`
+	Content []ResponseContent `json:"content"`
+}
+
+type ResponseContent struct {
+	Type        string `json:"type"`
+	Text        string `json:"text"`
+	Annotations []any  `json:"annotations"`
+}
+
+// ResponseFunctionCall is an output item with a tool call of the model.
+type ResponseFunctionCall struct {
+	Type      string `json:"type"`
+	Id        string `json:"id"`
+	CallId    string `json:"call_id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+	Status    string `json:"status"`
+}
+
+func newResponseMessage() *ResponseMessage {
+	return &ResponseMessage{
+		Type:    "message",
+		Id:      randomId("msg_"),
+		Status:  "in_progress",
+		Role:    "assistant",
+		Content: []ResponseContent{},
+	}
+}
+
+func newOutputText(text string) ResponseContent {
+	return ResponseContent{Type: "output_text", Text: text, Annotations: []any{}}
+}
+
+func newResponseFunctionCall(name string) *ResponseFunctionCall {
+	return &ResponseFunctionCall{
+		Type:   "function_call",
+		Id:     randomId("fc_"),
+		CallId: toolCallId(),
+		Name:   name,
+		Status: "in_progress",
+	}
+}
+
+func toResponseUsage(r api.ChatResponse) *ResponseUsage {
+	return &ResponseUsage{
+		InputTokens:  r.PromptEvalCount,
+		OutputTokens: r.EvalCount,
+		TotalTokens:  r.PromptEvalCount + r.EvalCount,
+	}
+}
+
+// toResponseStatus returns the status of a response that is done for reason,
+// and why it is incomplete if it is.
+func toResponseStatus(reason string) (string, *IncompleteDetails) {
+	switch reason {
+	case api.DoneReasonLength, api.DoneReasonRepetitionDetected:
+		return "incomplete", &IncompleteDetails{Reason: "max_output_tokens"}
+	case api.DoneReasonContentFilter:
+		return "incomplete", &IncompleteDetails{Reason: "content_filter"}
+	default:
+		return "completed", nil
+	}
+}
+
+func toolCallArguments(tc api.ToolCall) string {
+	args, err := json.Marshal(tc.Function.Arguments)
+	if err != nil {
+		return "{}"
+	}
+
+	return string(args)
+}
+
+func toResponse(id string, createdAt int64, r api.ChatResponse) Response {
+	output := []any{}
+	if r.Message.Content != "" {
+		m := newResponseMessage()
+		m.Status = "completed"
+		m.Content = append(m.Content, newOutputText(r.Message.Content))
+		output = append(output, m)
+	}
+
+	for _, tc := range r.Message.ToolCalls {
+		call := newResponseFunctionCall(tc.Function.Name)
+		call.Arguments = toolCallArguments(tc)
+		call.Status = "completed"
+		output = append(output, call)
+	}
+
+	status, details := toResponseStatus(r.DoneReason)
+	return Response{
+		Id:                id,
+		Object:            "response",
+		CreatedAt:         createdAt,
+		Status:            status,
+		Model:             r.Model,
+		Output:            output,
+		IncompleteDetails: details,
+		Usage:             toResponseUsage(r),
+	}
+}
+
+func fromResponsesInput(input json.RawMessage) ([]api.Message, error) {
+	var text string
+	if err := json.Unmarshal(input, &text); err == nil {
+		return []api.Message{{Role: "user", Content: text}}, nil
+	}
+
+	var items []ResponsesInputItem
+	if err := json.Unmarshal(input, &items); err != nil {
+		return nil, errors.New("invalid input, expected a string or a list of items")
+	}
+
+	var messages []api.Message
+	for _, item := range items {
+		switch item.Type {
+		case "", "message":
+			role := item.Role
+			if role == "developer" {
+				role = "system"
+			}
+
+			var content string
+			if err := json.Unmarshal(item.Content, &content); err == nil {
+				messages = append(messages, api.Message{Role: role, Content: content})
+				continue
+			}
+
+			var parts []ResponsesContentPart
+			if err := json.Unmarshal(item.Content, &parts); err != nil {
+				return nil, errors.New("invalid message content")
+			}
+
+			for _, part := range parts {
+				switch part.Type {
+				case "input_text", "output_text":
+					messages = append(messages, api.Message{Role: role, Content: part.Text})
+				case "input_image":
+					img, err := decodeImageURL(part.ImageURL)
+					if err != nil {
+						return nil, err
+					}
+
+					messages = append(messages, api.Message{Role: role, Images: []api.ImageData{img}})
+				default:
+					return nil, fmt.Errorf("unsupported content type %q", part.Type)
+				}
+			}
+		case "function_call":
+			var tc api.ToolCall
+			tc.Function.Name = item.Name
+			if err := json.Unmarshal([]byte(item.Arguments), &tc.Function.Arguments); err != nil {
+				return nil, errors.New("invalid tool call arguments")
+			}
+
+			// parallel calls are made in one assistant message
+			if n := len(messages); n > 0 && messages[n-1].Role == "assistant" && len(messages[n-1].ToolCalls) > 0 {
+				messages[n-1].ToolCalls = append(messages[n-1].ToolCalls, tc)
+				continue
+			}
+
+			messages = append(messages, api.Message{Role: "assistant", ToolCalls: []api.ToolCall{tc}})
+		case "function_call_output":
+			messages = append(messages, api.Message{Role: "tool", Content: item.Output})
+		default:
+			return nil, fmt.Errorf("unsupported input item type %q", item.Type)
+		}
+	}
+
+	return messages, nil
+}
+
+func fromResponsesRequest(r ResponsesRequest) (*api.ChatRequest, error) {
+	if r.PreviousResponseID != "" {
+		return nil, errors.New("previous_response_id is not supported, include the conversation in input instead")
+	}
+
+	if len(r.Input) == 0 {
+		return nil, errors.New("input is required")
+	}
+
+	messages, err := fromResponsesInput(r.Input)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Instructions != "" {
+		messages = append([]api.Message{{Role: "system", Content: r.Instructions}}, messages...)
+	}
+
+	options := make(map[string]any)
+
+	if r.MaxOutputTokens != nil {
+		options["num_predict"] = *r.MaxOutputTokens
+	}
+
+	if r.Temperature != nil {
+		options["temperature"] = *r.Temperature
+	} else {
+		options["temperature"] = 1.0
+	}
+
+	if r.TopP != nil {
+		options["top_p"] = *r.TopP
+	} else {
+		options["top_p"] = 1.0
+	}
+
+	var tools []api.Tool
+	for _, t := range r.Tools {
+		if t.Type != "function" {
+			return nil, fmt.Errorf("unsupported tool type %q", t.Type)
+		}
+
+		tool := api.Tool{Type: "function"}
+		tool.Function.Name = t.Name
+		tool.Function.Description = t.Description
+		if len(t.Parameters) > 0 {
+			if err := json.Unmarshal(t.Parameters, &tool.Function.Parameters); err != nil {
+				return nil, fmt.Errorf("invalid parameters of tool %q", t.Name)
+			}
+		}
+
+		tools = append(tools, tool)
+	}
+
+	var format json.RawMessage
+	if r.Text != nil && r.Text.Format != nil {
+		switch r.Text.Format.Type {
+		case "json_object":
+			format = json.RawMessage(`"json"`)
+		case "json_schema":
+			format = r.Text.Format.Schema
+		}
+	}
+
+	return &api.ChatRequest{
+		Model:    r.Model,
+		Messages: messages,
+		Format:   format,
+		Options:  options,
+		Stream:   &r.Stream,
+		Tools:    tools,
+	}, nil
+}
+
+// ResponsesWriter translates chat responses to a response or, when
+// streaming, to the semantic events of the Responses API.
+type ResponsesWriter struct {
+	BaseWriter
+	stream    bool
+	id        string
+	createdAt int64
+
+	// sequence is the number of events sent
+	sequence int
+
+	// output are the items of the streamed response. text is the message
+	// that is being streamed, if any, and calls are the function calls
+	// that are being streamed by the index of their tool call.
+	output []any
+	text   *ResponseMessage
+	calls  map[int]*ResponseFunctionCall
+}
+
+// event sends an event of type typ with fields.
+func (w *ResponsesWriter) event(typ string, fields map[string]any) error {
+	fields["type"] = typ
+	fields["sequence_number"] = w.sequence
+	w.sequence++
+
+	d, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.ResponseWriter.Write([]byte(fmt.Sprintf("event: %s\ndata: %s\n\n", typ, d)))
+	return err
+}
+
+func (w *ResponsesWriter) response(status, model string) *Response {
+	return &Response{
+		Id:        w.id,
+		Object:    "response",
+		CreatedAt: w.createdAt,
+		Status:    status,
+		Model:     model,
+		Output:    append([]any{}, w.output...),
+	}
+}
+
+func (w *ResponsesWriter) outputIndex(item any) int {
+	for i, o := range w.output {
+		if o == item {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (w *ResponsesWriter) addText(text string) error {
+	if w.text == nil {
+		w.text = newResponseMessage()
+		w.output = append(w.output, w.text)
+
+		if err := w.event("response.output_item.added", map[string]any{
+			"output_index": len(w.output) - 1,
+			"item":         w.text,
+		}); err != nil {
+			return err
+		}
+
+		w.text.Content = append(w.text.Content, newOutputText(""))
+		if err := w.event("response.content_part.added", map[string]any{
+			"item_id":       w.text.Id,
+			"output_index":  len(w.output) - 1,
+			"content_index": 0,
+			"part":          w.text.Content[0],
+		}); err != nil {
+			return err
+		}
+	}
+
+	w.text.Content[0].Text += text
+	return w.event("response.output_text.delta", map[string]any{
+		"item_id":       w.text.Id,
+		"output_index":  w.outputIndex(w.text),
+		"content_index": 0,
+		"delta":         text,
+	})
+}
+
+func (w *ResponsesWriter) closeText() error {
+	if w.text == nil {
+		return nil
+	}
+
+	m, i := w.text, w.outputIndex(w.text)
+	w.text = nil
+
+	if err := w.event("response.output_text.done", map[string]any{
+		"item_id":       m.Id,
+		"output_index":  i,
+		"content_index": 0,
+		"text":          m.Content[0].Text,
+	}); err != nil {
+		return err
+	}
+
+	if err := w.event("response.content_part.done", map[string]any{
+		"item_id":       m.Id,
+		"output_index":  i,
+		"content_index": 0,
+		"part":          m.Content[0],
+	}); err != nil {
+		return err
+	}
+
+	m.Status = "completed"
+	return w.event("response.output_item.done", map[string]any{
+		"output_index": i,
+		"item":         m,
+	})
+}
+
+// call returns the function call with the index of a tool call, which is
+// added to the output if it hasn't been yet.
+func (w *ResponsesWriter) call(index int, name string) (*ResponseFunctionCall, error) {
+	if call, ok := w.calls[index]; ok {
+		return call, nil
+	}
+
+	if err := w.closeText(); err != nil {
+		return nil, err
+	}
+
+	call := newResponseFunctionCall(name)
+	w.calls[index] = call
+	w.output = append(w.output, call)
+
+	return call, w.event("response.output_item.added", map[string]any{
+		"output_index": len(w.output) - 1,
+		"item":         call,
+	})
+}
+
+func (w *ResponsesWriter) addArguments(call *ResponseFunctionCall, arguments string) error {
+	call.Arguments += arguments
+	return w.event("response.function_call_arguments.delta", map[string]any{
+		"item_id":      call.Id,
+		"output_index": w.outputIndex(call),
+		"delta":        arguments,
+	})
+}
+
+func (w *ResponsesWriter) closeCall(index int, call *ResponseFunctionCall) error {
+	delete(w.calls, index)
+
+	i := w.outputIndex(call)
+	if err := w.event("response.function_call_arguments.done", map[string]any{
+		"item_id":      call.Id,
+		"output_index": i,
+		"arguments":    call.Arguments,
+	}); err != nil {
+		return err
+	}
+
+	call.Status = "completed"
+	return w.event("response.output_item.done", map[string]any{
+		"output_index": i,
+		"item":         call,
+	})
+}
+
+func (w *ResponsesWriter) writeEvents(r api.ChatResponse, errMessage string) error {
+	if w.sequence == 0 {
+		res := w.response("in_progress", r.Model)
+		if err := w.event("response.created", map[string]any{"response": res}); err != nil {
+			return err
+		}
+
+		if err := w.event("response.in_progress", map[string]any{"response": res}); err != nil {
+			return err
+		}
+	}
+
+	if errMessage != "" {
+		res := w.response("failed", r.Model)
+		res.Error = &ResponseError{Code: "server_error", Message: errMessage}
+		return w.event("response.failed", map[string]any{"response": res})
+	}
+
+	if r.Message.Content != "" {
+		if err := w.addText(r.Message.Content); err != nil {
+			return err
+		}
+	}
+
+	for _, d := range r.ToolCallDeltas {
+		call, err := w.call(d.Index, d.Name)
+		if err != nil {
+			return err
+		}
+
+		if d.Arguments != "" {
+			if err := w.addArguments(call, d.Arguments); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, tc := range r.Message.ToolCalls {
+		call, err := w.call(tc.Function.Index, tc.Function.Name)
+		if err != nil {
+			return err
+		}
+
+		// the arguments are sent whole unless they were streamed
+		if call.Arguments == "" {
+			if err := w.addArguments(call, toolCallArguments(tc)); err != nil {
+				return err
+			}
+		}
+
+		if err := w.closeCall(tc.Function.Index, call); err != nil {
+			return err
+		}
+	}
+
+	if !r.Done {
+		return nil
+	}
+
+	if err := w.closeText(); err != nil {
+		return err
+	}
+
+	// calls whose deltas didn't form a complete tool call
+	for _, index := range slices.Sorted(maps.Keys(w.calls)) {
+		if err := w.closeCall(index, w.calls[index]); err != nil {
+			return err
+		}
+	}
+
+	status, details := toResponseStatus(r.DoneReason)
+	res := w.response(status, r.Model)
+	res.IncompleteDetails = details
+	res.Usage = toResponseUsage(r)
+
+	return w.event("response."+status, map[string]any{"response": res})
+}
+
+func (w *ResponsesWriter) writeResponse(data []byte) (int, error) {
+	var chatResponse struct {
+		api.ChatResponse
+		Error string `json:"error"`
+	}
+
+	if err := json.Unmarshal(data, &chatResponse); err != nil {
+		return 0, err
+	}
+
+	if w.stream {
+		w.ResponseWriter.Header().Set("Content-Type", "text/event-stream")
+		if err := w.writeEvents(chatResponse.ChatResponse, chatResponse.Error); err != nil {
+			return 0, err
+		}
+
+		return len(data), nil
+	}
+
+	w.ResponseWriter.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w.ResponseWriter).Encode(toResponse(w.id, w.createdAt, chatResponse.ChatResponse)); err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+func (w *ResponsesWriter) Write(data []byte) (int, error) {
+	code := w.ResponseWriter.Status()
+	if code != http.StatusOK {
+		return w.writeError(data)
+	}
+
+	return w.writeResponse(data)
+}
+
+func ResponsesMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ResponsesRequest
+		err := c.ShouldBindJSON(&req)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, NewError(http.StatusBadRequest, err.Error()))
+			return
+		}
+
+		chatReq, err := fromResponsesRequest(req)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, NewError(http.StatusBadRequest, err.Error()))
+			return
+		}
+
+		var b bytes.Buffer
+		if err := json.NewEncoder(&b).Encode(chatReq); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, NewError(http.StatusInternalServerError, err.Error()))
+			return
+		}
+
+		c.Request.Body = io.NopCloser(&b)
+
+		w := &ResponsesWriter{
+			BaseWriter: BaseWriter{ResponseWriter: c.Writer},
+			stream:     req.Stream,
+			id:         fmt.Sprintf("resp_%d", rand.Intn(999)),
+			createdAt:  time.Now().Unix(),
+			calls:      make(map[int]*ResponseFunctionCall),
+		}
+
+		c.Writer = w
+
+		c.Next()
+	}
+}
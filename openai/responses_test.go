@@ -0,0 +1,439 @@
+package openai
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestResponsesMiddleware(t *testing.T) {
+	type testCase struct {
+		name string
+		body string
+		req  api.ChatRequest
+		err  ErrorResponse
+	}
+
+	var capturedRequest *api.ChatRequest
+
+	testCases := []testCase{
+		{
+			name: "string input",
+			body: `{
+				"model": "test-model",
+				"instructions": "Be brief.",
+				"input": "Hello",
+				"max_output_tokens": 10
+			}`,
+			req: api.ChatRequest{
+				Model: "test-model",
+				Messages: []api.Message{
+					{Role: "system", Content: "Be brief."},
+					{Role: "user", Content: "Hello"},
+				},
+				Options: map[string]any{
+					"num_predict": 10.0,
+					"temperature": 1.0,
+					"top_p":       1.0,
+				},
+				Stream: &False,
+			},
+		},
+		{
+			name: "input items",
+			body: `{
+				"model": "test-model",
+				"stream": true,
+				"input": [
+					{"role": "developer", "content": "Use tools."},
+					{"type": "message", "role": "user", "content": [{"type": "input_text", "text": "What's in the image?"}, {"type": "input_image", "image_url": "` + prefix + image + `"}]},
+					{"type": "function_call", "call_id": "call_1", "name": "describe", "arguments": "{\"detail\": \"high\"}"},
+					{"type": "function_call", "call_id": "call_2", "name": "count", "arguments": "{}"},
+					{"type": "function_call_output", "call_id": "call_1", "output": "a cat"},
+					{"type": "function_call_output", "call_id": "call_2", "output": "1"}
+				],
+				"text": {"format": {"type": "json_schema", "name": "answer", "schema": {"type": "object"}}}
+			}`,
+			req: api.ChatRequest{
+				Model: "test-model",
+				Messages: []api.Message{
+					{Role: "system", Content: "Use tools."},
+					{Role: "user", Content: "What's in the image?"},
+					{Role: "user", Images: []api.ImageData{
+						func() []byte {
+							img, _ := base64.StdEncoding.DecodeString(image)
+							return img
+						}(),
+					}},
+					{Role: "assistant", ToolCalls: []api.ToolCall{
+						{Function: api.ToolCallFunction{Name: "describe", Arguments: api.ToolCallFunctionArguments{"detail": "high"}}},
+						{Function: api.ToolCallFunction{Name: "count", Arguments: api.ToolCallFunctionArguments{}}},
+					}},
+					{Role: "tool", Content: "a cat"},
+					{Role: "tool", Content: "1"},
+				},
+				Format: json.RawMessage(`{"type":"object"}`),
+				Options: map[string]any{
+					"temperature": 1.0,
+					"top_p":       1.0,
+				},
+				Stream: &True,
+			},
+		},
+		{
+			name: "tools",
+			body: `{
+				"model": "test-model",
+				"input": "What's the weather in Paris?",
+				"tools": [{
+					"type": "function",
+					"name": "get_weather",
+					"description": "Get the current weather",
+					"parameters": {"type": "object", "required": ["location"], "properties": {"location": {"type": "string", "description": "The city"}}}
+				}]
+			}`,
+			req: api.ChatRequest{
+				Model:    "test-model",
+				Messages: []api.Message{{Role: "user", Content: "What's the weather in Paris?"}},
+				Tools: func() []api.Tool {
+					var tools []api.Tool
+					if err := json.Unmarshal([]byte(`[{"type": "function", "function": {
+						"name": "get_weather",
+						"description": "Get the current weather",
+						"parameters": {"type": "object", "required": ["location"], "properties": {"location": {"type": "string", "description": "The city"}}}
+					}}]`), &tools); err != nil {
+						t.Fatal(err)
+					}
+					return tools
+				}(),
+				Options: map[string]any{
+					"temperature": 1.0,
+					"top_p":       1.0,
+				},
+				Stream: &False,
+			},
+		},
+		{
+			name: "previous response",
+			body: `{"model": "test-model", "input": "Hello", "previous_response_id": "resp_1"}`,
+			err: ErrorResponse{
+				Error: Error{
+					Message: "previous_response_id is not supported, include the conversation in input instead",
+					Type:    "invalid_request_error",
+				},
+			},
+		},
+		{
+			name: "unsupported item",
+			body: `{"model": "test-model", "input": [{"type": "web_search_call"}]}`,
+			err: ErrorResponse{
+				Error: Error{
+					Message: `unsupported input item type "web_search_call"`,
+					Type:    "invalid_request_error",
+				},
+			},
+		},
+		{
+			name: "unsupported tool",
+			body: `{"model": "test-model", "input": "Hello", "tools": [{"type": "web_search_preview"}]}`,
+			err: ErrorResponse{
+				Error: Error{
+					Message: `unsupported tool type "web_search_preview"`,
+					Type:    "invalid_request_error",
+				},
+			},
+		},
+	}
+
+	endpoint := func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(ResponsesMiddleware(), captureRequestMiddleware(&capturedRequest))
+	router.Handle(http.MethodPost, "/api/chat", endpoint)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+
+			defer func() { capturedRequest = nil }()
+
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+
+			var errResp ErrorResponse
+			if resp.Code != http.StatusOK {
+				if err := json.Unmarshal(resp.Body.Bytes(), &errResp); err != nil {
+					t.Fatal(err)
+				}
+
+				if diff := cmp.Diff(tc.err, errResp); diff != "" {
+					t.Fatalf("errors did not match:\n%s", diff)
+				}
+				return
+			}
+
+			if diff := cmp.Diff(&tc.req, capturedRequest); diff != "" {
+				t.Fatalf("requests did not match: %+v", diff)
+			}
+		})
+	}
+}
+
+// respond returns a handler that writes the chat responses as the chat
+// handler does.
+func respond(responses ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Status(http.StatusOK)
+		for _, r := range responses {
+			if _, err := c.Writer.Write([]byte(r)); err != nil {
+				c.Error(err)
+				return
+			}
+		}
+	}
+}
+
+func responsesRouter(responses ...string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(ResponsesMiddleware())
+	router.Handle(http.MethodPost, "/api/chat", respond(responses...))
+	return router
+}
+
+func TestResponsesResponse(t *testing.T) {
+	router := responsesRouter(`{
+		"model": "test-model",
+		"message": {
+			"role": "assistant",
+			"content": "Let me check.",
+			"tool_calls": [{"function": {"name": "get_weather", "arguments": {"location": "Paris"}}}]
+		},
+		"done": true,
+		"done_reason": "tool_calls",
+		"prompt_eval_count": 10,
+		"eval_count": 5
+	}`)
+
+	req, _ := http.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(`{"model": "test-model", "input": "Hello"}`))
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	var res struct {
+		Id     string `json:"id"`
+		Object string `json:"object"`
+		Status string `json:"status"`
+		Model  string `json:"model"`
+		Output []struct {
+			Type      string            `json:"type"`
+			Role      string            `json:"role"`
+			Content   []ResponseContent `json:"content"`
+			Name      string            `json:"name"`
+			CallId    string            `json:"call_id"`
+			Arguments string            `json:"arguments"`
+		} `json:"output"`
+		Usage ResponseUsage `json:"usage"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(res.Id, "resp_") || res.Object != "response" || res.Status != "completed" || res.Model != "test-model" {
+		t.Errorf("unexpected response %+v", res)
+	}
+
+	if len(res.Output) != 2 {
+		t.Fatalf("expected 2 output items, got %d", len(res.Output))
+	}
+
+	if o := res.Output[0]; o.Type != "message" || o.Role != "assistant" || len(o.Content) != 1 || o.Content[0].Text != "Let me check." {
+		t.Errorf("unexpected message %+v", o)
+	}
+
+	if o := res.Output[1]; o.Type != "function_call" || o.Name != "get_weather" || o.Arguments != `{"location":"Paris"}` || !strings.HasPrefix(o.CallId, "call_") {
+		t.Errorf("unexpected function call %+v", o)
+	}
+
+	if res.Usage.InputTokens != 10 || res.Usage.OutputTokens != 5 || res.Usage.TotalTokens != 15 {
+		t.Errorf("unexpected usage %+v", res.Usage)
+	}
+}
+
+type responseEvent struct {
+	Type           string          `json:"type"`
+	SequenceNumber int             `json:"sequence_number"`
+	OutputIndex    int             `json:"output_index"`
+	Delta          string          `json:"delta"`
+	Text           string          `json:"text"`
+	Arguments      string          `json:"arguments"`
+	Item           json.RawMessage `json:"item"`
+	Response       *struct {
+		Status            string             `json:"status"`
+		Output            []json.RawMessage  `json:"output"`
+		IncompleteDetails *IncompleteDetails `json:"incomplete_details"`
+		Error             *ResponseError     `json:"error"`
+	} `json:"response"`
+}
+
+func streamEvents(t *testing.T, responses ...string) []responseEvent {
+	t.Helper()
+
+	req, _ := http.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(`{"model": "test-model", "input": "Hello", "stream": true}`))
+	resp := httptest.NewRecorder()
+	responsesRouter(responses...).ServeHTTP(resp, req)
+
+	if ct := resp.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %q", ct)
+	}
+
+	var events []responseEvent
+	var typ string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if after, ok := strings.CutPrefix(line, "event: "); ok {
+			typ = after
+		} else if after, ok := strings.CutPrefix(line, "data: "); ok {
+			var e responseEvent
+			if err := json.Unmarshal([]byte(after), &e); err != nil {
+				t.Fatal(err)
+			}
+
+			if e.Type != typ {
+				t.Errorf("event %q has data of type %q", typ, e.Type)
+			}
+
+			if e.SequenceNumber != len(events) {
+				t.Errorf("expected sequence number %d, got %d", len(events), e.SequenceNumber)
+			}
+
+			events = append(events, e)
+		}
+	}
+
+	return events
+}
+
+func eventTypes(events []responseEvent) []string {
+	types := make([]string, len(events))
+	for i, e := range events {
+		types[i] = e.Type
+	}
+	return types
+}
+
+func TestResponsesStream(t *testing.T) {
+	t.Run("text", func(t *testing.T) {
+		events := streamEvents(t,
+			`{"model": "test-model", "message": {"role": "assistant", "content": "Hello"}}`,
+			`{"model": "test-model", "message": {"role": "assistant", "content": " world"}}`,
+			`{"model": "test-model", "message": {"role": "assistant", "content": ""}, "done": true, "done_reason": "length"}`,
+		)
+
+		if diff := cmp.Diff([]string{
+			"response.created",
+			"response.in_progress",
+			"response.output_item.added",
+			"response.content_part.added",
+			"response.output_text.delta",
+			"response.output_text.delta",
+			"response.output_text.done",
+			"response.content_part.done",
+			"response.output_item.done",
+			"response.incomplete",
+		}, eventTypes(events)); diff != "" {
+			t.Fatalf("events did not match:\n%s", diff)
+		}
+
+		if events[4].Delta != "Hello" || events[5].Delta != " world" || events[6].Text != "Hello world" {
+			t.Errorf("unexpected text events %+v", events[4:7])
+		}
+
+		last := events[len(events)-1].Response
+		if last.Status != "incomplete" || last.IncompleteDetails == nil || last.IncompleteDetails.Reason != "max_output_tokens" || len(last.Output) != 1 {
+			t.Errorf("unexpected response %+v", last)
+		}
+	})
+
+	t.Run("tool calls", func(t *testing.T) {
+		events := streamEvents(t,
+			`{"model": "test-model", "message": {"role": "assistant", "content": "Checking."}}`,
+			`{"model": "test-model", "message": {"role": "assistant", "content": ""}, "tool_call_deltas": [{"index": 0, "name": "get_weather"}]}`,
+			`{"model": "test-model", "message": {"role": "assistant", "content": ""}, "tool_call_deltas": [{"index": 0, "arguments": "{\"location\": "}]}`,
+			`{"model": "test-model", "message": {"role": "assistant", "content": ""}, "tool_call_deltas": [{"index": 0, "arguments": "\"Paris\"}"}]}`,
+			`{"model": "test-model", "message": {"role": "assistant", "content": "", "tool_calls": [{"function": {"index": 0, "name": "get_weather", "arguments": {"location": "Paris"}}}, {"function": {"index": 1, "name": "get_time", "arguments": {}}}]}}`,
+			`{"model": "test-model", "message": {"role": "assistant", "content": ""}, "done": true, "done_reason": "tool_calls"}`,
+		)
+
+		if diff := cmp.Diff([]string{
+			"response.created",
+			"response.in_progress",
+			"response.output_item.added",
+			"response.content_part.added",
+			"response.output_text.delta",
+			"response.output_text.done",
+			"response.content_part.done",
+			"response.output_item.done",
+			"response.output_item.added",
+			"response.function_call_arguments.delta",
+			"response.function_call_arguments.delta",
+			"response.function_call_arguments.done",
+			"response.output_item.done",
+			"response.output_item.added",
+			"response.function_call_arguments.delta",
+			"response.function_call_arguments.done",
+			"response.output_item.done",
+			"response.completed",
+		}, eventTypes(events)); diff != "" {
+			t.Fatalf("events did not match:\n%s", diff)
+		}
+
+		var call ResponseFunctionCall
+		if err := json.Unmarshal(events[8].Item, &call); err != nil {
+			t.Fatal(err)
+		}
+
+		if events[8].OutputIndex != 1 || call.Name != "get_weather" || call.Arguments != "" || call.Status != "in_progress" {
+			t.Errorf("unexpected added function call %+v", call)
+		}
+
+		// streamed arguments are kept as they were generated
+		if events[11].Arguments != `{"location": "Paris"}` {
+			t.Errorf("unexpected arguments %q", events[11].Arguments)
+		}
+
+		if events[13].OutputIndex != 2 || events[15].Arguments != "{}" {
+			t.Errorf("unexpected second function call %+v %+v", events[13], events[15])
+		}
+
+		last := events[len(events)-1].Response
+		if last.Status != "completed" || len(last.Output) != 3 {
+			t.Errorf("unexpected response %+v", last)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		events := streamEvents(t,
+			`{"model": "test-model", "message": {"role": "assistant", "content": "Hello"}}`,
+			`{"error": "model runner has unexpectedly stopped"}`,
+		)
+
+		last := events[len(events)-1]
+		if last.Type != "response.failed" || last.Response.Error == nil || last.Response.Error.Message != "model runner has unexpectedly stopped" {
+			t.Errorf("unexpected last event %+v", last)
+		}
+	})
+}
@@ -11,6 +11,7 @@ import (
 	"os"
 	"os/user"
 	"path/filepath"
+	"regexp"
 	"slices"
 	"strconv"
 	"strings"
@@ -141,7 +142,10 @@ func fileDigestMap(path string) (map[string]string, error) {
 			return nil, err
 		}
 	} else {
-		files = []string{path}
+		files, err = shardsForModel(path)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	for _, f := range files {
@@ -155,6 +159,36 @@ func fileDigestMap(path string) (map[string]string, error) {
 	return fl, nil
 }
 
+// shardPattern matches the names llama.cpp gives to the files of a model split
+// into shards, e.g. model-00001-of-00003.gguf
+var shardPattern = regexp.MustCompile(`^(.*)-(\d{5})-of-(\d{5})\.gguf$`)
+
+// shardsForModel returns every file of the split model whose first shard is
+// path, or just path if it is not the first shard of a split model.
+func shardsForModel(path string) ([]string, error) {
+	m := shardPattern.FindStringSubmatch(path)
+	if m == nil || m[2] != "00001" {
+		return []string{path}, nil
+	}
+
+	count, err := strconv.Atoi(m[3])
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, count)
+	for i := range files {
+		files[i] = fmt.Sprintf("%s-%05d-of-%s.gguf", m[1], i+1, m[3])
+		if _, err := os.Stat(files[i]); err != nil {
+			// not wrapped so that a missing shard isn't mistaken for a
+			// model name
+			return nil, fmt.Errorf("missing shard of split model: %v", err)
+		}
+	}
+
+	return files, nil
+}
+
 func digestForFile(filename string) (string, error) {
 	filepath, err := filepath.EvalSymlinks(filename)
 	if err != nil {
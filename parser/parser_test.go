@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"unicode/utf16"
@@ -475,6 +476,7 @@ func TestParseFileParameters(t *testing.T) {
 		"numa true":                    {"numa", "true"},
 		"num_ctx 1":                    {"num_ctx", "1"},
 		"num_batch 1":                  {"num_batch", "1"},
+		"num_ubatch 1":                 {"num_ubatch", "1"},
 		"num_gqa 1":                    {"num_gqa", "1"},
 		"num_gpu 1":                    {"num_gpu", "1"},
 		"main_gpu 1":                   {"main_gpu", "1"},
@@ -484,6 +486,7 @@ func TestParseFileParameters(t *testing.T) {
 		"use_mmap true":                {"use_mmap", "true"},
 		"use_mlock true":               {"use_mlock", "true"},
 		"num_thread 1":                 {"num_thread", "1"},
+		"layer_split 1,3":              {"layer_split", "1,3"},
 		"num_keep 1":                   {"num_keep", "1"},
 		"seed 1":                       {"seed", "1"},
 		"num_predict 1":                {"num_predict", "1"},
@@ -830,3 +833,43 @@ func TestCreateRequestFiles(t *testing.T) {
 		}
 	}
 }
+
+func TestCreateRequestShards(t *testing.T) {
+	dir := t.TempDir()
+
+	var files []string
+	var digests []string
+	for i := range 2 {
+		name := filepath.Join(dir, fmt.Sprintf("model-%05d-of-00002.gguf", i+1))
+		if err := os.WriteFile(name, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		digest, _ := getSHA256Digest(t, strings.NewReader(name))
+		files = append(files, name)
+		digests = append(digests, digest)
+	}
+
+	p, err := ParseFile(strings.NewReader("FROM " + files[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := p.CreateRequest("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &api.CreateRequest{Files: map[string]string{files[0]: digests[0], files[1]: digests[1]}}
+	if diff := cmp.Diff(actual, expected); diff != "" {
+		t.Errorf("mismatch (-got +want):\n%s", diff)
+	}
+
+	if err := os.Remove(files[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.CreateRequest(""); err == nil || !strings.Contains(err.Error(), "missing shard") {
+		t.Errorf("expected missing shard error, got %v", err)
+	}
+}
@@ -0,0 +1,34 @@
+package common
+
+import "math"
+
+// EOSProbability returns the probability of sampling one of the eos tokens
+// from logits before any sampling transforms are applied.
+func EOSProbability(logits []float32, eos []int32) float32 {
+	if len(eos) == 0 || len(logits) == 0 {
+		return 0
+	}
+
+	maxLogit := float32(math.Inf(-1))
+	for _, l := range logits {
+		maxLogit = max(maxLogit, l)
+	}
+
+	if math.IsInf(float64(maxLogit), -1) {
+		return 0
+	}
+
+	var sum float64
+	for _, l := range logits {
+		sum += math.Exp(float64(l - maxLogit))
+	}
+
+	var eosSum float64
+	for _, id := range eos {
+		if int(id) < len(logits) {
+			eosSum += math.Exp(float64(logits[id] - maxLogit))
+		}
+	}
+
+	return float32(eosSum / sum)
+}
@@ -0,0 +1,30 @@
+package common
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEOSProbability(t *testing.T) {
+	tests := []struct {
+		name   string
+		logits []float32
+		eos    []int32
+		expect float32
+	}{
+		{"uniform", []float32{1, 1, 1, 1}, []int32{3}, 0.25},
+		{"multiple eos", []float32{1, 1, 1, 1}, []int32{2, 3}, 0.5},
+		{"certain", []float32{float32(math.Inf(-1)), 0}, []int32{1}, 1},
+		{"no eos", []float32{1, 2, 3}, nil, 0},
+		{"out of range", []float32{1, 1}, []int32{5}, 0},
+		{"large logits", []float32{1000, 1000}, []int32{0}, 0.5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EOSProbability(tt.logits, tt.eos); math.Abs(float64(got-tt.expect)) > 1e-6 {
+				t.Errorf("expected %v, got %v", tt.expect, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package common
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+)
+
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+
+	// lowest priority of the best effort class, equivalent to ionice -c2 -n7
+	ioprioLow = 2<<ioprioClassShift | 7
+)
+
+// LowerIOPriority moves every thread of the process to the lowest best effort
+// I/O priority so that reading a model yields to other disk users. It returns
+// a function that restores the previous priorities.
+func LowerIOPriority() (func(), error) {
+	tids, err := threads()
+	if err != nil {
+		return nil, err
+	}
+
+	prev := make(map[int]uintptr)
+	restore := func() {
+		tids, _ := threads()
+		for _, tid := range tids {
+			// threads started while lowered get the default priority
+			syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), prev[tid]) //nolint:errcheck
+		}
+	}
+
+	for _, tid := range tids {
+		p, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_GET, ioprioWhoProcess, uintptr(tid), 0)
+		if errno != 0 {
+			// the thread has exited
+			continue
+		}
+
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), ioprioLow); errno != 0 {
+			restore()
+			return nil, errno
+		}
+
+		prev[tid] = p
+	}
+
+	return restore, nil
+}
+
+func threads() ([]int, error) {
+	entries, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return nil, err
+	}
+
+	tids := make([]int, 0, len(entries))
+	for _, e := range entries {
+		if tid, err := strconv.Atoi(e.Name()); err == nil {
+			tids = append(tids, tid)
+		}
+	}
+
+	return tids, nil
+}
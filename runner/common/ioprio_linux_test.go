@@ -0,0 +1,33 @@
+package common
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestLowerIOPriority(t *testing.T) {
+	get := func() uintptr {
+		p, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_GET, ioprioWhoProcess, uintptr(syscall.Getpid()), 0)
+		if errno != 0 {
+			t.Skipf("ioprio_get: %v", errno)
+		}
+		return p
+	}
+
+	prev := get()
+
+	restore, err := LowerIOPriority()
+	if err != nil {
+		t.Skipf("unable to lower io priority: %v", err)
+	}
+
+	if p := get(); p != ioprioLow {
+		t.Errorf("expected priority %#x, got %#x", ioprioLow, p)
+	}
+
+	restore()
+
+	if p := get(); p != prev {
+		t.Errorf("expected priority to be restored to %#x, got %#x", prev, p)
+	}
+}
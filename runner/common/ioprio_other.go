@@ -0,0 +1,10 @@
+//go:build !linux
+
+package common
+
+import "errors"
+
+// LowerIOPriority is only supported on Linux.
+func LowerIOPriority() (func(), error) {
+	return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,77 @@
+package common
+
+import (
+	"math"
+	"slices"
+)
+
+// LogProb returns the natural log probability of token under logits and
+// whether it is the most likely token.
+func LogProb(logits []float32, token int32) (float64, bool) {
+	if int(token) >= len(logits) || token < 0 {
+		return math.Inf(-1), false
+	}
+
+	maxLogit, logSum := logSumExp(logits)
+	return float64(logits[token]-maxLogit) - logSum, logits[token] == maxLogit
+}
+
+// TokenLogProb is a token with its natural log probability.
+type TokenLogProb struct {
+	Token   int32
+	LogProb float64
+}
+
+// TopLogProbs returns the k most likely tokens under logits with their
+// natural log probabilities, most likely first.
+func TopLogProbs(logits []float32, k int) []TokenLogProb {
+	k = min(k, len(logits))
+	if k <= 0 {
+		return nil
+	}
+
+	// keep the k largest logits seen so far in descending order
+	top := make([]int32, 0, k)
+	for id, l := range logits {
+		if len(top) == k && l <= logits[top[k-1]] {
+			continue
+		}
+
+		i, _ := slices.BinarySearchFunc(top, l, func(id int32, l float32) int {
+			if logits[id] >= l {
+				return -1
+			}
+			return 1
+		})
+
+		if len(top) == k {
+			top = top[:k-1]
+		}
+		top = slices.Insert(top, i, int32(id))
+	}
+
+	maxLogit, logSum := logSumExp(logits)
+
+	tokens := make([]TokenLogProb, len(top))
+	for i, id := range top {
+		tokens[i] = TokenLogProb{Token: id, LogProb: float64(logits[id]-maxLogit) - logSum}
+	}
+
+	return tokens
+}
+
+// logSumExp returns the largest of logits and the log of the sum of their
+// exponents, offset by it for numerical stability.
+func logSumExp(logits []float32) (float32, float64) {
+	maxLogit := float32(math.Inf(-1))
+	for _, l := range logits {
+		maxLogit = max(maxLogit, l)
+	}
+
+	var sum float64
+	for _, l := range logits {
+		sum += math.Exp(float64(l - maxLogit))
+	}
+
+	return maxLogit, math.Log(sum)
+}
@@ -0,0 +1,66 @@
+package common
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLogProb(t *testing.T) {
+	tests := []struct {
+		name   string
+		logits []float32
+		token  int32
+		expect float64
+		greedy bool
+	}{
+		{"uniform", []float32{1, 1, 1, 1}, 2, math.Log(0.25), true},
+		{"likely", []float32{0, float32(math.Log(3))}, 1, math.Log(0.75), true},
+		{"unlikely", []float32{0, float32(math.Log(3))}, 0, math.Log(0.25), false},
+		{"large logits", []float32{1000, 1000}, 0, math.Log(0.5), true},
+		{"out of range", []float32{1, 1}, 2, math.Inf(-1), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, greedy := LogProb(tt.logits, tt.token)
+			if math.IsInf(tt.expect, -1) && !math.IsInf(got, -1) || !math.IsInf(tt.expect, -1) && math.Abs(got-tt.expect) > 1e-6 {
+				t.Errorf("expected %v, got %v", tt.expect, got)
+			}
+
+			if greedy != tt.greedy {
+				t.Errorf("expected greedy %v, got %v", tt.greedy, greedy)
+			}
+		})
+	}
+}
+
+func TestTopLogProbs(t *testing.T) {
+	logits := []float32{0, float32(math.Log(4)), float32(math.Log(2)), float32(math.Log(2)), float32(math.Log(8))}
+
+	tests := []struct {
+		name   string
+		k      int
+		expect []TokenLogProb
+	}{
+		{"none", 0, nil},
+		{"one", 1, []TokenLogProb{{4, math.Log(8.0 / 17)}}},
+		{"ties keep order", 4, []TokenLogProb{{4, math.Log(8.0 / 17)}, {1, math.Log(4.0 / 17)}, {2, math.Log(2.0 / 17)}, {3, math.Log(2.0 / 17)}}},
+		{"more than vocabulary", 10, []TokenLogProb{{4, math.Log(8.0 / 17)}, {1, math.Log(4.0 / 17)}, {2, math.Log(2.0 / 17)}, {3, math.Log(2.0 / 17)}, {0, math.Log(1.0 / 17)}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := TopLogProbs(logits, tt.k)
+			if len(got) != len(tt.expect) {
+				t.Fatalf("expected %v, got %v", tt.expect, got)
+			}
+
+			for i := range got {
+				if got[i].Token != tt.expect[i].Token || math.Abs(got[i].LogProb-tt.expect[i].LogProb) > 1e-6 {
+					t.Errorf("expected %v, got %v", tt.expect, got)
+					break
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,14 @@
+package common
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Prefetch asks the kernel to read length bytes of f at offset into the page
+// cache in the background, so that a memory mapping of them doesn't wait for
+// the disk when they are used.
+func Prefetch(f *os.File, offset, length int64) error {
+	return unix.Fadvise(int(f.Fd()), offset, length, unix.FADV_WILLNEED)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package common
+
+import (
+	"errors"
+	"os"
+)
+
+// Prefetch is only supported on Linux.
+func Prefetch(f *os.File, offset, length int64) error {
+	return errors.ErrUnsupported
+}
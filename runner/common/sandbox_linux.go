@@ -0,0 +1,137 @@
+package common
+
+import (
+	"errors"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// offsets of the fields of struct seccomp_data
+const (
+	seccompNr   = 0
+	seccompArch = 4
+	seccompArg0 = 16
+)
+
+// x32SyscallBit marks system calls of the x32 ABI, which share the
+// architecture of x86-64 but not its system call numbers
+const x32SyscallBit = 0x40000000
+
+const seccompDeny = unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)
+
+// deniedSyscalls are system calls a runner never makes that would let
+// compromised code escape the process or attack the rest of the system.
+// io_uring is denied because it can create sockets without socket(2).
+var deniedSyscalls = []uint32{
+	unix.SYS_PTRACE,
+	unix.SYS_PROCESS_VM_READV,
+	unix.SYS_PROCESS_VM_WRITEV,
+	unix.SYS_MOUNT,
+	unix.SYS_UMOUNT2,
+	unix.SYS_PIVOT_ROOT,
+	unix.SYS_UNSHARE,
+	unix.SYS_SETNS,
+	unix.SYS_KEXEC_LOAD,
+	unix.SYS_KEXEC_FILE_LOAD,
+	unix.SYS_INIT_MODULE,
+	unix.SYS_FINIT_MODULE,
+	unix.SYS_DELETE_MODULE,
+	unix.SYS_BPF,
+	unix.SYS_PERF_EVENT_OPEN,
+	unix.SYS_USERFAULTFD,
+	unix.SYS_KEYCTL,
+	unix.SYS_ADD_KEY,
+	unix.SYS_REQUEST_KEY,
+	unix.SYS_IO_URING_SETUP,
+}
+
+// deniedFamilies are the address families of sockets a runner may not
+// create. Sockets that exist already, such as the listener the server
+// connects to, keep working.
+var deniedFamilies = []uint32{
+	unix.AF_INET,
+	unix.AF_INET6,
+	unix.AF_PACKET,
+}
+
+// Sandbox restricts the process, including threads it starts later, with a
+// seccomp filter so that it can neither open network connections nor use
+// system calls that reach outside of the process. It can't be undone.
+func Sandbox() error {
+	var arch uint32
+	switch runtime.GOARCH {
+	case "amd64":
+		arch = unix.AUDIT_ARCH_X86_64
+	case "arm64":
+		arch = unix.AUDIT_ARCH_AARCH64
+	default:
+		return errors.ErrUnsupported
+	}
+
+	filter := seccompFilter(arch)
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+
+	// no_new_privs is set for the calling thread only, the filter then
+	// applies it to every thread it synchronizes
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return err
+	}
+
+	if _, _, errno := unix.Syscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER, unix.SECCOMP_FILTER_FLAG_TSYNC, uintptr(unsafe.Pointer(&prog))); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// seccompFilter returns a BPF program that denies deniedSyscalls, sockets of
+// deniedFamilies and every system call of architectures other than arch.
+func seccompFilter(arch uint32) []unix.SockFilter {
+	var filter []unix.SockFilter
+	var deny []int
+	add := func(code uint16, k uint32) int {
+		filter = append(filter, unix.SockFilter{Code: code, K: k})
+		return len(filter) - 1
+	}
+
+	// jumpDeny adds a comparison that jumps to the end of the program,
+	// where the system call is denied, if it is true
+	jumpDeny := func(code uint16, k uint32) {
+		deny = append(deny, add(unix.BPF_JMP|code|unix.BPF_K, k))
+	}
+
+	add(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, seccompArch)
+	i := add(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, arch)
+	filter[i].Jt = 1
+	add(unix.BPF_RET|unix.BPF_K, seccompDeny)
+
+	add(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, seccompNr)
+	if arch == unix.AUDIT_ARCH_X86_64 {
+		jumpDeny(unix.BPF_JGE, x32SyscallBit)
+	}
+
+	for _, nr := range deniedSyscalls {
+		jumpDeny(unix.BPF_JEQ, nr)
+	}
+
+	socket := add(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, unix.SYS_SOCKET)
+	add(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, seccompArg0)
+	for _, family := range deniedFamilies {
+		jumpDeny(unix.BPF_JEQ, family)
+	}
+
+	allow := add(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_ALLOW)
+	end := add(unix.BPF_RET|unix.BPF_K, seccompDeny)
+
+	filter[socket].Jf = uint8(allow - socket - 1)
+	for _, i := range deny {
+		filter[i].Jt = uint8(end - i - 1)
+	}
+
+	return filter
+}
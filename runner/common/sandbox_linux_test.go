@@ -0,0 +1,71 @@
+package common
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// TestSandbox runs itself in a subprocess, since a sandbox can't be removed
+// from the process that installs it.
+func TestSandbox(t *testing.T) {
+	if os.Getenv("OLLAMA_TEST_SANDBOX") == "" {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestSandbox$", "-test.v")
+		cmd.Env = append(os.Environ(), "OLLAMA_TEST_SANDBOX=1")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("%v\n%s", err, out)
+		}
+		t.Logf("%s", out)
+		return
+	}
+
+	// connections to a listener created before are accepted
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := Sandbox(); err != nil {
+		t.Skipf("unable to sandbox: %v", err)
+	}
+
+	s, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("expected accept to succeed, got %v", err)
+	}
+	s.Close()
+
+	for _, network := range []string{"tcp4", "tcp6"} {
+		if _, err := net.Listen(network, ""); !errors.Is(err, syscall.EPERM) {
+			t.Errorf("%s: expected %v, got %v", network, syscall.EPERM, err)
+		}
+	}
+
+	if _, err := net.ListenPacket("udp", ""); !errors.Is(err, syscall.EPERM) {
+		t.Errorf("udp: expected %v, got %v", syscall.EPERM, err)
+	}
+
+	if _, err := net.Dial("tcp", ln.Addr().String()); !errors.Is(err, syscall.EPERM) {
+		t.Errorf("expected %v, got %v", syscall.EPERM, err)
+	}
+
+	if _, err := net.Listen("unix", filepath.Join(t.TempDir(), "sock")); err != nil {
+		t.Errorf("expected unix sockets to be allowed, got %v", err)
+	}
+
+	if err := syscall.Unshare(syscall.CLONE_NEWNS); !errors.Is(err, syscall.EPERM) {
+		t.Errorf("expected %v, got %v", syscall.EPERM, err)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package common
+
+import "errors"
+
+// Sandbox is only supported on Linux. On other platforms the server starts
+// runners with reduced privileges instead.
+func Sandbox() error {
+	return errors.ErrUnsupported
+}
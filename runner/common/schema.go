@@ -0,0 +1,715 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// Schema is a JSON schema compiled to constrain output as it is generated
+// with a SchemaDecoder. It supports the keywords that describe the structure
+// of values: type, properties, required, additionalProperties, items,
+// minItems, maxItems, enum and const. Schemas with other keywords, such as
+// patterns, formats and references, return an error from CompileSchema.
+type Schema struct {
+	// types are the types of values that match, or 0 for any type
+	types uint8
+
+	properties []string
+	children   []*Schema
+	required   uint64
+
+	// additional is the schema of properties that aren't in properties, or
+	// nil if the object can't have them
+	additional *Schema
+
+	items    *Schema
+	minItems int
+	maxItems int
+
+	// enum are the values that match in compact JSON, if they are limited
+	enum []string
+}
+
+const (
+	typeNull uint8 = 1 << iota
+	typeBoolean
+	typeInteger
+	typeNumber
+	typeString
+	typeArray
+	typeObject
+)
+
+var schemaTypes = map[string]uint8{
+	"null":    typeNull,
+	"boolean": typeBoolean,
+	"integer": typeInteger,
+	"number":  typeNumber | typeInteger,
+	"string":  typeString,
+	"array":   typeArray,
+	"object":  typeObject,
+}
+
+// anySchema matches any JSON value
+var anySchema = &Schema{maxItems: -1}
+
+func init() {
+	anySchema.additional = anySchema
+}
+
+// limits of a schema and the output it matches, which keep the state of a
+// decoder small and stop models from generating unbounded whitespace,
+// nesting, keys or numbers
+const (
+	maxSchemaValues = 64
+	maxDepth        = 64
+	maxSpace        = 32
+	maxKeyLength    = 256
+	maxNumberLength = 32
+)
+
+// CompileSchema compiles a JSON schema for a SchemaDecoder
+func CompileSchema(data []byte) (*Schema, error) {
+	return compileSchema(data)
+}
+
+func compileSchema(data json.RawMessage) (*Schema, error) {
+	var b bool
+	if err := json.Unmarshal(data, &b); err == nil {
+		if !b {
+			return nil, errors.New("schema false matches no values")
+		}
+
+		return anySchema, nil
+	}
+
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+
+	s := Schema{maxItems: -1}
+	var required []string
+	var additional bool
+	for _, k := range slices.Sorted(maps.Keys(m)) {
+		v := m[k]
+		switch k {
+		case "type":
+			var types []string
+			var t string
+			if err := json.Unmarshal(v, &t); err == nil {
+				types = []string{t}
+			} else if err := json.Unmarshal(v, &types); err != nil {
+				return nil, fmt.Errorf("invalid type: %w", err)
+			}
+
+			for _, t := range types {
+				bits, ok := schemaTypes[t]
+				if !ok {
+					return nil, fmt.Errorf("unsupported type %q", t)
+				}
+				s.types |= bits
+			}
+		case "properties":
+			var properties map[string]json.RawMessage
+			if err := json.Unmarshal(v, &properties); err != nil {
+				return nil, fmt.Errorf("invalid properties: %w", err)
+			}
+
+			if len(properties) > maxSchemaValues {
+				return nil, fmt.Errorf("more than %d properties", maxSchemaValues)
+			}
+
+			// properties are indexed in a consistent order since objects
+			// can list them in any order anyway
+			s.properties = slices.Sorted(maps.Keys(properties))
+
+			for _, name := range s.properties {
+				child, err := compileSchema(properties[name])
+				if err != nil {
+					return nil, fmt.Errorf("property %q: %w", name, err)
+				}
+				s.children = append(s.children, child)
+			}
+		case "required":
+			if err := json.Unmarshal(v, &required); err != nil {
+				return nil, fmt.Errorf("invalid required: %w", err)
+			}
+		case "additionalProperties":
+			additional = true
+			child, err := compileSchema(v)
+			if err != nil && !bytes.Equal(bytes.TrimSpace(v), []byte("false")) {
+				return nil, fmt.Errorf("additionalProperties: %w", err)
+			}
+			s.additional = child
+		case "items":
+			child, err := compileSchema(v)
+			if err != nil {
+				return nil, fmt.Errorf("items: %w", err)
+			}
+			s.items = child
+		case "minItems":
+			if err := json.Unmarshal(v, &s.minItems); err != nil {
+				return nil, fmt.Errorf("invalid minItems: %w", err)
+			}
+		case "maxItems":
+			if err := json.Unmarshal(v, &s.maxItems); err != nil || s.maxItems < 0 {
+				return nil, fmt.Errorf("invalid maxItems: %s", v)
+			}
+		case "enum", "const":
+			values := []json.RawMessage{v}
+			if k == "enum" {
+				if err := json.Unmarshal(v, &values); err != nil {
+					return nil, fmt.Errorf("invalid enum: %w", err)
+				}
+			}
+
+			if len(values) == 0 || len(values) > maxSchemaValues {
+				return nil, fmt.Errorf("%s must have 1 to %d values", k, maxSchemaValues)
+			}
+
+			for _, value := range values {
+				var b bytes.Buffer
+				if err := json.Compact(&b, value); err != nil {
+					return nil, fmt.Errorf("invalid %s: %w", k, err)
+				}
+				s.enum = append(s.enum, b.String())
+			}
+		case "$schema", "$id", "$comment", "title", "description", "default", "examples":
+		default:
+			return nil, fmt.Errorf("unsupported keyword %q", k)
+		}
+	}
+
+	// like llama.cpp's grammars, objects with listed properties can only
+	// have other properties if additionalProperties allows them
+	if s.properties == nil && !additional {
+		s.additional = anySchema
+	}
+
+	for _, name := range required {
+		i := slices.Index(s.properties, name)
+		if i < 0 {
+			return nil, fmt.Errorf("required property %q isn't in properties", name)
+		}
+		s.required |= 1 << i
+	}
+
+	if s.maxItems >= 0 && s.minItems > s.maxItems {
+		return nil, errors.New("minItems is greater than maxItems")
+	}
+
+	return &s, nil
+}
+
+func (s *Schema) allows(types uint8) bool {
+	return s.types == 0 || s.types&types != 0
+}
+
+func orAny(s *Schema) *Schema {
+	if s == nil {
+		return anySchema
+	}
+
+	return s
+}
+
+// kinds of values that a frame decodes
+const (
+	kindValue uint8 = iota
+	kindLiteral
+	kindString
+	kindNumber
+	kindObject
+	kindArray
+)
+
+// states of a frame, which are the bytes or values that it expects next
+const (
+	stateOpen uint8 = iota
+
+	stateString
+	stateEscape
+	stateUnicode
+
+	stateMinus
+	stateZero
+	stateInt
+	stateDot
+	stateFrac
+	stateExp
+	stateExpSign
+	stateExpDigits
+
+	stateFirst
+	stateKey
+	stateColon
+	stateNext
+	stateComma
+)
+
+var (
+	booleanLiterals = []string{"true", "false"}
+	nullLiterals    = []string{"null"}
+)
+
+// frame is the state of decoding a value, which is on the stack of a
+// SchemaDecoder along with the values that contain it
+type frame struct {
+	schema      *Schema
+	kind, state uint8
+
+	// n is the number of bytes of a literal, key or number, or of items of
+	// an array, that have been decoded
+	n int
+
+	// lits are the values a literal can be and cands are the literals, or
+	// properties of an object for a key, that match the bytes so far
+	lits  []string
+	cands uint64
+
+	// seen are the properties that an object has, key is the index of the
+	// property whose value is next, or -1 for an additional property, and
+	// additional is whether the key being decoded can be one
+	seen       uint64
+	key        int
+	additional bool
+
+	// space is the number of whitespace bytes in a row
+	space int
+}
+
+// SchemaDecoder checks that output is JSON that matches a Schema as it is
+// generated, so that samplers can mask tokens that don't continue it. Output
+// is a single JSON value with optional whitespace before it.
+type SchemaDecoder struct {
+	stack   []frame
+	scratch []frame
+}
+
+func NewSchemaDecoder(s *Schema) *SchemaDecoder {
+	return &SchemaDecoder{stack: []frame{{schema: orAny(s), kind: kindValue}}}
+}
+
+// Feed advances the decoder past the bytes of piece. It returns the number
+// of bytes that are part of the JSON value, which is fewer than len(piece)
+// when the value ends partway through, such as when a model adds text after
+// it. If piece doesn't continue a value that matches the schema, Feed
+// returns false and the decoder is unchanged.
+func (d *SchemaDecoder) Feed(piece string) (int, bool) {
+	d.scratch = append(d.scratch[:0], d.stack...)
+	n, ok := feed(&d.scratch, piece)
+	if ok {
+		d.stack, d.scratch = d.scratch, d.stack
+	}
+
+	return n, ok
+}
+
+// Accepts reports whether Feed would accept piece, without advancing
+func (d *SchemaDecoder) Accepts(piece string) bool {
+	d.scratch = append(d.scratch[:0], d.stack...)
+	_, ok := feed(&d.scratch, piece)
+	return ok
+}
+
+// Done reports whether the JSON value has ended
+func (d *SchemaDecoder) Done() bool {
+	return len(d.stack) == 0
+}
+
+// Complete reports whether the output can end here, which is when the value
+// has ended or is a number or literal that is complete but could continue
+func (d *SchemaDecoder) Complete() bool {
+	switch len(d.stack) {
+	case 0:
+		return true
+	case 1:
+		f := d.stack[0]
+		switch f.kind {
+		case kindNumber:
+			return f.state == stateZero || f.state == stateInt || f.state == stateFrac || f.state == stateExpDigits
+		case kindLiteral:
+			return f.exact()
+		}
+	}
+
+	return false
+}
+
+type result uint8
+
+const (
+	consumed result = iota
+	invalid
+	trailing
+)
+
+func feed(stack *[]frame, piece string) (int, bool) {
+	for i := range len(piece) {
+		switch step(stack, piece[i]) {
+		case invalid:
+			return i, false
+		case trailing:
+			return i, true
+		}
+	}
+
+	return len(piece), true
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isHex(c byte) bool {
+	return isDigit(c) || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// step advances the top of stack past c. When a value ends before c, such
+// as a number, c continues the value that contains it.
+func step(stack *[]frame, c byte) result {
+	for {
+		if len(*stack) == 0 {
+			return trailing
+		}
+
+		f := &(*stack)[len(*stack)-1]
+		pop := func() {
+			*stack = (*stack)[:len(*stack)-1]
+		}
+		push := func(s *Schema) bool {
+			if len(*stack) >= maxDepth {
+				return false
+			}
+
+			*stack = append(*stack, frame{schema: orAny(s), kind: kindValue})
+			return true
+		}
+
+		if isSpace(c) && f.spaceAllowed() {
+			if f.space++; f.space > maxSpace {
+				return invalid
+			}
+			return consumed
+		}
+		f.space = 0
+
+		switch f.kind {
+		case kindValue:
+			if !f.begin(c) {
+				return invalid
+			}
+			continue
+		case kindLiteral:
+			var cands uint64
+			for i, lit := range f.lits {
+				if f.cands&(1<<i) != 0 && len(lit) > f.n && lit[f.n] == c {
+					cands |= 1 << i
+				}
+			}
+
+			if cands == 0 {
+				if f.exact() {
+					pop()
+					continue
+				}
+				return invalid
+			}
+
+			f.n++
+			f.cands = cands
+			if !f.extends() {
+				pop()
+			}
+			return consumed
+		case kindString:
+			switch f.state {
+			case stateOpen:
+				if c != '"' {
+					return invalid
+				}
+				f.state = stateString
+			case stateString:
+				switch {
+				case c == '"':
+					pop()
+				case c == '\\':
+					f.state = stateEscape
+				case c < 0x20:
+					return invalid
+				}
+			case stateEscape:
+				switch c {
+				case '"', '\\', '/', 'b', 'f', 'n', 'r', 't':
+					f.state = stateString
+				case 'u':
+					f.state = stateUnicode
+					f.n = 0
+				default:
+					return invalid
+				}
+			case stateUnicode:
+				if !isHex(c) {
+					return invalid
+				}
+
+				if f.n++; f.n == 4 {
+					f.state = stateString
+				}
+			}
+			return consumed
+		case kindNumber:
+			if f.n++; f.n > maxNumberLength {
+				return invalid
+			}
+
+			fraction := f.schema.allows(typeNumber)
+			switch f.state {
+			case stateOpen, stateMinus:
+				switch {
+				case c == '-' && f.state == stateOpen:
+					f.state = stateMinus
+				case c == '0':
+					f.state = stateZero
+				case isDigit(c):
+					f.state = stateInt
+				default:
+					return invalid
+				}
+			case stateZero, stateInt, stateFrac, stateExpDigits:
+				switch {
+				case isDigit(c) && f.state != stateZero:
+				case (c == '.') && fraction && (f.state == stateZero || f.state == stateInt):
+					f.state = stateDot
+				case (c == 'e' || c == 'E') && fraction && f.state != stateExpDigits:
+					f.state = stateExp
+				case isDigit(c):
+					// numbers can't have leading zeros
+					return invalid
+				default:
+					pop()
+					continue
+				}
+			case stateDot:
+				if !isDigit(c) {
+					return invalid
+				}
+				f.state = stateFrac
+			case stateExp:
+				switch {
+				case c == '+' || c == '-':
+					f.state = stateExpSign
+				case isDigit(c):
+					f.state = stateExpDigits
+				default:
+					return invalid
+				}
+			case stateExpSign:
+				if !isDigit(c) {
+					return invalid
+				}
+				f.state = stateExpDigits
+			}
+			return consumed
+		case kindObject:
+			switch f.state {
+			case stateOpen:
+				if c != '{' {
+					return invalid
+				}
+				f.state = stateFirst
+			case stateFirst, stateNext:
+				switch {
+				case c == '}':
+					if f.seen&f.schema.required != f.schema.required {
+						return invalid
+					}
+					pop()
+				case c == '"' && f.state == stateFirst && f.canAdd():
+					f.startKey()
+				case c == ',' && f.state == stateNext && f.canAdd():
+					f.state = stateComma
+				default:
+					return invalid
+				}
+			case stateComma:
+				if c != '"' {
+					return invalid
+				}
+				f.startKey()
+			case stateKey:
+				if c == '"' {
+					return f.endKey()
+				}
+
+				// keys can't have escapes, which keeps matching them to
+				// properties simple
+				if c == '\\' || c < 0x20 || f.n >= maxKeyLength {
+					return invalid
+				}
+
+				var cands uint64
+				for i, name := range f.schema.properties {
+					if f.cands&(1<<i) != 0 && len(name) > f.n && name[f.n] == c {
+						cands |= 1 << i
+					}
+				}
+
+				if cands == 0 && !f.additional {
+					return invalid
+				}
+
+				f.n++
+				f.cands = cands
+			case stateColon:
+				if c != ':' {
+					return invalid
+				}
+
+				f.state = stateNext
+				child := f.schema.additional
+				if f.key >= 0 {
+					child = f.schema.children[f.key]
+				}
+
+				if !push(child) {
+					return invalid
+				}
+			}
+			return consumed
+		case kindArray:
+			switch f.state {
+			case stateOpen:
+				if c != '[' {
+					return invalid
+				}
+				f.state = stateFirst
+				return consumed
+			case stateFirst, stateNext:
+				switch {
+				case c == ']' && f.n >= f.schema.minItems:
+					pop()
+					return consumed
+				case c == ',' && f.state == stateNext && (f.schema.maxItems < 0 || f.n < f.schema.maxItems):
+					f.state = stateComma
+					return consumed
+				case f.state == stateFirst && f.schema.maxItems != 0:
+				default:
+					return invalid
+				}
+			}
+
+			// c starts an item
+			f.n++
+			f.state = stateNext
+			if !push(f.schema.items) {
+				return invalid
+			}
+		}
+	}
+}
+
+// begin starts decoding a value that begins with c
+func (f *frame) begin(c byte) bool {
+	if len(f.schema.enum) > 0 {
+		f.kind, f.lits = kindLiteral, f.schema.enum
+	} else {
+		switch {
+		case c == '{' && f.schema.allows(typeObject):
+			f.kind = kindObject
+		case c == '[' && f.schema.allows(typeArray):
+			f.kind = kindArray
+		case c == '"' && f.schema.allows(typeString):
+			f.kind = kindString
+		case (c == '-' || isDigit(c)) && f.schema.allows(typeInteger):
+			f.kind = kindNumber
+		case (c == 't' || c == 'f') && f.schema.allows(typeBoolean):
+			f.kind, f.lits = kindLiteral, booleanLiterals
+		case c == 'n' && f.schema.allows(typeNull):
+			f.kind, f.lits = kindLiteral, nullLiterals
+		default:
+			return false
+		}
+	}
+
+	f.state = stateOpen
+	f.cands = 1<<len(f.lits) - 1
+	return true
+}
+
+// spaceAllowed reports whether whitespace can come next
+func (f *frame) spaceAllowed() bool {
+	switch f.kind {
+	case kindValue:
+		return true
+	case kindObject:
+		return f.state == stateFirst || f.state == stateColon || f.state == stateNext || f.state == stateComma
+	case kindArray:
+		return f.state == stateFirst || f.state == stateNext || f.state == stateComma
+	}
+
+	return false
+}
+
+// exact reports whether a literal matches one of its values exactly
+func (f *frame) exact() bool {
+	for i, lit := range f.lits {
+		if f.cands&(1<<i) != 0 && len(lit) == f.n {
+			return true
+		}
+	}
+
+	return false
+}
+
+// extends reports whether a literal can continue
+func (f *frame) extends() bool {
+	for i, lit := range f.lits {
+		if f.cands&(1<<i) != 0 && len(lit) > f.n {
+			return true
+		}
+	}
+
+	return false
+}
+
+// canAdd reports whether an object can have another property
+func (f *frame) canAdd() bool {
+	all := uint64(1)<<len(f.schema.properties) - 1
+	return f.seen != all || f.schema.additional != nil
+}
+
+func (f *frame) startKey() {
+	all := uint64(1)<<len(f.schema.properties) - 1
+	f.state = stateKey
+	f.n = 0
+	f.cands = all &^ f.seen
+	f.additional = f.schema.additional != nil
+}
+
+func (f *frame) endKey() result {
+	f.state = stateColon
+	for i, name := range f.schema.properties {
+		if f.cands&(1<<i) != 0 && len(name) == f.n {
+			f.key = i
+			f.seen |= 1 << i
+			return consumed
+		}
+	}
+
+	if !f.additional {
+		return invalid
+	}
+
+	f.key = -1
+	return consumed
+}
@@ -0,0 +1,140 @@
+package common
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompileSchema(t *testing.T) {
+	cases := []struct {
+		name   string
+		schema string
+		err    bool
+	}{
+		{"object", `{"type": "object", "properties": {"name": {"type": "string"}}, "required": ["name"]}`, false},
+		{"true", `true`, false},
+		{"false", `false`, true},
+		{"unsupported keyword", `{"type": "string", "pattern": "^a+$"}`, true},
+		{"unsupported type", `{"type": "date"}`, true},
+		{"missing required", `{"type": "object", "properties": {"a": {}}, "required": ["b"]}`, true},
+		{"items", `{"type": "array", "items": {"enum": ["a", "b"]}, "minItems": 1, "maxItems": 2}`, false},
+		{"bad items", `{"type": "array", "items": {"$ref": "#/defs/item"}}`, true},
+		{"min over max", `{"type": "array", "minItems": 3, "maxItems": 2}`, true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := CompileSchema([]byte(tt.schema))
+			if tt.err != (err != nil) {
+				t.Errorf("expected error %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestSchemaDecoder(t *testing.T) {
+	person := `{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string"},
+			"age": {"type": "integer"},
+			"nickname": {"type": "string"},
+			"tags": {"type": "array", "items": {"type": "string"}, "maxItems": 2},
+			"role": {"enum": ["admin", "user"]}
+		},
+		"required": ["name", "age"]
+	}`
+
+	cases := []struct {
+		name     string
+		schema   string
+		pieces   []string
+		valid    bool
+		complete bool
+		trailing int
+	}{
+		{"object", person, []string{`{"name": "Ada", `, `"age": 36}`}, true, true, 0},
+		{"any order", person, []string{`{"age": 36, "name": "Ada", "role": "user"}`}, true, true, 0},
+		{"prefix", person, []string{`{"na`}, true, false, 0},
+		{"missing required", person, []string{`{"name": "Ada"}`}, false, false, 0},
+		{"unknown property", person, []string{`{"email"`}, false, false, 0},
+		{"duplicate property", person, []string{`{"age": 1, "age"`}, false, false, 0},
+		{"wrong type", person, []string{`{"age": "36"`}, false, false, 0},
+		{"fraction in integer", person, []string{`{"age": 3.5`}, false, false, 0},
+		{"enum", person, []string{`{"role": "ad`, `min"`}, true, false, 0},
+		{"not in enum", person, []string{`{"role": "guest"`}, false, false, 0},
+		{"too many items", person, []string{`{"tags": ["a", "b", `}, false, false, 0},
+		{"escapes", person, []string{`{"name": "A\"dé\n"`}, true, false, 0},
+		{"control character", person, []string{"{\"name\": \"A\tda\""}, false, false, 0},
+		{"trailing text", person, []string{`{"name": "Ada", "age": 36}`, "\n\nHope this helps!"}, true, true, 18},
+		{"trailing in piece", person, []string{`{"name": "Ada", "age": 36`, `} Done`}, true, true, 5},
+		{"leading space", `{"type": "string"}`, []string{"\n ", `"ok"`}, true, true, 0},
+		{"number", `{"type": "number"}`, []string{`-1.5e3`}, true, true, 0},
+		{"number ends", `{"type": "number"}`, []string{`12`, ` `}, true, true, 1},
+		{"leading zero", `{"type": "number"}`, []string{`01`}, false, false, 0},
+		{"any", `{}`, []string{`[1, {"a": null, "b": [true]}, "c"]`}, true, true, 0},
+		{"no additional", `{"type": "object", "properties": {"a": {}}}`, []string{`{"b"`}, false, false, 0},
+		{"additional", `{"type": "object", "properties": {"a": {}}, "additionalProperties": {"type": "integer"}}`, []string{`{"b": 1, "a": "x"}`}, true, true, 0},
+		{"min items", `{"type": "array", "minItems": 1}`, []string{`[]`}, false, false, 0},
+		{"const", `{"const": {"a": [1, 2]}}`, []string{`{"a":[1,2]}`}, true, true, 0},
+		{"too much space", `{"type": "object"}`, []string{"{" + strings.Repeat(" ", 40) + "}"}, false, false, 0},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := CompileSchema([]byte(tt.schema))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			d := NewSchemaDecoder(s)
+			valid := true
+			var trailing int
+			for _, piece := range tt.pieces {
+				accepts := d.Accepts(piece)
+				n, ok := d.Feed(piece)
+				if accepts != ok {
+					t.Fatalf("Accepts(%q) = %v, but Feed = %v", piece, accepts, ok)
+				}
+
+				if !ok {
+					valid = false
+					break
+				}
+				trailing = len(piece) - n
+			}
+
+			if valid != tt.valid {
+				t.Fatalf("expected valid %v, got %v", tt.valid, valid)
+			}
+
+			if d.Complete() != tt.complete {
+				t.Errorf("expected complete %v, got %v", tt.complete, d.Complete())
+			}
+
+			if trailing != tt.trailing {
+				t.Errorf("expected %d trailing bytes, got %d", tt.trailing, trailing)
+			}
+		})
+	}
+}
+
+func TestSchemaDecoderUnchanged(t *testing.T) {
+	s, err := CompileSchema([]byte(`{"type": "object", "properties": {"a": {"type": "boolean"}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewSchemaDecoder(s)
+	if _, ok := d.Feed(`{"a": tr`); !ok {
+		t.Fatal("expected a valid prefix")
+	}
+
+	if _, ok := d.Feed(`ux`); ok {
+		t.Fatal("expected an invalid piece")
+	}
+
+	if _, ok := d.Feed(`ue}`); !ok || !d.Done() {
+		t.Errorf("expected the decoder to be unchanged by an invalid piece")
+	}
+}
@@ -2,28 +2,147 @@ package common
 
 import (
 	"strings"
+	"unicode/utf8"
 )
 
-func FindStop(sequence string, stops []string) (bool, string) {
+// StopMatcher finds stop sequences in output that is generated a piece at
+// a time. It matches all of the stop sequences at once with an Aho-Corasick
+// automaton, so the time to check a piece doesn't grow with their number.
+// Stop patterns, which are regular expressions, are matched alongside them.
+type StopMatcher struct {
+	stops []string
+	nodes []stopNode
+
+	// state is the node for the longest suffix of the output so far that
+	// is a prefix of a stop sequence
+	state int32
+
+	patterns *stopRegexp
+}
+
+type stopNode struct {
+	next  map[byte]int32
+	fail  int32
+	depth int
+
+	// stop is the index of the longest stop sequence that ends at the node,
+	// including through its fail links, or -1
+	stop int
+}
+
+// NewStopMatcher returns a StopMatcher for stops and patterns, or nil if
+// there are none. The methods of a nil StopMatcher never find a stop.
+func NewStopMatcher(stops, patterns []string) (*StopMatcher, error) {
+	prog, err := compileStopPatterns(patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	m := StopMatcher{nodes: []stopNode{{stop: -1}}}
+	if prog != nil {
+		m.patterns = newStopRegexp(prog)
+	}
+
 	for _, stop := range stops {
-		if strings.Contains(sequence, stop) {
-			return true, stop
+		if stop == "" {
+			continue
+		}
+
+		var n int32
+		for i := range len(stop) {
+			next, ok := m.nodes[n].next[stop[i]]
+			if !ok {
+				next = int32(len(m.nodes))
+				m.nodes = append(m.nodes, stopNode{depth: i + 1, stop: -1})
+				if m.nodes[n].next == nil {
+					m.nodes[n].next = make(map[byte]int32)
+				}
+				m.nodes[n].next[stop[i]] = next
+			}
+			n = next
+		}
+
+		if m.nodes[n].stop < 0 {
+			m.nodes[n].stop = len(m.stops)
+		}
+		m.stops = append(m.stops, stop)
+	}
+
+	if len(m.stops) == 0 && m.patterns == nil {
+		return nil, nil
+	}
+
+	// link each node to the node for its longest proper suffix, breadth
+	// first so that the links of shorter nodes are set first
+	queue := []int32{0}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		for c, child := range m.nodes[n].next {
+			queue = append(queue, child)
+			if n == 0 {
+				continue
+			}
+
+			fail := m.nodes[n].fail
+			for {
+				if next, ok := m.nodes[fail].next[c]; ok {
+					m.nodes[child].fail = next
+					break
+				} else if fail == 0 {
+					break
+				}
+				fail = m.nodes[fail].fail
+			}
+
+			if m.nodes[child].stop < 0 {
+				m.nodes[child].stop = m.nodes[m.nodes[child].fail].stop
+			}
 		}
 	}
 
-	return false, ""
+	return &m, nil
 }
 
-func ContainsStopSuffix(sequence string, stops []string) bool {
-	for _, stop := range stops {
-		for i := 1; i <= len(stop); i++ {
-			if strings.HasSuffix(sequence, stop[:i]) {
-				return true
+// Feed advances the matcher past piece. If a stop sequence or a match of a
+// stop pattern ends in piece, it returns the first one to end and true.
+func (m *StopMatcher) Feed(piece string) (string, bool) {
+	if m == nil {
+		return "", false
+	}
+
+	for i := range len(piece) {
+		n := m.state
+		for {
+			if next, ok := m.nodes[n].next[piece[i]]; ok {
+				n = next
+				break
+			} else if n == 0 {
+				break
+			}
+			n = m.nodes[n].fail
+		}
+
+		m.state = n
+		if stop := m.nodes[n].stop; stop >= 0 {
+			return m.stops[stop], true
+		}
+
+		if m.patterns != nil {
+			if match, ok := m.patterns.feed(piece[i]); ok {
+				return match, true
 			}
 		}
 	}
 
-	return false
+	return "", false
+}
+
+// Partial reports whether the output ends with the beginning of a stop
+// sequence or what may be part of a match of a stop pattern, in which case
+// it should be held back until the next pieces show whether the stop follows
+func (m *StopMatcher) Partial() bool {
+	return m != nil && (m.nodes[m.state].depth > 0 || m.patterns != nil && m.patterns.inProgress())
 }
 
 // truncateStop removes the provided stop string from pieces,
@@ -32,7 +151,7 @@ func ContainsStopSuffix(sequence string, stops []string) bool {
 func TruncateStop(pieces []string, stop string) ([]string, bool) {
 	joined := strings.Join(pieces, "")
 
-	index := strings.Index(joined, stop)
+	index := stopIndex(joined, stop)
 	if index == -1 {
 		return pieces, false
 	}
@@ -65,6 +184,25 @@ func TruncateStop(pieces []string, stop string) ([]string, bool) {
 	return result, tokenTruncated
 }
 
+// stopIndex returns the index of the first instance of stop in s that begins
+// at the start of a character, or -1. Pieces can split characters, so a
+// match that begins partway through one isn't the stop sequence.
+func stopIndex(s, stop string) int {
+	for i := 0; i <= len(s); {
+		j := strings.Index(s[i:], stop)
+		if j < 0 {
+			return -1
+		}
+
+		if i+j == len(s) || utf8.RuneStart(s[i+j]) {
+			return i + j
+		}
+		i += j + 1
+	}
+
+	return -1
+}
+
 func IncompleteUnicode(token string) bool {
 	incomplete := false
 
@@ -0,0 +1,177 @@
+package common
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"strings"
+	"unicode/utf8"
+)
+
+// stopRegexp matches regular expression stop patterns against output as it
+// is generated. The standard regexp package can only search complete text,
+// so the compiled program is run as a Pike VM that advances one character at
+// a time and keeps the threads of matches that are still in progress.
+//
+// ^ and $ match at the beginning and end of lines. Assertions that look at
+// the next character, such as $ and \b, are only decided when it arrives.
+type stopRegexp struct {
+	prog *syntax.Prog
+
+	// threads are the matches in progress after the last character, before
+	// following the empty-width instructions that depend on the next one.
+	// They are ordered by where the match begins.
+	threads []stopThread
+	seen    []bool
+
+	// text is the output from the start of the oldest thread, which begins
+	// at offset pos-len(text) in the output
+	text strings.Builder
+	pos  int
+	prev rune
+
+	// partial is the end of the output that doesn't yet make a full character
+	partial []byte
+}
+
+type stopThread struct {
+	pc    uint32
+	start int
+}
+
+// compileStopPatterns compiles patterns into a single program that matches
+// any of them, or returns nil if there are none.
+func compileStopPatterns(patterns []string) (*syntax.Prog, error) {
+	var subs []*syntax.Regexp
+	for _, pattern := range patterns {
+		re, err := syntax.Parse(pattern, syntax.Perl&^syntax.OneLine)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stop pattern %q: %w", pattern, err)
+		}
+
+		subs = append(subs, re.Simplify())
+	}
+
+	if len(subs) == 0 {
+		return nil, nil
+	}
+
+	re := subs[0]
+	if len(subs) > 1 {
+		re = &syntax.Regexp{Op: syntax.OpAlternate, Sub: subs}
+	}
+
+	return syntax.Compile(re)
+}
+
+func newStopRegexp(prog *syntax.Prog) *stopRegexp {
+	return &stopRegexp{prog: prog, seen: make([]bool, len(prog.Inst)), prev: -1}
+}
+
+// feed advances past b and returns the text of the first match to end, if any.
+func (r *stopRegexp) feed(b byte) (string, bool) {
+	r.partial = append(r.partial, b)
+	if !utf8.FullRune(r.partial) {
+		return "", false
+	}
+
+	c, _ := utf8.DecodeRune(r.partial)
+	text := string(r.partial)
+	r.partial = r.partial[:0]
+
+	// with the next character known, matches that waited on it can finish
+	// and the rest can move past it, along with a match beginning here
+	threads, start := r.follow(append(r.threads, stopThread{pc: uint32(r.prog.Start), start: r.pos}), syntax.EmptyOpContext(r.prev, c))
+	if start >= 0 {
+		return r.match(start), true
+	}
+
+	r.threads = r.threads[:0]
+	for _, t := range threads {
+		if inst := &r.prog.Inst[t.pc]; inst.MatchRune(c) {
+			r.threads = append(r.threads, stopThread{pc: inst.Out, start: t.start})
+		}
+	}
+
+	r.text.WriteString(text)
+	r.pos += len(text)
+	r.prev = c
+
+	// report matches that end here without waiting for the next character
+	// where possible
+	var flags syntax.EmptyOp
+	if c == '\n' {
+		flags = syntax.EmptyBeginLine
+	}
+
+	if _, start := r.follow(r.threads, flags); start >= 0 {
+		return r.match(start), true
+	}
+
+	r.trim()
+	return "", false
+}
+
+// follow returns the threads that wait on a character after following the
+// empty-width instructions of threads with flags, as well as the start of
+// the first match that ends here, or -1.
+func (r *stopRegexp) follow(threads []stopThread, flags syntax.EmptyOp) ([]stopThread, int) {
+	clear(r.seen)
+
+	var next []stopThread
+	var add func(uint32, int) bool
+	add = func(pc uint32, start int) bool {
+		if r.seen[pc] {
+			return false
+		}
+		r.seen[pc] = true
+
+		switch inst := &r.prog.Inst[pc]; inst.Op {
+		case syntax.InstAlt, syntax.InstAltMatch:
+			return add(inst.Out, start) || add(inst.Arg, start)
+		case syntax.InstEmptyWidth:
+			return syntax.EmptyOp(inst.Arg)&^flags == 0 && add(inst.Out, start)
+		case syntax.InstNop, syntax.InstCapture:
+			return add(inst.Out, start)
+		case syntax.InstMatch:
+			// empty matches would stop before generating anything
+			return start < r.pos
+		case syntax.InstFail:
+			return false
+		default:
+			next = append(next, stopThread{pc: pc, start: start})
+			return false
+		}
+	}
+
+	for _, t := range threads {
+		if add(t.pc, t.start) {
+			return nil, t.start
+		}
+	}
+
+	return next, -1
+}
+
+func (r *stopRegexp) match(start int) string {
+	s := r.text.String()
+	return s[len(s)-(r.pos-start):]
+}
+
+// trim drops text before the oldest thread.
+func (r *stopRegexp) trim() {
+	keep := 0
+	if len(r.threads) > 0 {
+		keep = r.pos - r.threads[0].start
+	}
+
+	if s := r.text.String(); keep < len(s) {
+		r.text.Reset()
+		r.text.WriteString(s[len(s)-keep:])
+	}
+}
+
+// inProgress reports whether the output ends with what may be the
+// beginning of a match.
+func (r *stopRegexp) inProgress() bool {
+	return len(r.threads) > 0 || len(r.partial) > 0
+}
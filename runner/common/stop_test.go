@@ -1,6 +1,7 @@
 package common
 
 import (
+	"fmt"
 	"reflect"
 	"testing"
 )
@@ -41,6 +42,20 @@ func TestTruncateStop(t *testing.T) {
 			expected:      []string{"Hello", " "},
 			expectedTrunc: true,
 		},
+		{
+			name:          "Multibyte split across pieces",
+			pieces:        []string{"你", "\xe5\xa5", "\xbd。"},
+			stop:          "。",
+			expected:      []string{"你", "\xe5\xa5", "\xbd"},
+			expectedTrunc: true,
+		},
+		{
+			name:          "Inside a character",
+			pieces:        []string{"\xe5\xa5\xbd", "\xa5\xbd!"},
+			stop:          "\xa5\xbd",
+			expected:      []string{"\xe5\xa5\xbd", "\xa5\xbd!"},
+			expectedTrunc: false,
+		},
 		{
 			name:          "Middle",
 			pieces:        []string{"hello", " wor"},
@@ -60,6 +75,125 @@ func TestTruncateStop(t *testing.T) {
 	}
 }
 
+func TestStopMatcher(t *testing.T) {
+	cases := []struct {
+		name    string
+		stops   []string
+		pieces  []string
+		stop    string
+		partial []bool
+	}{
+		{"none", nil, []string{"hello"}, "", []bool{false}},
+		{"single piece", []string{"world"}, []string{"hello world"}, "world", nil},
+		{"across pieces", []string{"</answer>"}, []string{"42</", "ans", "wer>"}, "</answer>", []bool{true, true}},
+		{"false start", []string{"abc"}, []string{"ab", "d", "abab", "c"}, "abc", []bool{true, false, true}},
+		{"first to end", []string{"bcd", "c"}, []string{"abcd"}, "c", nil},
+		{"longest at end", []string{"x", "yx"}, []string{"y", "x"}, "yx", []bool{true}},
+		{"multibyte", []string{"。\n"}, []string{"好\xe3", "\x80\x82", "\n"}, "。\n", []bool{true, true}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewStopMatcher(tt.stops, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, piece := range tt.pieces {
+				stop, ok := m.Feed(piece)
+				if ok {
+					if i != len(tt.pieces)-1 || stop != tt.stop {
+						t.Fatalf("piece %d: unexpected stop %q", i, stop)
+					}
+					return
+				}
+
+				if tt.partial != nil && m.Partial() != tt.partial[i] {
+					t.Errorf("piece %d: expected partial %v, got %v", i, tt.partial[i], m.Partial())
+				}
+			}
+
+			if tt.stop != "" {
+				t.Errorf("expected stop %q", tt.stop)
+			}
+		})
+	}
+}
+
+func TestStopMatcherMany(t *testing.T) {
+	var stops []string
+	for i := range 500 {
+		stops = append(stops, fmt.Sprintf("<stop%d>", i))
+	}
+
+	m, err := NewStopMatcher(stops, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, piece := range []string{"text <stop1", "2", "3> more"} {
+		if stop, ok := m.Feed(piece); ok {
+			if stop != "<stop123>" {
+				t.Errorf("expected <stop123>, got %q", stop)
+			}
+			return
+		}
+	}
+
+	t.Error("expected a stop")
+}
+
+func TestStopMatcherPatterns(t *testing.T) {
+	cases := []struct {
+		name     string
+		stops    []string
+		patterns []string
+		pieces   []string
+		stop     string
+		partial  []bool
+	}{
+		{"newlines", nil, []string{`\n{3,}`}, []string{"a\n", "\n", "\nb"}, "\n\n\n", []bool{true, true}},
+		{"line start", nil, []string{"^User:"}, []string{"Hi User:", " ok\nUs", "er:"}, "User:", []bool{false, true}},
+		{"text start", nil, []string{"^User:"}, []string{"User", ":"}, "User:", []bool{true}},
+		{"line end", nil, []string{`done$`}, []string{"not done yet ", "done", "\n"}, "done", []bool{false, true}},
+		{"word boundary", nil, []string{`\bend\b`}, []string{"ending", " end", "."}, "end", []bool{false, true}},
+		{"case insensitive", nil, []string{`(?i)</?answer>`}, []string{"42</ANS", "WER>"}, "</ANSWER>", []bool{true}},
+		{"no empty matches", nil, []string{`x*`}, []string{"ab", "yx"}, "x", []bool{false}},
+		{"multibyte", nil, []string{`。{2}`}, []string{"好\xe3\x80", "\x82\xe3", "\x80\x82"}, "。。", []bool{true, true}},
+		{"with stops", []string{"STOP"}, []string{`[0-9]{3}`}, []string{"12", "3STOP"}, "123", []bool{true}},
+		{"stop first", []string{"ST"}, []string{`ST[0-9]`}, []string{"S", "T1"}, "ST", []bool{true}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewStopMatcher(tt.stops, tt.patterns)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, piece := range tt.pieces {
+				stop, ok := m.Feed(piece)
+				if ok {
+					if i != len(tt.pieces)-1 || stop != tt.stop {
+						t.Fatalf("piece %d: unexpected stop %q", i, stop)
+					}
+					return
+				}
+
+				if m.Partial() != tt.partial[i] {
+					t.Errorf("piece %d: expected partial %v, got %v", i, tt.partial[i], m.Partial())
+				}
+			}
+
+			t.Errorf("expected stop %q", tt.stop)
+		})
+	}
+
+	if _, err := NewStopMatcher(nil, []string{"("}); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}
+
 func TestIncompleteUnicode(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/ollama/ollama/llama"
+	"github.com/ollama/ollama/llm"
 )
 
 type InputCache struct {
@@ -61,26 +62,40 @@ type InputCacheSlot struct {
 
 	// last time this cache was used (as of start of processing)
 	lastUsed time.Time
+
+	// Key is the cache key of the request whose prompt the slot holds, if
+	// any. Pinned slots are only loaded by requests with their key, so that
+	// other prompts don't replace their contents.
+	Key    string
+	Pinned bool
 }
 
-func (c *InputCache) LoadCacheSlot(prompt []input, cachePrompt bool) (*InputCacheSlot, []input, error) {
+// LoadCacheSlot finds a slot for prompt, preferring a slot that holds the
+// prompt of a request with the cache key key if it isn't empty.
+func (c *InputCache) LoadCacheSlot(prompt []input, key string, cachePrompt bool) (*InputCacheSlot, []input, error) {
 	var slot *InputCacheSlot
 	var numPast int
 	var err error
 
-	// In single-user scenarios, the longest cache slot works fine for getting good input
-	// cache hit rates and it reuses the same VRAM over and over again, which is good for
-	// GPU performance in situations where we miss the input cache.
-	// For multiple users, the "best" cache slot produces better input cache hit rates
-	// at the cost of worse performance when we miss the input cache (because it causes
-	// GPU L2 cache misses due to spreading out accesses across VRAM).
-	if !c.multiUserCache {
-		slot, numPast, err = c.findLongestCacheSlot(prompt)
-	} else {
-		slot, numPast, err = c.findBestCacheSlot(prompt)
+	if key != "" {
+		slot, numPast = c.findKeyedCacheSlot(prompt, key)
 	}
-	if err != nil {
-		return nil, nil, err
+
+	if slot == nil {
+		// In single-user scenarios, the longest cache slot works fine for getting good input
+		// cache hit rates and it reuses the same VRAM over and over again, which is good for
+		// GPU performance in situations where we miss the input cache.
+		// For multiple users, the "best" cache slot produces better input cache hit rates
+		// at the cost of worse performance when we miss the input cache (because it causes
+		// GPU L2 cache misses due to spreading out accesses across VRAM).
+		if !c.multiUserCache {
+			slot, numPast, err = c.findLongestCacheSlot(prompt)
+		} else {
+			slot, numPast, err = c.findBestCacheSlot(prompt)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 
 	if !cachePrompt {
@@ -89,6 +104,7 @@ func (c *InputCache) LoadCacheSlot(prompt []input, cachePrompt bool) (*InputCach
 
 	slot.InUse = true
 	slot.lastUsed = time.Now()
+	slot.Key = key
 
 	if numPast == len(prompt) {
 		// Leave one input to sample so we can get a response
@@ -110,15 +126,113 @@ func (c *InputCache) LoadCacheSlot(prompt []input, cachePrompt bool) (*InputCach
 	return slot, prompt, nil
 }
 
-func (c *InputCache) findLongestCacheSlot(prompt []input) (*InputCacheSlot, int, error) {
+// findKeyedCacheSlot finds the free slot holding the prompt of a request
+// with the cache key key that has the longest prefix in common with prompt,
+// or returns nil if there is none.
+func (c *InputCache) findKeyedCacheSlot(prompt []input, key string) (*InputCacheSlot, int) {
 	longest := -1
 	var longestSlot *InputCacheSlot
 
 	for i, s := range c.slots {
+		if s.InUse || s.Key != key {
+			continue
+		}
+
+		count := countCommonPrefix(s.Inputs, prompt)
+		if count > longest {
+			longest = count
+			longestSlot = &c.slots[i]
+		}
+	}
+
+	return longestSlot, longest
+}
+
+var (
+	errCacheKeyNotFound = errors.New("no cached prompt has this key")
+	errNoUnpinnedSlots  = errors.New("pinning would leave no cache slot for other prompts")
+)
+
+// KeyedSlots lists the slots holding the prompts of requests with a cache
+// key.
+func (c *InputCache) KeyedSlots() []llm.CacheSlot {
+	var slots []llm.CacheSlot
+	for _, s := range c.slots {
+		if s.Key != "" {
+			slots = append(slots, llm.CacheSlot{Key: s.Key, Inputs: len(s.Inputs), Pinned: s.Pinned, InUse: s.InUse, LastUsed: s.lastUsed})
+		}
+	}
+
+	return slots
+}
+
+// Pin pins or unpins the slots holding the prompts of requests with the cache
+// key key. At least one slot is left unpinned for other prompts.
+func (c *InputCache) Pin(key string, pinned bool) error {
+	var keyed []*InputCacheSlot
+	var unpinned int
+	for i := range c.slots {
+		if c.slots[i].Key == key {
+			keyed = append(keyed, &c.slots[i])
+		} else if !c.slots[i].Pinned {
+			unpinned++
+		}
+	}
+
+	if len(keyed) == 0 {
+		return errCacheKeyNotFound
+	}
+
+	if pinned && unpinned == 0 {
+		return errNoUnpinnedSlots
+	}
+
+	for _, s := range keyed {
+		s.Pinned = pinned
+	}
+
+	return nil
+}
+
+// Evict removes the prompts of requests with the cache key key from the
+// cache. Slots that are in use keep their contents but lose the key.
+func (c *InputCache) Evict(key string) error {
+	var found bool
+	for i := range c.slots {
+		s := &c.slots[i]
+		if s.Key != key {
+			continue
+		}
+
+		found = true
+		s.Key, s.Pinned = "", false
 		if s.InUse {
 			continue
 		}
 
+		// This is only nil for unit tests
+		if c.lc != nil {
+			c.lc.KvCacheSeqRm(s.Id, 0, -1)
+		}
+		s.Inputs = nil
+	}
+
+	if !found {
+		return errCacheKeyNotFound
+	}
+
+	return nil
+}
+
+func (c *InputCache) findLongestCacheSlot(prompt []input) (*InputCacheSlot, int, error) {
+	longest := -1
+	var longestSlot *InputCacheSlot
+
+	for i, s := range c.slots {
+		if s.InUse || s.Pinned {
+			continue
+		}
+
 		count := countCommonPrefix(s.Inputs, prompt)
 		if count > longest {
 			longest = count
@@ -147,17 +261,18 @@ func (c *InputCache) findBestCacheSlot(prompt []input) (*InputCacheSlot, int, er
 			longestSlot = &c.slots[i]
 		}
 
-		if s.lastUsed.Compare(oldest) < 0 && !s.InUse {
+		// pinned slots may be forked but not evicted
+		if s.lastUsed.Compare(oldest) < 0 && !s.InUse && !s.Pinned {
 			oldest = s.lastUsed
 			oldestSlot = &c.slots[i]
 		}
 	}
 
-	if longest == len(longestSlot.Inputs) && !longestSlot.InUse {
+	if longest == len(longestSlot.Inputs) && !longestSlot.InUse && !longestSlot.Pinned {
 		return longestSlot, longest, nil
 	}
 
-	if oldestSlot.InUse {
+	if oldestSlot == nil || oldestSlot.InUse {
 		return nil, 0, errors.New("no available cache slots")
 	}
 
@@ -6,25 +6,26 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"log"
 	"log/slog"
 	"net"
 	"net/http"
 	"os"
-	"path/filepath"
 	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unicode/utf8"
 
 	"golang.org/x/sync/semaphore"
 
 	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/crash"
 	"github.com/ollama/ollama/llama"
 	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/logutil"
 	"github.com/ollama/ollama/runner/common"
 )
 
@@ -61,11 +62,14 @@ type Sequence struct {
 	crossAttention bool
 
 	// channel to send responses over
-	responses chan string
+	responses chan llm.CompletionResponse
 
 	// channel to stop decoding (such as if the remote connection is closed)
 	quit chan bool
 
+	// true while decoding waits for the client to read the buffered responses
+	paused bool
+
 	// number of tokens to predict
 	numPredict int
 
@@ -74,8 +78,8 @@ type Sequence struct {
 	// channel to send back the embedding if embedding only
 	embedding chan []float32
 
-	// stop sequences
-	stop []string
+	// stops finds the stop sequences in the output
+	stops *common.StopMatcher
 
 	// number of inputs to keep at the beginning when shifting context window
 	numKeep int
@@ -83,21 +87,59 @@ type Sequence struct {
 	// true if an embedding are to be returned instead of text generation
 	embeddingOnly bool
 
+	// true if the probability of ending the sequence should be reported
+	reportEOS bool
+
+	// probability of ending the sequence instead of generating the last token
+	eosProbability float32
+
+	// true if the log probabilities of the generated tokens should be
+	// reported, with the topLogprobs most likely tokens at each position
+	reportLogprobs bool
+	topLogprobs    int
+
+	// log probabilities of the pending responses
+	pendingLogprobs []api.Logprob
+
+	// when scoring instead of generating, targets holds the token following
+	// each input whose log probability is wanted, or -1
+	targets []int
+
+	// outputs of the current batch to score
+	pendingScores []pendingScore
+
+	// log probabilities of the scored tokens and whether each was the most
+	// likely token
+	logprobs []float64
+	greedy   bool
+
 	doneReason string
 
+	// logCtx is the context of the request, which carries its ID for logging
+	logCtx context.Context
+
 	// Metrics
 	startProcessingTime time.Time
 	startGenerationTime time.Time
 	numDecoded          int
 	numPromptInputs     int
+
+	// numTruncated is the number of inputs left out of a prompt that didn't
+	// fit in the context window
+	numTruncated int
 }
 
 type NewSequenceParams struct {
 	numPredict     int
 	stop           []string
+	stopRegex      []string
 	numKeep        int
 	samplingParams *llama.SamplingParams
 	embedding      bool
+	reportEOS      bool
+	logprobs       bool
+	topLogprobs    int
+	logCtx         context.Context
 }
 
 func (s *Server) NewSequence(prompt string, images []llm.ImageData, params NewSequenceParams) (*Sequence, error) {
@@ -123,13 +165,20 @@ func (s *Server) NewSequence(prompt string, images []llm.ImageData, params NewSe
 	// Ensure that at least 1 input can be discarded during shift
 	params.numKeep = min(params.numKeep, s.cache.numCtx-1)
 
+	var numTruncated int
 	if len(inputs) > s.cache.numCtx {
 		discard := len(inputs) - s.cache.numCtx
 		newInputs := inputs[:params.numKeep]
 		newInputs = append(newInputs, inputs[params.numKeep+discard:]...)
 
-		slog.Warn("truncating input prompt", "limit", s.cache.numCtx, "prompt", len(inputs), "keep", params.numKeep, "new", len(newInputs))
+		slog.WarnContext(params.logCtx, "truncating input prompt", "limit", s.cache.numCtx, "prompt", len(inputs), "keep", params.numKeep, "new", len(newInputs))
 		inputs = newInputs
+		numTruncated = discard
+	}
+
+	stops, err := common.NewStopMatcher(params.stop, params.stopRegex)
+	if err != nil {
+		return nil, err
 	}
 
 	var sc *llama.SamplingContext
@@ -148,16 +197,21 @@ func (s *Server) NewSequence(prompt string, images []llm.ImageData, params NewSe
 	return &Sequence{
 		inputs:              inputs,
 		numPromptInputs:     len(inputs),
+		numTruncated:        numTruncated,
 		startProcessingTime: startTime,
 		numPredict:          params.numPredict,
 		pendingResponses:    make([]string, 0),
-		responses:           make(chan string, 100),
+		responses:           make(chan llm.CompletionResponse, s.streamBuffer),
 		quit:                make(chan bool, 1),
 		embedding:           make(chan []float32, 1),
 		samplingCtx:         sc,
 		embeddingOnly:       params.embedding,
-		stop:                params.stop,
+		reportEOS:           params.reportEOS,
+		reportLogprobs:      params.logprobs,
+		topLogprobs:         params.topLogprobs,
+		stops:               stops,
 		numKeep:             params.numKeep,
+		logCtx:              params.logCtx,
 	}, nil
 }
 
@@ -242,6 +296,19 @@ type Server struct {
 	// TODO (jmorganca): make this n_batch
 	batchSize int
 
+	// maximum number of elements llama.cpp computes at once
+	ubatchSize int
+
+	// number of responses buffered for each sequence before it pauses
+	streamBuffer int
+
+	// prefetches weights from disk for models larger than memory, if set
+	streamer *weightStreamer
+
+	// number of sequences currently paused and number of times any paused
+	pausedSeqs atomic.Int32
+	pauses     atomic.Uint64
+
 	// protects access to everything below this line
 	// this is context state needed for decoding
 	mu sync.Mutex
@@ -264,6 +331,35 @@ type Server struct {
 
 	// next sequence for prompt processing to avoid starvation
 	nextSeq int
+
+	// end of generation tokens, found the first time they are needed
+	eosOnce sync.Once
+	eos     []int32
+}
+
+// eosTokens returns the tokens that end generation
+func (s *Server) eosTokens() []int32 {
+	s.eosOnce.Do(func() {
+		for id := range s.model.NumVocab() {
+			if s.model.TokenIsEog(id) {
+				s.eos = append(s.eos, int32(id))
+			}
+		}
+	})
+
+	return s.eos
+}
+
+// logprob returns the log probability of token, whose text is piece, under
+// logits with the top most likely tokens at its position
+func (s *Server) logprob(logits []float32, token int32, piece string, top int) api.Logprob {
+	logprob, _ := common.LogProb(logits, token)
+	lp := api.Logprob{TokenLogprob: api.TokenLogprob{Token: piece, Logprob: logprob}}
+	for _, t := range common.TopLogProbs(logits, top) {
+		lp.TopLogprobs = append(lp.TopLogprobs, api.TokenLogprob{Token: s.model.TokenToPiece(int(t.Token)), Logprob: t.LogProb})
+	}
+
+	return lp
 }
 
 func (s *Server) allNil() bool {
@@ -277,8 +373,12 @@ func (s *Server) allNil() bool {
 
 func flushPending(seq *Sequence) bool {
 	joined := strings.Join(seq.pendingResponses, "")
+	tokens := len(seq.pendingResponses)
 	seq.pendingResponses = []string{}
 
+	logprobs := seq.pendingLogprobs
+	seq.pendingLogprobs = nil
+
 	// Check if there are any partial UTF-8 characters remaining.
 	// We already check and queue as we are generating but some may
 	// still make it here:
@@ -294,15 +394,46 @@ func flushPending(seq *Sequence) bool {
 	}
 
 	select {
-	case seq.responses <- joined:
+	case seq.responses <- llm.CompletionResponse{Content: joined, Tokens: tokens, EOSProbability: seq.eosProbability, Logprobs: logprobs}:
 		return true
 	case <-seq.quit:
 		return false
 	}
 }
 
+// pause reports whether decoding seq should wait because its buffer of
+// responses is full, which happens when the client reads them slower than
+// they are generated. Skipping it leaves its room in batches to the other
+// sequences instead of stalling all of them until the client catches up.
+func (s *Server) pause(seq *Sequence) bool {
+	full := len(seq.responses) == cap(seq.responses)
+	if full != seq.paused {
+		seq.paused = full
+		if full {
+			s.pausedSeqs.Add(1)
+			s.pauses.Add(1)
+			slog.DebugContext(seq.logCtx, "pausing sequence until the client catches up", "buffered", len(seq.responses))
+		} else {
+			s.pausedSeqs.Add(-1)
+		}
+	}
+
+	return full
+}
+
+// resume wakes up processing of batches, which waits while all sequences are
+// paused, after a client has read a response or gone away.
+func (s *Server) resume() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cond.Signal()
+}
+
 func (s *Server) removeSequence(seqIndex int, reason string) {
 	seq := s.seqs[seqIndex]
+	if seq.paused {
+		s.pausedSeqs.Add(-1)
+	}
 
 	flushPending(seq)
 	seq.doneReason = reason
@@ -314,6 +445,16 @@ func (s *Server) removeSequence(seqIndex int, reason string) {
 }
 
 func (s *Server) run(ctx context.Context) {
+	defer func() {
+		if v := recover(); v != nil {
+			// the state of the model is unknown after a panic, so the runner
+			// exits and the server fails the requests in progress
+			crash.New("runner", v).Log()
+			fmt.Fprintf(os.Stderr, "panic: %v\n", v)
+			os.Exit(2)
+		}
+	}()
+
 	s.ready.Wait()
 
 	// Logically these batches are used only within the context of processBatch
@@ -378,9 +519,18 @@ func (s *Server) processBatch(tokenBatch *llama.Batch, embedBatch *llama.Batch)
 			continue
 		}
 
+		if s.pause(seq) {
+			select {
+			case <-seq.quit:
+				s.removeSequence(seqIdx, api.DoneReasonCanceled)
+			default:
+			}
+			continue
+		}
+
 		// if past the num predict limit
 		if seq.numPredict > 0 && seq.numPredicted >= seq.numPredict {
-			s.removeSequence(seqIdx, "limit")
+			s.removeSequence(seqIdx, api.DoneReasonLength)
 			continue
 		}
 
@@ -419,21 +569,31 @@ func (s *Server) processBatch(tokenBatch *llama.Batch, embedBatch *llama.Batch)
 			}
 
 			crossAttention = seq.crossAttention
-			batch.Add(input.token, input.embed, len(seq.cache.Inputs)+len(seq.pendingInputs), i+1 == len(seq.inputs), seq.cache.Id)
+			scored := seq.targets != nil && seq.targets[i] >= 0
+			batch.Add(input.token, input.embed, len(seq.cache.Inputs)+len(seq.pendingInputs), i+1 == len(seq.inputs) || scored, seq.cache.Id)
 			seq.pendingInputs = append(seq.pendingInputs, input)
 			seq.iBatch = batch.NumTokens() - 1
+			if scored {
+				seq.pendingScores = append(seq.pendingScores, pendingScore{output: seq.iBatch, target: seq.targets[i]})
+			}
 		}
 
 		seq.inputs = seq.inputs[len(seq.pendingInputs):]
+		if seq.targets != nil {
+			seq.targets = seq.targets[len(seq.pendingInputs):]
+		}
 	}
 
 	if batch == nil || batch.NumTokens() == 0 {
+		if s.pausedSeqs.Load() > 0 {
+			s.cond.Wait()
+		}
 		return nil
 	}
 
 	s.lc.SetCrossAttention(crossAttention)
 
-	err := s.lc.Decode(batch)
+	err := s.streamer.decode(func() error { return s.lc.Decode(batch) })
 	if err != nil {
 		return fmt.Errorf("failed to decode batch: %w", err)
 	}
@@ -446,7 +606,7 @@ func (s *Server) processBatch(tokenBatch *llama.Batch, embedBatch *llama.Batch)
 	}
 
 	for i, seq := range s.seqs {
-		if seq == nil {
+		if seq == nil || seq.paused {
 			continue
 		}
 
@@ -456,6 +616,21 @@ func (s *Server) processBatch(tokenBatch *llama.Batch, embedBatch *llama.Batch)
 			seq.pendingInputs = []input{}
 		}
 
+		// score instead of sampling when scoring
+		if seq.targets != nil {
+			for _, p := range seq.pendingScores {
+				logprob, greedy := common.LogProb(s.lc.GetLogitsIth(p.output), int32(p.target))
+				seq.logprobs = append(seq.logprobs, logprob)
+				seq.greedy = seq.greedy && greedy
+			}
+			seq.pendingScores = seq.pendingScores[:0]
+
+			if len(seq.inputs) == 0 {
+				s.removeSequence(i, api.DoneReasonStop)
+			}
+			continue
+		}
+
 		// don't sample prompt processing
 		if len(seq.inputs) != 0 {
 			continue
@@ -478,6 +653,10 @@ func (s *Server) processBatch(tokenBatch *llama.Batch, embedBatch *llama.Batch)
 			continue
 		}
 
+		if seq.reportEOS {
+			seq.eosProbability = common.EOSProbability(s.lc.GetLogitsIth(seq.iBatch), s.eosTokens())
+		}
+
 		// sample a token
 		token := seq.samplingCtx.Sample(s.lc, seq.iBatch)
 		seq.samplingCtx.Accept(token, true)
@@ -491,7 +670,7 @@ func (s *Server) processBatch(tokenBatch *llama.Batch, embedBatch *llama.Batch)
 			// as it's important for the /api/generate context
 			// seq.responses <- piece
 
-			s.removeSequence(i, "stop")
+			s.removeSequence(i, api.DoneReasonStop)
 			continue
 		}
 
@@ -500,13 +679,20 @@ func (s *Server) processBatch(tokenBatch *llama.Batch, embedBatch *llama.Batch)
 		seq.pendingResponses = append(seq.pendingResponses, piece)
 		sequence := strings.Join(seq.pendingResponses, "")
 
-		if ok, stop := common.FindStop(sequence, seq.stop); ok {
-			slog.Debug("hit stop token", "pending", seq.pendingResponses, "stop", stop)
+		if seq.reportLogprobs {
+			seq.pendingLogprobs = append(seq.pendingLogprobs, s.logprob(s.lc.GetLogitsIth(seq.iBatch), int32(token), piece, seq.topLogprobs))
+		}
+
+		if stop, ok := seq.stops.Feed(piece); ok {
+			slog.DebugContext(seq.logCtx, "hit stop token", "pending", seq.pendingResponses, "stop", stop)
 
 			var tokenTruncated bool
 			origLen := len(seq.pendingResponses)
 			seq.pendingResponses, tokenTruncated = common.TruncateStop(seq.pendingResponses, stop)
 			newLen := len(seq.pendingResponses)
+			if len(seq.pendingLogprobs) > newLen {
+				seq.pendingLogprobs = seq.pendingLogprobs[:newLen]
+			}
 
 			// Update the cache based on the tokens that will be returned:
 			// - We have 1 token more than is currently in the cache because
@@ -522,11 +708,11 @@ func (s *Server) processBatch(tokenBatch *llama.Batch, embedBatch *llama.Batch)
 			}
 			seq.cache.Inputs = seq.cache.Inputs[:tokenLen]
 
-			s.removeSequence(i, "stop")
+			s.removeSequence(i, api.DoneReasonStop)
 			continue
 		}
 
-		if common.ContainsStopSuffix(sequence, seq.stop) {
+		if seq.stops.Partial() {
 			continue
 		}
 
@@ -535,7 +721,7 @@ func (s *Server) processBatch(tokenBatch *llama.Batch, embedBatch *llama.Batch)
 		}
 
 		if !flushPending(seq) {
-			s.removeSequence(i, "connection")
+			s.removeSequence(i, api.DoneReasonCanceled)
 		}
 	}
 
@@ -579,15 +765,21 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 		MirostatTau:    req.Options.MirostatTau,
 		MirostatEta:    req.Options.MirostatEta,
 		Seed:           uint32(req.Options.Seed),
+		IgnoreEOS:      req.Options.IgnoreEOS,
 		Grammar:        req.Grammar,
 	}
 
 	seq, err := s.NewSequence(req.Prompt, req.Images, NewSequenceParams{
 		numPredict:     req.Options.NumPredict,
 		stop:           req.Options.Stop,
+		stopRegex:      req.Options.StopRegex,
 		numKeep:        req.Options.NumKeep,
 		samplingParams: &samplingParams,
 		embedding:      false,
+		reportEOS:      req.EOSProbability,
+		logprobs:       req.Logprobs,
+		topLogprobs:    req.TopLogprobs,
+		logCtx:         r.Context(),
 	})
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to create new sequence: %v", err), http.StatusInternalServerError)
@@ -597,9 +789,9 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 	// Ensure there is a place to put the sequence, released when removed from s.seqs
 	if err := s.seqsSem.Acquire(r.Context(), 1); err != nil {
 		if errors.Is(err, context.Canceled) {
-			slog.Info("aborting completion request due to client closing the connection")
+			slog.InfoContext(r.Context(), "aborting completion request due to client closing the connection")
 		} else {
-			slog.Error("Failed to acquire semaphore", "error", err)
+			slog.ErrorContext(r.Context(), "Failed to acquire semaphore", "error", err)
 		}
 		return
 	}
@@ -608,7 +800,7 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 	found := false
 	for i, sq := range s.seqs {
 		if sq == nil {
-			seq.cache, seq.inputs, err = s.cache.LoadCacheSlot(seq.inputs, true)
+			seq.cache, seq.inputs, err = s.cache.LoadCacheSlot(seq.inputs, req.CacheKey, true)
 			if err != nil {
 				s.mu.Unlock()
 				http.Error(w, fmt.Sprintf("Failed to load cache: %v", err), http.StatusInternalServerError)
@@ -634,28 +826,35 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 		select {
 		case <-r.Context().Done():
 			close(seq.quit)
+			s.resume()
 			return
-		case content, ok := <-seq.responses:
+		case resp, ok := <-seq.responses:
 			if ok {
-				if err := json.NewEncoder(w).Encode(&llm.CompletionResponse{
-					Content: content,
-				}); err != nil {
+				// the sequence pauses when its buffer is full, so taking a
+				// response from a full buffer may let it continue
+				if len(seq.responses) == cap(seq.responses)-1 {
+					s.resume()
+				}
+
+				if err := json.NewEncoder(w).Encode(&resp); err != nil {
 					http.Error(w, fmt.Sprintf("failed to encode response: %v", err), http.StatusInternalServerError)
 					close(seq.quit)
+					s.resume()
 					return
 				}
 
 				flusher.Flush()
 			} else {
 				// Send the final response
-				doneReason := "stop"
-				if seq.doneReason == "limit" {
-					doneReason = "length"
+				doneReason := seq.doneReason
+				if doneReason == "" {
+					doneReason = api.DoneReasonStop
 				}
 				if err := json.NewEncoder(w).Encode(&llm.CompletionResponse{
 					Done:               true,
 					DoneReason:         doneReason,
 					PromptEvalCount:    seq.numPromptInputs,
+					PromptTruncated:    seq.numTruncated,
 					PromptEvalDuration: seq.startGenerationTime.Sub(seq.startProcessingTime),
 					EvalCount:          seq.numDecoded,
 					EvalDuration:       time.Since(seq.startGenerationTime),
@@ -678,54 +877,107 @@ func (s *Server) embeddings(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 
-	slog.Debug("embedding request", "content", req.Content)
+	if req.Contents != nil {
+		s.embeddingsBatch(w, r, req.Contents)
+		return
+	}
 
-	seq, err := s.NewSequence(req.Content, nil, NewSequenceParams{embedding: true})
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to create new sequence: %v", err), http.StatusInternalServerError)
+	slog.DebugContext(r.Context(), "embedding request", "content", req.Content)
+
+	seq, err := s.addEmbeddingSequence(r.Context(), req.Content)
+	if errors.Is(err, context.Canceled) {
+		slog.InfoContext(r.Context(), "aborting embeddings request due to client closing the connection")
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Ensure there is a place to put the sequence, released when removed from s.seqs
-	if err := s.seqsSem.Acquire(r.Context(), 1); err != nil {
+	embedding := <-seq.embedding
+
+	if err := json.NewEncoder(w).Encode(&llm.EmbeddingResponse{
+		Embedding: embedding,
+	}); err != nil {
+		http.Error(w, fmt.Sprintf("failed to encode response: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// embeddingsBatch embeds several inputs, reporting errors for each of them.
+// All inputs are queued before waiting for any embedding so that they share
+// batches as far as there are free sequences.
+func (s *Server) embeddingsBatch(w http.ResponseWriter, r *http.Request, contents []string) {
+	slog.DebugContext(r.Context(), "embedding request", "inputs", len(contents))
+
+	seqs := make([]*Sequence, len(contents))
+	results := make([]llm.EmbeddingResult, len(contents))
+	for i, content := range contents {
+		seq, err := s.addEmbeddingSequence(r.Context(), content)
 		if errors.Is(err, context.Canceled) {
-			slog.Info("aborting embeddings request due to client closing the connection")
+			slog.InfoContext(r.Context(), "aborting embeddings request due to client closing the connection")
+			return
+		} else if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+
+		seqs[i] = seq
+	}
+
+	for i, seq := range seqs {
+		if seq == nil {
+			continue
+		}
+
+		// the channel is closed without an embedding if the sequence
+		// was removed before its prompt was processed
+		if embedding := <-seq.embedding; embedding != nil {
+			results[i].Embedding = embedding
 		} else {
-			slog.Error("Failed to acquire semaphore", "error", err)
+			results[i].Error = "failed to embed input"
 		}
-		return
+	}
+
+	if err := json.NewEncoder(w).Encode(&llm.EmbeddingResponse{
+		Embeddings: results,
+	}); err != nil {
+		http.Error(w, fmt.Sprintf("failed to encode response: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// addEmbeddingSequence adds a sequence that embeds content once a sequence is
+// free and returns it. The embedding is sent on its embedding channel.
+func (s *Server) addEmbeddingSequence(ctx context.Context, content string) (*Sequence, error) {
+	seq, err := s.NewSequence(content, nil, NewSequenceParams{embedding: true, logCtx: ctx})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create new sequence: %v", err)
+	}
+
+	// Ensure there is a place to put the sequence, released when removed from s.seqs
+	if err := s.seqsSem.Acquire(ctx, 1); err != nil {
+		if !errors.Is(err, context.Canceled) {
+			slog.ErrorContext(ctx, "Failed to acquire semaphore", "error", err)
+		}
+		return nil, err
 	}
 
 	s.mu.Lock()
-	found := false
+	defer s.mu.Unlock()
+
 	for i, sq := range s.seqs {
 		if sq == nil {
-			seq.cache, seq.inputs, err = s.cache.LoadCacheSlot(seq.inputs, false)
+			seq.cache, seq.inputs, err = s.cache.LoadCacheSlot(seq.inputs, "", false)
 			if err != nil {
-				s.mu.Unlock()
-				http.Error(w, fmt.Sprintf("Failed to load cache: %v", err), http.StatusInternalServerError)
-				return
+				s.seqsSem.Release(1)
+				return nil, fmt.Errorf("Failed to load cache: %v", err)
 			}
 			s.seqs[i] = seq
 			s.cond.Signal()
-			found = true
-			break
+			return seq, nil
 		}
 	}
-	s.mu.Unlock()
 
-	if !found {
-		http.Error(w, "could not find an available sequence", http.StatusInternalServerError)
-		return
-	}
-
-	embedding := <-seq.embedding
-
-	if err := json.NewEncoder(w).Encode(&llm.EmbeddingResponse{
-		Embedding: embedding,
-	}); err != nil {
-		http.Error(w, fmt.Sprintf("failed to encode response: %v", err), http.StatusInternalServerError)
-	}
+	s.seqsSem.Release(1)
+	return nil, errors.New("could not find an available sequence")
 }
 
 func (s *Server) health(w http.ResponseWriter, r *http.Request) {
@@ -733,11 +985,78 @@ func (s *Server) health(w http.ResponseWriter, r *http.Request) {
 	if err := json.NewEncoder(w).Encode(&llm.ServerStatusResponse{
 		Status:   s.status,
 		Progress: s.progress,
+		Streams: llm.StreamStats{
+			Paused: int(s.pausedSeqs.Load()),
+			Pauses: s.pauses.Load(),
+		},
 	}); err != nil {
 		http.Error(w, fmt.Sprintf("failed to encode response: %v", err), http.StatusInternalServerError)
 	}
 }
 
+func (s *Server) cacheSlots(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cache == nil {
+		http.Error(w, "model is not loaded", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.cache.KeyedSlots()); err != nil {
+		http.Error(w, fmt.Sprintf("failed to encode response: %v", err), http.StatusInternalServerError)
+	}
+}
+
+func (s *Server) pinCache(w http.ResponseWriter, r *http.Request) {
+	var req llm.CachePinRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cache == nil {
+		http.Error(w, "model is not loaded", http.StatusServiceUnavailable)
+		return
+	}
+
+	cacheError(w, s.cache.Pin(req.Key, req.Pinned))
+}
+
+func (s *Server) evictCache(w http.ResponseWriter, r *http.Request) {
+	var req llm.CacheEvictRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cache == nil {
+		http.Error(w, "model is not loaded", http.StatusServiceUnavailable)
+		return
+	}
+
+	cacheError(w, s.cache.Evict(req.Key))
+}
+
+// cacheError writes the response to a request that changed the cache
+func cacheError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errCacheKeyNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errNoUnpinnedSlots):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
 type multiLPath []string
 
 func (m *multiLPath) Set(value string) error {
@@ -766,7 +1085,7 @@ func (s *Server) loadModel(
 		panic(err)
 	}
 
-	ctxParams := llama.NewContextParams(kvSize, s.batchSize*s.parallel, s.parallel, threads, flashAttention, kvCacheType)
+	ctxParams := llama.NewContextParams(kvSize, s.batchSize*s.parallel, s.ubatchSize, s.parallel, threads, flashAttention, kvCacheType)
 	s.lc, err = llama.NewContextWithModel(s.model, ctxParams)
 	if err != nil {
 		panic(err)
@@ -804,6 +1123,7 @@ func Execute(args []string) error {
 	ppath := fs.String("mmproj", "", "Path to projector binary file")
 	parallel := fs.Int("parallel", 1, "Number of sequences to handle simultaneously")
 	batchSize := fs.Int("batch-size", 512, "Batch size")
+	ubatchSize := fs.Int("ubatch-size", 512, "Physical batch size")
 	nGpuLayers := fs.Int("n-gpu-layers", 0, "Number of layers to offload to GPU")
 	mainGpu := fs.Int("main-gpu", 0, "Main GPU")
 	flashAttention := fs.Bool("flash-attn", false, "Enable flash attention")
@@ -816,6 +1136,10 @@ func Execute(args []string) error {
 	mlock := fs.Bool("mlock", false, "force system to keep model in RAM rather than swapping or compressing")
 	tensorSplit := fs.String("tensor-split", "", "fraction of the model to offload to each GPU, comma-separated list of proportions")
 	multiUserCache := fs.Bool("multiuser-cache", false, "optimize input cache algorithm for multiple users")
+	lowPriorityLoad := fs.Bool("low-priority-load", false, "load the model with a low i/o priority")
+	streamBuffer := fs.Int("stream-buffer", 100, "number of responses to buffer for each sequence before pausing it until the client catches up")
+	streamWeights := fs.Int("stream-weights", 0, "prefetch the weights of this many layers ahead from disk, for models larger than memory (requires mmap)")
+	sandbox := fs.Bool("sandbox", false, "deny network access and privileged system calls once listening (linux only)")
 
 	var lpaths multiLPath
 	fs.Var(&lpaths, "lora", "Path to lora layer file (can be specified multiple times)")
@@ -831,28 +1155,22 @@ func Execute(args []string) error {
 	if *verbose {
 		level = slog.LevelDebug
 	}
-	handler := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
-		Level:     level,
-		AddSource: true,
-		ReplaceAttr: func(_ []string, attr slog.Attr) slog.Attr {
-			if attr.Key == slog.SourceKey {
-				source := attr.Value.Any().(*slog.Source)
-				source.File = filepath.Base(source.File)
-			}
-			return attr
-		},
-	})
-	slog.SetDefault(slog.New(handler))
+	if err := logutil.Setup(os.Stderr, level, logutil.Runner); err != nil {
+		return err
+	}
+
 	slog.Info("starting go runner")
 
 	llama.BackendInit()
 
 	server := &Server{
-		batchSize: *batchSize,
-		parallel:  *parallel,
-		seqs:      make([]*Sequence, *parallel),
-		seqsSem:   semaphore.NewWeighted(int64(*parallel)),
-		status:    llm.ServerStatusLoadingModel,
+		batchSize:    *batchSize,
+		ubatchSize:   *ubatchSize,
+		streamBuffer: max(*streamBuffer, 1),
+		parallel:     *parallel,
+		seqs:         make([]*Sequence, *parallel),
+		seqsSem:      semaphore.NewWeighted(int64(*parallel)),
+		status:       llm.ServerStatusLoadingModel,
 	}
 
 	var tensorSplitFloats []float32
@@ -876,8 +1194,44 @@ func Execute(args []string) error {
 		},
 	}
 
+	if *streamWeights > 0 {
+		if !params.UseMmap {
+			slog.Warn("streaming weights requires mmap, which is disabled")
+		} else if streamer, err := newWeightStreamer(*mpath, *streamWeights); err != nil {
+			slog.Warn("unable to stream weights", "error", err)
+		} else {
+			server.streamer = streamer
+		}
+	}
+
+	addr := "127.0.0.1:" + strconv.Itoa(*port)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		slog.Error("failed to listen", "addr", addr, "error", err)
+		return err
+	}
+	defer listener.Close()
+
+	// the sandbox denies creating sockets, so it follows the listener but
+	// precedes parsing the model
+	if *sandbox {
+		if err := common.Sandbox(); err != nil {
+			slog.Warn("unable to sandbox runner", "error", err)
+		}
+	}
+
 	server.ready.Add(1)
-	go server.loadModel(params, *mpath, lpaths, *ppath, *kvSize, *kvCacheType, *flashAttention, *threads, *multiUserCache)
+	go func() {
+		if *lowPriorityLoad {
+			if restore, err := common.LowerIOPriority(); err != nil {
+				slog.Warn("unable to lower i/o priority while loading", "error", err)
+			} else {
+				defer restore()
+			}
+		}
+
+		server.loadModel(params, *mpath, lpaths, *ppath, *kvSize, *kvCacheType, *flashAttention, *threads, *multiUserCache)
+	}()
 
 	server.cond = sync.NewCond(&server.mu)
 
@@ -886,26 +1240,28 @@ func Execute(args []string) error {
 
 	go server.run(ctx)
 
-	addr := "127.0.0.1:" + strconv.Itoa(*port)
-	listener, err := net.Listen("tcp", addr)
-	if err != nil {
-		fmt.Println("Listen error:", err)
-		return err
-	}
-	defer listener.Close()
-
 	mux := http.NewServeMux()
 	mux.HandleFunc("/embedding", server.embeddings)
 	mux.HandleFunc("/completion", server.completion)
+	mux.HandleFunc("/score", server.score)
+	mux.HandleFunc("/classify", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "this model does not support classification", http.StatusNotImplemented)
+	})
+	mux.HandleFunc("/finetune", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "fine-tuning requires the Ollama engine", http.StatusNotImplemented)
+	})
 	mux.HandleFunc("/health", server.health)
+	mux.HandleFunc("GET /cache", server.cacheSlots)
+	mux.HandleFunc("POST /cache/pin", server.pinCache)
+	mux.HandleFunc("POST /cache/evict", server.evictCache)
 
 	httpServer := http.Server{
-		Handler: mux,
+		Handler: logutil.Middleware(mux),
 	}
 
-	log.Println("Server listening on", addr)
+	slog.Info("server listening", "addr", addr)
 	if err := httpServer.Serve(listener); err != nil {
-		log.Fatal("server error:", err)
+		slog.Error("server error", "error", err)
 		return err
 	}
 
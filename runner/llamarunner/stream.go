@@ -0,0 +1,146 @@
+package llamarunner
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/runner/common"
+)
+
+// fileRange is the part of the model file that holds the weights of a layer
+type fileRange struct {
+	offset, length int64
+}
+
+// weightStreamer runs models that are larger than system memory by
+// prefetching the weights of each layer from the memory mapped model file
+// just before it is computed, so that the kernel can evict the weights of
+// layers that have already been computed to make room for them. Layers are
+// computed in the order that they are stored, which is the order of
+// layers.
+type weightStreamer struct {
+	f      *os.File
+	layers []fileRange
+
+	// ahead is how many layers are prefetched before the one computing
+	ahead int
+
+	// layerTime is how long the last batch took to compute each layer and
+	// paces the prefetching of the next one
+	layerTime time.Duration
+}
+
+func newWeightStreamer(path string, ahead int) (*weightStreamer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	g, _, err := ggml.Decode(f, 0)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	layers := weightLayers(g.Tensors())
+	if len(layers) == 0 {
+		f.Close()
+		return nil, fmt.Errorf("model has no layers to stream")
+	}
+
+	return &weightStreamer{f: f, layers: layers, ahead: max(ahead, 1)}, nil
+}
+
+// weightLayers returns the file ranges of the weights of the input layers,
+// each block and the output layers, in the order that they are computed
+func weightLayers(ts ggml.Tensors) []fileRange {
+	ranges := make(map[int]fileRange)
+	for _, t := range ts.Items() {
+		var n int
+		switch {
+		case strings.HasPrefix(t.Name, "output"):
+			n = math.MaxInt
+		case strings.HasPrefix(t.Name, "blk."):
+			if _, err := fmt.Sscanf(t.Name, "blk.%d.", &n); err != nil {
+				continue
+			}
+		default:
+			n = -1
+		}
+
+		start := int64(ts.Offset + t.Offset)
+		end := start + int64(t.Size())
+		if r, ok := ranges[n]; ok {
+			end = max(end, r.offset+r.length)
+			start = min(start, r.offset)
+		}
+
+		ranges[n] = fileRange{offset: start, length: end - start}
+	}
+
+	keys := make([]int, 0, len(ranges))
+	for n := range ranges {
+		keys = append(keys, n)
+	}
+	slices.Sort(keys)
+
+	layers := make([]fileRange, len(keys))
+	for i, n := range keys {
+		layers[i] = ranges[n]
+	}
+
+	return layers
+}
+
+// decode calls fn to compute a batch while prefetching the weights of the
+// layers it will need. Until the time to compute a layer is known, only the
+// first layers are prefetched. A nil weightStreamer only calls fn.
+func (w *weightStreamer) decode(fn func() error) error {
+	if w == nil {
+		return fn()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.prefetch(ctx, w.layerTime)
+	}()
+
+	start := time.Now()
+	err := fn()
+	cancel()
+	<-done
+
+	w.layerTime = time.Since(start) / time.Duration(len(w.layers))
+	return err
+}
+
+func (w *weightStreamer) prefetch(ctx context.Context, layerTime time.Duration) {
+	last := min(w.ahead, len(w.layers))
+	if layerTime > 0 {
+		last = len(w.layers)
+	}
+
+	for i, r := range w.layers[:last] {
+		if i >= w.ahead {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(layerTime):
+			}
+		}
+
+		if err := common.Prefetch(w.f, r.offset, r.length); err != nil {
+			slog.Debug("failed to prefetch weights", "layer", i, "error", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package llamarunner
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+func TestWeightStreamer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "model.gguf")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// layers in the order that they are computed
+	layers := [][]string{
+		{"token_embd.weight"},
+		{"blk.0.attn_q.weight", "blk.0.attn_k.weight"},
+		{"blk.2.attn_q.weight"},
+		{"blk.10.attn_q.weight", "blk.10.ffn_up.weight"},
+		{"output_norm.weight", "output.weight"},
+	}
+
+	var tensors []ggml.Tensor
+	for _, names := range layers {
+		for _, name := range names {
+			tensors = append(tensors, ggml.Tensor{Name: name, Shape: []uint64{8}, WriterTo: bytes.NewReader(make([]byte, 32))})
+		}
+	}
+
+	if err := ggml.WriteGGUF(f, ggml.KV{"general.architecture": "llama"}, tensors); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := newWeightStreamer(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.f.Close()
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	g, _, err := ggml.Decode(f, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	offsets := make(map[string]int64)
+	for _, t := range g.Tensors().Items() {
+		offsets[t.Name] = int64(g.Tensors().Offset + t.Offset)
+	}
+
+	want := make([]fileRange, len(layers))
+	for i, names := range layers {
+		start, end := offsets[names[0]], offsets[names[0]]+32
+		for _, name := range names[1:] {
+			start, end = min(start, offsets[name]), max(end, offsets[name]+32)
+		}
+		want[i] = fileRange{offset: start, length: end - start}
+	}
+
+	if diff := cmp.Diff(want, w.layers, cmp.AllowUnexported(fileRange{})); diff != "" {
+		t.Errorf("layers mismatch (-want +got):\n%s", diff)
+	}
+
+	var called bool
+	if err := w.decode(func() error { called = true; return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	if !called {
+		t.Error("expected decode to compute the batch")
+	}
+
+	// a nil streamer only computes the batch
+	var nilStreamer *weightStreamer
+	if err := nilStreamer.decode(func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/ollama/ollama/kvcache"
+	"github.com/ollama/ollama/llm"
 	"github.com/ollama/ollama/ml"
 	"github.com/ollama/ollama/model"
 	"github.com/ollama/ollama/model/input"
@@ -29,6 +30,9 @@ type InputCache struct {
 	multiUserCache bool
 
 	cache kvcache.Cache
+
+	// saves the prompts of requests with a cache key to disk, if enabled
+	snapshots *snapshots
 }
 
 func NewInputCache(model model.Model, kvCacheType string, kvSize int32, numSlots int, batchSize int, multiUserCache bool) (*InputCache, error) {
@@ -70,7 +74,13 @@ func kvCacheTypeFromStr(s string) ml.DType {
 }
 
 func (c *InputCache) Close() {
-	c.cache.Close()
+	if c.snapshots != nil {
+		c.snapshots.saving.Wait()
+	}
+
+	if c.cache != nil {
+		c.cache.Close()
+	}
 }
 
 // Locking: Operations on InputCacheSlot (including finding one
@@ -89,28 +99,57 @@ type InputCacheSlot struct {
 
 	// last time this cache was used (as of start of processing)
 	lastUsed time.Time
+
+	// Key is the cache key of the request whose prompt the slot holds, if
+	// any. Pinned slots are only loaded by requests with their key, so that
+	// other prompts don't replace their contents.
+	Key    string
+	Pinned bool
+
+	// saved is the inputs of the slot when it was last saved to disk or
+	// restored from it, if the key's prompt is saved
+	saved []input.Input
 }
 
-func (c *InputCache) LoadCacheSlot(prompt []input.Input) (*InputCacheSlot, []input.Input, error) {
+// LoadCacheSlot finds a slot for prompt, preferring a slot that holds the
+// prompt of a request with the cache key key if it isn't empty.
+func (c *InputCache) LoadCacheSlot(prompt []input.Input, key string) (*InputCacheSlot, []input.Input, error) {
 	var slot *InputCacheSlot
 	var numPast int32
 	var err error
 
-	// In single-user scenarios, the longest cache slot works fine for getting good input
-	// cache hit rates and it keeps the footprint of the cache small, which improves throughput.
-	// For multiple users, the "best" cache slot produces better input cache hit rates
-	// at the cost of worse performance when we miss the input cache.
-	if !c.multiUserCache {
-		slot, numPast, err = c.findLongestCacheSlot(prompt)
-	} else {
-		slot, numPast, err = c.findBestCacheSlot(prompt)
+	if key != "" {
+		slot, numPast = c.findKeyedCacheSlot(prompt, key)
 	}
-	if err != nil {
-		return nil, nil, err
+
+	if slot == nil {
+		// In single-user scenarios, the longest cache slot works fine for getting good input
+		// cache hit rates and it keeps the footprint of the cache small, which improves throughput.
+		// For multiple users, the "best" cache slot produces better input cache hit rates
+		// at the cost of worse performance when we miss the input cache.
+		if !c.multiUserCache {
+			slot, numPast, err = c.findLongestCacheSlot(prompt)
+		} else {
+			slot, numPast, err = c.findBestCacheSlot(prompt)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if slot.Key != key {
+		// the prompt of another key is saved before it is replaced
+		c.saveSlot(slot)
+		slot.saved = nil
+	}
+
+	if key != "" {
+		numPast = c.restoreSlot(slot, key, prompt, numPast)
 	}
 
 	slot.InUse = true
 	slot.lastUsed = time.Now()
+	slot.Key = key
 
 	if numPast == int32(len(prompt)) {
 		// Leave one input to sample so we can get a response
@@ -138,15 +177,119 @@ func (c *InputCache) LoadCacheSlot(prompt []input.Input) (*InputCacheSlot, []inp
 	return slot, prompt, nil
 }
 
-func (c *InputCache) findLongestCacheSlot(prompt []input.Input) (*InputCacheSlot, int32, error) {
+// findKeyedCacheSlot finds the free slot holding the prompt of a request
+// with the cache key key that has the longest prefix in common with prompt,
+// or returns nil if there is none.
+func (c *InputCache) findKeyedCacheSlot(prompt []input.Input, key string) (*InputCacheSlot, int32) {
 	longest := int32(-1)
 	var longestSlot *InputCacheSlot
 
 	for i, s := range c.slots {
+		if s.InUse || s.Key != key {
+			continue
+		}
+
+		count := countCommonPrefix(s.Inputs, prompt)
+		if count > longest {
+			longest = count
+			longestSlot = &c.slots[i]
+		}
+	}
+
+	return longestSlot, longest
+}
+
+var (
+	errCacheKeyNotFound = errors.New("no cached prompt has this key")
+	errNoUnpinnedSlots  = errors.New("pinning would leave no cache slot for other prompts")
+)
+
+// KeyedSlots lists the slots holding the prompts of requests with a cache
+// key.
+func (c *InputCache) KeyedSlots() []llm.CacheSlot {
+	var slots []llm.CacheSlot
+	for _, s := range c.slots {
+		if s.Key != "" {
+			slots = append(slots, llm.CacheSlot{Key: s.Key, Inputs: len(s.Inputs), Pinned: s.Pinned, InUse: s.InUse, LastUsed: s.lastUsed})
+		}
+	}
+
+	return slots
+}
+
+// Pin pins or unpins the slots holding the prompts of requests with the cache
+// key key. At least one slot is left unpinned for other prompts.
+func (c *InputCache) Pin(key string, pinned bool) error {
+	var keyed []*InputCacheSlot
+	var unpinned int
+	for i := range c.slots {
+		if c.slots[i].Key == key {
+			keyed = append(keyed, &c.slots[i])
+		} else if !c.slots[i].Pinned {
+			unpinned++
+		}
+	}
+
+	if len(keyed) == 0 {
+		return errCacheKeyNotFound
+	}
+
+	if pinned && unpinned == 0 {
+		return errNoUnpinnedSlots
+	}
+
+	for _, s := range keyed {
+		s.Pinned = pinned
+	}
+
+	return nil
+}
+
+// Evict removes the prompts of requests with the cache key key from the
+// cache, including any saved to disk. Slots that are in use keep their
+// contents but lose the key.
+func (c *InputCache) Evict(key string) error {
+	found, err := c.removeSnapshot(key)
+	if err != nil {
+		return err
+	}
+
+	for i := range c.slots {
+		s := &c.slots[i]
+		if s.Key != key {
+			continue
+		}
+
+		found = true
+		s.Key, s.Pinned, s.saved = "", false, nil
 		if s.InUse {
 			continue
 		}
 
+		if c.cache != nil {
+			if err := c.cache.Remove(s.Id, 0, math.MaxInt32); err != nil {
+				return err
+			}
+		}
+		s.Inputs = nil
+	}
+
+	if !found {
+		return errCacheKeyNotFound
+	}
+
+	return nil
+}
+
+func (c *InputCache) findLongestCacheSlot(prompt []input.Input) (*InputCacheSlot, int32, error) {
+	longest := int32(-1)
+	var longestSlot *InputCacheSlot
+
+	for i, s := range c.slots {
+		if s.InUse || s.Pinned {
+			continue
+		}
+
 		count := countCommonPrefix(s.Inputs, prompt)
 		if count > longest {
 			longest = count
@@ -175,17 +318,18 @@ func (c *InputCache) findBestCacheSlot(prompt []input.Input) (*InputCacheSlot, i
 			longestSlot = &c.slots[i]
 		}
 
-		if s.lastUsed.Compare(oldest) < 0 && !s.InUse {
+		// pinned slots may be forked but not evicted
+		if s.lastUsed.Compare(oldest) < 0 && !s.InUse && !s.Pinned {
 			oldest = s.lastUsed
 			oldestSlot = &c.slots[i]
 		}
 	}
 
-	if longest == int32(len(longestSlot.Inputs)) && !longestSlot.InUse {
+	if longest == int32(len(longestSlot.Inputs)) && !longestSlot.InUse && !longestSlot.Pinned {
 		return longestSlot, longest, nil
 	}
 
-	if oldestSlot.InUse {
+	if oldestSlot == nil || oldestSlot.InUse {
 		return nil, 0, errors.New("no available cache slots")
 	}
 
@@ -195,6 +339,10 @@ func (c *InputCache) findBestCacheSlot(prompt []input.Input) (*InputCacheSlot, i
 	}
 
 	if longest > 0 && longestSlot != oldestSlot {
+		if oldestSlot.Key != "" {
+			c.saveSlot(oldestSlot)
+		}
+
 		slog.Debug("forking cache slot", "src", longestSlot.Id, "dst", oldestSlot.Id, "inputs", longest, "total",
 			len(longestSlot.Inputs))
 		oldestSlot.Inputs = make([]input.Input, longest)
@@ -273,3 +421,26 @@ func (c *InputCache) ShiftCacheSlot(slot *InputCacheSlot, numKeep int32) error {
 
 	return nil
 }
+
+// Rollback removes the entries after slot.Inputs from the KV cache, such as
+// those of a batch that failed to compute. If the cache can't remove only
+// those, the slot is emptied and its inputs are returned to be processed
+// again.
+func (c *InputCache) Rollback(slot *InputCacheSlot) ([]input.Input, error) {
+	if c.cache == nil {
+		return nil, nil
+	}
+
+	if err := c.cache.Remove(slot.Id, int32(len(slot.Inputs)), math.MaxInt32); err == nil {
+		return nil, nil
+	}
+
+	// Some models don't support partial erasure
+	if err := c.cache.Remove(slot.Id, 0, math.MaxInt32); err != nil {
+		return nil, err
+	}
+
+	inputs := slot.Inputs
+	slot.Inputs = []input.Input{}
+	return inputs, nil
+}
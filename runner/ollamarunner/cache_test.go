@@ -1,10 +1,14 @@
 package ollamarunner
 
 import (
+	"errors"
 	"image"
 	"testing"
 	"time"
 
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/llm"
 	"github.com/ollama/ollama/model/input"
 )
 
@@ -395,7 +399,7 @@ func TestLoadCacheSlot(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			slot, remainingPrompt, err := tt.cache.LoadCacheSlot(tt.prompt)
+			slot, remainingPrompt, err := tt.cache.LoadCacheSlot(tt.prompt, "")
 
 			// Check error state
 			if (err != nil) != tt.wantErr {
@@ -425,3 +429,73 @@ func TestLoadCacheSlot(t *testing.T) {
 		})
 	}
 }
+
+func TestCacheKeys(t *testing.T) {
+	cache := InputCache{
+		multiUserCache: true,
+		slots: []InputCacheSlot{
+			{Id: 0, Inputs: []input.Input{{Token: 1}, {Token: 2}}, lastUsed: time.Now().Add(-3 * time.Second)},
+			{Id: 1, Inputs: []input.Input{{Token: 1}, {Token: 2}, {Token: 3}}, lastUsed: time.Now().Add(-2 * time.Second)},
+		},
+	}
+
+	// the keyed slot is preferred over a longer prefix
+	slot, _, err := cache.LoadCacheSlot([]input.Input{{Token: 1}, {Token: 2}, {Token: 3}, {Token: 4}}, "system")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slot.Id != 1 || slot.Key != "system" {
+		t.Fatalf("expected slot 1 with the key, got %d %q", slot.Id, slot.Key)
+	}
+	slot.Inputs = []input.Input{{Token: 1}, {Token: 2}, {Token: 3}, {Token: 4}}
+	slot.InUse = false
+
+	slot, _, err = cache.LoadCacheSlot([]input.Input{{Token: 1}, {Token: 2}, {Token: 5}}, "system")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slot.Id != 1 {
+		t.Fatalf("expected the keyed slot 1, got %d", slot.Id)
+	}
+	slot.InUse = false
+
+	if err := cache.Pin("missing", true); !errors.Is(err, errCacheKeyNotFound) {
+		t.Fatalf("expected errCacheKeyNotFound, got %v", err)
+	}
+
+	if err := cache.Pin("system", true); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(cache.KeyedSlots(), []llm.CacheSlot{
+		{Key: "system", Inputs: 2, Pinned: true, LastUsed: cache.slots[1].lastUsed},
+	}); diff != "" {
+		t.Errorf("slots mismatch (-got +want):\n%s", diff)
+	}
+
+	// pinned slots are not loaded for other prompts
+	slot, _, err = cache.LoadCacheSlot([]input.Input{{Token: 1}, {Token: 2}, {Token: 5}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slot.Id != 0 {
+		t.Fatalf("expected the unpinned slot 0, got %d", slot.Id)
+	}
+
+	cache.slots[0].Key = "other"
+	if err := cache.Pin("other", true); !errors.Is(err, errNoUnpinnedSlots) {
+		t.Fatalf("expected errNoUnpinnedSlots, got %v", err)
+	}
+	slot.InUse = false
+
+	if err := cache.Evict("system"); err != nil {
+		t.Fatal(err)
+	}
+	if s := cache.slots[1]; s.Key != "" || s.Pinned || len(s.Inputs) != 0 {
+		t.Errorf("expected the slot to be evicted, got %+v", s)
+	}
+
+	if err := cache.Evict("system"); !errors.Is(err, errCacheKeyNotFound) {
+		t.Fatalf("expected errCacheKeyNotFound, got %v", err)
+	}
+}
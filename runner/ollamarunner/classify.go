@@ -0,0 +1,98 @@
+package ollamarunner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/model"
+)
+
+func (s *Server) classify(w http.ResponseWriter, r *http.Request) {
+	var req llm.ClassifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("bad request: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	s.ready.Wait()
+
+	c, ok := s.model.(model.Classifier)
+	if !ok || c.Labels() == nil {
+		http.Error(w, "this model does not support classification", http.StatusNotImplemented)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	seq, err := s.NewSequence(req.Content, nil, NewSequenceParams{embedding: true})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create new sequence: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Ensure there is a place to put the sequence, released when removed from s.seqs
+	if err := s.seqsSem.Acquire(r.Context(), 1); err != nil {
+		if errors.Is(err, context.Canceled) {
+			slog.Info("aborting classify request due to client closing the connection")
+		} else {
+			slog.Error("Failed to acquire semaphore", "error", err)
+		}
+		return
+	}
+
+	s.mu.Lock()
+	found := false
+	for i, sq := range s.seqs {
+		if sq == nil {
+			seq.cache, seq.inputs, err = s.cache.LoadCacheSlot(seq.inputs, "")
+			if err != nil {
+				s.mu.Unlock()
+				s.seqsSem.Release(1)
+				http.Error(w, fmt.Sprintf("Failed to load cache: %v", err), http.StatusInternalServerError)
+				return
+			}
+
+			s.seqs[i] = seq
+			s.cond.Signal()
+			found = true
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	if !found {
+		s.seqsSem.Release(1)
+		http.Error(w, "could not find an available sequence", http.StatusInternalServerError)
+		return
+	}
+
+	var logits []float32
+	select {
+	case <-r.Context().Done():
+		close(seq.quit)
+		return
+	case logits = <-seq.embedding:
+	}
+
+	if logits == nil && seq.err != nil {
+		http.Error(w, seq.err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(logits) != len(c.Labels()) {
+		http.Error(w, fmt.Sprintf("expected %d scores from the classification head, got %d", len(c.Labels()), len(logits)), http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(&llm.ClassifyResponse{
+		Labels: c.Labels(),
+		Logits: logits,
+	}); err != nil {
+		http.Error(w, fmt.Sprintf("failed to encode response: %v", err), http.StatusInternalServerError)
+	}
+}
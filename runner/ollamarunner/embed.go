@@ -0,0 +1,150 @@
+package ollamarunner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/model"
+)
+
+func (s *Server) embeddings(w http.ResponseWriter, r *http.Request) {
+	var req llm.EmbeddingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("bad request: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	s.ready.Wait()
+
+	if _, ok := s.model.(model.Embedder); !ok {
+		http.Error(w, "this model does not support embeddings", http.StatusNotImplemented)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if req.Contents != nil {
+		s.embeddingsBatch(w, r, req.Contents)
+		return
+	}
+
+	slog.DebugContext(r.Context(), "embedding request", "content", req.Content)
+
+	seq, err := s.addEmbeddingSequence(r.Context(), req.Content)
+	if errors.Is(err, context.Canceled) {
+		slog.InfoContext(r.Context(), "aborting embeddings request due to client closing the connection")
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var embedding []float32
+	select {
+	case <-r.Context().Done():
+		close(seq.quit)
+		return
+	case embedding = <-seq.embedding:
+	}
+
+	if embedding == nil {
+		msg := "failed to embed input"
+		if seq.err != nil {
+			msg = seq.err.Error()
+		}
+		http.Error(w, msg, http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(&llm.EmbeddingResponse{
+		Embedding: embedding,
+	}); err != nil {
+		http.Error(w, fmt.Sprintf("failed to encode response: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// embeddingsBatch embeds several inputs, reporting errors for each of them.
+// All inputs are queued before waiting for any embedding so that they share
+// batches as far as there are free sequences.
+func (s *Server) embeddingsBatch(w http.ResponseWriter, r *http.Request, contents []string) {
+	slog.DebugContext(r.Context(), "embedding request", "inputs", len(contents))
+
+	seqs := make([]*Sequence, len(contents))
+	results := make([]llm.EmbeddingResult, len(contents))
+	for i, content := range contents {
+		seq, err := s.addEmbeddingSequence(r.Context(), content)
+		if errors.Is(err, context.Canceled) {
+			slog.InfoContext(r.Context(), "aborting embeddings request due to client closing the connection")
+			return
+		} else if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+
+		seqs[i] = seq
+	}
+
+	for i, seq := range seqs {
+		if seq == nil {
+			continue
+		}
+
+		// the channel is closed without an embedding if the sequence
+		// was removed before its prompt was processed
+		if embedding := <-seq.embedding; embedding != nil {
+			results[i].Embedding = embedding
+		} else if seq.err != nil {
+			results[i].Error = seq.err.Error()
+		} else {
+			results[i].Error = "failed to embed input"
+		}
+	}
+
+	if err := json.NewEncoder(w).Encode(&llm.EmbeddingResponse{
+		Embeddings: results,
+	}); err != nil {
+		http.Error(w, fmt.Sprintf("failed to encode response: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// addEmbeddingSequence adds a sequence that embeds content once a sequence is
+// free and returns it. The embedding is sent on its embedding channel.
+func (s *Server) addEmbeddingSequence(ctx context.Context, content string) (*Sequence, error) {
+	seq, err := s.NewSequence(content, nil, NewSequenceParams{embedding: true, logCtx: ctx})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create new sequence: %v", err)
+	}
+
+	// Ensure there is a place to put the sequence, released when removed from s.seqs
+	if err := s.seqsSem.Acquire(ctx, 1); err != nil {
+		if !errors.Is(err, context.Canceled) {
+			slog.ErrorContext(ctx, "Failed to acquire semaphore", "error", err)
+		}
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, sq := range s.seqs {
+		if sq == nil {
+			seq.cache, seq.inputs, err = s.cache.LoadCacheSlot(seq.inputs, "")
+			if err != nil {
+				s.seqsSem.Release(1)
+				return nil, fmt.Errorf("Failed to load cache: %v", err)
+			}
+
+			s.seqs[i] = seq
+			s.cond.Signal()
+			return seq, nil
+		}
+	}
+
+	s.seqsSem.Release(1)
+	return nil, errors.New("could not find an available sequence")
+}
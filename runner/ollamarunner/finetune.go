@@ -0,0 +1,305 @@
+package ollamarunner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/ollama/ollama/kvcache"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/ml/nn"
+	"github.com/ollama/ollama/model"
+	"github.com/ollama/ollama/model/input"
+)
+
+// example is a tokenized training example
+type example struct {
+	inputs []int32
+
+	// targets are the tokens the model should predict following each input,
+	// -1 if the prediction is not part of the loss or targetEOS
+	targets []int32
+}
+
+// targetEOS is the target following the end of a completion, which becomes
+// the end of sequence token of the model once the size of the vocabulary is
+// known from the logits
+const targetEOS = -2
+
+// tokenize converts training examples to inputs and targets of at most
+// numCtx tokens
+func tokenize(tp model.TextProcessor, examples []llm.FinetuneExample, numCtx int) ([]example, error) {
+	var tokenized []example
+	for i, e := range examples {
+		prompt, err := tp.Encode(e.Prompt, true)
+		if err != nil {
+			return nil, fmt.Errorf("example %d: %w", i, err)
+		}
+
+		completion, err := tp.Encode(e.Completion, false)
+		if err != nil {
+			return nil, fmt.Errorf("example %d: %w", i, err)
+		}
+
+		if len(completion) == 0 {
+			return nil, fmt.Errorf("example %d: completion is empty", i)
+		}
+
+		tokens := append(append(prompt, completion...), targetEOS)
+		tokens = tokens[:min(len(tokens), numCtx+1)]
+		if len(prompt) >= len(tokens) {
+			return nil, fmt.Errorf("example %d: prompt does not fit in the context", i)
+		}
+
+		targets := slices.Clone(tokens[1:])
+		for j := range max(len(prompt)-1, 0) {
+			targets[j] = -1
+		}
+
+		tokenized = append(tokenized, example{inputs: tokens[:len(tokens)-1], targets: targets})
+	}
+
+	return tokenized, nil
+}
+
+// attachLoRA attaches new adapters to the linear layers of m whose names end
+// with one of targets. A is initialized randomly and B with zeros so that the
+// adapters initially have no effect.
+func attachLoRA(ctx ml.Context, m model.Model, targets []string, rank int, alpha float32, rng *rand.Rand) (map[string]*nn.LoRA, error) {
+	loras := make(map[string]*nn.LoRA)
+	for name, linear := range model.Linears(m) {
+		if !slices.ContainsFunc(targets, func(target string) bool { return strings.HasSuffix(name, "."+target) || name == target }) {
+			continue
+		}
+
+		if linear.LoRA != nil {
+			return nil, errors.New("model already has an adapter")
+		}
+
+		in, out := linear.Weight.Dim(0), linear.Weight.Dim(1)
+
+		// the same bound as the default initialization of torch.nn.Linear
+		bound := 1 / math.Sqrt(float64(in))
+		a := make([]float32, in*rank)
+		for i := range a {
+			a[i] = float32((rng.Float64()*2 - 1) * bound)
+		}
+
+		var n int
+		lctx := ctx.Output()
+		if _, err := fmt.Sscanf(name, "blk.%d.", &n); err == nil {
+			lctx = ctx.Layer(n)
+		}
+
+		A, err := lctx.FromFloatSlice(a, in, rank)
+		if err != nil {
+			return nil, err
+		}
+
+		loras[name] = &nn.LoRA{
+			A:     A,
+			B:     lctx.Zeros(ml.DTypeF32, rank, out),
+			Scale: alpha / float32(rank),
+		}
+	}
+
+	if len(loras) == 0 {
+		return nil, fmt.Errorf("model has no layers matching %v", targets)
+	}
+
+	for name, lora := range loras {
+		model.Linears(m)[name].LoRA = lora
+	}
+
+	return loras, nil
+}
+
+func (s *Server) finetune(w http.ResponseWriter, r *http.Request) {
+	var req llm.FinetuneRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("bad request: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	s.ready.Wait()
+
+	trainer, ok := s.model.Backend().(ml.Trainer)
+	if !ok {
+		http.Error(w, "this backend does not support training", http.StatusNotImplemented)
+		return
+	}
+
+	cacheSetter, ok := s.model.(interface{ SetCache(kvcache.Cache) })
+	if !ok || len(req.Examples) == 0 || req.Epochs < 1 || req.Rank < 1 {
+		http.Error(w, "invalid finetune request", http.StatusBadRequest)
+		return
+	}
+
+	tp, ok := s.model.(model.TextProcessor)
+	if !ok {
+		http.Error(w, "this model does not support training", http.StatusNotImplemented)
+		return
+	}
+
+	// wait for in flight sequences to finish and keep new ones from starting
+	if err := s.seqsSem.Acquire(r.Context(), int64(s.parallel)); err != nil {
+		slog.Info("aborting finetune request", "error", err)
+		return
+	}
+	defer s.seqsSem.Release(int64(s.parallel))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rng := rand.New(rand.NewPCG(uint64(req.Seed), 0))
+
+	// the adapters and optimizer state live until training ends
+	ctx := s.model.Backend().NewContext()
+	defer ctx.Close()
+
+	loras, err := attachLoRA(ctx, s.model, req.Targets, req.Rank, req.Alpha, rng)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer func() {
+		for name := range loras {
+			model.Linears(s.model)[name].LoRA = nil
+		}
+	}()
+
+	cache := s.model.Config().Cache
+	cacheSetter.SetCache(kvcache.NewTrainingCache())
+	defer cacheSetter.SetCache(cache)
+
+	params := make([]ml.Tensor, 0, 2*len(loras))
+	for _, lora := range loras {
+		params = append(params, lora.A, lora.B)
+	}
+
+	opt := trainer.NewAdamW(params, ml.DefaultAdamWOptions(req.LearningRate))
+	defer opt.Close()
+
+	numCtx := req.NumCtx
+	if numCtx <= 0 {
+		numCtx = s.batchSize
+	}
+
+	examples, err := tokenize(tp, req.Examples, numCtx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+
+	fail := func(err error) {
+		slog.Error("finetune failed", "error", err)
+		enc.Encode(llm.FinetuneResponse{Error: err.Error()})
+	}
+
+	step := func(e example) (float32, error) {
+		ctx := trainer.NewTrainingContext()
+		defer ctx.Close()
+
+		positions := make([]int32, len(e.inputs))
+		for i := range positions {
+			positions[i] = int32(i)
+		}
+
+		batch := input.Batch{
+			Positions: positions,
+			Sequences: make([]int, len(e.inputs)),
+			Outputs:   positions,
+		}
+
+		var err error
+		batch.Inputs, err = ctx.Input().FromIntSlice(e.inputs, len(e.inputs))
+		if err != nil {
+			return 0, err
+		}
+
+		if err := s.model.Config().Cache.StartForward(ctx, batch); err != nil {
+			return 0, err
+		}
+
+		logits, err := s.model.Forward(ctx, batch)
+		if err != nil {
+			return 0, err
+		}
+
+		eos := int32(-1)
+		if tokens := s.eosTokens(logits.Dim(0)); len(tokens) > 0 {
+			eos = tokens[0]
+		}
+
+		targets := slices.Clone(e.targets)
+		for i, target := range targets {
+			if target == targetEOS {
+				targets[i] = eos
+			}
+		}
+
+		loss, err := ctx.CrossEntropy(logits, targets)
+		if err != nil {
+			return 0, err
+		}
+
+		return opt.Step(ctx, loss), nil
+	}
+
+	for epoch := range req.Epochs {
+		var total float32
+		for i, n := range rng.Perm(len(examples)) {
+			if r.Context().Err() != nil {
+				slog.Info("aborting finetune request due to client closing the connection")
+				return
+			}
+
+			loss, err := step(examples[n])
+			if err != nil {
+				fail(err)
+				return
+			}
+
+			total += loss
+			if err := enc.Encode(llm.FinetuneResponse{
+				Epoch: epoch + 1,
+				Step:  epoch*len(examples) + i + 1,
+				Steps: req.Epochs * len(examples),
+				Loss:  total / float32(i+1),
+			}); err != nil {
+				slog.Error("failed to encode response", "error", err)
+				return
+			}
+
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+
+	f, err := os.Create(req.Adapter)
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer f.Close()
+
+	if err := model.WriteAdapter(f, s.model.Backend().Config().Architecture(), req.Alpha, loras); err != nil {
+		fail(err)
+		return
+	}
+
+	enc.Encode(llm.FinetuneResponse{Done: true, Steps: req.Epochs * len(examples)})
+}
@@ -0,0 +1,90 @@
+package ollamarunner
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/model"
+)
+
+// wordProcessor encodes each word as its length
+type wordProcessor struct{}
+
+func (wordProcessor) Encode(s string, addSpecial bool) ([]int32, error) {
+	var tokens []int32
+	if addSpecial {
+		tokens = append(tokens, 0)
+	}
+
+	for _, w := range strings.Fields(s) {
+		tokens = append(tokens, int32(len(w)))
+	}
+
+	return tokens, nil
+}
+
+func (wordProcessor) Decode([]int32) (string, error) { return "", nil }
+
+func (wordProcessor) Is(int32, model.Special) bool { return false }
+
+func TestTokenize(t *testing.T) {
+	cases := []struct {
+		name     string
+		example  llm.FinetuneExample
+		numCtx   int
+		expected example
+		err      bool
+	}{
+		{
+			name:    "prompt and completion",
+			example: llm.FinetuneExample{Prompt: "a bb", Completion: "ccc dddd"},
+			numCtx:  16,
+			expected: example{
+				inputs:  []int32{0, 1, 2, 3, 4},
+				targets: []int32{-1, -1, 3, 4, targetEOS},
+			},
+		},
+		{
+			name:    "truncated",
+			example: llm.FinetuneExample{Prompt: "a", Completion: "bb ccc dddd"},
+			numCtx:  3,
+			expected: example{
+				inputs:  []int32{0, 1, 2},
+				targets: []int32{-1, 2, 3},
+			},
+		},
+		{
+			name:    "prompt too long",
+			example: llm.FinetuneExample{Prompt: "a bb ccc", Completion: "dddd"},
+			numCtx:  3,
+			err:     true,
+		},
+		{
+			name:    "empty completion",
+			example: llm.FinetuneExample{Prompt: "a"},
+			numCtx:  16,
+			err:     true,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			examples, err := tokenize(wordProcessor{}, []llm.FinetuneExample{tt.example}, tt.numCtx)
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.expected, examples[0], cmp.AllowUnexported(example{})); diff != "" {
+				t.Errorf("tokenize() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -2,29 +2,36 @@ package ollamarunner
 
 import (
 	"context"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"hash/maphash"
-	"log"
 	"log/slog"
+	"math"
 	"net"
 	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unicode/utf8"
 
 	"golang.org/x/sync/semaphore"
 
 	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/crash"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/kvcache"
 	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/logutil"
 	"github.com/ollama/ollama/ml"
 	"github.com/ollama/ollama/model"
 	"github.com/ollama/ollama/model/input"
@@ -59,22 +66,32 @@ type Sequence struct {
 	cache *InputCacheSlot
 
 	// channel to send responses over
-	responses chan string
+	responses chan llm.CompletionResponse
 
 	// channel to stop decoding (such as if the remote connection is closed)
 	quit chan bool
 
+	// true while decoding waits for the client to read the buffered responses
+	paused bool
+
 	// number of tokens to predict
 	numPredict int
 
 	// sampler with transforms to run on generated logits
 	sampler sample.Sampler
 
+	// schema constrains the output to JSON matching the schema in format,
+	// and ends the sequence once the JSON is complete
+	schema *schemaConstraint
+
 	// channel to send back the embedding if embedding only
 	embedding chan []float32
 
-	// stop sequences
-	stop []string
+	// stops finds the stop sequences in the output
+	stops *common.StopMatcher
+
+	// true if end of sequence tokens should never be sampled
+	ignoreEOS bool
 
 	// number of inputs to keep at the beginning when shifting context window
 	numKeep int32
@@ -82,21 +99,64 @@ type Sequence struct {
 	// true if an embedding are to be returned instead of text generation
 	embeddingOnly bool
 
+	// true if the probability of ending the sequence should be reported
+	reportEOS bool
+
+	// probability of ending the sequence instead of generating the last token
+	eosProbability float32
+
+	// true if the log probabilities of the generated tokens should be
+	// reported, with the topLogprobs most likely tokens at each position
+	reportLogprobs bool
+	topLogprobs    int
+
+	// log probabilities of the pending responses
+	pendingLogprobs []api.Logprob
+
+	// when scoring instead of generating, targets holds the token following
+	// each input whose log probability is wanted, or -1
+	targets []int32
+
+	// outputs of the current batch to score
+	pendingScores []pendingScore
+
+	// log probabilities of the scored tokens and whether each was the most
+	// likely token
+	logprobs []float64
+	greedy   bool
+
 	doneReason string
 
+	// err is why the sequence was removed with [api.DoneReasonError]
+	err error
+
+	// logCtx is the context of the request, which carries its ID for logging
+	logCtx context.Context
+
 	// Metrics
 	startProcessingTime time.Time
 	startGenerationTime time.Time
 	numPredicted        int
 	numPromptInputs     int
+
+	// numTruncated is the number of inputs left out of a prompt that didn't
+	// fit in the context window
+	numTruncated int
 }
 
 type NewSequenceParams struct {
-	numPredict int
-	stop       []string
-	numKeep    int32
-	sampler    sample.Sampler
-	embedding  bool
+	numPredict  int
+	stop        []string
+	stopRegex   []string
+	ignoreEOS   bool
+	numKeep     int32
+	sampler     sample.Sampler
+	schema      *schemaConstraint
+	embedding   bool
+	reportEOS   bool
+	logprobs    bool
+	topLogprobs int
+	logCtx      context.Context
 }
 
 func (s *Server) NewSequence(prompt string, images []llm.ImageData, params NewSequenceParams) (*Sequence, error) {
@@ -121,13 +181,26 @@ func (s *Server) NewSequence(prompt string, images []llm.ImageData, params NewSe
 	// Ensure that at least 1 input can be discarded during shift
 	params.numKeep = min(params.numKeep, s.cache.numCtx-1)
 
+	// The decoder start token of encoder-decoder models carries the output
+	// of the encoder, so it can't be discarded
+	if _, ok := s.model.(model.EncoderDecoder); ok {
+		params.numKeep = max(params.numKeep, 1)
+	}
+
+	var numTruncated int
 	if int32(len(inputs)) > s.cache.numCtx {
 		discard := int32(len(inputs)) - s.cache.numCtx
 		newInputs := inputs[:params.numKeep]
 		newInputs = append(newInputs, inputs[params.numKeep+discard:]...)
 
-		slog.Warn("truncating input prompt", "limit", s.cache.numCtx, "prompt", len(inputs), "keep", params.numKeep, "new", len(newInputs))
+		slog.WarnContext(params.logCtx, "truncating input prompt", "limit", s.cache.numCtx, "prompt", len(inputs), "keep", params.numKeep, "new", len(newInputs))
 		inputs = newInputs
+		numTruncated = int(discard)
+	}
+
+	stops, err := common.NewStopMatcher(params.stop, params.stopRegex)
+	if err != nil {
+		return nil, err
 	}
 
 	// TODO(jessegross): Ingest cached history for grammar
@@ -136,16 +209,23 @@ func (s *Server) NewSequence(prompt string, images []llm.ImageData, params NewSe
 		ctxs:                ctxs,
 		inputs:              inputs,
 		numPromptInputs:     len(inputs),
+		numTruncated:        numTruncated,
 		startProcessingTime: startTime,
 		numPredict:          params.numPredict,
 		pendingResponses:    make([]string, 0),
-		responses:           make(chan string, 100),
+		responses:           make(chan llm.CompletionResponse, s.streamBuffer),
 		quit:                make(chan bool, 1),
 		embedding:           make(chan []float32, 1),
 		sampler:             params.sampler,
+		schema:              params.schema,
 		embeddingOnly:       params.embedding,
-		stop:                params.stop,
+		reportEOS:           params.reportEOS,
+		reportLogprobs:      params.logprobs,
+		topLogprobs:         params.topLogprobs,
+		stops:               stops,
+		ignoreEOS:           params.ignoreEOS,
 		numKeep:             params.numKeep,
+		logCtx:              params.logCtx,
 	}, nil
 }
 
@@ -226,6 +306,32 @@ func (s *Server) inputs(prompt string, images []llm.ImageData) ([]input.Input, *
 		}
 	}
 
+	// Encoder-decoder models run the prompt through the encoder and generate
+	// from the decoder start token, which carries the output of the encoder
+	if encoderDecoder, ok := s.model.(model.EncoderDecoder); ok && len(inputs) > 0 {
+		tokens := make([]int32, len(inputs))
+		for i, inp := range inputs {
+			tokens[i] = inp.Token
+		}
+
+		if int32(len(tokens)) > s.cache.numCtx {
+			slog.Warn("truncating input prompt for encoder", "limit", s.cache.numCtx, "prompt", len(tokens))
+			tokens = tokens[:s.cache.numCtx]
+		}
+
+		ctx := s.model.Backend().NewContext()
+		contexts.list = append(contexts.list, ctx)
+		encoded, err := encoderDecoder.EncodeTokens(ctx, tokens)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		s.multimodalHash.Reset()
+		_ = binary.Write(&s.multimodalHash, binary.NativeEndian, tokens)
+
+		inputs = []input.Input{{Token: encoderDecoder.DecoderStart(), Multimodal: encoded, MultimodalHash: s.multimodalHash.Sum64()}}
+	}
+
 	return inputs, &contexts, nil
 }
 
@@ -243,6 +349,13 @@ type Server struct {
 	// current progress on loading the model
 	progress float32
 
+	// loadErr is the error that loading the remaining weights in the
+	// background failed with, which fails the sequences that need them
+	loadErr atomic.Pointer[error]
+
+	// results of the numerical self-test of the devices of the backend
+	selfTest []ml.SelfTestResult
+
 	// number of simultaneous requests to handle
 	parallel int
 
@@ -250,6 +363,17 @@ type Server struct {
 	// TODO (jmorganca): make this n_batch
 	batchSize int
 
+	// maximum number of inputs in each of the micro-batches a batch is
+	// split into, 0 to compute batches at once
+	ubatchSize int
+
+	// number of responses buffered for each sequence before it pauses
+	streamBuffer int
+
+	// number of sequences currently paused and number of times any paused
+	pausedSeqs atomic.Int32
+	pauses     atomic.Uint64
+
 	// protects access to everything below this line
 	// this is context state needed for decoding
 	mu sync.Mutex
@@ -276,6 +400,42 @@ type Server struct {
 	// TODO: this is temporary until Ollama sampling supports
 	// constrained generation
 	vocab *sample.Vocab
+
+	// end of sequence tokens, found the first time they are needed
+	eosOnce sync.Once
+	eos     []int32
+
+	// text of the tokens checked by schema constraints
+	piecesMu sync.Mutex
+	pieces   map[int32]string
+}
+
+// eosTokens returns the tokens that end a sequence in a vocabulary of
+// vocabSize tokens
+func (s *Server) eosTokens(vocabSize int) []int32 {
+	s.eosOnce.Do(func() {
+		for id := range int32(vocabSize) {
+			if s.model.(model.TextProcessor).Is(id, model.SpecialEOS) {
+				s.eos = append(s.eos, id)
+			}
+		}
+	})
+
+	return s.eos
+}
+
+// logprob returns the log probability of token, whose text is piece, under
+// logits with the top most likely tokens at its position
+func (s *Server) logprob(logits []float32, token int32, piece string, top int) api.Logprob {
+	logprob, _ := common.LogProb(logits, token)
+	lp := api.Logprob{TokenLogprob: api.TokenLogprob{Token: piece, Logprob: logprob}}
+	for _, t := range common.TopLogProbs(logits, top) {
+		// tokens that can't be decoded on their own are listed empty
+		text, _ := s.model.(model.TextProcessor).Decode([]int32{t.Token})
+		lp.TopLogprobs = append(lp.TopLogprobs, api.TokenLogprob{Token: text, Logprob: t.LogProb})
+	}
+
+	return lp
 }
 
 func (s *Server) allNil() bool {
@@ -289,8 +449,12 @@ func (s *Server) allNil() bool {
 
 func flushPending(seq *Sequence) bool {
 	joined := strings.Join(seq.pendingResponses, "")
+	tokens := len(seq.pendingResponses)
 	seq.pendingResponses = []string{}
 
+	logprobs := seq.pendingLogprobs
+	seq.pendingLogprobs = nil
+
 	// Check if there are any partial UTF-8 characters remaining.
 	// We already check and queue as we are generating but some may
 	// still make it here:
@@ -306,15 +470,46 @@ func flushPending(seq *Sequence) bool {
 	}
 
 	select {
-	case seq.responses <- joined:
+	case seq.responses <- llm.CompletionResponse{Content: joined, Tokens: tokens, EOSProbability: seq.eosProbability, Logprobs: logprobs}:
 		return true
 	case <-seq.quit:
 		return false
 	}
 }
 
+// pause reports whether decoding seq should wait because its buffer of
+// responses is full, which happens when the client reads them slower than
+// they are generated. Skipping it leaves its room in batches to the other
+// sequences instead of stalling all of them until the client catches up.
+func (s *Server) pause(seq *Sequence) bool {
+	full := len(seq.responses) == cap(seq.responses)
+	if full != seq.paused {
+		seq.paused = full
+		if full {
+			s.pausedSeqs.Add(1)
+			s.pauses.Add(1)
+			slog.DebugContext(seq.logCtx, "pausing sequence until the client catches up", "buffered", len(seq.responses))
+		} else {
+			s.pausedSeqs.Add(-1)
+		}
+	}
+
+	return full
+}
+
+// resume wakes up processing of batches, which waits while all sequences are
+// paused, after a client has read a response or gone away.
+func (s *Server) resume() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cond.Signal()
+}
+
 func (s *Server) removeSequence(seqIndex int, reason string) {
 	seq := s.seqs[seqIndex]
+	if seq.paused {
+		s.pausedSeqs.Add(-1)
+	}
 
 	flushPending(seq)
 	seq.doneReason = reason
@@ -326,6 +521,16 @@ func (s *Server) removeSequence(seqIndex int, reason string) {
 }
 
 func (s *Server) run(ctx context.Context) {
+	defer func() {
+		if v := recover(); v != nil {
+			// the state of the model is unknown after a panic, so the runner
+			// exits and the server fails the requests in progress
+			crash.New("runner", v).Log()
+			fmt.Fprintf(os.Stderr, "panic: %v\n", v)
+			os.Exit(2)
+		}
+	}()
+
 	s.ready.Wait()
 
 	for {
@@ -344,27 +549,61 @@ func (s *Server) run(ctx context.Context) {
 func (s *Server) processBatch() error {
 	s.mu.Lock()
 	for s.allNil() {
+		// prompts are saved while no batches wait for the cache to be read
+		s.cache.SaveSlots()
 		s.cond.Wait() // Wait until an item is added
 	}
 	defer s.mu.Unlock()
 
+	if err := s.loadErr.Load(); err != nil {
+		for i, seq := range s.seqs {
+			if seq != nil {
+				seq.err = *err
+				s.removeSequence(i, api.DoneReasonError)
+			}
+		}
+		return nil
+	}
+
 	var batchInputs []int32
 	var batch input.Batch
 
+	// canSplit reports for each input whether a micro-batch may start with
+	// it, which it can't if it has to be in the same batch as the previous
+	var canSplit []bool
+	var sameBatch int
+
 	for i, seq := range s.seqs {
 		if seq == nil {
 			continue
 		}
 
+		if s.pause(seq) {
+			select {
+			case <-seq.quit:
+				s.removeSequence(i, api.DoneReasonCanceled)
+			default:
+			}
+			continue
+		}
+
 		// if past the num predict limit
 		if seq.numPredict > 0 && seq.numPredicted >= seq.numPredict {
-			s.removeSequence(i, "limit")
+			s.removeSequence(i, api.DoneReasonLength)
 			continue
 		}
 
 		if !s.cache.enabled {
+			if seq.targets != nil {
+				seq.targets = append(slices.Repeat([]int32{-1}, len(seq.cache.Inputs)), seq.targets...)
+			}
 			seq.inputs = append(seq.cache.Inputs, seq.inputs...)
 			seq.cache.Inputs = []input.Input{}
+
+			// Without a cache, each batch has to hold all of the inputs of the sequence
+			if len(seq.inputs) > 0 {
+				seq.inputs[0].SameBatch = len(seq.inputs) - 1
+			}
 		}
 
 		batchSize := s.batchSize
@@ -396,6 +635,11 @@ func (s *Server) processBatch() error {
 				}
 			}
 
+			canSplit = append(canSplit, len(batchInputs) > sameBatch)
+			if inp.SameBatch > 0 {
+				sameBatch = max(sameBatch, len(batchInputs)+inp.SameBatch)
+			}
+
 			batchInputs = append(batchInputs, inp.Token)
 			if inp.Multimodal != nil {
 				batch.Multimodal = append(batch.Multimodal, input.MultimodalIndex{Index: len(batchInputs) - 1, Multimodal: inp.Multimodal})
@@ -405,9 +649,13 @@ func (s *Server) processBatch() error {
 			batch.Sequences = append(batch.Sequences, seq.cache.Id)
 
 			seq.iBatch = len(batch.Outputs)
-			if j+1 == len(seq.inputs) {
+			scored := seq.targets != nil && seq.targets[j] >= 0
+			if j+1 == len(seq.inputs) || scored {
 				batch.Outputs = append(batch.Outputs, int32(len(batchInputs)-1))
 			}
+			if scored {
+				seq.pendingScores = append(seq.pendingScores, pendingScore{output: len(batch.Outputs) - 1, target: seq.targets[j]})
+			}
 			seq.pendingInputs = append(seq.pendingInputs, inp)
 		}
 
@@ -415,30 +663,49 @@ func (s *Server) processBatch() error {
 	}
 
 	if len(batchInputs) == 0 {
+		if s.pausedSeqs.Load() > 0 {
+			s.cond.Wait()
+		}
 		return nil
 	}
 
-	ctx := s.model.Backend().NewContext()
-	defer ctx.Close()
-
-	modelOutput, err := model.Forward(ctx, s.model, batchInputs, batch)
-	if err != nil {
+	logits, err := s.forward(batchInputs, batch, canSplit)
+	if errors.Is(err, ml.ErrNoMem) || errors.Is(err, kvcache.ErrKvCacheFull) {
+		return s.recoverNoMem(err)
+	} else if err != nil {
 		return fmt.Errorf("failed to decode batch: %w", err)
 	}
 
-	logits := modelOutput.Floats()
-
 	for i, seq := range s.seqs {
-		if seq == nil {
+		if seq == nil || seq.paused {
 			continue
 		}
 
 		// After calling Forward, pending inputs are now in the cache
 		if len(seq.pendingInputs) > 0 {
+			if seq.targets != nil {
+				seq.targets = seq.targets[len(seq.pendingInputs):]
+			}
 			seq.cache.Inputs = append(seq.cache.Inputs, seq.pendingInputs...)
 			seq.pendingInputs = []input.Input{}
 		}
 
+		// score instead of sampling when scoring
+		if seq.targets != nil {
+			vocabSize := len(logits) / len(batch.Outputs)
+			for _, p := range seq.pendingScores {
+				logprob, greedy := common.LogProb(logits[p.output*vocabSize:(p.output+1)*vocabSize], p.target)
+				seq.logprobs = append(seq.logprobs, logprob)
+				seq.greedy = seq.greedy && greedy
+			}
+			seq.pendingScores = seq.pendingScores[:0]
+
+			if len(seq.inputs) == 0 {
+				s.removeSequence(i, api.DoneReasonStop)
+			}
+			continue
+		}
+
 		// don't sample prompt processing
 		if len(seq.inputs) != 0 {
 			if !s.cache.enabled {
@@ -452,18 +719,37 @@ func (s *Server) processBatch() error {
 			seq.startGenerationTime = time.Now()
 		}
 
-		// if done processing the prompt, generate an embedding and return
+		vocabSize := len(logits) / len(batch.Outputs)
+		seqLogits := logits[seq.iBatch*vocabSize : (seq.iBatch+1)*vocabSize]
+
+		// if done processing the prompt, return the pooled embedding or
+		// the label scores of a classification head
 		if seq.embeddingOnly {
-			// TODO(jessegross): Embedding support
-			slog.Warn("generation of embedding outputs not yet supported")
+			_, embedder := s.model.(model.Embedder)
+			if c, ok := s.model.(model.Classifier); embedder || ok && c.Labels() != nil {
+				seq.embedding <- slices.Clone(seqLogits)
+			} else {
+				// TODO(jessegross): Embedding support
+				slog.WarnContext(seq.logCtx, "generation of embedding outputs not yet supported")
+			}
 			s.removeSequence(i, "")
 			continue
 		}
 
 		// sample a token
-		vocabSize := len(logits) / len(batch.Outputs)
 
-		token, err := seq.sampler.Sample(logits[seq.iBatch*vocabSize : (seq.iBatch+1)*vocabSize])
+		// the probability of ending is reported even if it is then ignored
+		if seq.reportEOS {
+			seq.eosProbability = common.EOSProbability(seqLogits, s.eosTokens(len(seqLogits)))
+		}
+
+		if seq.ignoreEOS {
+			for _, id := range s.eosTokens(len(seqLogits)) {
+				seqLogits[id] = float32(math.Inf(-1))
+			}
+		}
+
+		token, err := seq.sampler.Sample(seqLogits)
 		if err != nil {
 			return fmt.Errorf("failed to sample token: %w", err)
 		}
@@ -474,7 +760,7 @@ func (s *Server) processBatch() error {
 			// as it's important for the /api/generate context
 			// seq.responses <- piece
 
-			s.removeSequence(i, "stop")
+			s.removeSequence(i, api.DoneReasonStop)
 			continue
 		}
 
@@ -485,16 +771,33 @@ func (s *Server) processBatch() error {
 
 		seq.inputs = []input.Input{{Token: token}}
 
+		// drop any text that a model adds after JSON that follows a schema
+		if seq.schema != nil {
+			piece = piece[:len(piece)-seq.schema.trailing]
+		}
+
 		seq.pendingResponses = append(seq.pendingResponses, piece)
 		sequence := strings.Join(seq.pendingResponses, "")
 
-		if ok, stop := common.FindStop(sequence, seq.stop); ok {
-			slog.Debug("hit stop token", "pending", seq.pendingResponses, "stop", stop)
+		if seq.reportLogprobs {
+			seq.pendingLogprobs = append(seq.pendingLogprobs, s.logprob(seqLogits, token, piece, seq.topLogprobs))
+		}
+
+		if seq.schema != nil && seq.schema.decoder.Done() {
+			s.removeSequence(i, api.DoneReasonStop)
+			continue
+		}
+
+		if stop, ok := seq.stops.Feed(piece); ok {
+			slog.DebugContext(seq.logCtx, "hit stop token", "pending", seq.pendingResponses, "stop", stop)
 
 			var tokenTruncated bool
 			origLen := len(seq.pendingResponses)
 			seq.pendingResponses, tokenTruncated = common.TruncateStop(seq.pendingResponses, stop)
 			newLen := len(seq.pendingResponses)
+			if len(seq.pendingLogprobs) > newLen {
+				seq.pendingLogprobs = seq.pendingLogprobs[:newLen]
+			}
 
 			// Update the cache based on the tokens that will be returned:
 			// - We have 1 token more than is currently in the cache because
@@ -510,11 +813,11 @@ func (s *Server) processBatch() error {
 			}
 			seq.cache.Inputs = seq.cache.Inputs[:tokenLen]
 
-			s.removeSequence(i, "stop")
+			s.removeSequence(i, api.DoneReasonStop)
 			continue
 		}
 
-		if common.ContainsStopSuffix(sequence, seq.stop) {
+		if seq.stops.Partial() {
 			continue
 		}
 
@@ -523,10 +826,116 @@ func (s *Server) processBatch() error {
 		}
 
 		if !flushPending(seq) {
-			s.removeSequence(i, "connection")
+			s.removeSequence(i, api.DoneReasonCanceled)
+		}
+	}
+
+	return nil
+}
+
+// forward returns the logits of the outputs of a batch. Batches larger than
+// the micro-batch size are split into micro-batches which are computed
+// without waiting for each other, so that GPUs holding different layers of
+// the model work on different micro-batches at the same time.
+func (s *Server) forward(inputs []int32, batch input.Batch, canSplit []bool) (logits []float32, err error) {
+	// the backend panics when it runs out of memory, which only fails the
+	// batch rather than the runner
+	defer func() {
+		if v := recover(); v != nil {
+			if e, ok := v.(error); ok && errors.Is(e, ml.ErrNoMem) {
+				logits, err = nil, e
+				return
+			}
+			panic(v)
+		}
+	}()
+
+	var outputs []ml.Tensor
+	for start := 0; start < len(inputs); {
+		end := len(inputs)
+		// without a cache, the inputs of a batch only attend to each other
+		if s.ubatchSize > 0 && s.cache.enabled && end-start > s.ubatchSize {
+			end = start + s.ubatchSize
+			for end < len(inputs) && !canSplit[end] {
+				end++
+			}
+		}
+
+		ubatch := input.Batch{
+			Positions: batch.Positions[start:end],
+			Sequences: batch.Sequences[start:end],
+		}
+		for _, i := range batch.Outputs {
+			if int(i) >= start && int(i) < end {
+				ubatch.Outputs = append(ubatch.Outputs, i-int32(start))
+			}
+		}
+		for _, mm := range batch.Multimodal {
+			if mm.Index >= start && mm.Index < end {
+				ubatch.Multimodal = append(ubatch.Multimodal, input.MultimodalIndex{Index: mm.Index - start, Multimodal: mm.Multimodal})
+			}
+		}
+
+		// the contexts hold the outputs until all of them are read
+		ctx := s.model.Backend().NewContext()
+		defer ctx.Close()
+
+		t, err := model.Forward(ctx, s.model, inputs[start:end], ubatch)
+		if err != nil {
+			return nil, err
+		}
+
+		outputs = append(outputs, t)
+		start = end
+	}
+
+	if len(outputs) == 1 {
+		return outputs[0].Floats(), nil
+	}
+
+	for _, t := range outputs {
+		logits = append(logits, t.Floats()...)
+	}
+
+	return logits, nil
+}
+
+// recoverNoMem undoes a batch that the backend or the KV cache ran out of
+// memory for and fails the sequence with the most inputs in it, which is the
+// most likely to have needed the memory, so that the other sequences
+// continue in the next batches.
+func (s *Server) recoverNoMem(err error) error {
+	failed, most := -1, 0
+	for i, seq := range s.seqs {
+		if seq == nil || len(seq.pendingInputs) == 0 {
+			continue
+		}
+
+		if len(seq.pendingInputs) > most {
+			failed, most = i, len(seq.pendingInputs)
+		}
+
+		// the inputs of the batch may be in the cache in part
+		inputs, err := s.cache.Rollback(seq.cache)
+		if err != nil {
+			return err
+		}
+
+		if seq.targets != nil {
+			seq.targets = append(slices.Repeat([]int32{-1}, len(inputs)), seq.targets...)
 		}
+		seq.inputs = slices.Concat(inputs, seq.pendingInputs, seq.inputs)
+		seq.pendingInputs = []input.Input{}
+		seq.pendingScores = seq.pendingScores[:0]
 	}
 
+	if failed < 0 {
+		return err
+	}
+
+	slog.WarnContext(s.seqs[failed].logCtx, "failing sequence", "error", err)
+	s.seqs[failed].err = err
+	s.removeSequence(failed, api.DoneReasonError)
 	return nil
 }
 
@@ -537,6 +946,13 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	s.ready.Wait()
+
+	if _, ok := s.model.(model.Embedder); ok {
+		http.Error(w, "this model does not support generation", http.StatusNotImplemented)
+		return
+	}
+
 	if req.Options == nil {
 		opts := api.DefaultOptions()
 		req.Options = &opts
@@ -552,12 +968,23 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// schemas are followed by a decoder as the output is generated, unless
+	// they have keywords that only the grammar supports
+	var schema *schemaConstraint
+	if len(req.Format) > 0 && req.Format[0] == '{' {
+		if compiled, err := common.CompileSchema(req.Format); err != nil {
+			slog.Debug("using grammar for format", "reason", err)
+		} else {
+			schema = s.newSchemaConstraint(compiled)
+		}
+	}
+
 	var grammar *sample.Grammar
 	var err error
-	if req.Grammar != "" {
+	if req.Grammar != "" && schema == nil {
 		grammar, err = sample.NewGrammar(s.vocab, req.Grammar)
 		if err != nil {
-			http.Error(w, "failed to load model vocabulary required for format", http.StatusInternalServerError)
+			http.Error(w, fmt.Sprintf("failed to load grammar required for format: %v", err), http.StatusInternalServerError)
 			return
 		}
 	}
@@ -567,16 +994,30 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 		req.Options.TopK,
 		req.Options.TopP,
 		req.Options.MinP,
+		req.Options.TypicalP,
+		req.Options.Mirostat,
+		req.Options.MirostatTau,
+		req.Options.MirostatEta,
 		req.Options.Seed,
 		grammar,
 	)
+	if schema != nil {
+		sampler.Constrain(schema)
+	}
 
 	seq, err := s.NewSequence(req.Prompt, req.Images, NewSequenceParams{
-		numPredict: req.Options.NumPredict,
-		stop:       req.Options.Stop,
-		numKeep:    int32(req.Options.NumKeep),
-		sampler:    sampler,
-		embedding:  false,
+		numPredict:  req.Options.NumPredict,
+		stop:        req.Options.Stop,
+		stopRegex:   req.Options.StopRegex,
+		ignoreEOS:   req.Options.IgnoreEOS,
+		numKeep:     int32(req.Options.NumKeep),
+		sampler:     sampler,
+		schema:      schema,
+		embedding:   false,
+		reportEOS:   req.EOSProbability,
+		logprobs:    req.Logprobs,
+		topLogprobs: req.TopLogprobs,
+		logCtx:      r.Context(),
 	})
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to create new sequence: %v", err), http.StatusInternalServerError)
@@ -586,9 +1027,9 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 	// Ensure there is a place to put the sequence, released when removed from s.seqs
 	if err := s.seqsSem.Acquire(r.Context(), 1); err != nil {
 		if errors.Is(err, context.Canceled) {
-			slog.Info("aborting completion request due to client closing the connection")
+			slog.InfoContext(r.Context(), "aborting completion request due to client closing the connection")
 		} else {
-			slog.Error("Failed to acquire semaphore", "error", err)
+			slog.ErrorContext(r.Context(), "Failed to acquire semaphore", "error", err)
 		}
 		return
 	}
@@ -597,7 +1038,7 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 	found := false
 	for i, sq := range s.seqs {
 		if sq == nil {
-			seq.cache, seq.inputs, err = s.cache.LoadCacheSlot(seq.inputs)
+			seq.cache, seq.inputs, err = s.cache.LoadCacheSlot(seq.inputs, req.CacheKey)
 			if err != nil {
 				s.mu.Unlock()
 				http.Error(w, fmt.Sprintf("Failed to load cache: %v", err), http.StatusInternalServerError)
@@ -621,32 +1062,44 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 		select {
 		case <-r.Context().Done():
 			close(seq.quit)
+			s.resume()
 			return
-		case content, ok := <-seq.responses:
+		case resp, ok := <-seq.responses:
 			if ok {
-				if err := json.NewEncoder(w).Encode(&llm.CompletionResponse{
-					Content: content,
-				}); err != nil {
+				// the sequence pauses when its buffer is full, so taking a
+				// response from a full buffer may let it continue
+				if len(seq.responses) == cap(seq.responses)-1 {
+					s.resume()
+				}
+
+				if err := json.NewEncoder(w).Encode(&resp); err != nil {
 					http.Error(w, fmt.Sprintf("failed to encode response: %v", err), http.StatusInternalServerError)
 					close(seq.quit)
+					s.resume()
 					return
 				}
 
 				flusher.Flush()
 			} else {
 				// Send the final response
-				doneReason := "stop"
-				if seq.doneReason == "limit" {
-					doneReason = "length"
+				doneReason := seq.doneReason
+				if doneReason == "" {
+					doneReason = api.DoneReasonStop
 				}
-				if err := json.NewEncoder(w).Encode(&llm.CompletionResponse{
+				final := llm.CompletionResponse{
 					Done:               true,
 					DoneReason:         doneReason,
 					PromptEvalCount:    seq.numPromptInputs,
+					PromptTruncated:    seq.numTruncated,
 					PromptEvalDuration: seq.startGenerationTime.Sub(seq.startProcessingTime),
 					EvalCount:          seq.numPredicted,
 					EvalDuration:       time.Since(seq.startGenerationTime),
-				}); err != nil {
+				}
+				if seq.err != nil {
+					final.Error, final.Code = seq.err.Error(), errorCode(seq.err)
+				}
+
+				if err := json.NewEncoder(w).Encode(&final); err != nil {
 					http.Error(w, fmt.Sprintf("failed to encode final response: %v", err), http.StatusInternalServerError)
 				}
 
@@ -656,16 +1109,156 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// errorCode returns the code of errors that clients can handle, like
+// [api.ErrorResponse.Code].
+func errorCode(err error) string {
+	if errors.Is(err, ml.ErrNoMem) || errors.Is(err, kvcache.ErrKvCacheFull) {
+		return api.ErrorCodeOutOfMemory
+	}
+
+	return ""
+}
+
 func (s *Server) health(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(&llm.ServerStatusResponse{
+	status := llm.ServerStatusResponse{
 		Status:   s.status,
 		Progress: s.progress,
-	}); err != nil {
+		SelfTest: s.selfTest,
+		Streams: llm.StreamStats{
+			Paused: int(s.pausedSeqs.Load()),
+			Pauses: s.pauses.Load(),
+		},
+	}
+	if err := s.loadErr.Load(); err != nil {
+		status.Error = (*err).Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&status); err != nil {
+		http.Error(w, fmt.Sprintf("failed to encode response: %v", err), http.StatusInternalServerError)
+	}
+}
+
+func (s *Server) cacheSlots(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cache == nil {
+		http.Error(w, "model is not loaded", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.cache.KeyedSlots()); err != nil {
 		http.Error(w, fmt.Sprintf("failed to encode response: %v", err), http.StatusInternalServerError)
 	}
 }
 
+func (s *Server) pinCache(w http.ResponseWriter, r *http.Request) {
+	var req llm.CachePinRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cache == nil {
+		http.Error(w, "model is not loaded", http.StatusServiceUnavailable)
+		return
+	}
+
+	cacheError(w, s.cache.Pin(req.Key, req.Pinned))
+}
+
+func (s *Server) evictCache(w http.ResponseWriter, r *http.Request) {
+	var req llm.CacheEvictRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cache == nil {
+		http.Error(w, "model is not loaded", http.StatusServiceUnavailable)
+		return
+	}
+
+	cacheError(w, s.cache.Evict(req.Key))
+}
+
+// saveImage writes a restore image of the weights to the requested path,
+// which a runner of the same model can be started with by --image.
+func (s *Server) saveImage(w http.ResponseWriter, r *http.Request) {
+	var req llm.ImageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	loaded := s.cache != nil
+	s.mu.Unlock()
+
+	if !loaded {
+		http.Error(w, "model is not loaded", http.StatusServiceUnavailable)
+		return
+	}
+
+	imager, ok := s.model.Backend().(ml.BackendImager)
+	if !ok {
+		http.Error(w, llm.ErrImageUnsupported.Error(), http.StatusNotImplemented)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(req.Path), 0o755); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// the image is only put in place once complete, so that a runner never
+	// reads a partial one
+	f, err := os.CreateTemp(filepath.Dir(req.Path), filepath.Base(req.Path)+"-partial-")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	defer os.Remove(f.Name())
+
+	if err := imager.SaveImage(r.Context(), f); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := f.Close(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := os.Rename(f.Name(), req.Path); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("saved restore image", "path", req.Path)
+}
+
+// cacheError writes the response to a request that changed the cache
+func cacheError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errCacheKeyNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errNoUnpinnedSlots):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
 type multiLPath []string
 
 func (m *multiLPath) Set(value string) error {
@@ -693,11 +1286,32 @@ func (s *Server) loadModel(
 		panic(err)
 	}
 
+	if tester, ok := s.model.Backend().(ml.SelfTester); ok {
+		s.selfTest = tester.SelfTest()
+		for _, result := range s.selfTest {
+			if result.Passed {
+				slog.Debug("device passed self-test", "device", result.Device, "max_error", result.MaxError)
+				continue
+			}
+
+			slog.Warn("device failed self-test, model output may be wrong", "device", result.Device,
+				"max_error", result.MaxError, "failed", result.Failed, "error", result.Error)
+			if envconfig.SelfTest() == "strict" {
+				panic(fmt.Errorf("device %s failed self-test (set OLLAMA_SELF_TEST=warn to load anyway)", result.Device))
+			}
+		}
+	}
+
 	s.vocab = sample.NewVocab(mpath)
 
-	// TODO(jessegross): LoRA loading
-	if lpath.String() != "" {
-		panic("loras are not yet implemented")
+	if len(lpath) > 0 {
+		// adapters are used for as long as the model is loaded
+		ctx := s.model.Backend().NewContext()
+		for _, path := range lpath {
+			if err := model.LoadAdapter(ctx, s.model, path); err != nil {
+				panic(err)
+			}
+		}
 	}
 
 	s.cache, err = NewInputCache(s.model, kvCacheType, int32(kvSize), parallel, s.batchSize, multiUserCache)
@@ -705,6 +1319,12 @@ func (s *Server) loadModel(
 		panic(err)
 	}
 
+	if dir := envconfig.KvCacheDir(); dir != "" {
+		if err := s.cache.EnableSnapshots(dir, mpath, lpath); err != nil {
+			slog.Warn("unable to save the kv cache to disk", "dir", dir, "error", err)
+		}
+	}
+
 	if !s.cache.enabled && parallel > 1 {
 		parallel = 1
 		slog.Warn("model does not support caching, disabling parallel processing")
@@ -716,6 +1336,27 @@ func (s *Server) loadModel(
 
 	s.status = llm.ServerStatusReady
 	s.ready.Done()
+
+	// with progressive loading the remaining weights are still being read and
+	// batches wait for the layers they need
+	if loader, ok := s.model.Backend().(ml.BackendLoader); ok && params.Progressive {
+		if err := loader.WaitLoaded(ctx); err != nil {
+			slog.Error("failed to load model weights", "error", err)
+
+			// the runner reports the error instead of exiting so that the
+			// server fails the requests in progress with it and reloads
+			err = fmt.Errorf("failed to load model weights: %w", err)
+			s.loadErr.Store(&err)
+			s.status = llm.ServerStatusError
+
+			s.mu.Lock()
+			s.cond.Broadcast()
+			s.mu.Unlock()
+			return
+		}
+
+		slog.Info("model weights loaded")
+	}
 }
 
 func Execute(args []string) error {
@@ -723,6 +1364,7 @@ func Execute(args []string) error {
 	mpath := fs.String("model", "", "Path to model binary file")
 	parallel := fs.Int("parallel", 1, "Number of sequences to handle simultaneously")
 	batchSize := fs.Int("batch-size", 512, "Batch size")
+	ubatchSize := fs.Int("ubatch-size", 0, "Size of the micro-batches that batches are split into and pipelined across GPUs (default: no split)")
 	numGPULayers := fs.Int("n-gpu-layers", 0, "Number of layers to offload to GPU")
 	mainGPU := fs.Int("main-gpu", 0, "Main GPU")
 	flashAttention := fs.Bool("flash-attn", false, "Enable flash attention")
@@ -735,6 +1377,12 @@ func Execute(args []string) error {
 	_ = fs.Bool("mlock", false, "force system to keep model in RAM rather than swapping or compressing")
 	tensorSplit := fs.String("tensor-split", "", "fraction of the model to offload to each GPU, comma-separated list of proportions")
 	multiUserCache := fs.Bool("multiuser-cache", false, "optimize input cache algorithm for multiple users")
+	progressiveLoad := fs.Bool("progressive-load", false, "start processing requests while the remaining layers load")
+	loadBandwidth := fs.Uint64("load-bandwidth", 0, "maximum bytes per second to read while loading the model (default: unlimited)")
+	image := fs.String("image", "", "path of a restore image to read the weights from if they are placed the same way")
+	lowPriorityLoad := fs.Bool("low-priority-load", false, "load the model with a low i/o priority")
+	streamBuffer := fs.Int("stream-buffer", 100, "number of responses to buffer for each sequence before pausing it until the client catches up")
+	sandbox := fs.Bool("sandbox", false, "deny network access and privileged system calls once listening (linux only)")
 
 	var lpaths multiLPath
 	fs.Var(&lpaths, "lora", "Path to lora layer file (can be specified multiple times)")
@@ -750,23 +1398,17 @@ func Execute(args []string) error {
 	if *verbose {
 		level = slog.LevelDebug
 	}
-	handler := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
-		Level:     level,
-		AddSource: true,
-		ReplaceAttr: func(_ []string, attr slog.Attr) slog.Attr {
-			if attr.Key == slog.SourceKey {
-				source := attr.Value.Any().(*slog.Source)
-				source.File = filepath.Base(source.File)
-			}
-			return attr
-		},
-	})
-	slog.SetDefault(slog.New(handler))
+	if err := logutil.Setup(os.Stderr, level, logutil.Runner); err != nil {
+		return err
+	}
+
 	slog.Info("starting ollama engine")
 
 	server := &Server{
-		batchSize: *batchSize,
-		status:    llm.ServerStatusLoadingModel,
+		batchSize:    *batchSize,
+		ubatchSize:   *ubatchSize,
+		streamBuffer: max(*streamBuffer, 1),
+		status:       llm.ServerStatusLoadingModel,
 	}
 
 	// TODO(jessegross): Parameters that need to be implemented:
@@ -792,42 +1434,67 @@ func Execute(args []string) error {
 		MainGPU:        *mainGPU,
 		TensorSplit:    tensorSplitFloats,
 		FlashAttention: *flashAttention,
+		Progressive:    *progressiveLoad,
+		LoadBandwidth:  *loadBandwidth,
+		SelfTest:       envconfig.SelfTest() != "off",
+		Image:          *image,
+	}
+
+	addr := "127.0.0.1:" + strconv.Itoa(*port)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		slog.Error("failed to listen", "addr", addr, "error", err)
+		return err
+	}
+	defer listener.Close()
+
+	// the sandbox denies creating sockets, so it follows the listener but
+	// precedes parsing the model
+	if *sandbox {
+		if err := common.Sandbox(); err != nil {
+			slog.Warn("unable to sandbox runner", "error", err)
+		}
 	}
 
 	server.ready.Add(1)
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	go server.loadModel(ctx, *mpath, params, lpaths, *parallel, *kvCacheType, *kvSize, *multiUserCache)
+	go func() {
+		if *lowPriorityLoad {
+			if restore, err := common.LowerIOPriority(); err != nil {
+				slog.Warn("unable to lower i/o priority while loading", "error", err)
+			} else {
+				defer restore()
+			}
+		}
+
+		server.loadModel(ctx, *mpath, params, lpaths, *parallel, *kvCacheType, *kvSize, *multiUserCache)
+	}()
 
 	server.cond = sync.NewCond(&server.mu)
 
 	go server.run(ctx)
 
-	addr := "127.0.0.1:" + strconv.Itoa(*port)
-	listener, err := net.Listen("tcp", addr)
-	if err != nil {
-		fmt.Println("Listen error:", err)
-		return err
-	}
-	defer listener.Close()
-
 	mux := http.NewServeMux()
-	// TODO: support embeddings
-	mux.HandleFunc("POST /embedding", func(w http.ResponseWriter, r *http.Request) {
-		http.Error(w, "this model does not support embeddings", http.StatusNotImplemented)
-	})
-
+	mux.HandleFunc("POST /embedding", server.embeddings)
 	mux.HandleFunc("POST /completion", server.completion)
+	mux.HandleFunc("POST /score", server.score)
+	mux.HandleFunc("POST /classify", server.classify)
+	mux.HandleFunc("POST /finetune", server.finetune)
 	mux.HandleFunc("GET /health", server.health)
+	mux.HandleFunc("GET /cache", server.cacheSlots)
+	mux.HandleFunc("POST /cache/pin", server.pinCache)
+	mux.HandleFunc("POST /cache/evict", server.evictCache)
+	mux.HandleFunc("POST /image", server.saveImage)
 
 	httpServer := http.Server{
-		Handler: mux,
+		Handler: logutil.Middleware(mux),
 	}
 
-	log.Println("Server listening on", addr)
+	slog.Info("server listening", "addr", addr)
 	if err := httpServer.Serve(listener); err != nil {
-		log.Fatal("server error:", err)
+		slog.Error("server error", "error", err)
 		return err
 	}
 
@@ -0,0 +1,142 @@
+package ollamarunner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"golang.org/x/sync/semaphore"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/model/input"
+)
+
+func TestPause(t *testing.T) {
+	var s Server
+	seq := &Sequence{
+		responses: make(chan llm.CompletionResponse, 2),
+		logCtx:    context.Background(),
+	}
+
+	seq.responses <- llm.CompletionResponse{}
+	if s.pause(seq) {
+		t.Fatal("paused with room in the buffer")
+	}
+
+	seq.responses <- llm.CompletionResponse{}
+	for range 2 {
+		if !s.pause(seq) {
+			t.Fatal("not paused with a full buffer")
+		}
+	}
+
+	if paused, pauses := s.pausedSeqs.Load(), s.pauses.Load(); paused != 1 || pauses != 1 {
+		t.Errorf("expected 1 paused sequence and 1 pause, got %d and %d", paused, pauses)
+	}
+
+	<-seq.responses
+	if s.pause(seq) {
+		t.Fatal("still paused after the client read a response")
+	}
+
+	if paused, pauses := s.pausedSeqs.Load(), s.pauses.Load(); paused != 0 || pauses != 1 {
+		t.Errorf("expected 0 paused sequences and 1 pause, got %d and %d", paused, pauses)
+	}
+}
+
+func TestRecoverNoMem(t *testing.T) {
+	s := Server{
+		cache:   &InputCache{enabled: true},
+		seqsSem: semaphore.NewWeighted(2),
+	}
+
+	newSeq := func(id int, pending, remaining int) *Sequence {
+		if err := s.seqsSem.Acquire(context.Background(), 1); err != nil {
+			t.Fatal(err)
+		}
+
+		return &Sequence{
+			cache:         &InputCacheSlot{Id: id, Inputs: []input.Input{{Token: 1}}, InUse: true},
+			pendingInputs: slices.Repeat([]input.Input{{Token: 2}}, pending),
+			inputs:        slices.Repeat([]input.Input{{Token: 3}}, remaining),
+			responses:     make(chan llm.CompletionResponse, 1),
+			embedding:     make(chan []float32, 1),
+			logCtx:        context.Background(),
+		}
+	}
+
+	small, large := newSeq(0, 1, 4), newSeq(1, 3, 0)
+	s.seqs = []*Sequence{small, large, nil}
+
+	err := fmt.Errorf("%w: unable to allocate the compute graph", ml.ErrNoMem)
+	if err := s.recoverNoMem(err); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.seqs[0] != small || s.seqs[1] != nil {
+		t.Fatalf("expected only the sequence with the most inputs in the batch to be removed, got %v", s.seqs)
+	}
+
+	if !errors.Is(large.err, ml.ErrNoMem) || large.doneReason != api.DoneReasonError || errorCode(large.err) != api.ErrorCodeOutOfMemory {
+		t.Errorf("expected the removed sequence to fail with out of memory, got %v (%s)", large.err, large.doneReason)
+	}
+
+	if _, ok := <-large.responses; ok {
+		t.Error("expected the responses of the removed sequence to be closed")
+	}
+
+	if len(small.pendingInputs) != 0 || len(small.inputs) != 5 || small.inputs[0].Token != 2 || len(small.cache.Inputs) != 1 {
+		t.Errorf("expected the pending input to be processed again, got pending %v inputs %v cache %v", small.pendingInputs, small.inputs, small.cache.Inputs)
+	}
+}
+
+func TestLoadError(t *testing.T) {
+	s := Server{
+		status:  llm.ServerStatusReady,
+		seqsSem: semaphore.NewWeighted(1),
+	}
+
+	if err := s.seqsSem.Acquire(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	seq := &Sequence{
+		cache:     &InputCacheSlot{InUse: true},
+		inputs:    []input.Input{{Token: 1}},
+		responses: make(chan llm.CompletionResponse, 1),
+		embedding: make(chan []float32, 1),
+		logCtx:    context.Background(),
+	}
+	s.seqs = []*Sequence{seq}
+
+	err := errors.New("failed to load model weights: unexpected EOF")
+	s.loadErr.Store(&err)
+	s.status = llm.ServerStatusError
+
+	if err := s.processBatch(); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.seqs[0] != nil || seq.err != err || seq.doneReason != api.DoneReasonError {
+		t.Errorf("expected the sequence to fail with the load error, got %v (%s)", seq.err, seq.doneReason)
+	}
+
+	w := httptest.NewRecorder()
+	s.health(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	var status llm.ServerStatusResponse
+	if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+
+	if status.Status != llm.ServerStatusError || status.Error != err.Error() {
+		t.Errorf("expected the health check to report the load error, got %+v", status)
+	}
+}
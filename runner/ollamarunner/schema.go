@@ -0,0 +1,60 @@
+package ollamarunner
+
+import (
+	"github.com/ollama/ollama/model"
+	"github.com/ollama/ollama/runner/common"
+)
+
+// schemaConstraint masks the tokens that don't continue JSON matching the
+// schema of a request, so that sampling follows it as it is generated
+type schemaConstraint struct {
+	s       *Server
+	decoder *common.SchemaDecoder
+
+	// trailing is the number of bytes of the last accepted token that come
+	// after the end of the JSON value
+	trailing int
+}
+
+func (s *Server) newSchemaConstraint(schema *common.Schema) *schemaConstraint {
+	return &schemaConstraint{s: s, decoder: common.NewSchemaDecoder(schema)}
+}
+
+func (c *schemaConstraint) Allowed(id int32) bool {
+	if c.s.model.(model.TextProcessor).Is(id, model.SpecialEOS) {
+		return c.decoder.Complete()
+	}
+
+	piece := c.s.piece(id)
+	return piece != "" && c.decoder.Accepts(piece)
+}
+
+func (c *schemaConstraint) Accept(id int32) {
+	if c.s.model.(model.TextProcessor).Is(id, model.SpecialEOS) {
+		return
+	}
+
+	piece := c.s.piece(id)
+	n, _ := c.decoder.Feed(piece)
+	c.trailing = len(piece) - n
+}
+
+// piece returns the text of a token, or an empty string if it can't be
+// decoded on its own. Pieces are cached since constraints check the text of
+// every token in the vocabulary when they mask them.
+func (s *Server) piece(id int32) string {
+	s.piecesMu.Lock()
+	defer s.piecesMu.Unlock()
+
+	if piece, ok := s.pieces[id]; ok {
+		return piece
+	}
+
+	if s.pieces == nil {
+		s.pieces = make(map[int32]string)
+	}
+
+	piece, _ := s.model.(model.TextProcessor).Decode([]int32{id})
+	s.pieces[id] = piece
+	return piece
+}
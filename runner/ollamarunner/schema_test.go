@@ -0,0 +1,74 @@
+package ollamarunner
+
+import (
+	"testing"
+
+	"github.com/ollama/ollama/model"
+	"github.com/ollama/ollama/runner/common"
+	"github.com/ollama/ollama/sample"
+)
+
+// pieceModel is a model whose tokens are pieces, with 0 as end of sequence
+type pieceModel struct {
+	model.Model
+	pieces []string
+}
+
+func (m pieceModel) Encode(string, bool) ([]int32, error) { return nil, nil }
+
+func (m pieceModel) Decode(ids []int32) (string, error) {
+	var s string
+	for _, id := range ids {
+		s += m.pieces[id]
+	}
+	return s, nil
+}
+
+func (m pieceModel) Is(id int32, special model.Special) bool {
+	return special == model.SpecialEOS && id == 0
+}
+
+func TestSchemaConstraint(t *testing.T) {
+	pieces := []string{"", `{"`, `answer`, `":`, ` 42`, ` "42"`, `}`, "} Hope that helps"}
+	s := Server{model: pieceModel{pieces: pieces}}
+
+	schema, err := common.CompileSchema([]byte(`{"type": "object", "properties": {"answer": {"type": "integer"}}, "required": ["answer"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := s.newSchemaConstraint(schema)
+	sampler := sample.NewSampler(0, 0, 0, 0, 0, 0, 0, 0, 0, nil)
+	sampler.Constrain(c)
+
+	// each step makes the most likely token one that the schema doesn't allow
+	steps := []struct {
+		logits []float32
+		want   int32
+	}{
+		{[]float32{9, 1, 0, 0, 0, 0, 0, 0}, 1},
+		{[]float32{0, 0, 1, 0, 0, 0, 9, 0}, 2},
+		{[]float32{0, 0, 0, 1, 0, 0, 0, 0}, 3},
+		{[]float32{0, 0, 0, 0, 1, 9, 0, 0}, 4},
+		{[]float32{9, 0, 0, 0, 0, 0, 0, 1}, 7},
+	}
+
+	for i, step := range steps {
+		got, err := sampler.Sample(step.logits)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != step.want {
+			t.Fatalf("step %d: want token %d, got %d", i, step.want, got)
+		}
+	}
+
+	if !c.decoder.Done() {
+		t.Error("expected the JSON to be complete")
+	}
+
+	if want := len(" Hope that helps"); c.trailing != want {
+		t.Errorf("want %d trailing bytes, got %d", want, c.trailing)
+	}
+}
@@ -0,0 +1,176 @@
+package ollamarunner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/model"
+	"github.com/ollama/ollama/model/input"
+)
+
+// pendingScore is an output of the current batch and the token whose log
+// probability it gives
+type pendingScore struct {
+	output int
+	target int32
+}
+
+// NewScoreSequence creates a sequence that scores the tokens of continuation
+// following prompt instead of generating. The prompt inputs are returned
+// separately from the continuation so the cache is only loaded for the prompt.
+func (s *Server) NewScoreSequence(prompt, continuation string) (*Sequence, []input.Input, error) {
+	s.ready.Wait()
+
+	startTime := time.Now()
+
+	var inputs []input.Input
+	var ctxs *contextList
+	var err error
+	var tokens []int32
+	if prompt == "" {
+		tokens, err = s.model.(model.TextProcessor).Encode(continuation, true)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to process inputs: %w", err)
+		} else if len(tokens) < 2 {
+			return nil, nil, errors.New("at least two tokens are required to score without a prompt")
+		}
+
+		inputs, tokens = []input.Input{{Token: tokens[0]}}, tokens[1:]
+	} else {
+		inputs, ctxs, err = s.inputs(prompt, nil)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to process inputs: %w", err)
+		} else if len(inputs) == 0 {
+			return nil, nil, errors.New("no input provided")
+		}
+
+		tokens, err = s.model.(model.TextProcessor).Encode(continuation, false)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to process inputs: %w", err)
+		}
+	}
+
+	if len(tokens) == 0 {
+		return nil, nil, errors.New("no continuation provided")
+	}
+
+	scored := make([]input.Input, len(tokens))
+	for i, token := range tokens {
+		scored[i] = input.Input{Token: token}
+	}
+
+	return &Sequence{
+		ctxs:                ctxs,
+		inputs:              inputs,
+		numPromptInputs:     len(inputs) + len(scored),
+		startProcessingTime: startTime,
+		pendingResponses:    make([]string, 0),
+		responses:           make(chan llm.CompletionResponse, 100),
+		quit:                make(chan bool, 1),
+		embedding:           make(chan []float32, 1),
+		greedy:              true,
+	}, scored, nil
+}
+
+func (s *Server) score(w http.ResponseWriter, r *http.Request) {
+	var req llm.ScoreRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("bad request: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	seq, continuation, err := s.NewScoreSequence(req.Prompt, req.Continuation)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create new sequence: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	pieces := make([]string, len(continuation))
+	for i, in := range continuation {
+		pieces[i], err = s.model.(model.TextProcessor).Decode([]int32{in.Token})
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to decode token: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Ensure there is a place to put the sequence, released when removed from s.seqs
+	if err := s.seqsSem.Acquire(r.Context(), 1); err != nil {
+		if errors.Is(err, context.Canceled) {
+			slog.Info("aborting score request due to client closing the connection")
+		} else {
+			slog.Error("Failed to acquire semaphore", "error", err)
+		}
+		return
+	}
+
+	s.mu.Lock()
+	found := false
+	for i, sq := range s.seqs {
+		if sq == nil {
+			seq.cache, seq.inputs, err = s.cache.LoadCacheSlot(seq.inputs, "")
+			if err != nil {
+				s.mu.Unlock()
+				s.seqsSem.Release(1)
+				http.Error(w, fmt.Sprintf("Failed to load cache: %v", err), http.StatusInternalServerError)
+				return
+			}
+
+			// every input from the last of the prompt predicts the next
+			// token of the continuation
+			seq.targets = make([]int32, len(seq.inputs)+len(continuation))
+			for j := range seq.targets {
+				seq.targets[j] = -1
+				if k := j + 1 - len(seq.inputs); k >= 0 && k < len(continuation) {
+					seq.targets[j] = continuation[k].Token
+				}
+			}
+			seq.inputs = append(seq.inputs, continuation...)
+
+			s.seqs[i] = seq
+			s.cond.Signal()
+			found = true
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	if !found {
+		s.seqsSem.Release(1)
+		http.Error(w, "could not find an available sequence", http.StatusInternalServerError)
+		return
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			close(seq.quit)
+			return
+		case _, ok := <-seq.responses:
+			if !ok {
+				if seq.err != nil {
+					http.Error(w, seq.err.Error(), http.StatusInternalServerError)
+					return
+				}
+
+				if err := json.NewEncoder(w).Encode(&llm.ScoreResponse{
+					LogProbs: seq.logprobs,
+					Tokens:   pieces,
+					Greedy:   seq.greedy,
+				}); err != nil {
+					http.Error(w, fmt.Sprintf("failed to encode response: %v", err), http.StatusInternalServerError)
+				}
+
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package ollamarunner
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/ollama/ollama/kvcache"
+	"github.com/ollama/ollama/model/input"
+)
+
+// snapshotVersion is written at the start of a snapshot file and changed
+// whenever its format is
+const snapshotVersion uint32 = 1
+
+// snapshots saves the prompts of requests with a cache key to disk.
+type snapshots struct {
+	// dir is where snapshots are saved, under names derived from modelID
+	dir     string
+	modelID string
+
+	// saving tracks snapshots that are being written to disk
+	saving sync.WaitGroup
+}
+
+// EnableSnapshots saves the prompts of requests with a cache key to dir when
+// they finish, and restores them for later requests with the key, such as
+// after the model is loaded again. Snapshots are only shared by the same
+// model and adapters.
+func (c *InputCache) EnableSnapshots(dir string, mpath string, lpath []string) error {
+	snapshotter, ok := c.cache.(kvcache.Snapshotter)
+	if !ok {
+		return kvcache.ErrNotSupported
+	}
+
+	// caches may only support snapshots of some types, such as those that
+	// aren't quantized, which shows when the empty first slot is saved
+	if err := snapshotter.Snapshot(io.Discard, 0); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	for _, path := range append([]string{mpath}, lpath...) {
+		fmt.Fprintf(h, "%s\x00", path)
+	}
+
+	c.snapshots = &snapshots{dir: dir, modelID: hex.EncodeToString(h.Sum(nil))}
+	return nil
+}
+
+// path returns the file that the prompt with the cache key key is saved to.
+// Keys are hashed, so they never form a path of their own.
+func (s *snapshots) path(key string) string {
+	h := sha256.Sum256([]byte(s.modelID + "\x00" + key))
+	return filepath.Join(s.dir, "sha256-"+hex.EncodeToString(h[:]))
+}
+
+// SaveSlots saves the prompts of requests with a cache key that changed
+// since they were last saved. It reads the cache, so it is called while no
+// batches are processed, such as once the runner is idle.
+func (c *InputCache) SaveSlots() {
+	for i := range c.slots {
+		if !c.slots[i].InUse {
+			c.saveSlot(&c.slots[i])
+		}
+	}
+}
+
+// saveSlot saves the prompt that slot holds if it has a cache key and
+// changed since it was last saved. The cache is read immediately, but written
+// to disk in the background.
+func (c *InputCache) saveSlot(slot *InputCacheSlot) {
+	if c.snapshots == nil || slot.Key == "" || len(slot.Inputs) == 0 {
+		return
+	}
+
+	if len(slot.saved) == len(slot.Inputs) && countCommonPrefix(slot.saved, slot.Inputs) == int32(len(slot.Inputs)) {
+		return
+	}
+
+	tokens := make([]int32, len(slot.Inputs))
+	for i, inp := range slot.Inputs {
+		// images can't be restored from their hashes
+		if inp.Multimodal != nil || inp.MultimodalHash != 0 {
+			return
+		}
+		tokens[i] = inp.Token
+	}
+
+	var b bytes.Buffer
+	if err := writeSnapshot(&b, tokens); err != nil {
+		slog.Warn("unable to save cache slot", "id", slot.Id, "key", slot.Key, "error", err)
+		return
+	}
+
+	if err := c.cache.(kvcache.Snapshotter).Snapshot(&b, slot.Id); err != nil {
+		slog.Warn("unable to save cache slot", "id", slot.Id, "key", slot.Key, "error", err)
+		return
+	}
+
+	slot.saved = slices.Clone(slot.Inputs)
+
+	path := c.snapshots.path(slot.Key)
+	c.snapshots.saving.Add(1)
+	go func() {
+		defer c.snapshots.saving.Done()
+		if err := writeFileAtomic(path, b.Bytes()); err != nil {
+			slog.Warn("unable to save cache slot", "path", path, "error", err)
+			return
+		}
+		slog.Debug("saved cache slot", "path", path, "inputs", len(tokens), "size", b.Len())
+	}()
+}
+
+// restoreSlot restores the saved prompt with the cache key key into slot if
+// it has more in common with prompt than the numPast inputs that slot already
+// holds, and returns the number of inputs that can be used.
+func (c *InputCache) restoreSlot(slot *InputCacheSlot, key string, prompt []input.Input, numPast int32) int32 {
+	if c.snapshots == nil {
+		return numPast
+	}
+
+	path := c.snapshots.path(key)
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return numPast
+	} else if err != nil {
+		slog.Warn("unable to restore cache slot", "path", path, "error", err)
+		return numPast
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	tokens, err := readSnapshot(r, c.numCtx)
+	if err != nil {
+		slog.Warn("unable to restore cache slot", "path", path, "error", err)
+		return numPast
+	}
+
+	inputs := make([]input.Input, len(tokens))
+	for i, token := range tokens {
+		inputs[i] = input.Input{Token: token}
+	}
+
+	count := countCommonPrefix(inputs, prompt)
+	if count <= numPast {
+		return numPast
+	}
+
+	if err := c.cache.Remove(slot.Id, 0, math.MaxInt32); err != nil {
+		slog.Warn("unable to restore cache slot", "path", path, "error", err)
+		return numPast
+	}
+
+	if err := c.cache.(kvcache.Snapshotter).Restore(r, slot.Id); err != nil {
+		slog.Warn("unable to restore cache slot", "path", path, "error", err)
+		slot.Inputs = nil
+		if err := c.cache.Remove(slot.Id, 0, math.MaxInt32); err != nil {
+			slog.Warn("unable to clear cache slot", "id", slot.Id, "error", err)
+		}
+		return 0
+	}
+
+	slog.Debug("restored cache slot", "id", slot.Id, "path", path, "inputs", len(inputs), "used", count)
+	slot.Inputs = inputs
+	slot.saved = slices.Clone(inputs)
+	return count
+}
+
+// removeSnapshot removes the saved prompt with the cache key key, reporting
+// whether there was one.
+func (c *InputCache) removeSnapshot(key string) (bool, error) {
+	if c.snapshots == nil {
+		return false, nil
+	}
+
+	// wait for saves in progress, which would write the file again
+	c.snapshots.saving.Wait()
+
+	err := os.Remove(c.snapshots.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// writeSnapshot starts a snapshot file with the tokens of the prompt, which
+// is followed by the snapshot of the cache.
+func writeSnapshot(w io.Writer, tokens []int32) error {
+	if err := binary.Write(w, binary.LittleEndian, [2]uint32{snapshotVersion, uint32(len(tokens))}); err != nil {
+		return err
+	}
+
+	return binary.Write(w, binary.LittleEndian, tokens)
+}
+
+// readSnapshot reads the tokens of a prompt written by writeSnapshot, which
+// may have at most numCtx tokens.
+func readSnapshot(r io.Reader, numCtx int32) ([]int32, error) {
+	var header [2]uint32
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+
+	if header[0] != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", header[0])
+	}
+
+	if header[1] > uint32(numCtx) {
+		return nil, fmt.Errorf("snapshot is longer than the context (context: %v, snapshot: %v)", numCtx, header[1])
+	}
+
+	tokens := make([]int32, header[1])
+	if err := binary.Read(r, binary.LittleEndian, tokens); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func writeFileAtomic(path string, b []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+"-partial-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,179 @@
+package ollamarunner
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/kvcache"
+	"github.com/ollama/ollama/model/input"
+)
+
+// snapshotCache stores the contents of each sequence as a string
+type snapshotCache struct {
+	kvcache.Cache
+
+	seqs map[int]string
+
+	// snapshots is the number of snapshots taken
+	snapshots int
+}
+
+func (c *snapshotCache) Close() {}
+
+func (c *snapshotCache) Remove(seq int, beginIndex, endIndex int32) error {
+	if beginIndex == 0 {
+		delete(c.seqs, seq)
+	}
+	return nil
+}
+
+func (c *snapshotCache) Snapshot(w io.Writer, seq int) error {
+	c.snapshots++
+	_, err := io.WriteString(w, c.seqs[seq])
+	return err
+}
+
+func (c *snapshotCache) Restore(r io.Reader, seq int) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	if len(b) == 0 {
+		return errors.New("empty snapshot")
+	}
+
+	c.seqs[seq] = string(b)
+	return nil
+}
+
+func TestSnapshots(t *testing.T) {
+	dir := t.TempDir()
+
+	newCache := func() (*InputCache, *snapshotCache) {
+		kv := &snapshotCache{seqs: make(map[int]string)}
+		c := &InputCache{
+			numCtx:  16,
+			enabled: true,
+			slots:   []InputCacheSlot{{Id: 0}, {Id: 1}},
+			cache:   kv,
+		}
+		if err := c.EnableSnapshots(dir, "model", nil); err != nil {
+			t.Fatal(err)
+		}
+		kv.snapshots = 0
+		return c, kv
+	}
+
+	tokens := func(ts ...int32) []input.Input {
+		inputs := make([]input.Input, len(ts))
+		for i, t := range ts {
+			inputs[i] = input.Input{Token: t}
+		}
+		return inputs
+	}
+
+	cache, kv := newCache()
+
+	slot, _, err := cache.LoadCacheSlot(tokens(1, 2, 3), "system")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slot.Inputs = tokens(1, 2, 3)
+	kv.seqs[slot.Id] = "system prompt"
+
+	// prompts without a key are not saved
+	other := &cache.slots[1]
+	other.Inputs = tokens(4, 5)
+	kv.seqs[other.Id] = "other prompt"
+
+	// nor are slots that are in use
+	cache.SaveSlots()
+	if kv.snapshots != 0 {
+		t.Fatalf("expected no snapshots while the slot is in use, got %d", kv.snapshots)
+	}
+
+	slot.InUse = false
+	cache.SaveSlots()
+
+	// slots that haven't changed since they were saved are skipped
+	cache.SaveSlots()
+	if kv.snapshots != 1 {
+		t.Fatalf("expected 1 snapshot, got %d", kv.snapshots)
+	}
+	cache.Close()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 snapshot, got %d", len(entries))
+	}
+
+	// a new cache, such as after the model is loaded again, restores the
+	// prompt with the key
+	cache, kv = newCache()
+
+	slot, remaining, err := cache.LoadCacheSlot(tokens(1, 2, 6), "system")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(slot.Inputs, tokens(1, 2)); diff != "" {
+		t.Errorf("inputs mismatch (-got +want):\n%s", diff)
+	}
+	if diff := cmp.Diff(remaining, tokens(6)); diff != "" {
+		t.Errorf("remaining mismatch (-got +want):\n%s", diff)
+	}
+	if kv.seqs[slot.Id] != "system prompt" {
+		t.Errorf("expected the cache to be restored, got %q", kv.seqs[slot.Id])
+	}
+	slot.Inputs = tokens(1, 2, 6, 7)
+	kv.seqs[slot.Id] = "system prompt and answer"
+	slot.InUse = false
+
+	// the prompt of a key is saved before another prompt replaces it
+	if slot, _, err := cache.LoadCacheSlot(tokens(1, 2, 9), ""); err != nil {
+		t.Fatal(err)
+	} else if slot.Key != "" || kv.snapshots != 1 {
+		t.Errorf("expected the keyed prompt to be saved and replaced, got key %q and %d snapshots", slot.Key, kv.snapshots)
+	}
+
+	// snapshots are only restored for their key and model
+	keys, _ := newCache()
+	if _, remaining, err := keys.LoadCacheSlot(tokens(1, 2, 3), "other"); err != nil {
+		t.Fatal(err)
+	} else if len(remaining) != 3 {
+		t.Errorf("expected nothing to be restored for another key, got %d remaining", len(remaining))
+	}
+
+	models, _ := newCache()
+	if err := models.EnableSnapshots(dir, "model", []string{"adapter"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, remaining, err := models.LoadCacheSlot(tokens(1, 2, 3), "system"); err != nil {
+		t.Fatal(err)
+	} else if len(remaining) != 3 {
+		t.Errorf("expected nothing to be restored for another model, got %d remaining", len(remaining))
+	}
+
+	if err := cache.Evict("system"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err = os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected the snapshot to be evicted, got %d", len(entries))
+	}
+
+	if err := cache.Evict("system"); !errors.Is(err, errCacheKeyNotFound) {
+		t.Fatalf("expected errCacheKeyNotFound, got %v", err)
+	}
+}
@@ -21,8 +21,19 @@ type Sampler struct {
 	topK        int
 	topP        float32
 	minP        float32
+	typicalP    float32
 	temperature float32
 	grammar     *Grammar
+	constraint  Constraint
+
+	// mirostat is 1 or 2 to sample with mirostat instead of top k, top p,
+	// min p and typical p, which adjusts mu, the maximum surprise of the
+	// tokens it considers, after each token to keep the surprise of the
+	// output close to mirostatTau
+	mirostat    int
+	mirostatTau float32
+	mirostatEta float32
+	mu          float32
 }
 
 func (s *Sampler) Sample(logits []float32) (int32, error) {
@@ -36,39 +47,82 @@ func (s *Sampler) Sample(logits []float32) (int32, error) {
 		tokens[i].value = logits[i]
 	}
 
+	mu := s.mu
 	t, err := s.sample(tokens)
 	if err != nil {
 		return -1, err
 	}
 
-	if s.grammar != nil {
+	if s.grammar != nil || s.constraint != nil {
 		// optimization: first check if the max logit is accepted by the grammar
 		// if the max logit is rejected, apply the grammar to all logits (slower)
 		top := []token{t}
-		s.grammar.Apply(top)
+		s.constrain(top)
 		if !math.IsInf(float64(top[0].value), -1) {
-			s.grammar.Accept(top[0].id)
+			s.accept(top[0].id)
 			return top[0].id, nil
 		}
 
 		// since .sample has side effects of modifying the tokens
-		// we need to reset them before applying the grammar and
-		// sampling again
+		// and mirostat's mu we need to reset them before applying
+		// the grammar and sampling again
+		s.mu = mu
 		for i := range logits {
 			tokens[i].id = int32(i)
 			tokens[i].value = logits[i]
 		}
-		s.grammar.Apply(tokens)
+		s.constrain(tokens)
 		t, err = s.sample(tokens)
 		if err != nil {
 			return -1, err
 		}
-		s.grammar.Accept(t.id)
+		s.accept(t.id)
 	}
 
 	return t.id, nil
 }
 
+// Constraint restricts the tokens that a Sampler picks, such as to those
+// that continue JSON matching a schema
+type Constraint interface {
+	// Allowed reports whether the token can be picked next
+	Allowed(id int32) bool
+
+	// Accept advances the constraint past the token that was picked
+	Accept(id int32)
+}
+
+// Constrain restricts the tokens that the sampler picks to those that c
+// allows, in addition to its grammar
+func (s *Sampler) Constrain(c Constraint) {
+	s.constraint = c
+}
+
+// constrain masks the tokens that the grammar or constraint don't allow
+func (s *Sampler) constrain(tokens []token) {
+	if s.grammar != nil {
+		s.grammar.Apply(tokens)
+	}
+
+	if s.constraint != nil {
+		for i := range tokens {
+			if !s.constraint.Allowed(tokens[i].id) {
+				tokens[i].value = float32(math.Inf(-1))
+			}
+		}
+	}
+}
+
+func (s *Sampler) accept(id int32) {
+	if s.grammar != nil {
+		s.grammar.Accept(id)
+	}
+
+	if s.constraint != nil {
+		s.constraint.Accept(id)
+	}
+}
+
 // greedy returns the highest probability token from the tokens
 func greedy(tokens []token) token {
 	max := tokens[0]
@@ -88,6 +142,10 @@ func (s *Sampler) sample(tokens []token) (token, error) {
 		return greedy(tokens), nil
 	}
 
+	if s.mirostat > 0 {
+		return s.sampleMirostat(tokens)
+	}
+
 	// topK also sorts the tokens in descending order of logits
 	tokens = topK(tokens, s.topK)
 
@@ -95,9 +153,54 @@ func (s *Sampler) sample(tokens []token) (token, error) {
 	temperature(tokens, s.temperature)
 	softmax(tokens)
 
+	tokens = typicalP(tokens, s.typicalP)
 	tokens = topP(tokens, s.topP)
 	tokens = minP(tokens, s.minP)
 
+	i, err := s.pick(tokens)
+	if err != nil {
+		return token{}, err
+	}
+
+	return tokens[i], nil
+}
+
+// sampleMirostat samples from the tokens that are no more surprising than
+// mu and then moves mu by the difference between the surprise of the
+// sampled token and the target, scaled by the learning rate
+func (s *Sampler) sampleMirostat(tokens []token) (token, error) {
+	vocabSize := len(tokens)
+
+	temperature(tokens, s.temperature)
+	tokens = topK(tokens, 0)
+	softmax(tokens)
+
+	if s.mirostat == 1 {
+		tokens = mirostatV1(tokens, s.mu, vocabSize)
+	} else {
+		tokens = mirostatV2(tokens, s.mu)
+	}
+	normalize(tokens)
+
+	// picking replaces the probabilities of the tokens
+	probs := make([]float32, len(tokens))
+	for i, t := range tokens {
+		probs[i] = t.value
+	}
+
+	i, err := s.pick(tokens)
+	if err != nil {
+		return token{}, err
+	}
+
+	surprise := float32(-math.Log2(float64(probs[i])))
+	s.mu -= s.mirostatEta * (surprise - s.mirostatTau)
+	return tokens[i], nil
+}
+
+// pick returns the index of a random token weighted by the probabilities
+// of the tokens. It replaces them with their cumulative sum
+func (s *Sampler) pick(tokens []token) (int, error) {
 	var r float32
 	if s.rng != nil {
 		r = s.rng.Float32()
@@ -121,13 +224,13 @@ func (s *Sampler) sample(tokens []token) (token, error) {
 	})
 
 	if math.IsNaN(float64(sum)) {
-		return token{}, errors.New("sample: logits sum to NaN, check model output")
+		return -1, errors.New("sample: logits sum to NaN, check model output")
 	}
-	return tokens[idx], nil
+	return idx, nil
 }
 
 // TODO(parthsareen): update sampler interface to use json unmarshal https://github.com/ollama/ollama/issues/9278
-func NewSampler(temperature float32, topK int, topP float32, minP float32, seed int, grammar *Grammar) Sampler {
+func NewSampler(temperature float32, topK int, topP float32, minP float32, typicalP float32, mirostat int, mirostatTau float32, mirostatEta float32, seed int, grammar *Grammar) Sampler {
 	var rng *rand.Rand
 	if seed != -1 {
 		// PCG requires two parameters: sequence and stream
@@ -154,13 +257,28 @@ func NewSampler(temperature float32, topK int, topP float32, minP float32, seed
 		minP = 1.0
 	}
 
+	// typical p is disabled by 0, as well as 1, since it is omitted from
+	// requests when it is 0
+	if typicalP <= 0.0 || typicalP >= 1.0 {
+		typicalP = 1.0
+	}
+
+	if mirostat != 1 && mirostat != 2 {
+		mirostat = 0
+	}
+
 	return Sampler{
 		rng:         rng,
 		topK:        topK,
 		topP:        topP,
 		minP:        minP,
+		typicalP:    typicalP,
 		temperature: temperature,
 		grammar:     grammar,
+		mirostat:    mirostat,
+		mirostatTau: mirostatTau,
+		mirostatEta: mirostatEta,
+		mu:          2 * mirostatTau,
 	}
 }
 
@@ -171,6 +289,10 @@ type Grammar struct {
 }
 
 func NewGrammar(vocab *Vocab, grammar string) (*Grammar, error) {
+	if !llama.ValidGrammar(grammar) {
+		return nil, errors.New("sample: invalid grammar")
+	}
+
 	v, err := vocab.Load()
 	if err != nil {
 		return nil, err
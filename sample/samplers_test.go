@@ -8,7 +8,7 @@ import (
 
 func TestWeighted(t *testing.T) {
 	logits := []float32{-10, 3, -10, -10}
-	sampler := NewSampler(0, 0, 0, 0, 0, nil)
+	sampler := NewSampler(0, 0, 0, 0, 0, 0, 0, 0, 0, nil)
 	got, err := sampler.Sample(logits)
 	if err != nil {
 		t.Error(err)
@@ -20,7 +20,7 @@ func TestWeighted(t *testing.T) {
 	}
 
 	logits = []float32{-100, -10, 0, 10}
-	sampler = NewSampler(0, 0, 0, 0, 0, nil)
+	sampler = NewSampler(0, 0, 0, 0, 0, 0, 0, 0, 0, nil)
 	got, err = sampler.Sample(logits)
 	if err != nil {
 		t.Error(err)
@@ -34,7 +34,7 @@ func TestWeighted(t *testing.T) {
 	// Test very high p
 	logits = []float32{1.0, 0.9999999999999999, 0.5, 0.1}
 	// Use extremely small topP to filter out all tokens
-	sampler = NewSampler(1.0, 0, 1e-10, 0, 0, nil)
+	sampler = NewSampler(1.0, 0, 1e-10, 0, 0, 0, 0, 0, 0, nil)
 	got, err = sampler.Sample(logits)
 	if err != nil {
 		t.Error(err)
@@ -47,7 +47,7 @@ func TestWeighted(t *testing.T) {
 	}
 
 	logits = []float32{float32(math.NaN()), float32(math.NaN()), float32(math.NaN())}
-	sampler = NewSampler(1, 0, 0.95, 0.05, 0, nil)
+	sampler = NewSampler(1, 0, 0.95, 0.05, 0, 0, 0, 0, 0, nil)
 	got, err = sampler.Sample(logits)
 	if err == nil {
 		t.Errorf("expected error, got %d", got)
@@ -55,10 +55,61 @@ func TestWeighted(t *testing.T) {
 	}
 }
 
+// evenConstraint allows only even tokens and records the accepted ones
+type evenConstraint struct {
+	accepted []int32
+}
+
+func (c *evenConstraint) Allowed(id int32) bool {
+	return id%2 == 0
+}
+
+func (c *evenConstraint) Accept(id int32) {
+	c.accepted = append(c.accepted, id)
+}
+
+func TestConstraint(t *testing.T) {
+	var c evenConstraint
+	for _, temperature := range []float32{0, 1} {
+		sampler := NewSampler(temperature, 0, 0, 0, 0, 0, 0, 0, 42, nil)
+		sampler.Constrain(&c)
+
+		got, err := sampler.Sample([]float32{0, 10, 2, -10})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got%2 != 0 {
+			t.Errorf("temperature %v: sampled token %d that the constraint doesn't allow", temperature, got)
+		}
+	}
+
+	if len(c.accepted) != 2 {
+		t.Errorf("expected 2 accepted tokens, got %v", c.accepted)
+	}
+}
+
+func TestMirostatSampler(t *testing.T) {
+	// all 16 tokens are equally likely, so each has a surprise of 4 bits
+	logits := make([]float32, 16)
+
+	for _, version := range []int{1, 2} {
+		sampler := NewSampler(1, 0, 0, 0, 0, version, 3, 0.1, 42, nil)
+		if _, err := sampler.Sample(logits); err != nil {
+			t.Fatal(err)
+		}
+
+		// mu starts at twice tau and moves towards lower surprise
+		if want := float32(6 - 0.1*(4-3)); math.Abs(float64(sampler.mu-want)) > 1e-6 {
+			t.Errorf("mirostat %d: want mu %f, got %f", version, want, sampler.mu)
+		}
+	}
+}
+
 func BenchmarkSample(b *testing.B) {
 	samplers := map[string]Sampler{
-		"Greedy":   NewSampler(0, 0, 0, 0, 0, nil), // Use NewSampler with temp=0 for greedy
-		"Weighted": NewSampler(0.5, 10, 0.9, 0.2, -1, nil),
+		"Greedy":   NewSampler(0, 0, 0, 0, 0, 0, 0, 0, 0, nil), // Use NewSampler with temp=0 for greedy
+		"Weighted": NewSampler(0.5, 10, 0.9, 0.2, 0, 0, 0, 0, -1, nil),
 	}
 
 	// Generate random logits for benchmarking
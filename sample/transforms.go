@@ -1,6 +1,7 @@
 package sample
 
 import (
+	"cmp"
 	"container/heap"
 	"math"
 	"slices"
@@ -128,3 +129,106 @@ func minP(ts []token, p float32) []token {
 	}
 	return ts
 }
+
+// normalize scales probabilities to sum to 1 after some have been removed
+func normalize(ts []token) {
+	var sum float32
+	for _, t := range ts {
+		sum += t.value
+	}
+
+	for i := range ts {
+		ts[i].value /= sum
+	}
+}
+
+// typicalP limits tokens to those whose surprise is closest to the entropy
+// of the distribution, until their cumulative probability exceeds p, see
+// https://arxiv.org/abs/2202.00666. It requires ts to hold probabilities and
+// returns them normalized and sorted in descending order of probabilities
+func typicalP(ts []token, p float32) []token {
+	if p >= 1.0 || len(ts) < 2 {
+		return ts
+	}
+
+	var entropy float64
+	for _, t := range ts {
+		if t.value > 0 {
+			entropy -= float64(t.value) * math.Log(float64(t.value))
+		}
+	}
+
+	type scored struct {
+		token
+		score float64
+	}
+
+	scores := make([]scored, len(ts))
+	for i, t := range ts {
+		scores[i] = scored{t, math.Abs(-math.Log(float64(t.value)) - entropy)}
+	}
+
+	slices.SortStableFunc(scores, func(a, b scored) int {
+		return cmp.Compare(a.score, b.score)
+	})
+
+	n := len(ts)
+	var sum float32
+	for i, s := range scores {
+		sum += s.value
+		if sum > p {
+			n = i + 1
+			break
+		}
+	}
+
+	ts = ts[:n]
+	for i := range ts {
+		ts[i] = scores[i].token
+	}
+
+	slices.SortStableFunc(ts, func(a, b token) int {
+		return cmp.Compare(b.value, a.value)
+	})
+
+	normalize(ts)
+	return ts
+}
+
+// mirostatV1 limits tokens to the k most probable, where k is estimated
+// from the Zipf exponent of the distribution so that no token is more
+// surprising than mu, see https://arxiv.org/abs/2007.14966. It requires ts
+// to hold probabilities sorted in descending order
+func mirostatV1(ts []token, mu float32, vocabSize int) []token {
+	// the number of most probable tokens the exponent is estimated from
+	const m = 100
+
+	var sumTiBi, sumTiSq float64
+	for i := 0; i < m-1 && i < len(ts)-1; i++ {
+		ti := math.Log(float64(i+2) / float64(i+1))
+		bi := math.Log(float64(ts[i].value) / float64(ts[i+1].value))
+		sumTiBi += ti * bi
+		sumTiSq += ti * ti
+	}
+
+	sHat := sumTiBi / sumTiSq
+	epsilonHat := sHat - 1
+	k := math.Pow(epsilonHat*math.Pow(2, float64(mu))/(1-math.Pow(-epsilonHat, float64(vocabSize))), 1/sHat)
+	if math.IsNaN(k) || k < 1 {
+		k = 1
+	}
+
+	return ts[:int(min(k, float64(len(ts))))]
+}
+
+// mirostatV2 limits tokens to those that are no more surprising than mu.
+// It requires ts to hold probabilities sorted in descending order
+func mirostatV2(ts []token, mu float32) []token {
+	for i, t := range ts {
+		if -math.Log2(float64(t.value)) > float64(mu) {
+			return ts[:max(i, 1)]
+		}
+	}
+
+	return ts
+}
@@ -295,6 +295,55 @@ func TestMinP(t *testing.T) {
 	}
 }
 
+func TestTypicalP(t *testing.T) {
+	// surprises are 0.69, 1.20, 1.90 and 3.00 with an entropy of 1.14, so
+	// the second token is the most typical followed by the first
+	input := []float32{0.5, 0.3, 0.15, 0.05}
+
+	tokens := typicalP(toTokens(input), 0.5)
+	compareLogits(t, "typicalP(0.5)", []float32{0.625, 0.375}, tokens)
+
+	tokens = typicalP(toTokens(input), 0.9)
+	compareLogits(t, "typicalP(0.9)", []float32{0.5 / 0.95, 0.3 / 0.95, 0.15 / 0.95}, tokens)
+
+	tokens = typicalP(toTokens(input), 0.2)
+	compareLogits(t, "typicalP(0.2)", []float32{1}, tokens)
+	if tokens[0].id != 1 {
+		t.Errorf("typicalP(0.2): want token 1, got %d", tokens[0].id)
+	}
+
+	tokens = typicalP(toTokens(input), 1.0)
+	compareLogits(t, "typicalP(1.0)", input, tokens)
+}
+
+func TestMirostat(t *testing.T) {
+	// surprises are 1, 2, 3 and 3 bits
+	input := []float32{0.5, 0.25, 0.125, 0.125}
+
+	compareLogits(t, "mirostatV2(2.5)", []float32{0.5, 0.25}, mirostatV2(toTokens(input), 2.5))
+	compareLogits(t, "mirostatV2(0.5)", []float32{0.5}, mirostatV2(toTokens(input), 0.5))
+	compareLogits(t, "mirostatV2(4)", input, mirostatV2(toTokens(input), 4))
+
+	// probabilities follow Zipf's law with an exponent of 1.1, which mirostat
+	// v1 estimates to find k = (0.1 * 2^mu)^(1/1.1)
+	input = make([]float32, 16)
+	for i := range input {
+		input[i] = float32(math.Pow(float64(i+1), -1.1))
+	}
+
+	if got := mirostatV1(toTokens(input), 0, len(input)); len(got) != 1 {
+		t.Errorf("mirostatV1(0): want 1 token, got %d", len(got))
+	}
+
+	if got := mirostatV1(toTokens(input), 20, len(input)); len(got) != len(input) {
+		t.Errorf("mirostatV1(20): want %d tokens, got %d", len(input), len(got))
+	}
+
+	if got := mirostatV1(toTokens(input), 7, len(input)); len(got) != 10 {
+		t.Errorf("mirostatV1(7): want 10 tokens, got %d", len(got))
+	}
+}
+
 func BenchmarkTransforms(b *testing.B) {
 	// Generate random logits
 	tokens := make([]token, 1<<16)
@@ -0,0 +1,416 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/types/model"
+)
+
+// Models can be shared through a bucket, so that autoscaled servers fetch
+// them from it on demand instead of each pulling them from a registry. The
+// bucket holds the manifests and blobs in the layout of the models directory:
+//
+//	<prefix>/manifests/<host>/<namespace>/<model>/<tag>
+//	<prefix>/blobs/sha256-<digest>
+//
+// Servers download the manifests of the bucket when they start, and its blobs
+// when a model is used. Models that are pulled, created or copied are stored
+// in the bucket, and removed from it when they are deleted. The model weights
+// kept on disk are limited by OLLAMA_BLOB_CACHE_SIZE.
+
+// remoteBlobStore returns the store of OLLAMA_BLOB_STORE, or nil if it is
+// not set.
+func remoteBlobStore() (*objectStore, error) {
+	if u := envconfig.BlobStore(); u != "" {
+		return newObjectStore(u)
+	}
+
+	return nil, nil
+}
+
+func manifestKey(name model.Name) string {
+	return path.Join("manifests", filepath.ToSlash(name.Filepath()))
+}
+
+func blobKey(digest string) string {
+	return path.Join("blobs", strings.ReplaceAll(digest, ":", "-"))
+}
+
+// isWeightLayer reports whether layers of mediaType can be evicted from disk.
+func isWeightLayer(mediaType string) bool {
+	switch mediaType {
+	case "application/vnd.ollama.image.model",
+		"application/vnd.ollama.image.projector",
+		"application/vnd.ollama.image.adapter":
+		return true
+	}
+
+	return false
+}
+
+// syncManifests downloads the manifests of the blob store and the layers of
+// its models that are not weights. Local manifests that are not in the store
+// are kept.
+func syncManifests(ctx context.Context) error {
+	store, err := remoteBlobStore()
+	if store == nil || err != nil {
+		return err
+	}
+
+	keys, err := store.list(ctx, "manifests/")
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		name := model.ParseNameFromFilepath(strings.TrimPrefix(key, "manifests/"))
+		if !name.IsValid() {
+			slog.Warn("skipping invalid manifest in blob store", "key", key)
+			continue
+		}
+
+		if err := fetchManifest(ctx, name); err != nil {
+			return err
+		}
+
+		// configs, templates and licenses are read when models are
+		// listed or shown so they are always kept on disk
+		m, err := ParseNamedManifest(name)
+		if err != nil {
+			return err
+		}
+
+		for _, layer := range append(m.Layers, m.Config) {
+			if layer.Digest == "" || isWeightLayer(layer.MediaType) {
+				continue
+			}
+
+			if err := fetchBlob(ctx, store, layer.Digest); err != nil {
+				return fmt.Errorf("fetch %s from blob store: %w", layer.Digest, err)
+			}
+		}
+	}
+
+	slog.Info("synced manifests from blob store", "models", len(keys))
+	return nil
+}
+
+// fetchManifest downloads the manifest of name from the blob store.
+func fetchManifest(ctx context.Context, name model.Name) error {
+	store, err := remoteBlobStore()
+	if store == nil || err != nil {
+		return err
+	}
+
+	r, _, err := store.get(ctx, manifestKey(name))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	bts, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	manifests, err := GetManifestPath()
+	if err != nil {
+		return err
+	}
+
+	return writeManifestFile(filepath.Join(manifests, name.Filepath()), bts)
+}
+
+// fetchModel makes sure that the manifest and blobs of name are on disk,
+// downloading them from the blob store if they are not, and then frees space
+// in the cache of model weights. It does nothing without a blob store.
+func fetchModel(ctx context.Context, name model.Name) error {
+	store, err := remoteBlobStore()
+	if store == nil || err != nil {
+		return err
+	}
+
+	m, err := ParseNamedManifest(name)
+	if errors.Is(err, os.ErrNotExist) {
+		// the model may have been stored by another server since
+		// this one started
+		if err := fetchManifest(ctx, name); errors.Is(err, os.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		m, err = ParseNamedManifest(name)
+	}
+	if err != nil {
+		return err
+	}
+
+	keep := make(map[string]bool)
+	for _, layer := range append(m.Layers, m.Config) {
+		if layer.Digest == "" {
+			continue
+		}
+
+		if err := fetchBlob(ctx, store, layer.Digest); err != nil {
+			return fmt.Errorf("fetch %s from blob store: %w", layer.Digest, err)
+		}
+
+		keep[layer.Digest] = true
+	}
+
+	// blobs fetched from the store, or stored by servers on other machines,
+	// may still need their model files built, which does nothing otherwise
+	if err := prepareModelFiles(m.Layers, func(api.ProgressResponse) {}); err != nil {
+		return err
+	}
+
+	evictBlobs(ctx, store, keep)
+	return nil
+}
+
+// fetchBlob downloads the blob digest from store if it is not on disk, or
+// marks it as recently used if it is.
+func fetchBlob(ctx context.Context, store *objectStore, digest string) error {
+	fp, err := GetBlobsPath(digest)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if err := os.Chtimes(fp, now, now); err == nil {
+		return nil
+	}
+
+	unlock, err := lockStore(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// only one server sharing the models directory fetches the blob
+	unlockBlob, err := lockFile(ctx, fp+"-lock", true)
+	if err != nil {
+		return err
+	}
+	defer unlockBlob()
+
+	if _, err := os.Stat(fp); err == nil {
+		return nil
+	}
+
+	slog.Info("fetching blob from blob store", "digest", digest)
+	r, _, err := store.get(ctx, blobKey(digest))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	temp, err := os.CreateTemp(filepath.Dir(fp), filepath.Base(fp)+"-fetch-")
+	if err != nil {
+		return err
+	}
+	defer temp.Close()
+	defer os.Remove(temp.Name())
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(temp, h), r); err != nil {
+		return err
+	}
+
+	if got := fmt.Sprintf("sha256:%x", h.Sum(nil)); got != digest {
+		return fmt.Errorf("%w: want %s, got %s", errDigestMismatch, digest, got)
+	}
+
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), fp)
+}
+
+// storeModel uploads the blobs that are not in the blob store yet and the
+// manifest of name. It does nothing without a blob store.
+func storeModel(ctx context.Context, name model.Name, fn func(api.ProgressResponse)) error {
+	store, err := remoteBlobStore()
+	if store == nil || err != nil {
+		return err
+	}
+
+	m, err := ParseNamedManifest(name)
+	if err != nil {
+		return err
+	}
+
+	fn(api.ProgressResponse{Status: "storing in blob store"})
+	for _, layer := range append(m.Layers, m.Config) {
+		if layer.Digest == "" {
+			continue
+		}
+
+		if ok, err := store.exists(ctx, blobKey(layer.Digest)); err != nil {
+			return err
+		} else if ok {
+			continue
+		}
+
+		if err := storeBlob(ctx, store, layer.Digest); err != nil {
+			return err
+		}
+	}
+
+	manifests, err := GetManifestPath()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(filepath.Join(manifests, name.Filepath()))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	return store.put(ctx, manifestKey(name), f, fi.Size())
+}
+
+func storeBlob(ctx context.Context, store *objectStore, digest string) error {
+	fp, err := GetBlobsPath(digest)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(fp)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	slog.Info("storing blob in blob store", "digest", digest, "size", fi.Size())
+	return store.put(ctx, blobKey(digest), f, fi.Size())
+}
+
+// removeStoredModel removes the manifest of name from the blob store. Its
+// blobs are kept since other models may use them.
+func removeStoredModel(ctx context.Context, name model.Name) error {
+	store, err := remoteBlobStore()
+	if store == nil || err != nil {
+		return err
+	}
+
+	return store.delete(ctx, manifestKey(name))
+}
+
+// evictBlobs removes the least recently used model weights until the weights
+// on disk fit in OLLAMA_BLOB_CACHE_SIZE. Only weights that are in store and
+// not in keep are removed, since they can be fetched again.
+func evictBlobs(ctx context.Context, store *objectStore, keep map[string]bool) {
+	limit := envconfig.BlobCacheSize()
+	if limit == 0 {
+		return
+	}
+
+	ms, err := Manifests(true)
+	if err != nil {
+		slog.Warn("unable to evict blobs", "error", err)
+		return
+	}
+
+	type blob struct {
+		digest string
+		path   string
+		size   int64
+		used   time.Time
+	}
+
+	seen := make(map[string]bool)
+	var blobs []blob
+	var total int64
+	for _, m := range ms {
+		for _, layer := range m.Layers {
+			if !isWeightLayer(layer.MediaType) {
+				continue
+			}
+
+			if seen[layer.Digest] {
+				continue
+			}
+			seen[layer.Digest] = true
+
+			p, err := GetBlobsPath(layer.Digest)
+			if err != nil {
+				continue
+			}
+
+			fi, err := os.Stat(p)
+			if err != nil {
+				continue
+			}
+
+			total += fi.Size()
+			if !keep[layer.Digest] {
+				blobs = append(blobs, blob{layer.Digest, p, fi.Size(), fi.ModTime()})
+			}
+		}
+	}
+
+	if total <= int64(limit) {
+		return
+	}
+
+	slices.SortFunc(blobs, func(a, b blob) int {
+		return a.used.Compare(b.used)
+	})
+
+	err = collectGarbage(func() error {
+		for _, b := range blobs {
+			if total <= int64(limit) {
+				break
+			}
+
+			if ok, err := store.exists(ctx, blobKey(b.digest)); err != nil || !ok {
+				// never remove the only copy of a blob
+				continue
+			}
+
+			if err := os.Remove(b.path); err != nil {
+				slog.Warn("unable to evict blob", "digest", b.digest, "error", err)
+				continue
+			}
+
+			if cp, err := GetModelCachePath(b.digest); err == nil {
+				os.Remove(cp) //nolint:errcheck
+			}
+
+			slog.Info("evicted blob", "digest", b.digest, "size", b.size)
+			total -= b.size
+		}
+
+		return nil
+	})
+	if err != nil {
+		slog.Warn("unable to evict blobs", "error", err)
+	}
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/model"
+)
+
+func TestBlobStore(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	f := newFakeObjects(t)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_BLOB_CACHE_SIZE", "")
+
+	var s Server
+	for _, name := range []string{"test1", "test2"} {
+		_, digest := createBinFile(t, map[string]any{"general.name": name}, nil)
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Name:   name,
+			Files:  map[string]string{"test.gguf": digest},
+			Stream: &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	m1, err := ParseNamedManifest(model.ParseName("test1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m2, err := ParseNamedManifest(model.ParseName("test2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, layer := range append(m1.Layers, m1.Config) {
+		if _, ok := f.object("models/" + blobKey(layer.Digest)); !ok {
+			t.Errorf("expected %s in the blob store", layer.Digest)
+		}
+	}
+
+	if _, ok := f.object("models/" + manifestKey(model.ParseName("test1"))); !ok {
+		t.Error("expected the manifest in the blob store")
+	}
+
+	// a new server fetches models from the blob store
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	if err := syncManifests(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	blobExists := func(digest string) bool {
+		p, err := GetBlobsPath(digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = os.Stat(p)
+		return err == nil
+	}
+
+	if !blobExists(m1.Config.Digest) {
+		t.Error("expected the config to be fetched with the manifest")
+	}
+
+	if blobExists(m1.Layers[0].Digest) {
+		t.Error("expected the weights to be fetched when the model is used")
+	}
+
+	if err := fetchModel(t.Context(), model.ParseName("test1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if !blobExists(m1.Layers[0].Digest) {
+		t.Error("expected the weights to be fetched")
+	}
+
+	t.Run("evict", func(t *testing.T) {
+		t.Setenv("OLLAMA_BLOB_CACHE_SIZE", "1")
+
+		if err := fetchModel(t.Context(), model.ParseName("test2")); err != nil {
+			t.Fatal(err)
+		}
+
+		if blobExists(m1.Layers[0].Digest) {
+			t.Error("expected the least recently used weights to be evicted")
+		}
+
+		if !blobExists(m2.Layers[0].Digest) || !blobExists(m1.Config.Digest) {
+			t.Error("expected the weights in use and the configs to be kept")
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		w := createRequest(t, s.DeleteHandler, api.DeleteRequest{Model: "test1"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		if _, ok := f.object("models/" + manifestKey(model.ParseName("test1"))); ok {
+			t.Error("expected the manifest to be removed from the blob store")
+		}
+
+		if _, ok := f.object("models/" + blobKey(m1.Layers[0].Digest)); !ok {
+			t.Error("expected the blobs to be kept in the blob store")
+		}
+	})
+}
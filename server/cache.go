@@ -0,0 +1,137 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/types/model"
+)
+
+// cacheKeyRegexp matches the cache keys of requests. Keys are kept short
+// and plain since they are logged and listed by the cache API.
+var cacheKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+var errInvalidCacheKey = errors.New("cache_key must have 1 to 128 letters, digits, '.', '_', ':' or '-'")
+
+// CacheHandler lists the prompts with cache keys that the loaded runners
+// keep in their caches. Runners that are still loading are skipped.
+func (s *Server) CacheHandler(c *gin.Context) {
+	entries := []api.CacheEntry{}
+	for _, r := range s.sched.runners() {
+		if r.llama == nil || r.model == nil {
+			continue
+		}
+
+		slots, err := r.llama.CacheSlots(c.Request.Context())
+		if err != nil {
+			slog.Debug("failed to list cache slots", "model", r.model.ShortName, "error", err)
+			continue
+		}
+
+		for _, slot := range slots {
+			entries = append(entries, api.CacheEntry{
+				Model:    r.model.ShortName,
+				CacheKey: slot.Key,
+				Tokens:   slot.Inputs,
+				Pinned:   slot.Pinned,
+				InUse:    slot.InUse,
+				LastUsed: slot.LastUsed,
+			})
+		}
+	}
+
+	c.JSON(http.StatusOK, api.CacheResponse{Entries: entries})
+}
+
+func (s *Server) PinCacheHandler(c *gin.Context) {
+	s.pinCache(c, true)
+}
+
+func (s *Server) UnpinCacheHandler(c *gin.Context) {
+	s.pinCache(c, false)
+}
+
+func (s *Server) pinCache(c *gin.Context, pinned bool) {
+	req, r, ok := s.cacheRunner(c)
+	if !ok {
+		return
+	}
+
+	cacheResponse(c, r.llama.PinCache(c.Request.Context(), req.CacheKey, pinned))
+}
+
+func (s *Server) EvictCacheHandler(c *gin.Context) {
+	req, r, ok := s.cacheRunner(c)
+	if !ok {
+		return
+	}
+
+	cacheResponse(c, r.llama.EvictCache(c.Request.Context(), req.CacheKey))
+}
+
+// cacheRunner reads a request to change the cache and returns the runner of
+// its model. It responds with an error and returns false if the request is
+// invalid or the model isn't loaded.
+func (s *Server) cacheRunner(c *gin.Context) (api.CacheRequest, *runnerRef, bool) {
+	var req api.CacheRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return req, nil, false
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return req, nil, false
+	}
+
+	if !cacheKeyRegexp.MatchString(req.CacheKey) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": errInvalidCacheKey.Error()})
+		return req, nil, false
+	}
+
+	name := model.ParseName(req.Model)
+	if !name.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+		return req, nil, false
+	}
+
+	name, err := getExistingName(name)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return req, nil, false
+	}
+
+	m, err := GetModel(name.String())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, api.ErrorResponse{Error: fmt.Sprintf("model %q not found", req.Model), Code: api.ErrorCodeModelNotFound})
+		return req, nil, false
+	}
+
+	for _, r := range s.sched.runners() {
+		if r.modelPath == m.ModelPath && r.llama != nil {
+			return req, r, true
+		}
+	}
+
+	c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model %q is not loaded", req.Model)})
+	return req, nil, false
+}
+
+func cacheResponse(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, llm.ErrCacheKeyNotFound):
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, llm.ErrCacheAllPinned):
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.Status(http.StatusOK)
+	}
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/llm"
+)
+
+type mockCacheRunner struct {
+	mockRunner
+	slots []llm.CacheSlot
+}
+
+func (m *mockCacheRunner) CacheSlots(context.Context) ([]llm.CacheSlot, error) {
+	return m.slots, nil
+}
+
+func (m *mockCacheRunner) PinCache(_ context.Context, key string, pinned bool) error {
+	for i := range m.slots {
+		if m.slots[i].Key == key {
+			m.slots[i].Pinned = pinned
+			return nil
+		}
+	}
+
+	return llm.ErrCacheKeyNotFound
+}
+
+func (m *mockCacheRunner) EvictCache(_ context.Context, key string) error {
+	for i := range m.slots {
+		if m.slots[i].Key == key {
+			m.slots = append(m.slots[:i], m.slots[i+1:]...)
+			return nil
+		}
+	}
+
+	return llm.ErrCacheKeyNotFound
+}
+
+func TestCache(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var s Server
+	for name, arch := range map[string]string{"test": "llama", "idle": "gemma"} {
+		_, digest := createBinFile(t, ggml.KV{"general.architecture": arch}, nil)
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:  name,
+			Files:  map[string]string{"file.gguf": digest},
+			Stream: &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lastUsed := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	mock := mockCacheRunner{slots: []llm.CacheSlot{{Key: "system", Inputs: 512, LastUsed: lastUsed}}}
+	s.sched = &Scheduler{loaded: map[string]*runnerRef{
+		m.ModelPath: {llama: &mock, model: m, modelPath: m.ModelPath},
+	}}
+
+	list := func(t *testing.T) []api.CacheEntry {
+		t.Helper()
+
+		w := createRequest(t, s.CacheHandler, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.CacheResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		return resp.Entries
+	}
+
+	t.Run("list", func(t *testing.T) {
+		if diff := cmp.Diff(list(t), []api.CacheEntry{
+			{Model: "test:latest", CacheKey: "system", Tokens: 512, LastUsed: lastUsed},
+		}); diff != "" {
+			t.Errorf("entries mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("pin", func(t *testing.T) {
+		w := createRequest(t, s.PinCacheHandler, api.CacheRequest{Model: "test", CacheKey: "system"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		if entries := list(t); len(entries) != 1 || !entries[0].Pinned {
+			t.Errorf("expected the entry to be pinned, got %+v", entries)
+		}
+
+		w = createRequest(t, s.UnpinCacheHandler, api.CacheRequest{Model: "test", CacheKey: "system"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		if entries := list(t); len(entries) != 1 || entries[0].Pinned {
+			t.Errorf("expected the entry to be unpinned, got %+v", entries)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		cases := []struct {
+			name string
+			req  api.CacheRequest
+			code int
+		}{
+			{"missing key", api.CacheRequest{Model: "test", CacheKey: "other"}, http.StatusNotFound},
+			{"invalid key", api.CacheRequest{Model: "test", CacheKey: "system prompt"}, http.StatusBadRequest},
+			{"missing model", api.CacheRequest{Model: "missing", CacheKey: "system"}, http.StatusNotFound},
+			{"not loaded", api.CacheRequest{Model: "idle", CacheKey: "system"}, http.StatusNotFound},
+		}
+
+		for _, tt := range cases {
+			t.Run(tt.name, func(t *testing.T) {
+				w := createRequest(t, s.EvictCacheHandler, tt.req)
+				if w.Code != tt.code {
+					t.Errorf("expected status %d, got %d: %s", tt.code, w.Code, w.Body.String())
+				}
+			})
+		}
+
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{Model: "test", Prompt: "Hello", CacheKey: "system prompt"})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("evict", func(t *testing.T) {
+		w := createRequest(t, s.EvictCacheHandler, api.CacheRequest{Model: "test", CacheKey: "system"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		if entries := list(t); len(entries) != 0 {
+			t.Errorf("expected no entries, got %+v", entries)
+		}
+	})
+}
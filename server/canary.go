@@ -0,0 +1,472 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/types/errtypes"
+	"github.com/ollama/ollama/types/model"
+)
+
+// canaries split the requests to models between their stable version and a
+// canary model, so that new versions of a model can serve a small share of
+// the requests before they replace it. The canaries are stored in the models
+// directory so that they persist across restarts, while the metrics of their
+// variants are kept in memory. The zero value is ready to use.
+type canaries struct {
+	mu     sync.Mutex
+	loaded bool
+	m      map[string]*canary
+}
+
+// canary is the split of the requests to a model.
+type canary struct {
+	Model     string    `json:"model"`
+	Canary    string    `json:"canary"`
+	Percent   float64   `json:"percent"`
+	StartedAt time.Time `json:"started_at"`
+
+	// stable and canary are the metrics of the variants
+	stable, canary api.CanaryVariant
+}
+
+func canariesPath() string {
+	return filepath.Join(envconfig.Models(), "canaries.json")
+}
+
+// canaryKey is the key of name in the canaries
+func canaryKey(name model.Name) string {
+	return strings.ToLower(name.String())
+}
+
+// load reads the canaries the first time they are needed. Callers must hold
+// cs.mu.
+func (cs *canaries) load() error {
+	if cs.loaded {
+		return nil
+	}
+
+	var f struct {
+		Canaries map[string]*canary `json:"canaries"`
+	}
+
+	bts, err := os.ReadFile(canariesPath())
+	if errors.Is(err, os.ErrNotExist) {
+		f.Canaries = make(map[string]*canary)
+	} else if err != nil {
+		return err
+	} else if err := json.Unmarshal(bts, &f); err != nil {
+		return fmt.Errorf("%s: %w", canariesPath(), err)
+	}
+
+	if f.Canaries == nil {
+		f.Canaries = make(map[string]*canary)
+	}
+
+	cs.m, cs.loaded = f.Canaries, true
+	return nil
+}
+
+// save writes the canaries. Callers must hold cs.mu.
+func (cs *canaries) save() error {
+	unlock, err := lockFile(context.TODO(), canariesPath()+".lock", true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	bts, err := json.MarshalIndent(map[string]any{"canaries": cs.m}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write the canaries atomically so that a crash cannot lose all of them
+	temp, err := os.CreateTemp(filepath.Dir(canariesPath()), "canaries-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(bts); err != nil {
+		temp.Close()
+		return err
+	}
+
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), canariesPath())
+}
+
+// start splits the requests to name with canaryName, or changes the share of
+// its canary if it has started already.
+func (cs *canaries) start(name, canaryName model.Name, percent float64) (api.Canary, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if err := cs.load(); err != nil {
+		return api.Canary{}, err
+	}
+
+	key := canaryKey(name)
+	prev, ok := cs.m[key]
+
+	// changing the share keeps the metrics, while a new canary starts over
+	var c canary
+	if ok && canaryKey(model.ParseName(prev.Canary)) == canaryKey(canaryName) {
+		c = *prev
+	} else {
+		c = canary{
+			Model:     name.DisplayShortest(),
+			Canary:    canaryName.DisplayShortest(),
+			StartedAt: time.Now().UTC(),
+		}
+	}
+
+	c.Percent = percent
+	cs.m[key] = &c
+	if err := cs.save(); err != nil {
+		if ok {
+			cs.m[key] = prev
+		} else {
+			delete(cs.m, key)
+		}
+		return api.Canary{}, err
+	}
+
+	return c.status(), nil
+}
+
+// get returns the canary of name, or nil if name has no canary.
+func (cs *canaries) get(name model.Name) (*canary, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if err := cs.load(); err != nil {
+		return nil, err
+	}
+
+	return cs.m[canaryKey(name)], nil
+}
+
+// end removes c, the canary of name returned by get, unless it has ended or
+// started over since.
+func (cs *canaries) end(name model.Name, c *canary) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if err := cs.load(); err != nil {
+		return err
+	}
+
+	key := canaryKey(name)
+	current, ok := cs.m[key]
+	if !ok || !current.StartedAt.Equal(c.StartedAt) {
+		return nil
+	}
+
+	delete(cs.m, key)
+	if err := cs.save(); err != nil {
+		cs.m[key] = current
+		return err
+	}
+
+	return nil
+}
+
+// list returns the canaries sorted by model.
+func (cs *canaries) list() ([]api.Canary, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if err := cs.load(); err != nil {
+		return nil, err
+	}
+
+	list := make([]api.Canary, 0, len(cs.m))
+	for _, c := range cs.m {
+		list = append(list, c.status())
+	}
+
+	slices.SortFunc(list, func(a, b api.Canary) int {
+		return cmp.Compare(a.Model, b.Model)
+	})
+
+	return list, nil
+}
+
+// status returns the canary with the current digests of its variants.
+func (c *canary) status() api.Canary {
+	status := api.Canary{
+		Model:     c.Model,
+		Percent:   c.Percent,
+		StartedAt: c.StartedAt,
+		Stable:    c.stable,
+		Canary:    c.canary,
+	}
+
+	status.Stable.Model, status.Canary.Model = c.Model, c.Canary
+	if m, err := ParseNamedManifest(model.ParseName(c.Model)); err == nil {
+		status.Stable.Digest = m.digest
+	}
+	if m, err := ParseNamedManifest(model.ParseName(c.Canary)); err == nil {
+		status.Canary.Digest = m.digest
+	}
+
+	return status
+}
+
+// canaryRequest is a request to a model with a canary.
+type canaryRequest struct {
+	cs  *canaries
+	key string
+
+	// startedAt tells the canary of the request apart from later ones of
+	// the same model
+	startedAt time.Time
+
+	canary bool
+	model  string
+	start  time.Time
+
+	once sync.Once
+}
+
+// split picks the variant of a request to name, or returns nil if name has
+// no canary.
+func (cs *canaries) split(ctx context.Context, name string) *canaryRequest {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if err := cs.load(); err != nil {
+		slog.WarnContext(ctx, "unable to load canaries", "error", err)
+		return nil
+	}
+
+	if len(cs.m) == 0 {
+		return nil
+	}
+
+	key := canaryKey(model.ParseName(name))
+	c, ok := cs.m[key]
+	if !ok {
+		return nil
+	}
+
+	r := &canaryRequest{cs: cs, key: key, startedAt: c.StartedAt, model: name, start: time.Now()}
+	if rand.Float64()*100 < c.Percent {
+		r.canary, r.model = true, c.Canary
+	}
+
+	return r
+}
+
+// route describes the variant picked for a request.
+func (r *canaryRequest) route(name string) *api.RouteDecision {
+	decision := &api.RouteDecision{Router: name, Model: r.model, Reason: "stable"}
+	if r.canary {
+		decision.Reason = "canary"
+	}
+
+	return decision
+}
+
+// record adds the outcome of the request to the metrics of its variant. Only
+// the first outcome of a request is recorded, and requests that the client
+// canceled are not.
+func (r *canaryRequest) record(metrics api.Metrics, doneReason string, err error) {
+	if r == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+
+	r.once.Do(func() {
+		r.cs.mu.Lock()
+		defer r.cs.mu.Unlock()
+
+		c, ok := r.cs.m[r.key]
+		if !ok || !c.StartedAt.Equal(r.startedAt) {
+			return
+		}
+
+		v := &c.stable
+		if r.canary {
+			v = &c.canary
+		}
+
+		v.Requests++
+		if err != nil {
+			v.Errors++
+		}
+		if doneReason == api.DoneReasonLength {
+			v.Truncated++
+		}
+
+		v.PromptEvalCount += metrics.PromptEvalCount
+		v.EvalCount += metrics.EvalCount
+		v.EvalDuration += metrics.EvalDuration
+		v.TotalDuration += time.Since(r.start)
+	})
+}
+
+func (s *Server) CanariesHandler(c *gin.Context) {
+	list, err := s.canaries.list()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, api.CanariesResponse{Canaries: list})
+}
+
+// canaryModel resolves the name of a local model for the canary handlers.
+func canaryModel(c *gin.Context, s string) (model.Name, bool) {
+	name := model.ParseName(s)
+	if !name.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": errtypes.InvalidModelNameErrMsg})
+		return model.Name{}, false
+	}
+
+	name, err := getExistingName(name)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return model.Name{}, false
+	}
+
+	if _, err := ParseNamedManifest(name); err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, api.ErrorResponse{Error: fmt.Sprintf("model '%s' not found", s), Code: api.ErrorCodeModelNotFound})
+		return model.Name{}, false
+	}
+
+	return name, true
+}
+
+func (s *Server) StartCanaryHandler(c *gin.Context) {
+	var req api.CanaryRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Percent < 0 || req.Percent > 100 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "percent must be between 0 and 100"})
+		return
+	}
+
+	name, ok := canaryModel(c, req.Model)
+	if !ok {
+		return
+	}
+
+	canaryName, ok := canaryModel(c, req.Canary)
+	if !ok {
+		return
+	}
+
+	if canaryKey(name) == canaryKey(canaryName) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "a model can't be its own canary"})
+		return
+	}
+
+	status, err := s.canaries.start(name, canaryName, req.Percent)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// bindCanary binds the request of the promote and rollback handlers and
+// returns the canary of its model.
+func (s *Server) bindCanary(c *gin.Context) (model.Name, *canary, bool) {
+	var req api.CanaryEndRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return model.Name{}, nil, false
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return model.Name{}, nil, false
+	}
+
+	name := model.ParseName(req.Model)
+	if !name.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": errtypes.InvalidModelNameErrMsg})
+		return model.Name{}, nil, false
+	}
+
+	canary, err := s.canaries.get(name)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return model.Name{}, nil, false
+	} else if canary == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model %q has no canary", req.Model)})
+		return model.Name{}, nil, false
+	}
+
+	return name, canary, true
+}
+
+// PromoteCanaryHandler replaces a model with its canary, which then serves
+// all of its requests. The canary and its metrics are kept if the model
+// can't be replaced.
+func (s *Server) PromoteCanaryHandler(c *gin.Context) {
+	name, canary, ok := s.bindCanary(c)
+	if !ok {
+		return
+	}
+
+	if err := CopyModel(model.ParseName(canary.Canary), name); errors.Is(err, os.ErrNotExist) {
+		c.JSON(http.StatusNotFound, api.ErrorResponse{Error: fmt.Sprintf("model %q not found", canary.Canary), Code: api.ErrorCodeModelNotFound})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.canaries.end(name, canary); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	} else if err := storeModel(c.Request.Context(), name, func(api.ProgressResponse) {}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// RollbackCanaryHandler ends the canary of a model, which then serves all of
+// its requests with its stable version.
+func (s *Server) RollbackCanaryHandler(c *gin.Context) {
+	name, canary, ok := s.bindCanary(c)
+	if !ok {
+		return
+	}
+
+	if err := s.canaries.end(name, canary); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
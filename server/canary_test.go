@@ -0,0 +1,196 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/model"
+)
+
+func TestCanaryHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var s Server
+	for name, kv := range map[string]map[string]any{
+		"test":    {"general.architecture": "llama"},
+		"test-q5": {"general.architecture": "llama", "general.name": "requantized"},
+	} {
+		_, digest := createBinFile(t, kv, nil)
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Name:   name,
+			Files:  map[string]string{"test.gguf": digest},
+			Stream: &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	canaries := func(t *testing.T) []api.Canary {
+		t.Helper()
+
+		w := createRequest(t, s.CanariesHandler, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.CanariesResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		return resp.Canaries
+	}
+
+	if got := canaries(t); len(got) != 0 {
+		t.Fatalf("expected no canaries, got %+v", got)
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		cases := []struct {
+			req  api.CanaryRequest
+			code int
+		}{
+			{api.CanaryRequest{Model: "test", Canary: "test-q5", Percent: 101}, http.StatusBadRequest},
+			{api.CanaryRequest{Model: "test", Canary: "test", Percent: 5}, http.StatusBadRequest},
+			{api.CanaryRequest{Model: "test", Canary: "missing", Percent: 5}, http.StatusNotFound},
+		}
+
+		for _, tt := range cases {
+			w := createRequest(t, s.StartCanaryHandler, tt.req)
+			if w.Code != tt.code {
+				t.Errorf("%+v: expected status %d, got %d: %s", tt.req, tt.code, w.Code, w.Body.String())
+			}
+		}
+	})
+
+	w := createRequest(t, s.StartCanaryHandler, api.CanaryRequest{Model: "test", Canary: "test-q5", Percent: 100})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	t.Run("split", func(t *testing.T) {
+		r := s.canaries.split(t.Context(), "test")
+		if r == nil || !r.canary || r.model != "test-q5:latest" {
+			t.Fatalf("expected the canary, got %+v", r)
+		}
+
+		want := api.RouteDecision{Router: "test", Model: "test-q5:latest", Reason: "canary"}
+		if got := r.route("test"); got.Router != want.Router || got.Model != want.Model || got.Reason != want.Reason {
+			t.Errorf("expected %+v, got %+v", want, got)
+		}
+
+		r.record(api.Metrics{PromptEvalCount: 3, EvalCount: 10}, api.DoneReasonLength, nil)
+		r.record(api.Metrics{}, "", errors.New("recorded once"))
+
+		if r := s.canaries.split(t.Context(), "llama3"); r != nil {
+			t.Errorf("expected no canary for other models, got %+v", r)
+		}
+	})
+
+	// changing the share keeps the metrics
+	w = createRequest(t, s.StartCanaryHandler, api.CanaryRequest{Model: "test", Canary: "test-q5", Percent: 0})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if r := s.canaries.split(t.Context(), "test:latest"); r == nil || r.canary {
+		t.Errorf("expected the stable model, got %+v", r)
+	} else {
+		r.record(api.Metrics{}, "", errors.New("failed"))
+	}
+
+	got := canaries(t)
+	if len(got) != 1 {
+		t.Fatalf("expected a canary, got %+v", got)
+	}
+
+	stable, canary := got[0].Stable, got[0].Canary
+	if got[0].Model != "test:latest" || got[0].Percent != 0 ||
+		stable.Model != "test:latest" || stable.Requests != 1 || stable.Errors != 1 ||
+		canary.Model != "test-q5:latest" || canary.Requests != 1 || canary.Errors != 0 || canary.Truncated != 1 || canary.EvalCount != 10 ||
+		stable.Digest == "" || stable.Digest == canary.Digest {
+		t.Errorf("unexpected canary %+v", got[0])
+	}
+
+	// the canary persists in the models directory without its metrics
+	s = Server{}
+	if got := canaries(t); len(got) != 1 || got[0].Canary.Model != "test-q5:latest" || got[0].Canary.Requests != 0 {
+		t.Errorf("expected the canary to persist, got %+v", got)
+	}
+
+	t.Run("rollback", func(t *testing.T) {
+		w := createRequest(t, s.RollbackCanaryHandler, api.CanaryEndRequest{Model: "test"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		if got := canaries(t); len(got) != 0 {
+			t.Errorf("expected no canaries, got %+v", got)
+		}
+
+		w = createRequest(t, s.RollbackCanaryHandler, api.CanaryEndRequest{Model: "test"})
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("promote", func(t *testing.T) {
+		w := createRequest(t, s.StartCanaryHandler, api.CanaryRequest{Model: "test", Canary: "test-q5", Percent: 5})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		want, err := ParseNamedManifest(model.ParseName("test-q5"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w = createRequest(t, s.PromoteCanaryHandler, api.CanaryEndRequest{Model: "test"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		m, err := ParseNamedManifest(model.ParseName("test"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if m.digest != want.digest {
+			t.Errorf("expected test to be the canary %s, got %s", want.digest, m.digest)
+		}
+
+		if got := canaries(t); len(got) != 0 {
+			t.Errorf("expected no canaries, got %+v", got)
+		}
+	})
+
+	t.Run("promote missing canary", func(t *testing.T) {
+		w := createRequest(t, s.StartCanaryHandler, api.CanaryRequest{Model: "test", Canary: "test-q5", Percent: 5})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		w = createRequest(t, s.DeleteHandler, api.DeleteRequest{Model: "test-q5"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		w = createRequest(t, s.PromoteCanaryHandler, api.CanaryEndRequest{Model: "test"})
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("expected status 404, got %d: %s", w.Code, w.Body.String())
+		}
+
+		// the canary is kept when the model isn't replaced
+		if got := canaries(t); len(got) != 1 || got[0].Canary.Model != "test-q5:latest" {
+			t.Errorf("expected the canary to be kept, got %+v", got)
+		}
+	})
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+// cancels holds the requests in progress that clients can stop by their ID.
+// The zero value is ready to use.
+type cancels struct {
+	mu sync.Mutex
+	m  map[string][]*context.CancelFunc
+}
+
+// add registers cancel for the request with id and returns a function that
+// removes it again.
+func (cs *cancels) add(id string, cancel context.CancelFunc) func() {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if cs.m == nil {
+		cs.m = make(map[string][]*context.CancelFunc)
+	}
+
+	// clients may reuse an ID for several requests, which are all stopped
+	p := &cancel
+	cs.m[id] = append(cs.m[id], p)
+
+	return func() {
+		cs.mu.Lock()
+		defer cs.mu.Unlock()
+
+		for i, q := range cs.m[id] {
+			if q == p {
+				cs.m[id] = append(cs.m[id][:i], cs.m[id][i+1:]...)
+				break
+			}
+		}
+
+		if len(cs.m[id]) == 0 {
+			delete(cs.m, id)
+		}
+	}
+}
+
+// cancel stops the requests with id and reports whether there were any.
+func (cs *cancels) cancel(id string) bool {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	for _, cancel := range cs.m[id] {
+		(*cancel)()
+	}
+
+	return len(cs.m[id]) > 0
+}
+
+// cancelableMiddleware lets clients stop the request with [Server.CancelHandler]
+// by its request ID, which stops its generation like closing the connection.
+func (s *Server) cancelableMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithCancel(c.Request.Context())
+		defer cancel()
+
+		remove := s.cancels.add(requestID(c), cancel)
+		defer remove()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// CancelHandler stops the generate or chat request with the ID in the body.
+func (s *Server) CancelHandler(c *gin.Context) {
+	var req api.CancelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.RequestID == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "request_id is required"})
+		return
+	}
+
+	if !s.cancels.cancel(req.RequestID) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("no request with id %q in progress", req.RequestID)})
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCancelHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var s Server
+	r := gin.New()
+	r.Use(requestIDMiddleware())
+	r.POST("/api/chat", s.cancelableMiddleware(), func(c *gin.Context) {
+		select {
+		case <-c.Request.Context().Done():
+			c.JSON(499, gin.H{"error": "request canceled"})
+		case <-time.After(5 * time.Second):
+			c.Status(http.StatusOK)
+		}
+	})
+	r.POST("/api/cancel", s.CancelHandler)
+
+	done := make(chan int)
+	go func() {
+		req := httptest.NewRequest(http.MethodPost, "/api/chat", nil)
+		req.Header.Set("X-Request-ID", "chat-1")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		done <- w.Code
+	}()
+
+	cancel := func(body string) int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/cancel", strings.NewReader(body)))
+		return w.Code
+	}
+
+	// wait for the chat request to start
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if code := cancel(`{"request_id":"chat-1"}`); code == http.StatusOK {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("expected status 200, got %d", code)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if code := <-done; code != 499 {
+		t.Errorf("expected the chat request to be canceled, got status %d", code)
+	}
+
+	if code := cancel(`{"request_id":"chat-1"}`); code != http.StatusNotFound {
+		t.Errorf("expected status 404 once the request finished, got %d", code)
+	}
+
+	if code := cancel(`{}`); code != http.StatusBadRequest {
+		t.Errorf("expected status 400 without an id, got %d", code)
+	}
+}
+
+func TestCancelsReusedID(t *testing.T) {
+	var cs cancels
+
+	var canceled [2]bool
+	remove0 := cs.add("a", func() { canceled[0] = true })
+	remove1 := cs.add("a", func() { canceled[1] = true })
+
+	remove0()
+	if !cs.cancel("a") {
+		t.Fatal("expected a request to cancel")
+	}
+
+	if canceled[0] || !canceled[1] {
+		t.Errorf("expected only the remaining request to be canceled, got %v", canceled)
+	}
+
+	remove1()
+	if cs.cancel("a") {
+		t.Error("expected no requests after removing them")
+	}
+}
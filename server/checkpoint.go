@@ -0,0 +1,129 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+)
+
+const (
+	// checkpointInterval is the number of tokens generated between checkpoints.
+	checkpointInterval = 128
+
+	// checkpointTTL is how long checkpoints of interrupted generations are kept.
+	checkpointTTL = 24 * time.Hour
+)
+
+// checkpoint is the state of a generation saved so that it can be resumed
+// after the runner or the server stops.
+type checkpoint struct {
+	Token string `json:"-"`
+
+	// Request is the original request and Digest the digest of the model
+	// that served it.
+	Request api.GenerateRequest `json:"request"`
+	Digest  string              `json:"digest"`
+
+	// Response is the text generated so far and EvalCount its length in tokens.
+	Response  string `json:"response"`
+	EvalCount int    `json:"eval_count"`
+}
+
+// newCheckpoint starts checkpointing a generation of req by the model with
+// digest.
+func newCheckpoint(req api.GenerateRequest, digest string) (*checkpoint, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return nil, err
+	}
+
+	pruneCheckpoints()
+
+	req.Stream, req.KeepAlive, req.Continuation = nil, nil, ""
+	return &checkpoint{Token: hex.EncodeToString(b[:]), Request: req, Digest: digest}, nil
+}
+
+// loadCheckpoint returns the checkpoint with the continuation token.
+func loadCheckpoint(token string) (*checkpoint, error) {
+	path, err := GetCheckpointPath(token)
+	if err != nil {
+		return nil, err
+	}
+
+	bts, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cp checkpoint
+	if err := json.Unmarshal(bts, &cp); err != nil {
+		return nil, err
+	}
+
+	cp.Token = token
+	return &cp, nil
+}
+
+// save writes the checkpoint, replacing the previous one.
+func (cp *checkpoint) save() error {
+	path, err := GetCheckpointPath(cp.Token)
+	if err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+"-partial-")
+	if err != nil {
+		return err
+	}
+	defer temp.Close()
+	defer os.Remove(temp.Name())
+
+	if err := json.NewEncoder(temp).Encode(cp); err != nil {
+		return err
+	}
+
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), path)
+}
+
+// remove deletes the checkpoint once the generation has finished.
+func (cp *checkpoint) remove() error {
+	path, err := GetCheckpointPath(cp.Token)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// pruneCheckpoints removes checkpoints that haven't been updated for
+// checkpointTTL.
+func pruneCheckpoints() {
+	files, err := filepath.Glob(filepath.Join(envconfig.Scratch(), "checkpoints", "*.json"))
+	if err != nil {
+		return
+	}
+
+	for _, file := range files {
+		if fi, err := os.Stat(file); err == nil && time.Since(fi.ModTime()) > checkpointTTL {
+			slog.Debug("removing expired checkpoint", "path", file)
+			if err := os.Remove(file); err != nil {
+				slog.Warn("failed to remove checkpoint", "path", file, "error", err)
+			}
+		}
+	}
+}
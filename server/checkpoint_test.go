@@ -0,0 +1,178 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestGenerateCheckpoint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var mock mockRunner
+	s := Server{
+		sched: &Scheduler{
+			pendingReqCh:  make(chan *LlmRequest, 1),
+			finishedReqCh: make(chan *LlmRequest, 1),
+			expiredCh:     make(chan *runnerRef, 1),
+			unloadedCh:    make(chan any, 1),
+			loaded:        make(map[string]*runnerRef),
+			newServerFn:   newMockServer(&mock),
+			getGpuFn:      discover.GetGPUInfo,
+			getCpuFn:      discover.GetCPUInfo,
+			reschedDelay:  250 * time.Millisecond,
+			loadFn: func(req *LlmRequest, _ *ggml.GGML, _ discover.GpuInfoList, _ int) {
+				req.successCh <- &runnerRef{
+					llama: &mock,
+				}
+			},
+		},
+	}
+
+	go s.sched.Run(t.Context())
+
+	_, digest := createBinFile(t, ggml.KV{
+		"general.architecture":       "llama",
+		"llama.block_count":          uint32(1),
+		"llama.context_length":       uint32(2048),
+		"llama.embedding_length":     uint32(1024),
+		"llama.attention.head_count": uint32(8),
+		"tokenizer.ggml.tokens":      []string{""},
+		"tokenizer.ggml.scores":      []float32{0},
+		"tokenizer.ggml.token_type":  []int32{0},
+	}, []ggml.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+	})
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:    "test",
+		Files:    map[string]string{"file.gguf": digest},
+		Template: "{{ .Prompt }}",
+		Stream:   &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// decode returns the streamed responses and the error, if any
+	decode := func(t *testing.T, w *httptest.ResponseRecorder) (responses []api.GenerateResponse, continuation, errMsg string) {
+		t.Helper()
+
+		scanner := bufio.NewScanner(w.Body)
+		for scanner.Scan() {
+			var line struct {
+				api.GenerateResponse
+				Error string `json:"error"`
+			}
+
+			if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+				t.Fatal(err)
+			}
+
+			if line.Error != "" {
+				errMsg = line.Error
+			} else {
+				responses = append(responses, line.GenerateResponse)
+			}
+
+			continuation = line.Continuation
+		}
+
+		return responses, continuation, errMsg
+	}
+
+	tokens := checkpointInterval + checkpointInterval/2
+	mock.CompletionFn = func(ctx context.Context, r llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+		for range tokens {
+			fn(llm.CompletionResponse{Content: "a"})
+		}
+
+		return errors.New("runner crashed")
+	}
+
+	w = createRequest(t, s.GenerateHandler, api.GenerateRequest{
+		Model:      "test",
+		Prompt:     "Hello!",
+		Checkpoint: true,
+		Options:    map[string]any{"num_predict": 1000},
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	responses, continuation, errMsg := decode(t, w)
+	if len(responses) != tokens || errMsg != "runner crashed" {
+		t.Fatalf("expected %d responses and an error, got %d and %q", tokens, len(responses), errMsg)
+	}
+
+	if continuation == "" {
+		t.Fatal("expected a continuation token")
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{Continuation: "../../etc/passwd"})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{Continuation: strings.Repeat("0", 32)})
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("resume", func(t *testing.T) {
+		mock.CompletionFn = func(ctx context.Context, r llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+			fn(llm.CompletionResponse{Content: "b"})
+			fn(llm.CompletionResponse{Done: true, DoneReason: "stop"})
+			return nil
+		}
+
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{Continuation: continuation})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		// only the checkpointed tokens are kept
+		if expect := "Hello!" + strings.Repeat("a", checkpointInterval); mock.CompletionRequest.Prompt != expect {
+			t.Errorf("expected prompt %q, got %q", expect, mock.CompletionRequest.Prompt)
+		}
+
+		if expect := 1000 - checkpointInterval; mock.CompletionRequest.Options.NumPredict != expect {
+			t.Errorf("expected num_predict %d, got %d", expect, mock.CompletionRequest.Options.NumPredict)
+		}
+
+		responses, resumed, errMsg := decode(t, w)
+		if errMsg != "" || len(responses) != 2 || !responses[1].Done {
+			t.Fatalf("expected the generation to finish, got %+v %q", responses, errMsg)
+		}
+
+		if resumed != continuation {
+			t.Errorf("expected continuation %q, got %q", continuation, resumed)
+		}
+
+		// the checkpoint is removed once the generation finishes
+		w = createRequest(t, s.GenerateHandler, api.GenerateRequest{Continuation: continuation})
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/model"
+)
+
+func (s *Server) ClassifyHandler(c *gin.Context) {
+	checkpointStart := time.Now()
+	var req api.ClassifyRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	truncate := true
+	if req.Truncate != nil && !*req.Truncate {
+		truncate = false
+	}
+
+	var input []string
+	switch i := req.Input.(type) {
+	case string:
+		if len(i) > 0 {
+			input = append(input, i)
+		}
+	case []any:
+		for _, v := range i {
+			if _, ok := v.(string); !ok {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid input type"})
+				return
+			}
+			input = append(input, v.(string))
+		}
+	default:
+		if req.Input != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid input type"})
+			return
+		}
+	}
+
+	name, err := getExistingName(model.ParseName(req.Model))
+	if err != nil {
+		c.JSON(http.StatusNotFound, api.ErrorResponse{Error: fmt.Sprintf("model '%s' not found", req.Model), Code: api.ErrorCodeModelNotFound})
+		return
+	}
+
+	r, m, opts, _, err := s.scheduleRunner(c.Request.Context(), name.String(), []Capability{CapabilityClassification}, req.Options, req.KeepAlive)
+	if errors.Is(err, errCapabilityClassification) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%q does not support classification", req.Model)})
+		return
+	} else if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	checkpointLoaded := time.Now()
+
+	if len(input) == 0 {
+		c.JSON(http.StatusOK, api.ClassifyResponse{Model: req.Model, Classifications: [][]api.LabelScore{}})
+		return
+	}
+
+	var count int
+	for i, s := range input {
+		tokens, err := r.Tokenize(c.Request.Context(), s)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if len(tokens) > opts.NumCtx {
+			if !truncate {
+				c.JSON(http.StatusBadRequest, api.ErrorResponse{Error: "input length exceeds maximum context length", Code: api.ErrorCodeContextExceeded})
+				return
+			}
+
+			tokens = tokens[:opts.NumCtx]
+			s, err = r.Detokenize(c.Request.Context(), tokens)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		count += len(tokens)
+
+		input[i] = s
+	}
+
+	var g errgroup.Group
+	classifications := make([][]api.LabelScore, len(input))
+	for i, text := range input {
+		g.Go(func() error {
+			resp, err := r.Classify(c.Request.Context(), text)
+			if err != nil {
+				return err
+			}
+
+			classifications[i] = labelScores(resp.Labels, resp.Logits)
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": strings.TrimSpace(err.Error())})
+		return
+	}
+
+	c.JSON(http.StatusOK, api.ClassifyResponse{
+		Model:           req.Model,
+		Classifications: classifications,
+		TotalDuration:   time.Since(checkpointStart),
+		LoadDuration:    checkpointLoaded.Sub(checkpointStart),
+		PromptEvalCount: count,
+		Fingerprint:     fingerprint(m, "", r),
+	})
+}
+
+// labelScores scores the logits of a classification head and sorts the labels
+// from the highest score to the lowest. A single logit is scored with a
+// sigmoid and several with a softmax.
+func labelScores(labels []string, logits []float32) []api.LabelScore {
+	scores := make([]api.LabelScore, len(logits))
+	if len(logits) == 0 {
+		return scores
+	}
+
+	for i, logit := range logits {
+		scores[i].Logit = float64(logit)
+		if i < len(labels) {
+			scores[i].Label = labels[i]
+		}
+	}
+
+	if len(scores) == 1 {
+		scores[0].Score = 1 / (1 + math.Exp(-scores[0].Logit))
+		return scores
+	}
+
+	maxLogit := slices.MaxFunc(scores, func(a, b api.LabelScore) int {
+		return cmp.Compare(a.Logit, b.Logit)
+	}).Logit
+
+	var sum float64
+	for i := range scores {
+		scores[i].Score = math.Exp(scores[i].Logit - maxLogit)
+		sum += scores[i].Score
+	}
+
+	for i := range scores {
+		scores[i].Score /= sum
+	}
+
+	slices.SortStableFunc(scores, func(a, b api.LabelScore) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+
+	return scores
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestLabelScores(t *testing.T) {
+	t.Run("softmax", func(t *testing.T) {
+		scores := labelScores([]string{"negative", "neutral", "positive"}, []float32{0, float32(math.Log(3)), float32(math.Log(6))})
+		want := []api.LabelScore{
+			{Label: "positive", Score: 0.6, Logit: math.Log(6)},
+			{Label: "neutral", Score: 0.3, Logit: math.Log(3)},
+			{Label: "negative", Score: 0.1, Logit: 0},
+		}
+
+		if len(scores) != len(want) {
+			t.Fatalf("expected %d scores, got %d", len(want), len(scores))
+		}
+
+		for i := range want {
+			if scores[i].Label != want[i].Label || math.Abs(scores[i].Score-want[i].Score) > 1e-6 || math.Abs(scores[i].Logit-want[i].Logit) > 1e-6 {
+				t.Errorf("expected %+v, got %+v", want[i], scores[i])
+			}
+		}
+	})
+
+	t.Run("sigmoid", func(t *testing.T) {
+		scores := labelScores([]string{"reward"}, []float32{0})
+		if len(scores) != 1 || scores[0].Label != "reward" || scores[0].Score != 0.5 {
+			t.Errorf("expected a score of 0.5, got %+v", scores)
+		}
+	})
+
+	t.Run("large logits", func(t *testing.T) {
+		scores := labelScores([]string{"a", "b"}, []float32{1000, 1000})
+		if scores[0].Score != 0.5 || scores[1].Score != 0.5 {
+			t.Errorf("expected even scores, got %+v", scores)
+		}
+	})
+}
+
+func TestClassifyHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mock := mockRunner{
+		ClassifyFn: func(_ context.Context, input string) (*llm.ClassifyResponse, error) {
+			logits := []float32{2, -2}
+			if input == "I love it" {
+				logits = []float32{-2, 2}
+			}
+			return &llm.ClassifyResponse{Labels: []string{"negative", "positive"}, Logits: logits}, nil
+		},
+	}
+
+	s := Server{
+		sched: &Scheduler{
+			pendingReqCh:  make(chan *LlmRequest, 1),
+			finishedReqCh: make(chan *LlmRequest, 1),
+			expiredCh:     make(chan *runnerRef, 1),
+			unloadedCh:    make(chan any, 1),
+			loaded:        make(map[string]*runnerRef),
+			newServerFn:   newMockServer(&mock),
+			getGpuFn:      discover.GetGPUInfo,
+			getCpuFn:      discover.GetCPUInfo,
+			reschedDelay:  250 * time.Millisecond,
+			loadFn: func(req *LlmRequest, _ *ggml.GGML, _ discover.GpuInfoList, _ int) {
+				// add small delay to simulate loading
+				time.Sleep(time.Millisecond)
+				req.successCh <- &runnerRef{
+					llama: &mock,
+				}
+			},
+		},
+	}
+
+	go s.sched.Run(t.Context())
+
+	create := func(t *testing.T, name string, kv ggml.KV) {
+		t.Helper()
+
+		_, digest := createBinFile(t, kv, []ggml.Tensor{
+			{Name: "token_embd.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		})
+
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:  name,
+			Files:  map[string]string{"file.gguf": digest},
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	kv := ggml.KV{
+		"general.architecture":       "llama",
+		"llama.block_count":          uint32(1),
+		"llama.context_length":       uint32(2048),
+		"llama.embedding_length":     uint32(1024),
+		"llama.attention.head_count": uint32(8),
+		"tokenizer.ggml.tokens":      []string{""},
+		"tokenizer.ggml.scores":      []float32{0},
+		"tokenizer.ggml.token_type":  []int32{0},
+	}
+
+	create(t, "test", kv)
+
+	kv["llama.classifier.output_labels"] = []string{"negative", "positive"}
+	create(t, "classifier", kv)
+
+	t.Run("invalid input", func(t *testing.T) {
+		w := createRequest(t, s.ClassifyHandler, api.ClassifyRequest{Model: "classifier", Input: []any{1}})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("missing model", func(t *testing.T) {
+		w := createRequest(t, s.ClassifyHandler, api.ClassifyRequest{Model: "missing", Input: "hi"})
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("not a classifier", func(t *testing.T) {
+		w := createRequest(t, s.ClassifyHandler, api.ClassifyRequest{Model: "test", Input: "hi"})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("generate with a classifier", func(t *testing.T) {
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{Model: "classifier", Prompt: "hi"})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("classify", func(t *testing.T) {
+		w := createRequest(t, s.ClassifyHandler, api.ClassifyRequest{Model: "classifier", Input: []any{"I love it", "I hate it"}})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.ClassifyResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(resp.Classifications) != 2 {
+			t.Fatalf("expected 2 classifications, got %d", len(resp.Classifications))
+		}
+
+		for i, label := range []string{"positive", "negative"} {
+			if top := resp.Classifications[i][0]; top.Label != label || top.Score < 0.98 || top.Logit != 2 {
+				t.Errorf("expected %s first, got %+v", label, resp.Classifications[i])
+			}
+		}
+
+		if resp.PromptEvalCount != 6 {
+			t.Errorf("expected 6 tokens, got %d", resp.PromptEvalCount)
+		}
+
+		if resp.Fingerprint == nil {
+			t.Error("expected a fingerprint")
+		}
+	})
+
+	t.Run("empty input", func(t *testing.T) {
+		w := createRequest(t, s.ClassifyHandler, api.ClassifyRequest{Model: "classifier"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.ClassifyResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Classifications == nil || len(resp.Classifications) != 0 {
+			t.Errorf("expected no classifications, got %v", resp.Classifications)
+		}
+	})
+}
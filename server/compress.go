@@ -0,0 +1,241 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressMinSize is the smallest response body worth compressing.
+const compressMinSize = 1024
+
+var (
+	gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+	zstdWriters = sync.Pool{New: func() any {
+		w, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		return w
+	}}
+)
+
+// negotiateEncoding picks the preferred content encoding the client accepts,
+// favoring zstd over gzip. It returns an empty string if neither is acceptable.
+func negotiateEncoding(header string) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+
+		accepted[name] = q > 0
+	}
+
+	for _, encoding := range []string{"zstd", "gzip"} {
+		if ok, set := accepted[encoding]; ok || (!set && accepted["*"]) {
+			return encoding
+		}
+	}
+
+	return ""
+}
+
+// compressible reports whether a response with the given content type should
+// be compressed. Streaming responses are exempt so each chunk reaches the
+// client as soon as it is produced.
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	switch mediaType {
+	case "application/x-ndjson", "text/event-stream":
+		return false
+	case "application/json":
+		return true
+	}
+
+	return strings.HasPrefix(mediaType, "text/")
+}
+
+// compressWriter buffers the start of a response until it knows whether the
+// body is large and compressible enough to encode.
+type compressWriter struct {
+	gin.ResponseWriter
+
+	encoding string
+	decided  bool
+	buf      []byte
+	w        interface {
+		io.WriteCloser
+		Flush() error
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		if !w.eligible() {
+			w.decide(false)
+			return w.ResponseWriter.Write(b)
+		}
+
+		w.buf = append(w.buf, b...)
+		if len(w.buf) < compressMinSize {
+			return len(b), nil
+		}
+
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+
+		return len(b), nil
+	}
+
+	if w.w != nil {
+		return w.w.Write(b)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *compressWriter) WriteHeaderNow() {
+	if !w.decided {
+		w.decide(false)
+	}
+
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *compressWriter) Written() bool {
+	return len(w.buf) > 0 || w.ResponseWriter.Written()
+}
+
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		// a handler flushing early is streaming, send what it has as is
+		w.decide(false)
+	}
+
+	if w.w != nil {
+		w.w.Flush()
+	}
+
+	w.ResponseWriter.Flush()
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *compressWriter) eligible() bool {
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+
+	switch status := w.Status(); {
+	case status < http.StatusOK, status == http.StatusNoContent, status == http.StatusNotModified:
+		return false
+	}
+
+	return compressible(h.Get("Content-Type"))
+}
+
+// decide commits to sending the response compressed or as is and writes out
+// any buffered body.
+func (w *compressWriter) decide(compress bool) error {
+	w.decided = true
+
+	if compress {
+		h := w.Header()
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", w.encoding)
+		h.Add("Vary", "Accept-Encoding")
+
+		switch w.encoding {
+		case "zstd":
+			zw := zstdWriters.Get().(*zstd.Encoder)
+			zw.Reset(w.ResponseWriter)
+			w.w = zw
+		case "gzip":
+			gw := gzipWriters.Get().(*gzip.Writer)
+			gw.Reset(w.ResponseWriter)
+			w.w = gw
+		}
+	}
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+
+	if w.w != nil {
+		_, err := w.w.Write(buf)
+		return err
+	}
+
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+// close sends whatever is still buffered and releases the encoder.
+func (w *compressWriter) close() error {
+	if !w.decided {
+		return w.decide(false)
+	}
+
+	if w.w == nil {
+		return nil
+	}
+
+	err := w.w.Close()
+	switch zw := w.w.(type) {
+	case *zstd.Encoder:
+		zw.Reset(nil)
+		zstdWriters.Put(zw)
+	case *gzip.Writer:
+		zw.Reset(nil)
+		gzipWriters.Put(zw)
+	}
+
+	w.w = nil
+	return err
+}
+
+// compressionMiddleware compresses non-streaming responses with zstd or gzip
+// when the client advertises support for them in Accept-Encoding.
+func compressionMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		encoding := negotiateEncoding(c.GetHeader("Accept-Encoding"))
+		if encoding == "" || c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+
+		w := &compressWriter{ResponseWriter: c.Writer, encoding: encoding}
+		c.Writer = w
+		defer func() {
+			w.close()
+			c.Writer = w.ResponseWriter
+		}()
+
+		c.Next()
+	}
+}
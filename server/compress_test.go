@@ -0,0 +1,141 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	cases := map[string]string{
+		"":                       "",
+		"identity":               "",
+		"gzip":                   "gzip",
+		"gzip, deflate, br":      "gzip",
+		"gzip, zstd":             "zstd",
+		"zstd;q=0, gzip":         "gzip",
+		"gzip;q=0.5, zstd;q=0.1": "zstd",
+		"GZIP":                   "gzip",
+		"*":                      "zstd",
+		"*, zstd;q=0":            "gzip",
+		"gzip;q=0":               "",
+	}
+
+	for header, expect := range cases {
+		if actual := negotiateEncoding(header); actual != expect {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", header, actual, expect)
+		}
+	}
+}
+
+func TestCompressionMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	large := strings.Repeat("ollama ", 1024)
+
+	r := gin.New()
+	r.Use(compressionMiddleware())
+	r.GET("/large", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"content": large}) })
+	r.GET("/small", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"content": "ollama"}) })
+	r.GET("/stream", func(c *gin.Context) {
+		ch := make(chan any, 1)
+		ch <- api.ProgressResponse{Status: large}
+		close(ch)
+		streamResponse(c, ch)
+	})
+	r.GET("/error", func(c *gin.Context) { c.AbortWithStatus(http.StatusNotFound) })
+
+	decode := map[string]func(io.Reader) ([]byte, error){
+		"gzip": func(r io.Reader) ([]byte, error) {
+			gr, err := gzip.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return io.ReadAll(gr)
+		},
+		"zstd": func(r io.Reader) ([]byte, error) {
+			zr, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			defer zr.Close()
+			return io.ReadAll(zr)
+		},
+		"": io.ReadAll,
+	}
+
+	cases := []struct {
+		path           string
+		acceptEncoding string
+		status         int
+		encoding       string
+		contains       string
+	}{
+		{"/large", "", http.StatusOK, "", large},
+		{"/large", "gzip", http.StatusOK, "gzip", large},
+		{"/large", "gzip, zstd", http.StatusOK, "zstd", large},
+		{"/small", "gzip", http.StatusOK, "", "ollama"},
+		{"/stream", "gzip, zstd", http.StatusOK, "", large},
+		{"/error", "gzip", http.StatusNotFound, "", ""},
+	}
+
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	// disable transparent decompression to inspect the encoded body
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+
+	for _, tt := range cases {
+		t.Run(tt.path+" "+tt.acceptEncoding, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, ts.URL+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Fatalf("expected status %d, got %d", tt.status, resp.StatusCode)
+			}
+
+			if actual := resp.Header.Get("Content-Encoding"); actual != tt.encoding {
+				t.Fatalf("expected Content-Encoding %q, got %q", tt.encoding, actual)
+			}
+
+			raw, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			body, err := decode[tt.encoding](bytes.NewReader(raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.Contains(string(body), tt.contains) {
+				t.Errorf("body does not contain expected content")
+			}
+
+			if tt.encoding != "" && len(raw) >= len(body) {
+				t.Errorf("expected compressed body to be smaller: %d >= %d", len(raw), len(body))
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+// corsHeaders are the request headers allowed for every origin.
+var corsHeaders = []string{
+	"Authorization",
+	"Content-Type",
+	"User-Agent",
+	"Accept",
+	"X-Requested-With",
+
+	// OpenAI compatibility headers
+	"x-stainless-lang",
+	"x-stainless-package-version",
+	"x-stainless-os",
+	"x-stainless-arch",
+	"x-stainless-retry-count",
+	"x-stainless-runtime",
+	"x-stainless-runtime-version",
+	"x-stainless-async",
+	"x-stainless-helper-method",
+	"x-stainless-poll-helper",
+	"x-stainless-custom-poll-interval",
+	"x-stainless-timeout",
+}
+
+// corsRule is a CORS policy for a set of origins, as read from the file
+// named by OLLAMA_CORS_CONFIG.
+type corsRule struct {
+	Origins          []string `json:"origins"`
+	Methods          []string `json:"methods,omitempty"`
+	Headers          []string `json:"headers,omitempty"`
+	ExposeHeaders    []string `json:"expose_headers,omitempty"`
+	AllowCredentials bool     `json:"allow_credentials,omitempty"`
+	MaxAge           int      `json:"max_age,omitempty"`
+}
+
+type corsPolicy struct {
+	Rules []corsRule `json:"rules"`
+}
+
+func splitList(s string) (list []string) {
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+// config converts the rule into a gin-contrib/cors configuration. Methods
+// replace the defaults while headers extend them so ollama clients keep working.
+func (r corsRule) config() (cors.Config, error) {
+	config := cors.DefaultConfig()
+	config.AllowWildcard = true
+	config.AllowBrowserExtensions = true
+	config.AllowOrigins = r.Origins
+	config.AllowHeaders = append(slices.Clone(corsHeaders), r.Headers...)
+	config.ExposeHeaders = r.ExposeHeaders
+	config.AllowCredentials = r.AllowCredentials
+	if len(r.Methods) > 0 {
+		config.AllowMethods = r.Methods
+	}
+
+	if r.MaxAge > 0 {
+		config.MaxAge = time.Duration(r.MaxAge) * time.Second
+	}
+
+	if r.AllowCredentials && slices.Contains(r.Origins, "*") {
+		return config, errors.New("allow_credentials cannot be combined with the \"*\" origin")
+	}
+
+	for _, origin := range r.Origins {
+		if strings.Count(origin, "*") > 1 {
+			return config, fmt.Errorf("origin %q: only one \"*\" is allowed", origin)
+		}
+	}
+
+	return config, config.Validate()
+}
+
+// matchOrigin reports whether origin matches pattern, which may contain a
+// single "*" wildcard.
+func matchOrigin(pattern, origin string) bool {
+	pattern, origin = strings.ToLower(pattern), strings.ToLower(origin)
+	if pattern == "*" {
+		return true
+	}
+
+	prefix, suffix, ok := strings.Cut(pattern, "*")
+	if !ok {
+		return pattern == origin
+	}
+
+	return len(origin) >= len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix)
+}
+
+// corsMiddleware applies the first rule from OLLAMA_CORS_CONFIG that matches the
+// request origin. Requests from other origins fall back to the default rule built
+// from OLLAMA_ORIGINS, OLLAMA_CORS_METHODS, OLLAMA_CORS_HEADERS and
+// OLLAMA_CORS_CREDENTIALS, which only admits local origins unless configured otherwise.
+func corsMiddleware() (gin.HandlerFunc, error) {
+	var policy corsPolicy
+	if name := envconfig.CORSConfig(); name != "" {
+		bts, err := os.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("cors config: %w", err)
+		}
+
+		if err := json.Unmarshal(bts, &policy); err != nil {
+			return nil, fmt.Errorf("cors config %s: %w", name, err)
+		}
+	}
+
+	type handler struct {
+		origins []string
+		fn      gin.HandlerFunc
+	}
+
+	var handlers []handler
+	for i, rule := range policy.Rules {
+		if len(rule.Origins) == 0 {
+			return nil, fmt.Errorf("cors config: rule %d has no origins", i)
+		}
+
+		config, err := rule.config()
+		if err != nil {
+			return nil, fmt.Errorf("cors config: rule %d: %w", i, err)
+		}
+
+		handlers = append(handlers, handler{rule.Origins, cors.New(config)})
+	}
+
+	config, err := corsRule{
+		Origins:          envconfig.AllowedOrigins(),
+		Methods:          splitList(envconfig.CORSMethods()),
+		Headers:          splitList(envconfig.CORSHeaders()),
+		AllowCredentials: envconfig.CORSCredentials(),
+	}.config()
+	if err != nil {
+		return nil, fmt.Errorf("cors: %w", err)
+	}
+
+	fallback := cors.New(config)
+	if len(handlers) == 0 {
+		return fallback, nil
+	}
+
+	return func(c *gin.Context) {
+		if origin := c.Request.Header.Get("Origin"); origin != "" {
+			for _, h := range handlers {
+				if slices.ContainsFunc(h.origins, func(pattern string) bool { return matchOrigin(pattern, origin) }) {
+					h.fn(c)
+					return
+				}
+			}
+		}
+
+		fallback(c)
+	}, nil
+}
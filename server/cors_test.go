@@ -0,0 +1,132 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMatchOrigin(t *testing.T) {
+	cases := []struct {
+		pattern, origin string
+		expect          bool
+	}{
+		{"*", "https://example.com", true},
+		{"https://example.com", "https://example.com", true},
+		{"https://example.com", "https://EXAMPLE.com", true},
+		{"https://example.com", "https://example.com.evil.net", false},
+		{"http://localhost:*", "http://localhost:3000", true},
+		{"http://localhost:*", "http://localhost", false},
+		{"https://*.example.com", "https://app.example.com", true},
+		{"https://*.example.com", "https://example.com", false},
+		{"https://*.example.com", "https://evil.net", false},
+	}
+
+	for _, tt := range cases {
+		if actual := matchOrigin(tt.pattern, tt.origin); actual != tt.expect {
+			t.Errorf("matchOrigin(%q, %q) = %v, want %v", tt.pattern, tt.origin, actual, tt.expect)
+		}
+	}
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	config := filepath.Join(t.TempDir(), "cors.json")
+	if err := os.WriteFile(config, []byte(`{
+		"rules": [
+			{"origins": ["https://app.example.com"], "methods": ["GET", "POST"], "headers": ["X-Custom"], "allow_credentials": true},
+			{"origins": ["https://*.example.net"], "methods": ["GET"]}
+		]
+	}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("OLLAMA_CORS_CONFIG", config)
+	t.Setenv("OLLAMA_ORIGINS", "")
+
+	h, err := corsMiddleware()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.Use(h)
+	r.Any("/api/tags", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	cases := []struct {
+		name        string
+		method      string
+		origin      string
+		status      int
+		allowOrigin string
+		credentials string
+		methods     string
+	}{
+		{"no origin", http.MethodGet, "", http.StatusOK, "", "", ""},
+		{"localhost", http.MethodGet, "http://localhost:3000", http.StatusOK, "http://localhost:3000", "", ""},
+		{"remote", http.MethodGet, "https://evil.com", http.StatusForbidden, "", "", ""},
+		{"rule", http.MethodGet, "https://app.example.com", http.StatusOK, "https://app.example.com", "true", ""},
+		{"rule preflight", http.MethodOptions, "https://app.example.com", http.StatusNoContent, "https://app.example.com", "true", "GET,POST"},
+		{"wildcard rule", http.MethodOptions, "https://chat.example.net", http.StatusNoContent, "https://chat.example.net", "", "GET"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/tags", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+
+			if tt.method == http.MethodOptions {
+				req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+			}
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, w.Code)
+			}
+
+			if actual := w.Header().Get("Access-Control-Allow-Origin"); actual != tt.allowOrigin {
+				t.Errorf("expected Access-Control-Allow-Origin %q, got %q", tt.allowOrigin, actual)
+			}
+
+			if actual := w.Header().Get("Access-Control-Allow-Credentials"); actual != tt.credentials {
+				t.Errorf("expected Access-Control-Allow-Credentials %q, got %q", tt.credentials, actual)
+			}
+
+			if actual := w.Header().Get("Access-Control-Allow-Methods"); actual != tt.methods {
+				t.Errorf("expected Access-Control-Allow-Methods %q, got %q", tt.methods, actual)
+			}
+		})
+	}
+}
+
+func TestCORSMiddlewareInvalid(t *testing.T) {
+	cases := map[string]string{
+		"no origins":          `{"rules": [{"methods": ["GET"]}]}`,
+		"wildcard with creds": `{"rules": [{"origins": ["*"], "allow_credentials": true}]}`,
+		"two wildcards":       `{"rules": [{"origins": ["https://*.*.example.com"]}]}`,
+		"malformed":           `{"rules": [`,
+	}
+
+	for name, content := range cases {
+		t.Run(name, func(t *testing.T) {
+			config := filepath.Join(t.TempDir(), "cors.json")
+			if err := os.WriteFile(config, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			t.Setenv("OLLAMA_CORS_CONFIG", config)
+			if _, err := corsMiddleware(); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
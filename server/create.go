@@ -10,8 +10,10 @@ import (
 	"io"
 	"io/fs"
 	"log/slog"
+	"maps"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"slices"
 	"strings"
@@ -33,6 +35,7 @@ var (
 	errNoFilesProvided         = errors.New("no files provided to convert")
 	errOnlyOneAdapterSupported = errors.New("only one adapter is currently supported")
 	errOnlyGGUFSupported       = errors.New("supplied file was not in GGUF format")
+	errBadQuantizeTensors      = errors.New("invalid quantize_tensors")
 	errUnknownType             = errors.New("unknown type")
 	errNeitherFromOrFiles      = errors.New("neither 'from' or 'files' was specified")
 	errFilePath                = errors.New("file path must be relative")
@@ -70,15 +73,25 @@ func (s *Server) CreateHandler(c *gin.Context) {
 	ch := make(chan any)
 	go func() {
 		defer close(ch)
+		defer recoverStream(c, ch)
 		fn := func(resp api.ProgressResponse) {
 			ch <- resp
 		}
 
+		// keep the new blobs from being removed before the manifest that
+		// uses them is written
+		unlock, err := lockStore(c.Request.Context())
+		if err != nil {
+			ch <- gin.H{"error": err.Error()}
+			return
+		}
+		defer func() { unlock() }()
+
 		oldManifest, _ := ParseNamedManifest(name)
 
 		var baseLayers []*layerGGML
 		if r.From != "" {
-			slog.Debug("create model from model name")
+			slog.DebugContext(c.Request.Context(), "create model from model name")
 			fromName := model.ParseName(r.From)
 			if !fromName.IsValid() {
 				ch <- gin.H{"error": errtypes.InvalidModelNameErrMsg, "status": http.StatusBadRequest}
@@ -95,7 +108,7 @@ func (s *Server) CreateHandler(c *gin.Context) {
 		} else if r.Files != nil {
 			baseLayers, err = convertModelFromFiles(r.Files, baseLayers, false, fn)
 			if err != nil {
-				for _, badReq := range []error{errNoFilesProvided, errOnlyGGUFSupported, errUnknownType} {
+				for _, badReq := range []error{errNoFilesProvided, errOnlyGGUFSupported, errUnknownType, ggml.ErrMalformed} {
 					if errors.Is(err, badReq) {
 						ch <- gin.H{"error": err.Error(), "status": http.StatusBadRequest}
 						return
@@ -113,7 +126,7 @@ func (s *Server) CreateHandler(c *gin.Context) {
 		if r.Adapters != nil {
 			adapterLayers, err = convertModelFromFiles(r.Adapters, baseLayers, true, fn)
 			if err != nil {
-				for _, badReq := range []error{errNoFilesProvided, errOnlyOneAdapterSupported, errOnlyGGUFSupported, errUnknownType, errFilePath} {
+				for _, badReq := range []error{errNoFilesProvided, errOnlyOneAdapterSupported, errOnlyGGUFSupported, errUnknownType, errFilePath, ggml.ErrMalformed} {
 					if errors.Is(err, badReq) {
 						ch <- gin.H{"error": err.Error(), "status": http.StatusBadRequest}
 						return
@@ -129,7 +142,7 @@ func (s *Server) CreateHandler(c *gin.Context) {
 		}
 
 		if err := createModel(r, name, baseLayers, fn); err != nil {
-			if errors.Is(err, errBadTemplate) {
+			if errors.Is(err, errBadTemplate) || errors.Is(err, errBadQuantizeTensors) {
 				ch <- gin.H{"error": err.Error(), "status": http.StatusBadRequest}
 				return
 			}
@@ -137,12 +150,20 @@ func (s *Server) CreateHandler(c *gin.Context) {
 			return
 		}
 
+		unlock()
+		unlock = func() {}
+
 		if !envconfig.NoPrune() && oldManifest != nil {
 			if err := oldManifest.RemoveLayers(); err != nil {
 				ch <- gin.H{"error": err.Error()}
 			}
 		}
 
+		if err := storeModel(c.Request.Context(), name, fn); err != nil {
+			ch <- gin.H{"error": err.Error()}
+			return
+		}
+
 		ch <- api.ProgressResponse{Status: "success"}
 	}()
 
@@ -170,6 +191,11 @@ func convertModelFromFiles(files map[string]string, baseLayers []*layerGGML, isA
 			return nil, errOnlyOneAdapterSupported
 		}
 
+		files, err := mergeShardFiles(files, fn)
+		if err != nil {
+			return nil, err
+		}
+
 		var digest string
 		var allLayers []*layerGGML
 		for _, v := range files {
@@ -325,21 +351,30 @@ func createModel(r api.CreateRequest, name model.Name, baseLayers []*layerGGML,
 		},
 	}
 
+	quantType := strings.ToUpper(cmp.Or(r.Quantize, r.Quantization))
+	if quantType == "" && len(r.QuantizeTensors) > 0 {
+		return fmt.Errorf("%w: quantize is required", errBadQuantizeTensors)
+	}
+
 	var layers []Layer
 	for _, layer := range baseLayers {
 		if layer.GGML != nil {
-			quantType := strings.ToUpper(cmp.Or(r.Quantize, r.Quantization))
 			if quantType != "" && layer.GGML.Name() == "gguf" && layer.MediaType == "application/vnd.ollama.image.model" {
 				want, err := ggml.ParseFileType(quantType)
 				if err != nil {
 					return err
 				}
 
+				tensorTypes, err := quantizeTensorTypes(layer.GGML.Tensors(), r.QuantizeTensors)
+				if err != nil {
+					return err
+				}
+
 				ft := layer.GGML.KV().FileType()
 				if !slices.Contains([]string{"F16", "F32"}, ft.String()) {
 					return errors.New("quantization is only supported for F16 and F32 models")
-				} else if ft != want {
-					layer, err = quantizeLayer(layer, quantType, fn)
+				} else if ft != want || len(tensorTypes) > 0 {
+					layer, err = quantizeLayer(layer, quantType, tensorTypes, fn)
 					if err != nil {
 						return err
 					}
@@ -415,6 +450,10 @@ func createModel(r api.CreateRequest, name model.Name, baseLayers []*layerGGML,
 		}
 	}
 
+	if err := prepareModelFiles(layers, fn); err != nil {
+		return err
+	}
+
 	fn(api.ProgressResponse{Status: "writing manifest"})
 	if err := WriteManifest(name, *configLayer, layers); err != nil {
 		return err
@@ -423,9 +462,53 @@ func createModel(r api.CreateRequest, name model.Name, baseLayers []*layerGGML,
 	return nil
 }
 
-func quantizeLayer(layer *layerGGML, quantizeType string, fn func(resp api.ProgressResponse)) (*layerGGML, error) {
+// quantizeTensorTypes returns the types that the tensors matching the
+// patterns of a create request's quantize_tensors are quantized to, by the
+// names of the tensors. The longest pattern that matches a tensor applies,
+// and every pattern must match a tensor.
+func quantizeTensorTypes(ts ggml.Tensors, overrides map[string]string) (map[string]uint32, error) {
+	patterns := slices.Collect(maps.Keys(overrides))
+	slices.SortFunc(patterns, func(a, b string) int {
+		return cmp.Or(cmp.Compare(len(b), len(a)), strings.Compare(a, b))
+	})
+
+	types := make(map[string]uint32)
+	for _, pattern := range patterns {
+		t, err := ggml.ParseTensorType(overrides[pattern])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errBadQuantizeTensors, err)
+		}
+
+		var matched bool
+		for _, tensor := range ts.Items() {
+			ok, err := path.Match(pattern, tensor.Name)
+			if err != nil {
+				return nil, fmt.Errorf("%w: pattern %q: %w", errBadQuantizeTensors, pattern, err)
+			} else if !ok {
+				continue
+			}
+
+			matched = true
+			if _, ok := types[tensor.Name]; !ok {
+				types[tensor.Name] = t
+			}
+		}
+
+		if !matched {
+			return nil, fmt.Errorf("%w: pattern %q matches no tensors", errBadQuantizeTensors, pattern)
+		}
+	}
+
+	return types, nil
+}
+
+func quantizeLayer(layer *layerGGML, quantizeType string, tensorTypes map[string]uint32, fn func(resp api.ProgressResponse)) (*layerGGML, error) {
 	ft := layer.GGML.KV().FileType()
-	fn(api.ProgressResponse{Status: fmt.Sprintf("quantizing %s model to %s", ft, quantizeType)})
+	status := fmt.Sprintf("quantizing %s model to %s", ft, quantizeType)
+	if len(tensorTypes) > 0 {
+		status += fmt.Sprintf(" with %d tensor overrides", len(tensorTypes))
+	}
+	fn(api.ProgressResponse{Status: status})
 
 	want, err := ggml.ParseFileType(quantizeType)
 	if err != nil {
@@ -444,7 +527,7 @@ func quantizeLayer(layer *layerGGML, quantizeType string, fn func(resp api.Progr
 	defer temp.Close()
 	defer os.Remove(temp.Name())
 
-	if err := llama.Quantize(blob, temp.Name(), uint32(want)); err != nil {
+	if err := llama.Quantize(blob, temp.Name(), uint32(want), tensorTypes); err != nil {
 		return nil, err
 	}
 
@@ -652,7 +735,7 @@ func setMessages(layers []Layer, m []api.Message) ([]Layer, error) {
 		return layers, nil
 	}
 
-	fmt.Printf("removing old messages\n")
+	slog.Debug("removing old messages")
 	layers = removeLayer(layers, "application/vnd.ollama.image.messages")
 	var b bytes.Buffer
 	if err := json.NewEncoder(&b).Encode(m); err != nil {
@@ -9,7 +9,10 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
+
 	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/fs/ggml"
 )
 
 func TestConvertFromSafetensors(t *testing.T) {
@@ -104,3 +107,56 @@ func TestConvertFromSafetensors(t *testing.T) {
 		})
 	}
 }
+
+func TestQuantizeTensorTypes(t *testing.T) {
+	var tensors []ggml.Tensor
+	for _, name := range []string{"token_embd.weight", "blk.0.attn_q.weight", "blk.0.attn_v.weight", "blk.0.ffn_down.weight", "blk.1.attn_v.weight", "output.weight"} {
+		tensors = append(tensors, ggml.Tensor{Name: name, Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))})
+	}
+
+	p, _ := createBinFile(t, ggml.KV{"general.architecture": "llama"}, tensors)
+	f, err := os.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	g, _, err := ggml.Decode(f, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("longest pattern applies", func(t *testing.T) {
+		got, err := quantizeTensorTypes(g.Tensors(), map[string]string{
+			"blk.*":               "q4_K",
+			"blk.*.attn_v.weight": "q6_K",
+			"output.weight":       "Q8_0",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := map[string]uint32{
+			"blk.0.attn_q.weight":   12,
+			"blk.0.attn_v.weight":   14,
+			"blk.0.ffn_down.weight": 12,
+			"blk.1.attn_v.weight":   14,
+			"output.weight":         8,
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("types mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for _, overrides := range []map[string]string{
+			{"blk.*": "iq2_xxs"},
+			{"blk.[": "q4_K"},
+			{"mm.*": "q4_K"},
+		} {
+			if _, err := quantizeTensorTypes(g.Tensors(), overrides); !errors.Is(err, errBadQuantizeTensors) {
+				t.Errorf("%v: expected %v, got %v", overrides, errBadQuantizeTensors, err)
+			}
+		}
+	})
+}
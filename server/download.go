@@ -6,7 +6,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log/slog"
 	"math"
 	"math/rand/v2"
 	"net/http"
@@ -24,8 +23,12 @@ import (
 
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/format"
+	"github.com/ollama/ollama/logutil"
 )
 
+// registryLog logs pulls and pushes at the level of the registry subsystem
+var registryLog = logutil.Subsystem(logutil.Registry)
+
 const maxRetries = 6
 
 var (
@@ -41,6 +44,7 @@ type blobDownload struct {
 
 	Total     int64
 	Completed atomic.Int64
+	Retries   atomic.Int32
 
 	Parts []*blobDownloadPart
 
@@ -49,6 +53,9 @@ type blobDownload struct {
 	done       chan struct{}
 	err        error
 	references atomic.Int32
+
+	// unlock releases the lock of the blob once it is downloaded
+	unlock func()
 }
 
 type blobDownloadPart struct {
@@ -173,7 +180,7 @@ func (b *blobDownload) Prepare(ctx context.Context, requestURL *url.URL, opts *r
 	}
 
 	if len(b.Parts) > 0 {
-		slog.Info(fmt.Sprintf("downloading %s in %d %s part(s)", b.Digest[7:19], len(b.Parts), format.HumanBytes(b.Parts[0].Size)))
+		registryLog.Info(fmt.Sprintf("downloading %s in %d %s part(s)", b.Digest[7:19], len(b.Parts), format.HumanBytes(b.Parts[0].Size)))
 	}
 
 	return nil
@@ -181,6 +188,9 @@ func (b *blobDownload) Prepare(ctx context.Context, requestURL *url.URL, opts *r
 
 func (b *blobDownload) Run(ctx context.Context, requestURL *url.URL, opts *registryOptions) {
 	defer close(b.done)
+	if b.unlock != nil {
+		defer b.unlock()
+	}
 	b.err = b.run(ctx, requestURL, opts)
 }
 
@@ -252,7 +262,7 @@ func (b *blobDownload) run(ctx context.Context, requestURL *url.URL, opts *regis
 
 			resp, err := makeRequestWithRetry(ctx, http.MethodGet, requestURL, nil, nil, newOpts)
 			if err != nil {
-				slog.Warn("failed to get direct URL; backing off and retrying", "err", err)
+				registryLog.Warn("failed to get direct URL; backing off and retrying", "err", err)
 				if err := backoff(ctx); err != nil {
 					return nil, err
 				}
@@ -287,11 +297,13 @@ func (b *blobDownload) run(ctx context.Context, requestURL *url.URL, opts *regis
 					// return immediately if the context is canceled or the device is out of space
 					return err
 				case errors.Is(err, errPartStalled):
+					b.Retries.Add(1)
 					try--
 					continue
 				case err != nil:
+					b.Retries.Add(1)
 					sleep := time.Second * time.Duration(math.Pow(2, float64(try)))
-					slog.Info(fmt.Sprintf("%s part %d attempt %d failed: %v, retrying in %s", b.Digest[7:19], part.N, try, err, sleep))
+					registryLog.Info(fmt.Sprintf("%s part %d attempt %d failed: %v, retrying in %s", b.Digest[7:19], part.N, try, err, sleep))
 					time.Sleep(sleep)
 					continue
 				default:
@@ -339,7 +351,7 @@ func (b *blobDownload) downloadChunk(ctx context.Context, requestURL *url.URL, w
 		}
 		defer resp.Body.Close()
 
-		n, err := io.CopyN(w, io.TeeReader(resp.Body, part), part.Size-part.Completed.Load())
+		n, err := io.CopyN(w, io.TeeReader(downloadLimit.reader(ctx, resp.Body), part), part.Size-part.Completed.Load())
 		if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, io.ErrUnexpectedEOF) {
 			// rollback progress
 			b.Completed.Add(-n)
@@ -370,7 +382,7 @@ func (b *blobDownload) downloadChunk(ctx context.Context, requestURL *url.URL, w
 
 				if !lastUpdated.IsZero() && time.Since(lastUpdated) > 30*time.Second {
 					const msg = "%s part %d stalled; retrying. If this persists, press ctrl-c to exit, then 'ollama pull' to find a faster connection."
-					slog.Info(fmt.Sprintf(msg, b.Digest[7:19], part.N))
+					registryLog.Info(fmt.Sprintf(msg, b.Digest[7:19], part.N))
 					// reset last updated
 					part.lastUpdatedMu.Lock()
 					part.lastUpdated = time.Time{}
@@ -436,17 +448,23 @@ func (b *blobDownload) Wait(ctx context.Context, fn func(api.ProgressResponse))
 	b.acquire()
 	defer b.release()
 
+	var speed transferSpeed
 	ticker := time.NewTicker(60 * time.Millisecond)
 	for {
 		select {
 		case <-b.done:
 			return b.err
 		case <-ticker.C:
+			now := time.Now()
+			completed := b.Completed.Load()
 			fn(api.ProgressResponse{
 				Status:    fmt.Sprintf("pulling %s", b.Digest[7:19]),
 				Digest:    b.Digest,
 				Total:     b.Total,
-				Completed: b.Completed.Load(),
+				Completed: completed,
+				Rate:      downloadLimit.rate(now),
+				Speed:     speed.update(completed, now),
+				Retries:   int(b.Retries.Load()),
 			})
 		case <-ctx.Done():
 			return ctx.Err()
@@ -454,6 +472,38 @@ func (b *blobDownload) Wait(ctx context.Context, fn func(api.ProgressResponse))
 	}
 }
 
+// transferSpeed estimates the speed of a transfer from its progress as an
+// exponentially weighted moving average, so that it doesn't jump around with
+// each update.
+type transferSpeed struct {
+	completed int64
+	at        time.Time
+	speed     float64
+}
+
+// transferSpeedWindow is about how far back the speed of a transfer reaches
+const transferSpeedWindow = 3 * time.Second
+
+// update records that completed bytes were transferred by now and returns
+// the speed in bytes per second.
+func (s *transferSpeed) update(completed int64, now time.Time) float64 {
+	if s.at.IsZero() {
+		s.completed, s.at = completed, now
+		return 0
+	}
+
+	elapsed := now.Sub(s.at)
+	if elapsed <= 0 {
+		return s.speed
+	}
+
+	current := float64(completed-s.completed) / elapsed.Seconds()
+	weight := 1 - math.Exp(-elapsed.Seconds()/transferSpeedWindow.Seconds())
+	s.speed += weight * (current - s.speed)
+	s.completed, s.at = completed, now
+	return s.speed
+}
+
 type downloadOpts struct {
 	mp      ModelPath
 	digest  string
@@ -487,9 +537,36 @@ func downloadBlob(ctx context.Context, opts downloadOpts) (cacheHit bool, _ erro
 	data, ok := blobDownloadManager.LoadOrStore(opts.digest, &blobDownload{Name: fp, Digest: opts.digest})
 	download := data.(*blobDownload)
 	if !ok {
+		// only one server sharing the model store downloads the blob at a
+		// time, and the others use it once it is done
+		unlock, err := lockFile(ctx, fp+"-lock", true)
+		if err != nil {
+			blobDownloadManager.Delete(opts.digest)
+			return false, err
+		}
+
+		if fi, err := os.Stat(fp); err == nil {
+			unlock()
+			download.done = make(chan struct{})
+			close(download.done)
+			blobDownloadManager.Delete(opts.digest)
+
+			opts.fn(api.ProgressResponse{
+				Status:    fmt.Sprintf("pulling %s", opts.digest[7:19]),
+				Digest:    opts.digest,
+				Total:     fi.Size(),
+				Completed: fi.Size(),
+			})
+
+			return true, nil
+		}
+
+		download.unlock = unlock
+
 		requestURL := opts.mp.BaseURL()
 		requestURL = requestURL.JoinPath("v2", opts.mp.GetNamespaceRepository(), "blobs", opts.digest)
 		if err := download.Prepare(ctx, requestURL, opts.regOpts); err != nil {
+			unlock()
 			blobDownloadManager.Delete(opts.digest)
 			return false, err
 		}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestTransferSpeed(t *testing.T) {
+	var s transferSpeed
+	start := time.Now()
+	if speed := s.update(0, start); speed != 0 {
+		t.Fatalf("expected no speed before any progress, got %f", speed)
+	}
+
+	// a steady 1 MB/s converges on 1 MB/s
+	var speed float64
+	for i := 1; i <= 300; i++ {
+		speed = s.update(int64(i)*100_000, start.Add(time.Duration(i)*100*time.Millisecond))
+	}
+
+	if math.Abs(speed-1_000_000) > 1_000 {
+		t.Errorf("expected about 1000000 bytes/s, got %f", speed)
+	}
+
+	// a stall slows it down gradually rather than at once
+	speed = s.update(300*100_000, start.Add(30100*time.Millisecond))
+	if speed <= 0 || speed >= 1_000_000 {
+		t.Errorf("expected the speed to drop gradually, got %f", speed)
+	}
+
+	if again := s.update(300*100_000, start.Add(30100*time.Millisecond)); again != speed {
+		t.Errorf("expected the same speed without time passing, got %f and %f", speed, again)
+	}
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/types/model"
+)
+
+// EmbedBatchHandler embeds many inputs with a single request to the runner,
+// which processes them in as few batches as its parallel sequences allow.
+// Unlike [Server.EmbedHandler], inputs that fail are reported in their result
+// rather than failing the request, so that indexing can skip them.
+func (s *Server) EmbedBatchHandler(c *gin.Context) {
+	checkpointStart := time.Now()
+	var req api.EmbedBatchRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Dimensions < 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "dimensions must be positive"})
+		return
+	}
+
+	if n := envconfig.MaxEmbedBatch(); uint(len(req.Inputs)) > n {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("too many inputs: %d, the maximum is %d", len(req.Inputs), n)})
+		return
+	}
+
+	name, err := getExistingName(model.ParseName(req.Model))
+	if err != nil {
+		c.JSON(http.StatusNotFound, api.ErrorResponse{Error: fmt.Sprintf("model '%s' not found", req.Model), Code: api.ErrorCodeModelNotFound})
+		return
+	}
+
+	r, m, opts, _, err := s.scheduleRunner(c.Request.Context(), name.String(), []Capability{}, req.Options, req.KeepAlive)
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	checkpointLoaded := time.Now()
+
+	if len(req.Inputs) == 0 {
+		c.JSON(http.StatusOK, api.EmbedBatchResponse{Model: req.Model, Results: []api.EmbedBatchResult{}})
+		return
+	}
+
+	kvData, _, err := getModelData(m.ModelPath, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := validateDimensions(kvData, req.Dimensions); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s %s", req.Model, err)})
+		return
+	}
+
+	ctxLen := min(opts.NumCtx, int(kvData.ContextLength()))
+	truncate := req.Truncate == nil || *req.Truncate
+	norm := req.Normalize == nil || *req.Normalize
+
+	results := make([]api.EmbedBatchResult, len(req.Inputs))
+
+	// texts are the inputs sent to the runner, which are those that could
+	// be tokenized, and indices are their positions in the request
+	var texts []string
+	var indices []int
+	var count int
+	for i, input := range req.Inputs {
+		tokens, err := r.Tokenize(c.Request.Context(), input)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+
+		if len(tokens) > ctxLen {
+			if !truncate {
+				results[i].Error = "input length exceeds maximum context length"
+				continue
+			}
+
+			tokens = tokens[:ctxLen]
+			input, err = r.Detokenize(c.Request.Context(), tokens)
+			if err != nil {
+				results[i].Error = err.Error()
+				continue
+			}
+		}
+
+		count += len(tokens)
+		texts = append(texts, input)
+		indices = append(indices, i)
+	}
+
+	if len(texts) > 0 {
+		embeddings, err := r.Embeddings(c.Request.Context(), texts)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": strings.TrimSpace(err.Error())})
+			return
+		}
+
+		for k, e := range embeddings {
+			i := indices[k]
+			if e.Error != "" {
+				results[i].Error = strings.TrimSpace(e.Error)
+				continue
+			}
+
+			results[i].Embedding = reduceEmbedding(e.Embedding, req.Dimensions, norm)
+		}
+	}
+
+	c.JSON(http.StatusOK, api.EmbedBatchResponse{
+		Model:           req.Model,
+		Results:         results,
+		TotalDuration:   time.Since(checkpointStart),
+		LoadDuration:    checkpointLoaded.Sub(checkpointStart),
+		PromptEvalCount: count,
+		Fingerprint:     fingerprint(m, "", r),
+	})
+}
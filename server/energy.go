@@ -0,0 +1,271 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/llm"
+)
+
+// energyMonitor samples the power draw and temperature of the GPUs while
+// requests are running and adds up the energy each GPU used. Requests that run
+// at the same time on the same GPU are each charged for all of its energy.
+type energyMonitor struct {
+	sampleFn func() []discover.GpuTelemetry
+	interval time.Duration
+
+	mu      sync.Mutex
+	spans   map[*energySpan]struct{}
+	stop    chan struct{}
+	sampled time.Time
+	gpus    map[string]*gpuEnergy
+}
+
+type gpuEnergy struct {
+	discover.GpuTelemetry
+
+	// Joules is the energy used while requests were running
+	Joules float64
+}
+
+func newEnergyMonitor(sampleFn func() []discover.GpuTelemetry) *energyMonitor {
+	return &energyMonitor{
+		sampleFn: sampleFn,
+		interval: 250 * time.Millisecond,
+		spans:    make(map[*energySpan]struct{}),
+		gpus:     make(map[string]*gpuEnergy),
+	}
+}
+
+func gpuKey(library, id string) string {
+	return library + "/" + id
+}
+
+// sampleLocked records the power draw and temperature of each GPU. While
+// requests are running, the energy used since the previous sample is added
+// to each GPU. m.mu must be held.
+func (m *energyMonitor) sampleLocked() {
+	now := time.Now()
+	integrate := len(m.spans) > 0 && !m.sampled.IsZero()
+	elapsed := now.Sub(m.sampled).Seconds()
+	m.sampled = now
+
+	for _, t := range m.sampleFn() {
+		key := gpuKey(t.Library, t.ID)
+		g, ok := m.gpus[key]
+		if !ok {
+			g = &gpuEnergy{}
+			m.gpus[key] = g
+		} else if integrate {
+			g.Joules += (g.Watts + t.Watts) / 2 * elapsed
+		}
+
+		g.GpuTelemetry = t
+
+		for span := range m.spans {
+			if _, ok := span.start[key]; ok {
+				span.temperature = max(span.temperature, t.Temperature)
+			}
+		}
+	}
+}
+
+func (m *energyMonitor) run(stop chan struct{}) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			m.mu.Lock()
+			m.sampleLocked()
+			m.mu.Unlock()
+		}
+	}
+}
+
+// begin starts measuring the energy used by gpus for a request. It returns nil
+// if m is nil.
+func (m *energyMonitor) begin(gpus discover.GpuInfoList) *energySpan {
+	if m == nil {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sampleLocked()
+
+	span := &energySpan{m: m, start: make(map[string]float64)}
+	for _, gpu := range gpus {
+		key := gpuKey(gpu.Library, gpu.ID)
+		if g, ok := m.gpus[key]; ok {
+			span.start[key] = g.Joules
+			span.temperature = max(span.temperature, g.Temperature)
+		}
+	}
+
+	if len(m.spans) == 0 {
+		m.stop = make(chan struct{})
+		go m.run(m.stop)
+	}
+
+	m.spans[span] = struct{}{}
+	return span
+}
+
+// snapshot returns the latest sample of each GPU, sorted by GPU.
+func (m *energyMonitor) snapshot() []gpuEnergy {
+	if m == nil {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sampleLocked()
+
+	keys := slices.Sorted(maps.Keys(m.gpus))
+	gpus := make([]gpuEnergy, len(keys))
+	for i, key := range keys {
+		gpus[i] = *m.gpus[key]
+	}
+
+	return gpus
+}
+
+// energySpan measures the energy used by the GPUs running a request.
+type energySpan struct {
+	m     *energyMonitor
+	start map[string]float64
+
+	once        sync.Once
+	joules      float64
+	temperature float64
+}
+
+// end stops measuring and returns the energy in joules used by the GPUs since
+// the span began and their highest temperature. Calling end again returns the
+// same values. A nil span returns zeroes.
+func (s *energySpan) end() (joules, temperature float64) {
+	if s == nil {
+		return 0, 0
+	}
+
+	s.once.Do(func() {
+		m := s.m
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		m.sampleLocked()
+
+		for key, start := range s.start {
+			s.joules += m.gpus[key].Joules - start
+		}
+
+		delete(m.spans, s)
+		if len(m.spans) == 0 {
+			close(m.stop)
+		}
+	})
+
+	return s.joules, s.temperature
+}
+
+// prometheusLabel escapes label values in the Prometheus text format.
+var prometheusLabel = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// MetricsHandler reports the power draw, temperature and energy used by each
+// GPU, and the sequences of each loaded model paused waiting for slow clients,
+// in the Prometheus text format.
+func (s *Server) MetricsHandler(c *gin.Context) {
+	gpus := s.energy.snapshot()
+
+	var b strings.Builder
+	for _, metric := range []struct {
+		name, kind, help string
+		value            func(gpuEnergy) float64
+	}{
+		{"ollama_gpu_power_watts", "gauge", "Current power draw of the GPU.", func(g gpuEnergy) float64 { return g.Watts }},
+		{"ollama_gpu_temperature_celsius", "gauge", "Current temperature of the GPU.", func(g gpuEnergy) float64 { return g.Temperature }},
+		{"ollama_gpu_energy_joules_total", "counter", "Energy used by the GPU while requests were running.", func(g gpuEnergy) float64 { return g.Joules }},
+	} {
+		fmt.Fprintf(&b, "# HELP %s %s\n", metric.name, metric.help)
+		fmt.Fprintf(&b, "# TYPE %s %s\n", metric.name, metric.kind)
+		for _, g := range gpus {
+			fmt.Fprintf(&b, "%s{library=\"%s\",gpu=\"%s\",name=\"%s\"} %g\n", metric.name,
+				prometheusLabel.Replace(g.Library),
+				prometheusLabel.Replace(g.ID),
+				prometheusLabel.Replace(cmp.Or(g.Name, g.ID)),
+				metric.value(g))
+		}
+	}
+
+	s.writeStreamMetrics(c.Request.Context(), &b)
+
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(b.String()))
+}
+
+// streamStatser is implemented by runners that pause decoding sequences whose
+// clients read responses slower than they are generated.
+type streamStatser interface {
+	StreamStats(context.Context) (llm.StreamStats, error)
+}
+
+// writeStreamMetrics writes the paused sequences of each loaded model.
+// Runners that can't be reached are left out.
+func (s *Server) writeStreamMetrics(ctx context.Context, b *strings.Builder) {
+	type modelStats struct {
+		model string
+		stats llm.StreamStats
+	}
+
+	var models []modelStats
+	if s.sched != nil {
+		s.sched.loadedMu.Lock()
+		runners := slices.Collect(maps.Values(s.sched.loaded))
+		s.sched.loadedMu.Unlock()
+
+		for _, r := range runners {
+			runner, ok := r.llama.(streamStatser)
+			if !ok {
+				continue
+			}
+
+			stats, err := runner.StreamStats(ctx)
+			if err != nil {
+				continue
+			}
+
+			models = append(models, modelStats{r.model.ShortName, stats})
+		}
+	}
+
+	slices.SortFunc(models, func(a, b modelStats) int { return cmp.Compare(a.model, b.model) })
+
+	for _, metric := range []struct {
+		name, kind, help string
+		value            func(llm.StreamStats) float64
+	}{
+		{"ollama_paused_sequences", "gauge", "Sequences whose decoding is paused until their client reads the buffered responses.", func(st llm.StreamStats) float64 { return float64(st.Paused) }},
+		{"ollama_sequence_pauses_total", "counter", "Times decoding of a sequence paused for a slow client.", func(st llm.StreamStats) float64 { return float64(st.Pauses) }},
+	} {
+		fmt.Fprintf(b, "# HELP %s %s\n", metric.name, metric.help)
+		fmt.Fprintf(b, "# TYPE %s %s\n", metric.name, metric.kind)
+		for _, m := range models {
+			fmt.Fprintf(b, "%s{model=\"%s\"} %g\n", metric.name, prometheusLabel.Replace(m.model), metric.value(m.stats))
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestEnergyMonitor(t *testing.T) {
+	m := newEnergyMonitor(func() []discover.GpuTelemetry {
+		return []discover.GpuTelemetry{
+			{Library: "cuda", ID: "GPU-0", Name: "Test GPU", Watts: 100, Temperature: 60},
+			{Library: "cuda", ID: "GPU-1", Name: "Other GPU", Watts: 50, Temperature: 40},
+		}
+	})
+	m.interval = 10 * time.Millisecond
+
+	var gpu discover.GpuInfo
+	gpu.Library = "cuda"
+	gpu.ID = "GPU-0"
+
+	start := time.Now()
+	span := m.begin(discover.GpuInfoList{gpu})
+	time.Sleep(50 * time.Millisecond)
+	joules, temperature := span.end()
+	elapsed := time.Since(start)
+
+	if joules < 100*0.05 || joules > 100*elapsed.Seconds() {
+		t.Errorf("expected about %.1f J, got %.1f J", 100*elapsed.Seconds(), joules)
+	}
+
+	if temperature != 60 {
+		t.Errorf("expected temperature 60, got %f", temperature)
+	}
+
+	if again, _ := span.end(); again != joules {
+		t.Errorf("expected ending again to return %f, got %f", joules, again)
+	}
+
+	if len(m.spans) != 0 {
+		t.Errorf("expected no running spans, got %d", len(m.spans))
+	}
+
+	// the other GPU used energy too but isn't charged to the span
+	gpus := m.snapshot()
+	if len(gpus) != 2 || gpus[1].Joules >= gpus[0].Joules {
+		t.Errorf("unexpected energy %+v", gpus)
+	}
+
+	// energy isn't added up while no requests are running
+	time.Sleep(20 * time.Millisecond)
+	if after := m.snapshot(); after[0].Joules != gpus[0].Joules {
+		t.Errorf("expected %f J while idle, got %f J", gpus[0].Joules, after[0].Joules)
+	}
+
+	var nilMonitor *energyMonitor
+	if joules, temperature := nilMonitor.begin(nil).end(); joules != 0 || temperature != 0 {
+		t.Errorf("expected no energy without a monitor, got %f J %f", joules, temperature)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	s := Server{energy: newEnergyMonitor(func() []discover.GpuTelemetry {
+		return []discover.GpuTelemetry{{Library: "rocm", ID: "0", Name: `Radeon "Pro"`, Watts: 42.5, Temperature: 55}}
+	})}
+
+	w := createRequest(t, s.MetricsHandler, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	for _, line := range []string{
+		"# TYPE ollama_gpu_power_watts gauge",
+		`ollama_gpu_power_watts{library="rocm",gpu="0",name="Radeon \"Pro\""} 42.5`,
+		`ollama_gpu_temperature_celsius{library="rocm",gpu="0",name="Radeon \"Pro\""} 55`,
+		"# TYPE ollama_gpu_energy_joules_total counter",
+		`ollama_gpu_energy_joules_total{library="rocm",gpu="0",name="Radeon \"Pro\""} 0`,
+	} {
+		if !strings.Contains(w.Body.String(), line+"\n") {
+			t.Errorf("expected %q in\n%s", line, w.Body.String())
+		}
+	}
+}
+
+type streamStatsLlm struct {
+	mockLlm
+	stats llm.StreamStats
+}
+
+func (s *streamStatsLlm) StreamStats(context.Context) (llm.StreamStats, error) {
+	return s.stats, nil
+}
+
+func TestStreamMetrics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	s := Server{
+		energy: newEnergyMonitor(func() []discover.GpuTelemetry { return nil }),
+		sched: &Scheduler{loaded: map[string]*runnerRef{
+			"a": {model: &Model{ShortName: "llama3:latest"}, llama: &streamStatsLlm{stats: llm.StreamStats{Paused: 2, Pauses: 7}}},
+			"b": {model: &Model{ShortName: "other:latest"}, llama: &mockLlm{}},
+		}},
+	}
+
+	w := createRequest(t, s.MetricsHandler, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	for _, line := range []string{
+		"# TYPE ollama_paused_sequences gauge",
+		`ollama_paused_sequences{model="llama3:latest"} 2`,
+		"# TYPE ollama_sequence_pauses_total counter",
+		`ollama_sequence_pauses_total{model="llama3:latest"} 7`,
+	} {
+		if !strings.Contains(w.Body.String(), line+"\n") {
+			t.Errorf("expected %q in\n%s", line, w.Body.String())
+		}
+	}
+
+	if strings.Contains(w.Body.String(), "other:latest") {
+		t.Errorf("expected no metrics for runners without stream stats in\n%s", w.Body.String())
+	}
+}
@@ -0,0 +1,259 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/types/errtypes"
+	"github.com/ollama/ollama/types/model"
+)
+
+// Evaluation tasks
+const (
+	// evalExactMatch generates a response to each question with the model's
+	// template and compares it to the expected answer.
+	evalExactMatch = "exact_match"
+
+	// evalPerplexity measures the model's perplexity on a text.
+	evalPerplexity = "perplexity"
+
+	// evalMultipleChoice picks the choice the model gives the highest log
+	// probability as the continuation of each question.
+	evalMultipleChoice = "multiple_choice"
+)
+
+func (s *Server) EvalHandler(c *gin.Context) {
+	var req api.EvalRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := validateEval(req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := model.ParseName(req.Model)
+	if !name.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": errtypes.InvalidModelNameErrMsg})
+		return
+	}
+
+	name, err = getExistingName(name)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	want, err := GetModel(name.String())
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	// generate greedily unless asked otherwise, with options typed as they
+	// would be when decoded from JSON
+	requestOpts := map[string]any{"temperature": 0.0, "num_predict": 128.0}
+	maps.Copy(requestOpts, req.Options)
+
+	r, m, opts, _, err := s.scheduleRunner(c.Request.Context(), name.String(), []Capability{CapabilityCompletion}, requestOpts, req.KeepAlive)
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	if m.Digest != want.Digest {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("%s does not fit in available memory, cannot evaluate it", req.Model)})
+		return
+	}
+
+	ch := make(chan any)
+	go func() {
+		defer close(ch)
+		defer recoverStream(c, ch)
+
+		start := time.Now()
+		report := api.EvalReport{Model: req.Model, Task: req.Task}
+		fn := func(completed, total int) {
+			ch <- api.EvalResponse{Status: "evaluating", Completed: completed, Total: total}
+		}
+
+		var err error
+		switch req.Task {
+		case evalExactMatch:
+			report.Fingerprint = fingerprint(m, m.Template.String(), r)
+			report.Results, err = evalAnswers(c.Request.Context(), r, m, opts, req.Items, fn)
+		case evalMultipleChoice:
+			report.Fingerprint = fingerprint(m, "", r)
+			report.Results, err = evalChoices(c.Request.Context(), r, req.Items, fn)
+		case evalPerplexity:
+			report.Fingerprint = fingerprint(m, "", r)
+			report.Tokens, report.Perplexity, err = evalPerplexityOf(c.Request.Context(), r, opts, req.Text, fn)
+		}
+
+		if err != nil {
+			ch <- gin.H{"error": err.Error()}
+			return
+		}
+
+		if req.Task == evalPerplexity {
+			report.Count = 1
+		} else {
+			report.Count = len(report.Results)
+			for _, result := range report.Results {
+				if result.Correct {
+					report.Correct++
+				}
+			}
+
+			accuracy := float64(report.Correct) / float64(report.Count)
+			report.Accuracy = &accuracy
+		}
+
+		report.Duration = time.Since(start)
+		ch <- api.EvalResponse{Status: "success", Report: &report}
+	}()
+
+	if req.Stream != nil && !*req.Stream {
+		waitForStream(c, ch)
+		return
+	}
+
+	streamResponse(c, ch)
+}
+
+// validateEval checks that req has what its task needs.
+func validateEval(req api.EvalRequest) error {
+	switch req.Task {
+	case evalExactMatch:
+		if len(req.Items) == 0 {
+			return errors.New("items are required")
+		}
+	case evalMultipleChoice:
+		if len(req.Items) == 0 {
+			return errors.New("items are required")
+		}
+
+		for i, item := range req.Items {
+			if len(item.Choices) < 2 {
+				return fmt.Errorf("item %d: at least two choices are required", i)
+			} else if item.Label < 0 || item.Label >= len(item.Choices) {
+				return fmt.Errorf("item %d: label %d is not a choice", i, item.Label)
+			}
+		}
+	case evalPerplexity:
+		if strings.TrimSpace(req.Text) == "" {
+			return errors.New("text is required")
+		}
+	case "":
+		return errors.New("task is required")
+	default:
+		return fmt.Errorf("unknown task %q, expected %s, %s or %s", req.Task, evalExactMatch, evalMultipleChoice, evalPerplexity)
+	}
+
+	return nil
+}
+
+// evalAnswers generates a response to each item and compares it to the
+// answer, ignoring case, spacing and surrounding punctuation.
+func evalAnswers(ctx context.Context, r llm.LlamaServer, m *Model, opts *api.Options, items []api.EvalItem, fn func(completed, total int)) ([]api.EvalResult, error) {
+	results := make([]api.EvalResult, len(items))
+	for i, item := range items {
+		fn(i, len(items))
+
+		var msgs []api.Message
+		if m.System != "" {
+			msgs = append(msgs, api.Message{Role: "system", Content: m.System})
+		}
+		msgs = append(msgs, api.Message{Role: "user", Content: item.Prompt})
+
+		prompt, _, err := chatPrompt(ctx, m, r.Tokenize, opts, msgs, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var sb strings.Builder
+		if err := r.Completion(ctx, llm.CompletionRequest{Prompt: prompt, Options: opts}, func(cr llm.CompletionResponse) {
+			sb.WriteString(cr.Content)
+		}); err != nil {
+			return nil, err
+		}
+
+		results[i] = api.EvalResult{
+			Response: sb.String(),
+			Correct:  normalizeAnswer(sb.String()) == normalizeAnswer(item.Answer),
+		}
+	}
+
+	fn(len(items), len(items))
+	return results, nil
+}
+
+// normalizeAnswer lowercases s, collapses its spacing and trims punctuation
+// around it so that answers can be compared for an exact match.
+func normalizeAnswer(s string) string {
+	s = strings.Join(strings.Fields(strings.ToLower(s)), " ")
+	return strings.TrimFunc(s, func(r rune) bool {
+		return unicode.IsPunct(r) || unicode.IsSpace(r)
+	})
+}
+
+// evalChoices scores each choice of each item as the continuation of its
+// prompt and picks the one with the highest total log probability.
+func evalChoices(ctx context.Context, r llm.LlamaServer, items []api.EvalItem, fn func(completed, total int)) ([]api.EvalResult, error) {
+	results := make([]api.EvalResult, len(items))
+	for i, item := range items {
+		fn(i, len(items))
+
+		result := api.EvalResult{LogProbs: make([]float64, len(item.Choices))}
+		for j, choice := range item.Choices {
+			score, err := r.Score(ctx, llm.ScoreRequest{Prompt: item.Prompt, Continuation: choice})
+			if err != nil {
+				return nil, err
+			}
+
+			for _, logprob := range score.LogProbs {
+				result.LogProbs[j] += logprob
+			}
+
+			if result.LogProbs[j] > result.LogProbs[result.Choice] {
+				result.Choice = j
+			}
+		}
+
+		result.Correct = result.Choice == item.Label
+		results[i] = result
+	}
+
+	fn(len(items), len(items))
+	return results, nil
+}
+
+// evalPerplexityOf returns the number of tokens of text scored and the
+// model's perplexity on them.
+func evalPerplexityOf(ctx context.Context, r llm.LlamaServer, opts *api.Options, text string, fn func(completed, total int)) (int, float64, error) {
+	tokens, err := scoreText(ctx, r, opts, text, fn)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return len(tokens), math.Exp(meanNLL(tokens)), nil
+}
@@ -0,0 +1,262 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestValidateEval(t *testing.T) {
+	cases := []struct {
+		name string
+		req  api.EvalRequest
+		err  string
+	}{
+		{"no task", api.EvalRequest{}, "task is required"},
+		{"unknown task", api.EvalRequest{Task: "bleu"}, `unknown task "bleu"`},
+		{"exact match", api.EvalRequest{Task: evalExactMatch, Items: []api.EvalItem{{Prompt: "1+1?", Answer: "2"}}}, ""},
+		{"exact match without items", api.EvalRequest{Task: evalExactMatch}, "items are required"},
+		{"multiple choice", api.EvalRequest{Task: evalMultipleChoice, Items: []api.EvalItem{{Prompt: "1+1=", Choices: []string{"2", "3"}, Label: 1}}}, ""},
+		{"one choice", api.EvalRequest{Task: evalMultipleChoice, Items: []api.EvalItem{{Prompt: "1+1=", Choices: []string{"2"}}}}, "item 0: at least two choices are required"},
+		{"label out of range", api.EvalRequest{Task: evalMultipleChoice, Items: []api.EvalItem{{Prompt: "1+1=", Choices: []string{"2", "3"}, Label: 2}}}, "item 0: label 2 is not a choice"},
+		{"perplexity", api.EvalRequest{Task: evalPerplexity, Text: "the quick brown fox"}, ""},
+		{"perplexity without text", api.EvalRequest{Task: evalPerplexity, Text: " \n"}, "text is required"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateEval(tt.req)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			} else if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+				t.Fatalf("expected error %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestNormalizeAnswer(t *testing.T) {
+	cases := map[string]string{
+		"Paris":             "paris",
+		"  Paris.\n":        "paris",
+		"\"New   York\"":    "new york",
+		"It's 42!":          "it's 42",
+		"":                  "",
+		"...":               "",
+		"Rio de\tJaneiro ?": "rio de janeiro",
+	}
+
+	for in, want := range cases {
+		if got := normalizeAnswer(in); got != want {
+			t.Errorf("normalizeAnswer(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestEvalHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mock := mockRunner{}
+	s := Server{
+		sched: &Scheduler{
+			pendingReqCh:  make(chan *LlmRequest, 1),
+			finishedReqCh: make(chan *LlmRequest, 1),
+			expiredCh:     make(chan *runnerRef, 1),
+			unloadedCh:    make(chan any, 1),
+			loaded:        make(map[string]*runnerRef),
+			newServerFn:   newMockServer(&mock),
+			getGpuFn:      discover.GetGPUInfo,
+			getCpuFn:      discover.GetCPUInfo,
+			reschedDelay:  250 * time.Millisecond,
+			loadFn: func(req *LlmRequest, _ *ggml.GGML, _ discover.GpuInfoList, _ int) {
+				req.successCh <- &runnerRef{
+					llama: &mock,
+				}
+			},
+		},
+	}
+
+	go s.sched.Run(t.Context())
+
+	_, digest := createBinFile(t, ggml.KV{
+		"general.architecture":       "llama",
+		"llama.block_count":          uint32(1),
+		"llama.context_length":       uint32(2048),
+		"llama.embedding_length":     uint32(1024),
+		"llama.attention.head_count": uint32(8),
+		"tokenizer.ggml.tokens":      []string{""},
+		"tokenizer.ggml.scores":      []float32{0},
+		"tokenizer.ggml.token_type":  []int32{0},
+	}, []ggml.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+	})
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:    "test",
+		Files:    map[string]string{"file.gguf": digest},
+		Template: "{{ .Prompt }}",
+		Stream:   &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	eval := func(t *testing.T, req api.EvalRequest) *api.EvalReport {
+		t.Helper()
+
+		req.Model = "test"
+		req.Stream = &stream
+		w := createRequest(t, s.EvalHandler, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.EvalResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Status != "success" || resp.Report == nil {
+			t.Fatalf("expected a report, got %+v", resp)
+		}
+
+		return resp.Report
+	}
+
+	t.Run("missing body", func(t *testing.T) {
+		w := createRequest(t, s.EvalHandler, nil)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("missing model", func(t *testing.T) {
+		w := createRequest(t, s.EvalHandler, api.EvalRequest{Model: "missing", Task: evalPerplexity, Text: "hi"})
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("exact match", func(t *testing.T) {
+		mock.CompletionFn = func(_ context.Context, r llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+			if r.Options.Temperature != 0 {
+				t.Errorf("expected greedy sampling, got temperature %v", r.Options.Temperature)
+			}
+
+			answer := " Paris."
+			if r.Prompt != "What is the capital of France?" {
+				answer = "Lyon"
+			}
+
+			fn(llm.CompletionResponse{Content: answer})
+			fn(llm.CompletionResponse{Done: true, DoneReason: api.DoneReasonStop})
+			return nil
+		}
+		t.Cleanup(func() { mock.CompletionFn = nil })
+
+		report := eval(t, api.EvalRequest{Task: evalExactMatch, Items: []api.EvalItem{
+			{Prompt: "What is the capital of France?", Answer: "paris"},
+			{Prompt: "What is the capital of Italy?", Answer: "Rome"},
+		}})
+
+		if report.Count != 2 || report.Correct != 1 || report.Accuracy == nil || *report.Accuracy != 0.5 {
+			t.Errorf("expected 1 of 2 correct, got %+v", report)
+		}
+
+		if report.Results[0].Response != " Paris." || !report.Results[0].Correct || report.Results[1].Correct {
+			t.Errorf("unexpected results %+v", report.Results)
+		}
+
+		if report.Fingerprint == nil || report.Fingerprint.TemplateHash == "" {
+			t.Errorf("expected a fingerprint with the template, got %+v", report.Fingerprint)
+		}
+	})
+
+	t.Run("multiple choice", func(t *testing.T) {
+		mock.ScoreFn = func(_ context.Context, r llm.ScoreRequest) (*llm.ScoreResponse, error) {
+			if r.Prompt != "The capital of France is" {
+				t.Errorf("unexpected prompt %q", r.Prompt)
+			}
+
+			switch r.Continuation {
+			case " Paris":
+				return &llm.ScoreResponse{LogProbs: []float64{-0.5, -0.5}}, nil
+			default:
+				return &llm.ScoreResponse{LogProbs: []float64{-2}}, nil
+			}
+		}
+		t.Cleanup(func() { mock.ScoreFn = nil })
+
+		report := eval(t, api.EvalRequest{Task: evalMultipleChoice, Items: []api.EvalItem{
+			{Prompt: "The capital of France is", Choices: []string{" Lyon", " Paris"}, Label: 1},
+			{Prompt: "The capital of France is", Choices: []string{" Paris", " Lyon"}, Label: 1},
+		}})
+
+		if report.Count != 2 || report.Correct != 1 {
+			t.Errorf("expected 1 of 2 correct, got %+v", report)
+		}
+
+		want := []api.EvalResult{
+			{Choice: 1, LogProbs: []float64{-2, -1}, Correct: true},
+			{Choice: 0, LogProbs: []float64{-1, -2}},
+		}
+		if diff := cmp.Diff(want, report.Results); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("perplexity", func(t *testing.T) {
+		var windows []string
+		mock.ScoreFn = func(_ context.Context, r llm.ScoreRequest) (*llm.ScoreResponse, error) {
+			if r.Prompt != "" {
+				t.Errorf("expected no prompt, got %q", r.Prompt)
+			}
+
+			windows = append(windows, r.Continuation)
+			logprobs := make([]float64, len(strings.Fields(r.Continuation))-1)
+			for i := range logprobs {
+				logprobs[i] = -math.Ln2
+			}
+			return &llm.ScoreResponse{LogProbs: logprobs}, nil
+		}
+		t.Cleanup(func() { mock.ScoreFn = nil })
+
+		text := strings.TrimSpace(strings.Repeat("lorem ipsum ", 8))
+		report := eval(t, api.EvalRequest{Task: evalPerplexity, Text: text, Options: map[string]any{"num_ctx": 8}})
+
+		if len(windows) != 4 || strings.Join(windows, "") != text {
+			t.Errorf("expected the text in 4 windows, got %q", windows)
+		}
+
+		if report.Count != 1 || report.Tokens != 12 || math.Abs(report.Perplexity-2) > 1e-9 {
+			t.Errorf("expected perplexity 2 over 12 tokens, got %+v", report)
+		}
+
+		if report.Accuracy != nil {
+			t.Errorf("expected no accuracy, got %v", *report.Accuracy)
+		}
+	})
+
+	t.Run("invalid task", func(t *testing.T) {
+		w := createRequest(t, s.EvalHandler, api.EvalRequest{Model: "test", Task: evalMultipleChoice, Items: []api.EvalItem{{Prompt: "hi"}}})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// acceptsEventStream reports whether a client prefers server-sent events to
+// newline delimited JSON by the Accept header of its request.
+func acceptsEventStream(accept string) bool {
+	quality := make(map[string]float64)
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+
+		quality[mediaType] = q
+	}
+
+	return quality["text/event-stream"] > 0 && quality["text/event-stream"] >= quality["application/x-ndjson"]
+}
+
+// eventStreamMiddleware sends streamed responses as server-sent events to
+// clients that accept them, so that browsers can read them without a proxy
+// translating them. Each chunk of the stream is the data of an event, and
+// errors are events of the type error.
+func eventStreamMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !acceptsEventStream(c.GetHeader("Accept")) {
+			c.Next()
+			return
+		}
+
+		w := &eventStreamWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		defer func() {
+			c.Writer = w.ResponseWriter
+		}()
+
+		c.Next()
+	}
+}
+
+// eventStreamWriter writes the lines of newline delimited JSON responses as
+// server-sent events. Other responses are written as they are.
+type eventStreamWriter struct {
+	gin.ResponseWriter
+	events bool
+}
+
+func (w *eventStreamWriter) Write(b []byte) (int, error) {
+	if !w.events {
+		mediaType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+		if mediaType != "application/x-ndjson" || w.Written() {
+			return w.ResponseWriter.Write(b)
+		}
+
+		w.events = true
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+
+	var events bytes.Buffer
+	for line := range bytes.Lines(b) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		var resp struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(line, &resp) == nil && resp.Error != "" {
+			events.WriteString("event: error\n")
+		}
+
+		events.WriteString("data: ")
+		events.Write(line)
+		events.WriteString("\n\n")
+	}
+
+	if _, err := w.ResponseWriter.Write(events.Bytes()); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+func (w *eventStreamWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAcceptsEventStream(t *testing.T) {
+	cases := map[string]bool{
+		"":                                 false,
+		"application/x-ndjson":             false,
+		"*/*":                              false,
+		"text/event-stream":                true,
+		"text/event-stream; charset=utf-8": true,
+		"application/x-ndjson, text/event-stream":       true,
+		"text/event-stream;q=0.5, application/x-ndjson": false,
+		"text/event-stream;q=0":                         false,
+	}
+
+	for accept, want := range cases {
+		if got := acceptsEventStream(accept); got != want {
+			t.Errorf("%q: expected %v, got %v", accept, want, got)
+		}
+	}
+}
+
+func TestEventStreamMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.Use(requestIDMiddleware(), compressionMiddleware(), eventStreamMiddleware(), requestIDResponseMiddleware())
+	r.GET("/stream", func(c *gin.Context) {
+		ch := make(chan any, 2)
+		ch <- gin.H{"response": "Hello"}
+		ch <- gin.H{"error": "out of memory"}
+		close(ch)
+		streamResponse(c, ch)
+	})
+	r.GET("/json", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"response": "Hello"})
+	})
+
+	get := func(t *testing.T, path, accept string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		w := NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-Request-ID", "req-1")
+		req.Header.Set("Accept", accept)
+		r.ServeHTTP(w, req)
+		return w.ResponseRecorder
+	}
+
+	t.Run("events", func(t *testing.T) {
+		w := get(t, "/stream", "text/event-stream")
+		if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+			t.Errorf("expected content type text/event-stream, got %q", ct)
+		}
+
+		expect := "data: {\"request_id\":\"req-1\",\"response\":\"Hello\"}\n\n" +
+			"event: error\ndata: {\"request_id\":\"req-1\",\"error\":\"out of memory\"}\n\n"
+		if body := w.Body.String(); body != expect {
+			t.Errorf("expected %q, got %q", expect, body)
+		}
+	})
+
+	t.Run("ndjson", func(t *testing.T) {
+		w := get(t, "/stream", "application/x-ndjson")
+		expect := "{\"request_id\":\"req-1\",\"response\":\"Hello\"}\n{\"request_id\":\"req-1\",\"error\":\"out of memory\"}\n"
+		if body := w.Body.String(); body != expect {
+			t.Errorf("expected %q, got %q", expect, body)
+		}
+	})
+
+	// responses that aren't streamed are left alone
+	t.Run("json", func(t *testing.T) {
+		w := get(t, "/json", "text/event-stream")
+		if body := w.Body.String(); body != `{"response":"Hello"}` {
+			t.Errorf("unexpected body %q", body)
+		}
+	})
+}
@@ -0,0 +1,295 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/logutil"
+	"github.com/ollama/ollama/types/model"
+)
+
+// fallback tries its models in order until one of them serves a request.
+type fallback struct {
+	name string
+
+	Models []fallbackModel `json:"models"`
+}
+
+// fallbackModel is a model that a fallback model tries. The next model is
+// tried if it fails, takes longer than Timeout or answers with less
+// confidence than MinLogprob.
+type fallbackModel struct {
+	Model string `json:"model"`
+
+	// Timeout limits the time to load the model and generate the response
+	Timeout *api.Duration `json:"timeout,omitempty"`
+
+	// MinLogprob is the lowest mean log probability of the tokens of a
+	// response that is accepted
+	MinLogprob *float64 `json:"min_logprob,omitempty"`
+}
+
+// fallbackAttempt is the request of a fallback model to one of its models.
+// Handlers find it in the request context with [fallbackAttemptFrom].
+type fallbackAttempt struct {
+	// route is reported in the summary of the response
+	route *api.RouteDecision
+
+	// score asks the handler to set logprob, or err if it fails
+	score   bool
+	logprob float64
+	err     error
+
+	// messages are added to session by the handler of an attempt that isn't
+	// scored, and otherwise once its response is used
+	session  string
+	messages []api.Message
+}
+
+type fallbackAttemptKey struct{}
+
+func fallbackAttemptFrom(ctx context.Context) *fallbackAttempt {
+	a, _ := ctx.Value(fallbackAttemptKey{}).(*fallbackAttempt)
+	return a
+}
+
+// scoreResponse sets the mean log probability of the tokens of response if
+// the attempt asks for it.
+func (a *fallbackAttempt) scoreResponse(ctx context.Context, r llm.LlamaServer, prompt, response string) {
+	if a == nil || !a.score {
+		return
+	}
+
+	resp, err := r.Score(ctx, llm.ScoreRequest{Prompt: prompt, Continuation: response})
+	if err != nil {
+		a.err = err
+		return
+	}
+
+	var sum float64
+	for _, lp := range resp.LogProbs {
+		sum += lp
+	}
+
+	if len(resp.LogProbs) > 0 {
+		a.logprob = sum / float64(len(resp.LogProbs))
+	}
+}
+
+// recordSession adds messages to the session of a chat, unless the attempt
+// is scored and so may not be used, in which case they are added by
+// fallbackMiddleware if it is.
+func (a *fallbackAttempt) recordSession(ss *sessions, session string, messages ...api.Message) {
+	if a != nil && a.score {
+		a.session, a.messages = session, messages
+		return
+	}
+
+	ss.record(session, messages...)
+}
+
+// fallbackRecorder holds the response of an attempt until it is known
+// whether it is used.
+type fallbackRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *fallbackRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *fallbackRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *fallbackRecorder) Write(b []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(b)
+}
+
+func (r *fallbackRecorder) Flush() {}
+
+// CloseNotify is needed by [gin.Context.Stream]. The client of an attempt
+// never goes away, since the context of the attempt ends it.
+func (r *fallbackRecorder) CloseNotify() <-chan bool {
+	return nil
+}
+
+// responseError returns the error of a failed response, which is either
+// its status or an error in its stream.
+func (r *fallbackRecorder) responseError() string {
+	for line := range bytes.Lines(r.body.Bytes()) {
+		var resp struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(line, &resp) == nil && resp.Error != "" {
+			return resp.Error
+		}
+	}
+
+	if r.status >= http.StatusBadRequest {
+		return http.StatusText(r.status)
+	}
+
+	return ""
+}
+
+// replay sends the response to w in the chunks that it was written in, so
+// that writers converting each chunk such as those of the OpenAI
+// compatible endpoints see them as if they were streamed.
+func (r *fallbackRecorder) replay(w gin.ResponseWriter) {
+	for k, v := range r.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(r.status)
+
+	for line := range bytes.Lines(r.body.Bytes()) {
+		if _, err := w.Write(line); err != nil {
+			return
+		}
+		w.Flush()
+	}
+}
+
+// fallbackMiddleware serves requests to fallback models by sending them to
+// handler with each of their models in turn. The response of each model but
+// the last is held back until it is known to be used, so streamed responses
+// of those arrive all at once when their model is done. The last model's
+// response is always used, so it is streamed as it is generated.
+func (s *Server) fallbackMiddleware(handler gin.HandlerFunc) gin.HandlerFunc {
+	attempts := gin.New()
+	attempts.POST("/", handler)
+
+	return func(c *gin.Context) {
+		if len(s.fallbacks) == 0 {
+			c.Next()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		// requests that can't be decoded are rejected by the handler
+		var req map[string]json.RawMessage
+		var name string
+		if json.Unmarshal(body, &req) != nil || json.Unmarshal(req["model"], &name) != nil {
+			c.Next()
+			return
+		}
+
+		f, ok := s.fallbacks[model.ParseName(name).String()]
+		if !ok {
+			c.Next()
+			return
+		}
+
+		c.Abort()
+
+		var skipped []api.SkippedModel
+		for i, fm := range f.Models {
+			last := i == len(f.Models)-1
+			attempt := &fallbackAttempt{
+				route: &api.RouteDecision{Router: name, Model: fm.Model, Reason: "primary", Skipped: slices.Clone(skipped)},
+				score: fm.MinLogprob != nil && !last,
+			}
+			if i > 0 {
+				attempt.route.Reason = "fallback"
+			}
+
+			if last {
+				tryFallback(c, attempts, c.Writer, req, fm, attempt)
+				return
+			}
+
+			rec := &fallbackRecorder{header: http.Header{}}
+			timedOut, err := tryFallback(c, attempts, rec, req, fm, attempt)
+			if err != nil {
+				// the client went away
+				return
+			}
+
+			skip := api.SkippedModel{Model: fm.Model}
+			switch {
+			case rec.responseError() != "" && timedOut:
+				skip.Reason, skip.Error = "timeout", "timed out after "+fm.Timeout.String()
+			case rec.responseError() != "":
+				skip.Reason, skip.Error = "error", rec.responseError()
+			case attempt.err != nil:
+				skip.Reason, skip.Error = "error", attempt.err.Error()
+			case attempt.score && attempt.logprob < *fm.MinLogprob:
+				skip.Reason, skip.Logprob = "confidence", attempt.logprob
+			default:
+				if attempt.session != "" {
+					s.sessions.record(attempt.session, attempt.messages...)
+				}
+
+				rec.replay(c.Writer)
+				return
+			}
+
+			slog.WarnContext(c.Request.Context(), "fallback model skipped", "fallback", name, "model", fm.Model, "reason", skip.Reason, "error", skip.Error, "logprob", skip.Logprob)
+			skipped = append(skipped, skip)
+		}
+	}
+}
+
+// tryFallback sends the request req to the model fm, writing its response
+// to w, and reports whether it timed out. It only fails if the client of the
+// request went away.
+func tryFallback(c *gin.Context, attempts http.Handler, w http.ResponseWriter, req map[string]json.RawMessage, fm fallbackModel, attempt *fallbackAttempt) (bool, error) {
+	name, err := json.Marshal(fm.Model)
+	if err != nil {
+		return false, err
+	}
+
+	req = maps.Clone(req)
+	req["model"] = name
+	body, err := json.Marshal(req)
+	if err != nil {
+		return false, err
+	}
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if fm.Timeout != nil && fm.Timeout.Duration > 0 {
+		ctx, cancel = context.WithTimeout(c.Request.Context(), fm.Timeout.Duration)
+	} else {
+		ctx, cancel = context.WithCancel(c.Request.Context())
+	}
+	defer cancel()
+
+	r := c.Request.Clone(context.WithValue(ctx, fallbackAttemptKey{}, attempt))
+	r.Method, r.URL.Path, r.URL.RawPath = http.MethodPost, "/", ""
+	r.Body, r.ContentLength = io.NopCloser(bytes.NewReader(body)), int64(len(body))
+	if r.Header == nil {
+		r.Header = http.Header{}
+	}
+	r.Header.Set(logutil.RequestIDHeader, requestID(c))
+
+	attempts.ServeHTTP(w, r)
+
+	if err := c.Request.Context().Err(); err != nil {
+		return false, err
+	}
+
+	return errors.Is(ctx.Err(), context.DeadlineExceeded), nil
+}
@@ -0,0 +1,288 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/template"
+	"github.com/ollama/ollama/types/errtypes"
+	"github.com/ollama/ollama/types/model"
+)
+
+// Fine-tuning defaults, following common LoRA recipes
+var (
+	finetuneEpochs       = 1
+	finetuneLearningRate = float32(1e-4)
+	finetuneRank         = 8
+	finetuneTargets      = []string{"attn_q", "attn_v"}
+)
+
+func (s *Server) FinetuneHandler(c *gin.Context) {
+	var req api.FinetuneRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Epochs < 0 || req.Rank < 0 || req.LearningRate < 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "epochs, rank and learning_rate must not be negative"})
+		return
+	}
+
+	name := model.ParseName(req.Model)
+	output := model.ParseName(req.Output)
+	if !name.IsValid() || !output.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": errtypes.InvalidModelNameErrMsg})
+		return
+	}
+
+	name, err = getExistingName(name)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	output, err = getExistingName(output)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if output == name {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "output must be different from the base model"})
+		return
+	}
+
+	m, err := GetModel(name.String())
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	if len(m.AdapterPaths) > 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": errOnlyOneAdapterSupported.Error()})
+		return
+	}
+
+	examples, err := parseDataset(m, req.Dataset)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	r, _, opts, _, err := s.scheduleRunner(c.Request.Context(), name.String(), []Capability{CapabilityCompletion}, req.Options, req.KeepAlive)
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	if r.Runtime().Engine != "ollama" {
+		c.AbortWithStatusJSON(http.StatusNotImplemented, gin.H{"error": "fine-tuning requires the Ollama engine, set OLLAMA_NEW_ENGINE=1"})
+		return
+	}
+
+	ch := make(chan any)
+	go func() {
+		defer close(ch)
+		defer recoverStream(c, ch)
+
+		// the adapter is written to the blobs directory, so keep it from
+		// being removed until the model that uses it is created
+		unlock, err := lockStore(c.Request.Context())
+		if err != nil {
+			ch <- gin.H{"error": err.Error()}
+			return
+		}
+		defer func() { unlock() }()
+
+		blobs, err := GetBlobsPath("")
+		if err != nil {
+			ch <- gin.H{"error": err.Error()}
+			return
+		}
+
+		temp, err := os.CreateTemp(blobs, "adapter-")
+		if err != nil {
+			ch <- gin.H{"error": err.Error()}
+			return
+		}
+		temp.Close()
+		defer os.Remove(temp.Name())
+
+		ftreq := llm.FinetuneRequest{
+			Examples:     examples,
+			Adapter:      temp.Name(),
+			Epochs:       cmp.Or(req.Epochs, finetuneEpochs),
+			LearningRate: cmp.Or(req.LearningRate, finetuneLearningRate),
+			Rank:         cmp.Or(req.Rank, finetuneRank),
+			Targets:      req.Targets,
+			Seed:         req.Seed,
+			NumCtx:       opts.NumCtx,
+		}
+
+		// alpha defaults to twice the rank
+		ftreq.Alpha = cmp.Or(req.Alpha, 2*float32(ftreq.Rank))
+		if len(ftreq.Targets) == 0 {
+			ftreq.Targets = finetuneTargets
+		}
+
+		var last api.FinetuneResponse
+		if err := r.Finetune(c.Request.Context(), ftreq, func(resp llm.FinetuneResponse) {
+			if !resp.Done {
+				last = api.FinetuneResponse{
+					Status:    "training",
+					Epoch:     resp.Epoch,
+					Completed: resp.Step,
+					Total:     resp.Steps,
+					Loss:      resp.Loss,
+				}
+				ch <- last
+			}
+		}); err != nil {
+			ch <- gin.H{"error": err.Error()}
+			return
+		}
+
+		fn := func(resp api.ProgressResponse) {
+			ch <- api.FinetuneResponse{Status: resp.Status}
+		}
+
+		oldManifest, _ := ParseNamedManifest(output)
+		if err := createFinetuned(c, name, output, temp.Name(), fn); err != nil {
+			ch <- gin.H{"error": err.Error()}
+			return
+		}
+
+		unlock()
+		unlock = func() {}
+
+		if !envconfig.NoPrune() && oldManifest != nil {
+			if err := oldManifest.RemoveLayers(); err != nil {
+				ch <- gin.H{"error": err.Error()}
+				return
+			}
+		}
+
+		// the final response has the loss of the last epoch
+		last.Status = "success"
+		ch <- last
+	}()
+
+	if req.Stream != nil && !*req.Stream {
+		waitForStream(c, ch)
+		return
+	}
+
+	streamResponse(c, ch)
+}
+
+// createFinetuned creates the model output from the layers of the model name
+// and the adapter at path.
+func createFinetuned(c *gin.Context, name, output model.Name, path string, fn func(api.ProgressResponse)) error {
+	layers, err := parseFromModel(c.Request.Context(), name, fn)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fn(api.ProgressResponse{Status: "creating adapter layer"})
+	layer, err := NewLayer(f, "application/vnd.ollama.image.adapter")
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	adapter, _, err := ggml.Decode(f, 0)
+	if err != nil {
+		return err
+	}
+
+	layers = append(layers, &layerGGML{layer, adapter})
+	return createModel(api.CreateRequest{}, output, layers, fn)
+}
+
+// datasetExample is a line of a fine-tuning dataset
+type datasetExample struct {
+	Prompt   string        `json:"prompt"`
+	Response string        `json:"response"`
+	Messages []api.Message `json:"messages"`
+}
+
+// parseDataset parses a JSON lines dataset into examples whose prompts are
+// formatted with the template and system message of m.
+func parseDataset(m *Model, dataset string) ([]llm.FinetuneExample, error) {
+	var examples []llm.FinetuneExample
+	scanner := bufio.NewScanner(strings.NewReader(dataset))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(dataset)+1)
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var e datasetExample
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, fmt.Errorf("dataset line %d: %w", n, err)
+		}
+
+		msgs := e.Messages
+		if len(msgs) == 0 {
+			msgs = []api.Message{{Role: "user", Content: e.Prompt}, {Role: "assistant", Content: e.Response}}
+		}
+
+		last := msgs[len(msgs)-1]
+		if last.Role != "assistant" || last.Content == "" {
+			return nil, fmt.Errorf("dataset line %d: example must end with a response", n)
+		}
+
+		msgs = msgs[:len(msgs)-1]
+		if m.System != "" && !slices.ContainsFunc(msgs, func(m api.Message) bool { return m.Role == "system" }) {
+			msgs = append([]api.Message{{Role: "system", Content: m.System}}, msgs...)
+		}
+
+		var b bytes.Buffer
+		if err := m.Template.Execute(&b, template.Values{Messages: msgs}); err != nil {
+			return nil, fmt.Errorf("dataset line %d: %w", n, err)
+		}
+
+		examples = append(examples, llm.FinetuneExample{Prompt: b.String(), Completion: last.Content})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(examples) == 0 {
+		return nil, errors.New("dataset is empty")
+	}
+
+	return examples, nil
+}
@@ -0,0 +1,258 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/template"
+	"github.com/ollama/ollama/types/model"
+)
+
+func TestParseDataset(t *testing.T) {
+	tmpl, err := template.Parse("{{ range .Messages }}<{{ .Role }}>{{ .Content }}{{ end }}<assistant>")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Model{Template: tmpl, System: "be brief"}
+
+	cases := []struct {
+		name     string
+		dataset  string
+		expected []llm.FinetuneExample
+		err      bool
+	}{
+		{
+			name:    "prompt and response",
+			dataset: `{"prompt": "hi", "response": "hello"}` + "\n\n" + `{"prompt": "bye", "response": "goodbye"}`,
+			expected: []llm.FinetuneExample{
+				{Prompt: "<system>be brief<user>hi<assistant>", Completion: "hello"},
+				{Prompt: "<system>be brief<user>bye<assistant>", Completion: "goodbye"},
+			},
+		},
+		{
+			name:    "messages",
+			dataset: `{"messages": [{"role": "system", "content": "be nice"}, {"role": "user", "content": "hi"}, {"role": "assistant", "content": "hello"}]}`,
+			expected: []llm.FinetuneExample{
+				{Prompt: "<system>be nice<user>hi<assistant>", Completion: "hello"},
+			},
+		},
+		{
+			name:    "no response",
+			dataset: `{"messages": [{"role": "user", "content": "hi"}]}`,
+			err:     true,
+		},
+		{
+			name:    "invalid json",
+			dataset: `{"prompt": "hi"`,
+			err:     true,
+		},
+		{
+			name:    "empty",
+			dataset: "\n",
+			err:     true,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			examples, err := parseDataset(m, tt.dataset)
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.expected, examples); diff != "" {
+				t.Errorf("parseDataset() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFinetuneHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var got llm.FinetuneRequest
+	mock := mockRunner{
+		FinetuneFn: func(_ context.Context, req llm.FinetuneRequest, fn func(llm.FinetuneResponse)) error {
+			got = req
+			fn(llm.FinetuneResponse{Epoch: 1, Step: 1, Steps: 1, Loss: 0.5})
+
+			f, err := os.Create(req.Adapter)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			if err := ggml.WriteGGUF(f, ggml.KV{
+				"general.architecture": "llama",
+				"general.type":         "adapter",
+				"adapter.type":         "lora",
+				"adapter.lora.alpha":   req.Alpha,
+			}, []ggml.Tensor{
+				{Name: "blk.0.attn_q.weight.lora_a", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+				{Name: "blk.0.attn_q.weight.lora_b", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+			}); err != nil {
+				return err
+			}
+
+			fn(llm.FinetuneResponse{Done: true, Steps: 1})
+			return nil
+		},
+		runtime: api.ProcessModelRuntime{Engine: "ollama"},
+	}
+
+	s := Server{
+		sched: &Scheduler{
+			pendingReqCh:  make(chan *LlmRequest, 1),
+			finishedReqCh: make(chan *LlmRequest, 1),
+			expiredCh:     make(chan *runnerRef, 1),
+			unloadedCh:    make(chan any, 1),
+			loaded:        make(map[string]*runnerRef),
+			newServerFn:   newMockServer(&mock),
+			getGpuFn:      discover.GetGPUInfo,
+			getCpuFn:      discover.GetCPUInfo,
+			reschedDelay:  250 * time.Millisecond,
+			loadFn: func(req *LlmRequest, _ *ggml.GGML, _ discover.GpuInfoList, _ int) {
+				req.successCh <- &runnerRef{
+					llama: &mock,
+				}
+			},
+		},
+	}
+
+	go s.sched.Run(t.Context())
+
+	_, digest := createBinFile(t, ggml.KV{
+		"general.architecture":       "llama",
+		"llama.block_count":          uint32(1),
+		"llama.context_length":       uint32(2048),
+		"llama.embedding_length":     uint32(1024),
+		"llama.attention.head_count": uint32(8),
+		"tokenizer.ggml.tokens":      []string{""},
+		"tokenizer.ggml.scores":      []float32{0},
+		"tokenizer.ggml.token_type":  []int32{0},
+	}, []ggml.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+	})
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:    "test",
+		Files:    map[string]string{"file.gguf": digest},
+		Template: "{{ .Prompt }}",
+		Stream:   &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	dataset := `{"prompt": "hi", "response": "hello"}`
+
+	t.Run("missing dataset", func(t *testing.T) {
+		w := createRequest(t, s.FinetuneHandler, api.FinetuneRequest{Model: "test", Output: "tuned"})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("same output", func(t *testing.T) {
+		w := createRequest(t, s.FinetuneHandler, api.FinetuneRequest{Model: "test", Output: "test", Dataset: dataset})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("missing model", func(t *testing.T) {
+		w := createRequest(t, s.FinetuneHandler, api.FinetuneRequest{Model: "missing", Output: "tuned", Dataset: dataset})
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("finetune", func(t *testing.T) {
+		w := createRequest(t, s.FinetuneHandler, api.FinetuneRequest{Model: "test", Output: "tuned", Dataset: dataset, Stream: &stream})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.FinetuneResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Status != "success" || resp.Loss != 0.5 {
+			t.Errorf("unexpected response %+v", resp)
+		}
+
+		if diff := cmp.Diff(llm.FinetuneRequest{
+			Examples:     []llm.FinetuneExample{{Prompt: "hi", Completion: "hello"}},
+			Adapter:      got.Adapter,
+			Epochs:       1,
+			LearningRate: 1e-4,
+			Rank:         8,
+			Alpha:        16,
+			Targets:      []string{"attn_q", "attn_v"},
+			NumCtx:       got.NumCtx,
+		}, got); diff != "" {
+			t.Errorf("finetune request mismatch (-want +got):\n%s", diff)
+		}
+
+		if _, err := os.Stat(got.Adapter); !os.IsNotExist(err) {
+			t.Error("expected the adapter file to be removed")
+		}
+
+		m, err := GetModel("tuned")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(m.AdapterPaths) != 1 {
+			t.Errorf("expected an adapter, got %v", m.AdapterPaths)
+		}
+
+		if m.Template.String() != "{{ .Prompt }}" {
+			t.Errorf("expected the template of the base model, got %q", m.Template.String())
+		}
+
+		base, err := ParseNamedManifest(model.ParseName("test"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tuned, err := ParseNamedManifest(model.ParseName("tuned"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(tuned.Layers) != len(base.Layers)+1 {
+			t.Errorf("expected the layers of the base model and an adapter, got %v", tuned.Layers)
+		}
+	})
+
+	t.Run("llama engine", func(t *testing.T) {
+		mock.runtime.Engine = "llama.cpp"
+		defer func() { mock.runtime.Engine = "ollama" }()
+
+		w := createRequest(t, s.FinetuneHandler, api.FinetuneRequest{Model: "test", Output: "tuned", Dataset: dataset})
+		if w.Code != http.StatusNotImplemented {
+			t.Errorf("expected status 501, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+}
@@ -0,0 +1,21 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+// fingerprint identifies the model m, the template tmpl applied to the prompt
+// and the engine of the runner r that generated a response.
+func fingerprint(m *Model, tmpl string, r llm.LlamaServer) *api.Fingerprint {
+	f := api.Fingerprint{Digest: m.Digest, Engine: r.Runtime().Engine}
+	if tmpl != "" {
+		sum := sha256.Sum256([]byte(tmpl))
+		f.TemplateHash = hex.EncodeToString(sum[:])
+	}
+
+	return &f
+}
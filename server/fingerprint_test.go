@@ -0,0 +1,146 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestFingerprint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mock := mockRunner{
+		CompletionResponse: llm.CompletionResponse{
+			Done:       true,
+			DoneReason: "stop",
+		},
+		runtime: api.ProcessModelRuntime{Engine: "llama.cpp"},
+	}
+
+	s := Server{
+		sched: &Scheduler{
+			pendingReqCh:  make(chan *LlmRequest, 1),
+			finishedReqCh: make(chan *LlmRequest, 1),
+			expiredCh:     make(chan *runnerRef, 1),
+			unloadedCh:    make(chan any, 1),
+			loaded:        make(map[string]*runnerRef),
+			newServerFn:   newMockServer(&mock),
+			getGpuFn:      discover.GetGPUInfo,
+			getCpuFn:      discover.GetCPUInfo,
+			reschedDelay:  250 * time.Millisecond,
+			loadFn: func(req *LlmRequest, _ *ggml.GGML, _ discover.GpuInfoList, _ int) {
+				req.successCh <- &runnerRef{
+					llama: &mock,
+				}
+			},
+		},
+	}
+
+	go s.sched.Run(t.Context())
+
+	_, digest := createBinFile(t, ggml.KV{
+		"general.architecture":       "llama",
+		"llama.block_count":          uint32(1),
+		"llama.context_length":       uint32(2048),
+		"llama.embedding_length":     uint32(1024),
+		"llama.attention.head_count": uint32(8),
+		"tokenizer.ggml.tokens":      []string{""},
+		"tokenizer.ggml.scores":      []float32{0},
+		"tokenizer.ggml.token_type":  []int32{0},
+	}, []ggml.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+	})
+
+	const tmpl = "{{ .Prompt }}"
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:    "test",
+		Files:    map[string]string{"file.gguf": digest},
+		Template: tmpl,
+		Stream:   &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	templateHash := func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+
+	generate := func(t *testing.T, req api.GenerateRequest) *api.Fingerprint {
+		t.Helper()
+
+		w := createRequest(t, s.GenerateHandler, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.GenerateResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		return resp.Fingerprint
+	}
+
+	t.Run("generate", func(t *testing.T) {
+		fp := generate(t, api.GenerateRequest{Model: "test", Prompt: "Hello!", Stream: &stream})
+		expect := api.Fingerprint{Digest: m.Digest, TemplateHash: templateHash(tmpl), Engine: "llama.cpp"}
+		if fp == nil || *fp != expect {
+			t.Errorf("expected fingerprint %+v, got %+v", expect, fp)
+		}
+	})
+
+	t.Run("template", func(t *testing.T) {
+		fp := generate(t, api.GenerateRequest{Model: "test", Prompt: "Hello!", Template: "Q: {{ .Prompt }}", Stream: &stream})
+		if fp == nil || fp.TemplateHash != templateHash("Q: {{ .Prompt }}") {
+			t.Errorf("expected the hash of the request template, got %+v", fp)
+		}
+	})
+
+	t.Run("raw", func(t *testing.T) {
+		fp := generate(t, api.GenerateRequest{Model: "test", Prompt: "Hello!", Raw: true, Stream: &stream})
+		if fp == nil || fp.TemplateHash != "" {
+			t.Errorf("expected no template hash, got %+v", fp)
+		}
+	})
+
+	t.Run("chat", func(t *testing.T) {
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "test",
+			Messages: []api.Message{{Role: "user", Content: "Hello!"}},
+			Stream:   &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.ChatResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		expect := api.Fingerprint{Digest: m.Digest, TemplateHash: templateHash(tmpl), Engine: "llama.cpp"}
+		if resp.Fingerprint == nil || *resp.Fingerprint != expect {
+			t.Errorf("expected fingerprint %+v, got %+v", expect, resp.Fingerprint)
+		}
+	})
+}
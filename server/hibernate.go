@@ -0,0 +1,227 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+// hibernation is the contents of OLLAMA_HIBERNATE_FILE: the models that were
+// loaded when the server stopped.
+//
+// Device memory is freed when a runner exits and can't be mapped into a new
+// one, so the runners of the Ollama engine also save a restore image of
+// their weights as they were placed on the devices next to the file. A
+// runner that places the weights of the model the same way copies them from
+// the image to the devices rather than parsing the model file again. What a
+// runner computed is kept apart from this: the K/V cache of prompts with a
+// cache key is saved to OLLAMA_KV_CACHE_DIR and restored by the reloaded
+// runner.
+type hibernation struct {
+	SavedAt time.Time         `json:"saved_at"`
+	Models  []hibernatedModel `json:"models"`
+}
+
+// hibernatedModel is a loaded model and what is needed to load it the same
+// way again.
+type hibernatedModel struct {
+	Model  string `json:"model"`
+	Digest string `json:"digest"`
+
+	Options api.Options `json:"options"`
+
+	// KeepAlive is how much longer the model was to stay loaded
+	KeepAlive time.Duration `json:"keep_alive"`
+
+	// SizeVRAM is how much of the model was in device memory. Models are
+	// restored from the largest so that they are placed on devices first.
+	SizeVRAM uint64 `json:"size_vram"`
+
+	// Image is the restore image of the weights, if the runner saved one
+	Image string `json:"image,omitempty"`
+
+	modelPath string
+}
+
+// hibernate writes the models that are loaded to path, and the restore
+// images of their runners to the images directory next to it.
+func (s *Scheduler) hibernate(path string) error {
+	h := hibernation{SavedAt: time.Now()}
+
+	// the runners of the models, in the same order
+	var runners []llm.LlamaServer
+
+	s.loadedMu.Lock()
+	for _, runner := range s.loaded {
+		runner.refMu.Lock()
+		if runner.model != nil && runner.Options != nil {
+			keepAlive := runner.sessionDuration
+			if runner.refCount == 0 && !runner.expiresAt.IsZero() && keepAlive < time.Duration(math.MaxInt64) {
+				keepAlive = time.Until(runner.expiresAt)
+			}
+
+			// runners hold the context of all of their parallel requests
+			opts := *runner.Options
+			if runner.numParallel > 0 {
+				opts.NumCtx /= runner.numParallel
+			}
+
+			if keepAlive > 0 {
+				h.Models = append(h.Models, hibernatedModel{
+					Model:     runner.model.ShortName,
+					Digest:    runner.model.Digest,
+					Options:   opts,
+					KeepAlive: keepAlive,
+					SizeVRAM:  runner.estimatedVRAM,
+					modelPath: runner.model.ModelPath,
+				})
+				runners = append(runners, runner.llama)
+			}
+		}
+		runner.refMu.Unlock()
+	}
+	s.loadedMu.Unlock()
+
+	// images are saved outside of the locks as they are as large as the
+	// weights of the models
+	images := make(map[string]bool)
+	for i, runner := range runners {
+		if runner == nil {
+			continue
+		}
+
+		image := llm.ImagePath(path, h.Models[i].modelPath)
+		if err := runner.SaveImage(context.Background(), image); errors.Is(err, llm.ErrImageUnsupported) {
+			slog.Debug("not saving a restore image", "model", h.Models[i].Model, "error", err)
+		} else if err != nil {
+			slog.Warn("unable to save restore image", "model", h.Models[i].Model, "error", err)
+		} else {
+			h.Models[i].Image = image
+			images[filepath.Base(image)] = true
+		}
+	}
+
+	// images of models that are no longer loaded would never be read
+	if entries, err := os.ReadDir(path + ".images"); err == nil {
+		for _, entry := range entries {
+			if !images[entry.Name()] {
+				os.RemoveAll(filepath.Join(path+".images", entry.Name()))
+			}
+		}
+	}
+
+	slices.SortStableFunc(h.Models, func(a, b hibernatedModel) int {
+		return cmp.Or(cmp.Compare(b.SizeVRAM, a.SizeVRAM), cmp.Compare(a.Model, b.Model))
+	})
+
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+"-partial-")
+	if err != nil {
+		return err
+	}
+	defer temp.Close()
+	defer os.Remove(temp.Name())
+
+	if err := json.NewEncoder(temp).Encode(h); err != nil {
+		return err
+	}
+
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), path)
+}
+
+// restore loads the models that hibernate wrote to path in the background
+// and removes the file, and each restore image once its model is loaded, so
+// that models are only restored once. Models that
+// have changed since, or whose keep alive ran out while the server was
+// stopped, are not loaded.
+func (s *Server) restore(ctx context.Context, path string) {
+	bts, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	} else if err != nil {
+		slog.Warn("unable to read hibernated models", "path", path, "error", err)
+		return
+	}
+
+	if err := os.Remove(path); err != nil {
+		slog.Warn("unable to remove hibernated models", "path", path, "error", err)
+	}
+
+	var h hibernation
+	if err := json.Unmarshal(bts, &h); err != nil {
+		slog.Warn("unable to read hibernated models", "path", path, "error", err)
+		return
+	}
+
+	go func() {
+		for _, hm := range h.Models {
+			// models kept loaded indefinitely stay that way
+			keepAlive := hm.KeepAlive
+			if keepAlive < time.Duration(math.MaxInt64) {
+				keepAlive -= time.Since(h.SavedAt)
+			}
+
+			if err := s.restoreModel(ctx, hm, keepAlive); err != nil {
+				slog.Warn("unable to restore model", "model", hm.Model, "error", err)
+			}
+
+			// the server may be hibernating again, saving new images
+			if ctx.Err() != nil {
+				return
+			}
+
+			// the runner has read the image, or won't, once it has loaded
+			if hm.Image != "" {
+				if err := os.Remove(hm.Image); err != nil && !errors.Is(err, os.ErrNotExist) {
+					slog.Warn("unable to remove restore image", "path", hm.Image, "error", err)
+				}
+			}
+		}
+	}()
+}
+
+func (s *Server) restoreModel(ctx context.Context, hm hibernatedModel, keepAlive time.Duration) error {
+	if keepAlive <= 0 {
+		slog.Info("not restoring model whose keep alive has expired", "model", hm.Model)
+		return nil
+	}
+
+	m, err := GetModel(hm.Model)
+	if err != nil {
+		return err
+	}
+
+	if m.Digest != hm.Digest {
+		slog.Info("not restoring model that has changed", "model", hm.Model)
+		return nil
+	}
+
+	// the runner is released once loaded, so that the model stays loaded
+	// for the rest of its keep alive
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	runnerCh, errCh := s.sched.GetRunner(ctx, m, hm.Options, &api.Duration{Duration: keepAlive})
+	select {
+	case <-runnerCh:
+		slog.Info("restored model", "model", hm.Model, "keep_alive", keepAlive)
+		return nil
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
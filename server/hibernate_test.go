@@ -0,0 +1,192 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/llm"
+)
+
+// mockImageRunner saves restore images that don't hold any weights
+type mockImageRunner struct {
+	mockRunner
+}
+
+func (m *mockImageRunner) SaveImage(_ context.Context, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, []byte("image"), 0o644)
+}
+
+func TestHibernate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var mock mockRunner
+	loads := make(chan *LlmRequest, 2)
+	s := Server{
+		sched: &Scheduler{
+			pendingReqCh:  make(chan *LlmRequest, 1),
+			finishedReqCh: make(chan *LlmRequest, 1),
+			expiredCh:     make(chan *runnerRef, 1),
+			unloadedCh:    make(chan any, 1),
+			loaded:        make(map[string]*runnerRef),
+			newServerFn:   newMockServer(&mock),
+			getGpuFn:      discover.GetGPUInfo,
+			getCpuFn:      discover.GetCPUInfo,
+			reschedDelay:  250 * time.Millisecond,
+			loadFn: func(req *LlmRequest, _ *ggml.GGML, _ discover.GpuInfoList, _ int) {
+				loads <- req
+				req.successCh <- &runnerRef{llama: &mock}
+			},
+		},
+	}
+
+	go s.sched.Run(t.Context())
+
+	_, digest := createBinFile(t, ggml.KV{
+		"general.architecture":       "llama",
+		"llama.block_count":          uint32(1),
+		"llama.context_length":       uint32(2048),
+		"llama.embedding_length":     uint32(1024),
+		"llama.attention.head_count": uint32(8),
+		"tokenizer.ggml.tokens":      []string{""},
+		"tokenizer.ggml.scores":      []float32{0},
+		"tokenizer.ggml.token_type":  []int32{0},
+	}, []ggml.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "output.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+	})
+
+	for _, name := range []string{"test", "other"} {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:    name,
+			Files:    map[string]string{"file.gguf": digest},
+			Template: "{{ .Prompt }}",
+			Stream:   &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := api.DefaultOptions()
+	opts.NumCtx = 16384
+	sched := &Scheduler{loaded: map[string]*runnerRef{
+		// idle for another minute
+		"test": {llama: &mockImageRunner{}, model: m, Options: &opts, numParallel: 2, sessionDuration: 5 * time.Minute, expiresAt: time.Now().Add(time.Minute), estimatedVRAM: 2},
+		// the model changed after it was loaded
+		"other": {model: &Model{ShortName: "other:latest", Digest: "sha256:old"}, Options: &opts, sessionDuration: time.Minute, estimatedVRAM: 1},
+		// its keep alive has run out
+		"expired": {model: &Model{ShortName: "expired:latest"}, Options: &opts, expiresAt: time.Now().Add(-time.Second), estimatedVRAM: 3},
+		// unloaded
+		"unloaded": {},
+	}}
+
+	path := filepath.Join(t.TempDir(), "hibernate.json")
+
+	// left by a model that is no longer loaded
+	stale := llm.ImagePath(path, "sha256-stale")
+	if err := os.MkdirAll(filepath.Dir(stale), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(stale, []byte("image"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sched.hibernate(path); err != nil {
+		t.Fatal(err)
+	}
+
+	bts, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var h hibernation
+	if err := json.Unmarshal(bts, &h); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(h.Models) != 2 || h.Models[0].Model != "test:latest" || h.Models[1].Model != "other:latest" {
+		t.Fatalf("expected the loaded models from the largest, got %+v", h.Models)
+	}
+
+	if keepAlive := h.Models[0].KeepAlive; keepAlive <= 0 || keepAlive > time.Minute {
+		t.Errorf("expected the remaining keep alive, got %v", keepAlive)
+	}
+
+	if h.Models[0].Options.NumCtx != 8192 || h.Models[0].Digest != m.Digest {
+		t.Errorf("expected the options and digest of the model, got %+v", h.Models[0])
+	}
+
+	image := llm.ImagePath(path, m.ModelPath)
+	if h.Models[0].Image != image || h.Models[1].Image != "" {
+		t.Errorf("expected a restore image of test:latest only, got %q %q", h.Models[0].Image, h.Models[1].Image)
+	}
+
+	if _, err := os.Stat(image); err != nil {
+		t.Errorf("expected the restore image to be saved, got %v", err)
+	}
+
+	if _, err := os.Stat(stale); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the stale restore image to be removed, got %v", err)
+	}
+
+	s.restore(t.Context(), path)
+
+	select {
+	case req := <-loads:
+		if req.model.ShortName != "test:latest" || req.origNumCtx != 8192 {
+			t.Errorf("expected test:latest to be restored with its context, got %s %d", req.model.ShortName, req.origNumCtx)
+		}
+
+		if req.sessionDuration == nil || req.sessionDuration.Duration <= 0 || req.sessionDuration.Duration > time.Minute {
+			t.Errorf("expected the remaining keep alive, got %v", req.sessionDuration)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the model to be restored")
+	}
+
+	// the image is removed once the model is loaded
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(image); errors.Is(err, os.ErrNotExist) {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("expected the restore image to be removed, got %v", err)
+		}
+	}
+
+	// models are only restored once
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the hibernated models to be removed, got %v", err)
+	}
+
+	select {
+	case req := <-loads:
+		t.Errorf("expected only one model to be restored, got %s", req.model.ShortName)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// restoring without hibernated models does nothing
+	s.restore(context.Background(), path)
+}
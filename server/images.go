@@ -20,6 +20,7 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/envconfig"
@@ -31,18 +32,20 @@ import (
 )
 
 var (
-	errCapabilities         = errors.New("does not support")
-	errCapabilityCompletion = errors.New("completion")
-	errCapabilityTools      = errors.New("tools")
-	errCapabilityInsert     = errors.New("insert")
+	errCapabilities             = errors.New("does not support")
+	errCapabilityCompletion     = errors.New("completion")
+	errCapabilityTools          = errors.New("tools")
+	errCapabilityInsert         = errors.New("insert")
+	errCapabilityClassification = errors.New("classification")
 )
 
 type Capability string
 
 const (
-	CapabilityCompletion = Capability("completion")
-	CapabilityTools      = Capability("tools")
-	CapabilityInsert     = Capability("insert")
+	CapabilityCompletion     = Capability("completion")
+	CapabilityTools          = Capability("tools")
+	CapabilityInsert         = Capability("insert")
+	CapabilityClassification = Capability("classification")
 )
 
 type registryOptions struct {
@@ -74,26 +77,44 @@ type Model struct {
 // CheckCapabilities checks if the model has the specified capabilities returning an error describing
 // any missing or unknown capabilities
 func (m *Model) CheckCapabilities(caps ...Capability) error {
+	kv := func() ggml.KV {
+		r, err := os.Open(m.ModelPath)
+		if err != nil {
+			slog.Error("couldn't open model file", "error", err)
+			return nil
+		}
+		defer r.Close()
+
+		// TODO(mxyng): decode the GGML into model to avoid doing this multiple times
+		f, _, err := ggml.Decode(r, 0)
+		if err != nil {
+			slog.Error("couldn't decode ggml", "error", err)
+			return nil
+		}
+
+		return f.KV()
+	}
+
 	var errs []error
 	for _, cap := range caps {
 		switch cap {
 		case CapabilityCompletion:
-			r, err := os.Open(m.ModelPath)
-			if err != nil {
-				slog.Error("couldn't open model file", "error", err)
+			kv := kv()
+			if kv == nil {
 				continue
 			}
-			defer r.Close()
 
-			// TODO(mxyng): decode the GGML into model to avoid doing this multiple times
-			f, _, err := ggml.Decode(r, 0)
-			if err != nil {
-				slog.Error("couldn't decode ggml", "error", err)
+			if _, ok := kv[fmt.Sprintf("%s.pooling_type", kv.Architecture())]; ok || kv.Classifier() {
+				errs = append(errs, errCapabilityCompletion)
+			}
+		case CapabilityClassification:
+			kv := kv()
+			if kv == nil {
 				continue
 			}
 
-			if _, ok := f.KV()[fmt.Sprintf("%s.pooling_type", f.KV().Architecture())]; ok {
-				errs = append(errs, errCapabilityCompletion)
+			if !kv.Classifier() {
+				errs = append(errs, errCapabilityClassification)
 			}
 		case CapabilityTools:
 			if !slices.Contains(m.Template.Vars(), "tools") {
@@ -258,6 +279,7 @@ func GetModel(name string) (*Model, error) {
 		}
 	}
 
+	var modelPaths []string
 	for _, layer := range manifest.Layers {
 		filename, err := GetBlobsPath(layer.Digest)
 		if err != nil {
@@ -266,15 +288,25 @@ func GetModel(name string) (*Model, error) {
 
 		switch layer.MediaType {
 		case "application/vnd.ollama.image.model":
-			model.ModelPath = filename
+			modelPaths = append(modelPaths, filename)
 			model.ParentModel = layer.From
 		case "application/vnd.ollama.image.embed":
 			// Deprecated in versions  > 0.1.2
 			// TODO: remove this warning in a future version
 			slog.Info("WARNING: model contains embeddings, but embeddings in modelfiles have been deprecated and will be ignored.")
 		case "application/vnd.ollama.image.adapter":
+			filename, err = preparedModelFile(filename)
+			if err != nil {
+				return nil, err
+			}
+
 			model.AdapterPaths = append(model.AdapterPaths, filename)
 		case "application/vnd.ollama.image.projector":
+			filename, err = preparedModelFile(filename)
+			if err != nil {
+				return nil, err
+			}
+
 			model.ProjectorPaths = append(model.ProjectorPaths, filename)
 		case "application/vnd.ollama.image.prompt",
 			"application/vnd.ollama.image.template":
@@ -324,6 +356,18 @@ func GetModel(name string) (*Model, error) {
 		}
 	}
 
+	if len(modelPaths) > 0 {
+		// the shards of a split model are merged into the model file of the
+		// first one, which saves reading the shards to tell
+		model.ModelPath, err = preparedModelFile(modelPaths...)
+		if errors.Is(err, errModelNotPrepared) {
+			model.ModelPath, err = preparedModelFile(modelLayerPaths(modelPaths)...)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return model, nil
 }
 
@@ -344,29 +388,29 @@ func CopyModel(src, dst model.Name) error {
 		return err
 	}
 
-	dstpath := filepath.Join(manifests, dst.Filepath())
-	if err := os.MkdirAll(filepath.Dir(dstpath), 0o755); err != nil {
-		return err
-	}
-
-	srcpath := filepath.Join(manifests, src.Filepath())
-	srcfile, err := os.Open(srcpath)
+	unlock, err := lockStore(context.TODO())
 	if err != nil {
 		return err
 	}
-	defer srcfile.Close()
+	defer unlock()
 
-	dstfile, err := os.Create(dstpath)
+	bts, err := os.ReadFile(filepath.Join(manifests, src.Filepath()))
 	if err != nil {
 		return err
 	}
-	defer dstfile.Close()
 
-	_, err = io.Copy(dstfile, srcfile)
-	return err
+	return writeManifestFile(filepath.Join(manifests, dst.Filepath()), bts)
 }
 
+// deleteUnusedLayers removes the blobs in deleteMap that no manifest uses,
+// unless the model store is in use.
 func deleteUnusedLayers(deleteMap map[string]struct{}) error {
+	return collectGarbage(func() error {
+		return removeUnusedLayers(deleteMap)
+	})
+}
+
+func removeUnusedLayers(deleteMap map[string]struct{}) error {
 	// Ignore corrupt manifests to avoid blocking deletion of layers that are freshly orphaned
 	manifests, err := Manifests(true)
 	if err != nil {
@@ -392,12 +436,33 @@ func deleteUnusedLayers(deleteMap map[string]struct{}) error {
 			slog.Info(fmt.Sprintf("couldn't remove file '%s': %v", fp, err))
 			continue
 		}
+
+		if sp, err := GetChecksumsPath(k); err == nil {
+			if err := os.Remove(sp); err != nil && !errors.Is(err, os.ErrNotExist) {
+				slog.Info(fmt.Sprintf("couldn't remove file '%s': %v", sp, err))
+			}
+		}
+
+		if cp, err := GetModelCachePath(k); err == nil {
+			if err := os.Remove(cp); err != nil && !errors.Is(err, os.ErrNotExist) {
+				slog.Info(fmt.Sprintf("couldn't remove file '%s': %v", cp, err))
+			}
+		}
 	}
 
 	return nil
 }
 
+// pruneGracePeriod is how long new blobs are kept by PruneLayers even if
+// no manifest uses them, since another server sharing the model store may
+// be about to create a model from them
+const pruneGracePeriod = time.Hour
+
 func PruneLayers() error {
+	return collectGarbage(pruneLayers)
+}
+
+func pruneLayers() error {
 	deleteMap := make(map[string]struct{})
 	p, err := GetBlobsPath("")
 	if err != nil {
@@ -426,12 +491,16 @@ func PruneLayers() error {
 			continue
 		}
 
+		if info, err := blob.Info(); err == nil && time.Since(info.ModTime()) < pruneGracePeriod {
+			continue
+		}
+
 		deleteMap[name] = struct{}{}
 	}
 
 	slog.Info(fmt.Sprintf("total blobs: %d", len(deleteMap)))
 
-	if err := deleteUnusedLayers(deleteMap); err != nil {
+	if err := removeUnusedLayers(deleteMap); err != nil {
 		slog.Error(fmt.Sprintf("couldn't remove unused layers: %v", err))
 		return nil
 	}
@@ -482,6 +551,11 @@ func PushModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 		return errors.New("insecure protocol http")
 	}
 
+	// weights may have been evicted from the blob store cache
+	if err := fetchModel(ctx, model.ParseName(mp.GetFullTagname())); err != nil {
+		return err
+	}
+
 	manifest, _, err := GetManifest(mp)
 	if err != nil {
 		fn(api.ProgressResponse{Status: "couldn't retrieve manifest"})
@@ -496,7 +570,7 @@ func PushModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 
 	for _, layer := range layers {
 		if err := uploadBlob(ctx, mp, layer, regOpts, fn); err != nil {
-			slog.Info(fmt.Sprintf("error uploading blob: %v", err))
+			registryLog.Info(fmt.Sprintf("error uploading blob: %v", err))
 			return err
 		}
 	}
@@ -524,15 +598,29 @@ func PushModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 }
 
 func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn func(api.ProgressResponse)) error {
+	return pullModel(ctx, name, regOpts, nil, fn)
+}
+
+// pullModel pulls the model name and, if verify is not nil, checks its
+// manifest with verify before replacing the local manifest.
+func pullModel(ctx context.Context, name string, regOpts *registryOptions, verify func(*Manifest) error, fn func(api.ProgressResponse)) error {
 	mp := ParseModelPath(name)
 
+	// keep other pulls and servers from removing the blobs before the
+	// manifest that uses them is written
+	unlock, err := lockStore(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { unlock() }()
+
 	// build deleteMap to prune unused layers
 	deleteMap := make(map[string]struct{})
 	manifest, _, err := GetManifest(mp)
 	if errors.Is(err, os.ErrNotExist) {
 		// noop
 	} else if err != nil {
-		slog.Warn("pulling model with bad existing manifest", "name", name, "error", err)
+		registryLog.Warn("pulling model with bad existing manifest", "name", name, "error", err)
 	} else {
 		for _, l := range manifest.Layers {
 			deleteMap[l.Digest] = struct{}{}
@@ -589,13 +677,23 @@ func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 				}
 				if err := os.Remove(fp); err != nil {
 					// log this, but return the original error
-					slog.Info(fmt.Sprintf("couldn't remove file with digest mismatch '%s': %v", fp, err))
+					registryLog.Info(fmt.Sprintf("couldn't remove file with digest mismatch '%s': %v", fp, err))
 				}
 			}
 			return err
 		}
 	}
 
+	if verify != nil {
+		if err := verify(manifest); err != nil {
+			return err
+		}
+	}
+
+	if err := prepareModelFiles(layers, fn); err != nil {
+		return err
+	}
+
 	fn(api.ProgressResponse{Status: "writing manifest"})
 
 	manifestJSON, err := json.Marshal(manifest)
@@ -607,16 +705,15 @@ func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 	if err != nil {
 		return err
 	}
-	if err := os.MkdirAll(filepath.Dir(fp), 0o755); err != nil {
-		return err
-	}
 
-	err = os.WriteFile(fp, manifestJSON, 0o644)
-	if err != nil {
-		slog.Info(fmt.Sprintf("couldn't write to %s", fp))
+	if err := writeManifestFile(fp, manifestJSON); err != nil {
+		registryLog.Info(fmt.Sprintf("couldn't write to %s", fp))
 		return err
 	}
 
+	unlock()
+	unlock = func() {}
+
 	if !envconfig.NoPrune() && len(deleteMap) > 0 {
 		fn(api.ProgressResponse{Status: "removing unused layers"})
 		if err := deleteUnusedLayers(deleteMap); err != nil {
@@ -624,6 +721,10 @@ func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 		}
 	}
 
+	if err := storeModel(ctx, model.ParseName(mp.GetFullTagname()), fn); err != nil {
+		return err
+	}
+
 	fn(api.ProgressResponse{Status: "success"})
 
 	return nil
@@ -666,7 +767,7 @@ func makeRequestWithRetry(ctx context.Context, method string, requestURL *url.UR
 		resp, err := makeRequest(ctx, method, requestURL, headers, body, regOpts)
 		if err != nil {
 			if !errors.Is(err, context.Canceled) {
-				slog.Info(fmt.Sprintf("request failed: %v", err))
+				registryLog.Info(fmt.Sprintf("request failed: %v", err))
 			}
 
 			return nil, err
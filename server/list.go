@@ -0,0 +1,309 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+// capabilities are the capabilities models can be listed and searched by
+var capabilities = []Capability{CapabilityCompletion, CapabilityTools, CapabilityInsert, CapabilityClassification}
+
+// localModels returns the models available locally, in no particular order.
+func localModels() ([]api.ListModelResponse, error) {
+	ms, err := Manifests(true)
+	if err != nil {
+		return nil, err
+	}
+
+	models := []api.ListModelResponse{}
+	for n, m := range ms {
+		var cf ConfigV2
+
+		if m.Config.Digest != "" {
+			f, err := m.Config.Open()
+			if err != nil {
+				slog.Warn("bad manifest filepath", "name", n, "error", err)
+				continue
+			}
+			defer f.Close()
+
+			if err := json.NewDecoder(f).Decode(&cf); err != nil {
+				slog.Warn("bad manifest config", "name", n, "error", err)
+				continue
+			}
+		}
+
+		// tag should never be masked
+		models = append(models, api.ListModelResponse{
+			Model:      n.DisplayShortest(),
+			Name:       n.DisplayShortest(),
+			Size:       m.Size(),
+			Digest:     m.digest,
+			ModifiedAt: m.fi.ModTime(),
+			Details: api.ModelDetails{
+				Format:            cf.ModelFormat,
+				Family:            cf.ModelFamily,
+				Families:          cf.ModelFamilies,
+				ParameterSize:     cf.ModelType,
+				QuantizationLevel: cf.FileType,
+			},
+		})
+	}
+
+	return models, nil
+}
+
+// parseListRequest parses the query parameters of /api/tags, the inverse of
+// [api.ListRequest.Query].
+func parseListRequest(q url.Values) (api.ListRequest, error) {
+	r := api.ListRequest{
+		Family:       q.Get("family"),
+		Capabilities: q["capability"],
+		Sort:         cmp.Or(q.Get("sort"), "modified"),
+		Order:        q.Get("order"),
+	}
+
+	for _, c := range r.Capabilities {
+		if !slices.Contains(capabilities, Capability(c)) {
+			return r, fmt.Errorf("unknown capability %q", c)
+		}
+	}
+
+	if !slices.Contains([]string{"modified", "name", "size"}, r.Sort) {
+		return r, fmt.Errorf("unknown sort %q", r.Sort)
+	}
+
+	if !slices.Contains([]string{"", "asc", "desc"}, r.Order) {
+		return r, fmt.Errorf("unknown order %q", r.Order)
+	}
+
+	for key, v := range map[string]*int64{"min_size": &r.MinSize, "max_size": &r.MaxSize} {
+		if s := q.Get(key); s != "" {
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || n < 0 {
+				return r, fmt.Errorf("invalid %s %q", key, s)
+			}
+
+			*v = n
+		}
+	}
+
+	for key, v := range map[string]*int{"limit": &r.Limit, "offset": &r.Offset} {
+		if s := q.Get(key); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				return r, fmt.Errorf("invalid %s %q", key, s)
+			}
+
+			*v = n
+		}
+	}
+
+	for key, v := range map[string]*time.Time{"modified_after": &r.ModifiedAfter, "modified_before": &r.ModifiedBefore} {
+		if s := q.Get(key); s != "" {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return r, fmt.Errorf("invalid %s %q, expected an RFC 3339 time", key, s)
+			}
+
+			*v = t
+		}
+	}
+
+	return r, nil
+}
+
+// listModels filters, sorts and paginates models as r requests and returns
+// them with the number of models matching the filters.
+func listModels(models []api.ListModelResponse, r api.ListRequest) ([]api.ListModelResponse, int) {
+	models = slices.DeleteFunc(models, func(m api.ListModelResponse) bool {
+		switch {
+		case r.Family != "" && !matchFamily(m.Details, r.Family):
+			return true
+		case r.MinSize != 0 && m.Size < r.MinSize, r.MaxSize != 0 && m.Size > r.MaxSize:
+			return true
+		case !r.ModifiedAfter.IsZero() && !m.ModifiedAt.After(r.ModifiedAfter),
+			!r.ModifiedBefore.IsZero() && !m.ModifiedAt.Before(r.ModifiedBefore):
+			return true
+		case len(r.Capabilities) > 0:
+			caps := make([]Capability, len(r.Capabilities))
+			for i, c := range r.Capabilities {
+				caps[i] = Capability(c)
+			}
+
+			model, err := GetModel(m.Model)
+			return err != nil || model.CheckCapabilities(caps...) != nil
+		}
+
+		return false
+	})
+
+	// models are sorted in descending order by default, except by name
+	desc := r.Order == "desc" || r.Order == "" && r.Sort != "name"
+	slices.SortStableFunc(models, func(a, b api.ListModelResponse) int {
+		var c int
+		switch r.Sort {
+		case "size":
+			c = cmp.Compare(a.Size, b.Size)
+		case "name":
+			c = cmp.Compare(a.Name, b.Name)
+		case "modified", "":
+			c = a.ModifiedAt.Compare(b.ModifiedAt)
+		}
+
+		if desc {
+			c = -c
+		}
+
+		// break ties by name so that the order is stable across requests
+		return cmp.Or(c, cmp.Compare(a.Name, b.Name))
+	})
+
+	total := len(models)
+	models = models[min(r.Offset, total):]
+	if r.Limit > 0 {
+		models = models[:min(r.Limit, len(models))]
+	}
+
+	return models, total
+}
+
+// matchFamily reports whether the model family or one of its families is
+// family, ignoring case.
+func matchFamily(details api.ModelDetails, family string) bool {
+	return strings.EqualFold(details.Family, family) ||
+		slices.ContainsFunc(details.Families, func(f string) bool { return strings.EqualFold(f, family) })
+}
+
+func (s *Server) SearchModelsHandler(c *gin.Context) {
+	var req api.SearchModelsRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for _, c := range req.Capabilities {
+		if !slices.Contains(capabilities, Capability(c)) {
+			err = errors.Join(err, fmt.Errorf("unknown capability %q", c))
+		}
+	}
+
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	models, err := localModels()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := namePattern(req.Name)
+	resp := api.SearchModelsResponse{Models: []api.SearchModelResponse{}}
+	for _, lm := range models {
+		// filter on the listed metadata first to avoid reading the
+		// models that cannot match
+		if !name.MatchString(lm.Name) ||
+			req.Family != "" && !matchFamily(lm.Details, req.Family) ||
+			req.Quantization != "" && !strings.EqualFold(lm.Details.QuantizationLevel, req.Quantization) {
+			continue
+		}
+
+		sm, err := searchModel(lm)
+		if err != nil {
+			slog.Warn("bad model", "name", lm.Name, "error", err)
+			continue
+		}
+
+		if req.MinParameters != 0 && sm.ParameterCount < req.MinParameters ||
+			req.MaxParameters != 0 && sm.ParameterCount > req.MaxParameters ||
+			req.License != "" && !strings.Contains(strings.ToLower(sm.License), strings.ToLower(req.License)) ||
+			slices.ContainsFunc(req.Capabilities, func(c string) bool { return !slices.Contains(sm.Capabilities, c) }) {
+			continue
+		}
+
+		resp.Models = append(resp.Models, sm)
+	}
+
+	slices.SortFunc(resp.Models, func(a, b api.SearchModelResponse) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// namePattern compiles a name of [api.SearchModelsRequest] into a case
+// insensitive regular expression.
+func namePattern(name string) *regexp.Regexp {
+	pattern := regexp.QuoteMeta(name)
+	if strings.ContainsAny(name, "*?") {
+		pattern = strings.NewReplacer(`\*`, ".*", `\?`, ".").Replace(pattern)
+		pattern = "^" + pattern + "$"
+	}
+
+	return regexp.MustCompile("(?i)" + pattern)
+}
+
+// searchModel reads the metadata of lm that is not in its manifest.
+func searchModel(lm api.ListModelResponse) (api.SearchModelResponse, error) {
+	m, err := GetModel(lm.Name)
+	if err != nil {
+		return api.SearchModelResponse{}, err
+	}
+
+	f, err := llm.LoadModel(m.ModelPath, 0)
+	if err != nil {
+		return api.SearchModelResponse{}, err
+	}
+
+	kv := f.KV()
+
+	// prefer the license identifier of the model file, such as
+	// "apache-2.0", to the license text
+	license, _ := kv["general.license"].(string)
+	if license == "" && len(m.License) > 0 {
+		license, _, _ = strings.Cut(strings.TrimSpace(m.License[0]), "\n")
+	}
+
+	sm := api.SearchModelResponse{
+		Name:           lm.Name,
+		Model:          lm.Model,
+		ModifiedAt:     lm.ModifiedAt,
+		Size:           lm.Size,
+		Digest:         lm.Digest,
+		Details:        lm.Details,
+		ParameterCount: kv.ParameterCount(),
+		License:        license,
+		Capabilities:   []string{},
+	}
+
+	for _, c := range capabilities {
+		if m.CheckCapabilities(c) == nil {
+			sm.Capabilities = append(sm.Capabilities, string(c))
+		}
+	}
+
+	return sm, nil
+}
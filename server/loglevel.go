@@ -0,0 +1,99 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/logutil"
+)
+
+// logLevels returns the log levels in effect.
+func logLevels() api.LogLevels {
+	level, subsystems := logutil.Levels()
+	resp := api.LogLevels{Level: strings.ToLower(level.String())}
+	for name, level := range subsystems {
+		if resp.Subsystems == nil {
+			resp.Subsystems = make(map[string]string)
+		}
+
+		resp.Subsystems[name] = strings.ToLower(level.String())
+	}
+
+	return resp
+}
+
+func (s *Server) LogLevelsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, logLevels())
+}
+
+func (s *Server) SetLogLevelsHandler(c *gin.Context) {
+	var req api.LogLevels
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// every level is checked before any is set so that a bad request
+	// changes nothing
+	parse := func(name string) (slog.Level, error) {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(name)); err != nil {
+			return 0, fmt.Errorf("invalid log level %q", name)
+		}
+		return level, nil
+	}
+
+	var level slog.Level
+	if req.Level != "" {
+		if level, err = parse(req.Level); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	subsystems := make(map[string]slog.Level)
+	for name, s := range req.Subsystems {
+		if !slices.Contains(logutil.Subsystems, name) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown log subsystem %q, expected one of %s", name, strings.Join(logutil.Subsystems, ", "))})
+			return
+		}
+
+		if s == "" {
+			continue
+		}
+
+		if subsystems[name], err = parse(s); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if req.Level != "" {
+		logutil.SetLevel("", level)
+	}
+
+	for name, s := range req.Subsystems {
+		if s == "" {
+			logutil.ResetLevel(name)
+		} else {
+			logutil.SetLevel(name, subsystems[name])
+		}
+	}
+
+	resp := logLevels()
+	slog.InfoContext(c.Request.Context(), "log levels changed", "level", resp.Level, "subsystems", resp.Subsystems)
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/logutil"
+)
+
+func TestLogLevelsHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Cleanup(func() {
+		logutil.SetLevel("", slog.LevelInfo)
+		for _, name := range logutil.Subsystems {
+			logutil.ResetLevel(name)
+		}
+	})
+
+	var s Server
+
+	set := func(t *testing.T, req any, status int) api.LogLevels {
+		t.Helper()
+		w := createRequest(t, s.SetLogLevelsHandler, req)
+		if w.Code != status {
+			t.Fatalf("expected status %d, got %d: %s", status, w.Code, w.Body)
+		}
+
+		var resp api.LogLevels
+		if status == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return resp
+	}
+
+	resp := set(t, api.LogLevels{Level: "warn", Subsystems: map[string]string{"scheduler": "debug", "runner": "error"}}, http.StatusOK)
+	expect := api.LogLevels{Level: "warn", Subsystems: map[string]string{"scheduler": "debug", "runner": "error"}}
+	if diff := cmp.Diff(expect, resp); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	if logutil.Level(logutil.Scheduler) != slog.LevelDebug || logutil.Level(logutil.Registry) != slog.LevelWarn {
+		t.Errorf("unexpected levels %s", logutil.FormatLevels())
+	}
+
+	// an empty level leaves the default alone and resets subsystems
+	resp = set(t, api.LogLevels{Subsystems: map[string]string{"runner": ""}}, http.StatusOK)
+	expect = api.LogLevels{Level: "warn", Subsystems: map[string]string{"scheduler": "debug"}}
+	if diff := cmp.Diff(expect, resp); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	// bad requests change nothing
+	set(t, api.LogLevels{Level: "debug", Subsystems: map[string]string{"gpu": "debug"}}, http.StatusBadRequest)
+	set(t, api.LogLevels{Level: "debug", Subsystems: map[string]string{"runner": "loud"}}, http.StatusBadRequest)
+	set(t, api.LogLevels{Level: "loud"}, http.StatusBadRequest)
+
+	w := createRequest(t, s.LogLevelsHandler, nil)
+	resp = api.LogLevels{}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(expect, resp); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -38,26 +38,34 @@ func (m *Manifest) Remove() error {
 		return err
 	}
 
+	quantizations.reset()
+
 	manifests, err := GetManifestPath()
 	if err != nil {
 		return err
 	}
 
-	return PruneDirectory(manifests)
+	// directories are only removed when the store is not in use, since
+	// they may be about to hold a new manifest
+	return collectGarbage(func() error {
+		return PruneDirectory(manifests)
+	})
 }
 
 func (m *Manifest) RemoveLayers() error {
-	for _, layer := range append(m.Layers, m.Config) {
-		if layer.Digest != "" {
-			if err := layer.Remove(); errors.Is(err, os.ErrNotExist) {
-				slog.Debug("layer does not exist", "digest", layer.Digest)
-			} else if err != nil {
-				return err
+	return collectGarbage(func() error {
+		for _, layer := range append(m.Layers, m.Config) {
+			if layer.Digest != "" {
+				if err := layer.Remove(); errors.Is(err, os.ErrNotExist) {
+					slog.Debug("layer does not exist", "digest", layer.Digest)
+				} else if err != nil {
+					return err
+				}
 			}
 		}
-	}
 
-	return nil
+		return nil
+	})
 }
 
 func ParseNamedManifest(n model.Name) (*Manifest, error) {
@@ -102,25 +110,56 @@ func WriteManifest(name model.Name, config Layer, layers []Layer) error {
 		return err
 	}
 
-	p := filepath.Join(manifests, name.Filepath())
+	m := Manifest{
+		SchemaVersion: 2,
+		MediaType:     "application/vnd.docker.distribution.manifest.v2+json",
+		Config:        config,
+		Layers:        layers,
+	}
+
+	bts, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return writeManifestFile(filepath.Join(manifests, name.Filepath()), append(bts, '\n'))
+}
+
+// writeManifestFile replaces the manifest at p with data atomically, so that
+// other servers sharing the model store never read a partial manifest.
+func writeManifestFile(p string, data []byte) error {
 	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
 		return err
 	}
 
-	f, err := os.Create(p)
+	// the temporary file is outside of the directories of manifests so
+	// that it is never listed as one
+	manifests, err := GetManifestPath()
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	m := Manifest{
-		SchemaVersion: 2,
-		MediaType:     "application/vnd.docker.distribution.manifest.v2+json",
-		Config:        config,
-		Layers:        layers,
+	f, err := os.CreateTemp(manifests, ".manifest-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
 	}
 
-	return json.NewEncoder(f).Encode(m)
+	if err := os.Rename(f.Name(), p); err != nil {
+		return err
+	}
+
+	quantizations.reset()
+	return nil
 }
 
 func Manifests(continueOnError bool) (map[model.Name]*Manifest, error) {
@@ -138,7 +177,10 @@ func Manifests(continueOnError bool) (map[model.Name]*Manifest, error) {
 	ms := make(map[model.Name]*Manifest)
 	for _, match := range matches {
 		fi, err := os.Stat(match)
-		if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// removed by another request or server since it was listed
+			continue
+		} else if err != nil {
 			return nil, err
 		}
 
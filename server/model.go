@@ -28,6 +28,10 @@ type layerGGML struct {
 }
 
 func parseFromModel(ctx context.Context, name model.Name, fn func(api.ProgressResponse)) (layers []*layerGGML, err error) {
+	if err := fetchModel(ctx, name); err != nil {
+		return nil, err
+	}
+
 	m, err := ParseNamedManifest(name)
 	switch {
 	case errors.Is(err, os.ErrNotExist):
@@ -80,33 +84,42 @@ func parseFromModel(ctx context.Context, name model.Name, fn func(api.ProgressRe
 
 func detectChatTemplate(layers []*layerGGML) ([]*layerGGML, error) {
 	for _, layer := range layers {
-		if s := layer.GGML.KV().ChatTemplate(); s != "" {
-			if t, err := template.Named(s); err != nil {
+		kv := layer.GGML.KV()
+
+		// base code models have no chat template, but may fill in the middle
+		t, err := template.Infill(kv.Strings("tokenizer.ggml.tokens"))
+		if s := kv.ChatTemplate(); s != "" {
+			t, err = template.Named(s)
+			if err != nil {
 				slog.Debug("template detection", "error", err, "template", s)
-			} else {
-				layer, err := NewLayer(t.Reader(), "application/vnd.ollama.image.template")
-				if err != nil {
-					return nil, err
-				}
-
-				layer.status = fmt.Sprintf("using autodetected template %s", t.Name)
-				layers = append(layers, &layerGGML{layer, nil})
-
-				if t.Parameters != nil {
-					var b bytes.Buffer
-					if err := json.NewEncoder(&b).Encode(t.Parameters); err != nil {
-						return nil, err
-					}
-
-					layer, err := NewLayer(&b, "application/vnd.ollama.image.params")
-					if err != nil {
-						return nil, err
-					}
-
-					layers = append(layers, &layerGGML{layer, nil})
-				}
 			}
 		}
+
+		if err != nil {
+			continue
+		}
+
+		layer, err := NewLayer(t.Reader(), "application/vnd.ollama.image.template")
+		if err != nil {
+			return nil, err
+		}
+
+		layer.status = fmt.Sprintf("using autodetected template %s", t.Name)
+		layers = append(layers, &layerGGML{layer, nil})
+
+		if t.Parameters != nil {
+			var b bytes.Buffer
+			if err := json.NewEncoder(&b).Encode(t.Parameters); err != nil {
+				return nil, err
+			}
+
+			layer, err := NewLayer(&b, "application/vnd.ollama.image.params")
+			if err != nil {
+				return nil, err
+			}
+
+			layers = append(layers, &layerGGML{layer, nil})
+		}
 	}
 
 	return layers, nil
@@ -153,9 +166,9 @@ func parseObjects(s string) []map[string]any {
 	return objs
 }
 
-// parseToolCalls attempts to parse a JSON string into a slice of ToolCalls.
-// mxyng: this only really works if the input contains tool calls in some JSON format
-func (m *Model) parseToolCalls(s string) ([]api.ToolCall, bool) {
+// toolCallKeys returns the keys of the name and arguments of the tool calls
+// in the JSON objects that the template of m formats them as.
+func (m *Model) toolCallKeys() (name, arguments string, ok bool) {
 	// create a subtree from the node that ranges over .ToolCalls
 	tmpl := m.Template.Subtree(func(n parse.Node) bool {
 		if t, ok := n.(*parse.RangeNode); ok {
@@ -166,7 +179,7 @@ func (m *Model) parseToolCalls(s string) ([]api.ToolCall, bool) {
 	})
 
 	if tmpl == nil {
-		return nil, false
+		return "", "", false
 	}
 
 	var b bytes.Buffer
@@ -182,16 +195,15 @@ func (m *Model) parseToolCalls(s string) ([]api.ToolCall, bool) {
 			},
 		},
 	}); err != nil {
-		return nil, false
+		return "", "", false
 	}
 
 	templateObjects := parseObjects(b.String())
 	if len(templateObjects) == 0 {
-		return nil, false
+		return "", "", false
 	}
 
 	// find the keys that correspond to the name and arguments fields
-	var name, arguments string
 	for k, v := range templateObjects[0] {
 		switch v.(type) {
 		case string:
@@ -201,7 +213,14 @@ func (m *Model) parseToolCalls(s string) ([]api.ToolCall, bool) {
 		}
 	}
 
-	if name == "" || arguments == "" {
+	return name, arguments, name != "" && arguments != ""
+}
+
+// parseToolCalls attempts to parse a JSON string into a slice of ToolCalls.
+// mxyng: this only really works if the input contains tool calls in some JSON format
+func (m *Model) parseToolCalls(s string) ([]api.ToolCall, bool) {
+	name, arguments, ok := m.toolCallKeys()
+	if !ok {
 		return nil, false
 	}
 
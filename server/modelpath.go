@@ -34,6 +34,7 @@ var (
 	ErrInvalidProtocol     = errors.New("invalid protocol scheme")
 	ErrInsecureProtocol    = errors.New("insecure protocol http")
 	ErrInvalidDigestFormat = errors.New("invalid digest format")
+	ErrInvalidContinuation = errors.New("invalid continuation token")
 )
 
 func ParseModelPath(name string) ModelPath {
@@ -123,6 +124,60 @@ func GetManifestPath() (string, error) {
 	return path, nil
 }
 
+// GetChecksumsPath returns the path of the tensor checksums recorded for the
+// blob with the given digest.
+func GetChecksumsPath(digest string) (string, error) {
+	if !regexp.MustCompile("^sha256[:-][0-9a-fA-F]{64}$").MatchString(digest) {
+		return "", ErrInvalidDigestFormat
+	}
+
+	return scratchPath("checksums", strings.ReplaceAll(digest, ":", "-")+".json")
+}
+
+// GetCheckpointPath returns where the checkpoint of the generation with the
+// continuation token is kept.
+func GetCheckpointPath(token string) (string, error) {
+	if !regexp.MustCompile("^[0-9a-f]{32}$").MatchString(token) {
+		return "", ErrInvalidContinuation
+	}
+
+	path := filepath.Join(envconfig.Scratch(), "checkpoints")
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return "", err
+	}
+
+	return filepath.Join(path, token+".json"), nil
+}
+
+// GetModelCachePath returns where the single file, native byte order copy of
+// the model whose first blob is digest is kept.
+func GetModelCachePath(digest string) (string, error) {
+	if !regexp.MustCompile("^sha256[:-][0-9a-fA-F]{64}$").MatchString(digest) {
+		return "", ErrInvalidDigestFormat
+	}
+
+	return scratchPath("cache", strings.ReplaceAll(digest, ":", "-")+".gguf")
+}
+
+// scratchPath returns the path of the file name, which is derived from the
+// models, in dir of the scratch directory. A file that was already derived in
+// a read-only models directory is used from there instead.
+func scratchPath(dir, name string) (string, error) {
+	if envconfig.ModelsReadOnly() {
+		p := filepath.Join(envconfig.Models(), dir, name)
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+
+	path := filepath.Join(envconfig.Scratch(), dir)
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return "", err
+	}
+
+	return filepath.Join(path, name), nil
+}
+
 func GetBlobsPath(digest string) (string, error) {
 	// only accept actual sha256 digests
 	pattern := "^sha256[:-][0-9a-fA-F]{64}$"
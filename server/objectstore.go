@@ -0,0 +1,423 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+// objectPartSize is the size of the parts of multipart uploads. Objects that
+// are larger are uploaded in parts since single uploads are limited to 5GB.
+var objectPartSize int64 = 64 << 20
+
+// objectStore is a bucket of an object storage service with an S3 compatible
+// API, such as Amazon S3, Google Cloud Storage or MinIO. Requests are signed
+// with AWS Signature Version 4 if there are credentials.
+type objectStore struct {
+	endpoint *url.URL
+	bucket   string
+	prefix   string
+	region   string
+
+	accessKey, secretKey, sessionToken string
+}
+
+// newObjectStore returns the object store of a URL of the form
+// s3://bucket/prefix or gs://bucket/prefix. Credentials and the region are
+// read from the standard AWS environment variables, and Google Cloud Storage
+// uses its HMAC keys as credentials.
+func newObjectStore(rawURL string) (*objectStore, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("blob store %q has no bucket", rawURL)
+	}
+
+	s := objectStore{
+		bucket:       u.Host,
+		prefix:       strings.Trim(u.Path, "/"),
+		region:       cmp.Or(envconfig.Var("AWS_REGION"), envconfig.Var("AWS_DEFAULT_REGION")),
+		accessKey:    envconfig.Var("AWS_ACCESS_KEY_ID"),
+		secretKey:    envconfig.Var("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: envconfig.Var("AWS_SESSION_TOKEN"),
+	}
+
+	endpoint := envconfig.BlobStoreEndpoint()
+	switch u.Scheme {
+	case "s3":
+		if s.region == "" {
+			s.region = "us-east-1"
+		}
+
+		if endpoint == "" {
+			endpoint = "https://s3." + s.region + ".amazonaws.com"
+		}
+	case "gs":
+		if s.region == "" {
+			s.region = "auto"
+		}
+
+		if endpoint == "" {
+			endpoint = "https://storage.googleapis.com"
+		}
+	default:
+		return nil, fmt.Errorf("blob store %q must be an s3:// or gs:// URL", rawURL)
+	}
+
+	if s.endpoint, err = url.Parse(endpoint); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// key returns the key of name under the prefix of the store
+func (s *objectStore) key(name string) string {
+	if s.prefix == "" {
+		return name
+	}
+
+	return s.prefix + "/" + name
+}
+
+// do sends a request for the object key, or the bucket if key is empty, and
+// returns the response if it succeeded. A missing object is os.ErrNotExist.
+func (s *objectStore) do(ctx context.Context, method, key string, query url.Values, body io.Reader, size int64) (*http.Response, error) {
+	path := "/" + s.bucket
+	if key != "" {
+		path += "/" + key
+	}
+
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.ContentLength = size
+	}
+
+	s.sign(req, time.Now().UTC())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, os.ErrNotExist
+		}
+
+		var e struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		}
+
+		bts, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if err := xml.Unmarshal(bts, &e); err == nil && e.Code != "" {
+			return nil, fmt.Errorf("blob store: %s %s: %s: %s", method, path, e.Code, e.Message)
+		}
+
+		return nil, fmt.Errorf("blob store: %s %s: %s", method, path, resp.Status)
+	}
+
+	return resp, nil
+}
+
+// get returns the content of the object key and its size.
+func (s *objectStore) get(ctx context.Context, key string) (io.ReadCloser, int64, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.key(key), nil, nil, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return resp.Body, resp.ContentLength, nil
+}
+
+// exists reports whether the object key exists.
+func (s *objectStore) exists(ctx context.Context, key string) (bool, error) {
+	resp, err := s.do(ctx, http.MethodHead, s.key(key), nil, nil, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	return true, nil
+}
+
+// put stores the size bytes of r as the object key, in parts if it is larger
+// than objectPartSize.
+func (s *objectStore) put(ctx context.Context, key string, r io.ReaderAt, size int64) error {
+	if size <= objectPartSize {
+		resp, err := s.do(ctx, http.MethodPut, s.key(key), nil, io.NewSectionReader(r, 0, size), size)
+		if err != nil {
+			return err
+		}
+
+		return resp.Body.Close()
+	}
+
+	resp, err := s.do(ctx, http.MethodPost, s.key(key), url.Values{"uploads": {""}}, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var upload struct {
+		UploadID string `xml:"UploadId"`
+	}
+
+	if err := xml.NewDecoder(resp.Body).Decode(&upload); err != nil {
+		return err
+	}
+
+	if err := s.putParts(ctx, key, upload.UploadID, r, size); err != nil {
+		// abort the upload so that its parts are not kept
+		if resp, err := s.do(context.WithoutCancel(ctx), http.MethodDelete, s.key(key), url.Values{"uploadId": {upload.UploadID}}, nil, 0); err == nil {
+			resp.Body.Close()
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func (s *objectStore) putParts(ctx context.Context, key, uploadID string, r io.ReaderAt, size int64) error {
+	type part struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+
+	var complete struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}
+
+	for offset := int64(0); offset < size; offset += objectPartSize {
+		n := len(complete.Parts) + 1
+		length := min(objectPartSize, size-offset)
+
+		resp, err := s.do(ctx, http.MethodPut, s.key(key), url.Values{
+			"partNumber": {strconv.Itoa(n)},
+			"uploadId":   {uploadID},
+		}, io.NewSectionReader(r, offset, length), length)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		complete.Parts = append(complete.Parts, part{PartNumber: n, ETag: resp.Header.Get("ETag")})
+	}
+
+	bts, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(ctx, http.MethodPost, s.key(key), url.Values{"uploadId": {uploadID}}, bytes.NewReader(bts), int64(len(bts)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// completing an upload can fail after the response has started
+	var e struct {
+		XMLName xml.Name
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+
+	if err := xml.NewDecoder(resp.Body).Decode(&e); err == nil && e.XMLName.Local == "Error" {
+		return fmt.Errorf("blob store: complete upload of %s: %s: %s", key, e.Code, e.Message)
+	}
+
+	return nil
+}
+
+// delete removes the object key.
+func (s *objectStore) delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, s.key(key), nil, nil, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+// list returns the keys of the objects under prefix, relative to the prefix
+// of the store.
+func (s *objectStore) list(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	var token string
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.key(prefix)}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range result.Contents {
+			keys = append(keys, strings.TrimPrefix(c.Key, s.key("")))
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+
+		token = result.NextContinuationToken
+	}
+}
+
+// sign adds an AWS Signature Version 4 to req, which is sent at now. The
+// payload is not signed since it may be a large file.
+func (s *objectStore) sign(req *http.Request, now time.Time) {
+	if req.Header.Get("X-Amz-Content-Sha256") == "" {
+		req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	}
+
+	if s.accessKey == "" {
+		// anonymous access to a public bucket
+		return
+	}
+
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if strings.HasPrefix(k, "x-amz-") || k == "range" || k == "content-type" || k == "content-md5" {
+			headers[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	slices.Sort(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := []byte("AWS4" + s.secretKey)
+	for _, v := range []string{date, s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, v)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%x",
+		s.accessKey, scope, signedHeaders, hmacSHA256(key, stringToSign)))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// uriEncode encodes s as AWS signatures require, escaping every byte except
+// unreserved characters and, unless encodeSlash is set, slashes.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~',
+			c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+// canonicalQuery encodes query sorted by key as AWS signatures require.
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	var parts []string
+	for _, k := range keys {
+		values := slices.Clone(query[k])
+		slices.Sort(values)
+		for _, v := range values {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
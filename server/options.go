@@ -0,0 +1,100 @@
+package server
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+)
+
+// optionRange is the range of values of a numeric option.
+type optionRange struct {
+	min, max float64
+}
+
+func (r optionRange) String() string {
+	if math.IsInf(r.max, 1) {
+		return fmt.Sprintf("at least %v", r.min)
+	}
+
+	return fmt.Sprintf("between %v and %v", r.min, r.max)
+}
+
+// optionRanges are the values of numeric options that aren't errors or
+// ignored by the runners.
+var optionRanges = map[string]optionRange{
+	"num_keep":          {-1, math.Inf(1)},
+	"num_predict":       {-2, math.Inf(1)},
+	"top_k":             {0, math.Inf(1)},
+	"top_p":             {0, 1},
+	"min_p":             {0, 1},
+	"typical_p":         {0, 1},
+	"repeat_last_n":     {-1, math.Inf(1)},
+	"temperature":       {0, math.Inf(1)},
+	"repeat_penalty":    {0, math.Inf(1)},
+	"presence_penalty":  {-2, 2},
+	"frequency_penalty": {-2, 2},
+	"mirostat":          {0, 2},
+	"mirostat_tau":      {0, math.Inf(1)},
+	"mirostat_eta":      {0, math.Inf(1)},
+	"max_tps":           {0, math.Inf(1)},
+	"num_ctx":           {1, math.Inf(1)},
+	"num_batch":         {1, math.Inf(1)},
+	"num_ubatch":        {0, math.Inf(1)},
+	"num_gpu":           {-1, math.Inf(1)},
+	"main_gpu":          {0, math.Inf(1)},
+	"num_thread":        {0, math.Inf(1)},
+	"num_parallel":      {0, math.Inf(1)},
+}
+
+// strictOptions checks the options of a request if strict, or if the server
+// is strict when it is nil. It returns the error response listing the
+// options that are unknown, of the wrong type or out of range, or nil if
+// there are none.
+func strictOptions(strict *bool, requestOpts map[string]any) *api.ErrorResponse {
+	if strict == nil && !envconfig.StrictOptions() || strict != nil && !*strict {
+		return nil
+	}
+
+	var fields, problems []string
+	for _, name := range slices.Sorted(maps.Keys(requestOpts)) {
+		if problem := checkOption(name, requestOpts[name]); problem != "" {
+			fields = append(fields, name)
+			problems = append(problems, problem)
+		}
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+
+	return &api.ErrorResponse{
+		Error:  "invalid options: " + strings.Join(problems, "; "),
+		Code:   api.ErrorCodeInvalidOptions,
+		Fields: fields,
+	}
+}
+
+// checkOption describes what is wrong with the option name set to v, or
+// returns "" if it is valid.
+func checkOption(name string, v any) string {
+	if !knownOptions()[name] {
+		return fmt.Sprintf("unknown option %q", name)
+	}
+
+	var opts api.Options
+	if err := opts.FromMap(map[string]any{name: v}); err != nil {
+		return err.Error()
+	}
+
+	r, ok := optionRanges[name]
+	if f, isNumber := v.(float64); ok && isNumber && (f < r.min || f > r.max) {
+		return fmt.Sprintf("option %q must be %s, got %v", name, r, f)
+	}
+
+	return ""
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestStrictOptions(t *testing.T) {
+	strict, lax := true, false
+
+	cases := []struct {
+		name   string
+		env    string
+		strict *bool
+		opts   map[string]any
+		fields []string
+	}{
+		{"lax", "", nil, map[string]any{"temprature": 0.5}, nil},
+		{"server strict", "1", nil, map[string]any{"temprature": 0.5}, []string{"temprature"}},
+		{"request lax", "1", &lax, map[string]any{"temprature": 0.5}, nil},
+		{"request strict", "", &strict, map[string]any{"temprature": 0.5}, []string{"temprature"}},
+		{"valid", "", &strict, map[string]any{"temperature": 0.5, "top_p": 1.0, "num_predict": -1.0, "stop": []any{"\n"}, "use_mmap": false}, nil},
+		{"out of range", "", &strict, map[string]any{"temperature": -1.0, "top_p": 1.5, "top_k": 40.0}, []string{"temperature", "top_p"}},
+		{"wrong type", "", &strict, map[string]any{"num_ctx": "4096"}, []string{"num_ctx"}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OLLAMA_STRICT_OPTIONS", tt.env)
+
+			resp := strictOptions(tt.strict, tt.opts)
+			if tt.fields == nil {
+				if resp != nil {
+					t.Fatalf("expected no error, got %+v", resp)
+				}
+				return
+			}
+
+			if resp == nil {
+				t.Fatal("expected an error")
+			}
+
+			if resp.Code != api.ErrorCodeInvalidOptions {
+				t.Errorf("expected code %s, got %s", api.ErrorCodeInvalidOptions, resp.Code)
+			}
+
+			if diff := cmp.Diff(resp.Fields, tt.fields); diff != "" {
+				t.Errorf("mismatch (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
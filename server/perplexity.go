@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/types/errtypes"
+	"github.com/ollama/ollama/types/model"
+)
+
+func (s *Server) PerplexityHandler(c *gin.Context) {
+	checkpointStart := time.Now()
+	var req api.PerplexityRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if strings.TrimSpace(req.Text) == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "text is required"})
+		return
+	}
+
+	name := model.ParseName(req.Model)
+	if !name.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": errtypes.InvalidModelNameErrMsg})
+		return
+	}
+
+	name, err = getExistingName(name)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	r, m, opts, _, err := s.scheduleRunner(c.Request.Context(), name.String(), []Capability{CapabilityCompletion}, req.Options, req.KeepAlive)
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	checkpointLoaded := time.Now()
+
+	tokens, err := scoreText(c.Request.Context(), r, opts, req.Text, func(int, int) {})
+	if errors.Is(err, errTextTooShort) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": strings.TrimSpace(err.Error())})
+		return
+	}
+
+	nll := meanNLL(tokens)
+	c.JSON(http.StatusOK, api.PerplexityResponse{
+		Model:         req.Model,
+		Tokens:        tokens,
+		NLL:           nll,
+		Perplexity:    math.Exp(nll),
+		TotalDuration: time.Since(checkpointStart),
+		LoadDuration:  checkpointLoaded.Sub(checkpointStart),
+		Fingerprint:   fingerprint(m, "", r),
+	})
+}
+
+var errTextTooShort = errors.New("text is too short to measure perplexity")
+
+// scoreText scores text in windows of about half the context length and
+// returns the negative log likelihood of each scored token. The first token of
+// each window is only used as context.
+func scoreText(ctx context.Context, r llm.LlamaServer, opts *api.Options, text string, fn func(completed, total int)) ([]api.TokenNLL, error) {
+	tokens, err := r.Tokenize(ctx, text)
+	if err != nil {
+		return nil, err
+	}
+
+	windows := splitText(text, (len(tokens)+opts.NumCtx/2-1)/max(opts.NumCtx/2, 1))
+
+	var scored []api.TokenNLL
+	for i, window := range windows {
+		fn(i, len(windows))
+
+		score, err := r.Score(ctx, llm.ScoreRequest{Continuation: window})
+		if err != nil {
+			return nil, err
+		}
+
+		for j, logprob := range score.LogProbs {
+			var token string
+			if j < len(score.Tokens) {
+				token = score.Tokens[j]
+			}
+
+			scored = append(scored, api.TokenNLL{Token: token, NLL: -logprob})
+		}
+	}
+
+	fn(len(windows), len(windows))
+
+	if len(scored) == 0 {
+		return nil, errTextTooShort
+	}
+
+	return scored, nil
+}
+
+// meanNLL returns the mean negative log likelihood of tokens, the log of the
+// perplexity on them.
+func meanNLL(tokens []api.TokenNLL) float64 {
+	var sum float64
+	for _, t := range tokens {
+		sum += t.NLL
+	}
+
+	return sum / float64(len(tokens))
+}
+
+// splitText splits s into about n parts of similar length, breaking at
+// whitespace where possible.
+func splitText(s string, n int) []string {
+	if n <= 1 {
+		return []string{s}
+	}
+
+	var parts []string
+	size := len(s) / n
+	for len(s) > 0 && len(parts) < n-1 {
+		end := min(size, len(s))
+		for end < len(s) && !utf8.RuneStart(s[end]) {
+			end--
+		}
+
+		if i := strings.LastIndexFunc(s[:end], unicode.IsSpace); i > 0 {
+			end = i
+		}
+
+		parts = append(parts, s[:end])
+		s = s[end:]
+	}
+
+	if len(s) > 0 {
+		parts = append(parts, s)
+	}
+
+	return parts
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestPerplexityHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mock := mockRunner{
+		ScoreFn: func(_ context.Context, r llm.ScoreRequest) (*llm.ScoreResponse, error) {
+			words := strings.Fields(r.Continuation)
+			resp := llm.ScoreResponse{Tokens: words[1:]}
+			for _, word := range words[1:] {
+				if word == "fox" {
+					resp.LogProbs = append(resp.LogProbs, -math.Ln2*3)
+				} else {
+					resp.LogProbs = append(resp.LogProbs, -math.Ln2)
+				}
+			}
+			return &resp, nil
+		},
+	}
+
+	s := Server{
+		sched: &Scheduler{
+			pendingReqCh:  make(chan *LlmRequest, 1),
+			finishedReqCh: make(chan *LlmRequest, 1),
+			expiredCh:     make(chan *runnerRef, 1),
+			unloadedCh:    make(chan any, 1),
+			loaded:        make(map[string]*runnerRef),
+			newServerFn:   newMockServer(&mock),
+			getGpuFn:      discover.GetGPUInfo,
+			getCpuFn:      discover.GetCPUInfo,
+			reschedDelay:  250 * time.Millisecond,
+			loadFn: func(req *LlmRequest, _ *ggml.GGML, _ discover.GpuInfoList, _ int) {
+				req.successCh <- &runnerRef{
+					llama: &mock,
+				}
+			},
+		},
+	}
+
+	go s.sched.Run(t.Context())
+
+	_, digest := createBinFile(t, ggml.KV{
+		"general.architecture":       "llama",
+		"llama.block_count":          uint32(1),
+		"llama.context_length":       uint32(2048),
+		"llama.embedding_length":     uint32(1024),
+		"llama.attention.head_count": uint32(8),
+		"tokenizer.ggml.tokens":      []string{""},
+		"tokenizer.ggml.scores":      []float32{0},
+		"tokenizer.ggml.token_type":  []int32{0},
+	}, []ggml.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+	})
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  "test",
+		Files:  map[string]string{"file.gguf": digest},
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	t.Run("missing body", func(t *testing.T) {
+		w := createRequest(t, s.PerplexityHandler, nil)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("missing text", func(t *testing.T) {
+		w := createRequest(t, s.PerplexityHandler, api.PerplexityRequest{Model: "test"})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("missing model", func(t *testing.T) {
+		w := createRequest(t, s.PerplexityHandler, api.PerplexityRequest{Model: "missing", Text: "hello world"})
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("too short", func(t *testing.T) {
+		w := createRequest(t, s.PerplexityHandler, api.PerplexityRequest{Model: "test", Text: "hello"})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("perplexity", func(t *testing.T) {
+		w := createRequest(t, s.PerplexityHandler, api.PerplexityRequest{Model: "test", Text: "the quick brown fox"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.PerplexityResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		want := []api.TokenNLL{{Token: "quick", NLL: math.Ln2}, {Token: "brown", NLL: math.Ln2}, {Token: "fox", NLL: 3 * math.Ln2}}
+		if diff := cmp.Diff(want, resp.Tokens); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+
+		if math.Abs(resp.NLL-5*math.Ln2/3) > 1e-9 || math.Abs(resp.Perplexity-math.Pow(2, 5.0/3)) > 1e-9 {
+			t.Errorf("unexpected nll %v and perplexity %v", resp.NLL, resp.Perplexity)
+		}
+
+		if resp.Fingerprint == nil || resp.Fingerprint.TemplateHash != "" {
+			t.Errorf("expected a fingerprint without a template, got %+v", resp.Fingerprint)
+		}
+	})
+
+	t.Run("windows", func(t *testing.T) {
+		text := strings.TrimSpace(strings.Repeat("lorem ipsum ", 8))
+		w := createRequest(t, s.PerplexityHandler, api.PerplexityRequest{Model: "test", Text: text, Options: map[string]any{"num_ctx": 8}})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.PerplexityResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		// 4 windows of 4 tokens, the first of each only used as context
+		if len(resp.Tokens) != 12 || math.Abs(resp.Perplexity-2) > 1e-9 {
+			t.Errorf("expected perplexity 2 over 12 tokens, got %v over %d", resp.Perplexity, len(resp.Tokens))
+		}
+	})
+}
+
+func TestSplitText(t *testing.T) {
+	s := "the quick brown fox jumps over the lazy dog"
+	if parts := splitText(s, 1); !cmp.Equal(parts, []string{s}) {
+		t.Errorf("expected the whole text, got %q", parts)
+	}
+
+	parts := splitText(s, 3)
+	if len(parts) != 3 {
+		t.Fatalf("expected 3 parts, got %q", parts)
+	}
+
+	if strings.Join(parts, "") != s {
+		t.Errorf("expected the parts to make up the text, got %q", parts)
+	}
+
+	for _, part := range parts[1:] {
+		if !strings.HasPrefix(part, " ") {
+			t.Errorf("expected parts to break at whitespace, got %q", parts)
+		}
+	}
+
+	// never split runes
+	s = strings.Repeat("é", 10)
+	for _, part := range splitText(s, 4) {
+		if !strings.HasPrefix(part, "é") {
+			t.Errorf("expected whole runes, got %q", part)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+//go:build !windows
+
+package server
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// pinnedFile is a file mapped and locked into memory.
+type pinnedFile struct {
+	data []byte
+}
+
+func pinFile(path string) (*pinnedFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if fi.Size() == 0 {
+		return &pinnedFile{}, nil
+	}
+
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(fi.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("map %s: %w", path, err)
+	}
+
+	if err := syscall.Mlock(data); err != nil {
+		syscall.Munmap(data) //nolint:errcheck
+		return nil, fmt.Errorf("lock %s in memory: %w, check the locked memory limit (ulimit -l)", path, err)
+	}
+
+	return &pinnedFile{data: data}, nil
+}
+
+func (p *pinnedFile) unpin() error {
+	if p.data == nil {
+		return nil
+	}
+
+	data := p.data
+	p.data = nil
+	return syscall.Munmap(data)
+}
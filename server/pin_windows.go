@@ -0,0 +1,13 @@
+package server
+
+import "errors"
+
+type pinnedFile struct{}
+
+func pinFile(string) (*pinnedFile, error) {
+	return nil, errors.New("locking model files in memory is not supported on windows")
+}
+
+func (p *pinnedFile) unpin() error {
+	return nil
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+	"sync"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+const (
+	// outputLengthHistory is the number of response lengths kept for each
+	// model and template.
+	outputLengthHistory = 32
+
+	// outputLengthKeys is the number of models and templates response
+	// lengths are kept for.
+	outputLengthKeys = 1024
+
+	// eosSmoothing is the weight of the latest end of sequence probability
+	// in its moving average.
+	eosSmoothing = 0.3
+)
+
+// outputLengths keeps the lengths of recent responses that ended naturally,
+// by model and template, to predict the length of new responses.
+type outputLengths struct {
+	mu      sync.Mutex
+	lengths map[string][]int
+}
+
+func newOutputLengths() *outputLengths {
+	return &outputLengths{lengths: make(map[string][]int)}
+}
+
+func outputLengthsKey(digest, template string) string {
+	h := sha256.New()
+	h.Write([]byte(digest))
+	h.Write([]byte{0})
+	h.Write([]byte(template))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// record adds the length of a response. It does nothing if o is nil.
+func (o *outputLengths) record(key string, n int) {
+	if o == nil || n <= 0 {
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	lengths, ok := o.lengths[key]
+	if !ok && len(o.lengths) >= outputLengthKeys {
+		for k := range o.lengths {
+			delete(o.lengths, k)
+			break
+		}
+	}
+
+	if len(lengths) >= outputLengthHistory {
+		lengths = lengths[1:]
+	}
+
+	o.lengths[key] = append(lengths, n)
+}
+
+// typical returns the median length of recent responses, or 0 if there are
+// none.
+func (o *outputLengths) typical(key string) int {
+	if o == nil {
+		return 0
+	}
+
+	o.mu.Lock()
+	lengths := slices.Clone(o.lengths[key])
+	o.mu.Unlock()
+
+	if len(lengths) == 0 {
+		return 0
+	}
+
+	slices.Sort(lengths)
+	return lengths[len(lengths)/2]
+}
+
+// progressEstimator estimates how much of a response has been generated. The
+// remaining length is the least of what is left before the length limit, what
+// is left of the typical length of earlier responses and the expected wait for
+// the end of sequence given the model's recent probability of ending it.
+type progressEstimator struct {
+	lengths *outputLengths
+	key     string
+
+	typical int
+	limit   int
+
+	start    int
+	n        int
+	eos      float64
+	progress float64
+}
+
+// newProgressEstimator starts estimating the progress of a response by the
+// model with digest using template. generated is the number of tokens of the
+// response that were generated by an earlier request.
+func (s *Server) newProgressEstimator(digest, template string, opts *api.Options, generated int) *progressEstimator {
+	key := outputLengthsKey(digest, template)
+
+	limit := opts.NumCtx
+	if opts.NumPredict > 0 {
+		limit = opts.NumPredict
+	}
+
+	return &progressEstimator{
+		lengths: s.lengths,
+		key:     key,
+		typical: s.lengths.typical(key),
+		limit:   generated + limit,
+		start:   generated,
+		n:       generated,
+	}
+}
+
+// update counts a response chunk and returns the estimated progress, which
+// never decreases. The length of responses that end naturally is recorded for
+// later estimates.
+func (p *progressEstimator) update(cr llm.CompletionResponse) float64 {
+	if cr.Done {
+		if cr.DoneReason == api.DoneReasonStop {
+			p.lengths.record(p.key, p.start+cr.EvalCount)
+		}
+
+		p.progress = 1
+		return p.progress
+	}
+
+	if cr.Content == "" {
+		return p.progress
+	}
+
+	p.n++
+	if cr.EOSProbability > 0 {
+		if p.eos == 0 {
+			p.eos = float64(cr.EOSProbability)
+		} else {
+			p.eos = eosSmoothing*float64(cr.EOSProbability) + (1-eosSmoothing)*p.eos
+		}
+	}
+
+	remaining := -1.0
+	if p.limit > 0 {
+		remaining = float64(max(p.limit-p.n, 0))
+	}
+
+	if p.typical > p.n {
+		remaining = minRemaining(remaining, float64(p.typical-p.n))
+	}
+
+	if p.eos > 0 {
+		remaining = minRemaining(remaining, 1/p.eos)
+	}
+
+	if remaining < 0 {
+		return p.progress
+	}
+
+	total := float64(p.n) + max(remaining, 1)
+	p.progress = max(p.progress, min(float64(p.n)/total, 0.99))
+	return p.progress
+}
+
+// minRemaining returns the lesser of a and b, where a negative a is unknown.
+func minRemaining(a, b float64) float64 {
+	if a < 0 {
+		return b
+	}
+
+	return min(a, b)
+}
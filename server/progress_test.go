@@ -0,0 +1,123 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestOutputLengths(t *testing.T) {
+	var nilLengths *outputLengths
+	nilLengths.record("a", 10)
+	if n := nilLengths.typical("a"); n != 0 {
+		t.Errorf("expected 0, got %d", n)
+	}
+
+	o := newOutputLengths()
+	if n := o.typical("a"); n != 0 {
+		t.Errorf("expected 0, got %d", n)
+	}
+
+	for _, n := range []int{10, 1000, 30, 20, 0} {
+		o.record("a", n)
+	}
+
+	if n := o.typical("a"); n != 30 {
+		t.Errorf("expected median 30, got %d", n)
+	}
+
+	// only recent lengths are kept
+	for range outputLengthHistory {
+		o.record("a", 5)
+	}
+
+	if n := o.typical("a"); n != 5 {
+		t.Errorf("expected 5, got %d", n)
+	}
+
+	if n := o.typical("b"); n != 0 {
+		t.Errorf("expected 0, got %d", n)
+	}
+}
+
+func TestProgressEstimator(t *testing.T) {
+	token := llm.CompletionResponse{Content: "a"}
+	done := llm.CompletionResponse{Done: true, DoneReason: api.DoneReasonStop, EvalCount: 100}
+
+	generate := func(p *progressEstimator, n int) float64 {
+		var progress float64
+		for range n {
+			progress = p.update(token)
+		}
+		return progress
+	}
+
+	t.Run("limit", func(t *testing.T) {
+		s := Server{lengths: newOutputLengths()}
+		p := s.newProgressEstimator("sha256:abc", "{{ .Prompt }}", &api.Options{Runner: api.Runner{NumCtx: 2048}, NumPredict: 200}, 0)
+		if progress := generate(p, 50); progress != 0.25 {
+			t.Errorf("expected 0.25, got %v", progress)
+		}
+
+		if progress := p.update(done); progress != 1 {
+			t.Errorf("expected 1, got %v", progress)
+		}
+	})
+
+	t.Run("history", func(t *testing.T) {
+		s := Server{lengths: newOutputLengths()}
+		opts := &api.Options{Runner: api.Runner{NumCtx: 2048}, NumPredict: -1}
+
+		p := s.newProgressEstimator("sha256:abc", "{{ .Prompt }}", opts, 0)
+		generate(p, 100)
+		p.update(done)
+
+		p = s.newProgressEstimator("sha256:abc", "{{ .Prompt }}", opts, 0)
+		if progress := generate(p, 50); progress != 0.5 {
+			t.Errorf("expected 0.5, got %v", progress)
+		}
+
+		// longer than usual
+		if progress := generate(p, 100); progress >= 1 || progress < 0.5 {
+			t.Errorf("expected progress below 1 that doesn't decrease, got %v", progress)
+		}
+
+		// a different template has no history
+		p = s.newProgressEstimator("sha256:abc", "{{ .System }} {{ .Prompt }}", opts, 0)
+		if progress := generate(p, 50); progress > 0.05 {
+			t.Errorf("expected progress against the context length, got %v", progress)
+		}
+	})
+
+	t.Run("eos", func(t *testing.T) {
+		s := Server{}
+		p := s.newProgressEstimator("sha256:abc", "", &api.Options{Runner: api.Runner{NumCtx: 2048}, NumPredict: -1}, 0)
+		generate(p, 7)
+
+		progress := p.update(llm.CompletionResponse{Content: "a", EOSProbability: 0.125})
+		if progress != 0.5 {
+			t.Errorf("expected 0.5, got %v", progress)
+		}
+
+		// the model is unlikely to end after all
+		for range 10 {
+			if next := p.update(llm.CompletionResponse{Content: "a", EOSProbability: 1e-6}); next < progress {
+				t.Errorf("expected progress not to decrease, got %v after %v", next, progress)
+			}
+		}
+	})
+
+	t.Run("resume", func(t *testing.T) {
+		s := Server{lengths: newOutputLengths()}
+		p := s.newProgressEstimator("sha256:abc", "", &api.Options{Runner: api.Runner{NumCtx: 2048}, NumPredict: 100}, 100)
+		if progress := generate(p, 50); progress != 0.75 {
+			t.Errorf("expected 0.75, got %v", progress)
+		}
+
+		p.update(done)
+		if n := s.lengths.typical(outputLengthsKey("sha256:abc", "")); n != 200 {
+			t.Errorf("expected the whole response to be recorded, got %d", n)
+		}
+	})
+}
@@ -23,6 +23,13 @@ var errTooManyImages = errors.New("vision model only supports a single image per
 // chatPrompt truncates any messages that exceed the context window of the model, making sure to always include 1) the
 // latest message and 2) system messages
 func chatPrompt(ctx context.Context, m *Model, tokenize tokenizeFunc, opts *api.Options, msgs []api.Message, tools []api.Tool) (prompt string, images []llm.ImageData, _ error) {
+	prompt, images, _, err := truncatedChatPrompt(ctx, m, tokenize, opts, msgs, tools)
+	return prompt, images, err
+}
+
+// truncatedChatPrompt is [chatPrompt] that also returns the number of messages
+// that were left out of the prompt.
+func truncatedChatPrompt(ctx context.Context, m *Model, tokenize tokenizeFunc, opts *api.Options, msgs []api.Message, tools []api.Tool) (prompt string, images []llm.ImageData, truncated int, _ error) {
 	var system []api.Message
 
 	isMllama := checkMllamaModelFamily(m)
@@ -41,7 +48,7 @@ func chatPrompt(ctx context.Context, m *Model, tokenize tokenizeFunc, opts *api.
 	// in reverse, find all messages that fit into context window
 	for i := n; i >= 0; i-- {
 		if isMllama && len(msgs[i].Images) > 1 {
-			return "", nil, errTooManyImages
+			return "", nil, 0, errTooManyImages
 		}
 
 		// always include the last message
@@ -58,12 +65,12 @@ func chatPrompt(ctx context.Context, m *Model, tokenize tokenizeFunc, opts *api.
 
 		var b bytes.Buffer
 		if err := m.Template.Execute(&b, template.Values{Messages: append(system, msgs[i:]...), Tools: tools}); err != nil {
-			return "", nil, err
+			return "", nil, 0, err
 		}
 
 		s, err := tokenize(ctx, b.String())
 		if err != nil {
-			return "", nil, err
+			return "", nil, 0, err
 		}
 
 		ctxLen := len(s)
@@ -100,18 +107,18 @@ func chatPrompt(ctx context.Context, m *Model, tokenize tokenizeFunc, opts *api.
 				} else {
 					data, opts, err := mllama.Preprocess(bytes.NewReader(i))
 					if err != nil {
-						return "", nil, err
+						return "", nil, 0, err
 					}
 
 					buf := new(bytes.Buffer)
 					err = binary.Write(buf, binary.LittleEndian, data)
 					if err != nil {
-						return "", nil, err
+						return "", nil, 0, err
 					}
 
 					ar, ok := opts["aspectRatioIndex"].(int)
 					if !ok {
-						return "", nil, fmt.Errorf("missing aspect ratio for image")
+						return "", nil, 0, fmt.Errorf("missing aspect ratio for image")
 					}
 
 					imgData = llm.ImageData{
@@ -143,10 +150,10 @@ func chatPrompt(ctx context.Context, m *Model, tokenize tokenizeFunc, opts *api.
 	// truncate any messages that do not fit into the context window
 	var b bytes.Buffer
 	if err := m.Template.Execute(&b, template.Values{Messages: append(system, msgs[currMsgIdx:]...), Tools: tools}); err != nil {
-		return "", nil, err
+		return "", nil, 0, err
 	}
 
-	return b.String(), images, nil
+	return b.String(), images, currMsgIdx - len(system), nil
 }
 
 func checkMllamaModelFamily(m *Model) bool {
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/errtypes"
+	"github.com/ollama/ollama/types/model"
+)
+
+// PullPreviewHandler describes what pulling a model would download, so that
+// clients can ask users to confirm large downloads before starting them.
+func (s *Server) PullPreviewHandler(c *gin.Context) {
+	var req api.PullRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := model.ParseName(cmp.Or(req.Model, req.Name))
+	if !name.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": errtypes.InvalidModelNameErrMsg})
+		return
+	}
+
+	name, err = getExistingName(name)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp, err := previewPull(c.Request.Context(), name.DisplayShortest(), &registryOptions{Insecure: req.Insecure})
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		c.AbortWithStatusJSON(http.StatusNotFound, api.ErrorResponse{Error: fmt.Sprintf("model %q not found in the registry", name.DisplayShortest()), Code: api.ErrorCodeModelNotFound})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// previewPull fetches the manifest of the model name and compares its layers
+// with the blobs stored locally.
+func previewPull(ctx context.Context, name string, regOpts *registryOptions) (*api.PullPreviewResponse, error) {
+	mp := ParseModelPath(name)
+	if mp.ProtocolScheme == "http" && !regOpts.Insecure {
+		return nil, errors.New("insecure protocol http")
+	}
+
+	manifest, err := pullModelManifest(ctx, mp, regOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	layers := manifest.Layers
+	if manifest.Config.Digest != "" {
+		layers = append(layers, manifest.Config)
+	}
+
+	resp := api.PullPreviewResponse{Model: name, Layers: []api.PullPreviewLayer{}}
+	seen := make(map[string]bool)
+	for _, layer := range layers {
+		// layers used more than once are downloaded and stored once
+		if seen[layer.Digest] {
+			continue
+		}
+		seen[layer.Digest] = true
+
+		completed, err := layerCompleted(layer)
+		if err != nil {
+			return nil, err
+		}
+
+		resp.Layers = append(resp.Layers, api.PullPreviewLayer{
+			Digest:    layer.Digest,
+			MediaType: layer.MediaType,
+			Size:      layer.Size,
+			Completed: completed,
+		})
+
+		resp.Total += layer.Size
+		if completed < layer.Size {
+			resp.Download += layer.Size - completed
+			resp.Disk += layer.Size
+		}
+	}
+
+	return &resp, nil
+}
+
+// layerCompleted returns how much of layer is stored locally: its size if
+// the blob exists, or what interrupted downloads of it completed.
+func layerCompleted(layer Layer) (int64, error) {
+	fp, err := GetBlobsPath(layer.Digest)
+	if err != nil {
+		return 0, err
+	}
+
+	if fi, err := os.Stat(fp); err == nil && fi.Size() == layer.Size {
+		return layer.Size, nil
+	}
+
+	partFilePaths, err := filepath.Glob(fp + "-partial-*")
+	if err != nil {
+		return 0, err
+	}
+
+	var completed int64
+	for _, partFilePath := range partFilePaths {
+		b, err := os.ReadFile(partFilePath)
+		if err != nil {
+			return 0, err
+		}
+
+		var part jsonBlobDownloadPart
+		if err := json.Unmarshal(b, &part); err != nil {
+			// a corrupt part is downloaded again
+			continue
+		}
+
+		completed += part.Completed
+	}
+
+	return min(completed, layer.Size), nil
+}
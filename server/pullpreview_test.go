@@ -0,0 +1,109 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestPullPreviewHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	digest := func(c string) string { return "sha256:" + strings.Repeat(c, 64) }
+	stored := Layer{MediaType: "application/vnd.ollama.image.model", Digest: digest("a"), Size: 10}
+	partial := Layer{MediaType: "application/vnd.ollama.image.projector", Digest: digest("b"), Size: 100}
+	missing := Layer{MediaType: "application/vnd.ollama.image.template", Digest: digest("c"), Size: 1000}
+	config := Layer{MediaType: "application/vnd.docker.container.image.v1+json", Digest: digest("d"), Size: 5}
+
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/library/test/manifests/latest" {
+			http.NotFound(w, r)
+			return
+		}
+
+		json.NewEncoder(w).Encode(Manifest{ //nolint:errcheck
+			SchemaVersion: 2,
+			Config:        config,
+			Layers:        []Layer{stored, partial, missing, stored},
+		})
+	}))
+	defer registry.Close()
+
+	fp, err := GetBlobsPath(stored.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(fp, make([]byte, stored.Size), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	fp, err = GetBlobsPath(partial.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(fp+"-partial-0", []byte(`{"N":0,"Offset":0,"Size":50,"Completed":50}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(fp+"-partial-1", []byte(`{"N":1,"Offset":50,"Size":50,"Completed":20}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var s Server
+	name := registry.Listener.Addr().String() + "/library/test"
+
+	t.Run("preview", func(t *testing.T) {
+		w := createRequest(t, s.PullPreviewHandler, api.PullRequest{Model: name, Insecure: true})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.PullPreviewResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		want := api.PullPreviewResponse{
+			Model: name + ":latest",
+			Layers: []api.PullPreviewLayer{
+				{Digest: stored.Digest, MediaType: stored.MediaType, Size: 10, Completed: 10},
+				{Digest: partial.Digest, MediaType: partial.MediaType, Size: 100, Completed: 70},
+				{Digest: missing.Digest, MediaType: missing.MediaType, Size: 1000},
+				{Digest: config.Digest, MediaType: config.MediaType, Size: 5},
+			},
+			Total:    1115,
+			Download: 30 + 1000 + 5,
+			Disk:     100 + 1000 + 5,
+		}
+
+		if diff := cmp.Diff(want, resp); diff != "" {
+			t.Errorf("preview mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		w := createRequest(t, s.PullPreviewHandler, api.PullRequest{Model: registry.Listener.Addr().String() + "/library/missing", Insecure: true})
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("invalid name", func(t *testing.T) {
+		w := createRequest(t, s.PullPreviewHandler, api.PullRequest{Model: "://invalid"})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"cmp"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/types/model"
+)
+
+// quantizations caches the smaller quantizations of models and the decoded
+// files of the models it considers so that requests don't list every
+// manifest or decode files again
+var quantizations quantCache
+
+type quantCache struct {
+	mu sync.Mutex
+
+	// generation counts changes to the manifests, which make the
+	// smaller quantizations of models out of date
+	generation uint64
+
+	// models holds the smaller quantizations of models by their name and
+	// manifest digest
+	models map[string][]*Model
+
+	// files holds decoded model files by path. Paths are named by the
+	// digest of the files, so their contents never change.
+	files map[string]*ggml.GGML
+}
+
+// reset drops the smaller quantizations, such as after a model is created,
+// pulled or removed
+func (c *quantCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.models = nil
+}
+
+// smallerQuantizations returns the cached smaller quantizations of m,
+// listing them if they aren't cached.
+func (c *quantCache) smallerQuantizations(m *Model) ([]*Model, error) {
+	key := m.Name + "@" + m.Digest
+
+	c.mu.Lock()
+	models, ok := c.models[key]
+	generation := c.generation
+	c.mu.Unlock()
+	if ok {
+		return models, nil
+	}
+
+	models, err := smallerQuantizations(m)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// manifests that changed while listing may have been missed
+	if c.generation == generation {
+		if c.models == nil {
+			c.models = make(map[string][]*Model)
+		}
+		c.models[key] = models
+	}
+
+	return models, nil
+}
+
+// file returns the decoded model file at path.
+func (c *quantCache) file(path string) (*ggml.GGML, error) {
+	c.mu.Lock()
+	f, ok := c.files[path]
+	c.mu.Unlock()
+	if ok {
+		return f, nil
+	}
+
+	f, err := llm.LoadModel(path, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.files == nil {
+		c.files = make(map[string]*ggml.GGML)
+	}
+	c.files[path] = f
+	return f, nil
+}
+
+// smallerQuantizations returns the local models with the same name, family and
+// parameter size as m but a different file type that take less space on disk,
+// largest first.
+func smallerQuantizations(m *Model) ([]*Model, error) {
+	n := model.ParseName(m.Name)
+
+	manifests, err := Manifests(true)
+	if err != nil {
+		return nil, err
+	}
+
+	var size int64
+	for mn, mf := range manifests {
+		if mn.EqualFold(n) {
+			size = mf.Size()
+		}
+	}
+
+	type candidate struct {
+		name model.Name
+		size int64
+	}
+
+	var candidates []candidate
+	for mn, mf := range manifests {
+		if mn.EqualFold(n) ||
+			!strings.EqualFold(mn.Host, n.Host) ||
+			!strings.EqualFold(mn.Namespace, n.Namespace) ||
+			!strings.EqualFold(mn.Model, n.Model) ||
+			mf.Size() >= size {
+			continue
+		}
+
+		candidates = append(candidates, candidate{mn, mf.Size()})
+	}
+
+	slices.SortFunc(candidates, func(a, b candidate) int {
+		return cmp.Compare(b.size, a.size)
+	})
+
+	var models []*Model
+	for _, c := range candidates {
+		cm, err := GetModel(c.name.String())
+		if err != nil {
+			slog.Warn("skipping model", "model", c.name, "error", err)
+			continue
+		}
+
+		if cm.Config.ModelFamily == m.Config.ModelFamily &&
+			cm.Config.ModelType == m.Config.ModelType &&
+			cm.Config.FileType != m.Config.FileType {
+			models = append(models, cm)
+		}
+	}
+
+	return models, nil
+}
+
+// quantFallback returns the largest smaller quantization of m available
+// locally that has caps and fits in memory, and its options, if m itself does
+// not fit. It returns nil if m fits or there is no such model.
+func (s *Server) quantFallback(m *Model, caps []Capability, requestOpts map[string]any, opts api.Options) (*Model, *api.Options, error) {
+	s.sched.loadedMu.Lock()
+	_, loaded := s.sched.loaded[m.ModelPath]
+	s.sched.loadedMu.Unlock()
+	if loaded {
+		return nil, nil, nil
+	}
+
+	f, err := quantizations.file(m.ModelPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if ok := s.sched.fits(m, f, opts); ok {
+		return nil, nil, nil
+	}
+
+	candidates, err := quantizations.smallerQuantizations(m)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, c := range candidates {
+		if err := c.CheckCapabilities(caps...); err != nil {
+			continue
+		}
+
+		copts, err := modelOptions(c, requestOpts)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		f, err := quantizations.file(c.ModelPath)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if s.sched.fits(c, f, copts) {
+			return c, &copts, nil
+		}
+	}
+
+	return nil, nil, nil
+}
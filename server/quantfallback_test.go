@@ -0,0 +1,213 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestQuantFallback(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mock := mockRunner{
+		CompletionResponse: llm.CompletionResponse{
+			Done:       true,
+			DoneReason: "stop",
+		},
+	}
+
+	var memory uint64
+	var loaded string
+	cpu := func() discover.GpuInfoList {
+		var info discover.GpuInfo
+		info.Library = "cpu"
+		info.TotalMemory = memory
+		info.FreeMemory = memory
+		return discover.GpuInfoList{info}
+	}
+
+	s := Server{
+		sched: &Scheduler{
+			pendingReqCh:  make(chan *LlmRequest, 1),
+			finishedReqCh: make(chan *LlmRequest, 1),
+			expiredCh:     make(chan *runnerRef, 1),
+			unloadedCh:    make(chan any, 1),
+			loaded:        make(map[string]*runnerRef),
+			newServerFn:   newMockServer(&mock),
+			getGpuFn:      cpu,
+			getCpuFn:      cpu,
+			reschedDelay:  250 * time.Millisecond,
+			loadFn: func(req *LlmRequest, _ *ggml.GGML, _ discover.GpuInfoList, _ int) {
+				loaded = req.model.ModelPath
+				req.successCh <- &runnerRef{
+					llama: &mock,
+				}
+			},
+		},
+	}
+
+	go s.sched.Run(t.Context())
+
+	// the same parameters in a larger and a smaller file type
+	sizes := make(map[string]uint64)
+	for _, q := range []struct {
+		name     string
+		fileType uint32
+		kind     uint32
+		size     int
+	}{
+		{"test:f16", 1, 1, 1 << 21},
+		{"test:q4_0", 2, 2, 1 << 20 / 32 * 18},
+	} {
+		_, digest := createBinFile(t, ggml.KV{
+			"general.architecture":       "llama",
+			"general.file_type":          q.fileType,
+			"llama.block_count":          uint32(1),
+			"llama.context_length":       uint32(2048),
+			"llama.embedding_length":     uint32(1024),
+			"llama.attention.head_count": uint32(8),
+			"tokenizer.ggml.tokens":      []string{""},
+			"tokenizer.ggml.scores":      []float32{0},
+			"tokenizer.ggml.token_type":  []int32{0},
+		}, []ggml.Tensor{
+			{Name: "token_embd.weight", Kind: q.kind, Shape: []uint64{1 << 20}, WriterTo: bytes.NewReader(make([]byte, q.size))},
+		})
+
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:    q.name,
+			Files:    map[string]string{"file.gguf": digest},
+			Template: "{{ .Prompt }}",
+			Stream:   &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		m, err := GetModel(q.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := llm.LoadModel(m.ModelPath, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		opts, err := modelOptions(m, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sizes[q.name] = llm.EstimateGPULayers(discover.GpuInfoList{{Library: "cpu"}}, f, nil, opts).TotalSize
+	}
+
+	// enough memory for the smaller model only
+	memory = (sizes["test:f16"] + sizes["test:q4_0"]) / 2
+
+	generate := func(t *testing.T) api.GenerateResponse {
+		t.Helper()
+
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:  "test:f16",
+			Prompt: "Hello!",
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.GenerateResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		return resp
+	}
+
+	t.Run("off", func(t *testing.T) {
+		if resp := generate(t); resp.StreamSummary == nil || len(resp.StreamSummary.Warnings) > 0 {
+			t.Errorf("expected no warnings, got %+v", resp.StreamSummary)
+		}
+	})
+
+	t.Run("suggest", func(t *testing.T) {
+		t.Setenv("OLLAMA_QUANT_FALLBACK", "suggest")
+		resp := generate(t)
+		expect := []string{"test:f16 may not fit in available memory, try test:q4_0 instead"}
+		if resp.StreamSummary == nil || !slices.Equal(resp.StreamSummary.Warnings, expect) {
+			t.Errorf("expected warnings %v, got %+v", expect, resp.StreamSummary)
+		}
+	})
+
+	t.Run("auto", func(t *testing.T) {
+		t.Setenv("OLLAMA_QUANT_FALLBACK", "auto")
+		resp := generate(t)
+		expect := []string{"test:f16 does not fit in available memory, using test:q4_0 instead"}
+		if resp.StreamSummary == nil || !slices.Equal(resp.StreamSummary.Warnings, expect) {
+			t.Errorf("expected warnings %v, got %+v", expect, resp.StreamSummary)
+		}
+
+		q4, err := GetModel("test:q4_0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if loaded != q4.ModelPath {
+			t.Errorf("expected the smaller quantization to be loaded, got %s", loaded)
+		}
+	})
+
+	t.Run("cached", func(t *testing.T) {
+		f16, err := GetModel("test:f16")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		quantizations.mu.Lock()
+		models := quantizations.models[f16.Name+"@"+f16.Digest]
+		_, decoded := quantizations.files[f16.ModelPath]
+		quantizations.mu.Unlock()
+		if len(models) != 1 || models[0].ShortName != "test:q4_0" || !decoded {
+			t.Fatalf("expected the smaller quantization and the decoded file to be cached, got %v and %v", models, decoded)
+		}
+
+		// new models may be smaller quantizations too
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:  "other",
+			From:   "test:q4_0",
+			Stream: &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		quantizations.mu.Lock()
+		_, ok := quantizations.models[f16.Name+"@"+f16.Digest]
+		quantizations.mu.Unlock()
+		if ok {
+			t.Error("expected the smaller quantizations to be listed again after a model is created")
+		}
+	})
+
+	t.Run("fits", func(t *testing.T) {
+		t.Setenv("OLLAMA_QUANT_FALLBACK", "auto")
+		memory = sizes["test:f16"] * 2
+		t.Cleanup(func() { memory = (sizes["test:f16"] + sizes["test:q4_0"]) / 2 })
+
+		if resp := generate(t); resp.StreamSummary == nil || len(resp.StreamSummary.Warnings) > 0 {
+			t.Errorf("expected no warnings, got %+v", resp.StreamSummary)
+		}
+	})
+}
@@ -0,0 +1,241 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+// transferLimits throttle all pulls and all pushes of the server, so that the
+// limits apply to the combined rate of their concurrent parts.
+var (
+	downloadLimit = &transferLimit{max: envconfig.MaxDownloadRate}
+	uploadLimit   = &transferLimit{max: envconfig.MaxUploadRate}
+)
+
+// transferLimit limits a transfer rate to a maximum, which applies only during
+// the OLLAMA_RATE_WINDOWS if any are configured.
+type transferLimit struct {
+	max func() uint64
+
+	mu      sync.Mutex
+	limiter *rate.Limiter
+	bps     uint64
+
+	// invalid is the last invalid OLLAMA_RATE_WINDOWS, which is only
+	// logged once
+	invalid string
+}
+
+// rate returns the rate enforced at now in bytes per second, or 0 if
+// transfers are not limited.
+func (l *transferLimit) rate(now time.Time) uint64 {
+	bps := l.max()
+	if bps == 0 {
+		return 0
+	}
+
+	s := envconfig.RateWindows()
+	windows, err := parseRateWindows(s)
+	if err != nil {
+		l.mu.Lock()
+		if l.invalid != s {
+			slog.Warn("invalid OLLAMA_RATE_WINDOWS, limiting transfer rates at all times", "error", err)
+			l.invalid = s
+		}
+		l.mu.Unlock()
+
+		// limiting at all times is safer than not limiting at all
+		return bps
+	}
+
+	if len(windows) == 0 || windows.contains(now) {
+		return bps
+	}
+
+	return 0
+}
+
+// wait blocks until n bytes may be transferred.
+func (l *transferLimit) wait(ctx context.Context, n int) error {
+	bps := l.rate(time.Now())
+	if bps == 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	if l.limiter == nil || l.bps != bps {
+		// allow bursts of up to a second of transfer
+		l.limiter = rate.NewLimiter(rate.Limit(bps), int(min(bps, 1<<30)))
+		l.bps = bps
+	}
+	limiter := l.limiter
+	l.mu.Unlock()
+
+	for n > 0 {
+		chunk := min(n, limiter.Burst())
+		if err := limiter.WaitN(ctx, chunk); err != nil {
+			return err
+		}
+
+		n -= chunk
+	}
+
+	return nil
+}
+
+// reader returns a reader of r throttled by l.
+func (l *transferLimit) reader(ctx context.Context, r io.Reader) io.Reader {
+	return &limitedReader{ctx: ctx, r: r, limit: l}
+}
+
+type limitedReader struct {
+	ctx   context.Context
+	r     io.Reader
+	limit *transferLimit
+}
+
+func (r *limitedReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	if n > 0 {
+		if err := r.limit.wait(r.ctx, n); err != nil {
+			return n, err
+		}
+	}
+
+	return n, err
+}
+
+// rateWindow is a time of the week, e.g. weekdays from 09:00 to 17:00. A
+// window that ends before it starts runs past midnight into the next day.
+type rateWindow struct {
+	days       [7]bool
+	start, end time.Duration
+}
+
+type rateWindows []rateWindow
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// parseRateWindows parses a comma separated list of windows of the form
+// "[days] HH:MM-HH:MM", where days is a day or a range of days such as
+// "mon-fri". Windows without days apply every day.
+func parseRateWindows(s string) (rateWindows, error) {
+	var windows rateWindows
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		var w rateWindow
+		days, times, ok := strings.Cut(field, " ")
+		if !ok {
+			days, times = "", field
+		}
+
+		if err := w.parseDays(strings.ToLower(days)); err != nil {
+			return nil, fmt.Errorf("%q: %w", field, err)
+		}
+
+		start, end, ok := strings.Cut(strings.TrimSpace(times), "-")
+		if !ok {
+			return nil, fmt.Errorf("%q: expected a time range such as 09:00-17:00", field)
+		}
+
+		var err error
+		if w.start, err = parseTimeOfDay(start); err != nil {
+			return nil, fmt.Errorf("%q: %w", field, err)
+		}
+
+		if w.end, err = parseTimeOfDay(end); err != nil {
+			return nil, fmt.Errorf("%q: %w", field, err)
+		}
+
+		windows = append(windows, w)
+	}
+
+	return windows, nil
+}
+
+func (w *rateWindow) parseDays(s string) error {
+	if s == "" {
+		w.days = [7]bool{true, true, true, true, true, true, true}
+		return nil
+	}
+
+	first, last, _ := strings.Cut(s, "-")
+	if last == "" {
+		last = first
+	}
+
+	from, ok := weekdays[first]
+	if !ok {
+		return fmt.Errorf("unknown day %q", first)
+	}
+
+	to, ok := weekdays[last]
+	if !ok {
+		return fmt.Errorf("unknown day %q", last)
+	}
+
+	// ranges may wrap around the end of the week, e.g. sat-sun
+	for d := from; ; d = (d + 1) % 7 {
+		w.days[d] = true
+		if d == to {
+			return nil
+		}
+	}
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// contains reports whether t is in any of the windows.
+func (ws rateWindows) contains(t time.Time) bool {
+	day := t.Weekday()
+	yesterday := (day + 6) % 7
+	tod := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+
+	for _, w := range ws {
+		switch {
+		case w.start == w.end:
+			// the whole day
+			if w.days[day] {
+				return true
+			}
+		case w.start < w.end:
+			if w.days[day] && tod >= w.start && tod < w.end {
+				return true
+			}
+		default:
+			if w.days[day] && tod >= w.start || w.days[yesterday] && tod < w.end {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestRateWindows(t *testing.T) {
+	// 2025-01-06 is a monday
+	at := func(day int, clock string) time.Time {
+		tod, err := time.Parse("15:04", clock)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return time.Date(2025, time.January, 6+day, tod.Hour(), tod.Minute(), 0, 0, time.Local)
+	}
+
+	cases := []struct {
+		windows string
+		t       time.Time
+		want    bool
+	}{
+		{"09:00-17:00", at(0, "09:00"), true},
+		{"09:00-17:00", at(6, "16:59"), true},
+		{"09:00-17:00", at(0, "17:00"), false},
+		{"09:00-17:00", at(0, "08:59"), false},
+		{"mon-fri 09:00-17:00", at(4, "12:00"), true},
+		{"mon-fri 09:00-17:00", at(5, "12:00"), false},
+		{"MON-FRI 09:00-17:00, sat 10:00-14:00", at(5, "12:00"), true},
+		{"sat-sun 00:00-00:00", at(6, "23:59"), true},
+		{"sat-sun 00:00-00:00", at(7, "00:00"), false},
+		{"fri 22:00-06:00", at(4, "23:00"), true},
+		{"fri 22:00-06:00", at(5, "05:59"), true},
+		{"fri 22:00-06:00", at(5, "23:00"), false},
+		{"fri 22:00-06:00", at(4, "05:00"), false},
+	}
+
+	for _, tt := range cases {
+		windows, err := parseRateWindows(tt.windows)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.windows, err)
+		}
+
+		if got := windows.contains(tt.t); got != tt.want {
+			t.Errorf("%q at %s: expected %t, got %t", tt.windows, tt.t.Format("Mon 15:04"), tt.want, got)
+		}
+	}
+
+	for _, s := range []string{"09:00", "weekdays 09:00-17:00", "mon-xyz 09:00-17:00", "9am-5pm", "09:00-25:00"} {
+		if _, err := parseRateWindows(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestTransferLimit(t *testing.T) {
+	t.Setenv("OLLAMA_RATE_WINDOWS", "")
+
+	t.Run("rate", func(t *testing.T) {
+		l := &transferLimit{max: func() uint64 { return 1000 }}
+		now := time.Now()
+		if got := l.rate(now); got != 1000 {
+			t.Errorf("expected the rate to always apply, got %d", got)
+		}
+
+		t.Setenv("OLLAMA_RATE_WINDOWS", now.Add(time.Hour).Format("15:04")+"-"+now.Add(2*time.Hour).Format("15:04"))
+		if got := l.rate(now); got != 0 {
+			t.Errorf("expected no limit outside the windows, got %d", got)
+		}
+
+		t.Setenv("OLLAMA_RATE_WINDOWS", "invalid")
+		if got := l.rate(now); got != 1000 {
+			t.Errorf("expected invalid windows to always apply, got %d", got)
+		}
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		l := &transferLimit{max: func() uint64 { return 0 }}
+		if got := l.rate(time.Now()); got != 0 {
+			t.Errorf("expected no limit, got %d", got)
+		}
+	})
+
+	t.Run("reader", func(t *testing.T) {
+		t.Setenv("OLLAMA_RATE_WINDOWS", "")
+
+		l := &transferLimit{max: func() uint64 { return 10000 }}
+		start := time.Now()
+
+		// the first second of transfer is a burst, so 15000 bytes take at
+		// least half a second
+		n, err := io.Copy(io.Discard, l.reader(context.Background(), bytes.NewReader(make([]byte, 15000))))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if n != 15000 {
+			t.Errorf("expected 15000 bytes, got %d", n)
+		}
+
+		if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+			t.Errorf("expected the reader to be throttled, took %s", elapsed)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := io.Copy(io.Discard, l.reader(ctx, bytes.NewReader(make([]byte, 15000)))); err == nil {
+			t.Error("expected an error after the context is canceled")
+		}
+	})
+}
@@ -0,0 +1,287 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/types/model"
+)
+
+// Statuses of prefetched models
+const (
+	prefetchPending = "pending"
+	prefetchPulling = "pulling"
+	prefetchReady   = "ready"
+	prefetchFailed  = "failed"
+)
+
+// prefetchAnnotation is the pod annotation that lists the models to prefetch
+// in a Kubernetes annotations file.
+const prefetchAnnotation = "ollama.com/prefetch"
+
+// prefetchRetryInterval is how long to wait before pulling models that failed
+// to prefetch again.
+var prefetchRetryInterval = 30 * time.Second
+
+// probePaths are the routes that are not counted as requests in progress,
+// since probes and scrapes continue while the server drains.
+var probePaths = []string{"/", "/api/version", "/readyz", "/drainz", "/metrics"}
+
+// readiness tracks whether the server can take traffic. The server is ready
+// once the models of OLLAMA_PREFETCH_FILE are pulled, and until it is drained
+// before shutting down.
+type readiness struct {
+	mu       sync.Mutex
+	models   []api.ModelPrefetch
+	draining bool
+
+	// active is the number of requests in progress, and idle is closed
+	// when it drops to zero while the server drains
+	active int
+	idle   chan struct{}
+}
+
+// parsePrefetchFile reads the models to prefetch from path. The file lists
+// models separated by commas or whitespace, or is a Kubernetes annotations
+// file mounted with the downward API, in which case the models are listed by
+// the ollama.com/prefetch annotation.
+func parsePrefetchFile(path string) ([]model.Name, error) {
+	bts, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []model.Name
+	for line := range strings.Lines(string(bts)) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if key, value, ok := strings.Cut(line, "="); ok {
+			if key != prefetchAnnotation {
+				continue
+			}
+
+			if line, err = strconv.Unquote(value); err != nil {
+				return nil, fmt.Errorf("%s: annotation %s: %w", path, key, err)
+			}
+		}
+
+		for _, s := range strings.FieldsFunc(line, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+			n := model.ParseName(s)
+			if !n.IsValid() {
+				return nil, fmt.Errorf("%s: invalid model name %q", path, s)
+			}
+
+			if !slices.Contains(names, n) {
+				names = append(names, n)
+			}
+		}
+	}
+
+	return names, nil
+}
+
+// prefetch pulls names in the background. The server is not ready until all
+// of them are pulled, and models that fail are retried.
+func (r *readiness) prefetch(ctx context.Context, names []model.Name) {
+	r.mu.Lock()
+	for _, n := range names {
+		r.models = append(r.models, api.ModelPrefetch{Model: n.DisplayShortest(), Status: prefetchPending})
+	}
+	r.mu.Unlock()
+
+	go r.pull(ctx, names)
+}
+
+func (r *readiness) pull(ctx context.Context, names []model.Name) {
+	for {
+		var failed bool
+		for i, n := range names {
+			r.mu.Lock()
+			status := r.models[i].Status
+			r.mu.Unlock()
+
+			if status == prefetchReady {
+				continue
+			}
+
+			r.set(i, prefetchPulling, nil)
+			err := prefetchModel(ctx, n)
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				slog.Warn("unable to prefetch model", "model", n.DisplayShortest(), "error", err)
+				r.set(i, prefetchFailed, err)
+				failed = true
+				continue
+			}
+
+			slog.Info("prefetched model", "model", n.DisplayShortest())
+			r.set(i, prefetchReady, nil)
+		}
+
+		if !failed {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(prefetchRetryInterval):
+		}
+	}
+}
+
+func (r *readiness) set(i int, status string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.models[i].Status = status
+	r.models[i].Error = ""
+	if err != nil {
+		r.models[i].Error = err.Error()
+	}
+}
+
+// prefetchModel makes sure that name is on disk, fetching it from the blob
+// store or pulling it if it is not.
+func prefetchModel(ctx context.Context, name model.Name) error {
+	if err := fetchModel(ctx, name); err != nil {
+		return err
+	}
+
+	if _, err := ParseNamedManifest(name); err == nil {
+		return nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if envconfig.ModelsReadOnly() {
+		return fmt.Errorf("model %s not found and %w", name.DisplayShortest(), errModelsReadOnly)
+	}
+
+	return PullModel(ctx, name.String(), &registryOptions{}, func(api.ProgressResponse) {})
+}
+
+func (r *readiness) status() api.ReadyResponse {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	resp := api.ReadyResponse{
+		Ready:    !r.draining,
+		Draining: r.draining,
+		Active:   r.active,
+		Models:   slices.Clone(r.models),
+	}
+
+	for _, m := range r.models {
+		if m.Status != prefetchReady {
+			resp.Ready = false
+		}
+	}
+
+	return resp
+}
+
+// track counts the requests in progress so that draining can wait for them.
+func (r *readiness) track() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if slices.Contains(probePaths, c.FullPath()) {
+			c.Next()
+			return
+		}
+
+		r.mu.Lock()
+		r.active++
+		r.mu.Unlock()
+
+		defer func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+
+			r.active--
+			if r.active == 0 && r.idle != nil {
+				close(r.idle)
+				r.idle = nil
+			}
+		}()
+
+		c.Next()
+	}
+}
+
+// drain stops reporting the server as ready and waits up to timeout for the
+// requests in progress to finish. It reports whether they did. Requests that
+// arrive while the server drains are still served, since load balancers take
+// a while to stop sending them.
+func (r *readiness) drain(ctx context.Context, timeout time.Duration) bool {
+	r.mu.Lock()
+	if !r.draining {
+		slog.Info("draining server", "active", r.active, "timeout", timeout)
+		r.draining = true
+	}
+
+	if r.active == 0 {
+		r.mu.Unlock()
+		return true
+	}
+
+	if r.idle == nil {
+		r.idle = make(chan struct{})
+	}
+	idle := r.idle
+	r.mu.Unlock()
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	select {
+	case <-idle:
+		return true
+	case <-t.C:
+	case <-ctx.Done():
+	}
+
+	r.mu.Lock()
+	slog.Warn("stopped draining with requests in progress", "active", r.active)
+	r.mu.Unlock()
+	return false
+}
+
+// ReadyHandler reports whether the server is ready to take traffic, for use
+// as a Kubernetes readiness probe.
+func (s *Server) ReadyHandler(c *gin.Context) {
+	resp := s.ready.status()
+	if !resp.Ready {
+		c.JSON(http.StatusServiceUnavailable, resp)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// DrainHandler drains the server and responds once the requests in progress
+// have finished or OLLAMA_DRAIN_TIMEOUT has passed, for use as a Kubernetes
+// preStop hook.
+func (s *Server) DrainHandler(c *gin.Context) {
+	s.ready.drain(c.Request.Context(), envconfig.DrainTimeout())
+	c.JSON(http.StatusOK, s.ready.status())
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/model"
+)
+
+func TestParsePrefetchFile(t *testing.T) {
+	cases := []struct {
+		name, content string
+		expect        []string
+		err           bool
+	}{
+		{"list", "# models\nllama3.2\nqwen2.5:7b, llama3.2\n\n", []string{"llama3.2:latest", "qwen2.5:7b"}, false},
+		{"annotations", "kubernetes.io/config.seen=\"2025-01-01\"\nollama.com/prefetch=\"llama3.2,\\nqwen2.5:7b\"\n", []string{"llama3.2:latest", "qwen2.5:7b"}, false},
+		{"no annotation", "app=\"ollama\"\n", nil, false},
+		{"bad quoting", "ollama.com/prefetch=llama3.2\n", nil, true},
+		{"invalid name", "llama3.2:\n", nil, true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), "prefetch")
+			if err := os.WriteFile(p, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			names, err := parsePrefetchFile(p)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", names)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, n := range names {
+				got = append(got, n.DisplayShortest())
+			}
+
+			if !slices.Equal(got, tt.expect) {
+				t.Errorf("expected %v, got %v", tt.expect, got)
+			}
+		})
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_MODELS_READONLY", "")
+
+	var s Server
+	_, digest := createBinFile(t, nil, nil)
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:   "test",
+		Files:  map[string]string{"test.gguf": digest},
+		Stream: &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// keep the missing model from being pulled
+	t.Setenv("OLLAMA_SCRATCH_DIR", t.TempDir())
+	t.Setenv("OLLAMA_MODELS_READONLY", "1")
+
+	ready := func(s *Server) (int, api.ReadyResponse) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/readyz", nil)
+		s.ReadyHandler(c)
+
+		var resp api.ReadyResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		return w.Code, resp
+	}
+
+	waitFor := func(r *readiness, done func([]api.ModelPrefetch) bool) {
+		t.Helper()
+		for range 100 {
+			if done(r.status().Models) {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("timed out waiting for prefetch: %+v", r.status().Models)
+	}
+
+	t.Run("ready", func(t *testing.T) {
+		var s Server
+		s.ready.prefetch(t.Context(), []model.Name{model.ParseName("test")})
+		waitFor(&s.ready, func(ms []api.ModelPrefetch) bool { return ms[0].Status == prefetchReady })
+
+		if code, resp := ready(&s); code != http.StatusOK || !resp.Ready {
+			t.Errorf("expected ready, got %d %+v", code, resp)
+		}
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		var s Server
+		s.ready.prefetch(t.Context(), []model.Name{model.ParseName("test"), model.ParseName("missing")})
+		waitFor(&s.ready, func(ms []api.ModelPrefetch) bool { return ms[1].Status == prefetchFailed })
+
+		code, resp := ready(&s)
+		if code != http.StatusServiceUnavailable || resp.Ready {
+			t.Errorf("expected not ready, got %d %+v", code, resp)
+		}
+
+		if resp.Models[0].Status != prefetchReady || !strings.Contains(resp.Models[1].Error, "not found") {
+			t.Errorf("unexpected model statuses %+v", resp.Models)
+		}
+	})
+
+	t.Run("draining", func(t *testing.T) {
+		var s Server
+		if !s.ready.drain(t.Context(), 0) {
+			t.Error("expected an idle server to drain")
+		}
+
+		if code, resp := ready(&s); code != http.StatusServiceUnavailable || !resp.Draining {
+			t.Errorf("expected a draining server not to be ready, got %d %+v", code, resp)
+		}
+	})
+}
+
+func TestDrain(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var r readiness
+	router := gin.New()
+	router.Use(r.track())
+
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	router.POST("/api/generate", func(c *gin.Context) {
+		started <- struct{}{}
+		<-release
+		c.Status(http.StatusOK)
+	})
+	router.GET("/readyz", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	go router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/generate", nil))
+	<-started
+
+	// probes are not counted as requests in progress
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if active := r.status().Active; active != 1 {
+		t.Fatalf("expected 1 active request, got %d", active)
+	}
+
+	if r.drain(t.Context(), 10*time.Millisecond) {
+		t.Error("expected draining to time out")
+	}
+
+	drained := make(chan bool)
+	go func() { drained <- r.drain(t.Context(), time.Minute) }()
+
+	select {
+	case <-drained:
+		t.Fatal("expected draining to wait for the request")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(release)
+	if !<-drained {
+		t.Error("expected draining to finish with the request")
+	}
+
+	if active := r.status().Active; active != 0 {
+		t.Errorf("expected no active requests, got %d", active)
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+var errModelsReadOnly = errors.New("the models directory is read-only because OLLAMA_MODELS_READONLY is set")
+
+// writableModelsMiddleware rejects requests that change the models directory
+// if it is read-only.
+func writableModelsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if envconfig.ModelsReadOnly() {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": errModelsReadOnly.Error()})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// checkReadOnlyModels checks that a read-only models directory can be served:
+// its manifests are valid, the blobs they use exist and the scratch directory
+// is writable.
+func checkReadOnlyModels() error {
+	if fi, err := os.Stat(envconfig.Models()); err != nil {
+		return fmt.Errorf("models directory: %w", err)
+	} else if !fi.IsDir() {
+		return fmt.Errorf("models directory %s is not a directory", envconfig.Models())
+	}
+
+	ms, err := Manifests(false)
+	if err != nil {
+		return fmt.Errorf("models directory: %w", err)
+	}
+
+	for name, m := range ms {
+		for _, layer := range append(m.Layers, m.Config) {
+			if layer.Digest == "" {
+				continue
+			}
+
+			p, err := GetBlobsPath(layer.Digest)
+			if err != nil {
+				return fmt.Errorf("model %s: %w", name.DisplayShortest(), err)
+			}
+
+			if _, err := os.Stat(p); err != nil {
+				return fmt.Errorf("model %s is missing blob %s: %w", name.DisplayShortest(), layer.Digest, err)
+			}
+		}
+	}
+
+	if err := os.MkdirAll(envconfig.Scratch(), 0o755); err != nil {
+		return fmt.Errorf("scratch directory: %w", err)
+	}
+
+	f, err := os.CreateTemp(envconfig.Scratch(), ".check-")
+	if err != nil {
+		return fmt.Errorf("scratch directory is not writable: %w", err)
+	}
+	f.Close()
+
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/model"
+)
+
+func TestReadOnlyModels(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_MODELS_READONLY", "")
+
+	var s Server
+	_, digest := createBinFile(t, nil, nil)
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:   "test",
+		Files:  map[string]string{"test.gguf": digest},
+		Stream: &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	scratch := t.TempDir()
+	t.Setenv("OLLAMA_SCRATCH_DIR", scratch)
+	t.Setenv("OLLAMA_MODELS_READONLY", "1")
+
+	if err := checkReadOnlyModels(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("routes", func(t *testing.T) {
+		router, err := s.GenerateRoutes(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cases := []struct {
+			method, path, body string
+			status             int
+		}{
+			{http.MethodPost, "/api/pull", `{"model":"test"}`, http.StatusForbidden},
+			{http.MethodPost, "/api/create", `{"model":"test2","from":"test"}`, http.StatusForbidden},
+			{http.MethodPost, "/api/copy", `{"source":"test","destination":"test2"}`, http.StatusForbidden},
+			{http.MethodDelete, "/api/delete", `{"model":"test"}`, http.StatusForbidden},
+			{http.MethodPost, "/api/blobs/sha256:" + testDigest(1), "", http.StatusForbidden},
+			{http.MethodGet, "/api/tags", "", http.StatusOK},
+			{http.MethodPost, "/api/show", `{"model":"test"}`, http.StatusOK},
+		}
+
+		for _, tt := range cases {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+			if w.Code != tt.status {
+				t.Errorf("%s %s: expected status %d, got %d: %s", tt.method, tt.path, tt.status, w.Code, w.Body.String())
+			}
+
+			if tt.status == http.StatusForbidden && !strings.Contains(w.Body.String(), "OLLAMA_MODELS_READONLY") {
+				t.Errorf("%s %s: expected a read-only error, got %s", tt.method, tt.path, w.Body.String())
+			}
+		}
+
+		if _, err := ParseNamedManifest(model.ParseName("test")); err != nil {
+			t.Errorf("expected the model to be kept: %v", err)
+		}
+	})
+
+	t.Run("scratch", func(t *testing.T) {
+		d := "sha256:" + testDigest(1)
+		p, err := GetModelCachePath(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if filepath.Dir(filepath.Dir(p)) != scratch {
+			t.Errorf("expected the cache in the scratch directory, got %s", p)
+		}
+
+		// files that are part of the models directory are used
+		baked := filepath.Join(os.Getenv("OLLAMA_MODELS"), "checksums", "sha256-"+testDigest(1)+".json")
+		if err := os.MkdirAll(filepath.Dir(baked), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(baked, []byte("{}"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if p, err := GetChecksumsPath(d); err != nil || p != baked {
+			t.Errorf("expected %s, got %s, %v", baked, p, err)
+		}
+
+		if _, err := lockStore(t.Context()); !errors.Is(err, errModelsReadOnly) {
+			t.Errorf("expected a read-only error, got %v", err)
+		}
+	})
+
+	t.Run("missing blob", func(t *testing.T) {
+		m, err := ParseNamedManifest(model.ParseName("test"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p, err := GetBlobsPath(m.Layers[0].Digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Remove(p); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := checkReadOnlyModels(); err == nil || !strings.Contains(err.Error(), "missing blob") {
+			t.Errorf("expected a missing blob error, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/crash"
+)
+
+var errInternal = errors.New("internal server error")
+
+// reportPanic logs the panic v of the handler of c and returns the error to
+// respond with.
+func reportPanic(c *gin.Context, v any) error {
+	r := crash.New("server", v)
+	r.RequestID = requestID(c)
+	r.Method = c.Request.Method
+	r.Route = c.FullPath()
+	r.Log()
+
+	return fmt.Errorf("%w (request id %s)", errInternal, r.RequestID)
+}
+
+// recoveryMiddleware responds to requests whose handler panics with a 500
+// error naming the request ID the panic was logged with.
+func recoveryMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			} else if v == http.ErrAbortHandler {
+				// the handler aborted the response on purpose
+				panic(v)
+			}
+
+			err := reportPanic(c, v)
+			if c.Writer.Written() {
+				// the status was sent with the start of a streamed response
+				c.Abort()
+				return
+			}
+
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}()
+
+		c.Next()
+	}
+}
+
+// recoverStream is deferred by the goroutines that produce the responses
+// of streaming handlers, which the middleware can't recover, to end the
+// stream with an error instead of crashing the server.
+func recoverStream(c *gin.Context, ch chan any) {
+	if v := recover(); v != nil {
+		ch <- gin.H{"error": reportPanic(c, v).Error()}
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRecoveryMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	t.Setenv("OLLAMA_CRASH_DIR", dir)
+
+	r := gin.New()
+	r.Use(recoveryMiddleware())
+	r.GET("/panic", func(c *gin.Context) {
+		var m map[string]int
+		m["boom"]++
+	})
+	r.GET("/stream", func(c *gin.Context) {
+		ch := make(chan any)
+		go func() {
+			defer close(ch)
+			defer recoverStream(c, ch)
+			ch <- gin.H{"status": "starting"}
+			panic("boom")
+		}()
+
+		streamResponse(c, ch)
+	})
+
+	t.Run("handler", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/panic", nil)
+		req.Header.Set("X-Request-ID", "req-1")
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusInternalServerError {
+			t.Fatalf("expected status %d, got %d", http.StatusInternalServerError, w.Code)
+		}
+
+		var resp struct {
+			Error string `json:"error"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Error != "internal server error (request id req-1)" {
+			t.Errorf("unexpected error %q", resp.Error)
+		}
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(entries) != 1 || !strings.HasSuffix(entries[0].Name(), "-req-1.json") {
+			t.Errorf("expected a crash report, got %v", entries)
+		}
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		w := NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stream", nil))
+
+		lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+		if len(lines) != 2 || !strings.Contains(lines[1], `"error":"internal server error (request id `) {
+			t.Errorf("expected the stream to end with an error, got %q", lines)
+		}
+	})
+
+	t.Run("invalid request id", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/panic", nil)
+		req.Header.Set("X-Request-ID", "not valid\n")
+		r.ServeHTTP(w, req)
+
+		if strings.Contains(w.Body.String(), "not valid") {
+			t.Errorf("expected a generated request id, got %s", w.Body.String())
+		}
+	})
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+// checkRemote refuses to serve on addresses that other machines can reach
+// unless OLLAMA_ALLOW_REMOTE acknowledges it. The API has no authentication,
+// so anyone who can connect can run, pull and delete models.
+func checkRemote(lns []net.Listener) error {
+	var remote []string
+	for _, ln := range lns {
+		if isRemote(ln.Addr()) {
+			remote = append(remote, ln.Addr().String())
+		}
+	}
+
+	if len(remote) == 0 {
+		return nil
+	}
+
+	if !envconfig.AllowRemote() {
+		return fmt.Errorf("refusing to listen on %s without authentication, since other machines can connect to it: set OLLAMA_ALLOW_REMOTE=1 to allow this", strings.Join(remote, ", "))
+	}
+
+	if envconfig.AllowedIPs() == "" {
+		slog.Warn("!!! OLLAMA IS REACHABLE FROM OTHER MACHINES WITHOUT AUTHENTICATION !!! Anyone who can connect can run, pull and delete models. Set OLLAMA_ALLOWED_IPS to restrict which clients may connect.", "addrs", remote)
+	} else {
+		slog.Warn("Ollama is reachable from other machines without authentication", "addrs", remote, "allowed", envconfig.AllowedIPs())
+	}
+
+	return nil
+}
+
+// isRemote reports whether other machines may be able to connect to addr.
+// Unix domain sockets and named pipes are local.
+func isRemote(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	ip, ok := netip.AddrFromSlice(tcp.IP)
+	return !ok || !ip.Unmap().IsLoopback()
+}
+
+// parsePrefixes parses a comma separated list of addresses and ranges in
+// CIDR notation.
+func parsePrefixes(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		if strings.Contains(field, "/") {
+			prefix, err := netip.ParsePrefix(field)
+			if err != nil {
+				return nil, err
+			}
+
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(field)
+		if err != nil {
+			return nil, err
+		}
+
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+
+	return prefixes, nil
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ipFilterMiddleware rejects clients whose address is in OLLAMA_DENIED_IPS or,
+// when OLLAMA_ALLOWED_IPS is set, not in it. Clients connecting over loopback
+// or a Unix domain socket are only subject to the denylist. Addresses are
+// those of the connection, so a reverse proxy must filter clients itself.
+func ipFilterMiddleware() (gin.HandlerFunc, error) {
+	allowed, err := parsePrefixes(envconfig.AllowedIPs())
+	if err != nil {
+		return nil, fmt.Errorf("OLLAMA_ALLOWED_IPS: %w", err)
+	}
+
+	denied, err := parsePrefixes(envconfig.DeniedIPs())
+	if err != nil {
+		return nil, fmt.Errorf("OLLAMA_DENIED_IPS: %w", err)
+	}
+
+	return func(c *gin.Context) {
+		if len(allowed) == 0 && len(denied) == 0 {
+			c.Next()
+			return
+		}
+
+		addrPort, err := netip.ParseAddrPort(c.Request.RemoteAddr)
+		if err != nil {
+			// connections over unix sockets and named pipes have no address
+			c.Next()
+			return
+		}
+
+		addr := addrPort.Addr().Unmap()
+		if containsAddr(denied, addr) ||
+			(len(allowed) > 0 && !addr.IsLoopback() && !containsAddr(allowed, addr)) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("clients from %s are not allowed", addr)})
+			return
+		}
+
+		c.Next()
+	}, nil
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type addrListener struct {
+	net.Listener
+	addr net.Addr
+}
+
+func (l addrListener) Addr() net.Addr { return l.addr }
+
+func TestCheckRemote(t *testing.T) {
+	loopback := addrListener{addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 11434}}
+	unspecified := addrListener{addr: &net.TCPAddr{IP: net.IPv4zero, Port: 11434}}
+	unix := addrListener{addr: &net.UnixAddr{Name: "/run/ollama.sock", Net: "unix"}}
+
+	cases := []struct {
+		name   string
+		lns    []net.Listener
+		allow  string
+		expect bool
+	}{
+		{"loopback", []net.Listener{loopback, unix}, "", true},
+		{"remote", []net.Listener{loopback, unspecified}, "", false},
+		{"remote allowed", []net.Listener{unspecified}, "1", true},
+		{"remote not allowed", []net.Listener{unspecified}, "false", false},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OLLAMA_ALLOW_REMOTE", tt.allow)
+			if err := checkRemote(tt.lns); (err == nil) != tt.expect {
+				t.Errorf("expected success %t, got %v", tt.expect, err)
+			}
+		})
+	}
+}
+
+func TestParsePrefixes(t *testing.T) {
+	prefixes, err := parsePrefixes("10.0.0.0/8, 192.168.1.5,,::ffff:172.16.0.1, fd00::/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []string{"10.0.0.0/8", "192.168.1.5/32", "172.16.0.1/32", "fd00::/8"}
+	if len(prefixes) != len(expect) {
+		t.Fatalf("expected %v, got %v", expect, prefixes)
+	}
+
+	for i := range expect {
+		if prefixes[i].String() != expect[i] {
+			t.Errorf("expected %s, got %s", expect[i], prefixes[i])
+		}
+	}
+
+	for _, s := range []string{"10.0.0.0/33", "example.com"} {
+		if _, err := parsePrefixes(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
+
+func TestIPFilterMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cases := []struct {
+		name           string
+		allowed        string
+		denied         string
+		remoteAddr     string
+		expectedStatus int
+	}{
+		{"no lists", "", "", "203.0.113.7:50000", http.StatusOK},
+		{"allowed", "192.168.0.0/16", "", "192.168.1.20:50000", http.StatusOK},
+		{"not allowed", "192.168.0.0/16", "", "203.0.113.7:50000", http.StatusForbidden},
+		{"loopback", "192.168.0.0/16", "", "127.0.0.1:50000", http.StatusOK},
+		{"ipv4 mapped", "192.168.0.0/16", "", "[::ffff:192.168.1.20]:50000", http.StatusOK},
+		{"denied", "192.168.0.0/16", "192.168.1.20", "192.168.1.20:50000", http.StatusForbidden},
+		{"denied loopback", "", "127.0.0.0/8", "127.0.0.1:50000", http.StatusForbidden},
+		{"unix socket", "192.168.0.0/16", "", "@", http.StatusOK},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OLLAMA_ALLOWED_IPS", tt.allowed)
+			t.Setenv("OLLAMA_DENIED_IPS", tt.denied)
+
+			filter, err := ipFilterMiddleware()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := gin.New()
+			r.Use(filter)
+			r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		t.Setenv("OLLAMA_ALLOWED_IPS", "not an address")
+		if _, err := ipFilterMiddleware(); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/logutil"
+)
+
+// reRequestID matches request IDs that clients may choose
+var reRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// requestID returns the ID of the request, which is the X-Request-ID header
+// sent by the client or a random ID.
+func requestID(c *gin.Context) string {
+	if id := c.GetString("request_id"); id != "" {
+		return id
+	}
+
+	id := c.GetHeader(logutil.RequestIDHeader)
+	if !reRequestID.MatchString(id) {
+		var b [8]byte
+		rand.Read(b[:])
+		id = hex.EncodeToString(b[:])
+	}
+
+	c.Set("request_id", id)
+	return id
+}
+
+// requestIDMiddleware returns the ID of each request in the X-Request-ID
+// header of its response and adds it to the context of the request, so that
+// log lines and requests to runners made on its behalf carry it too.
+func requestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := requestID(c)
+		c.Header(logutil.RequestIDHeader, id)
+		c.Request = c.Request.WithContext(logutil.WithRequestID(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+// requestIDResponseMiddleware adds the ID of each request to the chunks of
+// its streamed response and to its error response, for clients that log
+// bodies rather than headers.
+func requestIDResponseMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		field, _ := json.Marshal(requestID(c))
+		w := &requestIDWriter{ResponseWriter: c.Writer, field: fmt.Appendf(nil, `{"request_id":%s,`, field)}
+		c.Writer = w
+		defer func() {
+			c.Writer = w.ResponseWriter
+		}()
+
+		c.Next()
+	}
+}
+
+// requestIDWriter inserts field, the opening of a JSON object with the
+// request ID as its first member, into the objects written to it.
+type requestIDWriter struct {
+	gin.ResponseWriter
+	field []byte
+}
+
+// tagged reports whether each write to the response is a JSON object that
+// should carry the request ID.
+func (w *requestIDWriter) tagged() bool {
+	mediaType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	switch mediaType {
+	case "application/x-ndjson", "text/event-stream":
+		// events are written as JSON objects to the writer that frames them
+		return true
+	case "application/json":
+		return w.Status() >= http.StatusBadRequest
+	default:
+		return false
+	}
+}
+
+func (w *requestIDWriter) Write(b []byte) (int, error) {
+	// objects without members are left alone rather than given a trailing comma
+	if !w.tagged() || !bytes.HasPrefix(b, []byte("{")) || bytes.HasPrefix(b, []byte("{}")) {
+		return w.ResponseWriter.Write(b)
+	}
+
+	n, err := w.ResponseWriter.Write(append(w.field[:len(w.field):len(w.field)], b[1:]...))
+	return max(0, n-len(w.field)+1), err
+}
+
+func (w *requestIDWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// logFormatter formats requests like the default logger of gin, followed by
+// their request ID.
+func logFormatter(param gin.LogFormatterParams) string {
+	var statusColor, methodColor, resetColor string
+	if param.IsOutputColor() {
+		statusColor = param.StatusCodeColor()
+		methodColor = param.MethodColor()
+		resetColor = param.ResetColor()
+	}
+
+	if param.Latency > time.Minute {
+		param.Latency = param.Latency.Truncate(time.Second)
+	}
+
+	id, _ := param.Keys["request_id"].(string)
+	return fmt.Sprintf("[GIN] %v |%s %3d %s| %13v | %15s |%s %-7s %s %#v | %s\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		statusColor, param.StatusCode, resetColor,
+		param.Latency,
+		param.ClientIP,
+		methodColor, param.Method, resetColor,
+		param.Path,
+		id,
+		param.ErrorMessage,
+	)
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/logutil"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.Use(requestIDMiddleware(), compressionMiddleware(), requestIDResponseMiddleware())
+	r.GET("/ok", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "request_id": logutil.RequestID(c.Request.Context())})
+	})
+	r.GET("/error", func(c *gin.Context) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad"})
+	})
+	r.GET("/stream", func(c *gin.Context) {
+		ch := make(chan any, 3)
+		ch <- gin.H{"status": "one"}
+		ch <- gin.H{}
+		ch <- gin.H{"status": "two"}
+		close(ch)
+		streamResponse(c, ch)
+	})
+
+	t.Run("header", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/ok", nil)
+		req.Header.Set("X-Request-ID", "req-1")
+		r.ServeHTTP(w, req)
+
+		if id := w.Header().Get("X-Request-ID"); id != "req-1" {
+			t.Errorf("expected request id req-1, got %q", id)
+		}
+
+		// successful responses other than streams are left alone
+		if body := w.Body.String(); body != `{"request_id":"req-1","status":"ok"}` {
+			t.Errorf("unexpected body %s", body)
+		}
+	})
+
+	t.Run("generated", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/ok", nil)
+		req.Header.Set("X-Request-ID", "not valid\n")
+		r.ServeHTTP(w, req)
+
+		if id := w.Header().Get("X-Request-ID"); !reRequestID.MatchString(id) || id == "not valid\n" {
+			t.Errorf("expected a generated request id, got %q", id)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/error", nil)
+		req.Header.Set("X-Request-ID", "req-2")
+		r.ServeHTTP(w, req)
+
+		if body := w.Body.String(); body != `{"request_id":"req-2","error":"bad"}` {
+			t.Errorf("unexpected body %s", body)
+		}
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		w := NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/stream", nil)
+		req.Header.Set("X-Request-ID", "req-3")
+		r.ServeHTTP(w, req)
+
+		expect := []string{
+			`{"request_id":"req-3","status":"one"}`,
+			`{}`,
+			`{"request_id":"req-3","status":"two"}`,
+		}
+		if lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n"); strings.Join(lines, "\n") != strings.Join(expect, "\n") {
+			t.Errorf("expected %q, got %q", expect, lines)
+		}
+
+		for _, line := range expect {
+			if !json.Valid([]byte(line)) {
+				t.Errorf("invalid json %s", line)
+			}
+		}
+	})
+}
+
+func TestRequestIDLog(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var b strings.Builder
+	logger := slog.New(logutil.NewHandler(slog.NewTextHandler(&b, nil)))
+
+	r := gin.New()
+	r.Use(requestIDMiddleware())
+	r.GET("/", func(c *gin.Context) {
+		logger.InfoContext(c.Request.Context(), "handling")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Request-ID", "req-4")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	if !strings.Contains(b.String(), "request_id=req-4") {
+		t.Errorf("expected the log line to carry the request id, got %q", b.String())
+	}
+}
@@ -0,0 +1,286 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/types/model"
+)
+
+// routerConfig is the file named by OLLAMA_ROUTERS. It defines router and
+// fallback models: names that don't refer to a model of their own but send
+// each request to one of several models.
+type routerConfig struct {
+	Routers   map[string]*router   `json:"routers"`
+	Fallbacks map[string]*fallback `json:"fallbacks"`
+}
+
+// router picks the model of a request from its routes, either by asking the
+// classifier model or by the rules of the routes.
+type router struct {
+	name string
+
+	// Classifier is a model that is asked which route suits a request
+	// best, given their descriptions. The rules pick the route if it is
+	// not set or fails to answer.
+	Classifier string  `json:"classifier,omitempty"`
+	Routes     []route `json:"routes"`
+}
+
+// route is a model that a router sends requests to. Requests that match all
+// rules of a route use it, and a route without rules matches every request.
+type route struct {
+	Model       string `json:"model"`
+	Description string `json:"description,omitempty"`
+
+	// MaxPromptLength matches prompts of at most this many characters
+	MaxPromptLength int `json:"max_prompt_length,omitempty"`
+
+	// Match is a regular expression that matches prompts
+	Match string `json:"match,omitempty"`
+	match *regexp.Regexp
+
+	// Images and Tools match requests with or without images or tools
+	Images *bool `json:"images,omitempty"`
+	Tools  *bool `json:"tools,omitempty"`
+}
+
+// routeInput is what the rules and the classifier know of a request.
+type routeInput struct {
+	prompt string
+	images bool
+	tools  bool
+}
+
+// chatRouteInput routes chat requests by their last user message.
+func chatRouteInput(req api.ChatRequest) routeInput {
+	in := routeInput{tools: len(req.Tools) > 0}
+	for _, msg := range req.Messages {
+		if msg.Role == "user" {
+			in.prompt = msg.Content
+		}
+		if len(msg.Images) > 0 {
+			in.images = true
+		}
+	}
+
+	return in
+}
+
+func (r *route) matches(in routeInput) bool {
+	return (r.MaxPromptLength == 0 || len([]rune(in.prompt)) <= r.MaxPromptLength) &&
+		(r.match == nil || r.match.MatchString(in.prompt)) &&
+		(r.Images == nil || *r.Images == in.images) &&
+		(r.Tools == nil || *r.Tools == in.tools)
+}
+
+// loadRouters reads the router and fallback models from the file named by
+// OLLAMA_ROUTERS, keyed by the full name of the model that they stand in for.
+func loadRouters() (map[string]*router, map[string]*fallback, error) {
+	path := envconfig.Routers()
+	if path == "" {
+		return nil, nil, nil
+	}
+
+	bts, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var config routerConfig
+	if err := json.Unmarshal(bts, &config); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	routers := make(map[string]*router, len(config.Routers))
+	for name, r := range config.Routers {
+		n := model.ParseName(name)
+		if !n.IsValid() {
+			return nil, nil, fmt.Errorf("router %q: invalid model name", name)
+		}
+
+		if r == nil || len(r.Routes) == 0 {
+			return nil, nil, fmt.Errorf("router %q has no routes", name)
+		}
+
+		r.name = name
+		for i := range r.Routes {
+			rt := &r.Routes[i]
+			if rt.Model == "" {
+				return nil, nil, fmt.Errorf("router %q: route %d has no model", name, i)
+			}
+
+			if r.Classifier != "" && rt.Description == "" {
+				return nil, nil, fmt.Errorf("router %q: route %d needs a description for the classifier", name, i)
+			}
+
+			if rt.Match != "" {
+				if rt.match, err = regexp.Compile(rt.Match); err != nil {
+					return nil, nil, fmt.Errorf("router %q: route %d: %w", name, i, err)
+				}
+			}
+		}
+
+		routers[n.String()] = r
+	}
+
+	fallbacks := make(map[string]*fallback, len(config.Fallbacks))
+	for name, f := range config.Fallbacks {
+		n := model.ParseName(name)
+		if !n.IsValid() {
+			return nil, nil, fmt.Errorf("fallback %q: invalid model name", name)
+		}
+
+		if _, ok := routers[n.String()]; ok {
+			return nil, nil, fmt.Errorf("fallback %q is also a router", name)
+		}
+
+		if f == nil || len(f.Models) == 0 {
+			return nil, nil, fmt.Errorf("fallback %q has no models", name)
+		}
+
+		f.name = name
+		for i, fm := range f.Models {
+			if fm.Model == "" {
+				return nil, nil, fmt.Errorf("fallback %q: model %d has no name", name, i)
+			}
+
+			if fm.MinLogprob != nil && *fm.MinLogprob > 0 {
+				return nil, nil, fmt.Errorf("fallback %q: model %d: min_logprob must not be positive", name, i)
+			}
+		}
+
+		fallbacks[n.String()] = f
+	}
+
+	// routes and fallbacks go to models of their own, which keeps requests
+	// from going around in circles
+	isVirtual := func(name string) bool {
+		n := model.ParseName(name).String()
+		_, router := routers[n]
+		_, fallback := fallbacks[n]
+		return router || fallback
+	}
+
+	for _, r := range routers {
+		for _, rt := range r.Routes {
+			if isVirtual(rt.Model) {
+				return nil, nil, fmt.Errorf("router %q: route to router %q", r.name, rt.Model)
+			}
+		}
+	}
+
+	for _, f := range fallbacks {
+		for _, fm := range f.Models {
+			if isVirtual(fm.Model) {
+				return nil, nil, fmt.Errorf("fallback %q: %q is a router or fallback model", f.name, fm.Model)
+			}
+		}
+	}
+
+	return routers, fallbacks, nil
+}
+
+// route picks the model for a request to name, or returns nil if name is not
+// a router.
+func (s *Server) route(ctx context.Context, name string, in routeInput) *api.RouteDecision {
+	r, ok := s.routers[model.ParseName(name).String()]
+	if !ok {
+		return nil
+	}
+
+	decision := &api.RouteDecision{Router: name}
+	if r.Classifier != "" {
+		i, err := s.classifyRoute(ctx, r, in)
+		if err == nil {
+			decision.Model, decision.Reason = r.Routes[i].Model, "classifier"
+			return decision
+		}
+
+		slog.WarnContext(ctx, "router classifier failed, using rules", "router", name, "classifier", r.Classifier, "error", err)
+	}
+
+	for _, rt := range r.Routes {
+		if rt.matches(in) {
+			decision.Model, decision.Reason = rt.Model, "rule"
+			return decision
+		}
+	}
+
+	decision.Model, decision.Reason = r.Routes[len(r.Routes)-1].Model, "default"
+	return decision
+}
+
+// maxClassifierPrompt is the number of characters of a prompt shown to the
+// classifier of a router, which only needs to get an idea of the request.
+const maxClassifierPrompt = 2000
+
+var errNoRoute = errors.New("no route in classifier response")
+
+// classifyRoute asks the classifier of r which of its routes suits the
+// request and returns its index.
+func (s *Server) classifyRoute(ctx context.Context, r *router, in routeInput) (int, error) {
+	runner, m, opts, _, err := s.scheduleRunner(ctx, r.Classifier, []Capability{CapabilityCompletion}, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	prompt := []rune(in.prompt)
+	if len(prompt) > maxClassifierPrompt {
+		prompt = prompt[:maxClassifierPrompt]
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Pick the model that should answer the request below. The models are:\n\n")
+	for i, rt := range r.Routes {
+		fmt.Fprintf(&sb, "%d. %s\n", i+1, rt.Description)
+	}
+	if in.images {
+		sb.WriteString("\nThe request includes images.")
+	}
+	if in.tools {
+		sb.WriteString("\nThe request offers tools to call.")
+	}
+	fmt.Fprintf(&sb, "\n\nRequest:\n%s\n\nReply with the number of the model only.", string(prompt))
+
+	msgs := []api.Message{{Role: "user", Content: sb.String()}}
+	p, _, err := chatPrompt(ctx, m, runner.Tokenize, opts, msgs, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	// the answer is a short number that should be the most likely one
+	opts.NumPredict = 8
+	opts.Temperature = 0
+
+	var answer strings.Builder
+	if err := runner.Completion(ctx, llm.CompletionRequest{Prompt: p, Options: opts}, func(cr llm.CompletionResponse) {
+		answer.WriteString(cr.Content)
+	}); err != nil {
+		return 0, err
+	}
+
+	return parseRoute(answer.String(), len(r.Routes))
+}
+
+var routeNumber = regexp.MustCompile(`\d+`)
+
+// parseRoute returns the index of the first route numbered in answer.
+func parseRoute(answer string, routes int) (int, error) {
+	n, err := strconv.Atoi(routeNumber.FindString(answer))
+	if err != nil || n < 1 || n > routes {
+		return 0, fmt.Errorf("%w: %q", errNoRoute, answer)
+	}
+
+	return n - 1, nil
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/model"
+)
+
+func TestLoadRouters(t *testing.T) {
+	load := func(t *testing.T, config string) (map[string]*router, map[string]*fallback, error) {
+		t.Helper()
+
+		path := filepath.Join(t.TempDir(), "routers.json")
+		if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		t.Setenv("OLLAMA_ROUTERS", path)
+		return loadRouters()
+	}
+
+	t.Run("unset", func(t *testing.T) {
+		t.Setenv("OLLAMA_ROUTERS", "")
+		routers, fallbacks, err := loadRouters()
+		if err != nil || routers != nil || fallbacks != nil {
+			t.Errorf("expected no routers, got %v, %v, %v", routers, fallbacks, err)
+		}
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		routers, fallbacks, err := load(t, `{"routers": {"auto": {"routes": [
+			{"model": "llama3.2:1b", "max_prompt_length": 200, "match": "(?i)hello"},
+			{"model": "llama3.3:70b"}
+		]}}, "fallbacks": {"resilient": {"models": [
+			{"model": "llama3.3:70b", "timeout": "30s", "min_logprob": -1.5},
+			{"model": "llama3.2:1b"}
+		]}}}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, ok := routers[model.ParseName("auto:latest").String()]
+		if !ok || len(r.Routes) != 2 || r.Routes[0].match == nil {
+			t.Fatalf("unexpected routers %+v", routers)
+		}
+
+		f, ok := fallbacks[model.ParseName("resilient:latest").String()]
+		if !ok || len(f.Models) != 2 || f.Models[0].Timeout.Duration != 30*time.Second || *f.Models[0].MinLogprob != -1.5 {
+			t.Fatalf("unexpected fallbacks %+v", fallbacks)
+		}
+	})
+
+	cases := map[string]struct {
+		config string
+		err    string
+	}{
+		"no routes":          {`{"routers": {"auto": {"routes": []}}}`, "has no routes"},
+		"no model":           {`{"routers": {"auto": {"routes": [{"max_prompt_length": 10}]}}}`, "has no model"},
+		"no description":     {`{"routers": {"auto": {"classifier": "small", "routes": [{"model": "big"}]}}}`, "needs a description"},
+		"invalid match":      {`{"routers": {"auto": {"routes": [{"model": "big", "match": "("}]}}}`, "missing closing )"},
+		"route to router":    {`{"routers": {"auto": {"routes": [{"model": "other"}]}, "other": {"routes": [{"model": "big"}]}}}`, "route to router"},
+		"invalid model name": {`{"routers": {"a:b:c": {"routes": [{"model": "big"}]}}}`, "invalid model name"},
+		"no fallback models": {`{"fallbacks": {"safe": {"models": []}}}`, "has no models"},
+		"no fallback name":   {`{"fallbacks": {"safe": {"models": [{"timeout": "1s"}]}}}`, "has no name"},
+		"positive logprob":   {`{"fallbacks": {"safe": {"models": [{"model": "big", "min_logprob": 1}]}}}`, "must not be positive"},
+		"fallback to router": {`{"routers": {"auto": {"routes": [{"model": "big"}]}}, "fallbacks": {"safe": {"models": [{"model": "auto"}]}}}`, "is a router or fallback model"},
+		"route to fallback":  {`{"routers": {"auto": {"routes": [{"model": "safe"}]}}, "fallbacks": {"safe": {"models": [{"model": "big"}]}}}`, "route to router"},
+		"router fallback":    {`{"routers": {"auto": {"routes": [{"model": "big"}]}}, "fallbacks": {"auto": {"models": [{"model": "big"}]}}}`, "is also a router"},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := load(t, tt.config)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestRouteRules(t *testing.T) {
+	yes, no := true, false
+	s := Server{routers: map[string]*router{
+		model.ParseName("auto").String(): {name: "auto", Routes: []route{
+			{Model: "vision", Images: &yes},
+			{Model: "coder", Tools: &no, match: regexp.MustCompile(`(?i)\bcode\b`)},
+			{Model: "small", MaxPromptLength: 10},
+			{Model: "large", Tools: &yes},
+		}},
+	}}
+
+	cases := []struct {
+		name string
+		in   routeInput
+		want api.RouteDecision
+	}{
+		{"images", routeInput{prompt: "what is this?", images: true}, api.RouteDecision{Router: "auto", Model: "vision", Reason: "rule"}},
+		{"match", routeInput{prompt: "write some Code please"}, api.RouteDecision{Router: "auto", Model: "coder", Reason: "rule"}},
+		{"short", routeInput{prompt: "hello"}, api.RouteDecision{Router: "auto", Model: "small", Reason: "rule"}},
+		{"tools", routeInput{prompt: "book a flight to Lisbon", tools: true}, api.RouteDecision{Router: "auto", Model: "large", Reason: "rule"}},
+		{"default", routeInput{prompt: "tell me a long story"}, api.RouteDecision{Router: "auto", Model: "large", Reason: "default"}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			got := s.route(t.Context(), "auto", tt.in)
+			if got == nil || !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+
+	if got := s.route(t.Context(), "llama3", routeInput{}); got != nil {
+		t.Errorf("expected no route for other models, got %+v", got)
+	}
+}
+
+func TestChatRouteInput(t *testing.T) {
+	in := chatRouteInput(api.ChatRequest{
+		Messages: []api.Message{
+			{Role: "system", Content: "be brief"},
+			{Role: "user", Content: "what is this?", Images: []api.ImageData{[]byte("image")}},
+			{Role: "assistant", Content: "a cat"},
+			{Role: "user", Content: "what color is it?"},
+		},
+		Tools: []api.Tool{{Type: "function"}},
+	})
+
+	if in != (routeInput{prompt: "what color is it?", images: true, tools: true}) {
+		t.Errorf("unexpected input %+v", in)
+	}
+}
+
+func TestParseRoute(t *testing.T) {
+	cases := []struct {
+		answer string
+		want   int
+		err    bool
+	}{
+		{"2", 1, false},
+		{" 1.\n", 0, false},
+		{"Model 3 suits it best", 2, false},
+		{"4", 0, true},
+		{"0", 0, true},
+		{"the large one", 0, true},
+	}
+
+	for _, tt := range cases {
+		got, err := parseRoute(tt.answer, 3)
+		if tt.err {
+			if !errors.Is(err, errNoRoute) {
+				t.Errorf("%q: expected errNoRoute, got %v", tt.answer, err)
+			}
+			continue
+		}
+
+		if err != nil || got != tt.want {
+			t.Errorf("%q: expected %d, got %d, %v", tt.answer, tt.want, got, err)
+		}
+	}
+}
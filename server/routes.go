@@ -17,23 +17,23 @@ import (
 	"net/netip"
 	"os"
 	"os/signal"
-	"path/filepath"
 	"slices"
 	"strings"
 	"syscall"
 	"time"
 
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"golang.org/x/sync/errgroup"
 
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/discover"
 	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/fs/ggml"
 	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/logutil"
 	"github.com/ollama/ollama/model/models/mllama"
+	"github.com/ollama/ollama/npipe"
 	"github.com/ollama/ollama/openai"
+	"github.com/ollama/ollama/runner/common"
 	"github.com/ollama/ollama/server/internal/client/ollama"
 	"github.com/ollama/ollama/server/internal/registry"
 	"github.com/ollama/ollama/template"
@@ -51,8 +51,20 @@ var useClient2 = experimentEnabled("client2")
 var mode string = gin.DebugMode
 
 type Server struct {
-	addr  net.Addr
-	sched *Scheduler
+	addr     net.Addr
+	sched    *Scheduler
+	energy   *energyMonitor
+	lengths  *outputLengths
+	updates  updater
+	canaries canaries
+	ready    readiness
+	cancels  cancels
+	sessions sessions
+
+	// routers and fallbacks are the router and fallback models from
+	// OLLAMA_ROUTERS by their full name
+	routers   map[string]*router
+	fallbacks map[string]*fallback
 }
 
 func init() {
@@ -68,8 +80,9 @@ func init() {
 }
 
 var (
-	errRequired    = errors.New("is required")
-	errBadTemplate = errors.New("template error")
+	errRequired      = errors.New("is required")
+	errBadTemplate   = errors.New("template error")
+	errInvalidOption = errors.New("invalid option")
 )
 
 func modelOptions(model *Model, requestOpts map[string]interface{}) (api.Options, error) {
@@ -82,28 +95,98 @@ func modelOptions(model *Model, requestOpts map[string]interface{}) (api.Options
 		return api.Options{}, err
 	}
 
+	if err := validateBatchSize(model, opts); err != nil {
+		return api.Options{}, err
+	}
+
+	if _, err := common.NewStopMatcher(nil, opts.StopRegex); err != nil {
+		return api.Options{}, fmt.Errorf("%w: %v", errInvalidOption, err)
+	}
+
 	return opts, nil
 }
 
+// validateCompletionOptions checks the options of a completion with model
+// that the runner can only reject once it has been scheduled.
+func validateCompletionOptions(model *Model, requestOpts map[string]any) error {
+	opts, err := modelOptions(model, requestOpts)
+	if err != nil {
+		return err
+	}
+
+	if opts.IgnoreEOS && opts.NumPredict <= 0 {
+		return fmt.Errorf("%w: ignore_eos requires num_predict to be set", errInvalidOption)
+	}
+
+	return nil
+}
+
+// validateBatchSize checks num_batch and num_ubatch against the limits of the
+// runners so bad values are rejected before a model is loaded with them.
+func validateBatchSize(model *Model, opts api.Options) error {
+	switch {
+	case opts.NumBatch < 1:
+		return fmt.Errorf("%w: num_batch must be at least 1, got %d", errInvalidOption, opts.NumBatch)
+	case opts.NumUBatch < 0:
+		return fmt.Errorf("%w: num_ubatch must not be negative, got %d", errInvalidOption, opts.NumUBatch)
+	case opts.NumUBatch == 0:
+		// let the runner decide
+	case opts.NumUBatch > opts.NumBatch:
+		return fmt.Errorf("%w: num_ubatch (%d) must not be larger than num_batch (%d)", errInvalidOption, opts.NumUBatch, opts.NumBatch)
+	case opts.NumUBatch < opts.NumBatch && model.CheckCapabilities(CapabilityCompletion) != nil:
+		// embedding models attend to the whole input so llama.cpp must
+		// compute each batch at once
+		return fmt.Errorf("%w: num_ubatch (%d) must equal num_batch (%d) for embedding models", errInvalidOption, opts.NumUBatch, opts.NumBatch)
+	}
+
+	return nil
+}
+
 // scheduleRunner schedules a runner after validating inputs such as capabilities and model options.
-// It returns the allocated runner, model instance, and consolidated options if successful and error otherwise.
-func (s *Server) scheduleRunner(ctx context.Context, name string, caps []Capability, requestOpts map[string]any, keepAlive *api.Duration) (llm.LlamaServer, *Model, *api.Options, error) {
+// It returns the allocated runner, model instance, consolidated options and any warnings if successful
+// and error otherwise. The model may be a smaller quantization of name if OLLAMA_QUANT_FALLBACK is set.
+func (s *Server) scheduleRunner(ctx context.Context, name string, caps []Capability, requestOpts map[string]any, keepAlive *api.Duration) (llm.LlamaServer, *Model, *api.Options, []api.Warning, error) {
 	if name == "" {
-		return nil, nil, nil, fmt.Errorf("model %w", errRequired)
+		return nil, nil, nil, nil, fmt.Errorf("model %w", errRequired)
+	}
+
+	if err := fetchModel(ctx, model.ParseName(name)); err != nil {
+		return nil, nil, nil, nil, err
 	}
 
 	model, err := GetModel(name)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, nil, nil, nil, err
 	}
 
 	if err := model.CheckCapabilities(caps...); err != nil {
-		return nil, nil, nil, fmt.Errorf("%s %w", name, err)
+		return nil, nil, nil, nil, fmt.Errorf("%s %w", name, err)
 	}
 
 	opts, err := modelOptions(model, requestOpts)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, nil, nil, nil, err
+	}
+
+	var warnings []api.Warning
+	if mode := envconfig.QuantFallback(); mode != "" {
+		fallback, fallbackOpts, err := s.quantFallback(model, caps, requestOpts, opts)
+		switch {
+		case err != nil:
+			slog.WarnContext(ctx, "failed to look for a smaller quantization", "model", name, "error", err)
+		case fallback == nil:
+		case mode == "auto":
+			warnings = append(warnings, api.Warning{
+				Code:    api.WarningModelFallback,
+				Message: fmt.Sprintf("%s does not fit in available memory, using %s instead", model.ShortName, fallback.ShortName),
+			})
+			model, opts = fallback, *fallbackOpts
+		default:
+			warnings = append(warnings, api.Warning{
+				Code:    api.WarningModelFallback,
+				Message: fmt.Sprintf("%s may not fit in available memory, try %s instead", model.ShortName, fallback.ShortName),
+			})
+		}
 	}
 
 	runnerCh, errCh := s.sched.GetRunner(ctx, model, opts, keepAlive)
@@ -111,10 +194,11 @@ func (s *Server) scheduleRunner(ctx context.Context, name string, caps []Capabil
 	select {
 	case runner = <-runnerCh:
 	case err = <-errCh:
-		return nil, nil, nil, err
+		return nil, nil, nil, nil, err
 	}
 
-	return runner.llama, model, &opts, nil
+	warnings = append(warnings, optionWarnings(runner.llama.Runtime().Engine, requestOpts)...)
+	return runner.llama, model, &opts, warnings, nil
 }
 
 func (s *Server) GenerateHandler(c *gin.Context) {
@@ -128,11 +212,68 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		return
 	}
 
+	if req.TopLogprobs < 0 || req.TopLogprobs > maxTopLogprobs {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("top_logprobs must be between 0 and %d", maxTopLogprobs)})
+		return
+	}
+
+	if req.CacheKey != "" && !cacheKeyRegexp.MatchString(req.CacheKey) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": errInvalidCacheKey.Error()})
+		return
+	}
+
+	if resp := strictOptions(req.Strict, req.Options); resp != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, resp)
+		return
+	}
+
+	var cp *checkpoint
+	if req.Continuation != "" {
+		var err error
+		cp, err = loadCheckpoint(req.Continuation)
+		switch {
+		case errors.Is(err, ErrInvalidContinuation):
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, os.ErrNotExist):
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("continuation '%s' not found", req.Continuation)})
+			return
+		case err != nil:
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		// resume the original request
+		stream, keepAlive := req.Stream, req.KeepAlive
+		req = cp.Request
+		req.Stream, req.KeepAlive = stream, keepAlive
+	}
+
+	route := s.route(c.Request.Context(), req.Model, routeInput{prompt: req.Prompt, images: len(req.Images) > 0})
+	if route != nil {
+		req.Model = route.Model
+	}
+
+	attempt := fallbackAttemptFrom(c.Request.Context())
+	if attempt != nil {
+		route = attempt.route
+	}
+
+	canary := s.canaries.split(c.Request.Context(), req.Model)
+	if canary != nil {
+		if route == nil {
+			route = canary.route(req.Model)
+		} else {
+			route.Model = canary.model
+		}
+		req.Model = canary.model
+	}
+
 	name := model.ParseName(req.Model)
 	if !name.IsValid() {
 		// Ideally this is "invalid model name" but we're keeping with
 		// what the API currently returns until we can change it.
-		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+		c.JSON(http.StatusNotFound, api.ErrorResponse{Error: fmt.Sprintf("model '%s' not found", req.Model), Code: api.ErrorCodeModelNotFound})
 		return
 	}
 
@@ -140,7 +281,7 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 	// induce infinite recursion given the current code structure.
 	name, err := getExistingName(name)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+		c.JSON(http.StatusNotFound, api.ErrorResponse{Error: fmt.Sprintf("model '%s' not found", req.Model), Code: api.ErrorCodeModelNotFound})
 		return
 	}
 
@@ -148,7 +289,7 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 	if err != nil {
 		switch {
 		case errors.Is(err, fs.ErrNotExist):
-			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+			c.JSON(http.StatusNotFound, api.ErrorResponse{Error: fmt.Sprintf("model '%s' not found", req.Model), Code: api.ErrorCodeModelNotFound})
 		case err.Error() == errtypes.InvalidModelNameErrMsg:
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		default:
@@ -166,7 +307,7 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 			CreatedAt:  time.Now().UTC(),
 			Response:   "",
 			Done:       true,
-			DoneReason: "unload",
+			DoneReason: api.DoneReasonUnload,
 		})
 		return
 	}
@@ -181,15 +322,26 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		caps = append(caps, CapabilityInsert)
 	}
 
-	r, m, opts, err := s.scheduleRunner(c.Request.Context(), name.String(), caps, req.Options, req.KeepAlive)
+	if err := validateCompletionOptions(model, req.Options); err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	r, m, opts, warnings, err := s.scheduleRunner(c.Request.Context(), name.String(), caps, req.Options, req.KeepAlive)
 	if errors.Is(err, errCapabilityCompletion) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%q does not support generate", req.Model)})
 		return
 	} else if err != nil {
+		canary.record(api.Metrics{}, "", err)
 		handleScheduleError(c, req.Model, err)
 		return
 	}
 
+	if cp != nil && cp.Digest != m.Digest {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("model '%s' has changed since the generation was checkpointed", req.Model)})
+		return
+	}
+
 	checkpointLoaded := time.Now()
 
 	// load the model
@@ -198,7 +350,7 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 			Model:      req.Model,
 			CreatedAt:  time.Now().UTC(),
 			Done:       true,
-			DoneReason: "load",
+			DoneReason: api.DoneReasonLoad,
 		})
 		return
 	}
@@ -277,7 +429,7 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 
 		var b bytes.Buffer
 		if req.Context != nil {
-			slog.Warn("the context field is deprecated and will be removed in a future version of Ollama")
+			slog.WarnContext(c.Request.Context(), "the context field is deprecated and will be removed in a future version of Ollama")
 			s, err := r.Detokenize(c.Request.Context(), req.Context)
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -294,25 +446,64 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		prompt = b.String()
 	}
 
-	slog.Debug("generate request", "images", len(images), "prompt", prompt)
+	// continue from the last checkpoint
+	var checkpointed string
+	var checkpointedCount int
+	if cp != nil {
+		checkpointed, checkpointedCount = cp.Response, cp.EvalCount
+		prompt += checkpointed
+		if opts.NumPredict > 0 {
+			opts.NumPredict = max(opts.NumPredict-checkpointedCount, 1)
+		}
+	} else if req.Checkpoint {
+		cp, err = newCheckpoint(req, m.Digest)
+		if err == nil {
+			err = cp.save()
+		}
+
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	var tmpl string
+	if !req.Raw {
+		tmpl = cmp.Or(req.Template, m.Template.String())
+	}
+	progress := s.newProgressEstimator(m.Digest, tmpl, opts, checkpointedCount)
+	fp := fingerprint(m, tmpl, r)
+
+	slog.DebugContext(c.Request.Context(), "generate request", "images", len(images), "prompt", prompt)
 
 	ch := make(chan any)
 	go func() {
 		// TODO (jmorganca): avoid building the response twice both here and below
 		var sb strings.Builder
 		defer close(ch)
+		defer recoverStream(c, ch)
+		energy := s.energy.begin(s.sched.gpusFor(m))
+		defer energy.end()
+		var generated int
 		if err := r.Completion(c.Request.Context(), llm.CompletionRequest{
-			Prompt:  prompt,
-			Images:  images,
-			Format:  req.Format,
-			Options: opts,
+			Prompt:         prompt,
+			Images:         images,
+			Format:         req.Format,
+			Options:        opts,
+			EOSProbability: req.Progress,
+			Logprobs:       req.Logprobs || req.TopLogprobs > 0,
+			TopLogprobs:    req.TopLogprobs,
+			CacheKey:       req.CacheKey,
+			Throttle:       throttle(c.Request, opts),
 		}, func(cr llm.CompletionResponse) {
 			res := api.GenerateResponse{
-				Model:      req.Model,
-				CreatedAt:  time.Now().UTC(),
-				Response:   cr.Content,
-				Done:       cr.Done,
-				DoneReason: cr.DoneReason,
+				Model:       req.Model,
+				CreatedAt:   time.Now().UTC(),
+				Response:    cr.Content,
+				Done:        cr.Done,
+				DoneReason:  cr.DoneReason,
+				Logprobs:    cr.Logprobs,
+				Fingerprint: fp,
 				Metrics: api.Metrics{
 					PromptEvalCount:    cr.PromptEvalCount,
 					PromptEvalDuration: cr.PromptEvalDuration,
@@ -325,9 +516,33 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 				ch <- gin.H{"error": err.Error()}
 			}
 
+			if p := progress.update(cr); req.Progress {
+				res.Progress = p
+			}
+
+			if cp != nil {
+				res.Continuation = cp.Token
+				if cr.Content != "" {
+					generated++
+				}
+
+				switch {
+				case cr.Done:
+					if err := cp.remove(); err != nil {
+						slog.WarnContext(c.Request.Context(), "failed to remove checkpoint", "continuation", cp.Token, "error", err)
+					}
+				case cr.Content != "" && generated%checkpointInterval == 0:
+					cp.Response, cp.EvalCount = checkpointed+sb.String(), checkpointedCount+generated
+					if err := cp.save(); err != nil {
+						slog.WarnContext(c.Request.Context(), "failed to save checkpoint", "continuation", cp.Token, "error", err)
+					}
+				}
+			}
+
 			if cr.Done {
 				res.TotalDuration = time.Since(checkpointStart)
 				res.LoadDuration = checkpointLoaded.Sub(checkpointStart)
+				res.Energy, res.GPUTemperature = energy.end()
 
 				if !req.Raw {
 					tokens, err := r.Tokenize(c.Request.Context(), prompt+sb.String())
@@ -337,21 +552,41 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 					}
 					res.Context = tokens
 				}
+
+				if cr.PromptTruncated > 0 {
+					warnings = append(warnings, promptTruncatedWarning(cr.PromptTruncated, opts.NumCtx))
+				}
+
+				res.Warnings = warnings
+				res.StreamSummary = api.NewStreamSummary(res.DoneReason, res.Metrics)
+				res.StreamSummary.Warnings = warningMessages(warnings)
+				res.StreamSummary.Route = route
+
+				attempt.scoreResponse(c.Request.Context(), r, prompt, sb.String())
+				canary.record(res.Metrics, res.DoneReason, nil)
 			}
 
 			ch <- res
 		}); err != nil {
-			ch <- gin.H{"error": err.Error()}
+			canary.record(api.Metrics{}, "", err)
+			res := completionError(err)
+			res["summary"] = errorSummary(err, warnings, route)
+			if cp != nil {
+				res["continuation"] = cp.Token
+			}
+			ch <- res
 		}
 	}()
 
 	if req.Stream != nil && !*req.Stream {
 		var r api.GenerateResponse
 		var sb strings.Builder
+		var logprobs []api.Logprob
 		for rr := range ch {
 			switch t := rr.(type) {
 			case api.GenerateResponse:
 				sb.WriteString(t.Response)
+				logprobs = append(logprobs, t.Logprobs...)
 				r = t
 			case gin.H:
 				msg, ok := t["error"].(string)
@@ -359,7 +594,12 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 					msg = "unexpected error format in response"
 				}
 
-				c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+				res := gin.H{"error": msg}
+				if code, ok := t["code"].(string); ok {
+					res["code"] = code
+				}
+
+				c.JSON(http.StatusInternalServerError, res)
 				return
 			default:
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected response"})
@@ -368,6 +608,7 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		}
 
 		r.Response = sb.String()
+		r.Logprobs = logprobs
 		c.JSON(http.StatusOK, r)
 		return
 	}
@@ -388,12 +629,22 @@ func (s *Server) EmbedHandler(c *gin.Context) {
 		return
 	}
 
+	if resp := strictOptions(req.Strict, req.Options); resp != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, resp)
+		return
+	}
+
 	truncate := true
 
 	if req.Truncate != nil && !*req.Truncate {
 		truncate = false
 	}
 
+	if req.Dimensions < 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "dimensions must be positive"})
+		return
+	}
+
 	var input []string
 
 	switch i := req.Input.(type) {
@@ -418,11 +669,11 @@ func (s *Server) EmbedHandler(c *gin.Context) {
 
 	name, err := getExistingName(model.ParseName(req.Model))
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+		c.JSON(http.StatusNotFound, api.ErrorResponse{Error: fmt.Sprintf("model '%s' not found", req.Model), Code: api.ErrorCodeModelNotFound})
 		return
 	}
 
-	r, m, opts, err := s.scheduleRunner(c.Request.Context(), name.String(), []Capability{}, req.Options, req.KeepAlive)
+	r, m, opts, warnings, err := s.scheduleRunner(c.Request.Context(), name.String(), []Capability{}, req.Options, req.KeepAlive)
 	if err != nil {
 		handleScheduleError(c, req.Model, err)
 		return
@@ -431,7 +682,7 @@ func (s *Server) EmbedHandler(c *gin.Context) {
 	checkpointLoaded := time.Now()
 
 	if len(input) == 0 {
-		c.JSON(http.StatusOK, api.EmbedResponse{Model: req.Model, Embeddings: [][]float32{}})
+		c.JSON(http.StatusOK, api.EmbedResponse{Model: req.Model, Embeddings: [][]float32{}, Warnings: warnings})
 		return
 	}
 
@@ -441,6 +692,23 @@ func (s *Server) EmbedHandler(c *gin.Context) {
 		return
 	}
 
+	if err := validateDimensions(kvData, req.Dimensions); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s %s", req.Model, err)})
+		return
+	}
+
+	ctxLen := min(opts.NumCtx, int(kvData.ContextLength()))
+	if req.Chunking != nil {
+		if err := validateChunking(*req.Chunking, ctxLen); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	// chunks are the texts embedded for each input, which are the inputs
+	// themselves unless chunking was requested
+	chunks := make([][]api.EmbedChunk, len(input))
+
 	var count int
 	for i, s := range input {
 		tokens, err := r.Tokenize(c.Request.Context(), s)
@@ -449,54 +717,191 @@ func (s *Server) EmbedHandler(c *gin.Context) {
 			return
 		}
 
-		ctxLen := min(opts.NumCtx, int(kvData.ContextLength()))
-		if len(tokens) > ctxLen {
-			if !truncate {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "input length exceeds maximum context length"})
-				return
-			}
-
-			tokens = tokens[:ctxLen]
-			s, err = r.Detokenize(c.Request.Context(), tokens)
+		if req.Chunking != nil {
+			chunks[i], err = chunkTokens(c.Request.Context(), r, s, tokens, cmp.Or(req.Chunking.Size, ctxLen), req.Chunking.Overlap)
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 				return
 			}
-		}
+		} else {
+			if len(tokens) > ctxLen {
+				if !truncate {
+					c.JSON(http.StatusBadRequest, api.ErrorResponse{Error: "input length exceeds maximum context length", Code: api.ErrorCodeContextExceeded})
+					return
+				}
 
-		count += len(tokens)
+				warnings = append(warnings, api.Warning{
+					Code:    api.WarningPromptTruncated,
+					Message: fmt.Sprintf("%d tokens of input %d didn't fit in the context window of %d tokens and were left out", len(tokens)-ctxLen, i, ctxLen),
+				})
 
-		input[i] = s
+				tokens = tokens[:ctxLen]
+				s, err = r.Detokenize(c.Request.Context(), tokens)
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+			}
+
+			chunks[i] = []api.EmbedChunk{{Text: s, Tokens: len(tokens)}}
+		}
+
+		for _, chunk := range chunks[i] {
+			count += chunk.Tokens
+		}
 	}
 
-	var g errgroup.Group
-	embeddings := make([][]float32, len(input))
-	for i, text := range input {
-		g.Go(func() error {
-			embedding, err := r.Embedding(c.Request.Context(), text)
-			if err != nil {
-				return err
-			}
-			embeddings[i] = normalize(embedding)
-			return nil
-		})
+	norm := req.Normalize == nil || *req.Normalize
+
+	var texts []string
+	for i := range chunks {
+		for _, chunk := range chunks[i] {
+			texts = append(texts, chunk.Text)
+		}
 	}
 
-	if err := g.Wait(); err != nil {
+	results, err := r.Embeddings(c.Request.Context(), texts)
+	if err != nil {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": strings.TrimSpace(err.Error())})
 		return
 	}
 
+	for i := range chunks {
+		for j := range chunks[i] {
+			result := results[0]
+			results = results[1:]
+			if result.Error != "" {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": strings.TrimSpace(result.Error)})
+				return
+			}
+
+			chunks[i][j].Embedding = reduceEmbedding(result.Embedding, req.Dimensions, norm)
+		}
+	}
+
+	embeddings := make([][]float32, len(chunks))
+	for i := range chunks {
+		embeddings[i] = meanEmbedding(chunks[i], norm)
+	}
+
 	resp := api.EmbedResponse{
 		Model:           req.Model,
 		Embeddings:      embeddings,
 		TotalDuration:   time.Since(checkpointStart),
 		LoadDuration:    checkpointLoaded.Sub(checkpointStart),
 		PromptEvalCount: count,
+		Fingerprint:     fingerprint(m, "", r),
+		Warnings:        warnings,
+	}
+
+	if req.Chunking != nil && req.Chunking.Pooling == "none" {
+		resp.Chunks = chunks
 	}
+
 	c.JSON(http.StatusOK, resp)
 }
 
+// reduceEmbedding truncates embedding to dims dimensions, if set, and
+// normalizes it if norm is set.
+func reduceEmbedding(embedding []float32, dims int, norm bool) []float32 {
+	if dims > 0 && dims < len(embedding) {
+		embedding = embedding[:dims]
+	}
+
+	if norm {
+		embedding = normalize(embedding)
+	}
+
+	return embedding
+}
+
+// validateDimensions checks that the embeddings of a model with metadata kv
+// can be truncated to dims dimensions.
+func validateDimensions(kv ggml.KV, dims int) error {
+	if dims == 0 || uint64(dims) == kv.EmbeddingLength() {
+		return nil
+	}
+
+	supported := kv.EmbeddingDimensions()
+	if len(supported) == 0 {
+		return errors.New("does not support reducing the dimensions of its embeddings")
+	}
+
+	if !slices.Contains(supported, uint64(dims)) {
+		return fmt.Errorf("does not support %d dimensions, supported dimensions are %v", dims, supported)
+	}
+
+	return nil
+}
+
+// validateChunking checks the chunking options of an embedding request for a
+// context of ctxLen tokens.
+func validateChunking(chunking api.EmbedChunking, ctxLen int) error {
+	size := cmp.Or(chunking.Size, ctxLen)
+	switch {
+	case chunking.Size < 0 || chunking.Overlap < 0:
+		return errors.New("chunk size and overlap must not be negative")
+	case size > ctxLen:
+		return fmt.Errorf("chunk size must not exceed the context length of %d", ctxLen)
+	case chunking.Overlap >= size:
+		return errors.New("chunk overlap must be less than the chunk size")
+	case !slices.Contains([]string{"", "mean", "none"}, chunking.Pooling):
+		return fmt.Errorf("unknown pooling %q", chunking.Pooling)
+	}
+
+	return nil
+}
+
+// chunkTokens splits the text s with tokens into chunks of size tokens,
+// consecutive chunks sharing overlap tokens.
+func chunkTokens(ctx context.Context, r llm.LlamaServer, s string, tokens []int, size, overlap int) ([]api.EmbedChunk, error) {
+	if len(tokens) <= size {
+		return []api.EmbedChunk{{Text: s, Tokens: len(tokens)}}, nil
+	}
+
+	var chunks []api.EmbedChunk
+	for start := 0; ; start += size - overlap {
+		end := min(start+size, len(tokens))
+		text, err := r.Detokenize(ctx, tokens[start:end])
+		if err != nil {
+			return nil, err
+		}
+
+		chunks = append(chunks, api.EmbedChunk{Text: text, Tokens: end - start})
+		if end == len(tokens) {
+			return chunks, nil
+		}
+	}
+}
+
+// meanEmbedding returns the average of the embeddings of chunks weighted by
+// their number of tokens, normalized if norm is set.
+func meanEmbedding(chunks []api.EmbedChunk, norm bool) []float32 {
+	if len(chunks) == 1 {
+		return chunks[0].Embedding
+	}
+
+	var total int
+	mean := make([]float32, len(chunks[0].Embedding))
+	for _, chunk := range chunks {
+		for i, v := range chunk.Embedding {
+			mean[i] += v * float32(chunk.Tokens)
+		}
+
+		total += chunk.Tokens
+	}
+
+	if norm {
+		return normalize(mean)
+	}
+
+	for i := range mean {
+		mean[i] /= float32(max(total, 1))
+	}
+
+	return mean
+}
+
 func normalize(vec []float32) []float32 {
 	var sum float32
 	for _, v := range vec {
@@ -530,7 +935,7 @@ func (s *Server) EmbeddingsHandler(c *gin.Context) {
 		return
 	}
 
-	r, _, _, err := s.scheduleRunner(c.Request.Context(), name.String(), []Capability{}, req.Options, req.KeepAlive)
+	r, m, _, _, err := s.scheduleRunner(c.Request.Context(), name.String(), []Capability{}, req.Options, req.KeepAlive)
 	if err != nil {
 		handleScheduleError(c, req.Model, err)
 		return
@@ -548,19 +953,143 @@ func (s *Server) EmbeddingsHandler(c *gin.Context) {
 		return
 	}
 
-	var e []float64
-	for _, v := range embedding {
-		e = append(e, float64(v))
-	}
-
-	resp := api.EmbeddingResponse{
-		Embedding: e,
-	}
-	c.JSON(http.StatusOK, resp)
+	var e []float64
+	for _, v := range embedding {
+		e = append(e, float64(v))
+	}
+
+	resp := api.EmbeddingResponse{
+		Embedding:   e,
+		Fingerprint: fingerprint(m, "", r),
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) PullHandler(c *gin.Context) {
+	var req api.PullRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := model.ParseName(cmp.Or(req.Model, req.Name))
+	if !name.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": errtypes.InvalidModelNameErrMsg})
+		return
+	}
+
+	name, err = getExistingName(name)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ch := make(chan any)
+	go func() {
+		defer close(ch)
+		defer recoverStream(c, ch)
+		fn := func(r api.ProgressResponse) {
+			ch <- r
+		}
+
+		regOpts := &registryOptions{
+			Insecure: req.Insecure,
+		}
+
+		ctx, cancel := context.WithCancel(c.Request.Context())
+		defer cancel()
+
+		if err := PullModel(ctx, name.DisplayShortest(), regOpts, fn); err != nil {
+			ch <- gin.H{"error": err.Error()}
+		}
+	}()
+
+	if req.Stream != nil && !*req.Stream {
+		waitForStream(c, ch)
+		return
+	}
+
+	streamResponse(c, ch)
+}
+
+func (s *Server) WarmHandler(c *gin.Context) {
+	var req api.WarmRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := model.ParseName(req.Model)
+	if !name.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": errtypes.InvalidModelNameErrMsg})
+		return
+	}
+
+	name, err = getExistingName(name)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	m, err := GetModel(name.String())
+	if err != nil {
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			c.JSON(http.StatusNotFound, api.ErrorResponse{Error: fmt.Sprintf("model '%s' not found", req.Model), Code: api.ErrorCodeModelNotFound})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	duration := envconfig.KeepAlive()
+	if req.KeepAlive != nil {
+		duration = req.KeepAlive.Duration
+	}
+
+	// locking for no time unpins the files of the model instead
+	if req.Lock && duration == 0 {
+		s.sched.warm.forget(m.ModelPath)
+		c.JSON(http.StatusOK, api.ProgressResponse{Status: "success"})
+		return
+	}
+
+	ch := make(chan any)
+	go func() {
+		defer close(ch)
+		defer recoverStream(c, ch)
+		fn := func(r api.ProgressResponse) {
+			ch <- r
+		}
+
+		if err := s.sched.warm.warm(c.Request.Context(), m, req.Lock, duration, fn); err != nil {
+			ch <- gin.H{"error": err.Error()}
+			return
+		}
+
+		ch <- api.ProgressResponse{Status: "success"}
+	}()
+
+	if req.Stream != nil && !*req.Stream {
+		waitForStream(c, ch)
+		return
+	}
+
+	streamResponse(c, ch)
 }
 
-func (s *Server) PullHandler(c *gin.Context) {
-	var req api.PullRequest
+func (s *Server) VerifyHandler(c *gin.Context) {
+	var req api.VerifyRequest
 	err := c.ShouldBindJSON(&req)
 	switch {
 	case errors.Is(err, io.EOF):
@@ -571,7 +1100,7 @@ func (s *Server) PullHandler(c *gin.Context) {
 		return
 	}
 
-	name := model.ParseName(cmp.Or(req.Model, req.Name))
+	name := model.ParseName(req.Model)
 	if !name.IsValid() {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": errtypes.InvalidModelNameErrMsg})
 		return
@@ -583,23 +1112,49 @@ func (s *Server) PullHandler(c *gin.Context) {
 		return
 	}
 
+	if err := fetchModel(c.Request.Context(), name); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	manifest, err := ParseNamedManifest(name)
+	if err != nil {
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			c.JSON(http.StatusNotFound, api.ErrorResponse{Error: fmt.Sprintf("model '%s' not found", req.Model), Code: api.ErrorCodeModelNotFound})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
 	ch := make(chan any)
 	go func() {
 		defer close(ch)
+		defer recoverStream(c, ch)
 		fn := func(r api.ProgressResponse) {
 			ch <- r
 		}
 
-		regOpts := &registryOptions{
-			Insecure: req.Insecure,
+		if err := verifyManifest(c.Request.Context(), manifest, fn); err != nil {
+			ch <- gin.H{"error": err.Error()}
+			return
 		}
 
-		ctx, cancel := context.WithCancel(c.Request.Context())
-		defer cancel()
+		if req.Golden {
+			m, err := GetModel(name.String())
+			if err != nil {
+				ch <- gin.H{"error": err.Error()}
+				return
+			}
 
-		if err := PullModel(ctx, name.DisplayShortest(), regOpts, fn); err != nil {
-			ch <- gin.H{"error": err.Error()}
+			if err := s.verifyGolden(c.Request.Context(), name.String(), m, fn); err != nil {
+				ch <- gin.H{"error": err.Error()}
+				return
+			}
 		}
+
+		ch <- api.ProgressResponse{Status: "success"}
 	}()
 
 	if req.Stream != nil && !*req.Stream {
@@ -635,6 +1190,7 @@ func (s *Server) PushHandler(c *gin.Context) {
 	ch := make(chan any)
 	go func() {
 		defer close(ch)
+		defer recoverStream(c, ch)
 		fn := func(r api.ProgressResponse) {
 			ch <- r
 		}
@@ -711,7 +1267,7 @@ func (s *Server) DeleteHandler(c *gin.Context) {
 
 	n, err := getExistingName(n)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", cmp.Or(r.Model, r.Name))})
+		c.JSON(http.StatusNotFound, api.ErrorResponse{Error: fmt.Sprintf("model '%s' not found", cmp.Or(r.Model, r.Name)), Code: api.ErrorCodeModelNotFound})
 		return
 	}
 
@@ -719,13 +1275,18 @@ func (s *Server) DeleteHandler(c *gin.Context) {
 	if err != nil {
 		switch {
 		case os.IsNotExist(err):
-			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", cmp.Or(r.Model, r.Name))})
+			c.JSON(http.StatusNotFound, api.ErrorResponse{Error: fmt.Sprintf("model '%s' not found", cmp.Or(r.Model, r.Name)), Code: api.ErrorCodeModelNotFound})
 		default:
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		}
 		return
 	}
 
+	if err := removeStoredModel(c.Request.Context(), n); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	if err := m.Remove(); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -735,6 +1296,21 @@ func (s *Server) DeleteHandler(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+
+	// models that shared their files with the deleted one are still warm
+	if s.sched != nil {
+		for _, layer := range m.Layers {
+			if layer.MediaType != "application/vnd.ollama.image.model" {
+				continue
+			}
+
+			if p, err := GetBlobsPath(layer.Digest); err == nil {
+				if _, err := os.Stat(p); errors.Is(err, os.ErrNotExist) {
+					s.sched.warm.forget(p)
+				}
+			}
+		}
+	}
 }
 
 func (s *Server) ShowHandler(c *gin.Context) {
@@ -762,7 +1338,7 @@ func (s *Server) ShowHandler(c *gin.Context) {
 	if err != nil {
 		switch {
 		case os.IsNotExist(err):
-			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+			c.JSON(http.StatusNotFound, api.ErrorResponse{Error: fmt.Sprintf("model '%s' not found", req.Model), Code: api.ErrorCodeModelNotFound})
 		case err.Error() == errtypes.InvalidModelNameErrMsg:
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		default:
@@ -784,6 +1360,10 @@ func GetModelInfo(req api.ShowRequest) (*api.ShowResponse, error) {
 		return nil, err
 	}
 
+	if err := fetchModel(context.TODO(), name); err != nil {
+		return nil, err
+	}
+
 	m, err := GetModel(name.String())
 	if err != nil {
 		return nil, err
@@ -897,54 +1477,30 @@ func getModelData(digest string, verbose bool) (ggml.KV, ggml.Tensors, error) {
 	return kv, data.Tensors(), nil
 }
 
+func (s *Server) VersionHandler(c *gin.Context) {
+	resp := api.VersionResponse{Version: version.Version}
+	for _, result := range llm.SelfTestResults() {
+		resp.SelfTest = append(resp.SelfTest, api.SelfTestResult(result))
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
 func (s *Server) ListHandler(c *gin.Context) {
-	ms, err := Manifests(true)
+	req, err := parseListRequest(c.Request.URL.Query())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	models := []api.ListModelResponse{}
-	for n, m := range ms {
-		var cf ConfigV2
-
-		if m.Config.Digest != "" {
-			f, err := m.Config.Open()
-			if err != nil {
-				slog.Warn("bad manifest filepath", "name", n, "error", err)
-				continue
-			}
-			defer f.Close()
-
-			if err := json.NewDecoder(f).Decode(&cf); err != nil {
-				slog.Warn("bad manifest config", "name", n, "error", err)
-				continue
-			}
-		}
-
-		// tag should never be masked
-		models = append(models, api.ListModelResponse{
-			Model:      n.DisplayShortest(),
-			Name:       n.DisplayShortest(),
-			Size:       m.Size(),
-			Digest:     m.digest,
-			ModifiedAt: m.fi.ModTime(),
-			Details: api.ModelDetails{
-				Format:            cf.ModelFormat,
-				Family:            cf.ModelFamily,
-				Families:          cf.ModelFamilies,
-				ParameterSize:     cf.ModelType,
-				QuantizationLevel: cf.FileType,
-			},
-		})
+	models, err := localModels()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 
-	slices.SortStableFunc(models, func(i, j api.ListModelResponse) int {
-		// most recently modified first
-		return cmp.Compare(j.ModifiedAt.Unix(), i.ModifiedAt.Unix())
-	})
-
-	c.JSON(http.StatusOK, api.ListResponse{Models: models})
+	models, total := listModels(models, req)
+	c.JSON(http.StatusOK, api.ListResponse{Models: models, Total: total})
 }
 
 func (s *Server) CopyHandler(c *gin.Context) {
@@ -980,9 +1536,11 @@ func (s *Server) CopyHandler(c *gin.Context) {
 	}
 
 	if err := CopyModel(src, dst); errors.Is(err, os.ErrNotExist) {
-		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model %q not found", r.Source)})
+		c.JSON(http.StatusNotFound, api.ErrorResponse{Error: fmt.Sprintf("model %q not found", r.Source), Code: api.ErrorCodeModelNotFound})
 	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	} else if err := storeModel(c.Request.Context(), dst, func(api.ProgressResponse) {}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 	}
 }
 
@@ -1108,6 +1666,13 @@ func allowedHostsMiddleware(addr net.Addr) gin.HandlerFunc {
 			return
 		}
 
+		// the server may listen on several addresses so prefer the one
+		// that accepted this connection
+		addr := addr
+		if local, ok := c.Request.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+			addr = local
+		}
+
 		if addr, err := netip.ParseAddrPort(addr.String()); err == nil && !addr.Addr().IsLoopback() {
 			c.Next()
 			return
@@ -1140,73 +1705,102 @@ func allowedHostsMiddleware(addr net.Addr) gin.HandlerFunc {
 }
 
 func (s *Server) GenerateRoutes(rc *ollama.Registry) (http.Handler, error) {
-	corsConfig := cors.DefaultConfig()
-	corsConfig.AllowWildcard = true
-	corsConfig.AllowBrowserExtensions = true
-	corsConfig.AllowHeaders = []string{
-		"Authorization",
-		"Content-Type",
-		"User-Agent",
-		"Accept",
-		"X-Requested-With",
-
-		// OpenAI compatibility headers
-		"x-stainless-lang",
-		"x-stainless-package-version",
-		"x-stainless-os",
-		"x-stainless-arch",
-		"x-stainless-retry-count",
-		"x-stainless-runtime",
-		"x-stainless-runtime-version",
-		"x-stainless-async",
-		"x-stainless-helper-method",
-		"x-stainless-poll-helper",
-		"x-stainless-custom-poll-interval",
-		"x-stainless-timeout",
-	}
-	corsConfig.AllowOrigins = envconfig.AllowedOrigins()
-
-	r := gin.Default()
+	corsHandler, err := corsMiddleware()
+	if err != nil {
+		return nil, err
+	}
+
+	ipFilter, err := ipFilterMiddleware()
+	if err != nil {
+		return nil, err
+	}
+
+	r := gin.New()
 	r.Use(
-		cors.New(corsConfig),
+		gin.LoggerWithFormatter(logFormatter),
+		gin.Recovery(),
+		recoveryMiddleware(),
+		requestIDMiddleware(),
+		ipFilter,
+		corsHandler,
 		allowedHostsMiddleware(s.addr),
+		compressionMiddleware(),
+		eventStreamMiddleware(),
+		requestIDResponseMiddleware(),
+		timeoutMiddleware(envconfig.ReadTimeout(), envconfig.WriteTimeout()),
+		s.ready.track(),
 	)
 
 	// General
 	r.HEAD("/", func(c *gin.Context) { c.String(http.StatusOK, "Ollama is running") })
 	r.GET("/", func(c *gin.Context) { c.String(http.StatusOK, "Ollama is running") })
 	r.HEAD("/api/version", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"version": version.Version}) })
-	r.GET("/api/version", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"version": version.Version}) })
+	r.GET("/api/version", s.VersionHandler)
+	r.HEAD("/readyz", s.ReadyHandler)
+	r.GET("/readyz", s.ReadyHandler)
+	r.GET("/drainz", s.DrainHandler)
+	r.POST("/drainz", s.DrainHandler)
+	r.GET("/api/loglevel", s.LogLevelsHandler)
+	r.POST("/api/loglevel", s.SetLogLevelsHandler)
 
 	// Local model cache management (new implementation is at end of function)
-	r.POST("/api/pull", s.PullHandler)
+	r.POST("/api/pull", writableModelsMiddleware(), s.PullHandler)
+	r.POST("/api/pull/preview", s.PullPreviewHandler)
 	r.POST("/api/push", s.PushHandler)
+	r.POST("/api/warm", s.WarmHandler)
+	r.POST("/api/verify", s.VerifyHandler)
+	r.POST("/api/eval", s.EvalHandler)
+	r.POST("/api/perplexity", s.PerplexityHandler)
+	r.POST("/api/classify", s.ClassifyHandler)
+	r.POST("/api/finetune", writableModelsMiddleware(), s.FinetuneHandler)
+	r.POST("/api/similarity", s.SimilarityHandler)
+	r.POST("/api/cluster", s.ClusterHandler)
 	r.HEAD("/api/tags", s.ListHandler)
 	r.GET("/api/tags", s.ListHandler)
+	r.POST("/api/models/search", s.SearchModelsHandler)
+	r.POST("/api/search", s.SearchHandler)
+	r.GET("/api/updates", s.UpdatesHandler)
+	r.POST("/api/updates", writableModelsMiddleware(), s.UpdatePolicyHandler)
+	r.GET("/api/canaries", s.CanariesHandler)
+	r.POST("/api/canaries", writableModelsMiddleware(), s.StartCanaryHandler)
+	r.POST("/api/canaries/promote", writableModelsMiddleware(), s.PromoteCanaryHandler)
+	r.POST("/api/canaries/rollback", writableModelsMiddleware(), s.RollbackCanaryHandler)
 	r.POST("/api/show", s.ShowHandler)
-	r.DELETE("/api/delete", s.DeleteHandler)
+	r.DELETE("/api/delete", writableModelsMiddleware(), s.DeleteHandler)
 
 	// Create
-	r.POST("/api/create", s.CreateHandler)
-	r.POST("/api/blobs/:digest", s.CreateBlobHandler)
+	r.POST("/api/create", writableModelsMiddleware(), s.CreateHandler)
+	r.POST("/api/blobs/:digest", writableModelsMiddleware(), s.CreateBlobHandler)
 	r.HEAD("/api/blobs/:digest", s.HeadBlobHandler)
-	r.POST("/api/copy", s.CopyHandler)
+	r.POST("/api/copy", writableModelsMiddleware(), s.CopyHandler)
 
 	// Inference
 	r.GET("/api/ps", s.PsHandler)
-	r.POST("/api/generate", s.GenerateHandler)
-	r.POST("/api/chat", s.ChatHandler)
+	r.GET("/metrics", s.MetricsHandler)
+	r.POST("/api/generate", s.cancelableMiddleware(), s.fallbackMiddleware(s.GenerateHandler), s.GenerateHandler)
+	r.POST("/api/chat", s.cancelableMiddleware(), s.fallbackMiddleware(s.ChatHandler), s.ChatHandler)
+	r.POST("/api/cancel", s.CancelHandler)
+	r.POST("/api/sessions", s.CreateSessionHandler)
+	r.GET("/api/sessions", s.SessionsHandler)
+	r.GET("/api/sessions/:id", s.SessionHandler)
+	r.DELETE("/api/sessions/:id", s.DeleteSessionHandler)
+	r.GET("/api/cache", s.CacheHandler)
+	r.POST("/api/cache/pin", s.PinCacheHandler)
+	r.POST("/api/cache/unpin", s.UnpinCacheHandler)
+	r.DELETE("/api/cache", s.EvictCacheHandler)
 	r.POST("/api/embed", s.EmbedHandler)
+	r.POST("/api/embed/batch", s.EmbedBatchHandler)
 	r.POST("/api/embeddings", s.EmbeddingsHandler)
 
 	// Inference (OpenAI compatibility)
-	r.POST("/v1/chat/completions", openai.ChatMiddleware(), s.ChatHandler)
-	r.POST("/v1/completions", openai.CompletionsMiddleware(), s.GenerateHandler)
+	r.POST("/v1/chat/completions", s.cancelableMiddleware(), openai.ChatMiddleware(), s.fallbackMiddleware(s.ChatHandler), s.ChatHandler)
+	r.POST("/v1/completions", s.cancelableMiddleware(), openai.CompletionsMiddleware(), s.fallbackMiddleware(s.GenerateHandler), s.GenerateHandler)
+	r.POST("/v1/responses", s.cancelableMiddleware(), openai.ResponsesMiddleware(), s.fallbackMiddleware(s.ChatHandler), s.ChatHandler)
 	r.POST("/v1/embeddings", openai.EmbeddingsMiddleware(), s.EmbedHandler)
 	r.GET("/v1/models", openai.ListMiddleware(), s.ListHandler)
 	r.GET("/v1/models/:model", openai.RetrieveMiddleware(), s.ShowHandler)
 
-	if rc != nil {
+	if rc != nil && !envconfig.ModelsReadOnly() {
 		// wrap old with new
 		rs := &registry.Local{
 			Client:   rc,
@@ -1215,63 +1809,162 @@ func (s *Server) GenerateRoutes(rc *ollama.Registry) (http.Handler, error) {
 
 			Prune: PruneLayers,
 		}
-		return rs, nil
+		return withBasePath(envconfig.BasePath(), rs), nil
 	}
 
-	return r, nil
+	return withBasePath(envconfig.BasePath(), r), nil
 }
 
-func Serve(ln net.Listener) error {
-	level := slog.LevelInfo
-	if envconfig.Debug() {
-		level = slog.LevelDebug
+// withBasePath serves h under prefix so the server can sit behind a reverse
+// proxy that forwards a sub-path without rewriting it. Requests outside the
+// prefix are not found.
+func withBasePath(prefix string, h http.Handler) http.Handler {
+	if prefix == "" {
+		return h
 	}
 
-	slog.Info("server config", "env", envconfig.Values())
-	handler := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
-		Level:     level,
-		AddSource: true,
-		ReplaceAttr: func(_ []string, attr slog.Attr) slog.Attr {
-			if attr.Key == slog.SourceKey {
-				source := attr.Value.Any().(*slog.Source)
-				source.File = filepath.Base(source.File)
-			}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := strings.CutPrefix(r.URL.Path, prefix)
+		if !ok || (p != "" && p[0] != '/') {
+			http.NotFound(w, r)
+			return
+		}
+
+		if p == "" {
+			p = "/"
+		}
 
-			return attr
-		},
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = p
+		r2.URL.RawPath = ""
+		h.ServeHTTP(w, r2)
 	})
+}
 
-	slog.SetDefault(slog.New(handler))
+// writeAddrs records the bound listener addresses, one per line, in the
+// file named by OLLAMA_ADDR_FILE so callers binding port 0 can find them
+func writeAddrs(lns []net.Listener) error {
+	name := envconfig.AddrFile()
+	if name == "" {
+		return nil
+	}
 
-	blobsDir, err := GetBlobsPath("")
-	if err != nil {
+	var b strings.Builder
+	for _, ln := range lns {
+		switch addr := ln.Addr().(type) {
+		case *net.UnixAddr:
+			fmt.Fprintf(&b, "unix://%s\n", addr.Name)
+		case npipe.Addr:
+			fmt.Fprintf(&b, "npipe://%s\n", addr)
+		default:
+			scheme := "http"
+			if _, ok := ln.(*tlsListener); ok {
+				scheme = "https"
+			}
+
+			fmt.Fprintf(&b, "%s://%s%s\n", scheme, addr.String(), envconfig.BasePath())
+		}
+	}
+
+	if name == "-" {
+		_, err := io.WriteString(os.Stdout, b.String())
 		return err
 	}
-	if err := fixBlobs(blobsDir); err != nil {
+
+	return os.WriteFile(name, []byte(b.String()), 0o644)
+}
+
+// serverProtocols enables HTTP/2 alongside HTTP/1.1 so clients and proxies can
+// multiplex many concurrent streams over a single connection. HTTP/2 is
+// negotiated over TLS and accepted in cleartext (h2c) with prior knowledge.
+func serverProtocols() *http.Protocols {
+	var p http.Protocols
+	p.SetHTTP1(true)
+	p.SetHTTP2(true)
+	p.SetUnencryptedHTTP2(true)
+	return &p
+}
+
+func Serve(lns ...net.Listener) error {
+	if len(lns) == 0 {
+		return errors.New("no listeners")
+	}
+
+	level := slog.LevelInfo
+	if envconfig.Debug() {
+		level = slog.LevelDebug
+	}
+
+	if err := logutil.Setup(os.Stderr, level, ""); err != nil {
 		return err
 	}
 
-	if !envconfig.NoPrune() {
-		if _, err := Manifests(false); err != nil {
-			slog.Warn("corrupt manifests detected, skipping prune operation.  Re-pull or delete to clear", "error", err)
-		} else {
-			// clean up unused layers and manifests
-			if err := PruneLayers(); err != nil {
-				return err
-			}
+	slog.Info("server config", "env", envconfig.Values())
 
-			manifestsPath, err := GetManifestPath()
-			if err != nil {
-				return err
-			}
+	if err := checkRemote(lns); err != nil {
+		return err
+	}
+
+	if envconfig.ModelsReadOnly() {
+		if envconfig.BlobStore() != "" {
+			return errors.New("OLLAMA_BLOB_STORE cannot be used with OLLAMA_MODELS_READONLY")
+		}
+
+		if err := checkReadOnlyModels(); err != nil {
+			return fmt.Errorf("read-only models: %w", err)
+		}
+
+		slog.Info("serving read-only models", "models", envconfig.Models(), "scratch", envconfig.Scratch())
+	} else {
+		blobsDir, err := GetBlobsPath("")
+		if err != nil {
+			return err
+		}
+		if err := fixBlobs(blobsDir); err != nil {
+			return err
+		}
+
+		if !envconfig.NoPrune() {
+			if _, err := Manifests(false); err != nil {
+				slog.Warn("corrupt manifests detected, skipping prune operation.  Re-pull or delete to clear", "error", err)
+			} else {
+				// clean up unused layers and manifests
+				if err := PruneLayers(); err != nil {
+					return err
+				}
+
+				manifestsPath, err := GetManifestPath()
+				if err != nil {
+					return err
+				}
 
-			if err := PruneDirectory(manifestsPath); err != nil {
-				return err
+				if err := collectGarbage(func() error {
+					return PruneDirectory(manifestsPath)
+				}); err != nil {
+					return err
+				}
 			}
 		}
+
+		if err := syncManifests(context.Background()); err != nil {
+			return fmt.Errorf("blob store: %w", err)
+		}
+	}
+
+	var prefetch []model.Name
+	if path := envconfig.PrefetchFile(); path != "" {
+		var err error
+		if prefetch, err = parsePrefetchFile(path); err != nil {
+			return fmt.Errorf("prefetch: %w", err)
+		}
 	}
 
-	s := &Server{addr: ln.Addr()}
+	routers, fallbacks, err := loadRouters()
+	if err != nil {
+		return fmt.Errorf("routers: %w", err)
+	}
+
+	s := &Server{addr: lns[0].Addr(), energy: newEnergyMonitor(discover.GetGPUTelemetry), lengths: newOutputLengths(), routers: routers, fallbacks: fallbacks}
 
 	var rc *ollama.Registry
 	if useClient2 {
@@ -1289,46 +1982,85 @@ func Serve(ln net.Listener) error {
 
 	http.Handle("/", h)
 
+	if err := writeAddrs(lns); err != nil {
+		return err
+	}
+
 	ctx, done := context.WithCancel(context.Background())
 	schedCtx, schedDone := context.WithCancel(ctx)
 	sched := InitScheduler(schedCtx)
 	s.sched = sched
 
-	slog.Info(fmt.Sprintf("Listening on %s (version %s)", ln.Addr(), version.Version))
-	srvr := &http.Server{
-		// Use http.DefaultServeMux so we get net/http/pprof for
-		// free.
-		//
-		// TODO(bmizerany): Decide if we want to make this
-		// configurable so it is not exposed by default, or allow
-		// users to bind it to a different port. This was a quick
-		// and easy way to get pprof, but it may not be the best
-		// way.
-		Handler: nil,
+	for _, ln := range lns {
+		slog.Info(fmt.Sprintf("Listening on %s%s (version %s)", ln.Addr(), envconfig.BasePath(), version.Version))
 	}
 
+	srvr := newHTTPServer()
+
 	// listen for a ctrl+c and stop any loaded llm
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		<-signals
+		if timeout := envconfig.DrainTimeout(); timeout > 0 {
+			// a second signal stops the server without waiting
+			drainCtx, cancel := context.WithCancel(context.Background())
+			go func() {
+				select {
+				case <-signals:
+					cancel()
+				case <-drainCtx.Done():
+				}
+			}()
+
+			s.ready.drain(drainCtx, timeout)
+			cancel()
+		}
+
 		srvr.Close()
 		schedDone()
+		if path := envconfig.HibernateFile(); path != "" {
+			if err := sched.hibernate(path); err != nil {
+				slog.Warn("unable to hibernate loaded models", "path", path, "error", err)
+			}
+		}
 		sched.unloadAllRunners()
 		done()
 	}()
 
 	s.sched.Run(schedCtx)
 
+	if path := envconfig.HibernateFile(); path != "" {
+		s.restore(schedCtx, path)
+	}
+
+	if len(prefetch) > 0 {
+		s.ready.prefetch(ctx, prefetch)
+	}
+
+	if interval := envconfig.UpdateInterval(); interval > 0 && envconfig.ModelsReadOnly() {
+		slog.Warn("OLLAMA_UPDATE_INTERVAL is ignored since the models directory is read-only")
+	} else if interval > 0 {
+		go s.updates.run(ctx, interval)
+	}
+
 	// At startup we retrieve GPU information so we can get log messages before loading a model
 	// This will log warnings to the log in case we have problems with detected GPUs
 	gpus := discover.GetGPUInfo()
 	gpus.LogDetails()
 
-	err = srvr.Serve(ln)
+	errCh := make(chan error, len(lns))
+	for _, ln := range lns {
+		go func() {
+			errCh <- srvr.Serve(limitListener(ln))
+		}()
+	}
+
+	err = <-errCh
 	// If server is closed from the signal handler, wait for the ctx to be done
 	// otherwise error out quickly
 	if !errors.Is(err, http.ErrServerClosed) {
+		srvr.Close()
 		return err
 	}
 	<-ctx.Done()
@@ -1344,6 +2076,16 @@ func waitForStream(c *gin.Context, ch chan interface{}) {
 				c.JSON(http.StatusOK, r)
 				return
 			}
+		case api.EvalResponse:
+			if r.Report != nil {
+				c.JSON(http.StatusOK, r)
+				return
+			}
+		case api.FinetuneResponse:
+			if r.Status == "success" {
+				c.JSON(http.StatusOK, r)
+				return
+			}
 		case gin.H:
 			status, ok := r["status"].(int)
 			if !ok {
@@ -1374,14 +2116,14 @@ func streamResponse(c *gin.Context, ch chan any) {
 
 		bts, err := json.Marshal(val)
 		if err != nil {
-			slog.Info(fmt.Sprintf("streamResponse: json.Marshal failed with %s", err))
+			slog.InfoContext(c.Request.Context(), fmt.Sprintf("streamResponse: json.Marshal failed with %s", err))
 			return false
 		}
 
 		// Delineate chunks with new-line delimiter
 		bts = append(bts, '\n')
 		if _, err := w.Write(bts); err != nil {
-			slog.Info(fmt.Sprintf("streamResponse: w.Write failed with %s", err))
+			slog.InfoContext(c.Request.Context(), fmt.Sprintf("streamResponse: w.Write failed with %s", err))
 			return false
 		}
 
@@ -1419,6 +2161,25 @@ func (s *Server) PsHandler(c *gin.Context) {
 			mr.ExpiresAt = time.Now().Add(v.sessionDuration)
 		}
 
+		if v.llama != nil {
+			if progress := v.llama.LoadProgress(); progress < 1 {
+				// refresh the progress of a model that is still loading
+				ctx, cancel := context.WithTimeout(c.Request.Context(), 200*time.Millisecond)
+				if err := v.llama.Ping(ctx); err == nil {
+					progress = v.llama.LoadProgress()
+				}
+				cancel()
+
+				if progress < 1 {
+					mr.Loading = true
+					mr.LoadProgress = progress
+				}
+			}
+
+			runtime := v.llama.Runtime()
+			mr.Runtime = &runtime
+		}
+
 		models = append(models, mr)
 	}
 
@@ -1442,13 +2203,49 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		return
 	}
 
+	if req.TopLogprobs < 0 || req.TopLogprobs > maxTopLogprobs {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("top_logprobs must be between 0 and %d", maxTopLogprobs)})
+		return
+	}
+
+	if req.CacheKey != "" && !cacheKeyRegexp.MatchString(req.CacheKey) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": errInvalidCacheKey.Error()})
+		return
+	}
+
+	if resp := strictOptions(req.Strict, req.Options); resp != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, resp)
+		return
+	}
+
+	// newMessages are the messages of the request without those of its
+	// session, which are added to the session with the response
+	newMessages := req.Messages
+	if req.Session != "" {
+		session, err := s.sessions.begin(req.Session)
+		switch {
+		case errors.Is(err, errSessionNotFound):
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, errSessionBusy):
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		defer s.sessions.end(req.Session)
+
+		if req, err = sessionChat(session, req); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	// expire the runner
 	if len(req.Messages) == 0 && req.KeepAlive != nil && int(req.KeepAlive.Seconds()) == 0 {
 		model, err := GetModel(req.Model)
 		if err != nil {
 			switch {
 			case os.IsNotExist(err):
-				c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+				c.JSON(http.StatusNotFound, api.ErrorResponse{Error: fmt.Sprintf("model '%s' not found", req.Model), Code: api.ErrorCodeModelNotFound})
 			case err.Error() == errtypes.InvalidModelNameErrMsg:
 				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			default:
@@ -1463,11 +2260,31 @@ func (s *Server) ChatHandler(c *gin.Context) {
 			CreatedAt:  time.Now().UTC(),
 			Message:    api.Message{Role: "assistant"},
 			Done:       true,
-			DoneReason: "unload",
+			DoneReason: api.DoneReasonUnload,
 		})
 		return
 	}
 
+	route := s.route(c.Request.Context(), req.Model, chatRouteInput(req))
+	if route != nil {
+		req.Model = route.Model
+	}
+
+	attempt := fallbackAttemptFrom(c.Request.Context())
+	if attempt != nil {
+		route = attempt.route
+	}
+
+	canary := s.canaries.split(c.Request.Context(), req.Model)
+	if canary != nil {
+		if route == nil {
+			route = canary.route(req.Model)
+		} else {
+			route.Model = canary.model
+		}
+		req.Model = canary.model
+	}
+
 	caps := []Capability{CapabilityCompletion}
 	if len(req.Tools) > 0 {
 		caps = append(caps, CapabilityTools)
@@ -1484,11 +2301,21 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		return
 	}
 
-	r, m, opts, err := s.scheduleRunner(c.Request.Context(), name.String(), caps, req.Options, req.KeepAlive)
+	// models that aren't stored here yet are fetched and reported by
+	// scheduleRunner
+	if m, err := GetModel(name.String()); err == nil {
+		if err := validateCompletionOptions(m, req.Options); err != nil {
+			handleScheduleError(c, req.Model, err)
+			return
+		}
+	}
+
+	r, m, opts, warnings, err := s.scheduleRunner(c.Request.Context(), name.String(), caps, req.Options, req.KeepAlive)
 	if errors.Is(err, errCapabilityCompletion) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%q does not support chat", req.Model)})
 		return
 	} else if err != nil {
+		canary.record(api.Metrics{}, "", err)
 		handleScheduleError(c, req.Model, err)
 		return
 	}
@@ -1501,7 +2328,7 @@ func (s *Server) ChatHandler(c *gin.Context) {
 			CreatedAt:  time.Now().UTC(),
 			Message:    api.Message{Role: "assistant"},
 			Done:       true,
-			DoneReason: "load",
+			DoneReason: api.DoneReasonLoad,
 		})
 		return
 	}
@@ -1511,32 +2338,77 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		msgs = append([]api.Message{{Role: "system", Content: m.System}}, msgs...)
 	}
 
-	prompt, images, err := chatPrompt(c.Request.Context(), m, r.Tokenize, opts, msgs, req.Tools)
+	prompt, images, truncated, err := truncatedChatPrompt(c.Request.Context(), m, r.Tokenize, opts, msgs, req.Tools)
 	if err != nil {
-		slog.Error("chat prompt error", "error", err)
+		slog.ErrorContext(c.Request.Context(), "chat prompt error", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	slog.Debug("chat request", "images", len(images), "prompt", prompt)
+	if truncated > 0 {
+		warnings = append(warnings, api.Warning{
+			Code:    api.WarningPromptTruncated,
+			Message: fmt.Sprintf("%d messages didn't fit in the context window of %d tokens and were left out", truncated, opts.NumCtx),
+		})
+	}
+
+	slog.DebugContext(c.Request.Context(), "chat request", "images", len(images), "prompt", prompt)
 
 	ch := make(chan any)
 	go func() {
 		defer close(ch)
+		defer recoverStream(c, ch)
 		var sb strings.Builder
+		// logprobs are those of the content held back in sb
+		var logprobs []api.Logprob
+		toolStream := newToolCallStreamer(m)
 		var toolCallIndex int = 0
+		energy := s.energy.begin(s.sched.gpusFor(m))
+		defer energy.end()
+		// content is the response so far, which fallback models may score
+		var content strings.Builder
+		var toolCalls []api.ToolCall
+		send := func(res api.ChatResponse) {
+			content.WriteString(res.Message.Content)
+			toolCalls = append(toolCalls, res.Message.ToolCalls...)
+			if res.Done {
+				if req.Session != "" {
+					attempt.recordSession(&s.sessions, req.Session, append(newMessages, sessionReply(m, req.Tools, content.String(), toolCalls))...)
+				}
+
+				res.Warnings = warnings
+				res.StreamSummary = api.NewStreamSummary(res.DoneReason, res.Metrics)
+				res.StreamSummary.Warnings = warningMessages(warnings)
+				res.StreamSummary.Route = route
+
+				attempt.scoreResponse(c.Request.Context(), r, prompt, content.String())
+				canary.record(res.Metrics, res.DoneReason, nil)
+			}
+
+			ch <- res
+		}
+
+		progress := s.newProgressEstimator(m.Digest, m.Template.String(), opts, 0)
+		fp := fingerprint(m, m.Template.String(), r)
 		if err := r.Completion(c.Request.Context(), llm.CompletionRequest{
-			Prompt:  prompt,
-			Images:  images,
-			Format:  req.Format,
-			Options: opts,
+			Prompt:         prompt,
+			Images:         images,
+			Format:         req.Format,
+			Options:        opts,
+			EOSProbability: req.Progress,
+			Logprobs:       req.Logprobs || req.TopLogprobs > 0,
+			TopLogprobs:    req.TopLogprobs,
+			CacheKey:       req.CacheKey,
+			Throttle:       throttle(c.Request, opts),
 		}, func(r llm.CompletionResponse) {
 			res := api.ChatResponse{
-				Model:      req.Model,
-				CreatedAt:  time.Now().UTC(),
-				Message:    api.Message{Role: "assistant", Content: r.Content},
-				Done:       r.Done,
-				DoneReason: r.DoneReason,
+				Model:       req.Model,
+				CreatedAt:   time.Now().UTC(),
+				Message:     api.Message{Role: "assistant", Content: r.Content},
+				Done:        r.Done,
+				DoneReason:  r.DoneReason,
+				Logprobs:    r.Logprobs,
+				Fingerprint: fp,
 				Metrics: api.Metrics{
 					PromptEvalCount:    r.PromptEvalCount,
 					PromptEvalDuration: r.PromptEvalDuration,
@@ -1545,16 +2417,25 @@ func (s *Server) ChatHandler(c *gin.Context) {
 				},
 			}
 
+			if p := progress.update(r); req.Progress {
+				res.Progress = p
+			}
+
 			if r.Done {
 				res.TotalDuration = time.Since(checkpointStart)
 				res.LoadDuration = checkpointLoaded.Sub(checkpointStart)
+				res.Energy, res.GPUTemperature = energy.end()
+
+				if r.PromptTruncated > 0 {
+					warnings = append(warnings, promptTruncatedWarning(r.PromptTruncated, opts.NumCtx))
+				}
 			}
 
 			// TODO: tool call checking and filtering should be moved outside of this callback once streaming
 			// however this was a simple change for now without reworking streaming logic of this (and other)
 			// handlers
 			if req.Stream != nil && !*req.Stream || len(req.Tools) == 0 {
-				ch <- res
+				send(res)
 				return
 			}
 
@@ -1562,6 +2443,8 @@ func (s *Server) ChatHandler(c *gin.Context) {
 			// If tools are recognized, use a flag to track the sending of a tool downstream
 			// This ensures that content is cleared from the message on the last chunk sent
 			sb.WriteString(r.Content)
+			logprobs = append(logprobs, r.Logprobs...)
+			res.ToolCallDeltas = toolStream.add(r.Content)
 			if toolCalls, ok := m.parseToolCalls(sb.String()); ok {
 				res.Message.ToolCalls = toolCalls
 				for i := range toolCalls {
@@ -1569,8 +2452,22 @@ func (s *Server) ChatHandler(c *gin.Context) {
 					toolCallIndex++
 				}
 				res.Message.Content = ""
+				res.Logprobs = logprobs
 				sb.Reset()
-				ch <- res
+				logprobs = nil
+				toolStream.reset(toolCallIndex)
+				if r.Done {
+					res.DoneReason = api.DoneReasonToolCalls
+				}
+				send(res)
+				return
+			}
+
+			// send the tool calls generated so far while the content is held back
+			if !r.Done && len(res.ToolCallDeltas) > 0 {
+				res.Message.Content = ""
+				res.Logprobs = nil
+				send(res)
 				return
 			}
 
@@ -1578,21 +2475,29 @@ func (s *Server) ChatHandler(c *gin.Context) {
 				// Send any remaining content if no tool calls were detected
 				if toolCallIndex == 0 {
 					res.Message.Content = sb.String()
+					res.Logprobs = logprobs
+				} else {
+					res.DoneReason = api.DoneReasonToolCalls
 				}
-				ch <- res
+				send(res)
 			}
 		}); err != nil {
-			ch <- gin.H{"error": err.Error()}
+			canary.record(api.Metrics{}, "", err)
+			res := completionError(err)
+			res["summary"] = errorSummary(err, warnings, route)
+			ch <- res
 		}
 	}()
 
 	if req.Stream != nil && !*req.Stream {
 		var resp api.ChatResponse
 		var sb strings.Builder
+		var logprobs []api.Logprob
 		for rr := range ch {
 			switch t := rr.(type) {
 			case api.ChatResponse:
 				sb.WriteString(t.Message.Content)
+				logprobs = append(logprobs, t.Logprobs...)
 				resp = t
 			case gin.H:
 				msg, ok := t["error"].(string)
@@ -1600,7 +2505,12 @@ func (s *Server) ChatHandler(c *gin.Context) {
 					msg = "unexpected error format in response"
 				}
 
-				c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+				res := gin.H{"error": msg}
+				if code, ok := t["code"].(string); ok {
+					res["code"] = code
+				}
+
+				c.JSON(http.StatusInternalServerError, res)
 				return
 			default:
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected response"})
@@ -1609,11 +2519,16 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		}
 
 		resp.Message.Content = sb.String()
+		resp.Logprobs = logprobs
 
 		if len(req.Tools) > 0 {
 			if toolCalls, ok := m.parseToolCalls(sb.String()); ok {
 				resp.Message.ToolCalls = toolCalls
 				resp.Message.Content = ""
+				resp.DoneReason = api.DoneReasonToolCalls
+				resp.StreamSummary = api.NewStreamSummary(resp.DoneReason, resp.Metrics)
+				resp.StreamSummary.Warnings = warningMessages(warnings)
+				resp.StreamSummary.Route = route
 			}
 		}
 
@@ -1624,16 +2539,58 @@ func (s *Server) ChatHandler(c *gin.Context) {
 	streamResponse(c, ch)
 }
 
+// maxTopLogprobs limits the number of the most likely tokens that are listed
+// for each generated token, as in the OpenAI API.
+const maxTopLogprobs = 20
+
+// completionErrorReason returns the done reason reported alongside an error
+// that ended a completion early.
+func completionErrorReason(err error) string {
+	if errors.Is(err, context.Canceled) {
+		return api.DoneReasonCanceled
+	}
+
+	return api.DoneReasonError
+}
+
+// completionError is the response for an error that ended a completion
+// early, with the code of errors that the runner identified.
+func completionError(err error) gin.H {
+	res := gin.H{"error": err.Error(), "done_reason": completionErrorReason(err)}
+
+	var se api.StatusError
+	if errors.As(err, &se) && se.Code != "" {
+		res["code"] = se.Code
+	}
+
+	return res
+}
+
+// errorSummary is the [api.StreamSummary] of a completion that ended early
+// with err, which counts the tokens that the runner processed until then.
+func errorSummary(err error, warnings []api.Warning, route *api.RouteDecision) *api.StreamSummary {
+	var m api.Metrics
+	var ce *llm.CompletionError
+	if errors.As(err, &ce) {
+		m = ce.Metrics
+	}
+
+	summary := api.NewStreamSummary(completionErrorReason(err), m)
+	summary.Warnings = warningMessages(warnings)
+	summary.Route = route
+	return summary
+}
+
 func handleScheduleError(c *gin.Context, name string, err error) {
 	switch {
-	case errors.Is(err, errCapabilities), errors.Is(err, errRequired):
+	case errors.Is(err, errCapabilities), errors.Is(err, errRequired), errors.Is(err, errInvalidOption):
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 	case errors.Is(err, context.Canceled):
 		c.JSON(499, gin.H{"error": "request canceled"})
 	case errors.Is(err, ErrMaxQueue):
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		c.JSON(http.StatusServiceUnavailable, api.ErrorResponse{Error: err.Error(), Code: api.ErrorCodeServerOverloaded})
 	case errors.Is(err, os.ErrNotExist):
-		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model %q not found, try pulling it first", name)})
+		c.JSON(http.StatusNotFound, api.ErrorResponse{Error: fmt.Sprintf("model %q not found, try pulling it first", name), Code: api.ErrorCodeModelNotFound})
 	default:
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 	}
@@ -4,11 +4,13 @@ import (
 	"bytes"
 	"cmp"
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
 	"slices"
@@ -85,6 +87,7 @@ func createRequest(t *testing.T, fn func(*gin.Context), body any) *httptest.Resp
 	}
 
 	c.Request = &http.Request{
+		URL:  &url.URL{},
 		Body: io.NopCloser(&b),
 	}
 
@@ -136,6 +139,92 @@ func TestCreateFromBin(t *testing.T) {
 	})
 }
 
+func TestCreateMalformed(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+
+	var s Server
+
+	// a header that claims more key-values than any model has
+	var b bytes.Buffer
+	b.WriteString("GGUF")
+	for _, v := range []any{uint32(3), uint64(0), uint64(1 << 40)} {
+		if err := binary.Write(&b, binary.LittleEndian, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(b.Bytes()))
+	if err := os.MkdirAll(filepath.Join(p, "blobs"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(p, "blobs", strings.ReplaceAll(digest, ":", "-")), b.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:   "test",
+		Files:  map[string]string{"test.gguf": digest},
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status code 400, actual %d", w.Code)
+	}
+
+	if !strings.Contains(w.Body.String(), "malformed model file at offset 24: key-value count") {
+		t.Errorf("unexpected response %s", w.Body.String())
+	}
+}
+
+func TestCreateQuantizeTensors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+
+	cases := []struct {
+		name string
+		req  api.CreateRequest
+		want string
+	}{
+		{
+			"without quantize",
+			api.CreateRequest{QuantizeTensors: map[string]string{"output.weight": "q6_K"}},
+			"invalid quantize_tensors: quantize is required",
+		},
+		{
+			"unmatched pattern",
+			api.CreateRequest{Quantize: "q4_K_M", QuantizeTensors: map[string]string{"output.weight": "q6_K"}},
+			"matches no tensors",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.Name = "test"
+			tt.req.Files = map[string]string{"test.gguf": digest}
+			tt.req.Stream = &stream
+
+			w := createRequest(t, s.CreateHandler, tt.req)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("expected status code 400, actual %d", w.Code)
+			}
+
+			if !strings.Contains(w.Body.String(), tt.want) {
+				t.Errorf("unexpected response %s", w.Body.String())
+			}
+		})
+	}
+}
+
 func TestCreateFromModel(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
@@ -710,6 +799,34 @@ func TestCreateDetectTemplate(t *testing.T) {
 			filepath.Join(p, "blobs", "sha256-ca239d7bd8ea90e4a5d2e6bf88f8d74a47b14336e73eb4e18bed4dd325018116"),
 		})
 	})
+
+	t.Run("fill-in-the-middle", func(t *testing.T) {
+		_, digest := createBinFile(t, ggml.KV{
+			"tokenizer.ggml.tokens": []string{"<|endoftext|>", "<fim_prefix>", "<fim_middle>", "<fim_suffix>", "<file_sep>"},
+		}, nil)
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Name:   "fim",
+			Files:  map[string]string{"test.gguf": digest},
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", w.Code)
+		}
+
+		m, err := GetModel("fim")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := m.CheckCapabilities(CapabilityInsert); err != nil {
+			t.Fatal(err)
+		}
+
+		if stop := m.Options["stop"]; !slices.Equal(stop.([]any), []any{"<file_sep>", "<|endoftext|>"}) {
+			t.Errorf("expected the stop sequences of fill-in-the-middle, got %v", stop)
+		}
+	})
 }
 
 func TestDetectModelTypeFromFiles(t *testing.T) {
@@ -0,0 +1,310 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+func TestEmbedHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mock := mockRunner{
+		EmbeddingFn: func(context.Context, string) ([]float32, error) {
+			return []float32{3, 4, 12, 0}, nil
+		},
+	}
+
+	s := Server{
+		sched: &Scheduler{
+			pendingReqCh:  make(chan *LlmRequest, 1),
+			finishedReqCh: make(chan *LlmRequest, 1),
+			expiredCh:     make(chan *runnerRef, 1),
+			unloadedCh:    make(chan any, 1),
+			loaded:        make(map[string]*runnerRef),
+			newServerFn:   newMockServer(&mock),
+			getGpuFn:      discover.GetGPUInfo,
+			getCpuFn:      discover.GetCPUInfo,
+			reschedDelay:  250 * time.Millisecond,
+			loadFn: func(req *LlmRequest, _ *ggml.GGML, _ discover.GpuInfoList, _ int) {
+				req.successCh <- &runnerRef{
+					llama: &mock,
+				}
+			},
+		},
+	}
+
+	go s.sched.Run(t.Context())
+
+	create := func(t *testing.T, name string, kv ggml.KV) {
+		t.Helper()
+
+		_, digest := createBinFile(t, kv, []ggml.Tensor{
+			{Name: "token_embd.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		})
+
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:  name,
+			Files:  map[string]string{"file.gguf": digest},
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	kv := ggml.KV{
+		"general.architecture":      "bert",
+		"bert.block_count":          uint32(1),
+		"bert.context_length":       uint32(512),
+		"bert.embedding_length":     uint32(4),
+		"bert.attention.head_count": uint32(1),
+		"tokenizer.ggml.tokens":     []string{""},
+		"tokenizer.ggml.scores":     []float32{0},
+		"tokenizer.ggml.token_type": []int32{0},
+	}
+
+	create(t, "embed", kv)
+
+	kv["bert.embedding.matryoshka_dimensions"] = []uint32{2, 3}
+	create(t, "matryoshka", kv)
+
+	embed := func(t *testing.T, req api.EmbedRequest) []float32 {
+		t.Helper()
+
+		w := createRequest(t, s.EmbedHandler, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.EmbedResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		return resp.Embeddings[0]
+	}
+
+	off := false
+	equal := func(a, b []float32) bool {
+		if len(a) != len(b) {
+			return false
+		}
+
+		for i := range a {
+			if math.Abs(float64(a[i]-b[i])) > 1e-6 {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	t.Run("default", func(t *testing.T) {
+		if got, want := embed(t, api.EmbedRequest{Model: "embed", Input: "hi"}), []float32{3. / 13, 4. / 13, 12. / 13, 0}; !equal(got, want) {
+			t.Errorf("expected %v, got %v", want, got)
+		}
+	})
+
+	t.Run("not normalized", func(t *testing.T) {
+		if got, want := embed(t, api.EmbedRequest{Model: "embed", Input: "hi", Normalize: &off}), []float32{3, 4, 12, 0}; !equal(got, want) {
+			t.Errorf("expected %v, got %v", want, got)
+		}
+	})
+
+	t.Run("full dimensions", func(t *testing.T) {
+		if got := embed(t, api.EmbedRequest{Model: "embed", Input: "hi", Dimensions: 4}); len(got) != 4 {
+			t.Errorf("expected 4 dimensions, got %v", got)
+		}
+	})
+
+	t.Run("reduced dimensions", func(t *testing.T) {
+		if got, want := embed(t, api.EmbedRequest{Model: "matryoshka", Input: "hi", Dimensions: 2}), []float32{3. / 5, 4. / 5}; !equal(got, want) {
+			t.Errorf("expected %v, got %v", want, got)
+		}
+	})
+
+	t.Run("reduced dimensions not normalized", func(t *testing.T) {
+		if got, want := embed(t, api.EmbedRequest{Model: "matryoshka", Input: "hi", Dimensions: 3, Normalize: &off}), []float32{3, 4, 12}; !equal(got, want) {
+			t.Errorf("expected %v, got %v", want, got)
+		}
+	})
+
+	t.Run("chunking", func(t *testing.T) {
+		mock.EmbeddingFn = func(_ context.Context, s string) ([]float32, error) {
+			if strings.HasPrefix(s, "w0") {
+				return []float32{2, 0, 0, 0}, nil
+			}
+			return []float32{0, 2, 0, 0}, nil
+		}
+		mock.DetokenizeFn = func(_ context.Context, tokens []int) (string, error) {
+			var words []string
+			for _, token := range tokens {
+				words = append(words, fmt.Sprintf("w%d", token))
+			}
+			return strings.Join(words, " "), nil
+		}
+		defer func() { mock.EmbeddingFn, mock.DetokenizeFn = nil, nil }()
+
+		w := createRequest(t, s.EmbedHandler, api.EmbedRequest{
+			Model:     "embed",
+			Input:     []string{"w0 w1 w2 w3 w4", "w0 w1"},
+			Normalize: &off,
+			Chunking:  &api.EmbedChunking{Size: 3, Overlap: 1, Pooling: "none"},
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.EmbedResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		// the chunks of the first input have equal weights
+		if want := []float32{1, 1, 0, 0}; !equal(resp.Embeddings[0], want) {
+			t.Errorf("expected %v, got %v", want, resp.Embeddings[0])
+		}
+
+		if want := []float32{2, 0, 0, 0}; !equal(resp.Embeddings[1], want) {
+			t.Errorf("expected %v, got %v", want, resp.Embeddings[1])
+		}
+
+		if diff := cmp.Diff([][]api.EmbedChunk{
+			{
+				{Text: "w0 w1 w2", Tokens: 3, Embedding: []float32{2, 0, 0, 0}},
+				{Text: "w2 w3 w4", Tokens: 3, Embedding: []float32{0, 2, 0, 0}},
+			},
+			{
+				{Text: "w0 w1", Tokens: 2, Embedding: []float32{2, 0, 0, 0}},
+			},
+		}, resp.Chunks); diff != "" {
+			t.Errorf("chunks mismatch (-want +got):\n%s", diff)
+		}
+
+		if resp.PromptEvalCount != 8 {
+			t.Errorf("expected 8 tokens, got %d", resp.PromptEvalCount)
+		}
+
+		w = createRequest(t, s.EmbedHandler, api.EmbedRequest{
+			Model:    "embed",
+			Input:    "w0 w1 w2 w3 w4",
+			Chunking: &api.EmbedChunking{Size: 3, Overlap: 1},
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		resp = api.EmbedResponse{}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if want := []float32{float32(math.Sqrt2 / 2), float32(math.Sqrt2 / 2), 0, 0}; !equal(resp.Embeddings[0], want) {
+			t.Errorf("expected %v, got %v", want, resp.Embeddings[0])
+		}
+
+		if resp.Chunks != nil {
+			t.Errorf("expected no chunks with mean pooling, got %v", resp.Chunks)
+		}
+	})
+
+	for _, tt := range []struct {
+		name     string
+		model    string
+		chunking api.EmbedChunking
+	}{
+		{"chunk larger than context", "embed", api.EmbedChunking{Size: 1024}},
+		{"overlap not less than size", "embed", api.EmbedChunking{Size: 3, Overlap: 3}},
+		{"negative overlap", "embed", api.EmbedChunking{Overlap: -1}},
+		{"unknown pooling", "embed", api.EmbedChunking{Pooling: "max"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			w := createRequest(t, s.EmbedHandler, api.EmbedRequest{Model: tt.model, Input: "hi", Chunking: &tt.chunking})
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400, got %d: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+
+	for _, tt := range []struct {
+		name       string
+		model      string
+		dimensions int
+	}{
+		{"unsupported model", "embed", 2},
+		{"unsupported dimensions", "matryoshka", 1},
+		{"negative dimensions", "embed", -1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			w := createRequest(t, s.EmbedHandler, api.EmbedRequest{Model: tt.model, Input: "hi", Dimensions: tt.dimensions})
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400, got %d: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+
+	t.Run("batch", func(t *testing.T) {
+		mock.EmbeddingFn = func(_ context.Context, s string) ([]float32, error) {
+			if s == "bad" {
+				return nil, errors.New("failed to embed")
+			}
+			return []float32{3, 4, 0, 0}, nil
+		}
+		defer func() { mock.EmbeddingFn = nil }()
+
+		w := createRequest(t, s.EmbedBatchHandler, api.EmbedBatchRequest{
+			Model:    "embed",
+			Inputs:   []string{"hi there", "bad", strings.Repeat("w ", 600), "hi"},
+			Truncate: &off,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.EmbedBatchResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		want := []api.EmbedBatchResult{
+			{Embedding: []float32{3. / 5, 4. / 5, 0, 0}},
+			{Error: "failed to embed"},
+			{Error: "input length exceeds maximum context length"},
+			{Embedding: []float32{3. / 5, 4. / 5, 0, 0}},
+		}
+
+		if diff := cmp.Diff(want, resp.Results); diff != "" {
+			t.Errorf("results mismatch (-want +got):\n%s", diff)
+		}
+
+		// the inputs that were sent to the runner
+		if resp.PromptEvalCount != 4 {
+			t.Errorf("expected 4 tokens, got %d", resp.PromptEvalCount)
+		}
+	})
+
+	t.Run("batch too large", func(t *testing.T) {
+		t.Setenv("OLLAMA_MAX_EMBED_BATCH", "2")
+
+		w := createRequest(t, s.EmbedBatchHandler, api.EmbedBatchRequest{Model: "embed", Inputs: []string{"a", "b", "c"}})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+}
@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"sync"
 	"testing"
@@ -18,6 +20,7 @@ import (
 	"github.com/ollama/ollama/discover"
 	"github.com/ollama/ollama/fs/ggml"
 	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/types/model"
 )
 
 type mockRunner struct {
@@ -27,6 +30,18 @@ type mockRunner struct {
 	llm.CompletionRequest
 	llm.CompletionResponse
 	CompletionFn func(context.Context, llm.CompletionRequest, func(llm.CompletionResponse)) error
+
+	ScoreFn func(context.Context, llm.ScoreRequest) (*llm.ScoreResponse, error)
+
+	ClassifyFn func(context.Context, string) (*llm.ClassifyResponse, error)
+
+	EmbeddingFn func(context.Context, string) ([]float32, error)
+
+	DetokenizeFn func(context.Context, []int) (string, error)
+
+	FinetuneFn func(context.Context, llm.FinetuneRequest, func(llm.FinetuneResponse)) error
+
+	runtime api.ProcessModelRuntime
 }
 
 func (m *mockRunner) Completion(ctx context.Context, r llm.CompletionRequest, fn func(r llm.CompletionResponse)) error {
@@ -38,6 +53,53 @@ func (m *mockRunner) Completion(ctx context.Context, r llm.CompletionRequest, fn
 	return nil
 }
 
+func (m *mockRunner) Score(ctx context.Context, r llm.ScoreRequest) (*llm.ScoreResponse, error) {
+	if m.ScoreFn != nil {
+		return m.ScoreFn(ctx, r)
+	}
+	return &llm.ScoreResponse{}, nil
+}
+
+func (m *mockRunner) Classify(ctx context.Context, input string) (*llm.ClassifyResponse, error) {
+	if m.ClassifyFn != nil {
+		return m.ClassifyFn(ctx, input)
+	}
+	return nil, errors.New("this model does not support classification")
+}
+
+func (m *mockRunner) Embedding(ctx context.Context, input string) ([]float32, error) {
+	if m.EmbeddingFn != nil {
+		return m.EmbeddingFn(ctx, input)
+	}
+	return nil, errors.New("this model does not support embeddings")
+}
+
+func (m *mockRunner) Embeddings(ctx context.Context, inputs []string) ([]llm.EmbeddingResult, error) {
+	results := make([]llm.EmbeddingResult, len(inputs))
+	for i, input := range inputs {
+		embedding, err := m.Embedding(ctx, input)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+
+		results[i].Embedding = embedding
+	}
+
+	return results, nil
+}
+
+func (m *mockRunner) Finetune(ctx context.Context, r llm.FinetuneRequest, fn func(llm.FinetuneResponse)) error {
+	if m.FinetuneFn != nil {
+		return m.FinetuneFn(ctx, r, fn)
+	}
+	return errors.New("fine-tuning requires the Ollama engine")
+}
+
+func (m *mockRunner) Runtime() api.ProcessModelRuntime {
+	return m.runtime
+}
+
 func (mockRunner) Tokenize(_ context.Context, s string) (tokens []int, err error) {
 	for range strings.Fields(s) {
 		tokens = append(tokens, len(tokens))
@@ -46,6 +108,13 @@ func (mockRunner) Tokenize(_ context.Context, s string) (tokens []int, err error
 	return
 }
 
+func (m *mockRunner) Detokenize(ctx context.Context, tokens []int) (string, error) {
+	if m.DetokenizeFn != nil {
+		return m.DetokenizeFn(ctx, tokens)
+	}
+	return "", errors.New("detokenize is not implemented")
+}
+
 func newMockServer(mock *mockRunner) func(discover.GpuInfoList, string, *ggml.GGML, []string, []string, api.Options, int) (llm.LlamaServer, error) {
 	return func(_ discover.GpuInfoList, _ string, _ *ggml.GGML, _, _ []string, _ api.Options, _ int) (llm.LlamaServer, error) {
 		return mock, nil
@@ -229,6 +298,13 @@ func TestGenerateChat(t *testing.T) {
 			t.Errorf("expected done reason stop, got %s", actual.DoneReason)
 		}
 
+		if diff := cmp.Diff(actual.StreamSummary, &api.StreamSummary{
+			DoneReason: "stop",
+			Usage:      api.Usage{PromptTokens: 1, CompletionTokens: 1, TotalTokens: 2},
+		}); diff != "" {
+			t.Errorf("summary mismatch (-got +want):\n%s", diff)
+		}
+
 		if diff := cmp.Diff(actual.Message, api.Message{
 			Role:    "assistant",
 			Content: content,
@@ -537,13 +613,15 @@ func TestGenerateChat(t *testing.T) {
 			return nil
 		}
 
+		streamRequest := true
+
 		w := createRequest(t, s.ChatHandler, api.ChatRequest{
 			Model: "test-system",
 			Messages: []api.Message{
 				{Role: "user", Content: "What's the weather in Seattle?"},
 			},
 			Tools:  tools,
-			Stream: &stream,
+			Stream: &streamRequest,
 		})
 
 		wg.Wait()
@@ -555,6 +633,7 @@ func TestGenerateChat(t *testing.T) {
 		// Read and validate the streamed responses
 		decoder := json.NewDecoder(w.Body)
 		var finalToolCall api.ToolCall
+		var deltas []api.ToolCallDelta
 
 		for {
 			var resp api.ChatResponse
@@ -564,11 +643,22 @@ func TestGenerateChat(t *testing.T) {
 				t.Fatal(err)
 			}
 
+			deltas = append(deltas, resp.ToolCallDeltas...)
+
 			if resp.Done {
 				if len(resp.Message.ToolCalls) != 1 {
 					t.Errorf("expected 1 tool call in final response, got %d", len(resp.Message.ToolCalls))
 				}
 				finalToolCall = resp.Message.ToolCalls[0]
+
+				if diff := cmp.Diff(resp.StreamSummary, &api.StreamSummary{
+					DoneReason: "tool_calls",
+					Usage:      api.Usage{PromptTokens: 3, TotalTokens: 3},
+				}); diff != "" {
+					t.Errorf("summary mismatch (-got +want):\n%s", diff)
+				}
+			} else if resp.StreamSummary != nil {
+				t.Errorf("expected no summary before the final response")
 			}
 		}
 
@@ -585,6 +675,40 @@ func TestGenerateChat(t *testing.T) {
 		if diff := cmp.Diff(finalToolCall, expectedToolCall); diff != "" {
 			t.Errorf("final tool call mismatch (-got +want):\n%s", diff)
 		}
+
+		// the name is streamed once it's complete, then the arguments as they grow
+		if diff := cmp.Diff(deltas, []api.ToolCallDelta{
+			{Index: 0, Name: "get_weather", Arguments: `{"location":"Seattle`},
+			{Index: 0, Arguments: `, WA","unit":"celsius"}`},
+		}); diff != "" {
+			t.Errorf("tool call deltas mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		mock.CompletionFn = func(context.Context, llm.CompletionRequest, func(llm.CompletionResponse)) error {
+			t.Error("expected the request to be rejected before the completion")
+			return nil
+		}
+		defer func() { mock.CompletionFn = nil }()
+
+		streamRequest := true
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model: "test",
+			Messages: []api.Message{
+				{Role: "user", Content: "Hello!"},
+			},
+			Options: map[string]any{"ignore_eos": true},
+			Stream:  &streamRequest,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d: %s", w.Code, w.Body.String())
+		}
+
+		if diff := cmp.Diff(w.Body.String(), `{"error":"invalid option: ignore_eos requires num_predict to be set"}`); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
 	})
 }
 
@@ -670,7 +794,7 @@ func TestGenerate(t *testing.T) {
 			t.Errorf("expected status 404, got %d", w.Code)
 		}
 
-		if diff := cmp.Diff(w.Body.String(), `{"error":"model '' not found"}`); diff != "" {
+		if diff := cmp.Diff(w.Body.String(), `{"error":"model '' not found","code":"model_not_found"}`); diff != "" {
 			t.Errorf("mismatch (-got +want):\n%s", diff)
 		}
 	})
@@ -681,7 +805,7 @@ func TestGenerate(t *testing.T) {
 			t.Errorf("expected status 404, got %d", w.Code)
 		}
 
-		if diff := cmp.Diff(w.Body.String(), `{"error":"model '' not found"}`); diff != "" {
+		if diff := cmp.Diff(w.Body.String(), `{"error":"model '' not found","code":"model_not_found"}`); diff != "" {
 			t.Errorf("mismatch (-got +want):\n%s", diff)
 		}
 	})
@@ -731,6 +855,22 @@ func TestGenerate(t *testing.T) {
 		}
 	})
 
+	t.Run("invalid batch size", func(t *testing.T) {
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:   "test",
+			Prompt:  "Hello!",
+			Options: map[string]any{"num_batch": 256, "num_ubatch": 512},
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+
+		if diff := cmp.Diff(w.Body.String(), `{"error":"invalid option: num_ubatch (512) must not be larger than num_batch (256)"}`); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
 	t.Run("load model", func(t *testing.T) {
 		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
 			Model: "test",
@@ -778,6 +918,13 @@ func TestGenerate(t *testing.T) {
 			t.Errorf("expected done reason stop, got %s", actual.DoneReason)
 		}
 
+		if diff := cmp.Diff(actual.StreamSummary, &api.StreamSummary{
+			DoneReason: "stop",
+			Usage:      api.Usage{PromptTokens: 1, CompletionTokens: 1, TotalTokens: 2},
+		}); diff != "" {
+			t.Errorf("summary mismatch (-got +want):\n%s", diff)
+		}
+
 		if actual.Response != content {
 			t.Errorf("expected response %s, got %s", content, actual.Response)
 		}
@@ -858,6 +1005,159 @@ func TestGenerate(t *testing.T) {
 		checkGenerateResponse(t, w.Body, "test-system", "Hi!")
 	})
 
+	t.Run("router", func(t *testing.T) {
+		images := true
+		s.routers = map[string]*router{
+			model.ParseName("auto").String(): {name: "auto", Routes: []route{
+				{Model: "test-system", MaxPromptLength: 5},
+				{Model: "test", Images: &images},
+			}},
+		}
+		defer func() { s.routers = nil }()
+
+		cases := []struct {
+			prompt string
+			want   api.RouteDecision
+		}{
+			{"Hi!", api.RouteDecision{Router: "auto", Model: "test-system", Reason: "rule"}},
+			{"Hello there!", api.RouteDecision{Router: "auto", Model: "test", Reason: "default"}},
+		}
+
+		for _, tt := range cases {
+			w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+				Model:  "auto",
+				Prompt: tt.prompt,
+				Stream: &stream,
+			})
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+
+			var resp api.GenerateResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.Model != tt.want.Model {
+				t.Errorf("expected model %q, got %q", tt.want.Model, resp.Model)
+			}
+
+			if resp.StreamSummary == nil || resp.StreamSummary.Route == nil {
+				t.Fatalf("expected a route in the summary, got %+v", resp.StreamSummary)
+			}
+
+			if diff := cmp.Diff(tt.want, *resp.StreamSummary.Route); diff != "" {
+				t.Errorf("route mismatch (-want +got):\n%s", diff)
+			}
+		}
+	})
+
+	t.Run("fallback", func(t *testing.T) {
+		minLogprob := -1.0
+		s.fallbacks = map[string]*fallback{
+			model.ParseName("resilient").String(): {name: "resilient", Models: []fallbackModel{
+				{Model: "missing"},
+				{Model: "test", MinLogprob: &minLogprob},
+				{Model: "test-system"},
+			}},
+		}
+		mock.ScoreFn = func(context.Context, llm.ScoreRequest) (*llm.ScoreResponse, error) {
+			return &llm.ScoreResponse{LogProbs: []float64{-2, -3}}, nil
+		}
+		defer func() { s.fallbacks, mock.ScoreFn = nil, nil }()
+
+		fallback := s.fallbackMiddleware(s.GenerateHandler)
+		w := createRequest(t, func(c *gin.Context) {
+			if fallback(c); !c.IsAborted() {
+				s.GenerateHandler(c)
+			}
+		}, api.GenerateRequest{
+			Model:  "resilient",
+			Prompt: "Hello!",
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.GenerateResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Model != "test-system" {
+			t.Errorf("expected model %q, got %q", "test-system", resp.Model)
+		}
+
+		if resp.StreamSummary == nil || resp.StreamSummary.Route == nil {
+			t.Fatalf("expected a route in the summary, got %+v", resp.StreamSummary)
+		}
+
+		want := api.RouteDecision{Router: "resilient", Model: "test-system", Reason: "fallback", Skipped: []api.SkippedModel{
+			{Model: "missing", Reason: "error", Error: "model 'missing' not found"},
+			{Model: "test", Reason: "confidence", Logprob: -2.5},
+		}}
+		if diff := cmp.Diff(want, *resp.StreamSummary.Route); diff != "" {
+			t.Errorf("route mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("fallback streams the last model", func(t *testing.T) {
+		s.fallbacks = map[string]*fallback{
+			model.ParseName("resilient").String(): {name: "resilient", Models: []fallbackModel{
+				{Model: "missing"},
+				{Model: "test"},
+			}},
+		}
+		defer func() { s.fallbacks = nil }()
+
+		w := &writeNotifier{responseRecorder: NewRecorder(), written: make(chan struct{}, 1)}
+		mock.CompletionFn = func(_ context.Context, _ llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+			fn(llm.CompletionResponse{Content: "Hi"})
+
+			// the first chunk reaches the client before the model is done
+			select {
+			case <-w.written:
+			case <-time.After(5 * time.Second):
+				t.Error("expected the response of the last model to be streamed")
+			}
+
+			fn(llm.CompletionResponse{Content: "!", Done: true, DoneReason: "stop"})
+			return nil
+		}
+		defer func() { mock.CompletionFn = nil }()
+
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"resilient","prompt":"Hello!"}`))
+
+		fallback := s.fallbackMiddleware(s.GenerateHandler)
+		if fallback(c); !c.IsAborted() {
+			t.Fatal("expected the fallback model to serve the request")
+		}
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var content strings.Builder
+		for dec := json.NewDecoder(w.Body); ; {
+			var resp api.GenerateResponse
+			if err := dec.Decode(&resp); errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			content.WriteString(resp.Response)
+		}
+
+		if content.String() != "Hi!" {
+			t.Errorf("expected %q, got %q", "Hi!", content.String())
+		}
+	})
+
 	mock.CompletionResponse.Content = "Abra kadabra!"
 	t.Run("prompt with system", func(t *testing.T) {
 		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
@@ -959,4 +1259,161 @@ func TestGenerate(t *testing.T) {
 			t.Errorf("mismatch (-got +want):\n%s", diff)
 		}
 	})
+	t.Run("warnings", func(t *testing.T) {
+		mock.CompletionResponse.PromptTruncated = 5
+		defer func() { mock.CompletionResponse.PromptTruncated = 0 }()
+
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:   "test",
+			Prompt:  "Hello!",
+			Options: map[string]any{"low_vram": true, "temperatur": 0.5},
+			Stream:  &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.GenerateResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		var codes []string
+		for _, w := range resp.Warnings {
+			codes = append(codes, w.Code)
+		}
+
+		if diff := cmp.Diff(codes, []string{api.WarningOptionIgnored, api.WarningUnknownOption, api.WarningPromptTruncated}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		if resp.StreamSummary == nil || len(resp.StreamSummary.Warnings) != len(resp.Warnings) {
+			t.Errorf("expected the summary to have the warning messages, got %+v", resp.StreamSummary)
+		}
+	})
+	t.Run("logprobs", func(t *testing.T) {
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:       "test",
+			Prompt:      "Hello!",
+			TopLogprobs: 21,
+			Stream:      &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d: %s", w.Code, w.Body.String())
+		}
+
+		want := []api.Logprob{{
+			TokenLogprob: api.TokenLogprob{Token: "Hi!", Logprob: -0.5},
+			TopLogprobs:  []api.TokenLogprob{{Token: "Hi!", Logprob: -0.5}, {Token: "Hello", Logprob: -1.5}},
+		}}
+		mock.CompletionResponse.Logprobs = want
+		defer func() { mock.CompletionResponse.Logprobs = nil }()
+
+		w = createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:       "test",
+			Prompt:      "Hello!",
+			TopLogprobs: 2,
+			Stream:      &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		if !mock.CompletionRequest.Logprobs || mock.CompletionRequest.TopLogprobs != 2 {
+			t.Errorf("expected logprobs to be requested, got %+v", mock.CompletionRequest)
+		}
+
+		var resp api.GenerateResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(resp.Logprobs, want); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		mock.CompletionFn = func(context.Context, llm.CompletionRequest, func(llm.CompletionResponse)) error {
+			t.Error("expected the request to be rejected before the completion")
+			return nil
+		}
+		defer func() { mock.CompletionFn = nil }()
+
+		// streamed requests are rejected before anything is streamed
+		streamRequest := true
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:   "test",
+			Prompt:  "Hello!",
+			Options: map[string]any{"ignore_eos": true},
+			Stream:  &streamRequest,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d: %s", w.Code, w.Body.String())
+		}
+
+		if diff := cmp.Diff(w.Body.String(), `{"error":"invalid option: ignore_eos requires num_predict to be set"}`); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("error summary", func(t *testing.T) {
+		mock.CompletionFn = func(_ context.Context, _ llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+			fn(llm.CompletionResponse{Content: "Hi"})
+			return &llm.CompletionError{
+				Err:     api.StatusError{StatusCode: http.StatusInternalServerError, ErrorMessage: "out of memory", Code: api.ErrorCodeOutOfMemory},
+				Metrics: api.Metrics{PromptEvalCount: 3, EvalCount: 1},
+			}
+		}
+		defer func() { mock.CompletionFn = nil }()
+
+		streaming := true
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:  "test",
+			Prompt: "Hello!",
+			Stream: &streaming,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var last struct {
+			Error   string             `json:"error"`
+			Summary *api.StreamSummary `json:"summary"`
+		}
+		for dec := json.NewDecoder(w.Body); dec.More(); {
+			if err := dec.Decode(&last); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		want := &api.StreamSummary{DoneReason: api.DoneReasonError, Usage: api.Usage{PromptTokens: 3, CompletionTokens: 1, TotalTokens: 4}}
+		if last.Error != "out of memory" {
+			t.Errorf("expected the stream to end with the error, got %q", last.Error)
+		}
+
+		if diff := cmp.Diff(last.Summary, want); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+}
+
+// writeNotifier is a response recorder that notifies written of each write.
+type writeNotifier struct {
+	*responseRecorder
+	written chan struct{}
+}
+
+func (w *writeNotifier) Write(b []byte) (int, error) {
+	n, err := w.responseRecorder.Write(b)
+	select {
+	case w.written <- struct{}{}:
+	default:
+	}
+	return n, err
 }
@@ -1,14 +1,18 @@
 package server
 
 import (
+	"bytes"
 	"encoding/json"
 	"net/http"
 	"slices"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
 
 	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/fs/ggml"
 )
 
 func TestList(t *testing.T) {
@@ -64,3 +68,308 @@ func TestList(t *testing.T) {
 		t.Fatalf("expected slices to be equal %v", actualNames)
 	}
 }
+
+func TestListFilters(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var s Server
+	for _, tt := range []struct {
+		name     string
+		template string
+	}{
+		{"alpha", "{{ .Prompt }}"},
+		{"beta", "{{ if .Tools }}{{ .Tools }}{{ end }}{{ .Prompt }}"},
+		{"gamma", "{{ .Prompt }}"},
+	} {
+		_, digest := createBinFile(t, nil, nil)
+
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Name:     tt.name,
+			Files:    map[string]string{"test.gguf": digest},
+			Template: tt.template,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	list := func(t *testing.T, req api.ListRequest) api.ListResponse {
+		t.Helper()
+
+		w := createRequest(t, func(c *gin.Context) {
+			c.Request.URL.RawQuery = req.Query().Encode()
+			s.ListHandler(c)
+		}, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.ListResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		return resp
+	}
+
+	names := func(resp api.ListResponse) []string {
+		var names []string
+		for _, m := range resp.Models {
+			names = append(names, m.Name)
+		}
+		return names
+	}
+
+	t.Run("sort by name", func(t *testing.T) {
+		resp := list(t, api.ListRequest{Sort: "name"})
+		if want := []string{"alpha:latest", "beta:latest", "gamma:latest"}; !slices.Equal(names(resp), want) || resp.Total != 3 {
+			t.Errorf("expected %v, actual %v (total %d)", want, names(resp), resp.Total)
+		}
+	})
+
+	t.Run("paginate", func(t *testing.T) {
+		resp := list(t, api.ListRequest{Sort: "name", Order: "desc", Offset: 1, Limit: 1})
+		if want := []string{"beta:latest"}; !slices.Equal(names(resp), want) || resp.Total != 3 {
+			t.Errorf("expected %v, actual %v (total %d)", want, names(resp), resp.Total)
+		}
+	})
+
+	t.Run("offset past the end", func(t *testing.T) {
+		resp := list(t, api.ListRequest{Offset: 5})
+		if len(resp.Models) != 0 || resp.Total != 3 {
+			t.Errorf("expected no models, actual %v (total %d)", names(resp), resp.Total)
+		}
+	})
+
+	t.Run("capability", func(t *testing.T) {
+		resp := list(t, api.ListRequest{Capabilities: []string{"tools"}})
+		if want := []string{"beta:latest"}; !slices.Equal(names(resp), want) || resp.Total != 1 {
+			t.Errorf("expected %v, actual %v (total %d)", want, names(resp), resp.Total)
+		}
+	})
+
+	t.Run("modified after", func(t *testing.T) {
+		resp := list(t, api.ListRequest{ModifiedAfter: time.Now().Add(time.Hour)})
+		if len(resp.Models) != 0 {
+			t.Errorf("expected no models, actual %v", names(resp))
+		}
+	})
+
+	for _, query := range []string{
+		"sort=date",
+		"order=up",
+		"limit=-1",
+		"offset=x",
+		"min_size=-5",
+		"capability=vision",
+		"modified_after=yesterday",
+	} {
+		t.Run(query, func(t *testing.T) {
+			w := createRequest(t, func(c *gin.Context) {
+				c.Request.URL.RawQuery = query
+				s.ListHandler(c)
+			}, nil)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status code 400, actual %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestListModels(t *testing.T) {
+	now := time.Now()
+	models := func() []api.ListModelResponse {
+		return []api.ListModelResponse{
+			{Name: "a", Size: 3, ModifiedAt: now.Add(-2 * time.Hour), Details: api.ModelDetails{Family: "llama"}},
+			{Name: "b", Size: 1, ModifiedAt: now, Details: api.ModelDetails{Family: "gemma3"}},
+			{Name: "c", Size: 2, ModifiedAt: now.Add(-time.Hour), Details: api.ModelDetails{Family: "qwen2", Families: []string{"qwen2", "clip"}}},
+			{Name: "d", Size: 2, ModifiedAt: now.Add(-time.Hour), Details: api.ModelDetails{Family: "llama"}},
+		}
+	}
+
+	cases := []struct {
+		name  string
+		req   api.ListRequest
+		names []string
+		total int
+	}{
+		{"default", api.ListRequest{}, []string{"b", "c", "d", "a"}, 4},
+		{"modified ascending", api.ListRequest{Sort: "modified", Order: "asc"}, []string{"a", "c", "d", "b"}, 4},
+		{"size", api.ListRequest{Sort: "size"}, []string{"a", "c", "d", "b"}, 4},
+		{"size ascending", api.ListRequest{Sort: "size", Order: "asc"}, []string{"b", "c", "d", "a"}, 4},
+		{"name descending", api.ListRequest{Sort: "name", Order: "desc"}, []string{"d", "c", "b", "a"}, 4},
+		{"family", api.ListRequest{Family: "LLAMA"}, []string{"d", "a"}, 2},
+		{"families", api.ListRequest{Family: "clip"}, []string{"c"}, 1},
+		{"size range", api.ListRequest{MinSize: 2, MaxSize: 2}, []string{"c", "d"}, 2},
+		{"modified range", api.ListRequest{ModifiedAfter: now.Add(-3 * time.Hour), ModifiedBefore: now}, []string{"c", "d", "a"}, 3},
+		{"limit", api.ListRequest{Limit: 2}, []string{"b", "c"}, 4},
+		{"offset", api.ListRequest{Offset: 3, Limit: 2}, []string{"a"}, 4},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			got, total := listModels(models(), tt.req)
+
+			var names []string
+			for _, m := range got {
+				names = append(names, m.Name)
+			}
+
+			if !slices.Equal(names, tt.names) || total != tt.total {
+				t.Errorf("expected %v (total %d), actual %v (total %d)", tt.names, tt.total, names, total)
+			}
+		})
+	}
+}
+
+func TestParseListRequest(t *testing.T) {
+	modified := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	req := api.ListRequest{
+		Family:         "llama",
+		Capabilities:   []string{"completion", "tools"},
+		MinSize:        1,
+		MaxSize:        2,
+		ModifiedAfter:  modified,
+		ModifiedBefore: modified.Add(time.Hour),
+		Sort:           "size",
+		Order:          "asc",
+		Limit:          10,
+		Offset:         20,
+	}
+
+	got, err := parseListRequest(req.Query())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(req, got); diff != "" {
+		t.Errorf("parseListRequest() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestSearchModels(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var s Server
+	for _, tt := range []struct {
+		name     string
+		kv       ggml.KV
+		elements uint64
+		template string
+		license  string
+	}{
+		{
+			name:     "llama3:8b",
+			kv:       ggml.KV{"general.architecture": "llama", "general.file_type": uint32(2), "general.license": "llama3"},
+			elements: 8,
+			template: "{{ if .Tools }}{{ .Tools }}{{ end }}{{ .Prompt }}",
+		},
+		{
+			name:     "myns/coder:1b",
+			kv:       ggml.KV{"general.architecture": "qwen2", "general.file_type": uint32(1)},
+			elements: 1,
+			template: "{{ .Prompt }}{{ .Suffix }}",
+			license:  "Apache License\nVersion 2.0",
+		},
+		{
+			name:     "embed:latest",
+			kv:       ggml.KV{"general.architecture": "bert", "bert.pooling_type": uint32(1)},
+			elements: 2,
+		},
+	} {
+		_, digest := createBinFile(t, tt.kv, []ggml.Tensor{
+			{Name: "token_embd.weight", Shape: []uint64{tt.elements}, WriterTo: bytes.NewReader(make([]byte, 4*tt.elements))},
+		})
+
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Name:     tt.name,
+			Files:    map[string]string{"test.gguf": digest},
+			Template: tt.template,
+			License:  tt.license,
+			Stream:   &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	search := func(t *testing.T, req api.SearchModelsRequest) []api.SearchModelResponse {
+		t.Helper()
+
+		w := createRequest(t, s.SearchModelsHandler, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.SearchModelsResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		return resp.Models
+	}
+
+	t.Run("metadata", func(t *testing.T) {
+		models := search(t, api.SearchModelsRequest{})
+		if len(models) != 3 {
+			t.Fatalf("expected 3 models, actual %d", len(models))
+		}
+
+		got := make(map[string]api.SearchModelResponse)
+		for _, m := range models {
+			got[m.Name] = m
+		}
+
+		for name, want := range map[string]struct {
+			params       uint64
+			license      string
+			capabilities []string
+		}{
+			"llama3:8b":     {8, "llama3", []string{"completion", "tools"}},
+			"myns/coder:1b": {1, "Apache License", []string{"completion", "insert"}},
+			"embed:latest":  {2, "", []string{}},
+		} {
+			m := got[name]
+			if m.ParameterCount != want.params || m.License != want.license || !slices.Equal(m.Capabilities, want.capabilities) {
+				t.Errorf("%s: unexpected metadata %+v", name, m)
+			}
+		}
+	})
+
+	for _, tt := range []struct {
+		name  string
+		req   api.SearchModelsRequest
+		names []string
+	}{
+		{"substring", api.SearchModelsRequest{Name: "LLAMA"}, []string{"llama3:8b"}},
+		{"pattern", api.SearchModelsRequest{Name: "*:?b"}, []string{"llama3:8b", "myns/coder:1b"}},
+		{"anchored pattern", api.SearchModelsRequest{Name: "coder*"}, nil},
+		{"family", api.SearchModelsRequest{Family: "bert"}, []string{"embed:latest"}},
+		{"quantization", api.SearchModelsRequest{Quantization: "q4_0"}, []string{"llama3:8b"}},
+		{"parameters", api.SearchModelsRequest{MinParameters: 2, MaxParameters: 4}, []string{"embed:latest"}},
+		{"license", api.SearchModelsRequest{License: "apache"}, []string{"myns/coder:1b"}},
+		{"capabilities", api.SearchModelsRequest{Capabilities: []string{"completion", "insert"}}, []string{"myns/coder:1b"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var names []string
+			for _, m := range search(t, tt.req) {
+				names = append(names, m.Name)
+			}
+
+			if !slices.Equal(names, tt.names) {
+				t.Errorf("expected %v, actual %v", tt.names, names)
+			}
+		})
+	}
+
+	t.Run("unknown capability", func(t *testing.T) {
+		w := createRequest(t, s.SearchModelsHandler, api.SearchModelsRequest{Capabilities: []string{"vision"}})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status code 400, actual %d", w.Code)
+		}
+	})
+}
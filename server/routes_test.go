@@ -1,13 +1,16 @@
 package server
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"maps"
 	"math"
 	"math/rand/v2"
 	"net"
@@ -15,11 +18,14 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"testing"
 	"unicode"
 
+	"github.com/gin-gonic/gin"
+
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/fs/ggml"
 	"github.com/ollama/ollama/openai"
@@ -550,6 +556,40 @@ func casingShuffle(s string) string {
 	return string(rr)
 }
 
+func TestBasePath(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_BASE_PATH", "/ollama/")
+
+	s := &Server{}
+	router, err := s.GenerateRoutes(nil)
+	if err != nil {
+		t.Fatalf("failed to generate routes: %v", err)
+	}
+
+	cases := []struct {
+		path   string
+		status int
+	}{
+		{"/ollama", http.StatusOK},
+		{"/ollama/", http.StatusOK},
+		{"/ollama/api/version", http.StatusOK},
+		{"/ollama/v1/models", http.StatusOK},
+		{"/api/version", http.StatusNotFound},
+		{"/ollamax/api/version", http.StatusNotFound},
+		{"/", http.StatusNotFound},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, w.Code)
+			}
+		})
+	}
+}
+
 func TestManifestCaseSensitivity(t *testing.T) {
 	t.Setenv("OLLAMA_MODELS", t.TempDir())
 
@@ -712,6 +752,150 @@ func TestShow(t *testing.T) {
 	}
 }
 
+func TestPsLoadProgress(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	s := Server{sched: &Scheduler{loaded: map[string]*runnerRef{
+		"loading": {
+			model: &Model{ShortName: "loading:latest"},
+			llama: &mockLlm{loadProgress: 0.25},
+		},
+		"loaded": {
+			model: &Model{ShortName: "loaded:latest"},
+			llama: &mockLlm{loadProgress: 1},
+		},
+	}}}
+
+	w := createRequest(t, s.PsHandler, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	var resp api.ProcessResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	progress := make(map[string]float32)
+	for _, m := range resp.Models {
+		if m.Loading {
+			progress[m.Name] = m.LoadProgress
+		}
+	}
+
+	if len(progress) != 1 || progress["loading:latest"] != 0.25 {
+		t.Errorf("unexpected load progress %v", progress)
+	}
+}
+
+func TestPsRuntime(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	runtime := api.ProcessModelRuntime{
+		NumCtx:         4096,
+		NumBatch:       512,
+		NumParallel:    2,
+		KvCacheType:    "q8_0",
+		FlashAttention: true,
+		GPULayers:      20,
+		TotalLayers:    33,
+		Engine:         "ollama",
+		Library:        "cuda_v12",
+	}
+
+	s := Server{sched: &Scheduler{loaded: map[string]*runnerRef{
+		"test": {
+			model: &Model{ShortName: "test:latest"},
+			llama: &mockLlm{loadProgress: 1, runtime: runtime},
+		},
+	}}}
+
+	w := createRequest(t, s.PsHandler, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	var resp api.ProcessResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resp.Models) != 1 {
+		t.Fatalf("expected 1 model, got %d", len(resp.Models))
+	}
+
+	if got := resp.Models[0].Runtime; got == nil || *got != runtime {
+		t.Errorf("expected runtime %+v, got %+v", runtime, got)
+	}
+}
+
+func TestValidateBatchSize(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	modelPath := func(kv ggml.KV) string {
+		_, digest := createBinFile(t, kv, nil)
+		path, err := GetBlobsPath(digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return path
+	}
+
+	completion := &Model{ModelPath: modelPath(ggml.KV{"general.architecture": "llama"})}
+	embedding := &Model{ModelPath: modelPath(ggml.KV{"general.architecture": "bert", "bert.pooling_type": uint32(0)})}
+
+	cases := []struct {
+		name      string
+		model     *Model
+		batch     int
+		ubatch    int
+		wantError bool
+	}{
+		{"default", completion, 512, 0, false},
+		{"smaller ubatch", completion, 2048, 512, false},
+		{"equal", completion, 512, 512, false},
+		{"zero batch", completion, 0, 0, true},
+		{"negative ubatch", completion, 512, -1, true},
+		{"larger ubatch", completion, 256, 512, true},
+		{"embedding default", embedding, 2048, 0, false},
+		{"embedding equal", embedding, 2048, 2048, false},
+		{"embedding smaller ubatch", embedding, 2048, 512, true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := api.DefaultOptions()
+			opts.NumBatch = tt.batch
+			opts.NumUBatch = tt.ubatch
+
+			err := validateBatchSize(tt.model, opts)
+			if tt.wantError != (err != nil) {
+				t.Fatalf("expected error %t, got %v", tt.wantError, err)
+			}
+
+			if err != nil && !errors.Is(err, errInvalidOption) {
+				t.Errorf("expected an invalid option error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestModelOptionsStopRegex(t *testing.T) {
+	opts, err := modelOptions(&Model{}, map[string]any{"stop_regex": []any{`\n{3,}`, "^User:"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(opts.StopRegex, []string{`\n{3,}`, "^User:"}) {
+		t.Errorf("unexpected stop patterns %v", opts.StopRegex)
+	}
+
+	if _, err := modelOptions(&Model{}, map[string]any{"stop_regex": []any{"[a-"}}); !errors.Is(err, errInvalidOption) {
+		t.Errorf("expected an invalid option error, got %v", err)
+	}
+}
+
 func TestNormalize(t *testing.T) {
 	type testCase struct {
 		input []float32
@@ -746,3 +930,83 @@ func TestNormalize(t *testing.T) {
 		})
 	}
 }
+
+func TestStreamResponseHTTP2(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ch := make(chan any)
+	r := gin.New()
+	r.GET("/stream", func(c *gin.Context) { streamResponse(c, ch) })
+
+	ts := httptest.NewUnstartedServer(r)
+	ts.Config.Protocols = serverProtocols()
+	ts.Start()
+	defer ts.Close()
+
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: &protocols}}
+
+	// headers are only written with the first chunk
+	go func() { ch <- api.ProgressResponse{Status: "step 0"} }()
+
+	resp, err := client.Get(ts.URL + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("expected HTTP/2, got %s", resp.Proto)
+	}
+
+	// each chunk must be flushed to the client before the next one is produced
+	scanner := bufio.NewScanner(resp.Body)
+	for i := range 3 {
+		if i > 0 {
+			ch <- api.ProgressResponse{Status: fmt.Sprintf("step %d", i)}
+		}
+
+		if !scanner.Scan() {
+			t.Fatalf("expected chunk %d: %v", i, scanner.Err())
+		}
+
+		var p api.ProgressResponse
+		if err := json.Unmarshal(scanner.Bytes(), &p); err != nil {
+			t.Fatal(err)
+		}
+
+		if p.Status != fmt.Sprintf("step %d", i) {
+			t.Errorf("unexpected status %q", p.Status)
+		}
+	}
+
+	close(ch)
+	if scanner.Scan() {
+		t.Fatalf("unexpected chunk %q", scanner.Text())
+	}
+}
+
+func TestCompletionError(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want gin.H
+	}{
+		{"error", errors.New("runner crashed"), gin.H{"error": "runner crashed", "done_reason": api.DoneReasonError}},
+		{"canceled", context.Canceled, gin.H{"error": "context canceled", "done_reason": api.DoneReasonCanceled}},
+		{
+			"out of memory",
+			fmt.Errorf("completion: %w", api.StatusError{StatusCode: http.StatusInternalServerError, ErrorMessage: "out of memory", Code: api.ErrorCodeOutOfMemory}),
+			gin.H{"error": "completion: out of memory", "done_reason": api.DoneReasonError, "code": api.ErrorCodeOutOfMemory},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := completionError(tt.err); !maps.Equal(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -4,10 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log/slog"
 	"os"
 	"reflect"
 	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -20,8 +20,12 @@ import (
 	"github.com/ollama/ollama/format"
 	"github.com/ollama/ollama/fs/ggml"
 	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/logutil"
 )
 
+// schedLog logs the decisions of the scheduler at the level of its subsystem
+var schedLog = logutil.Subsystem(logutil.Scheduler)
+
 type LlmRequest struct {
 	ctx             context.Context //nolint:containedctx
 	model           *Model
@@ -42,6 +46,10 @@ type Scheduler struct {
 	loaded   map[string]*runnerRef
 	loadedMu sync.Mutex
 
+	// warm tracks models read ahead of use, which are kept loaded in
+	// preference to others
+	warm warmModels
+
 	loadFn       func(req *LlmRequest, f *ggml.GGML, gpus discover.GpuInfoList, numParallel int)
 	newServerFn  func(gpus discover.GpuInfoList, model string, f *ggml.GGML, adapters []string, projectors []string, opts api.Options, numParallel int) (llm.LlamaServer, error)
 	getGpuFn     func() discover.GpuInfoList
@@ -103,7 +111,7 @@ func (s *Scheduler) GetRunner(c context.Context, model *Model, opts api.Options,
 
 // Returns immediately, spawns go routines for the scheduler which will shutdown when ctx is done
 func (s *Scheduler) Run(ctx context.Context) {
-	slog.Debug("starting llm scheduler")
+	schedLog.Debug("starting llm scheduler")
 	go func() {
 		s.processPending(ctx)
 	}()
@@ -114,201 +122,281 @@ func (s *Scheduler) Run(ctx context.Context) {
 }
 
 func (s *Scheduler) processPending(ctx context.Context) {
+	var q pendingQueue
 	for {
 		select {
 		case <-ctx.Done():
-			slog.Debug("shutting down scheduler pending loop")
+			schedLog.Debug("shutting down scheduler pending loop")
 			return
 		case pending := <-s.pendingReqCh:
-			// Block other requests until we get this pending request running
-			pending.schedAttempts++
-			if pending.origNumCtx == 0 {
-				pending.origNumCtx = pending.opts.NumCtx
+			s.admit(ctx, &q, pending)
+		case <-s.unloadedCh:
+			if len(q.reqs) == 0 {
+				// An unload request when there are no pending request can be ignored
+				schedLog.Debug("ignoring unload event with no pending requests")
+				continue
 			}
 
-			if pending.ctx.Err() != nil {
-				slog.Debug("pending request cancelled or timed out, skipping scheduling")
-				continue
+			if q.expiring != nil {
+				schedLog.Debug("unload completed", "modelPath", q.expiring.modelPath)
 			}
-			numParallel := int(envconfig.NumParallel())
-			// TODO (jmorganca): mllama doesn't support parallel yet
-			// see https://github.com/ollama/ollama/issues/4165
-			if checkMllamaModelFamily(pending.model) && numParallel != 1 {
-				numParallel = 1
-				slog.Warn("mllama doesn't support parallel requests yet")
+
+			// Schedule the waiting requests again, in order
+			reqs := q.reqs
+			q = pendingQueue{}
+			for _, pending := range reqs {
+				s.admit(ctx, &q, pending)
 			}
+		}
+	}
+}
 
-			for {
-				var runnerToExpire *runnerRef
-				s.loadedMu.Lock()
-				runner := s.loaded[pending.model.ModelPath]
-				loadedCount := len(s.loaded)
-				s.loadedMu.Unlock()
-				if runner != nil {
-					if runner.needsReload(ctx, pending) {
-						runnerToExpire = runner
-					} else {
-						// Runner is usable, return it
-						pending.useLoadedRunner(runner, s.finishedReqCh)
-						break
-					}
-				} else if envconfig.MaxRunners() > 0 && loadedCount >= int(envconfig.MaxRunners()) {
-					slog.Debug("max runners achieved, unloading one to make room", "runner_count", loadedCount)
-					runnerToExpire = s.findRunnerToUnload()
-				} else {
-					// Either no models are loaded or below envconfig.MaxRunners
-					// Get a refreshed GPU list
-					var gpus discover.GpuInfoList
-					if pending.opts.NumGPU == 0 {
-						gpus = s.getCpuFn()
-					} else {
-						gpus = s.getGpuFn()
-					}
+// pendingQueue holds the requests that wait for runners to unload so that
+// their models can be loaded, in the order they arrived. Requests for models
+// that are already loaded don't wait behind them, so a model that is being
+// loaded doesn't hold up the requests for other models.
+type pendingQueue struct {
+	reqs []*LlmRequest
 
-					if envconfig.MaxRunners() <= 0 {
-						// No user specified MaxRunners, so figure out what automatic setting to use
-						// If all GPUs have reliable free memory reporting, defaultModelsPerGPU * the number of GPUs
-						// if any GPU has unreliable free memory reporting, 1x the number of GPUs
-						allReliable := true
-						for _, gpu := range gpus {
-							if gpu.UnreliableFreeMemory {
-								allReliable = false
-								break
-							}
-						}
-						if allReliable {
-							// HACK
-							os.Setenv("OLLAMA_MAX_LOADED_MODELS", strconv.Itoa(defaultModelsPerGPU*len(gpus)))
-							slog.Debug("updating default concurrency", "OLLAMA_MAX_LOADED_MODELS", envconfig.MaxRunners(), "gpu_count", len(gpus))
-						} else {
-							// HACK
-							os.Setenv("OLLAMA_MAX_LOADED_MODELS", strconv.Itoa(len(gpus)))
-							slog.Info("one or more GPUs detected that are unable to accurately report free memory - disabling default concurrency")
-						}
-					}
+	// expiring is the runner unloading to make room for the first request
+	expiring *runnerRef
+}
 
-					// Load model for fitting
-					ggml, err := llm.LoadModel(pending.model.ModelPath, 0)
-					if err != nil {
-						pending.errCh <- err
-						break
-					}
+// admit schedules pending, or adds it to q if it has to wait for a runner to
+// unload first.
+func (s *Scheduler) admit(ctx context.Context, q *pendingQueue, pending *LlmRequest) {
+	pending.schedAttempts++
+	if pending.origNumCtx == 0 {
+		pending.origNumCtx = pending.opts.NumCtx
+	}
 
-					// Embedding models should always be loaded with parallel=1
-					if pending.model.CheckCapabilities(CapabilityCompletion) != nil {
-						numParallel = 1
-					}
+	if pending.ctx.Err() != nil {
+		schedLog.Debug("pending request cancelled or timed out, skipping scheduling")
+		return
+	}
 
-					// Evaluate if the model will fit in the available system memory, or if we should unload a model first
-					if len(gpus) == 1 && gpus[0].Library == "cpu" {
-						// simplifying assumption of defaultParallel when in CPU mode
-						if numParallel <= 0 {
-							numParallel = defaultParallel
-						}
+	if len(q.reqs) > 0 {
+		// Requests for a model keep their order, and only one model waits
+		// for memory at a time
+		waiting := slices.ContainsFunc(q.reqs, func(r *LlmRequest) bool {
+			return r.model.ModelPath == pending.model.ModelPath
+		})
+		if waiting || !s.useLoadedRunner(ctx, pending, q.expiring) {
+			if len(q.reqs) >= int(envconfig.MaxQueue()) {
+				pending.errCh <- ErrMaxQueue
+				return
+			}
 
-						pending.opts.NumCtx = pending.origNumCtx * numParallel
+			q.reqs = append(q.reqs, pending)
+		}
+		return
+	}
 
-						if loadedCount == 0 {
-							slog.Debug("cpu mode with first model, loading")
-							s.loadFn(pending, ggml, gpus, numParallel)
-							break
-						}
-						runnerToExpire = s.maybeFindCPURunnerToUnload(pending, ggml, gpus)
-						if runnerToExpire == nil {
-							slog.Debug("cpu mode with available system memory or first model, loading")
-							s.loadFn(pending, ggml, gpus, numParallel)
-							break
-						}
-						// else we need to expire a runner
-					} else if loadedCount == 0 {
-						// No models loaded. Load the model but prefer the best fit.
-						slog.Debug("loading first model", "model", pending.model.ModelPath)
-						g := pickBestFullFitByLibrary(pending, ggml, gpus, &numParallel)
-						if g != nil {
-							gpus = g
-						} else {
-							// Only allow partial loads when this is the first model
-							gpus = pickBestPartialFitByLibrary(pending, ggml, gpus, &numParallel)
-						}
-						s.loadFn(pending, ggml, gpus, numParallel)
-						break
-					}
+	runnerToExpire := s.schedule(ctx, pending)
+	if runnerToExpire == nil {
+		return
+	}
 
-					if runnerToExpire == nil {
-						// More than one loaded model, so we have to see if the
-						// new one fits
-						//
-						// We want to avoid loading on any GPUs that have other
-						// models still loading on them to avoid potential races
-						// with VRAM consumption ramping up during load
-						availGpus := s.filterGPUsWithoutLoadingModels(gpus)
-
-						// Update free memory from currently loaded models
-						s.updateFreeSpace(availGpus)
-						fitGpus := pickBestFullFitByLibrary(pending, ggml, availGpus, &numParallel)
-						if fitGpus != nil {
-							slog.Debug("new model fits with existing models, loading")
-							s.loadFn(pending, ggml, fitGpus, numParallel)
-							break
-						}
+	// Trigger an expiration to unload once it's done
+	runnerToExpire.refMu.Lock()
+	schedLog.Debug("resetting model to expire immediately to make room", "modelPath", runnerToExpire.modelPath, "refCount", runnerToExpire.refCount)
+	if runnerToExpire.expireTimer != nil {
+		runnerToExpire.expireTimer.Stop()
+		runnerToExpire.expireTimer = nil
+	}
+	runnerToExpire.sessionDuration = 0
+	if runnerToExpire.refCount <= 0 {
+		s.expiredCh <- runnerToExpire
+	}
+	runnerToExpire.refMu.Unlock()
 
-						// We couldn't find a set of GPUs to fully load the new
-						// model. If no other models are loading (both GPU lists
-						// are the same) then we need to unload another model to
-						// make room
-						if len(availGpus) < len(gpus) {
-							// There are other requests pending, and this one
-							// needs more time, so put it on the back of the
-							// queue so that we might satisfy other pending
-							// requests that aren't blocked
-							go func() {
-								// Process in a go routine to avoid deadlocking
-								// the scheduler if our queue is full
-								slog.Debug("delaying scheduling while other models finish loading", "attempts", pending.schedAttempts, "model", pending.model.ModelPath)
-								time.Sleep(s.reschedDelay)
-								s.pendingReqCh <- pending
-							}()
-							break
-						}
-						runnerToExpire = s.findRunnerToUnload()
-					}
-				}
+	// Wait for the unload to happen. Requests for other models that are
+	// loaded are still served in the meantime.
+	schedLog.Debug("waiting for pending requests to complete and unload to occur", "modelPath", runnerToExpire.modelPath)
+	q.reqs = append(q.reqs, pending)
+	q.expiring = runnerToExpire
+}
 
-				if runnerToExpire == nil {
-					// Shouildn't happen
-					slog.Error("runner to expire was nil!")
-					continue
-				}
-				// Trigger an expiration to unload once it's done
-				runnerToExpire.refMu.Lock()
-				slog.Debug("resetting model to expire immediately to make room", "modelPath", runnerToExpire.modelPath, "refCount", runnerToExpire.refCount)
-				if runnerToExpire.expireTimer != nil {
-					runnerToExpire.expireTimer.Stop()
-					runnerToExpire.expireTimer = nil
-				}
-				runnerToExpire.sessionDuration = 0
-				if runnerToExpire.refCount <= 0 {
-					s.expiredCh <- runnerToExpire
-				}
-				runnerToExpire.refMu.Unlock()
-				// Wait for the unload to happen
-				// Note: at this point we're queueing up all incoming requests, even if they were for
-				// a different model that's loaded and not scheduled to be removed.
-				slog.Debug("waiting for pending requests to complete and unload to occur", "modelPath", runnerToExpire.modelPath)
-				select {
-				case <-ctx.Done():
-					slog.Debug("shutting down scheduler pending loop")
-					return
-				case <-s.unloadedCh:
-					slog.Debug("unload completed", "modelPath", runnerToExpire.modelPath)
-					continue
+// useLoadedRunner hands the loaded runner for the model of pending to it
+// unless it isn't loaded, has to be reloaded or is expiring. It reports
+// whether pending got the runner.
+func (s *Scheduler) useLoadedRunner(ctx context.Context, pending *LlmRequest, expiring *runnerRef) bool {
+	s.loadedMu.Lock()
+	runner := s.loaded[pending.model.ModelPath]
+	s.loadedMu.Unlock()
+
+	if runner == nil || runner == expiring || runner.needsReload(ctx, pending) {
+		return false
+	}
+
+	pending.useLoadedRunner(runner, s.finishedReqCh)
+	return true
+}
+
+// schedule gets a runner for pending by using the loaded runner for its
+// model or loading one. It returns the runner to unload first if there isn't
+// enough memory to load the model, or nil once pending was handled.
+func (s *Scheduler) schedule(ctx context.Context, pending *LlmRequest) *runnerRef {
+	numParallel := int(envconfig.NumParallel())
+	if pending.opts.NumParallel > 0 {
+		numParallel = pending.opts.NumParallel
+	}
+
+	// TODO (jmorganca): mllama doesn't support parallel yet
+	// see https://github.com/ollama/ollama/issues/4165
+	if checkMllamaModelFamily(pending.model) && numParallel != 1 {
+		numParallel = 1
+		schedLog.Warn("mllama doesn't support parallel requests yet")
+	}
+
+	var runnerToExpire *runnerRef
+	s.loadedMu.Lock()
+	runner := s.loaded[pending.model.ModelPath]
+	loadedCount := len(s.loaded)
+	s.loadedMu.Unlock()
+	if runner != nil {
+		if runner.needsReload(ctx, pending) {
+			runnerToExpire = runner
+		} else {
+			// Runner is usable, return it
+			pending.useLoadedRunner(runner, s.finishedReqCh)
+			return nil
+		}
+	} else if envconfig.MaxRunners() > 0 && loadedCount >= int(envconfig.MaxRunners()) {
+		schedLog.Debug("max runners achieved, unloading one to make room", "runner_count", loadedCount)
+		runnerToExpire = s.findRunnerToUnload()
+	} else {
+		// Either no models are loaded or below envconfig.MaxRunners
+		// Get a refreshed GPU list
+		var gpus discover.GpuInfoList
+		if pending.opts.NumGPU == 0 {
+			gpus = s.getCpuFn()
+		} else {
+			gpus = s.getGpuFn()
+		}
+
+		if envconfig.MaxRunners() <= 0 {
+			// No user specified MaxRunners, so figure out what automatic setting to use
+			// If all GPUs have reliable free memory reporting, defaultModelsPerGPU * the number of GPUs
+			// if any GPU has unreliable free memory reporting, 1x the number of GPUs
+			allReliable := true
+			for _, gpu := range gpus {
+				if gpu.UnreliableFreeMemory {
+					allReliable = false
+					break
 				}
 			}
-		case <-s.unloadedCh:
-			// An unload request when there are no pending request can be ignored
-			slog.Debug("ignoring unload event with no pending requests")
+			if allReliable {
+				// HACK
+				os.Setenv("OLLAMA_MAX_LOADED_MODELS", strconv.Itoa(defaultModelsPerGPU*len(gpus)))
+				schedLog.Debug("updating default concurrency", "OLLAMA_MAX_LOADED_MODELS", envconfig.MaxRunners(), "gpu_count", len(gpus))
+			} else {
+				// HACK
+				os.Setenv("OLLAMA_MAX_LOADED_MODELS", strconv.Itoa(len(gpus)))
+				schedLog.Info("one or more GPUs detected that are unable to accurately report free memory - disabling default concurrency")
+			}
+		}
+
+		// Load model for fitting
+		ggml, err := llm.LoadModel(pending.model.ModelPath, 0)
+		if err != nil {
+			pending.errCh <- err
+			return nil
+		}
+
+		// Embedding models should always be loaded with parallel=1
+		if pending.model.CheckCapabilities(CapabilityCompletion) != nil {
+			numParallel = 1
+		}
+
+		// Evaluate if the model will fit in the available system memory, or if we should unload a model first
+		if len(gpus) == 1 && gpus[0].Library == "cpu" {
+			// simplifying assumption of defaultParallel when in CPU mode
+			if numParallel <= 0 {
+				numParallel = defaultParallel
+			}
+
+			pending.opts.NumCtx = pending.origNumCtx * numParallel
+
+			if loadedCount == 0 {
+				schedLog.Debug("cpu mode with first model, loading")
+				s.loadFn(pending, ggml, gpus, numParallel)
+				return nil
+			}
+			runnerToExpire = s.maybeFindCPURunnerToUnload(pending, ggml, gpus)
+			if runnerToExpire == nil {
+				schedLog.Debug("cpu mode with available system memory or first model, loading")
+				s.loadFn(pending, ggml, gpus, numParallel)
+				return nil
+			}
+			// else we need to expire a runner
+		} else if loadedCount == 0 {
+			// No models loaded. Load the model but prefer the best fit.
+			schedLog.Debug("loading first model", "model", pending.model.ModelPath)
+			g := pickBestFullFitByLibrary(pending, ggml, gpus, &numParallel)
+			if g != nil {
+				gpus = g
+			} else {
+				// Only allow partial loads when this is the first model
+				gpus = pickBestPartialFitByLibrary(pending, ggml, gpus, &numParallel)
+			}
+			s.loadFn(pending, ggml, gpus, numParallel)
+			return nil
+		}
+
+		if runnerToExpire == nil {
+			// More than one loaded model, so we have to see if the
+			// new one fits
+			//
+			// We want to avoid loading on any GPUs that have other
+			// models still loading on them to avoid potential races
+			// with VRAM consumption ramping up during load
+			availGpus := s.filterGPUsWithoutLoadingModels(gpus)
+
+			// Update free memory from currently loaded models
+			s.updateFreeSpace(availGpus)
+			if numParallel <= 0 && len(availGpus) > 0 {
+				numParallel = fairParallel(pending, ggml, availGpus)
+			}
+			fitGpus := pickBestFullFitByLibrary(pending, ggml, availGpus, &numParallel)
+			if fitGpus != nil {
+				schedLog.Debug("new model fits with existing models, loading")
+				s.loadFn(pending, ggml, fitGpus, numParallel)
+				return nil
+			}
+
+			// We couldn't find a set of GPUs to fully load the new
+			// model. If no other models are loading (both GPU lists
+			// are the same) then we need to unload another model to
+			// make room
+			if len(availGpus) < len(gpus) {
+				// There are other requests pending, and this one
+				// needs more time, so put it on the back of the
+				// queue so that we might satisfy other pending
+				// requests that aren't blocked
+				go func() {
+					// Process in a go routine to avoid deadlocking
+					// the scheduler if our queue is full
+					schedLog.Debug("delaying scheduling while other models finish loading", "attempts", pending.schedAttempts, "model", pending.model.ModelPath)
+					time.Sleep(s.reschedDelay)
+					s.pendingReqCh <- pending
+				}()
+				return nil
+			}
+			runnerToExpire = s.findRunnerToUnload()
 		}
 	}
+
+	if runnerToExpire == nil {
+		// Shouldn't happen, so try again later rather than spinning
+		schedLog.Error("runner to expire was nil!")
+		go func() {
+			time.Sleep(s.reschedDelay)
+			s.pendingReqCh <- pending
+		}()
+	}
+
+	return runnerToExpire
 }
 
 func (s *Scheduler) processCompleted(ctx context.Context) {
@@ -316,30 +404,30 @@ func (s *Scheduler) processCompleted(ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
-			slog.Debug("shutting down scheduler completed loop")
+			schedLog.Debug("shutting down scheduler completed loop")
 			return
 		case finished := <-s.finishedReqCh:
 			s.loadedMu.Lock()
 			runner := s.loaded[finished.model.ModelPath]
 			s.loadedMu.Unlock()
 			if runner == nil {
-				slog.Error("finished request signal received after model unloaded", "modelPath", finished.model.ModelPath)
+				schedLog.Error("finished request signal received after model unloaded", "modelPath", finished.model.ModelPath)
 				continue
 			}
 			runner.refMu.Lock()
 			runner.refCount--
 			if runner.refCount <= 0 {
 				if runner.sessionDuration <= 0 {
-					slog.Debug("runner with zero duration has gone idle, expiring to unload", "modelPath", runner.modelPath)
+					schedLog.Debug("runner with zero duration has gone idle, expiring to unload", "modelPath", runner.modelPath)
 					if runner.expireTimer != nil {
 						runner.expireTimer.Stop()
 						runner.expireTimer = nil
 					}
 					s.expiredCh <- runner
 				} else if runner.expireTimer == nil {
-					slog.Debug("runner with non-zero duration has gone idle, adding timer", "modelPath", runner.modelPath, "duration", runner.sessionDuration)
+					schedLog.Debug("runner with non-zero duration has gone idle, adding timer", "modelPath", runner.modelPath, "duration", runner.sessionDuration)
 					runner.expireTimer = time.AfterFunc(runner.sessionDuration, func() {
-						slog.Debug("timer expired, expiring to unload", "modelPath", runner.modelPath)
+						schedLog.Debug("timer expired, expiring to unload", "modelPath", runner.modelPath)
 						runner.refMu.Lock()
 						defer runner.refMu.Unlock()
 						if runner.expireTimer != nil {
@@ -350,18 +438,18 @@ func (s *Scheduler) processCompleted(ctx context.Context) {
 					})
 					runner.expiresAt = time.Now().Add(runner.sessionDuration)
 				} else {
-					slog.Debug("runner with non-zero duration has gone idle, resetting timer", "modelPath", runner.modelPath, "duration", runner.sessionDuration)
+					schedLog.Debug("runner with non-zero duration has gone idle, resetting timer", "modelPath", runner.modelPath, "duration", runner.sessionDuration)
 					runner.expireTimer.Reset(runner.sessionDuration)
 					runner.expiresAt = time.Now().Add(runner.sessionDuration)
 				}
 			}
-			slog.Debug("after processing request finished event", "modelPath", runner.modelPath, "refCount", runner.refCount)
+			schedLog.Debug("after processing request finished event", "modelPath", runner.modelPath, "refCount", runner.refCount)
 			runner.refMu.Unlock()
 		case runner := <-s.expiredCh:
-			slog.Debug("runner expired event received", "modelPath", runner.modelPath)
+			schedLog.Debug("runner expired event received", "modelPath", runner.modelPath)
 			runner.refMu.Lock()
 			if runner.refCount > 0 {
-				slog.Debug("expired event with positive ref count, retrying", "modelPath", runner.modelPath, "refCount", runner.refCount)
+				schedLog.Debug("expired event with positive ref count, retrying", "modelPath", runner.modelPath, "refCount", runner.refCount)
 				go func(runner *runnerRef) {
 					// We can't unload yet, but want to as soon as the current request completes
 					// So queue up another expired event
@@ -373,16 +461,16 @@ func (s *Scheduler) processCompleted(ctx context.Context) {
 			}
 
 			s.loadedMu.Lock()
-			slog.Debug("got lock to unload", "modelPath", runner.modelPath)
+			schedLog.Debug("got lock to unload", "modelPath", runner.modelPath)
 			finished := runner.waitForVRAMRecovery()
 			runner.unload()
 			delete(s.loaded, runner.modelPath)
 			s.loadedMu.Unlock()
-			slog.Debug("runner released", "modelPath", runner.modelPath)
+			schedLog.Debug("runner released", "modelPath", runner.modelPath)
 			runner.refMu.Unlock()
 
 			<-finished
-			slog.Debug("sending an unloaded event", "modelPath", runner.modelPath)
+			schedLog.Debug("sending an unloaded event", "modelPath", runner.modelPath)
 			s.unloadedCh <- struct{}{}
 		}
 	}
@@ -405,7 +493,7 @@ func (pending *LlmRequest) useLoadedRunner(runner *runnerRef, finished chan *Llm
 	pending.successCh <- runner
 	go func() {
 		<-pending.ctx.Done()
-		slog.Debug("context for request finished")
+		schedLog.Debug("context for request finished")
 		finished <- pending
 	}()
 }
@@ -418,6 +506,16 @@ func (s *Scheduler) load(req *LlmRequest, f *ggml.GGML, gpus discover.GpuInfoLis
 	if req.sessionDuration != nil {
 		sessionDuration = req.sessionDuration.Duration
 	}
+	if envconfig.VerifyTensors() {
+		for _, path := range append([]string{req.model.ModelPath}, req.model.ProjectorPaths...) {
+			if err := verifyTensors(req.ctx, path); err != nil {
+				schedLog.Error("model verification failed", "model", req.model.ModelPath, "error", err)
+				req.errCh <- fmt.Errorf("%w: try removing and pulling the model again", err)
+				return
+			}
+		}
+	}
+
 	llama, err := s.newServerFn(gpus, req.model.ModelPath, f, req.model.AdapterPaths, req.model.ProjectorPaths, req.opts, numParallel)
 	if err != nil {
 		// some older models are not compatible with newer versions of llama.cpp
@@ -426,7 +524,7 @@ func (s *Scheduler) load(req *LlmRequest, f *ggml.GGML, gpus discover.GpuInfoLis
 		if errors.Is(err, ggml.ErrUnsupportedFormat) || strings.Contains(err.Error(), "failed to load model") {
 			err = fmt.Errorf("%v: this model may be incompatible with your version of Ollama. If you previously pulled this model, try updating it by running `ollama pull %s`", err, req.model.ShortName)
 		}
-		slog.Info("NewLlamaServer failed", "model", req.model.ModelPath, "error", err)
+		schedLog.Info("NewLlamaServer failed", "model", req.model.ModelPath, "error", err)
 		req.errCh <- err
 		return
 	}
@@ -441,30 +539,31 @@ func (s *Scheduler) load(req *LlmRequest, f *ggml.GGML, gpus discover.GpuInfoLis
 		estimatedTotal:  llama.EstimatedTotal(),
 		loading:         true,
 		refCount:        1,
+		unifiedMemory:   llama.Runtime().UnifiedMemory,
 	}
 	runner.numParallel = numParallel
 	runner.refMu.Lock()
 
 	s.loadedMu.Lock()
 	s.loaded[req.model.ModelPath] = runner
-	slog.Info("loaded runners", "count", len(s.loaded))
+	schedLog.Info("loaded runners", "count", len(s.loaded))
 	s.loadedMu.Unlock()
 
 	go func() {
 		defer runner.refMu.Unlock()
 		if err = llama.WaitUntilRunning(req.ctx); err != nil {
-			slog.Error("error loading llama server", "error", err)
+			schedLog.Error("error loading llama server", "error", err)
 			runner.refCount--
 			req.errCh <- err
-			slog.Debug("triggering expiration for failed load", "model", runner.modelPath)
+			schedLog.Debug("triggering expiration for failed load", "model", runner.modelPath)
 			s.expiredCh <- runner
 			return
 		}
-		slog.Debug("finished setting up runner", "model", req.model.ModelPath)
+		schedLog.Debug("finished setting up runner", "model", req.model.ModelPath)
 		runner.loading = false
 		go func() {
 			<-req.ctx.Done()
-			slog.Debug("context for request finished")
+			schedLog.Debug("context for request finished")
 			s.finishedReqCh <- req
 		}()
 		req.successCh <- runner
@@ -485,7 +584,7 @@ func (s *Scheduler) updateFreeSpace(allGpus discover.GpuInfoList) {
 				predMap[predKey{gpu.Library, gpu.ID}] += r.llama.EstimatedVRAMByGPU(gpu.ID)
 			}
 		} else {
-			slog.Warn("unexpected nil runner reference, memory prediction may be incorrect")
+			schedLog.Warn("unexpected nil runner reference, memory prediction may be incorrect")
 		}
 		r.refMu.Unlock()
 	}
@@ -494,10 +593,10 @@ func (s *Scheduler) updateFreeSpace(allGpus discover.GpuInfoList) {
 	// Now that we've summed up all the GPU usage predictions across all the loaded runners, update the gpu list
 	for i := range allGpus {
 		if p, ok := predMap[predKey{allGpus[i].Library, allGpus[i].ID}]; ok {
-			slog.Debug("gpu reported", "gpu", allGpus[i].ID, "library", allGpus[i].Library, "available", format.HumanBytes2(allGpus[i].FreeMemory))
+			schedLog.Debug("gpu reported", "gpu", allGpus[i].ID, "library", allGpus[i].Library, "available", format.HumanBytes2(allGpus[i].FreeMemory))
 			if p > allGpus[i].TotalMemory {
 				// Shouldn't happen
-				slog.Warn("predicted usage exceeds VRAM", "gpu", allGpus[i].ID, "totalMemory", allGpus[i].TotalMemory, "predicted", p)
+				schedLog.Warn("predicted usage exceeds VRAM", "gpu", allGpus[i].ID, "totalMemory", allGpus[i].TotalMemory, "predicted", p)
 				allGpus[i].FreeMemory = 0
 			} else if (allGpus[i].TotalMemory - p) < allGpus[i].FreeMemory { // predicted free is smaller than reported free, use it
 				// TODO maybe we should just always trust our numbers, since cuda's free memory reporting is laggy
@@ -505,9 +604,75 @@ func (s *Scheduler) updateFreeSpace(allGpus discover.GpuInfoList) {
 				// after we start our first runner, then we'll never account for that, so picking the smallest free value seems prudent.
 				allGpus[i].FreeMemory = allGpus[i].TotalMemory - p
 			}
-			slog.Info("updated VRAM based on existing loaded models", "gpu", allGpus[i].ID, "library", allGpus[i].Library, "total", format.HumanBytes2(allGpus[i].TotalMemory), "available", format.HumanBytes2(allGpus[i].FreeMemory))
+			schedLog.Info("updated VRAM based on existing loaded models", "gpu", allGpus[i].ID, "library", allGpus[i].Library, "total", format.HumanBytes2(allGpus[i].TotalMemory), "available", format.HumanBytes2(allGpus[i].FreeMemory))
+		}
+	}
+}
+
+// gpusFor returns the GPUs the runner for m is loaded on.
+func (s *Scheduler) gpusFor(m *Model) discover.GpuInfoList {
+	s.loadedMu.Lock()
+	defer s.loadedMu.Unlock()
+	if r, ok := s.loaded[m.ModelPath]; ok {
+		return r.gpus
+	}
+
+	return nil
+}
+
+// runners returns the runners that are loaded, ordered by model path.
+func (s *Scheduler) runners() []*runnerRef {
+	s.loadedMu.Lock()
+	defer s.loadedMu.Unlock()
+
+	runners := make([]*runnerRef, 0, len(s.loaded))
+	for _, r := range s.loaded {
+		runners = append(runners, r)
+	}
+
+	sort.Slice(runners, func(i, j int) bool {
+		return runners[i].modelPath < runners[j].modelPath
+	})
+
+	return runners
+}
+
+// fits reports whether m, whose model file decodes to f, could be loaded
+// entirely into GPU memory, or into system memory if there are no GPUs, once
+// idle runners have been unloaded to make room for it. Memory held by runners
+// that are in use is not available.
+func (s *Scheduler) fits(m *Model, f *ggml.GGML, opts api.Options) bool {
+	gpus := s.getGpuFn()
+	if opts.NumGPU == 0 {
+		gpus = s.getCpuFn()
+	}
+
+	busy := make(map[string]uint64)
+	var busySystem uint64
+	s.loadedMu.Lock()
+	for _, r := range s.loaded {
+		r.refMu.Lock()
+		if r.refCount > 0 && r.llama != nil {
+			for _, gpu := range gpus {
+				busy[gpu.ID] += r.llama.EstimatedVRAMByGPU(gpu.ID)
+			}
+			busySystem += r.estimatedTotal - r.estimatedVRAM
 		}
+		r.refMu.Unlock()
 	}
+	s.loadedMu.Unlock()
+
+	if gpus[0].Library == "cpu" {
+		gpus[0].FreeMemory = gpus[0].TotalMemory - min(busySystem, gpus[0].TotalMemory)
+		return llm.EstimateGPULayers(gpus, f, m.ProjectorPaths, opts).TotalSize <= gpus[0].FreeMemory
+	}
+
+	for i := range gpus {
+		gpus[i].FreeMemory = gpus[i].TotalMemory - min(busy[gpus[i].ID], gpus[i].TotalMemory)
+	}
+
+	ok, _ := llm.PredictServerFit(gpus, f, m.AdapterPaths, m.ProjectorPaths, opts)
+	return ok
 }
 
 // While models are loading the VRAM consumption numbers will be indeterminate, so we have
@@ -520,7 +685,7 @@ func (s *Scheduler) filterGPUsWithoutLoadingModels(allGpus discover.GpuInfoList)
 	defer s.loadedMu.Unlock()
 	for _, runner := range s.loaded {
 		if runner.loading {
-			slog.Debug("overlapping loads detected", "gpus", runner.gpus, "model", runner.modelPath)
+			schedLog.Debug("overlapping loads detected", "gpus", runner.gpus, "model", runner.modelPath)
 			for _, busyGPU := range runner.gpus {
 				for i := range ret {
 					if ret[i].ID == busyGPU.ID {
@@ -555,6 +720,10 @@ type runnerRef struct {
 	modelPath   string
 	numParallel int
 	*api.Options
+
+	// unifiedMemory is set for models larger than the free VRAM that were
+	// loaded into unified memory. They are unloaded first to make room.
+	unifiedMemory bool
 }
 
 // The refMu must already be held when calling unload
@@ -573,7 +742,7 @@ func (runner *runnerRef) unload() {
 }
 
 func (runner *runnerRef) needsReload(ctx context.Context, req *LlmRequest) bool {
-	slog.Debug("evaluating already loaded", "model", req.model.ModelPath)
+	schedLog.Debug("evaluating already loaded", "model", req.model.ModelPath)
 	runner.refMu.Lock()
 	defer runner.refMu.Unlock()
 
@@ -597,6 +766,11 @@ func (runner *runnerRef) needsReload(ctx context.Context, req *LlmRequest) bool
 	// Normalize the NumCtx for parallelism
 	optsExisting.NumCtx = optsExisting.NumCtx / runner.numParallel
 
+	// Requests that don't set num_parallel can use any runner
+	if optsNew.NumParallel == 0 {
+		optsNew.NumParallel = optsExisting.NumParallel
+	}
+
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 	if !reflect.DeepEqual(runner.model.AdapterPaths, req.model.AdapterPaths) || // have the adapters changed?
@@ -644,7 +818,7 @@ func (runner *runnerRef) waitForVRAMRecovery() chan interface{} {
 		for {
 			<-ticker.C
 			if time.Now().After(expiresAt) {
-				slog.Warn("gpu VRAM usage didn't recover within timeout", "seconds", time.Since(start).Seconds(), "model", runner.modelPath)
+				schedLog.Warn("gpu VRAM usage didn't recover within timeout", "seconds", time.Since(start).Seconds(), "model", runner.modelPath)
 				finished <- struct{}{}
 			}
 
@@ -657,7 +831,7 @@ func (runner *runnerRef) waitForVRAMRecovery() chan interface{} {
 			}
 			// If we're within ~80% of the estimated memory usage recovered, bail out
 			if float32(freeMemoryNow-freeMemoryBefore) > float32(runner.estimatedVRAM)*0.8 {
-				slog.Debug(fmt.Sprintf("gpu VRAM free memory converged after %0.2f seconds", time.Since(start).Seconds()), "model", runner.modelPath)
+				schedLog.Debug(fmt.Sprintf("gpu VRAM free memory converged after %0.2f seconds", time.Since(start).Seconds()), "model", runner.modelPath)
 				finished <- struct{}{}
 				return
 			}
@@ -712,7 +886,7 @@ func pickBestFullFitByLibrary(req *LlmRequest, f *ggml.GGML, gpus discover.GpuIn
 			if !envconfig.SchedSpread() {
 				for _, g := range sgl {
 					if ok, estimatedVRAM = llm.PredictServerFit([]discover.GpuInfo{g}, f, req.model.AdapterPaths, req.model.ProjectorPaths, req.opts); ok {
-						slog.Info("new model will fit in available VRAM in single GPU, loading", "model", req.model.ModelPath, "gpu", g.ID, "parallel", p, "available", g.FreeMemory, "required", format.HumanBytes2(estimatedVRAM))
+						schedLog.Info("new model will fit in available VRAM in single GPU, loading", "model", req.model.ModelPath, "gpu", g.ID, "parallel", p, "available", g.FreeMemory, "required", format.HumanBytes2(estimatedVRAM))
 						*numParallel = p
 						return []discover.GpuInfo{g}
 					}
@@ -728,7 +902,7 @@ func pickBestFullFitByLibrary(req *LlmRequest, f *ggml.GGML, gpus discover.GpuIn
 		for _, p := range numParallelToTry {
 			req.opts.NumCtx = req.origNumCtx * p
 			if ok, estimatedVRAM = llm.PredictServerFit(sgl, f, req.model.AdapterPaths, req.model.ProjectorPaths, req.opts); ok {
-				slog.Info("new model will fit in available VRAM, loading", "model", req.model.ModelPath, "library", sgl[0].Library, "parallel", p, "required", format.HumanBytes2(estimatedVRAM))
+				schedLog.Info("new model will fit in available VRAM, loading", "model", req.model.ModelPath, "library", sgl[0].Library, "parallel", p, "required", format.HumanBytes2(estimatedVRAM))
 				*numParallel = p
 				return sgl
 			}
@@ -737,6 +911,30 @@ func pickBestFullFitByLibrary(req *LlmRequest, f *ggml.GGML, gpus discover.GpuIn
 	return nil
 }
 
+// fairParallel picks the number of parallel requests for a model that is
+// loaded next to other models when it wasn't set. Parallel requests multiply
+// the context, so the model only gets defaultParallel if it then fits in its
+// fair share of the GPUs: their memory divided by the number of models that
+// may be loaded on each, or less if less is free.
+func fairParallel(req *LlmRequest, f *ggml.GGML, gpus discover.GpuInfoList) int {
+	modelsPerGPU := max(1, (int(envconfig.MaxRunners())+len(gpus)-1)/len(gpus))
+
+	shares := make(discover.GpuInfoList, len(gpus))
+	for i, g := range gpus {
+		g.FreeMemory = min(g.FreeMemory, g.TotalMemory/uint64(modelsPerGPU))
+		shares[i] = g
+	}
+
+	opts := req.opts
+	opts.NumCtx = req.origNumCtx * defaultParallel
+	if ok, estimatedVRAM := llm.PredictServerFit(shares, f, req.model.AdapterPaths, req.model.ProjectorPaths, opts); !ok {
+		schedLog.Debug("model exceeds its share of VRAM with parallel requests", "model", req.model.ModelPath, "parallel", defaultParallel, "required", format.HumanBytes2(estimatedVRAM))
+		return 1
+	}
+
+	return defaultParallel
+}
+
 // If multiple Libraries are detected, pick the Library which loads the most layers for the model
 func pickBestPartialFitByLibrary(req *LlmRequest, f *ggml.GGML, gpus discover.GpuInfoList, numParallel *int) discover.GpuInfoList {
 	if *numParallel <= 0 {
@@ -768,7 +966,7 @@ func (s *Scheduler) findRunnerToUnload() *runnerRef {
 	}
 	s.loadedMu.Unlock()
 	if len(runnerList) == 0 {
-		slog.Debug("no loaded runner to unload")
+		schedLog.Debug("no loaded runner to unload")
 		return nil
 	}
 
@@ -776,18 +974,29 @@ func (s *Scheduler) findRunnerToUnload() *runnerRef {
 	// e.g., if we have multiple options, will one make room for the request?
 	sort.Sort(ByDuration(runnerList))
 
+	// Models that overflow VRAM run slower than models that fit, so make
+	// room by unloading them first
+	sort.SliceStable(runnerList, func(i, j int) bool {
+		return runnerList[i].unifiedMemory && !runnerList[j].unifiedMemory
+	})
+
+	// Warmed models were prepared ahead of use so unload other models first
+	sort.SliceStable(runnerList, func(i, j int) bool {
+		return !s.warm.isWarm(runnerList[i].modelPath) && s.warm.isWarm(runnerList[j].modelPath)
+	})
+
 	// First try to find a runner that's already idle
 	for _, runner := range runnerList {
 		runner.refMu.Lock()
 		rc := runner.refCount
 		runner.refMu.Unlock()
 		if rc == 0 {
-			slog.Debug("found an idle runner to unload")
+			schedLog.Debug("found an idle runner to unload", "warm", s.warm.isWarm(runner.modelPath))
 			return runner
 		}
 	}
 	// None appear idle, just wait for the one with the shortest duration
-	slog.Debug("no idle runners, picking the shortest duration", "count", len(runnerList))
+	schedLog.Debug("no idle runners, picking the shortest duration", "count", len(runnerList))
 	return runnerList[0]
 }
 
@@ -796,7 +1005,7 @@ func (s *Scheduler) unloadAllRunners() {
 	defer s.loadedMu.Unlock()
 	for model, runner := range s.loaded {
 		if runner.llama != nil {
-			slog.Debug("shutting down runner", "model", model)
+			schedLog.Debug("shutting down runner", "model", model)
 			runner.llama.Close()
 		}
 	}
@@ -824,10 +1033,10 @@ func (s *Scheduler) expireRunner(model *Model) {
 // If other runners are loaded, make sure the pending request will fit in system memory
 // If not, pick a runner to unload, else return nil and the request can be loaded
 func (s *Scheduler) maybeFindCPURunnerToUnload(req *LlmRequest, f *ggml.GGML, gpus discover.GpuInfoList) *runnerRef {
-	slog.Debug("evaluating if CPU model load will fit in available system memory")
+	schedLog.Debug("evaluating if CPU model load will fit in available system memory")
 	estimate := llm.EstimateGPULayers(gpus, f, req.model.ProjectorPaths, req.opts)
 	if estimate.TotalSize <= gpus[0].FreeMemory {
-		slog.Debug("cpu inference mode, model fits in available system memory", "model", format.HumanBytes2(estimate.TotalSize), "available", format.HumanBytes2(gpus[0].FreeMemory))
+		schedLog.Debug("cpu inference mode, model fits in available system memory", "model", format.HumanBytes2(estimate.TotalSize), "available", format.HumanBytes2(gpus[0].FreeMemory))
 		return nil
 	}
 
@@ -14,6 +14,7 @@ import (
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/app/lifecycle"
 	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/format"
 	"github.com/ollama/ollama/fs/ggml"
 	"github.com/ollama/ollama/llm"
@@ -354,6 +355,85 @@ func TestRequestsMultipleLoadedModels(t *testing.T) {
 	s.loadedMu.Unlock()
 }
 
+func TestRequestsLoadedModelWhileWaiting(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer done()
+	s := InitScheduler(ctx)
+	s.getGpuFn = getGpuFn
+	s.getCpuFn = getCpuFn
+	t.Setenv("OLLAMA_MAX_LOADED_MODELS", "2")
+
+	a := newScenarioRequest(t, ctx, "ollama-model-1", 10, nil)
+	b := newScenarioRequest(t, ctx, "ollama-model-2", 10, &api.Duration{Duration: time.Minute})
+	b.req.opts.NumParallel = 2
+	c := newScenarioRequest(t, ctx, "ollama-model-3", 10, nil)
+	d := newScenarioRequest(t, ctx, "ollama-model-2", 10, nil)
+	d.req.model = b.req.model
+
+	s.Run(ctx)
+	for _, r := range []*reqBundle{a, b} {
+		s.newServerFn = r.newServer
+		s.pendingReqCh <- r.req
+		select {
+		case resp := <-r.req.successCh:
+			require.Equal(t, resp.llama, r.srv)
+		case err := <-r.req.errCh:
+			t.Fatal(err.Error())
+		case <-ctx.Done():
+			t.Fatal("timeout")
+		}
+	}
+
+	s.loadedMu.Lock()
+	require.Equal(t, 2, s.loaded[b.req.model.ModelPath].numParallel)
+	s.loadedMu.Unlock()
+
+	// c has to wait for a to unload, which is still in use
+	s.newServerFn = c.newServer
+	s.pendingReqCh <- c.req
+	time.Sleep(5 * time.Millisecond)
+	require.Empty(t, c.req.successCh)
+
+	// d doesn't wait behind c since its model is loaded
+	s.pendingReqCh <- d.req
+	select {
+	case resp := <-d.req.successCh:
+		require.Equal(t, resp.llama, b.srv)
+	case err := <-d.req.errCh:
+		t.Fatal(err.Error())
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	a.ctxDone()
+	select {
+	case resp := <-c.req.successCh:
+		require.Equal(t, resp.llama, c.srv)
+		require.Empty(t, c.req.errCh)
+	case err := <-c.req.errCh:
+		t.Fatal(err.Error())
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+}
+
+func TestFairParallel(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer done()
+	t.Setenv("OLLAMA_MAX_LOADED_MODELS", "3")
+
+	a := newScenarioRequest(t, ctx, "ollama-model-1", 10, nil)
+	a.req.origNumCtx = a.req.opts.NumCtx
+
+	gpus := getGpuFn()
+	require.Equal(t, defaultParallel, fairParallel(a.req, a.f, gpus))
+
+	// the share of a small GPU is too small for parallel requests
+	gpus[0].TotalMemory = 3 * format.MebiByte
+	require.Equal(t, 1, fairParallel(a.req, a.f, gpus))
+	require.Equal(t, a.req.origNumCtx, a.req.opts.NumCtx)
+}
+
 func TestGetRunner(t *testing.T) {
 	ctx, done := context.WithTimeout(context.Background(), 200*time.Millisecond)
 	defer done()
@@ -615,6 +695,47 @@ func TestFindRunnerToUnload(t *testing.T) {
 	require.Equal(t, r1, resp)
 }
 
+func TestFindRunnerToUnloadUnifiedMemory(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer done()
+
+	r1 := &runnerRef{modelPath: "a", sessionDuration: 1, numParallel: 1}
+	r2 := &runnerRef{modelPath: "b", sessionDuration: 2, numParallel: 1, unifiedMemory: true}
+
+	s := InitScheduler(ctx)
+	s.loadedMu.Lock()
+	s.loaded["a"] = r1
+	s.loaded["b"] = r2
+	s.loadedMu.Unlock()
+
+	require.Equal(t, r2, s.findRunnerToUnload())
+
+	r2.refCount = 1
+	require.Equal(t, r1, s.findRunnerToUnload())
+}
+
+func TestFindRunnerToUnloadWarm(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer done()
+
+	r1 := &runnerRef{modelPath: "a", sessionDuration: 1, numParallel: 1}
+	r2 := &runnerRef{modelPath: "b", sessionDuration: 2, numParallel: 1}
+
+	s := InitScheduler(ctx)
+	s.loadedMu.Lock()
+	s.loaded["a"] = r1
+	s.loaded["b"] = r2
+	s.loadedMu.Unlock()
+
+	require.Equal(t, r1, s.findRunnerToUnload())
+
+	s.warm.set("a", nil, 0)
+	require.Equal(t, r2, s.findRunnerToUnload())
+
+	r2.refCount = 1
+	require.Equal(t, r1, s.findRunnerToUnload())
+}
+
 func TestNeedsReload(t *testing.T) {
 	ctx, done := context.WithTimeout(context.Background(), 100*time.Millisecond)
 	defer done()
@@ -660,6 +781,15 @@ func TestNeedsReload(t *testing.T) {
 	req.opts.NumGPU = -1
 	resp = runner.needsReload(ctx, req)
 	require.False(t, resp)
+	req.opts.NumParallel = 2
+	resp = runner.needsReload(ctx, req)
+	require.True(t, resp)
+	runner.Options.NumParallel = 2
+	resp = runner.needsReload(ctx, req)
+	require.False(t, resp)
+	req.opts.NumParallel = 0
+	resp = runner.needsReload(ctx, req)
+	require.False(t, resp)
 }
 
 func TestUnloadAllRunners(t *testing.T) {
@@ -750,12 +880,48 @@ func TestHomogeneousGPUs(t *testing.T) {
 	}
 }
 
+func TestUnreliableFreeMemory(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer done()
+	t.Setenv("OLLAMA_MAX_LOADED_MODELS", "0")
+	s := InitScheduler(ctx)
+
+	s.getGpuFn = func() discover.GpuInfoList {
+		g := discover.GpuInfo{Library: "rocm", UnreliableFreeMemory: true}
+		g.TotalMemory = 24 * format.GigaByte
+		g.FreeMemory = 12 * format.GigaByte
+		return []discover.GpuInfo{g}
+	}
+	s.getCpuFn = getCpuFn
+	a := newScenarioRequest(t, ctx, "ollama-model-1", 10, &api.Duration{Duration: 5 * time.Millisecond})
+	s.newServerFn = a.newServer
+	s.pendingReqCh <- a.req
+	s.Run(ctx)
+	select {
+	case resp := <-a.req.successCh:
+		require.Equal(t, resp.llama, a.srv)
+		require.Empty(t, a.req.errCh)
+	case err := <-a.req.errCh:
+		t.Fatal(err.Error())
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+
+	// default concurrency is disabled rather than scaled by the GPUs
+	require.Equal(t, uint(1), envconfig.MaxRunners())
+}
+
 type mockLlm struct {
 	pingResp           error
 	waitResp           error
 	completionResp     error
 	embeddingResp      []float32
 	embeddingRespErr   error
+	scoreResp          *llm.ScoreResponse
+	scoreRespErr       error
+	classifyResp       *llm.ClassifyResponse
+	classifyRespErr    error
+	finetuneRespErr    error
 	tokenizeResp       []int
 	tokenizeRespErr    error
 	detokenizeResp     string
@@ -765,6 +931,8 @@ type mockLlm struct {
 	estimatedVRAM      uint64
 	estimatedTotal     uint64
 	estimatedVRAMByGPU map[string]uint64
+	loadProgress       float32
+	runtime            api.ProcessModelRuntime
 }
 
 func (s *mockLlm) Ping(ctx context.Context) error             { return s.pingResp }
@@ -777,6 +945,22 @@ func (s *mockLlm) Embedding(ctx context.Context, input string) ([]float32, error
 	return s.embeddingResp, s.embeddingRespErr
 }
 
+func (s *mockLlm) Embeddings(ctx context.Context, inputs []string) ([]llm.EmbeddingResult, error) {
+	return nil, s.embeddingRespErr
+}
+
+func (s *mockLlm) Score(ctx context.Context, req llm.ScoreRequest) (*llm.ScoreResponse, error) {
+	return s.scoreResp, s.scoreRespErr
+}
+
+func (s *mockLlm) Classify(ctx context.Context, input string) (*llm.ClassifyResponse, error) {
+	return s.classifyResp, s.classifyRespErr
+}
+
+func (s *mockLlm) Finetune(ctx context.Context, req llm.FinetuneRequest, fn func(llm.FinetuneResponse)) error {
+	return s.finetuneRespErr
+}
+
 func (s *mockLlm) Tokenize(ctx context.Context, content string) ([]int, error) {
 	return s.tokenizeResp, s.tokenizeRespErr
 }
@@ -785,6 +969,13 @@ func (s *mockLlm) Detokenize(ctx context.Context, tokens []int) (string, error)
 	return s.detokenizeResp, s.detonekizeRespErr
 }
 
+func (s *mockLlm) CacheSlots(ctx context.Context) ([]llm.CacheSlot, error) { return nil, nil }
+func (s *mockLlm) PinCache(ctx context.Context, key string, pinned bool) error {
+	return nil
+}
+func (s *mockLlm) EvictCache(ctx context.Context, key string) error { return nil }
+func (s *mockLlm) SaveImage(ctx context.Context, path string) error { return nil }
+
 func (s *mockLlm) Close() error {
 	s.closeCalled = true
 	return s.closeResp
@@ -792,3 +983,5 @@ func (s *mockLlm) Close() error {
 func (s *mockLlm) EstimatedVRAM() uint64                  { return s.estimatedVRAM }
 func (s *mockLlm) EstimatedTotal() uint64                 { return s.estimatedTotal }
 func (s *mockLlm) EstimatedVRAMByGPU(gpuid string) uint64 { return s.estimatedVRAMByGPU[gpuid] }
+func (s *mockLlm) LoadProgress() float32                  { return s.loadProgress }
+func (s *mockLlm) Runtime() api.ProcessModelRuntime       { return s.runtime }
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/ollama/ollama/api"
+)
+
+// searchLimit is the default number of models returned by /api/search
+const searchLimit = 20
+
+func (s *Server) SearchHandler(c *gin.Context) {
+	var req api.SearchRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Limit < 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "limit must not be negative"})
+		return
+	}
+
+	scheme, registry := DefaultProtocolScheme, cmp.Or(req.Registry, DefaultRegistry)
+	if before, after, ok := strings.Cut(registry, "://"); ok {
+		scheme, registry = before, after
+	}
+
+	host, namespace, _ := strings.Cut(registry, "/")
+	if host == "" || strings.Contains(namespace, "/") {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid registry %q", req.Registry)})
+		return
+	}
+
+	base := &url.URL{Scheme: scheme, Host: host}
+	repos, err := registryCatalog(c.Request.Context(), base)
+	if errors.Is(err, os.ErrNotExist) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("registry %s does not support listing its models", base.Host)})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	repos = slices.DeleteFunc(repos, func(repo string) bool {
+		// models are named by a namespace and a repository
+		ns, _, ok := strings.Cut(repo, "/")
+		return !ok || strings.Contains(repo[len(ns)+1:], "/") ||
+			namespace != "" && ns != namespace ||
+			!strings.Contains(strings.ToLower(repo), strings.ToLower(req.Query))
+	})
+
+	slices.Sort(repos)
+	repos = repos[:min(len(repos), cmp.Or(req.Limit, searchLimit))]
+
+	results := make([]*api.SearchResult, len(repos))
+
+	var g errgroup.Group
+	g.SetLimit(4)
+	for i, repo := range repos {
+		g.Go(func() error {
+			// skip repositories that cannot be read rather than
+			// failing the whole search
+			result, err := searchRepository(c.Request.Context(), base, repo)
+			if err != nil {
+				slog.Warn("search registry", "repository", repo, "error", err)
+				return nil
+			}
+
+			results[i] = result
+			return nil
+		})
+	}
+
+	_ = g.Wait()
+
+	resp := api.SearchResponse{Models: []api.SearchResult{}}
+	for _, result := range results {
+		if result != nil {
+			resp.Models = append(resp.Models, *result)
+		}
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// registryCatalog lists the repositories of the registry at base, following
+// the pagination of the distribution catalog API.
+func registryCatalog(ctx context.Context, base *url.URL) ([]string, error) {
+	var repos []string
+	for requestURL := base.JoinPath("v2", "_catalog"); requestURL != nil; {
+		var catalog struct {
+			Repositories []string `json:"repositories"`
+		}
+
+		link, err := registryGet(ctx, requestURL, &catalog)
+		if err != nil {
+			return nil, err
+		}
+
+		repos = append(repos, catalog.Repositories...)
+		requestURL = nextLink(requestURL, link)
+	}
+
+	return repos, nil
+}
+
+// nextLink returns the URL of the next page in the Link header link of a
+// response to requestURL, or nil if it is the last page.
+func nextLink(requestURL *url.URL, link string) *url.URL {
+	for _, l := range strings.Split(link, ",") {
+		target, params, ok := strings.Cut(strings.TrimSpace(l), ";")
+		if !ok || !strings.Contains(params, `rel="next"`) {
+			continue
+		}
+
+		next, err := requestURL.Parse(strings.Trim(strings.TrimSpace(target), "<>"))
+		if err != nil {
+			return nil
+		}
+
+		return next
+	}
+
+	return nil
+}
+
+// searchRepository reads the tags of repo in the registry at base, with
+// their sizes and details.
+func searchRepository(ctx context.Context, base *url.URL, repo string) (*api.SearchResult, error) {
+	var list struct {
+		Tags []string `json:"tags"`
+	}
+
+	if _, err := registryGet(ctx, base.JoinPath("v2", repo, "tags", "list"), &list); err != nil {
+		return nil, err
+	}
+
+	mp := ParseModelPath(base.Host + "/" + repo)
+	result := api.SearchResult{Name: strings.TrimSuffix(mp.GetShortTagname(), ":"+mp.Tag), Tags: []api.SearchResultTag{}}
+
+	slices.Sort(list.Tags)
+	for _, tag := range list.Tags {
+		mp.Tag = tag
+
+		headers := make(http.Header)
+		headers.Set("Accept", "application/vnd.docker.distribution.manifest.v2+json")
+		resp, err := makeRequestWithRetry(ctx, http.MethodGet, base.JoinPath("v2", repo, "manifests", tag), headers, nil, &registryOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		var m Manifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, err
+		}
+
+		var config ConfigV2
+		if m.Config.Digest != "" {
+			if _, err := registryGet(ctx, base.JoinPath("v2", repo, "blobs", m.Config.Digest), &config); err != nil {
+				return nil, err
+			}
+		}
+
+		result.Tags = append(result.Tags, api.SearchResultTag{
+			Name:   mp.GetShortTagname(),
+			Tag:    tag,
+			Size:   m.Size(),
+			Digest: fmt.Sprintf("%x", sha256.Sum256(data)),
+			Details: api.ModelDetails{
+				Format:            config.ModelFormat,
+				Family:            config.ModelFamily,
+				Families:          config.ModelFamilies,
+				ParameterSize:     config.ModelType,
+				QuantizationLevel: config.FileType,
+			},
+		})
+	}
+
+	return &result, nil
+}
+
+// registryGet decodes the JSON response to a GET request of requestURL into
+// v and returns its Link header.
+func registryGet(ctx context.Context, requestURL *url.URL, v any) (string, error) {
+	// each request has its own options since the token is for its scope
+	resp, err := makeRequestWithRetry(ctx, http.MethodGet, requestURL, nil, nil, &registryOptions{})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	return resp.Header.Get("Link"), json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestSearchHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	manifests := map[string]Manifest{
+		"library/llama3:8b":    {Config: Layer{Digest: "sha256:llama3-config", Size: 1}, Layers: []Layer{{Digest: "sha256:a", Size: 100}}},
+		"library/llama3:70b":   {Config: Layer{Digest: "sha256:llama3-config", Size: 1}, Layers: []Layer{{Digest: "sha256:b", Size: 1000}}},
+		"library/gemma3:1b":    {Layers: []Layer{{Digest: "sha256:c", Size: 10}}},
+		"myns/llama3-tuned:v1": {Layers: []Layer{{Digest: "sha256:d", Size: 200}}},
+	}
+
+	// the catalog is split into two pages, with a repository that is not
+	// a model name
+	pages := map[string]string{
+		"":       `{"repositories": ["library/gemma3", "library/llama3"]}`,
+		"llama3": `{"repositories": ["myns/llama3-tuned", "toplevel"]}`,
+	}
+
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/v2/")
+		switch {
+		case path == "_catalog":
+			page := r.URL.Query().Get("last")
+			if page == "" {
+				w.Header().Set("Link", `</v2/_catalog?last=llama3>; rel="next"`)
+			}
+			w.Write([]byte(pages[page])) //nolint:errcheck
+		case strings.HasSuffix(path, "/tags/list"):
+			repo := strings.TrimSuffix(path, "/tags/list")
+			var tags []string
+			for name := range manifests {
+				if r, tag, _ := strings.Cut(name, ":"); r == repo {
+					tags = append(tags, tag)
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]any{"name": repo, "tags": tags}) //nolint:errcheck
+		case strings.Contains(path, "/manifests/"):
+			repo, tag, _ := strings.Cut(path, "/manifests/")
+			json.NewEncoder(w).Encode(manifests[repo+":"+tag]) //nolint:errcheck
+		case strings.HasSuffix(path, "/blobs/sha256:llama3-config"):
+			json.NewEncoder(w).Encode(ConfigV2{ModelFormat: "gguf", ModelFamily: "llama", ModelType: "8B", FileType: "Q4_K_M"}) //nolint:errcheck
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer registry.Close()
+
+	host := "http://" + registry.Listener.Addr().String()
+
+	var s Server
+	search := func(t *testing.T, req api.SearchRequest) []api.SearchResult {
+		t.Helper()
+
+		w := createRequest(t, s.SearchHandler, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.SearchResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		return resp.Models
+	}
+
+	t.Run("query", func(t *testing.T) {
+		models := search(t, api.SearchRequest{Query: "LLAMA", Registry: host})
+
+		addr := registry.Listener.Addr().String()
+		want := []api.SearchResult{
+			{
+				Name: addr + "/library/llama3",
+				Tags: []api.SearchResultTag{
+					{
+						Name:    addr + "/library/llama3:70b",
+						Tag:     "70b",
+						Size:    1001,
+						Details: api.ModelDetails{Format: "gguf", Family: "llama", ParameterSize: "8B", QuantizationLevel: "Q4_K_M"},
+					},
+					{
+						Name:    addr + "/library/llama3:8b",
+						Tag:     "8b",
+						Size:    101,
+						Details: api.ModelDetails{Format: "gguf", Family: "llama", ParameterSize: "8B", QuantizationLevel: "Q4_K_M"},
+					},
+				},
+			},
+			{
+				Name: addr + "/myns/llama3-tuned",
+				Tags: []api.SearchResultTag{{Name: addr + "/myns/llama3-tuned:v1", Tag: "v1", Size: 200}},
+			},
+		}
+
+		for _, m := range models {
+			for i := range m.Tags {
+				if len(m.Tags[i].Digest) != 64 {
+					t.Errorf("expected a sha256 digest, got %q", m.Tags[i].Digest)
+				}
+				m.Tags[i].Digest = ""
+			}
+		}
+
+		if diff := cmp.Diff(want, models); diff != "" {
+			t.Errorf("search mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("namespace", func(t *testing.T) {
+		models := search(t, api.SearchRequest{Registry: host + "/myns"})
+		if len(models) != 1 || !strings.HasSuffix(models[0].Name, "myns/llama3-tuned") {
+			t.Errorf("unexpected models %+v", models)
+		}
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		models := search(t, api.SearchRequest{Registry: host, Limit: 1})
+		if len(models) != 1 || !strings.HasSuffix(models[0].Name, "library/gemma3") {
+			t.Errorf("unexpected models %+v", models)
+		}
+	})
+
+	t.Run("no catalog", func(t *testing.T) {
+		unsupported := httptest.NewServer(http.NotFoundHandler())
+		defer unsupported.Close()
+
+		w := createRequest(t, s.SearchHandler, api.SearchRequest{Registry: "http://" + unsupported.Listener.Addr().String()})
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	for _, req := range []api.SearchRequest{
+		{Limit: -1},
+		{Registry: "example.com/a/b"},
+	} {
+		w := createRequest(t, s.SearchHandler, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%+v: expected status 400, got %d: %s", req, w.Code, w.Body.String())
+		}
+	}
+}
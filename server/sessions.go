@@ -0,0 +1,276 @@
+package server
+
+import (
+	"cmp"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/model"
+)
+
+// sessionTTL is how long sessions are kept after they were last used.
+const sessionTTL = 24 * time.Hour
+
+var (
+	errSessionNotFound = errors.New("session not found")
+	errSessionBusy     = errors.New("session is in use by another request")
+)
+
+// sessions are the chats whose messages are kept by the server. They are
+// kept in memory, so they don't persist across restarts. The zero value is
+// ready to use.
+type sessions struct {
+	mu sync.Mutex
+	m  map[string]*session
+}
+
+type session struct {
+	api.Session
+
+	// busy is set while a chat request uses the session, since the
+	// messages of concurrent requests would be interleaved
+	busy bool
+}
+
+// expire removes the sessions that weren't used for sessionTTL. Callers must
+// hold ss.mu.
+func (ss *sessions) expire() {
+	for id, s := range ss.m {
+		if !s.busy && time.Since(s.UpdatedAt) > sessionTTL {
+			delete(ss.m, id)
+		}
+	}
+}
+
+// create starts a session with the model name.
+func (ss *sessions) create(name model.Name, req api.SessionRequest) api.Session {
+	var b [16]byte
+	rand.Read(b[:])
+
+	now := time.Now().UTC()
+	s := &session{Session: api.Session{
+		ID:        hex.EncodeToString(b[:]),
+		Model:     name.DisplayShortest(),
+		Messages:  req.Messages,
+		Tools:     req.Tools,
+		Options:   req.Options,
+		KeepAlive: req.KeepAlive,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	ss.expire()
+	if ss.m == nil {
+		ss.m = make(map[string]*session)
+	}
+
+	ss.m[s.ID] = s
+	return s.copy()
+}
+
+// get returns the session with the ID id.
+func (ss *sessions) get(id string) (api.Session, bool) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	ss.expire()
+	s, ok := ss.m[id]
+	if !ok {
+		return api.Session{}, false
+	}
+
+	return s.copy(), true
+}
+
+// list returns the sessions without their messages, oldest first.
+func (ss *sessions) list() []api.Session {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	ss.expire()
+	list := make([]api.Session, 0, len(ss.m))
+	for _, s := range ss.m {
+		session := s.copy()
+		session.Messages = nil
+		list = append(list, session)
+	}
+
+	slices.SortFunc(list, func(a, b api.Session) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID, b.ID))
+	})
+
+	return list
+}
+
+// delete removes the session with the ID id and reports whether it existed.
+func (ss *sessions) delete(id string) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	_, ok := ss.m[id]
+	delete(ss.m, id)
+	return ok
+}
+
+// begin returns the session with the ID id for a chat request, which must
+// call end when it is done.
+func (ss *sessions) begin(id string) (api.Session, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	ss.expire()
+	s, ok := ss.m[id]
+	switch {
+	case !ok:
+		return api.Session{}, errSessionNotFound
+	case s.busy:
+		return api.Session{}, errSessionBusy
+	}
+
+	s.busy = true
+	return s.copy(), nil
+}
+
+// record adds messages to the session with the ID id, unless it was deleted
+// in the meantime.
+func (ss *sessions) record(id string, messages ...api.Message) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if s, ok := ss.m[id]; ok {
+		s.Messages = append(s.Messages, messages...)
+		s.UpdatedAt = time.Now().UTC()
+	}
+}
+
+// end releases the session with the ID id after a chat request.
+func (ss *sessions) end(id string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if s, ok := ss.m[id]; ok {
+		s.busy = false
+	}
+}
+
+func (s *session) copy() api.Session {
+	session := s.Session
+	session.Messages = slices.Clone(s.Messages)
+	session.Tools = slices.Clone(s.Tools)
+	session.Options = maps.Clone(s.Options)
+	return session
+}
+
+// sessionChat returns req continuing the chat of s. The request's tools,
+// options and keep alive take precedence over those of the session.
+func sessionChat(s api.Session, req api.ChatRequest) (api.ChatRequest, error) {
+	if req.Model != "" && !model.ParseName(req.Model).EqualFold(model.ParseName(s.Model)) {
+		return req, fmt.Errorf("model %q doesn't match the model %q of the session", req.Model, s.Model)
+	}
+
+	if len(req.Messages) == 0 {
+		return req, errors.New("messages are required to chat in a session")
+	}
+
+	req.Model = s.Model
+	req.Messages = append(s.Messages, req.Messages...)
+	if len(req.Tools) == 0 {
+		req.Tools = s.Tools
+	}
+	if req.KeepAlive == nil {
+		req.KeepAlive = s.KeepAlive
+	}
+
+	opts := maps.Clone(s.Options)
+	if opts == nil {
+		opts = make(map[string]any)
+	}
+	maps.Copy(opts, req.Options)
+	req.Options = opts
+
+	return req, nil
+}
+
+// sessionReply is the message recorded in a session for a chat response,
+// with the tool calls found in content when the response was not streamed.
+func sessionReply(m *Model, tools api.Tools, content string, toolCalls []api.ToolCall) api.Message {
+	if len(toolCalls) == 0 && len(tools) > 0 {
+		if calls, ok := m.parseToolCalls(content); ok {
+			return api.Message{Role: "assistant", ToolCalls: calls}
+		}
+	}
+
+	return api.Message{Role: "assistant", Content: content, ToolCalls: toolCalls}
+}
+
+func (s *Server) CreateSessionHandler(c *gin.Context) {
+	var req api.SessionRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := model.ParseName(req.Model)
+	if !name.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+		return
+	}
+
+	name, err := getExistingName(name)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := ParseNamedManifest(name); err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, api.ErrorResponse{Error: fmt.Sprintf("model %q not found", req.Model), Code: api.ErrorCodeModelNotFound})
+		return
+	}
+
+	if err := (&api.Options{}).FromMap(req.Options); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, s.sessions.create(name, req))
+}
+
+func (s *Server) SessionsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, api.SessionsResponse{Sessions: s.sessions.list()})
+}
+
+func (s *Server) SessionHandler(c *gin.Context) {
+	session, ok := s.sessions.get(c.Param("id"))
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": errSessionNotFound.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, session)
+}
+
+func (s *Server) DeleteSessionHandler(c *gin.Context) {
+	if !s.sessions.delete(c.Param("id")) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": errSessionNotFound.Error()})
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
@@ -0,0 +1,312 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/types/model"
+)
+
+func TestSessions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mock := mockRunner{
+		CompletionResponse: llm.CompletionResponse{
+			Content:    "Hi!",
+			Done:       true,
+			DoneReason: "stop",
+		},
+	}
+
+	s := Server{
+		sched: &Scheduler{
+			pendingReqCh:  make(chan *LlmRequest, 1),
+			finishedReqCh: make(chan *LlmRequest, 1),
+			expiredCh:     make(chan *runnerRef, 1),
+			unloadedCh:    make(chan any, 1),
+			loaded:        make(map[string]*runnerRef),
+			newServerFn:   newMockServer(&mock),
+			getGpuFn:      discover.GetGPUInfo,
+			getCpuFn:      discover.GetCPUInfo,
+			reschedDelay:  250 * time.Millisecond,
+			loadFn: func(req *LlmRequest, _ *ggml.GGML, _ discover.GpuInfoList, _ int) {
+				req.successCh <- &runnerRef{
+					llama: &mock,
+				}
+			},
+		},
+	}
+
+	go s.sched.Run(t.Context())
+
+	_, digest := createBinFile(t, ggml.KV{
+		"general.architecture":          "llama",
+		"llama.block_count":             uint32(1),
+		"llama.context_length":          uint32(8192),
+		"llama.embedding_length":        uint32(4096),
+		"llama.attention.head_count":    uint32(32),
+		"llama.attention.head_count_kv": uint32(8),
+		"tokenizer.ggml.tokens":         []string{""},
+		"tokenizer.ggml.scores":         []float32{0},
+		"tokenizer.ggml.token_type":     []int32{0},
+	}, []ggml.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.attn_norm.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "output.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+	})
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model: "test",
+		Files: map[string]string{"file.gguf": digest},
+		Template: `
+{{- range .Messages }}
+{{- .Role }}: {{ .Content }}
+{{ end }}`,
+		Stream: &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// withID calls fn for the session id, as routed by the server
+	withID := func(t *testing.T, fn gin.HandlerFunc, id string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/sessions/"+id, nil)
+		c.Params = gin.Params{{Key: "id", Value: id}}
+		fn(c)
+		return w
+	}
+
+	t.Run("missing model", func(t *testing.T) {
+		w := createRequest(t, s.CreateSessionHandler, api.SessionRequest{Model: "missing"})
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	w = createRequest(t, s.CreateSessionHandler, api.SessionRequest{
+		Model:    "test",
+		Messages: []api.Message{{Role: "system", Content: "You are terse."}},
+		Options:  map[string]any{"temperature": 0.25},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var session api.Session
+	if err := json.NewDecoder(w.Body).Decode(&session); err != nil {
+		t.Fatal(err)
+	}
+
+	if session.ID == "" || session.Model != "test:latest" {
+		t.Fatalf("unexpected session %+v", session)
+	}
+
+	chat := func(t *testing.T, req api.ChatRequest) api.ChatResponse {
+		t.Helper()
+
+		req.Session, req.Stream = session.ID, &stream
+		w := createRequest(t, s.ChatHandler, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.ChatResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		return resp
+	}
+
+	t.Run("chat", func(t *testing.T) {
+		if resp := chat(t, api.ChatRequest{Messages: []api.Message{{Role: "user", Content: "Hello"}}}); resp.Message.Content != "Hi!" {
+			t.Errorf("unexpected response %q", resp.Message.Content)
+		}
+
+		if diff := cmp.Diff(mock.CompletionRequest.Prompt, "system: You are terse.\nuser: Hello\n"); diff != "" {
+			t.Errorf("prompt mismatch (-got +want):\n%s", diff)
+		}
+
+		if got := mock.CompletionRequest.Options.Temperature; got != 0.25 {
+			t.Errorf("expected the temperature of the session, got %v", got)
+		}
+
+		// the second request only sends its new message
+		chat(t, api.ChatRequest{
+			Messages: []api.Message{{Role: "user", Content: "Bye"}},
+			Options:  map[string]any{"temperature": 1},
+		})
+
+		if diff := cmp.Diff(mock.CompletionRequest.Prompt, "system: You are terse.\nuser: Hello\nassistant: Hi!\nuser: Bye\n"); diff != "" {
+			t.Errorf("prompt mismatch (-got +want):\n%s", diff)
+		}
+
+		if got := mock.CompletionRequest.Options.Temperature; got != 1 {
+			t.Errorf("expected the temperature of the request, got %v", got)
+		}
+
+		w := withID(t, s.SessionHandler, session.ID)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var got api.Session
+		if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(got.Messages, []api.Message{
+			{Role: "system", Content: "You are terse."},
+			{Role: "user", Content: "Hello"},
+			{Role: "assistant", Content: "Hi!"},
+			{Role: "user", Content: "Bye"},
+			{Role: "assistant", Content: "Hi!"},
+		}); diff != "" {
+			t.Errorf("messages mismatch (-got +want):\n%s", diff)
+		}
+
+		if diff := cmp.Diff(got.Options, map[string]any{"temperature": 0.25}); diff != "" {
+			t.Errorf("options mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("fallback", func(t *testing.T) {
+		minLogprob := -1.0
+		s.fallbacks = map[string]*fallback{
+			model.ParseName("resilient").String(): {name: "resilient", Models: []fallbackModel{
+				{Model: "test", MinLogprob: &minLogprob},
+				{Model: "test"},
+			}},
+		}
+		mock.ScoreFn = func(context.Context, llm.ScoreRequest) (*llm.ScoreResponse, error) {
+			return &llm.ScoreResponse{LogProbs: []float64{-2, -3}}, nil
+		}
+		defer func() { s.fallbacks, mock.ScoreFn = nil, nil }()
+
+		before, ok := s.sessions.get(session.ID)
+		if !ok {
+			t.Fatal("expected the session")
+		}
+
+		fallback := s.fallbackMiddleware(s.ChatHandler)
+		w := createRequest(t, func(c *gin.Context) {
+			if fallback(c); !c.IsAborted() {
+				s.ChatHandler(c)
+			}
+		}, api.ChatRequest{
+			Model:    "resilient",
+			Session:  session.ID,
+			Messages: []api.Message{{Role: "user", Content: "Again"}},
+			Stream:   &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		// the rejected reply of the first model isn't part of the chat of the
+		// second
+		if !strings.HasSuffix(mock.CompletionRequest.Prompt, "assistant: Hi!\nuser: Again\n") || strings.Count(mock.CompletionRequest.Prompt, "user: Again") != 1 {
+			t.Errorf("unexpected prompt %q", mock.CompletionRequest.Prompt)
+		}
+
+		after, ok := s.sessions.get(session.ID)
+		if !ok {
+			t.Fatal("expected the session")
+		}
+
+		if diff := cmp.Diff(after.Messages[len(before.Messages):], []api.Message{
+			{Role: "user", Content: "Again"},
+			{Role: "assistant", Content: "Hi!"},
+		}); diff != "" {
+			t.Errorf("messages mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		cases := []struct {
+			name string
+			req  api.ChatRequest
+			code int
+		}{
+			{"not found", api.ChatRequest{Session: "missing", Messages: []api.Message{{Role: "user", Content: "Hello"}}}, http.StatusNotFound},
+			{"other model", api.ChatRequest{Session: session.ID, Model: "other", Messages: []api.Message{{Role: "user", Content: "Hello"}}}, http.StatusBadRequest},
+			{"no messages", api.ChatRequest{Session: session.ID}, http.StatusBadRequest},
+		}
+
+		for _, tt := range cases {
+			t.Run(tt.name, func(t *testing.T) {
+				w := createRequest(t, s.ChatHandler, tt.req)
+				if w.Code != tt.code {
+					t.Errorf("expected status %d, got %d: %s", tt.code, w.Code, w.Body.String())
+				}
+			})
+		}
+
+		// a session serves one request at a time
+		if _, err := s.sessions.begin(session.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{Session: session.ID, Messages: []api.Message{{Role: "user", Content: "Hello"}}})
+		if w.Code != http.StatusConflict {
+			t.Errorf("expected status 409, got %d: %s", w.Code, w.Body.String())
+		}
+
+		s.sessions.end(session.ID)
+	})
+
+	t.Run("list and delete", func(t *testing.T) {
+		w := createRequest(t, s.SessionsHandler, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.SessionsResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(resp.Sessions) != 1 || resp.Sessions[0].ID != session.ID || len(resp.Sessions[0].Messages) != 0 {
+			t.Fatalf("expected the session without messages, got %+v", resp.Sessions)
+		}
+
+		if w := withID(t, s.DeleteSessionHandler, session.ID); w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		if w := withID(t, s.SessionHandler, session.ID); w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d: %s", w.Code, w.Body.String())
+		}
+
+		if w := withID(t, s.DeleteSessionHandler, session.ID); w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+}
+
+func TestSessionsExpire(t *testing.T) {
+	var ss sessions
+	session := ss.create(model.ParseName("test"), api.SessionRequest{})
+
+	ss.m[session.ID].UpdatedAt = time.Now().Add(-sessionTTL - time.Minute)
+	if _, ok := ss.get(session.ID); ok {
+		t.Errorf("expected the session to expire")
+	}
+}
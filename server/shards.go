@@ -0,0 +1,275 @@
+package server
+
+import (
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+// nativeByteOrder is the byte order of this machine. Runners can only load
+// models in this byte order.
+var nativeByteOrder = func() binary.ByteOrder {
+	if binary.NativeEndian.Uint16([]byte{1, 0}) == 1 {
+		return binary.LittleEndian
+	}
+
+	return binary.BigEndian
+}()
+
+// shardOf reports the position of the model blob at path in a model split
+// into several files and the number of files.
+func shardOf(path string) (no, count int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	meta, _, err := ggml.Decode(f, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	no, count = meta.KV().Shard()
+	return no, count, nil
+}
+
+// errModelNotPrepared is returned when the model file of a model that runners
+// can't load as it is has not been built, such as when the model cache was
+// cleared.
+var errModelNotPrepared = errors.New("model file has not been prepared for this machine; pull or create the model again")
+
+// modelFilePath returns the path of a single model file in the native byte
+// order for the model blobs at paths and whether it exists. A model split
+// into shards, or in the other byte order, has its model file in the model
+// cache.
+func modelFilePath(paths ...string) (string, bool, error) {
+	if len(paths) == 1 {
+		f, err := os.Open(paths[0])
+		if err != nil {
+			// leave missing models for the runner to report
+			return paths[0], true, nil
+		}
+		defer f.Close()
+
+		if order, err := ggml.ReadByteOrder(f); err != nil || order == nativeByteOrder {
+			// leave models that aren't GGUF for the runner to reject
+			return paths[0], true, nil
+		}
+	}
+
+	digest := strings.Replace(filepath.Base(paths[0]), "-", ":", 1)
+	cachePath, err := GetModelCachePath(digest)
+	if err != nil {
+		return "", false, err
+	}
+
+	if _, err := os.Stat(cachePath); err == nil {
+		return cachePath, true, nil
+	}
+
+	return cachePath, false, nil
+}
+
+// modelFile returns the path of a single model file in the native byte order
+// built from the model blobs at paths. A model split into shards is merged and
+// a model in the other byte order is converted. The result is kept in the
+// model cache so this is only done once.
+func modelFile(paths ...string) (string, error) {
+	cachePath, ok, err := modelFilePath(paths...)
+	if err != nil || ok {
+		return cachePath, err
+	}
+
+	slog.Info("preparing model file", "model", paths[0], "shards", len(paths), "byteorder", nativeByteOrder)
+
+	rs := make([]io.ReaderAt, len(paths))
+	for i, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+
+		rs[i] = f
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(cachePath), filepath.Base(cachePath)+"-partial-")
+	if err != nil {
+		return "", err
+	}
+	defer temp.Close()
+	defer os.Remove(temp.Name())
+
+	if err := ggml.MergeShards(temp, nativeByteOrder, rs...); err != nil {
+		return "", err
+	}
+
+	if err := temp.Close(); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(temp.Name(), cachePath); err != nil {
+		return "", err
+	}
+
+	return cachePath, nil
+}
+
+// preparedModelFile returns the path of the model file for the model blobs at
+// paths that [prepareModelFiles] built. It doesn't build the model file
+// itself, which can take long for large models, so that loading a model never
+// waits for it.
+func preparedModelFile(paths ...string) (string, error) {
+	path, ok, err := modelFilePath(paths...)
+	if err != nil {
+		return "", err
+	} else if !ok {
+		return "", errModelNotPrepared
+	}
+
+	return path, nil
+}
+
+// modelLayerPaths returns the model blobs at paths of the model layers of a
+// manifest that make up its model. Layers that are the shards of one model
+// are all used; otherwise only the last one is.
+func modelLayerPaths(paths []string) []string {
+	if len(paths) > 1 {
+		if _, count, err := shardOf(paths[0]); err != nil || count != len(paths) {
+			// not a split model; use the last model layer
+			return paths[len(paths)-1:]
+		}
+	}
+
+	return paths
+}
+
+// prepareModelFiles builds the model files of layers that runners can't load
+// as they are when a model is created or pulled: the shards of a split model
+// are merged and models in the other byte order are converted.
+func prepareModelFiles(layers []Layer, fn func(api.ProgressResponse)) error {
+	var models [][]string
+	var modelPaths []string
+	for _, layer := range layers {
+		path, err := GetBlobsPath(layer.Digest)
+		if err != nil {
+			return err
+		}
+
+		switch layer.MediaType {
+		case "application/vnd.ollama.image.model":
+			modelPaths = append(modelPaths, path)
+		case "application/vnd.ollama.image.adapter", "application/vnd.ollama.image.projector":
+			models = append(models, []string{path})
+		}
+	}
+
+	if len(modelPaths) > 0 {
+		models = append(models, modelLayerPaths(modelPaths))
+	}
+
+	for _, paths := range models {
+		if _, ok, err := modelFilePath(paths...); err != nil {
+			return err
+		} else if ok {
+			continue
+		}
+
+		if len(paths) > 1 {
+			fn(api.ProgressResponse{Status: fmt.Sprintf("merging %d model shards", len(paths))})
+		} else {
+			fn(api.ProgressResponse{Status: "converting model byte order"})
+		}
+
+		if _, err := modelFile(paths...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// mergeShardFiles replaces the files that are shards of a split model with a
+// single blob holding the whole model.
+func mergeShardFiles(files map[string]string, fn func(resp api.ProgressResponse)) (map[string]string, error) {
+	type shard struct {
+		name, digest string
+		no           int
+	}
+
+	var shards []shard
+	for name, digest := range files {
+		blobPath, err := GetBlobsPath(digest)
+		if err != nil {
+			return nil, err
+		}
+
+		no, count, err := shardOf(blobPath)
+		if err != nil {
+			// not a GGUF model; leave it for the caller to report
+			continue
+		}
+
+		if count > 1 {
+			shards = append(shards, shard{name: name, digest: digest, no: no})
+		}
+	}
+
+	if len(shards) == 0 {
+		return files, nil
+	}
+
+	slices.SortFunc(shards, func(a, b shard) int {
+		return cmp.Compare(a.no, b.no)
+	})
+
+	fn(api.ProgressResponse{Status: fmt.Sprintf("merging %d model shards", len(shards))})
+
+	rs := make([]io.ReaderAt, len(shards))
+	for i, s := range shards {
+		blobPath, err := GetBlobsPath(s.digest)
+		if err != nil {
+			return nil, err
+		}
+
+		f, err := os.Open(blobPath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		rs[i] = f
+	}
+
+	pr, pw := io.Pipe()
+	defer pr.Close()
+
+	go func() {
+		pw.CloseWithError(ggml.MergeShards(pw, nil, rs...))
+	}()
+
+	layer, err := NewLayer(pr, "application/vnd.ollama.image.model")
+	if err != nil {
+		return nil, err
+	}
+
+	merged := maps.Clone(files)
+	for _, s := range shards {
+		delete(merged, s.name)
+	}
+
+	merged[shards[0].name] = layer.Digest
+	return merged, nil
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/types/model"
+)
+
+func createShards(t *testing.T) []string {
+	t.Helper()
+
+	var digests []string
+	for i, name := range []string{"token_embd.weight", "output.weight"} {
+		_, digest := createBinFile(t, map[string]any{
+			"general.architecture": "test",
+			"split.no":             uint32(i),
+			"split.count":          uint32(2),
+		}, []ggml.Tensor{
+			{Name: name, Kind: 0, Shape: []uint64{4}, WriterTo: bytes.NewReader(make([]byte, 16))},
+		})
+		digests = append(digests, digest)
+	}
+
+	return digests
+}
+
+func decodeModel(t *testing.T, path string) *ggml.GGML {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	meta, _, err := ggml.Decode(f, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return meta
+}
+
+func TestCreateFromShards(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var s Server
+
+	digests := createShards(t)
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name: "test",
+		Files: map[string]string{
+			"model-00002-of-00002.gguf": digests[1],
+			"model-00001-of-00002.gguf": digests[0],
+		},
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if filepath.Base(filepath.Dir(m.ModelPath)) != "blobs" {
+		t.Errorf("expected the merged model to be a blob, got %s", m.ModelPath)
+	}
+
+	meta := decodeModel(t, m.ModelPath)
+	if _, count := meta.KV().Shard(); count != 0 {
+		t.Errorf("expected a single file model, got %d shards", count)
+	}
+
+	if n := len(meta.Tensors().Items()); n != 2 {
+		t.Errorf("expected 2 tensors, got %d", n)
+	}
+}
+
+func TestGetModelShards(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var layers []Layer
+	for _, digest := range createShards(t) {
+		layer, err := NewLayerFromLayer(digest, "application/vnd.ollama.image.model", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		layers = append(layers, layer)
+	}
+
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(&ConfigV2{}); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := NewLayer(&b, "application/vnd.docker.container.image.v1+json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteManifest(model.ParseName("test"), config, layers); err != nil {
+		t.Fatal(err)
+	}
+
+	// shards are merged when the model is created or pulled, not loaded
+	if _, err := GetModel("test"); !errors.Is(err, errModelNotPrepared) {
+		t.Fatalf("expected errModelNotPrepared, got %v", err)
+	}
+
+	var statuses []string
+	if err := prepareModelFiles(layers, func(resp api.ProgressResponse) {
+		statuses = append(statuses, resp.Status)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(statuses, []string{"merging 2 model shards"}) {
+		t.Errorf("unexpected progress %v", statuses)
+	}
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cachePath, err := GetModelCachePath(layers[0].Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.ModelPath != cachePath {
+		t.Errorf("expected model path %s, got %s", cachePath, m.ModelPath)
+	}
+
+	if n := len(decodeModel(t, m.ModelPath).Tensors().Items()); n != 2 {
+		t.Errorf("expected 2 tensors, got %d", n)
+	}
+
+	manifestPath, err := ParseModelPath("test").GetManifestPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(manifestPath); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := deleteUnusedLayers(map[string]struct{}{layers[0].Digest: {}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(cachePath); !os.IsNotExist(err) {
+		t.Errorf("expected the merged model to be removed with its blob, got %v", err)
+	}
+}
+
+func TestModelFileByteOrder(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	_, digest := createBinFile(t, map[string]any{"general.architecture": "test"}, []ggml.Tensor{
+		{Name: "token_embd.weight", Kind: 0, Shape: []uint64{4}, WriterTo: bytes.NewReader(make([]byte, 16))},
+	})
+
+	blobPath, err := GetBlobsPath(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if path, err := modelFile(blobPath); err != nil {
+		t.Fatal(err)
+	} else if path != blobPath {
+		t.Errorf("expected a native byte order model to be used as is, got %s", path)
+	}
+
+	native := nativeByteOrder
+	t.Cleanup(func() { nativeByteOrder = native })
+	nativeByteOrder = otherByteOrder(native)
+
+	if _, err := preparedModelFile(blobPath); !errors.Is(err, errModelNotPrepared) {
+		t.Fatalf("expected errModelNotPrepared, got %v", err)
+	}
+
+	path, err := modelFile(blobPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if prepared, err := preparedModelFile(blobPath); err != nil {
+		t.Fatal(err)
+	} else if prepared != path {
+		t.Errorf("expected the converted model %s, got %s", path, prepared)
+	}
+
+	if !strings.HasPrefix(path, filepath.Join(os.Getenv("OLLAMA_MODELS"), "cache")) {
+		t.Fatalf("expected a converted model in the cache, got %s", path)
+	}
+
+	if order := decodeModel(t, path).ByteOrder(); order != nativeByteOrder {
+		t.Errorf("expected byte order %v, got %v", nativeByteOrder, order)
+	}
+}
+
+func otherByteOrder(order binary.ByteOrder) binary.ByteOrder {
+	if order == binary.ByteOrder(binary.LittleEndian) {
+		return binary.BigEndian
+	}
+
+	return binary.LittleEndian
+}
@@ -0,0 +1,281 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/errtypes"
+	"github.com/ollama/ollama/types/model"
+)
+
+func (s *Server) SimilarityHandler(c *gin.Context) {
+	var req api.SimilarityRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var similarity func(a, b []float32) float64
+	switch req.Metric {
+	case "", "cosine":
+		similarity = cosine
+	case "dot":
+		similarity = dot
+	default:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown metric %q", req.Metric)})
+		return
+	}
+
+	if (len(req.Vectors) > 0) == (len(req.Texts) > 0) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "either vectors or texts are required"})
+		return
+	}
+
+	vectors := req.Vectors
+	if len(req.Texts) > 0 {
+		name := model.ParseName(req.Model)
+		if !name.IsValid() {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": errtypes.InvalidModelNameErrMsg})
+			return
+		}
+
+		name, err = getExistingName(name)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		r, _, _, _, err := s.scheduleRunner(c.Request.Context(), name.String(), []Capability{}, req.Options, req.KeepAlive)
+		if err != nil {
+			handleScheduleError(c, req.Model, err)
+			return
+		}
+
+		// embed the texts as /api/embed does
+		var g errgroup.Group
+		vectors = make([][]float32, len(req.Texts))
+		for i, text := range req.Texts {
+			g.Go(func() error {
+				embedding, err := r.Embedding(c.Request.Context(), text)
+				if err != nil {
+					return err
+				}
+
+				vectors[i] = normalize(embedding)
+				return nil
+			})
+		}
+
+		if err := g.Wait(); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": strings.TrimSpace(err.Error())})
+			return
+		}
+	}
+
+	if err := validateVectors(vectors); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	scores := make([][]float64, len(vectors))
+	for i := range vectors {
+		scores[i] = make([]float64, len(vectors))
+		for j := range vectors {
+			scores[i][j] = similarity(vectors[i], vectors[j])
+		}
+	}
+
+	c.JSON(http.StatusOK, api.SimilarityResponse{Scores: scores})
+}
+
+func (s *Server) ClusterHandler(c *gin.Context) {
+	var req api.ClusterRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := validateVectors(req.Vectors); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.K < 1 || req.K > len(req.Vectors) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "k must be between 1 and the number of vectors"})
+		return
+	}
+
+	if req.MaxIterations < 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "max_iterations must not be negative"})
+		return
+	}
+
+	maxIterations := req.MaxIterations
+	if maxIterations == 0 {
+		maxIterations = 100
+	}
+
+	c.JSON(http.StatusOK, kmeans(req.Vectors, req.K, maxIterations, rand.New(rand.NewPCG(uint64(req.Seed), 0))))
+}
+
+// validateVectors checks that vectors are not empty and have the same number
+// of dimensions.
+func validateVectors(vectors [][]float32) error {
+	if len(vectors) == 0 {
+		return errors.New("vectors are required")
+	}
+
+	for _, v := range vectors {
+		if len(v) == 0 || len(v) != len(vectors[0]) {
+			return errors.New("vectors must have the same, non-zero, number of dimensions")
+		}
+	}
+
+	return nil
+}
+
+func dot(a, b []float32) float64 {
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+
+	return sum
+}
+
+// cosine returns the cosine similarity of a and b, or 0 if either is zero.
+func cosine(a, b []float32) float64 {
+	norm := math.Sqrt(dot(a, a) * dot(b, b))
+	if norm == 0 {
+		return 0
+	}
+
+	return dot(a, b) / norm
+}
+
+func squaredDistance(a, b []float32) float64 {
+	var sum float64
+	for i := range a {
+		d := float64(a[i]) - float64(b[i])
+		sum += d * d
+	}
+
+	return sum
+}
+
+// kmeans clusters vectors into k clusters with Lloyd's algorithm, choosing the
+// initial centroids with k-means++.
+func kmeans(vectors [][]float32, k, maxIterations int, rng *rand.Rand) api.ClusterResponse {
+	centroids := [][]float32{vectors[rng.IntN(len(vectors))]}
+	distances := make([]float64, len(vectors))
+	for len(centroids) < k {
+		var total float64
+		for i, v := range vectors {
+			distances[i] = math.Inf(1)
+			for _, centroid := range centroids {
+				distances[i] = min(distances[i], squaredDistance(v, centroid))
+			}
+
+			total += distances[i]
+		}
+
+		// pick vectors with a probability proportional to their squared
+		// distance to the nearest centroid, or uniformly if all of them
+		// are centroids
+		next := rng.IntN(len(vectors))
+		if total > 0 {
+			target := rng.Float64() * total
+			for i, d := range distances {
+				target -= d
+				if target <= 0 && d > 0 {
+					next = i
+					break
+				}
+			}
+		}
+
+		centroids = append(centroids, vectors[next])
+	}
+
+	// copy the centroids so that updating them leaves the vectors intact
+	for i := range centroids {
+		centroids[i] = append([]float32(nil), centroids[i]...)
+	}
+
+	resp := api.ClusterResponse{Labels: make([]int, len(vectors)), Centroids: centroids}
+	for resp.Iterations < maxIterations {
+		resp.Iterations++
+
+		// the centroids are always updated after the first assignment
+		changed := resp.Iterations == 1
+		for i, v := range vectors {
+			if nearest := nearestCentroid(v, centroids); nearest != resp.Labels[i] {
+				resp.Labels[i] = nearest
+				changed = true
+			}
+		}
+
+		if !changed {
+			break
+		}
+
+		sums := make([][]float64, k)
+		counts := make([]int, k)
+		for i, v := range vectors {
+			label := resp.Labels[i]
+			if sums[label] == nil {
+				sums[label] = make([]float64, len(v))
+			}
+
+			for j := range v {
+				sums[label][j] += float64(v[j])
+			}
+
+			counts[label]++
+		}
+
+		// empty clusters keep their centroids
+		for label, sum := range sums {
+			for j := range sum {
+				centroids[label][j] = float32(sum[j] / float64(counts[label]))
+			}
+		}
+	}
+
+	for i, v := range vectors {
+		resp.Labels[i] = nearestCentroid(v, centroids)
+		resp.Inertia += squaredDistance(v, centroids[resp.Labels[i]])
+	}
+
+	return resp
+}
+
+func nearestCentroid(v []float32, centroids [][]float32) int {
+	var nearest int
+	for i := range centroids {
+		if squaredDistance(v, centroids[i]) < squaredDistance(v, centroids[nearest]) {
+			nearest = i
+		}
+	}
+
+	return nearest
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+func TestSimilarityHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mock := mockRunner{
+		EmbeddingFn: func(_ context.Context, s string) ([]float32, error) {
+			switch s {
+			case "cat":
+				return []float32{3, 4}, nil
+			case "kitten":
+				return []float32{4, 3}, nil
+			default:
+				return []float32{-4, 3}, nil
+			}
+		},
+	}
+
+	s := Server{
+		sched: &Scheduler{
+			pendingReqCh:  make(chan *LlmRequest, 1),
+			finishedReqCh: make(chan *LlmRequest, 1),
+			expiredCh:     make(chan *runnerRef, 1),
+			unloadedCh:    make(chan any, 1),
+			loaded:        make(map[string]*runnerRef),
+			newServerFn:   newMockServer(&mock),
+			getGpuFn:      discover.GetGPUInfo,
+			getCpuFn:      discover.GetCPUInfo,
+			reschedDelay:  250 * time.Millisecond,
+			loadFn: func(req *LlmRequest, _ *ggml.GGML, _ discover.GpuInfoList, _ int) {
+				req.successCh <- &runnerRef{
+					llama: &mock,
+				}
+			},
+		},
+	}
+
+	go s.sched.Run(t.Context())
+
+	_, digest := createBinFile(t, ggml.KV{
+		"general.architecture":      "bert",
+		"bert.block_count":          uint32(1),
+		"bert.context_length":       uint32(512),
+		"bert.embedding_length":     uint32(2),
+		"bert.attention.head_count": uint32(1),
+		"tokenizer.ggml.tokens":     []string{""},
+		"tokenizer.ggml.scores":     []float32{0},
+		"tokenizer.ggml.token_type": []int32{0},
+	}, []ggml.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+	})
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  "embed",
+		Files:  map[string]string{"file.gguf": digest},
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	similarity := func(t *testing.T, req api.SimilarityRequest) [][]float64 {
+		t.Helper()
+
+		w := createRequest(t, s.SimilarityHandler, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.SimilarityResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		return resp.Scores
+	}
+
+	equal := func(a, b [][]float64) bool {
+		if len(a) != len(b) {
+			return false
+		}
+
+		for i := range a {
+			for j := range a[i] {
+				if len(a[i]) != len(b[i]) || math.Abs(a[i][j]-b[i][j]) > 1e-6 {
+					return false
+				}
+			}
+		}
+
+		return true
+	}
+
+	t.Run("cosine vectors", func(t *testing.T) {
+		got := similarity(t, api.SimilarityRequest{Vectors: [][]float32{{1, 0}, {1, 1}, {0, 2}}})
+		want := [][]float64{
+			{1, math.Sqrt2 / 2, 0},
+			{math.Sqrt2 / 2, 1, math.Sqrt2 / 2},
+			{0, math.Sqrt2 / 2, 1},
+		}
+		if !equal(got, want) {
+			t.Errorf("expected %v, got %v", want, got)
+		}
+	})
+
+	t.Run("dot vectors", func(t *testing.T) {
+		got := similarity(t, api.SimilarityRequest{Vectors: [][]float32{{1, 0}, {2, 3}}, Metric: "dot"})
+		if want := [][]float64{{1, 2}, {2, 13}}; !equal(got, want) {
+			t.Errorf("expected %v, got %v", want, got)
+		}
+	})
+
+	t.Run("texts", func(t *testing.T) {
+		got := similarity(t, api.SimilarityRequest{Model: "embed", Texts: []string{"cat", "kitten", "car"}})
+		want := [][]float64{
+			{1, 0.96, 0},
+			{0.96, 1, -0.28},
+			{0, -0.28, 1},
+		}
+		if !equal(got, want) {
+			t.Errorf("expected %v, got %v", want, got)
+		}
+	})
+
+	for _, tt := range []struct {
+		name string
+		req  api.SimilarityRequest
+		code int
+	}{
+		{"no inputs", api.SimilarityRequest{}, http.StatusBadRequest},
+		{"vectors and texts", api.SimilarityRequest{Vectors: [][]float32{{1}}, Texts: []string{"cat"}, Model: "embed"}, http.StatusBadRequest},
+		{"mismatched dimensions", api.SimilarityRequest{Vectors: [][]float32{{1}, {1, 2}}}, http.StatusBadRequest},
+		{"unknown metric", api.SimilarityRequest{Vectors: [][]float32{{1}}, Metric: "l2"}, http.StatusBadRequest},
+		{"missing model", api.SimilarityRequest{Texts: []string{"cat"}, Model: "missing"}, http.StatusNotFound},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			w := createRequest(t, s.SimilarityHandler, tt.req)
+			if w.Code != tt.code {
+				t.Errorf("expected status %d, got %d: %s", tt.code, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestKmeans(t *testing.T) {
+	vectors := [][]float32{
+		{0, 0}, {0, 1}, {1, 0},
+		{10, 10}, {10, 11}, {11, 10},
+		{-10, 10}, {-10, 11}, {-11, 10},
+	}
+
+	for seed := range uint64(10) {
+		resp := kmeans(vectors, 3, 100, rand.New(rand.NewPCG(seed, 0)))
+
+		for i := 0; i < len(vectors); i += 3 {
+			if resp.Labels[i] != resp.Labels[i+1] || resp.Labels[i] != resp.Labels[i+2] {
+				t.Fatalf("seed %d: expected vectors %d to %d in the same cluster, got %v", seed, i, i+2, resp.Labels)
+			}
+		}
+
+		if resp.Labels[0] == resp.Labels[3] || resp.Labels[0] == resp.Labels[6] || resp.Labels[3] == resp.Labels[6] {
+			t.Fatalf("seed %d: expected 3 clusters, got %v", seed, resp.Labels)
+		}
+
+		// each cluster has an inertia of 4/3
+		if math.Abs(resp.Inertia-4) > 1e-6 {
+			t.Errorf("seed %d: expected an inertia of 4, got %v", seed, resp.Inertia)
+		}
+
+		if centroid := resp.Centroids[resp.Labels[3]]; math.Abs(float64(centroid[0])-31./3) > 1e-5 || math.Abs(float64(centroid[1])-31./3) > 1e-5 {
+			t.Errorf("seed %d: unexpected centroid %v", seed, centroid)
+		}
+	}
+
+	if vectors[0][0] != 0 || vectors[3][0] != 10 {
+		t.Error("kmeans must not modify the vectors")
+	}
+}
+
+func TestClusterHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var s Server
+
+	t.Run("cluster", func(t *testing.T) {
+		w := createRequest(t, s.ClusterHandler, api.ClusterRequest{Vectors: [][]float32{{0}, {1}, {10}, {11}}, K: 2})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.ClusterResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(resp.Labels) != 4 || resp.Labels[0] != resp.Labels[1] || resp.Labels[2] != resp.Labels[3] || resp.Labels[0] == resp.Labels[2] {
+			t.Errorf("unexpected labels %v", resp.Labels)
+		}
+
+		if len(resp.Centroids) != 2 || resp.Iterations < 1 {
+			t.Errorf("unexpected response %+v", resp)
+		}
+	})
+
+	for _, tt := range []struct {
+		name string
+		req  api.ClusterRequest
+	}{
+		{"no vectors", api.ClusterRequest{K: 1}},
+		{"k too large", api.ClusterRequest{Vectors: [][]float32{{1}}, K: 2}},
+		{"k zero", api.ClusterRequest{Vectors: [][]float32{{1}}}},
+		{"negative iterations", api.ClusterRequest{Vectors: [][]float32{{1}}, K: 1, MaxIterations: -1}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			w := createRequest(t, s.ClusterHandler, tt.req)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400, got %d: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+// Several servers, such as per-user services or containers, may share one
+// OLLAMA_MODELS directory. They coordinate through file locks:
+//
+//   - pulls, creates and other writes of blobs and manifests hold the store
+//     lock shared, so any number of them can run at once
+//   - removals of unused blobs and manifest directories hold it exclusively,
+//     and are skipped while the store is in use since they can run later
+//   - downloads of a blob hold an exclusive lock of the blob, so that only
+//     one server writes its partial file
+//
+// The locks also coordinate the requests of a single server.
+
+// lockRetryInterval is how often a lock held by another server is retried
+const lockRetryInterval = 100 * time.Millisecond
+
+func storeLockPath() string {
+	return filepath.Join(envconfig.Models(), "store.lock")
+}
+
+// lockFile locks the file at path, creating it if needed, and waits until
+// the lock is acquired or ctx is done. The returned function unlocks it.
+func lockFile(ctx context.Context, path string, exclusive bool) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	t := time.NewTicker(lockRetryInterval)
+	defer t.Stop()
+
+	for {
+		if ok, err := tryLockFile(f, exclusive); err != nil {
+			f.Close()
+			return nil, err
+		} else if ok {
+			return func() {
+				unlockFile(f) //nolint:errcheck
+				f.Close()
+			}, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// lockStore locks the model store against the removal of unused files while
+// blobs and manifests are written.
+func lockStore(ctx context.Context) (func(), error) {
+	if envconfig.ModelsReadOnly() {
+		return nil, errModelsReadOnly
+	}
+
+	return lockFile(ctx, storeLockPath(), false)
+}
+
+// collectGarbage runs fn, which removes unused files from the model store,
+// unless the store is in use by this or another server.
+func collectGarbage(fn func() error) error {
+	if envconfig.ModelsReadOnly() {
+		return nil
+	}
+
+	if err := os.MkdirAll(envconfig.Models(), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(storeLockPath(), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if ok, err := tryLockFile(f, true); err != nil {
+		return err
+	} else if !ok {
+		slog.Info("model store is in use, skipping removal of unused files")
+		return nil
+	}
+	defer unlockFile(f) //nolint:errcheck
+
+	return fn()
+}
@@ -0,0 +1,31 @@
+//go:build !windows
+
+package server
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// tryLockFile locks f without blocking and reports whether it was locked.
+// Locks are advisory and held by open files, so files opened separately
+// exclude each other even within a process.
+func tryLockFile(f *os.File, exclusive bool) (bool, error) {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+
+	if err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB); errors.Is(err, syscall.EWOULDBLOCK) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
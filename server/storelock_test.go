@@ -0,0 +1,235 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/model"
+)
+
+func TestLockFile(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	ctx := context.Background()
+
+	// shared locks do not exclude each other
+	unlock1, err := lockStore(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unlock2, err := lockStore(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var collected bool
+	collect := func() error {
+		collected = true
+		return nil
+	}
+
+	if err := collectGarbage(collect); err != nil {
+		t.Fatal(err)
+	}
+
+	if collected {
+		t.Error("expected garbage collection to be skipped while the store is in use")
+	}
+
+	unlock1()
+	unlock2()
+
+	if err := collectGarbage(collect); err != nil {
+		t.Fatal(err)
+	}
+
+	if !collected {
+		t.Error("expected garbage collection to run")
+	}
+
+	// exclusive locks wait for the lock to be released
+	path := filepath.Join(t.TempDir(), "test.lock")
+	unlock, err := lockFile(ctx, path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, 3*lockRetryInterval)
+	defer cancel()
+
+	if _, err := lockFile(timeout, path, false); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the lock to time out, got %v", err)
+	}
+
+	go func() {
+		time.Sleep(lockRetryInterval)
+		unlock()
+	}()
+
+	unlock, err = lockFile(ctx, path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+}
+
+func TestWriteManifestFile(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	name := model.ParseName("test")
+	for _, layers := range [][]Layer{
+		{{MediaType: "application/vnd.ollama.image.model", Digest: "sha256:a", Size: 1}},
+		{{MediaType: "application/vnd.ollama.image.model", Digest: "sha256:b", Size: 2}},
+	} {
+		if err := WriteManifest(name, Layer{}, layers); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, err := ParseNamedManifest(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.Layers) != 1 || m.Layers[0].Digest != "sha256:b" {
+		t.Errorf("expected the manifest to be replaced, got %+v", m.Layers)
+	}
+
+	// temporary files are not left behind or listed as manifests
+	manifests, err := GetManifestPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	temps, err := filepath.Glob(filepath.Join(manifests, ".manifest-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(temps) > 0 {
+		t.Errorf("unexpected temporary files %v", temps)
+	}
+
+	ms, err := Manifests(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ms) != 1 {
+		t.Errorf("expected 1 manifest, got %d", len(ms))
+	}
+}
+
+func TestPruneLayersGracePeriod(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	blob := func(digest string, age time.Duration) string {
+		t.Helper()
+
+		p, err := GetBlobsPath(digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(p, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		modified := time.Now().Add(-age)
+		if err := os.Chtimes(p, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+
+		return p
+	}
+
+	recent := blob("sha256:"+testDigest(1), time.Minute)
+	old := blob("sha256:"+testDigest(2), 2*pruneGracePeriod)
+
+	if err := PruneLayers(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(recent); err != nil {
+		t.Errorf("expected a recent blob to be kept: %v", err)
+	}
+
+	if _, err := os.Stat(old); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected an old blob to be removed: %v", err)
+	}
+}
+
+func TestDownloadBlobLocked(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s", r.URL)
+		http.NotFound(w, r)
+	}))
+	defer registry.Close()
+
+	digest := "sha256:" + testDigest(1)
+	fp, err := GetBlobsPath(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// another server is downloading the blob
+	unlock, err := lockFile(context.Background(), fp+"-lock", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type result struct {
+		cacheHit bool
+		err      error
+	}
+
+	done := make(chan result)
+	go func() {
+		cacheHit, err := downloadBlob(context.Background(), downloadOpts{
+			mp:      ParseModelPath("http://" + registry.Listener.Addr().String() + "/library/test"),
+			digest:  digest,
+			regOpts: &registryOptions{Insecure: true},
+			fn:      func(api.ProgressResponse) {},
+		})
+		done <- result{cacheHit, err}
+	}()
+
+	time.Sleep(2 * lockRetryInterval)
+	if err := os.WriteFile(fp, []byte("blob"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+
+		if !r.cacheHit {
+			t.Error("expected the blob downloaded by the other server to be used")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the download")
+	}
+}
+
+// testDigest returns a hex digest that repeats the hex digit n
+func testDigest(n int) string {
+	b := make([]byte, 64)
+	for i := range b {
+		b[i] = "0123456789abcdef"[n%16]
+	}
+
+	return string(b)
+}
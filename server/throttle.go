@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+)
+
+// keyTokenLimits throttle the generated tokens of all requests with the same
+// API key to OLLAMA_MAX_TPS, so that the limit applies to their combined rate.
+var keyTokenLimits = &tokenLimits{limiters: make(map[string]*tokenLimiter)}
+
+// tokenLimitIdle is how long the limiter of an API key is kept after its last
+// request. A limiter that has been idle for a second is full again, so
+// dropping it later doesn't change how requests are throttled.
+const tokenLimitIdle = time.Minute
+
+type tokenLimits struct {
+	mu       sync.Mutex
+	limiters map[string]*tokenLimiter
+}
+
+type tokenLimiter struct {
+	*rate.Limiter
+	used time.Time
+}
+
+// get returns the limiter of key at tps tokens per second.
+func (l *tokenLimits) get(key string, tps float64, now time.Time) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for k, limiter := range l.limiters {
+		if now.Sub(limiter.used) > tokenLimitIdle {
+			delete(l.limiters, k)
+		}
+	}
+
+	limiter, ok := l.limiters[key]
+	if !ok {
+		limiter = &tokenLimiter{Limiter: newTokenLimiter(tps)}
+		l.limiters[key] = limiter
+	} else if limiter.Limit() != rate.Limit(tps) {
+		limiter.SetLimit(rate.Limit(tps))
+		limiter.SetBurst(tokenBurst(tps))
+	}
+
+	limiter.used = now
+	return limiter.Limiter
+}
+
+// newTokenLimiter returns a limiter of tps tokens per second that allows
+// bursts of up to a second of tokens.
+func newTokenLimiter(tps float64) *rate.Limiter {
+	return rate.NewLimiter(rate.Limit(tps), tokenBurst(tps))
+}
+
+func tokenBurst(tps float64) int {
+	return max(int(tps), 1)
+}
+
+// apiKey returns the bearer token of r, which clients such as the OpenAI
+// libraries send as their API key.
+func apiKey(r *http.Request) string {
+	key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return ""
+	}
+
+	return strings.TrimSpace(key)
+}
+
+// throttle returns the function that limits the rate of the generated tokens
+// of r, or nil if it isn't limited. OLLAMA_MAX_TPS limits the tokens of all
+// requests with the API key of r or, without one, of r alone, and the max_tps
+// option limits r.
+func throttle(r *http.Request, opts *api.Options) func(context.Context, int) error {
+	var limiters []*rate.Limiter
+
+	tps := float64(opts.MaxTPS)
+	if maxTPS := float64(envconfig.MaxTPS()); maxTPS > 0 {
+		if key := apiKey(r); key != "" {
+			limiters = append(limiters, keyTokenLimits.get(key, maxTPS, time.Now()))
+		} else if tps <= 0 || maxTPS < tps {
+			tps = maxTPS
+		}
+	}
+
+	if tps > 0 {
+		limiters = append(limiters, newTokenLimiter(tps))
+	}
+
+	if len(limiters) == 0 {
+		return nil
+	}
+
+	return func(ctx context.Context, n int) error {
+		for _, limiter := range limiters {
+			for remaining := n; remaining > 0; {
+				chunk := min(remaining, limiter.Burst())
+				if err := limiter.WaitN(ctx, chunk); err != nil {
+					return err
+				}
+
+				remaining -= chunk
+			}
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestThrottle(t *testing.T) {
+	request := func(key string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/api/generate", nil)
+		if key != "" {
+			r.Header.Set("Authorization", "Bearer "+key)
+		}
+		return r
+	}
+
+	t.Run("unlimited", func(t *testing.T) {
+		t.Setenv("OLLAMA_MAX_TPS", "")
+		if throttle(request("key"), &api.Options{}) != nil {
+			t.Error("expected no throttle")
+		}
+	})
+
+	t.Run("server limit", func(t *testing.T) {
+		t.Setenv("OLLAMA_MAX_TPS", "100")
+		if throttle(request(""), &api.Options{}) == nil {
+			t.Error("expected a throttle without an API key")
+		}
+
+		if throttle(request("key"), &api.Options{}) == nil {
+			t.Error("expected a throttle with an API key")
+		}
+	})
+
+	t.Run("request limit", func(t *testing.T) {
+		t.Setenv("OLLAMA_MAX_TPS", "")
+		fn := throttle(request(""), &api.Options{MaxTPS: 200})
+		if fn == nil {
+			t.Fatal("expected a throttle")
+		}
+
+		// the first second of tokens is a burst, the rest waits
+		start := time.Now()
+		if err := fn(t.Context(), 220); err != nil {
+			t.Fatal(err)
+		}
+
+		if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+			t.Errorf("expected to wait about 100ms, waited %s", elapsed)
+		}
+
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+		if err := fn(ctx, 1); err == nil {
+			t.Error("expected an error when canceled")
+		}
+	})
+}
+
+func TestTokenLimits(t *testing.T) {
+	limits := &tokenLimits{limiters: make(map[string]*tokenLimiter)}
+	now := time.Now()
+
+	a := limits.get("a", 10, now)
+	if limits.get("a", 10, now) != a {
+		t.Error("expected requests with the same key to share a limiter")
+	}
+
+	if limits.get("b", 10, now) == a {
+		t.Error("expected requests with different keys to have their own limiters")
+	}
+
+	if limits.get("a", 20, now) != a || a.Limit() != rate.Limit(20) || a.Burst() != 20 {
+		t.Errorf("expected the limit to change to 20, got %v with burst %d", a.Limit(), a.Burst())
+	}
+
+	later := now.Add(2 * tokenLimitIdle)
+	limits.get("a", 20, later)
+	if _, ok := limits.limiters["b"]; ok {
+		t.Error("expected the idle limiter to be dropped")
+	}
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/netutil"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+// newHTTPServer returns the server for the API with the limits configured in
+// the environment, so that a client can't hold on to connections by sending
+// or reading slowly or by leaving them idle.
+func newHTTPServer() *http.Server {
+	return &http.Server{
+		// Use http.DefaultServeMux so we get net/http/pprof for
+		// free.
+		//
+		// TODO(bmizerany): Decide if we want to make this
+		// configurable so it is not exposed by default, or allow
+		// users to bind it to a different port. This was a quick
+		// and easy way to get pprof, but it may not be the best
+		// way.
+		Handler:   nil,
+		Protocols: serverProtocols(),
+
+		// clients that don't send their headers in time are disconnected,
+		// stalls while sending or receiving bodies are limited by
+		// timeoutMiddleware, which applies to each request rather than the
+		// whole connection
+		ReadHeaderTimeout: envconfig.ReadHeaderTimeout(),
+		IdleTimeout:       envconfig.IdleTimeout(),
+		MaxHeaderBytes:    int(envconfig.MaxHeaderBytes()),
+	}
+}
+
+// limitListener limits the connections ln accepts at once to
+// OLLAMA_MAX_CONNECTIONS. Further connections wait to be accepted until
+// others close.
+func limitListener(ln net.Listener) net.Listener {
+	if n := envconfig.MaxConnections(); n > 0 {
+		return netutil.LimitListener(ln, int(n))
+	}
+
+	return ln
+}
+
+// errRequestTimeout is returned when reading a request body that the client
+// stopped sending for longer than the read timeout.
+var errRequestTimeout = errors.New("request timed out")
+
+// timeoutMiddleware limits how long a client may stall while sending the body
+// of a request to read, and while receiving its response to write. The limits
+// apply to each read and write rather than the whole request, so large uploads
+// and long streamed responses are unaffected as long as they make progress.
+// Requests whose body stalls get a 408 response.
+func timeoutMiddleware(read, write time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if read <= 0 && write <= 0 {
+			c.Next()
+			return
+		}
+
+		rc := http.NewResponseController(c.Writer)
+		w := &timeoutWriter{ResponseWriter: c.Writer, rc: rc, timeout: write}
+		c.Writer = w
+		defer func() {
+			c.Writer = w.ResponseWriter
+		}()
+
+		if read > 0 && c.Request.Body != nil {
+			c.Request.Body = &timeoutBody{ReadCloser: c.Request.Body, rc: rc, timeout: read, w: w}
+		}
+
+		c.Next()
+	}
+}
+
+// timeoutBody extends the read deadline of the connection before each read.
+type timeoutBody struct {
+	io.ReadCloser
+	rc      *http.ResponseController
+	timeout time.Duration
+	w       *timeoutWriter
+}
+
+func (b *timeoutBody) Read(p []byte) (int, error) {
+	// writers that don't support deadlines, such as recorders in tests,
+	// aren't limited
+	b.rc.SetReadDeadline(time.Now().Add(b.timeout))
+
+	n, err := b.ReadCloser.Read(p)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		b.w.requestTimeout(b.timeout)
+		return n, errRequestTimeout
+	}
+
+	return n, err
+}
+
+// timeoutWriter extends the write deadline of the connection before each
+// write. Once the request body timed out, it answers with a 408 response and
+// discards what the handler writes after failing to read the body.
+type timeoutWriter struct {
+	gin.ResponseWriter
+	rc       *http.ResponseController
+	timeout  time.Duration
+	timedOut bool
+}
+
+// requestTimeout sends the 408 response unless the handler already started
+// its response.
+func (w *timeoutWriter) requestTimeout(timeout time.Duration) {
+	if w.timedOut || w.ResponseWriter.Written() {
+		return
+	}
+
+	w.timedOut = true
+	body, _ := json.Marshal(gin.H{"error": fmt.Sprintf("request timed out: no request body received for %s", timeout)})
+	w.ResponseWriter.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.ResponseWriter.WriteHeader(http.StatusRequestTimeout)
+	w.deadline()
+	w.ResponseWriter.Write(body)
+}
+
+func (w *timeoutWriter) deadline() {
+	if w.timeout > 0 {
+		w.rc.SetWriteDeadline(time.Now().Add(w.timeout))
+	}
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	if !w.timedOut {
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *timeoutWriter) WriteHeaderNow() {
+	if !w.timedOut {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	if w.timedOut {
+		return len(b), nil
+	}
+
+	w.deadline()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *timeoutWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *timeoutWriter) Flush() {
+	if w.timedOut {
+		return
+	}
+
+	w.deadline()
+	w.ResponseWriter.Flush()
+}
+
+func (w *timeoutWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
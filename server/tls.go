@@ -0,0 +1,87 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+// tlsListener marks a listener that terminates TLS so the advertised
+// address uses the https scheme.
+type tlsListener struct {
+	net.Listener
+}
+
+// ListenTLS wraps ln so connections are served over TLS. Certificates are
+// read from the cert and key query parameters of host, falling back to
+// OLLAMA_TLS_CERT and OLLAMA_TLS_KEY. If no certificate is configured,
+// certificates for OLLAMA_ACME_DOMAINS are obtained automatically with ACME.
+func ListenTLS(ln net.Listener, host *url.URL) (net.Listener, error) {
+	config, err := tlsConfig(host)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tlsListener{tls.NewListener(ln, config)}, nil
+}
+
+func tlsConfig(host *url.URL) (*tls.Config, error) {
+	query := host.Query()
+
+	certFile, keyFile := query.Get("cert"), query.Get("key")
+	if certFile == "" && keyFile == "" {
+		certFile, keyFile = envconfig.TLSCert(), envconfig.TLSKey()
+	}
+
+	switch {
+	case certFile != "" && keyFile != "":
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load tls certificate: %w", err)
+		}
+
+		return &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			Certificates: []tls.Certificate{cert},
+			NextProtos:   []string{"h2", "http/1.1"},
+		}, nil
+	case certFile != "" || keyFile != "":
+		return nil, errors.New("tls requires both a certificate and a key")
+	}
+
+	var domains []string
+	for _, domain := range strings.Split(envconfig.ACMEDomains(), ",") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+
+	if len(domains) == 0 {
+		return nil, fmt.Errorf("%s: no tls certificate configured, set OLLAMA_TLS_CERT and OLLAMA_TLS_KEY or OLLAMA_ACME_DOMAINS", host.Host)
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Cache:      autocert.DirCache(filepath.Join(home, ".ollama", "acme")),
+		Email:      envconfig.ACMEEmail(),
+	}
+
+	config := m.TLSConfig()
+	config.MinVersion = tls.VersionTLS12
+	return config, nil
+}
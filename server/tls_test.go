@@ -0,0 +1,190 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestCert(t *testing.T) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
+
+func TestTLSConfig(t *testing.T) {
+	certFile, keyFile := writeTestCert(t)
+
+	t.Run("env", func(t *testing.T) {
+		t.Setenv("OLLAMA_TLS_CERT", certFile)
+		t.Setenv("OLLAMA_TLS_KEY", keyFile)
+
+		config, err := tlsConfig(&url.URL{Scheme: "https", Host: "127.0.0.1:0"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(config.Certificates) != 1 {
+			t.Fatalf("expected 1 certificate, got %d", len(config.Certificates))
+		}
+	})
+
+	t.Run("query", func(t *testing.T) {
+		t.Setenv("OLLAMA_TLS_CERT", "")
+		t.Setenv("OLLAMA_TLS_KEY", "")
+
+		host := &url.URL{Scheme: "https", Host: "127.0.0.1:0", RawQuery: url.Values{"cert": {certFile}, "key": {keyFile}}.Encode()}
+		if _, err := tlsConfig(host); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		t.Setenv("OLLAMA_TLS_CERT", certFile)
+		t.Setenv("OLLAMA_TLS_KEY", "")
+
+		if _, err := tlsConfig(&url.URL{Scheme: "https", Host: "127.0.0.1:0"}); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+
+	t.Run("no certificate", func(t *testing.T) {
+		t.Setenv("OLLAMA_TLS_CERT", "")
+		t.Setenv("OLLAMA_TLS_KEY", "")
+		t.Setenv("OLLAMA_ACME_DOMAINS", "")
+
+		if _, err := tlsConfig(&url.URL{Scheme: "https", Host: "127.0.0.1:0"}); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+
+	t.Run("acme", func(t *testing.T) {
+		t.Setenv("HOME", t.TempDir())
+		t.Setenv("OLLAMA_TLS_CERT", "")
+		t.Setenv("OLLAMA_TLS_KEY", "")
+		t.Setenv("OLLAMA_ACME_DOMAINS", "ollama.example.com")
+
+		config, err := tlsConfig(&url.URL{Scheme: "https", Host: "127.0.0.1:0"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if config.GetCertificate == nil {
+			t.Fatal("expected GetCertificate to be set")
+		}
+	})
+}
+
+func TestListenTLS(t *testing.T) {
+	certFile, keyFile := writeTestCert(t)
+	t.Setenv("OLLAMA_TLS_CERT", certFile)
+	t.Setenv("OLLAMA_TLS_KEY", keyFile)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tln, err := ListenTLS(ln, &url.URL{Scheme: "https", Host: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tln.Close()
+
+	addrFile := filepath.Join(t.TempDir(), "addrs")
+	t.Setenv("OLLAMA_ADDR_FILE", addrFile)
+	if err := writeAddrs([]net.Listener{tln}); err != nil {
+		t.Fatal(err)
+	}
+
+	bts, err := os.ReadFile(addrFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := strings.TrimSpace(string(bts))
+	if addr != "https://"+tln.Addr().String() {
+		t.Fatalf("unexpected address %q", addr)
+	}
+
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}), Protocols: serverProtocols()}
+	go srv.Serve(tln)
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.AppendCertsFromPEM(certPEM)
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}, ForceAttemptHTTP2: true}}
+	resp, err := client.Get(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(body) != "ok" {
+		t.Fatalf("unexpected body %q", body)
+	}
+
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("expected HTTP/2, got %s", resp.Proto)
+	}
+}
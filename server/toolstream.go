@@ -0,0 +1,211 @@
+package server
+
+import (
+	"encoding/json"
+
+	"github.com/ollama/ollama/api"
+)
+
+// toolCallStreamer finds the tool calls in a response while it is generated
+// and returns what was added to them with each chunk, so that clients can
+// act on tool calls before they are complete.
+type toolCallStreamer struct {
+	// name and arguments are the keys of the name and arguments of the tool
+	// calls, as in [Model.parseToolCalls]
+	name, arguments string
+
+	// index is the index of the first call in text
+	index int
+	text  string
+
+	// sent is how much of each call has been returned
+	sent []sentToolCall
+}
+
+type sentToolCall struct {
+	name      bool
+	arguments int
+}
+
+// partialToolCall is a tool call that may still be generated.
+type partialToolCall struct {
+	name string
+
+	// arguments is the JSON text of the arguments so far
+	arguments string
+}
+
+// newToolCallStreamer returns a streamer for the tool calls of m, or nil if
+// its template doesn't format tool calls as JSON.
+func newToolCallStreamer(m *Model) *toolCallStreamer {
+	name, arguments, ok := m.toolCallKeys()
+	if !ok {
+		return nil
+	}
+
+	return &toolCallStreamer{name: name, arguments: arguments}
+}
+
+// add adds content to the response and returns the changes to its tool
+// calls. A call's name is returned once it is complete, followed by its
+// arguments as they grow.
+func (t *toolCallStreamer) add(content string) []api.ToolCallDelta {
+	if t == nil {
+		return nil
+	}
+
+	t.text += content
+
+	var deltas []api.ToolCallDelta
+	for i, call := range t.scan() {
+		if i == len(t.sent) {
+			t.sent = append(t.sent, sentToolCall{})
+		}
+
+		sent := &t.sent[i]
+		if call.name == "" {
+			continue
+		}
+
+		delta := api.ToolCallDelta{Index: t.index + i}
+		if !sent.name {
+			delta.Name = call.name
+			sent.name = true
+		}
+
+		if len(call.arguments) > sent.arguments {
+			delta.Arguments = call.arguments[sent.arguments:]
+			sent.arguments = len(call.arguments)
+		}
+
+		if delta.Name != "" || delta.Arguments != "" {
+			deltas = append(deltas, delta)
+		}
+	}
+
+	return deltas
+}
+
+// reset starts over after the tool calls so far were parsed, with index the
+// index of the next call.
+func (t *toolCallStreamer) reset(index int) {
+	if t == nil {
+		return
+	}
+
+	t.index, t.text, t.sent = index, "", nil
+}
+
+// scan finds the objects in the text with a name or arguments key, which
+// may be incomplete. Text outside of JSON objects and arrays is skipped.
+func (t *toolCallStreamer) scan() []partialToolCall {
+	type frame struct {
+		object    bool
+		expectKey bool
+		key       string
+
+		// call is the index of the call of the object, or -1
+		call int
+	}
+
+	var calls []partialToolCall
+	var stack []frame
+
+	// callOf returns the call of the innermost object
+	callOf := func() *partialToolCall {
+		f := &stack[len(stack)-1]
+		if f.call < 0 {
+			calls = append(calls, partialToolCall{})
+			f.call = len(calls) - 1
+		}
+
+		return &calls[f.call]
+	}
+
+	inString, escaped := false, false
+	var stringStart int
+
+	// while in arguments, only their depth is tracked
+	var arguments *partialToolCall
+	var argumentsStart, argumentsDepth int
+
+	for i := 0; i < len(t.text); i++ {
+		c := t.text[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+				if arguments != nil || len(stack) == 0 || !stack[len(stack)-1].object {
+					continue
+				}
+
+				var s string
+				if err := json.Unmarshal([]byte(t.text[stringStart:i+1]), &s); err != nil {
+					continue
+				}
+
+				f := &stack[len(stack)-1]
+				if f.expectKey {
+					f.key = s
+				} else if f.key == t.name {
+					callOf().name = s
+				}
+			}
+			continue
+		}
+
+		if arguments != nil {
+			switch c {
+			case '"':
+				inString = true
+			case '{', '[':
+				argumentsDepth++
+			case '}', ']':
+				argumentsDepth--
+				if argumentsDepth == 0 {
+					arguments.arguments = t.text[argumentsStart : i+1]
+					arguments = nil
+				}
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			if len(stack) > 0 {
+				inString, stringStart = true, i
+			}
+		case '{':
+			if n := len(stack); n > 0 && stack[n-1].object && !stack[n-1].expectKey && stack[n-1].key == t.arguments {
+				arguments, argumentsStart, argumentsDepth = callOf(), i, 1
+				continue
+			}
+
+			stack = append(stack, frame{object: true, expectKey: true, call: -1})
+		case '[':
+			stack = append(stack, frame{call: -1})
+		case '}', ']':
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case ':':
+			if len(stack) > 0 {
+				stack[len(stack)-1].expectKey = false
+			}
+		case ',':
+			if len(stack) > 0 && stack[len(stack)-1].object {
+				stack[len(stack)-1].expectKey = true
+			}
+		}
+	}
+
+	if arguments != nil {
+		arguments.arguments = t.text[argumentsStart:]
+	}
+
+	return calls
+}
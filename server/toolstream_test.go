@@ -0,0 +1,103 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestToolCallStreamer(t *testing.T) {
+	cases := []struct {
+		name   string
+		chunks []string
+		calls  []api.ToolCallDelta
+	}{
+		{
+			name:   "plain text",
+			chunks: []string{"The weather ", "is {sunny}."},
+		},
+		{
+			name:   "one call",
+			chunks: []string{`{"na`, `me": "get_`, `weather", "arg`, `uments": {"location": "Par`, `is"}}`},
+			calls:  []api.ToolCallDelta{{Index: 0, Name: "get_weather", Arguments: `{"location": "Paris"}`}},
+		},
+		{
+			name:   "tags and array",
+			chunks: []string{"<tool_call>[", `{"name": "a", "arguments": {}}, `, `{"name": "b", "arguments": {"x": [1, {"y": "}"}]}}`, "]</tool_call>"},
+			calls: []api.ToolCallDelta{
+				{Index: 0, Name: "a", Arguments: `{}`},
+				{Index: 1, Name: "b", Arguments: `{"x": [1, {"y": "}"}]}`},
+			},
+		},
+		{
+			name:   "name in arguments",
+			chunks: []string{`{"name": "search", "arguments": {"name": "ollama", "q": "\"quoted\""}}`},
+			calls:  []api.ToolCallDelta{{Index: 0, Name: "search", Arguments: `{"name": "ollama", "q": "\"quoted\""}`}},
+		},
+		{
+			name:   "nested",
+			chunks: []string{`{"type": "function", "function": {"name": "f", "arguments": {"n": 1}}}`},
+			calls:  []api.ToolCallDelta{{Index: 0, Name: "f", Arguments: `{"n": 1}`}},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := &toolCallStreamer{name: "name", arguments: "arguments"}
+
+			// join the deltas of each call to compare them
+			calls := make(map[int]*api.ToolCallDelta)
+			var order []int
+			for _, chunk := range tt.chunks {
+				for _, d := range ts.add(chunk) {
+					c, ok := calls[d.Index]
+					if !ok {
+						if d.Name == "" {
+							t.Fatalf("expected the name of call %d first, got %+v", d.Index, d)
+						}
+
+						c = &api.ToolCallDelta{Index: d.Index}
+						calls[d.Index] = c
+						order = append(order, d.Index)
+					} else if d.Name != "" {
+						t.Errorf("expected the name of call %d once, got %+v", d.Index, d)
+					}
+
+					c.Name += d.Name
+					c.Arguments += d.Arguments
+				}
+			}
+
+			var got []api.ToolCallDelta
+			for _, i := range order {
+				got = append(got, *calls[i])
+			}
+
+			if diff := cmp.Diff(got, tt.calls); diff != "" {
+				t.Errorf("mismatch (-got +want):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("reset", func(t *testing.T) {
+		ts := &toolCallStreamer{name: "name", arguments: "arguments"}
+		ts.add(`{"name": "a", "arguments": {}}`)
+		ts.reset(1)
+
+		got := ts.add(`{"name": "b", "arguments": {}}`)
+		if diff := cmp.Diff(got, []api.ToolCallDelta{{Index: 1, Name: "b", Arguments: "{}"}}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("nil", func(t *testing.T) {
+		var ts *toolCallStreamer
+		if got := ts.add(strings.Repeat("{", 3)); got != nil {
+			t.Errorf("expected no deltas, got %+v", got)
+		}
+		ts.reset(0)
+	})
+}
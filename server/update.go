@@ -0,0 +1,395 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/runner/common"
+	"github.com/ollama/ollama/types/errtypes"
+	"github.com/ollama/ollama/types/model"
+)
+
+// Update policies of local models
+const (
+	updatePolicyAuto   = "auto"
+	updatePolicyManual = "manual"
+	updatePolicyPinned = "pinned"
+)
+
+// updater checks the registry for new versions of local models and pulls them
+// as the update policies of the models allow. The policies are stored in the
+// models directory so that they persist across restarts, while the statuses
+// of the checks are kept in memory.
+type updater struct {
+	mu     sync.Mutex
+	status map[string]api.ModelUpdate
+
+	// regOpts are the registry options of checks and pulls
+	regOpts registryOptions
+}
+
+// updateKey is the key of name in the policies and statuses of updates
+func updateKey(name model.Name) string {
+	return strings.ToLower(name.String())
+}
+
+func updatesPath() string {
+	return filepath.Join(envconfig.Models(), "updates.json")
+}
+
+// policies reads the update policies of models that have one.
+func (u *updater) policies() (map[string]string, error) {
+	var f struct {
+		Policies map[string]string `json:"policies"`
+	}
+
+	bts, err := os.ReadFile(updatesPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(bts, &f); err != nil {
+		return nil, fmt.Errorf("%s: %w", updatesPath(), err)
+	}
+
+	if f.Policies == nil {
+		f.Policies = make(map[string]string)
+	}
+
+	return f.Policies, nil
+}
+
+// setPolicy sets the update policy of name, or resets it to the default if
+// policy is empty.
+func (u *updater) setPolicy(name model.Name, policy string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	// other servers sharing the models directory may change policies too
+	unlock, err := lockFile(context.TODO(), updatesPath()+".lock", true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	policies, err := u.policies()
+	if err != nil {
+		return err
+	}
+
+	if policy == "" {
+		delete(policies, updateKey(name))
+	} else {
+		policies[updateKey(name)] = policy
+	}
+
+	bts, err := json.MarshalIndent(map[string]any{"policies": policies}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write the policies atomically so that a crash cannot lose all of them
+	temp, err := os.CreateTemp(filepath.Dir(updatesPath()), "updates-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(bts); err != nil {
+		temp.Close()
+		return err
+	}
+
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), updatesPath())
+}
+
+// list returns the update policies and statuses of the local models, sorted
+// by name.
+func (u *updater) list() ([]api.ModelUpdate, error) {
+	ms, err := Manifests(true)
+	if err != nil {
+		return nil, err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	policies, err := u.policies()
+	if err != nil {
+		return nil, err
+	}
+
+	updates := make([]api.ModelUpdate, 0, len(ms))
+	for name := range ms {
+		update := u.status[updateKey(name)]
+		update.Model = name.DisplayShortest()
+		update.Policy = cmp.Or(policies[updateKey(name)], envconfig.UpdatePolicy())
+		updates = append(updates, update)
+	}
+
+	slices.SortFunc(updates, func(a, b api.ModelUpdate) int {
+		return cmp.Compare(a.Model, b.Model)
+	})
+
+	return updates, nil
+}
+
+// run checks for updates every interval until ctx is done.
+func (u *updater) run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		u.checkAll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// checkAll checks every local model that is not pinned for updates, one at a
+// time to limit the load on the registry and the disk.
+func (u *updater) checkAll(ctx context.Context) {
+	updates, err := u.list()
+	if err != nil {
+		slog.Warn("unable to check for model updates", "error", err)
+		return
+	}
+
+	for _, update := range updates {
+		if ctx.Err() != nil {
+			return
+		}
+
+		if update.Policy == updatePolicyPinned {
+			continue
+		}
+
+		u.check(ctx, model.ParseName(update.Model), update.Policy)
+	}
+}
+
+// check checks name for an update and pulls it if policy is auto.
+func (u *updater) check(ctx context.Context, name model.Name, policy string) {
+	u.mu.Lock()
+	prev := u.status[updateKey(name)]
+	u.mu.Unlock()
+
+	update := api.ModelUpdate{
+		Model:     name.DisplayShortest(),
+		Policy:    policy,
+		Status:    "up to date",
+		CheckedAt: time.Now(),
+		UpdatedAt: prev.UpdatedAt,
+	}
+
+	// each check has its own options since the token is for its scope
+	regOpts := u.regOpts
+	available, err := updateAvailable(ctx, name, &regOpts)
+	switch {
+	case err != nil:
+		update.Status, update.Error = "failed", err.Error()
+	case available && policy == updatePolicyAuto:
+		if err := pullUpdate(ctx, name, &regOpts); err != nil {
+			update.Status, update.Error = "failed", err.Error()
+		} else {
+			update.Status, update.UpdatedAt = "updated", time.Now()
+		}
+	case available:
+		update.Status = "available"
+	}
+
+	if ctx.Err() != nil {
+		// the server is shutting down
+		return
+	}
+
+	u.mu.Lock()
+	if u.status == nil {
+		u.status = make(map[string]api.ModelUpdate)
+	}
+	u.status[updateKey(name)] = update
+	u.mu.Unlock()
+
+	if update.Status != prev.Status || update.Status == "updated" {
+		notifyUpdate(ctx, update)
+	}
+}
+
+// updateAvailable reports whether the manifest of name in the registry has
+// different layers than the local one.
+func updateAvailable(ctx context.Context, name model.Name, regOpts *registryOptions) (bool, error) {
+	local, err := ParseNamedManifest(name)
+	if err != nil {
+		return false, err
+	}
+
+	remote, err := pullModelManifest(ctx, ParseModelPath(name.String()), regOpts)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, errors.New("model not found in the registry")
+	} else if err != nil {
+		return false, err
+	}
+
+	digests := func(m *Manifest) []string {
+		var digests []string
+		for _, l := range append([]Layer{m.Config}, m.Layers...) {
+			digests = append(digests, l.Digest)
+		}
+		return digests
+	}
+
+	return !slices.Equal(digests(local), digests(remote)), nil
+}
+
+// pullUpdate pulls name in the background. The blobs are downloaded with a
+// low I/O priority, which only applies to the server process and not to the
+// runners, and the new manifest replaces the local one only once its model
+// files are valid.
+func pullUpdate(ctx context.Context, name model.Name, regOpts *registryOptions) error {
+	if restore, err := common.LowerIOPriority(); err != nil {
+		slog.Debug("unable to lower i/o priority while updating", "error", err)
+	} else {
+		defer restore()
+	}
+
+	return pullModel(ctx, name.String(), regOpts, func(m *Manifest) error {
+		for _, layer := range m.Layers {
+			switch layer.MediaType {
+			case "application/vnd.ollama.image.model",
+				"application/vnd.ollama.image.projector",
+				"application/vnd.ollama.image.adapter":
+				if err := validateBlob(ctx, layer.Digest); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}, func(api.ProgressResponse) {})
+}
+
+// notifyUpdate logs update and posts it to the update webhook, if any.
+func notifyUpdate(ctx context.Context, update api.ModelUpdate) {
+	if update.Status == "failed" {
+		slog.Warn("model update", "model", update.Model, "status", update.Status, "error", update.Error)
+	} else {
+		slog.Info("model update", "model", update.Model, "status", update.Status)
+	}
+
+	webhook := envconfig.UpdateWebhook()
+	if webhook == "" {
+		return
+	}
+
+	bts, err := json.Marshal(update)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(bts))
+	if err != nil {
+		slog.Warn("invalid update webhook", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		slog.Warn("unable to post model update", "error", err)
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body) //nolint:errcheck
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		slog.Warn("unable to post model update", "status", resp.Status)
+	}
+}
+
+func (s *Server) UpdatesHandler(c *gin.Context) {
+	updates, err := s.updates.list()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, api.UpdatesResponse{Models: updates})
+}
+
+func (s *Server) UpdatePolicyHandler(c *gin.Context) {
+	var req api.UpdatePolicyRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !slices.Contains([]string{"", updatePolicyAuto, updatePolicyManual, updatePolicyPinned}, req.Policy) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown policy %q, expected auto, manual or pinned", req.Policy)})
+		return
+	}
+
+	name := model.ParseName(req.Model)
+	if !name.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": errtypes.InvalidModelNameErrMsg})
+		return
+	}
+
+	name, err = getExistingName(name)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := ParseNamedManifest(name); err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, api.ErrorResponse{Error: fmt.Sprintf("model '%s' not found", req.Model), Code: api.ErrorCodeModelNotFound})
+		return
+	}
+
+	if err := s.updates.setPolicy(name, req.Policy); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.updates.mu.Lock()
+	update := s.updates.status[updateKey(name)]
+	s.updates.mu.Unlock()
+
+	update.Model = name.DisplayShortest()
+	update.Policy = cmp.Or(req.Policy, envconfig.UpdatePolicy())
+	c.JSON(http.StatusOK, update)
+}
@@ -0,0 +1,304 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/types/model"
+)
+
+func TestUpdatePolicyHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_UPDATE_POLICY", "")
+
+	var s Server
+	_, digest := createBinFile(t, nil, nil)
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:   "test",
+		Files:  map[string]string{"test.gguf": digest},
+		Stream: &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	updates := func(t *testing.T) []api.ModelUpdate {
+		t.Helper()
+
+		w := createRequest(t, s.UpdatesHandler, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resp api.UpdatesResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		return resp.Models
+	}
+
+	if got := updates(t); len(got) != 1 || got[0].Model != "test:latest" || got[0].Policy != "manual" {
+		t.Errorf("expected the default policy, got %+v", got)
+	}
+
+	w = createRequest(t, s.UpdatePolicyHandler, api.UpdatePolicyRequest{Model: "test", Policy: "pinned"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp api.ModelUpdate
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Model != "test:latest" || resp.Policy != "pinned" {
+		t.Errorf("unexpected response %+v", resp)
+	}
+
+	// the policy persists in the models directory
+	s = Server{}
+	if got := updates(t); len(got) != 1 || got[0].Policy != "pinned" {
+		t.Errorf("expected the pinned policy, got %+v", got)
+	}
+
+	t.Setenv("OLLAMA_UPDATE_POLICY", "auto")
+	createRequest(t, s.UpdatePolicyHandler, api.UpdatePolicyRequest{Model: "test"})
+	if got := updates(t); len(got) != 1 || got[0].Policy != "auto" {
+		t.Errorf("expected the default policy, got %+v", got)
+	}
+
+	for _, tt := range []struct {
+		req  api.UpdatePolicyRequest
+		code int
+	}{
+		{api.UpdatePolicyRequest{Model: "test", Policy: "always"}, http.StatusBadRequest},
+		{api.UpdatePolicyRequest{Model: "missing", Policy: "auto"}, http.StatusNotFound},
+	} {
+		w := createRequest(t, s.UpdatePolicyHandler, tt.req)
+		if w.Code != tt.code {
+			t.Errorf("%+v: expected status %d, got %d: %s", tt.req, tt.code, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestUpdaterCheck(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var mu sync.Mutex
+	var notifications []api.ModelUpdate
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var update api.ModelUpdate
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			t.Error(err)
+		}
+
+		mu.Lock()
+		notifications = append(notifications, update)
+		mu.Unlock()
+	}))
+	defer webhook.Close()
+
+	t.Setenv("OLLAMA_UPDATE_WEBHOOK", webhook.URL)
+
+	notified := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+
+		var statuses []string
+		for _, n := range notifications {
+			statuses = append(statuses, n.Status)
+		}
+		notifications = nil
+		return statuses
+	}
+
+	// blobs served by the registry, by digest
+	blobs := make(map[string][]byte)
+	addBlob := func(b []byte) Layer {
+		digest := fmt.Sprintf("sha256:%x", sha256.Sum256(b))
+		blobs[digest] = b
+		return Layer{MediaType: "application/vnd.ollama.image.model", Digest: digest, Size: int64(len(b))}
+	}
+
+	f, err := os.CreateTemp(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := ggml.WriteGGUF(f, ggml.KV{"general.architecture": "llama", "general.name": "updated"}, []ggml.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	gguf, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := addBlob([]byte(`{"model_format":"gguf"}`))
+	config.MediaType = "application/vnd.docker.container.image.v1+json"
+
+	var remote Manifest
+	var registry *httptest.Server
+	registry = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch path := r.URL.Path; {
+		case path == "/v2/library/test/manifests/latest":
+			json.NewEncoder(w).Encode(remote) //nolint:errcheck
+		case strings.HasPrefix(path, "/v2/library/test/blobs/"):
+			// redirect to the blob storage on another host, as
+			// registries do
+			digest := strings.TrimPrefix(path, "/v2/library/test/blobs/")
+			http.Redirect(w, r, fmt.Sprintf("http://localhost:%d/blobs/%s", registry.Listener.Addr().(*net.TCPAddr).Port, digest), http.StatusTemporaryRedirect)
+		case strings.HasPrefix(path, "/blobs/"):
+			b, ok := blobs[strings.TrimPrefix(path, "/blobs/")]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(b))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer registry.Close()
+
+	name := model.ParseName(registry.Listener.Addr().String() + "/library/test")
+
+	var s Server
+	_, digest := createBinFile(t, nil, nil)
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:   name.String(),
+		Files:  map[string]string{"test.gguf": digest},
+		Stream: &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	local, err := ParseNamedManifest(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := updater{regOpts: registryOptions{Insecure: true}}
+	check := func(t *testing.T, policy, status string) {
+		t.Helper()
+
+		u.check(context.Background(), name, policy)
+
+		updates, err := u.list()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(updates) != 1 || updates[0].Status != status || updates[0].CheckedAt.IsZero() {
+			t.Fatalf("expected status %q, got %+v", status, updates)
+		}
+	}
+
+	layers := func(t *testing.T) []Layer {
+		t.Helper()
+
+		m, err := ParseNamedManifest(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return m.Layers
+	}
+
+	t.Run("up to date", func(t *testing.T) {
+		remote = Manifest{SchemaVersion: 2, Config: local.Config, Layers: local.Layers}
+		check(t, "auto", "up to date")
+
+		if got := notified(); !slices.Equal(got, []string{"up to date"}) {
+			t.Errorf("unexpected notifications %v", got)
+		}
+	})
+
+	t.Run("invalid update", func(t *testing.T) {
+		remote = Manifest{SchemaVersion: 2, Config: config, Layers: []Layer{addBlob([]byte("not a gguf file"))}}
+		check(t, "auto", "failed")
+
+		if !slices.Equal(layers(t), local.Layers) {
+			t.Error("expected the local manifest to be kept")
+		}
+
+		if got := notified(); !slices.Equal(got, []string{"failed"}) {
+			t.Errorf("unexpected notifications %v", got)
+		}
+	})
+
+	remote = Manifest{SchemaVersion: 2, Config: config, Layers: []Layer{addBlob(gguf)}}
+
+	t.Run("manual", func(t *testing.T) {
+		check(t, "manual", "available")
+
+		if !slices.Equal(layers(t), local.Layers) {
+			t.Error("expected the local manifest to be kept")
+		}
+
+		// only changes are notified
+		check(t, "manual", "available")
+		if got := notified(); !slices.Equal(got, []string{"available"}) {
+			t.Errorf("unexpected notifications %v", got)
+		}
+	})
+
+	t.Run("auto", func(t *testing.T) {
+		check(t, "auto", "updated")
+
+		if got := layers(t); !slices.Equal(got, remote.Layers) {
+			t.Errorf("expected the remote layers, got %v", got)
+		}
+
+		check(t, "auto", "up to date")
+		if got := notified(); !slices.Equal(got, []string{"updated", "up to date"}) {
+			t.Errorf("unexpected notifications %v", got)
+		}
+	})
+
+	t.Run("pinned", func(t *testing.T) {
+		if err := u.setPolicy(name, "pinned"); err != nil {
+			t.Fatal(err)
+		}
+
+		remote = Manifest{SchemaVersion: 2, Config: local.Config, Layers: local.Layers}
+		u.checkAll(context.Background())
+
+		if got := layers(t); slices.Equal(got, local.Layers) {
+			t.Error("expected pinned models not to be updated")
+		}
+
+		if got := notified(); len(got) != 0 {
+			t.Errorf("unexpected notifications %v", got)
+		}
+	})
+
+	if _, err := os.Stat(updatesPath()); err != nil {
+		t.Error(err)
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"hash"
 	"io"
-	"log/slog"
 	"math"
 	"net/http"
 	"net/url"
@@ -30,6 +29,7 @@ type blobUpload struct {
 
 	Total     int64
 	Completed atomic.Int64
+	Retries   atomic.Int32
 
 	Parts []blobUploadPart
 
@@ -109,7 +109,7 @@ func (b *blobUpload) Prepare(ctx context.Context, requestURL *url.URL, opts *reg
 	}
 
 	if len(b.Parts) > 0 {
-		slog.Info(fmt.Sprintf("uploading %s in %d %s part(s)", b.Digest[7:19], len(b.Parts), format.HumanBytes(b.Parts[0].Size)))
+		registryLog.Info(fmt.Sprintf("uploading %s in %d %s part(s)", b.Digest[7:19], len(b.Parts), format.HumanBytes(b.Parts[0].Size)))
 	}
 
 	requestURL, err = url.Parse(location)
@@ -158,8 +158,9 @@ func (b *blobUpload) Run(ctx context.Context, opts *registryOptions) {
 					case errors.Is(err, errMaxRetriesExceeded):
 						return err
 					case err != nil:
+						b.Retries.Add(1)
 						sleep := time.Second * time.Duration(math.Pow(2, float64(try)))
-						slog.Info(fmt.Sprintf("%s part %d attempt %d failed: %v, retrying in %s", b.Digest[7:19], part.N, try, err, sleep))
+						registryLog.Info(fmt.Sprintf("%s part %d attempt %d failed: %v, retrying in %s", b.Digest[7:19], part.N, try, err, sleep))
 						time.Sleep(sleep)
 						continue
 					}
@@ -201,7 +202,7 @@ func (b *blobUpload) Run(ctx context.Context, opts *registryOptions) {
 			break
 		} else if err != nil {
 			sleep := time.Second * time.Duration(math.Pow(2, float64(try)))
-			slog.Info(fmt.Sprintf("%s complete upload attempt %d failed: %v, retrying in %s", b.Digest[7:19], try, err, sleep))
+			registryLog.Info(fmt.Sprintf("%s complete upload attempt %d failed: %v, retrying in %s", b.Digest[7:19], try, err, sleep))
 			time.Sleep(sleep)
 			continue
 		}
@@ -228,7 +229,7 @@ func (b *blobUpload) uploadPart(ctx context.Context, method string, requestURL *
 	md5sum := md5.New()
 	w := &progressWriter{blobUpload: b}
 
-	resp, err := makeRequest(ctx, method, requestURL, headers, io.TeeReader(sr, io.MultiWriter(w, md5sum)), opts)
+	resp, err := makeRequest(ctx, method, requestURL, headers, io.TeeReader(uploadLimit.reader(ctx, sr), io.MultiWriter(w, md5sum)), opts)
 	if err != nil {
 		w.Rollback()
 		return err
@@ -265,8 +266,9 @@ func (b *blobUpload) uploadPart(ctx context.Context, method string, requestURL *
 			case errors.Is(err, errMaxRetriesExceeded):
 				return err
 			case err != nil:
+				b.Retries.Add(1)
 				sleep := time.Second * time.Duration(math.Pow(2, float64(try)))
-				slog.Info(fmt.Sprintf("%s part %d attempt %d failed: %v, retrying in %s", b.Digest[7:19], part.N, try, err, sleep))
+				registryLog.Info(fmt.Sprintf("%s part %d attempt %d failed: %v, retrying in %s", b.Digest[7:19], part.N, try, err, sleep))
 				time.Sleep(sleep)
 				continue
 			}
@@ -318,6 +320,7 @@ func (b *blobUpload) Wait(ctx context.Context, fn func(api.ProgressResponse)) er
 	b.acquire()
 	defer b.release()
 
+	var speed transferSpeed
 	ticker := time.NewTicker(60 * time.Millisecond)
 	for {
 		select {
@@ -326,11 +329,16 @@ func (b *blobUpload) Wait(ctx context.Context, fn func(api.ProgressResponse)) er
 			return ctx.Err()
 		}
 
+		now := time.Now()
+		completed := b.Completed.Load()
 		fn(api.ProgressResponse{
 			Status:    fmt.Sprintf("pushing %s", b.Digest[7:19]),
 			Digest:    b.Digest,
 			Total:     b.Total,
-			Completed: b.Completed.Load(),
+			Completed: completed,
+			Rate:      uploadLimit.rate(now),
+			Speed:     speed.update(completed, now),
+			Retries:   int(b.Retries.Load()),
 		})
 
 		if b.done || b.err != nil {
@@ -0,0 +1,266 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/format"
+	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/llm"
+)
+
+// verifyTensors checks the tensors of the model blob at path against the
+// checksums recorded for it so that a corrupted blob is reported by tensor
+// instead of producing garbage at inference time. The first time a blob is
+// verified its digest is checked as a whole and the tensor checksums are
+// recorded.
+func verifyTensors(ctx context.Context, path string) error {
+	digest := strings.Replace(filepath.Base(path), "-", ":", 1)
+	sumsPath, err := GetChecksumsPath(digest)
+	if err != nil {
+		// not a blob so there is nothing to verify against
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	meta, _, err := ggml.Decode(f, 0)
+	if err != nil {
+		return fmt.Errorf("%s is corrupted: %w", digest, err)
+	}
+
+	bts, err := os.ReadFile(sumsPath)
+	if errors.Is(err, os.ErrNotExist) {
+		slog.Info("recording tensor checksums", "digest", digest)
+		if err := verifyBlob(digest); err != nil {
+			return fmt.Errorf("%s is corrupted: %w", digest, err)
+		}
+
+		sums, err := meta.Tensors().Checksums(ctx, f)
+		if err != nil {
+			return err
+		}
+
+		bts, err := json.Marshal(sums)
+		if err != nil {
+			return err
+		}
+
+		return os.WriteFile(sumsPath, bts, 0o644)
+	} else if err != nil {
+		return err
+	}
+
+	var sums ggml.Checksums
+	if err := json.Unmarshal(bts, &sums); err != nil {
+		return fmt.Errorf("%s: %w", sumsPath, err)
+	}
+
+	if err := meta.Tensors().Verify(ctx, f, sums); err != nil {
+		return fmt.Errorf("%s is corrupted: %w", digest, err)
+	}
+
+	return nil
+}
+
+// goldenPrompt is completed greedily when verifying a model with a golden
+// prompt so that its output can be compared between runs.
+const goldenPrompt = "The capital of France is"
+
+// golden is the recorded output of the golden prompt for a model.
+type golden struct {
+	Prompt string `json:"prompt"`
+
+	// Output is the hex encoded SHA-256 of the completion
+	Output string `json:"output"`
+}
+
+// verifyManifest re-hashes every blob of manifest and checks the structure and
+// recorded tensor checksums of the GGUF ones. The error names the first blob
+// that is corrupt.
+func verifyManifest(ctx context.Context, manifest *Manifest, fn func(api.ProgressResponse)) error {
+	for _, layer := range append([]Layer{manifest.Config}, manifest.Layers...) {
+		if layer.Digest == "" {
+			continue
+		}
+
+		if err := hashBlob(ctx, layer, fn); err != nil {
+			return err
+		}
+
+		switch layer.MediaType {
+		case "application/vnd.ollama.image.model",
+			"application/vnd.ollama.image.projector",
+			"application/vnd.ollama.image.adapter":
+			fn(api.ProgressResponse{Status: "validating " + layer.Digest[7:19]})
+			if err := validateBlob(ctx, layer.Digest); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// hashBlob checks that the blob of layer matches its digest and size.
+func hashBlob(ctx context.Context, layer Layer, fn func(api.ProgressResponse)) error {
+	path, err := GetBlobsPath(layer.Digest)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%s (%s) is missing", layer.Digest, layer.MediaType)
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	status := "verifying " + layer.Digest[7:19]
+	h := sha256.New()
+	bts := make([]byte, 8*format.MebiByte)
+	var completed int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		fn(api.ProgressResponse{Status: status, Digest: layer.Digest, Total: layer.Size, Completed: completed})
+
+		n, err := io.ReadFull(f, bts)
+		h.Write(bts[:n])
+		completed += int64(n)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	fn(api.ProgressResponse{Status: status, Digest: layer.Digest, Total: layer.Size, Completed: completed})
+
+	if digest := fmt.Sprintf("sha256:%x", h.Sum(nil)); digest != layer.Digest {
+		return fmt.Errorf("%s (%s) is corrupted: %w: got %s", layer.Digest, layer.MediaType, errDigestMismatch, digest)
+	} else if completed != layer.Size {
+		return fmt.Errorf("%s (%s) is corrupted: expected %d bytes, got %d", layer.Digest, layer.MediaType, layer.Size, completed)
+	}
+
+	return nil
+}
+
+// validateBlob checks the GGUF structure of the blob with digest and, if they
+// have been recorded, its tensor checksums.
+func validateBlob(ctx context.Context, digest string) error {
+	path, err := GetBlobsPath(digest)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, _, err := ggml.Decode(f, 0); err != nil {
+		return fmt.Errorf("%s is corrupted: %w", digest, err)
+	}
+
+	sumsPath, err := GetChecksumsPath(digest)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(sumsPath); err == nil {
+		return verifyTensors(ctx, path)
+	}
+
+	return nil
+}
+
+func goldenPath(digest string) (string, error) {
+	path, err := GetChecksumsPath("sha256:" + digest)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(path, ".json") + ".golden.json", nil
+}
+
+// verifyGolden completes the golden prompt with m and compares a hash of the
+// output to the one recorded the first time, recording it if there is none.
+func (s *Server) verifyGolden(ctx context.Context, name string, m *Model, fn func(api.ProgressResponse)) error {
+	if err := m.CheckCapabilities(CapabilityCompletion); err != nil {
+		fn(api.ProgressResponse{Status: "skipping golden prompt, model does not support completion"})
+		return nil
+	}
+
+	fn(api.ProgressResponse{Status: "running golden prompt"})
+
+	// options are typed as they would be when decoded from JSON
+	r, rm, opts, _, err := s.scheduleRunner(ctx, name, []Capability{CapabilityCompletion}, map[string]any{
+		"temperature": 0.0,
+		"top_k":       1.0,
+		"seed":        0.0,
+		"num_predict": 16.0,
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	if rm.Digest != m.Digest {
+		return fmt.Errorf("%s does not fit in available memory, cannot run golden prompt", name)
+	}
+
+	var sb strings.Builder
+	if err := r.Completion(ctx, llm.CompletionRequest{Prompt: goldenPrompt, Options: opts}, func(cr llm.CompletionResponse) {
+		sb.WriteString(cr.Content)
+	}); err != nil {
+		return err
+	}
+
+	actual := golden{Prompt: goldenPrompt, Output: fmt.Sprintf("%x", sha256.Sum256([]byte(sb.String())))}
+
+	path, err := goldenPath(m.Digest)
+	if err != nil {
+		return err
+	}
+
+	bts, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		fn(api.ProgressResponse{Status: "recording golden prompt output"})
+		bts, err := json.Marshal(actual)
+		if err != nil {
+			return err
+		}
+
+		return os.WriteFile(path, bts, 0o644)
+	} else if err != nil {
+		return err
+	}
+
+	var expect golden
+	if err := json.Unmarshal(bts, &expect); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	if expect != actual {
+		return fmt.Errorf("golden prompt output changed: expected %s, got %s", expect.Output, actual.Output)
+	}
+
+	return nil
+}
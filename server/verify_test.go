@@ -0,0 +1,187 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestVerifyTensors(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	_, digest := createBinFile(t, ggml.KV{"general.architecture": "test"}, []ggml.Tensor{
+		{Name: "token_embd.weight", Kind: 0, Shape: []uint64{4}, WriterTo: bytes.NewReader(bytes.Repeat([]byte{1}, 16))},
+		{Name: "output.weight", Kind: 0, Shape: []uint64{4}, WriterTo: bytes.NewReader(bytes.Repeat([]byte{2}, 16))},
+	})
+
+	path, err := GetBlobsPath(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sumsPath, err := GetChecksumsPath(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first verification records checksums
+	if err := verifyTensors(context.Background(), path); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(sumsPath); err != nil {
+		t.Fatalf("expected checksums to be recorded: %v", err)
+	}
+
+	if err := verifyTensors(context.Background(), path); err != nil {
+		t.Fatal(err)
+	}
+
+	// corrupt the last byte of the blob, which belongs to the last tensor
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.WriteAt([]byte{0xff}, fi.Size()-1); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	err = verifyTensors(context.Background(), path)
+	if !errors.Is(err, ggml.ErrChecksumMismatch) {
+		t.Fatalf("expected checksum mismatch, got %v", err)
+	}
+
+	if !strings.Contains(err.Error(), `tensor "output.weight"`) {
+		t.Errorf("expected error to name the corrupted tensor, got %v", err)
+	}
+}
+
+func TestVerifyHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	output := "Paris"
+	mock := mockRunner{
+		CompletionFn: func(_ context.Context, _ llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+			fn(llm.CompletionResponse{Content: output, Done: true, DoneReason: "stop"})
+			return nil
+		},
+	}
+
+	s := Server{
+		sched: &Scheduler{
+			pendingReqCh:  make(chan *LlmRequest, 1),
+			finishedReqCh: make(chan *LlmRequest, 1),
+			expiredCh:     make(chan *runnerRef, 1),
+			unloadedCh:    make(chan any, 1),
+			loaded:        make(map[string]*runnerRef),
+			newServerFn:   newMockServer(&mock),
+			getGpuFn:      discover.GetGPUInfo,
+			getCpuFn:      discover.GetCPUInfo,
+			reschedDelay:  250 * time.Millisecond,
+			loadFn: func(req *LlmRequest, _ *ggml.GGML, _ discover.GpuInfoList, _ int) {
+				req.successCh <- &runnerRef{
+					llama: &mock,
+				}
+			},
+		},
+	}
+
+	go s.sched.Run(t.Context())
+
+	_, digest := createBinFile(t, ggml.KV{
+		"general.architecture":          "llama",
+		"llama.block_count":             uint32(1),
+		"llama.context_length":          uint32(8192),
+		"llama.embedding_length":        uint32(4096),
+		"llama.attention.head_count":    uint32(32),
+		"llama.attention.head_count_kv": uint32(8),
+		"tokenizer.ggml.tokens":         []string{""},
+		"tokenizer.ggml.scores":         []float32{0},
+		"tokenizer.ggml.token_type":     []int32{0},
+	}, []ggml.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "output.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+	})
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  "test",
+		Files:  map[string]string{"file.gguf": digest},
+		Stream: &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	verify := func(golden bool) *httptest.ResponseRecorder {
+		return createRequest(t, s.VerifyHandler, api.VerifyRequest{Model: "test", Golden: golden, Stream: &stream})
+	}
+
+	t.Run("missing", func(t *testing.T) {
+		w := createRequest(t, s.VerifyHandler, api.VerifyRequest{Model: "missing", Stream: &stream})
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", w.Code)
+		}
+	})
+
+	t.Run("golden", func(t *testing.T) {
+		// the first run records the output
+		if w := verify(true); w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		if w := verify(true); w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		output = "Lyon"
+		if w := verify(true); w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "golden prompt output changed") {
+			t.Errorf("expected golden prompt mismatch, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("corrupt", func(t *testing.T) {
+		if w := verify(false); w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		path, err := GetBlobsPath(digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := f.WriteAt([]byte{0xff}, 100); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+
+		w := verify(false)
+		if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), digest+" (application/vnd.ollama.image.model) is corrupted") {
+			t.Errorf("expected the model blob to be reported as corrupt, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/format"
+)
+
+// warmModel is a model whose files have been read ahead of use.
+type warmModel struct {
+	warmedAt time.Time
+
+	// pinned holds the files locked in memory, if any, until expireTimer
+	// fires
+	pinned      []*pinnedFile
+	expireTimer *time.Timer
+}
+
+func (w *warmModel) unpin() {
+	if w.expireTimer != nil {
+		w.expireTimer.Stop()
+		w.expireTimer = nil
+	}
+
+	for _, p := range w.pinned {
+		if err := p.unpin(); err != nil {
+			slog.Warn("failed to unpin model file", "error", err)
+		}
+	}
+
+	w.pinned = nil
+}
+
+// warmModels tracks warmed models by model path. The zero value is ready to
+// use.
+type warmModels struct {
+	mu     sync.Mutex
+	models map[string]*warmModel
+}
+
+// isWarm reports whether the model at path has been warmed.
+func (w *warmModels) isWarm(path string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, ok := w.models[path]
+	return ok
+}
+
+func (w *warmModels) set(path string, pinned []*pinnedFile, duration time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.models == nil {
+		w.models = make(map[string]*warmModel)
+	}
+
+	if prev, ok := w.models[path]; ok {
+		prev.unpin()
+	}
+
+	m := &warmModel{warmedAt: time.Now(), pinned: pinned}
+	w.models[path] = m
+
+	if len(pinned) > 0 && duration >= 0 {
+		m.expireTimer = time.AfterFunc(duration, func() {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+
+			// the model may have been warmed again since
+			if w.models[path] != m {
+				return
+			}
+
+			// once unpinned, the files may be evicted at any time so the
+			// model is no longer considered warm
+			slog.Debug("unpinning warm model", "model", path)
+			m.unpin()
+			delete(w.models, path)
+		})
+	}
+}
+
+// forget drops the model at path, releasing any pinned memory. It is called
+// when the files of the model are unpinned or deleted.
+func (w *warmModels) forget(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if m, ok := w.models[path]; ok {
+		m.unpin()
+		delete(w.models, path)
+	}
+}
+
+// warm reads every file of m through the page cache so the first load does
+// not wait on disk. If lock is set the files are also locked in memory for
+// duration, or until the server exits if duration is negative.
+func (w *warmModels) warm(ctx context.Context, m *Model, lock bool, duration time.Duration, fn func(api.ProgressResponse)) error {
+	paths := append([]string{m.ModelPath}, m.AdapterPaths...)
+	paths = append(paths, m.ProjectorPaths...)
+
+	var pinned []*pinnedFile
+	release := func() {
+		for _, p := range pinned {
+			p.unpin() //nolint:errcheck
+		}
+	}
+
+	for _, path := range paths {
+		if err := warmFile(ctx, path, fn); err != nil {
+			release()
+			return err
+		}
+
+		if lock && duration != 0 {
+			p, err := pinFile(path)
+			if err != nil {
+				release()
+				return err
+			}
+
+			pinned = append(pinned, p)
+		}
+	}
+
+	w.set(m.ModelPath, pinned, duration)
+	return nil
+}
+
+func warmFile(ctx context.Context, path string, fn func(api.ProgressResponse)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	digest := strings.Replace(filepath.Base(path), "-", ":", 1)
+	status := "warming " + digest
+	if len(digest) > 19 {
+		status = "warming " + digest[7:19]
+	}
+
+	bts := make([]byte, 8*format.MebiByte)
+	var completed int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		fn(api.ProgressResponse{Status: status, Digest: digest, Total: fi.Size(), Completed: completed})
+
+		n, err := io.ReadFull(f, bts)
+		completed += int64(n)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	fn(api.ProgressResponse{Status: status, Digest: digest, Total: fi.Size(), Completed: completed})
+	return nil
+}
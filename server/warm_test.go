@@ -0,0 +1,211 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestWarmHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+
+	s := Server{sched: &Scheduler{}}
+
+	_, digest := createBinFile(t, nil, nil)
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:  "test",
+		Files: map[string]string{"test.gguf": digest},
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.sched.warm.isWarm(m.ModelPath) {
+		t.Fatal("expected model to not be warm")
+	}
+
+	w = createRequest(t, s.WarmHandler, api.WarmRequest{Model: "test"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	var resps []api.ProgressResponse
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		var resp api.ProgressResponse
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		resps = append(resps, resp)
+	}
+
+	if len(resps) < 2 {
+		t.Fatalf("expected progress responses, got %v", resps)
+	}
+
+	last := resps[len(resps)-2]
+	if last.Digest != digest || last.Total == 0 || last.Completed != last.Total {
+		t.Errorf("unexpected final progress %+v", last)
+	}
+
+	if status := resps[len(resps)-1].Status; status != "success" {
+		t.Errorf("expected success, got %q", status)
+	}
+
+	if !s.sched.warm.isWarm(m.ModelPath) {
+		t.Error("expected model to be warm")
+	}
+
+	w = createRequest(t, s.WarmHandler, api.WarmRequest{Model: "missing"})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status code 404, actual %d", w.Code)
+	}
+}
+
+func TestWarmLock(t *testing.T) {
+	if testing.Short() {
+		t.Skip("locks memory")
+	}
+
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+
+	s := Server{sched: &Scheduler{}}
+
+	_, digest := createBinFile(t, nil, nil)
+	createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:  "test",
+		Files: map[string]string{"test.gguf": digest},
+	})
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream := false
+	w := createRequest(t, s.WarmHandler, api.WarmRequest{
+		Model:     "test",
+		Lock:      true,
+		KeepAlive: &api.Duration{Duration: 10 * time.Millisecond},
+		Stream:    &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Skipf("unable to lock memory: %s", w.Body.String())
+	}
+
+	s.sched.warm.mu.Lock()
+	pinned := len(s.sched.warm.models[m.ModelPath].pinned)
+	s.sched.warm.mu.Unlock()
+	if pinned != 1 {
+		t.Fatalf("expected 1 pinned file, got %d", pinned)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if s.sched.warm.isWarm(m.ModelPath) {
+		t.Error("expected files to be unpinned after keep alive")
+	}
+}
+
+func TestWarmForget(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+
+	s := Server{sched: &Scheduler{}}
+
+	_, digest := createBinFile(t, nil, nil)
+	for _, name := range []string{"test", "test2"} {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Name:  name,
+			Files: map[string]string{"test.gguf": digest},
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", w.Code)
+		}
+	}
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream := false
+	warm := func(req api.WarmRequest) {
+		t.Helper()
+		req.Stream = &stream
+		if w := createRequest(t, s.WarmHandler, req); w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	t.Run("unpin", func(t *testing.T) {
+		warm(api.WarmRequest{Model: "test"})
+		if !s.sched.warm.isWarm(m.ModelPath) {
+			t.Fatal("expected model to be warm")
+		}
+
+		warm(api.WarmRequest{Model: "test", Lock: true, KeepAlive: &api.Duration{}})
+		if s.sched.warm.isWarm(m.ModelPath) {
+			t.Error("expected model to be forgotten when unpinned")
+		}
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		s.sched.warm.set(m.ModelPath, []*pinnedFile{{}}, 10*time.Millisecond)
+		if !s.sched.warm.isWarm(m.ModelPath) {
+			t.Fatal("expected model to be warm")
+		}
+
+		time.Sleep(100 * time.Millisecond)
+		if s.sched.warm.isWarm(m.ModelPath) {
+			t.Error("expected model to be forgotten once its files expire")
+		}
+
+		// warming again replaces the files that were pinned before
+		s.sched.warm.set(m.ModelPath, []*pinnedFile{{}}, 10*time.Millisecond)
+		s.sched.warm.set(m.ModelPath, nil, 0)
+		time.Sleep(100 * time.Millisecond)
+		if !s.sched.warm.isWarm(m.ModelPath) {
+			t.Error("expected model warmed again to stay warm")
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		warm(api.WarmRequest{Model: "test"})
+
+		// the files are still used by test2
+		if w := createRequest(t, s.DeleteHandler, api.DeleteRequest{Model: "test"}); w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", w.Code)
+		}
+		if !s.sched.warm.isWarm(m.ModelPath) {
+			t.Error("expected model with shared files to stay warm")
+		}
+
+		if w := createRequest(t, s.DeleteHandler, api.DeleteRequest{Model: "test2"}); w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", w.Code)
+		}
+		if s.sched.warm.isWarm(m.ModelPath) {
+			t.Error("expected deleted model to be forgotten")
+		}
+	})
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/ollama/ollama/api"
+)
+
+// knownOptions are the names of the fields of [api.Options].
+var knownOptions = sync.OnceValue(func() map[string]bool {
+	known := make(map[string]bool)
+	for _, field := range reflect.VisibleFields(reflect.TypeFor[api.Options]()) {
+		if name, _, _ := strings.Cut(field.Tag.Get("json"), ","); name != "" {
+			known[name] = true
+		}
+	}
+
+	return known
+})
+
+// ignoredOptions are the options that engines accept but don't use, by the
+// engine, with the options that no engine uses under "".
+var ignoredOptions = map[string][]string{
+	"":       {"f16_kv", "logits_all", "low_vram", "vocab_only"},
+	"ollama": {"typical_p", "repeat_last_n", "repeat_penalty", "presence_penalty", "frequency_penalty", "mirostat_tau", "mirostat_eta"},
+}
+
+// optionWarnings warns about the options of a request that engine won't use.
+// Options set by the model are left alone, since they are the same for every
+// request.
+func optionWarnings(engine string, requestOpts map[string]any) []api.Warning {
+	var warnings []api.Warning
+	for _, name := range slices.Sorted(maps.Keys(requestOpts)) {
+		switch {
+		case !knownOptions()[name]:
+			warnings = append(warnings, api.Warning{
+				Code:    api.WarningUnknownOption,
+				Message: fmt.Sprintf("unknown option %q was ignored", name),
+			})
+		case slices.Contains(ignoredOptions[""], name):
+			warnings = append(warnings, api.Warning{
+				Code:    api.WarningOptionIgnored,
+				Message: fmt.Sprintf("option %q has no effect", name),
+			})
+		case slices.Contains(ignoredOptions[engine], name):
+			warnings = append(warnings, api.Warning{
+				Code:    api.WarningOptionIgnored,
+				Message: fmt.Sprintf("option %q is not supported by the %s engine and was ignored", name, engine),
+			})
+		case name == "mirostat" && engine == "ollama" && requestOpts[name] != nil && requestOpts[name] != float64(0):
+			warnings = append(warnings, api.Warning{
+				Code:    api.WarningSamplerFallback,
+				Message: "mirostat sampling is not supported by the ollama engine, using top_k, top_p and min_p sampling instead",
+			})
+		}
+	}
+
+	return warnings
+}
+
+// promptTruncatedWarning warns that n tokens of a prompt didn't fit in the
+// context window of numCtx tokens.
+func promptTruncatedWarning(n, numCtx int) api.Warning {
+	return api.Warning{
+		Code:    api.WarningPromptTruncated,
+		Message: fmt.Sprintf("%d tokens of the prompt didn't fit in the context window of %d tokens and were left out", n, numCtx),
+	}
+}
+
+// warningMessages returns the messages of warnings for the deprecated
+// warnings of [api.StreamSummary].
+func warningMessages(warnings []api.Warning) []string {
+	var messages []string
+	for _, w := range warnings {
+		messages = append(messages, w.Message)
+	}
+
+	return messages
+}
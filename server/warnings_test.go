@@ -0,0 +1,44 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestOptionWarnings(t *testing.T) {
+	cases := []struct {
+		name   string
+		engine string
+		opts   map[string]any
+		want   []string
+	}{
+		{"none", "ollama", nil, nil},
+		{"supported", "ollama", map[string]any{"temperature": 0.5, "num_ctx": 4096}, nil},
+		{"unknown", "llama", map[string]any{"temprature": 0.5}, []string{api.WarningUnknownOption}},
+		{"no effect", "llama", map[string]any{"low_vram": true}, []string{api.WarningOptionIgnored}},
+		{"engine", "ollama", map[string]any{"repeat_penalty": 1.1}, []string{api.WarningOptionIgnored}},
+		{"other engine", "llama", map[string]any{"repeat_penalty": 1.1}, nil},
+		{"mirostat", "ollama", map[string]any{"mirostat": float64(2), "mirostat_tau": 5.0}, []string{api.WarningSamplerFallback, api.WarningOptionIgnored}},
+		{"mirostat disabled", "ollama", map[string]any{"mirostat": float64(0)}, nil},
+		{"mirostat llama", "llama", map[string]any{"mirostat": float64(2)}, nil},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, w := range optionWarnings(tt.engine, tt.opts) {
+				if w.Message == "" {
+					t.Errorf("expected a message for %s", w.Code)
+				}
+				got = append(got, w.Code)
+			}
+
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Errorf("mismatch (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
@@ -33,20 +33,7 @@ var templatesOnce = sync.OnceValues(func() ([]*named, error) {
 	}
 
 	for _, t := range templates {
-		bts, err := templatesFS.ReadFile(t.Name + ".gotmpl")
-		if err != nil {
-			return nil, err
-		}
-
-		// normalize line endings
-		t.Bytes = bytes.ReplaceAll(bts, []byte("\r\n"), []byte("\n"))
-
-		params, err := templatesFS.ReadFile(t.Name + ".json")
-		if err != nil {
-			continue
-		}
-
-		if err := json.Unmarshal(params, &t.Parameters); err != nil {
+		if err := t.read(); err != nil {
 			return nil, err
 		}
 	}
@@ -64,6 +51,24 @@ type named struct {
 	}
 }
 
+// read reads the template of t and its parameters, if it has any
+func (t *named) read() error {
+	bts, err := templatesFS.ReadFile(t.Name + ".gotmpl")
+	if err != nil {
+		return err
+	}
+
+	// normalize line endings
+	t.Bytes = bytes.ReplaceAll(bts, []byte("\r\n"), []byte("\n"))
+
+	params, err := templatesFS.ReadFile(t.Name + ".json")
+	if err != nil {
+		return nil
+	}
+
+	return json.Unmarshal(params, &t.Parameters)
+}
+
 func (t named) Reader() io.Reader {
 	return bytes.NewReader(t.Bytes)
 }
@@ -90,6 +95,24 @@ func Named(s string) (*named, error) {
 	return nil, errors.New("no matching template found")
 }
 
+// Infill returns the template of base code models, which have no chat
+// template, if their vocabulary tokens include the fill-in-the-middle tokens of
+// StarCoder. StarCoder2 and Granite Code models share them.
+func Infill(tokens []string) (*named, error) {
+	for _, token := range []string{"<fim_prefix>", "<fim_suffix>", "<fim_middle>"} {
+		if !slices.Contains(tokens, token) {
+			return nil, errors.New("no fill-in-the-middle tokens found")
+		}
+	}
+
+	t := named{Name: "starcoder-fim"}
+	if err := t.read(); err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
 var DefaultTemplate, _ = Parse("{{ .Prompt }}")
 
 type Template struct {
@@ -376,3 +376,42 @@ func TestExecuteWithSuffix(t *testing.T) {
 		})
 	}
 }
+
+func TestInfill(t *testing.T) {
+	if _, err := Infill([]string{"<|endoftext|>", "<fim_prefix>", "<fim_suffix>"}); err == nil {
+		t.Fatal("expected an error without all of the fill-in-the-middle tokens")
+	}
+
+	r, err := Infill([]string{"<|endoftext|>", "<fim_prefix>", "<fim_middle>", "<fim_suffix>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Name != "starcoder-fim" || r.Parameters == nil {
+		t.Fatalf("expected starcoder-fim with parameters, got %q", r.Name)
+	}
+
+	// the chat template of StarCoder2 Instruct also fills in the middle
+	for _, name := range []string{"starcoder-fim.gotmpl", "starcoder2-instruct.gotmpl"} {
+		t.Run(name, func(t *testing.T) {
+			bts, err := os.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tmpl, err := Parse(string(bts))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var b bytes.Buffer
+			if err := tmpl.Execute(&b, Values{Prompt: "def add(", Suffix: "return x"}); err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(b.String(), "<fim_prefix>def add(<fim_suffix>return x<fim_middle>"); diff != "" {
+				t.Errorf("mismatch (-got +want):\n%s", diff)
+			}
+		})
+	}
+}